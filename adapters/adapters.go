@@ -0,0 +1,128 @@
+// Package adapters runs the hooks written for other database/sql wrappers,
+// as instrumentedsql, as sqlhooks hooks. Their Before function gets the
+// context.Context an operation was issued with and returns a derived one,
+// typically carrying a span, which is the one their After function gets:
+//
+//	db, err := sqlhooks.Open("postgres", dsn, adapters.New(tracingHooks))
+package adapters
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// BeforeAfterHooks are the hooks of the wrappers deriving a context.Context
+// per operation, Operation tells which one it is. The Before hooks of the
+// transactions, as the commits, get an empty query and no args.
+type BeforeAfterHooks interface {
+	// Before is called before the operation, a failure fails it without
+	// calling After
+	Before(ctx context.Context, query string, args []interface{}) (context.Context, error)
+
+	// After is called once the operation returned, with the context Before
+	// returned and the error of the operation
+	After(ctx context.Context, query string, args []interface{}, err error)
+}
+
+// Funcs are BeforeAfterHooks made of functions, a nil one does nothing
+type Funcs struct {
+	BeforeFunc func(ctx context.Context, query string, args []interface{}) (context.Context, error)
+	AfterFunc  func(ctx context.Context, query string, args []interface{}, err error)
+}
+
+func (f Funcs) Before(ctx context.Context, query string, args []interface{}) (context.Context, error) {
+	if f.BeforeFunc == nil {
+		return ctx, nil
+	}
+	return f.BeforeFunc(ctx, query, args)
+}
+
+func (f Funcs) After(ctx context.Context, query string, args []interface{}, err error) {
+	if f.AfterFunc != nil {
+		f.AfterFunc(ctx, query, args, err)
+	}
+}
+
+type opKey struct{}
+
+// Operation returns the operation of the context.Context given to the
+// BeforeAfterHooks, or derived from it: OpQuery and OpExec for the prepared
+// statements as well, 0 for any other context
+func Operation(ctx context.Context) sqlhooks.Op {
+	op, _ := ctx.Value(opKey{}).(sqlhooks.Op)
+	return op
+}
+
+type hook struct {
+	hooks BeforeAfterHooks
+
+	// key is the sqlhooks.Context value holding the derived context of an
+	// operation, one per hook so several can be composed
+	key string
+}
+
+// New returns the hooks running hooks for every operation. The context
+// returned by Before replaces sqlhooks.Context.Ctx, so the hooks running
+// afterwards see it, as the wrappers nested in the BeforeAfterHooks one
+// would. The Query hooks return once the driver returned the rows, the time
+// spent reading them isn't part of the operation.
+func New(hooks BeforeAfterHooks) *hook {
+	h := &hook{hooks: hooks}
+	h.key = fmt.Sprintf("adapters.%p", h)
+	return h
+}
+
+func (h *hook) before(op sqlhooks.Op, ctx *sqlhooks.Context) error {
+	stdCtx := ctx.Ctx
+	if stdCtx == nil {
+		stdCtx = context.Background()
+	}
+	stdCtx = context.WithValue(stdCtx, opKey{}, op)
+	derived, err := h.hooks.Before(stdCtx, ctx.Query, ctx.Args)
+	if err != nil {
+		return err
+	}
+	if derived == nil {
+		derived = stdCtx
+	}
+	ctx.Set(h.key, derived)
+	ctx.Ctx = derived
+	return nil
+}
+
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	derived, ok := ctx.Get(h.key).(context.Context)
+	if !ok {
+		return ctx.Error
+	}
+	// The Context of a prepared statement is reused by its executions
+	ctx.Set(h.key, nil)
+	h.hooks.After(derived, ctx.Query, ctx.Args, ctx.Error)
+	return ctx.Error
+}
+
+func (h *hook) BeforeBegin(ctx *sqlhooks.Context) error { return h.before(sqlhooks.OpBegin, ctx) }
+func (h *hook) AfterBegin(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforeCommit(ctx *sqlhooks.Context) error { return h.before(sqlhooks.OpCommit, ctx) }
+func (h *hook) AfterCommit(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforeRollback(ctx *sqlhooks.Context) error { return h.before(sqlhooks.OpRollback, ctx) }
+func (h *hook) AfterRollback(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error { return h.before(sqlhooks.OpPrepare, ctx) }
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error { return h.before(sqlhooks.OpQuery, ctx) }
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error { return h.before(sqlhooks.OpExec, ctx) }
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error { return h.before(sqlhooks.OpQuery, ctx) }
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error { return h.before(sqlhooks.OpExec, ctx) }
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error  { return h.after(ctx) }
@@ -0,0 +1,146 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type spanKey struct{}
+
+// span is a span of tracer, parent is the span of the context it started from
+type span struct {
+	id, parent int
+	op         sqlhooks.Op
+	query      string
+	ended      int
+	err        error
+}
+
+// tracer starts a span in Before, a child of the context's one, and ends
+// the span of the context in After, as the tracing wrappers do
+type tracer struct {
+	spans []*span
+	fail  string
+}
+
+func (t *tracer) Before(ctx context.Context, query string, args []interface{}) (context.Context, error) {
+	if t.fail != "" && query == t.fail {
+		return ctx, errors.New("tracer: refused")
+	}
+	s := &span{id: len(t.spans) + 1, op: Operation(ctx), query: query}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.parent = parent.id
+	}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), nil
+}
+
+func (t *tracer) After(ctx context.Context, query string, args []interface{}, err error) {
+	s := ctx.Value(spanKey{}).(*span)
+	s.ended++
+	s.err = err
+}
+
+// summary describes the spans as op:query(parent), ! marking the failed ones
+func (t *tracer) summary(tb testing.TB) []string {
+	var spans []string
+	for _, s := range t.spans {
+		assert.Equal(tb, 1, s.ended, "span %d %s %s ended once", s.id, s.op, s.query)
+		var failed string
+		if s.err != nil {
+			failed = "!"
+		}
+		spans = append(spans, fmt.Sprintf("%s:%s(%d)%s", s.op, s.query, s.parent, failed))
+	}
+	return spans
+}
+
+func TestSpanPairing(t *testing.T) {
+	for _, mode := range []string{drivertest.ModeContext, drivertest.ModeLegacy} {
+		t.Run(mode, func(t *testing.T) {
+			tr := &tracer{}
+			db, err := sqlhooks.Open(drivertest.Name, mode+";"+t.Name(), New(tr))
+			require.NoError(t, err)
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			root := &span{id: 100}
+			ctx := context.WithValue(context.Background(), spanKey{}, root)
+
+			_, err = db.ExecContext(ctx, "INSERT INTO t VALUES (?)", 1)
+			require.NoError(t, err)
+			_, err = db.ExecContext(ctx, "FAIL deadlock|UPDATE t SET n = 1")
+			require.Error(t, err)
+
+			rows, err := db.QueryContext(ctx, "ROWS 2|SELECT n FROM t")
+			require.NoError(t, err)
+			for rows.Next() {
+			}
+			require.NoError(t, rows.Close())
+
+			stmt, err := db.PrepareContext(ctx, "ROWS 1|SELECT n FROM t WHERE n = ?")
+			require.NoError(t, err)
+			for i := 0; i < 2; i++ {
+				var n int
+				require.NoError(t, stmt.QueryRowContext(ctx, i).Scan(&n))
+			}
+			require.NoError(t, stmt.Close())
+
+			tx, err := db.BeginTx(ctx, nil)
+			require.NoError(t, err)
+			_, err = tx.ExecContext(ctx, "DELETE FROM t")
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+
+			tx, err = db.BeginTx(ctx, nil)
+			require.NoError(t, err)
+			require.NoError(t, tx.Rollback())
+
+			assert.Equal(t, []string{
+				"Exec:INSERT INTO t VALUES (?)(100)",
+				"Exec:FAIL deadlock|UPDATE t SET n = 1(100)!",
+				"Query:ROWS 2|SELECT n FROM t(100)",
+				"Prepare:ROWS 1|SELECT n FROM t WHERE n = ?(100)",
+				"Query:ROWS 1|SELECT n FROM t WHERE n = ?(100)",
+				"Query:ROWS 1|SELECT n FROM t WHERE n = ?(100)",
+				"Begin:(100)",
+				"Exec:DELETE FROM t(100)",
+				"Commit:(100)",
+				"Begin:(100)",
+				"Rollback:(100)",
+			}, tr.summary(t))
+		})
+	}
+}
+
+func TestDerivedContext(t *testing.T) {
+	// The hooks running after the adapter see its derived context, its
+	// Before failing fails the operation without calling its After
+	tr := &tracer{fail: "DELETE FROM t"}
+	var seen []int
+	after := &sqlhooks.FuncHooks{Exec: sqlhooks.Funcs{Before: func(ctx *sqlhooks.Context) error {
+		seen = append(seen, ctx.Ctx.Value(spanKey{}).(*span).id)
+		return nil
+	}}}
+	db, err := sqlhooks.Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), sqlhooks.Compose(New(tr), New(tr), after))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO t VALUES (1)")
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM t")
+	assert.EqualError(t, err, "tracer: refused")
+
+	assert.Equal(t, []int{2}, seen)
+	assert.Equal(t, []string{
+		"Exec:INSERT INTO t VALUES (1)(0)",
+		"Exec:INSERT INTO t VALUES (1)(1)",
+	}, tr.summary(t), "the composed adapters end their own span")
+}
@@ -0,0 +1,224 @@
+package sqlhooks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrArgCountMismatch is the error of a statement given a number of
+// arguments other than its placeholders, see WithArgCountCheck
+type ErrArgCountMismatch struct {
+	Expected int
+	Got      int
+	Query    string
+}
+
+func (e ErrArgCountMismatch) Error() string {
+	return fmt.Sprintf("sqlhooks: expected %d arguments, got %d: %s", e.Expected, e.Got, e.Query)
+}
+
+// Placeholders returns how many arguments query expects: the number of ?
+// placeholders, or the highest $1 or @p1 one, as used by postgres and SQL
+// Server. Placeholders in strings, quoted identifiers and comments are
+// ignored, as are casts like ::text and :name ones. It returns -1 when
+// query mixes placeholder styles. Counts are cached, see SetQueryCacheSize.
+func Placeholders(query string) int {
+	info := analyses.get(query)
+	if info == nil {
+		return countPlaceholders(query)
+	}
+	info.placeholdersOnce.Do(func() { info.placeholders = countPlaceholders(query) })
+	return info.placeholders
+}
+
+func countPlaceholders(query string) int {
+	n, _ := scanPlaceholders(query, false)
+	return n
+}
+
+// PlaceholderStyle is the style of the placeholders of a query, see QueryPlaceholderStyle
+type PlaceholderStyle uint8
+
+const (
+	// PlaceholderNone is the style of the queries without placeholders
+	PlaceholderNone PlaceholderStyle = iota
+
+	// PlaceholderQuestion is ?, as taken by MySQL and SQLite
+	PlaceholderQuestion
+
+	// PlaceholderDollar is $1, as taken by postgres
+	PlaceholderDollar
+
+	// PlaceholderAtP is @p1, as taken by SQL Server
+	PlaceholderAtP
+
+	// PlaceholderMixed is the style of the queries mixing several of them
+	PlaceholderMixed
+)
+
+var placeholderStyleNames = [...]string{
+	PlaceholderNone:     "none",
+	PlaceholderQuestion: "?",
+	PlaceholderDollar:   "$1",
+	PlaceholderAtP:      "@p1",
+	PlaceholderMixed:    "mixed",
+}
+
+func (s PlaceholderStyle) String() string {
+	if int(s) < len(placeholderStyleNames) {
+		return placeholderStyleNames[s]
+	}
+	return "unknown"
+}
+
+// QueryPlaceholderStyle returns the style of the placeholders of query,
+// run against system, a SystemInfo name. Placeholders are found as
+// Placeholders finds them, except for postgresql, where a ?, ?| or ?&
+// following an operand, as in data ? 'key', is a jsonb operator.
+func QueryPlaceholderStyle(query, system string) PlaceholderStyle {
+	_, style := scanPlaceholders(query, system == "postgresql")
+	return style
+}
+
+// scanPlaceholders returns the number of arguments query expects, -1 when
+// it mixes placeholder styles, and the style of its placeholders.
+// jsonOperators tells ? following an operand is an operator.
+func scanPlaceholders(query string, jsonOperators bool) (int, PlaceholderStyle) {
+	positional, numbered := 0, 0
+	style := PlaceholderNone
+
+	for i := 0; i < len(query); {
+		if next := skipLiteral(query, i); next != i {
+			i = next
+			continue
+		}
+
+		c := query[i]
+		n := 0
+		found := PlaceholderNone
+		switch {
+		case c == '?' && jsonOperators && followsOperand(query, i):
+			i++
+			if i < len(query) && (query[i] == '|' || query[i] == '&') {
+				i++
+			}
+		case c == '?':
+			positional++
+			found = PlaceholderQuestion
+			i++
+		case c == '$' && (i == 0 || !isIdentByte(query[i-1])):
+			if i, n = numberedPlaceholder(query, i+1); n > 0 {
+				found = PlaceholderDollar
+			}
+		case c == '@' && (i == 0 || !isIdentByte(query[i-1]) && query[i-1] != '@') &&
+			i+1 < len(query) && (query[i+1] == 'p' || query[i+1] == 'P'):
+			if i, n = numberedPlaceholder(query, i+2); n > 0 {
+				found = PlaceholderAtP
+			}
+		default:
+			i++
+		}
+
+		if found != PlaceholderNone {
+			if style == PlaceholderNone {
+				style = found
+			} else if style != found {
+				style = PlaceholderMixed
+			}
+			if n > numbered {
+				numbered = n
+			}
+		}
+	}
+
+	switch style {
+	case PlaceholderMixed:
+		return -1, style
+	case PlaceholderQuestion:
+		return positional, style
+	}
+	return numbered, style
+}
+
+// operandKeywords are the keywords a placeholder follows, as in LIMIT ?,
+// where a word preceding a ? is an operand otherwise
+var operandKeywords = map[string]bool{
+	"all": true, "and": true, "any": true, "as": true, "between": true, "by": true,
+	"case": true, "distinct": true, "else": true, "escape": true, "exists": true,
+	"from": true, "having": true, "ilike": true, "in": true, "interval": true,
+	"into": true, "is": true, "like": true, "limit": true, "not": true, "offset": true,
+	"on": true, "or": true, "returning": true, "select": true, "set": true,
+	"some": true, "then": true, "to": true, "using": true, "values": true,
+	"when": true, "where": true,
+}
+
+// followsOperand reports whether the ? at i follows an operand, an
+// identifier, a literal or a closing parenthesis, making it an operator
+func followsOperand(query string, i int) bool {
+	j := i - 1
+	for j >= 0 && (query[j] == ' ' || query[j] == '\t' || query[j] == '\n' || query[j] == '\r') {
+		j--
+	}
+	if j < 0 {
+		return false
+	}
+	switch c := query[j]; {
+	case c == ')' || c == ']' || c == '\'' || c == '"':
+		return true
+	case isIdentByte(c):
+		start := j
+		for start > 0 && isIdentByte(query[start-1]) {
+			start--
+		}
+		return !operandKeywords[strings.ToLower(query[start:j+1])]
+	}
+	return false
+}
+
+// numberedPlaceholder parses the number of a placeholder at i, it returns
+// the index following it and the number, 0 if there's none
+func numberedPlaceholder(query string, i int) (int, int) {
+	end := i
+	for end < len(query) && isDigit(query[end]) {
+		end++
+	}
+	if end == i || end < len(query) && isIdentByte(query[end]) && query[end] != '.' {
+		return end, 0
+	}
+	n, err := strconv.Atoi(query[i:end])
+	if err != nil {
+		return end, 0
+	}
+	return end, n
+}
+
+// checkArgs returns the ErrArgCountMismatch of query given nargs arguments,
+// nil if WithArgCountCheck isn't set or when only reporting it
+func (c *conn) checkArgs(query string, nargs int) error {
+	if !c.argCountCheck {
+		return nil
+	}
+	return c.argCountError(query, Placeholders(query), nargs)
+}
+
+// checkArgs is conn.checkArgs for the executions of s, whose placeholders are counted once
+func (s *stmt) checkArgs(nargs int) error {
+	if !s.conn.argCountCheck {
+		return nil
+	}
+	return s.conn.argCountError(s.query, s.placeholders, nargs)
+}
+
+func (c *conn) argCountError(query string, expected, nargs int) error {
+	if expected < 0 || expected == nargs {
+		return nil
+	}
+
+	err := ErrArgCountMismatch{Expected: expected, Got: nargs, Query: query}
+	if c.argCountWarn {
+		c.diag.report(EventArgCountMismatch, err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlaceholders(t *testing.T) {
+	for query, expected := range map[string]int{
+		"SELECT 1": 0,
+		"SELECT * FROM t WHERE a = ? AND b IN (?, ?)": 3,
+		"INSERT INTO t VALUES(?,?)":                   2,
+
+		// postgres
+		"SELECT * FROM t WHERE a = $1 AND b = $2": 2,
+		"SELECT * FROM t WHERE a = $2 OR b = $2":  2,
+		"SELECT * FROM t WHERE a = $1 OR b = $1":  1,
+		"SELECT $1::text, $2::int[]":              2,
+		"SELECT a::text FROM t WHERE b = $1":      1,
+		"SELECT $$it's $1$$ WHERE a = $1":         1,
+		"SELECT $body$ $1 $2 $body$ WHERE a = $1": 1,
+		"SELECT * FROM t WHERE a = $10":           10,
+		"SELECT * FROM t WHERE a$1 = 1":           0,
+		"SELECT * FROM t WHERE a = $1a":           0,
+		"SELECT t.a FROM t WHERE a = $1 -- $2":    1,
+		"SELECT 'a' FROM t WHERE a = $1 /* $2 */": 1,
+		`SELECT "$2" FROM t WHERE a = $1`:         1,
+		"SELECT E'\\\\' FROM t WHERE a = $1":      1,
+		"SELECT * FROM t WHERE a = $1 AND b = :b": 1,
+		"SELECT * FROM t WHERE a = $1 AND b = ?":  -1,
+
+		// SQL Server
+		"SELECT * FROM t WHERE a = @p1 AND b = @p2": 2,
+		"SELECT * FROM t WHERE a = @P1":             1,
+		"SELECT @@VERSION, @p1":                     1,
+		"SELECT * FROM t WHERE a = @name":           0,
+		"SELECT @p1 + ?":                            -1,
+
+		// MySQL and SQLite
+		"SELECT '?' FROM t WHERE a = ?":       1,
+		"SELECT 'it''s ?' FROM t WHERE a = ?": 1,
+		"SELECT `?` FROM t WHERE a = ?":       1,
+		"SELECT ? -- ?\nFROM t":               1,
+		"SELECT ? /* ? */ FROM t":             1,
+		"SELECT @user FROM t WHERE a = ?":     1,
+		"SELECT * FROM t WHERE a = :a":        0,
+		"SELECT 'unterminated ?":              0,
+	} {
+		assert.Equal(t, expected, Placeholders(query), query)
+	}
+}
+
+func TestQueryPlaceholderStyle(t *testing.T) {
+	for _, c := range []struct {
+		query, system string
+		expected      PlaceholderStyle
+	}{
+		{"SELECT 1", "postgresql", PlaceholderNone},
+		{"SELECT * FROM t WHERE a = $1", "postgresql", PlaceholderDollar},
+		{"SELECT * FROM t WHERE a = ?", "mysql", PlaceholderQuestion},
+		{"SELECT * FROM t WHERE a = @p1", "mssql", PlaceholderAtP},
+		{"SELECT * FROM t WHERE a = $1 AND b = ?", "postgresql", PlaceholderMixed},
+
+		// jsonb operators
+		{"SELECT * FROM t WHERE data ? 'k'", "postgresql", PlaceholderNone},
+		{"SELECT * FROM t WHERE data ? 'k'", "mysql", PlaceholderQuestion},
+		{"SELECT * FROM t WHERE data ?| $1", "postgresql", PlaceholderDollar},
+		{"SELECT * FROM t WHERE data->'a' ?& array['b']", "postgresql", PlaceholderNone},
+		{"SELECT * FROM t WHERE f(data) ? 'k' AND t.data ? $1", "postgresql", PlaceholderDollar},
+		{"SELECT * FROM t\nWHERE data\n? 'k'", "postgresql", PlaceholderNone},
+		{"SELECT * FROM t WHERE a = ?", "postgresql", PlaceholderQuestion},
+		{"SELECT * FROM t WHERE a IN (?, ?)", "postgresql", PlaceholderQuestion},
+		{"SELECT * FROM t WHERE a = 1 AND ? = b", "postgresql", PlaceholderQuestion},
+		{"SELECT * FROM t LIMIT ? OFFSET ?", "postgresql", PlaceholderQuestion},
+		{"SELECT ?", "postgresql", PlaceholderQuestion},
+		{"SELECT * FROM t WHERE data ? 'k' AND a = ?", "postgresql", PlaceholderQuestion},
+		{"SELECT * FROM t WHERE a = 'data ? k' -- data ? $1", "postgresql", PlaceholderNone},
+	} {
+		assert.Equal(t, c.expected, QueryPlaceholderStyle(c.query, c.system), "%s: %s", c.system, c.query)
+	}
+
+	assert.Equal(t, "$1", PlaceholderDollar.String())
+	assert.Equal(t, "unknown", PlaceholderStyle(42).String())
+}
+
+// argCountConn records the statements reaching the driver
+func argCountConn(t *testing.T, opts ...Option) (*conn, *recordingConn, *[]error) {
+	var errs []error
+	after := func(ctx *Context) error {
+		errs = append(errs, ctx.Error)
+		return ctx.Error
+	}
+	hooks := &FuncHooks{
+		Exec:      Funcs{After: after},
+		Query:     Funcs{After: after},
+		StmtExec:  Funcs{After: after},
+		StmtQuery: Funcs{After: after},
+	}
+
+	rc := &recordingConn{}
+	dc, err := NewDriver("", hooks, opts...).wrap(context.Background(), "", rc)
+	require.NoError(t, err)
+	return dc.(*conn), rc, &errs
+}
+
+func TestArgCountCheck(t *testing.T) {
+	c, rc, errs := argCountConn(t, WithArgCountCheck(true))
+
+	query := "UPDATE t SET f1 = $1 WHERE id = $2"
+	_, err := c.Exec(query, []driver.Value{"foo"})
+	mismatch := ErrArgCountMismatch{Expected: 2, Got: 1, Query: query}
+	assert.Equal(t, mismatch, err)
+	assert.Equal(t, "sqlhooks: expected 2 arguments, got 1: "+query, err.Error())
+
+	_, err = c.Exec(query, []driver.Value{"foo", 1})
+	require.NoError(t, err)
+
+	s, err := c.Prepare(query)
+	require.NoError(t, err)
+	_, err = s.Exec([]driver.Value{"foo", 1, 2})
+	assert.Equal(t, ErrArgCountMismatch{Expected: 2, Got: 3, Query: query}, err)
+	_, err = s.Query(nil)
+	assert.Equal(t, ErrArgCountMismatch{Expected: 2, Got: 0, Query: query}, err)
+
+	// mixed styles aren't checked
+	_, err = c.Exec("UPDATE t SET f1 = $1 WHERE id = ?", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []error{mismatch, nil, ErrArgCountMismatch{Expected: 2, Got: 3, Query: query}, ErrArgCountMismatch{Expected: 2, Got: 0, Query: query}, nil}, *errs)
+	assert.Equal(t, []string{
+		"exec " + query + " [foo 1]",
+		"prepare " + query,
+		"exec UPDATE t SET f1 = $1 WHERE id = ? []",
+	}, rc.log)
+}
+
+func TestArgCountWarning(t *testing.T) {
+	var events []interface{}
+	c, rc, errs := argCountConn(t, WithArgCountCheck(true), WithArgCountWarning(), WithInternalLogger(func(event string, err error) {
+		events = append(events, event, err)
+	}))
+
+	query := "INSERT INTO t VALUES(?)"
+	_, err := c.Exec(query, []driver.Value{"foo", "bar"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []error{nil}, *errs)
+	assert.Equal(t, []string{"exec " + query + " [foo bar]"}, rc.log)
+	assert.Equal(t, []interface{}{EventArgCountMismatch, ErrArgCountMismatch{Expected: 1, Got: 2, Query: query}}, events)
+}
+
+func TestArgCountCheckDisabled(t *testing.T) {
+	c, rc, errs := argCountConn(t)
+
+	_, err := c.Exec("INSERT INTO t VALUES(?)", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil}, *errs)
+	assert.Len(t, rc.log, 1)
+}
+
+// numInputConn prepares statements whose driver reports numInput arguments
+type numInputConn struct {
+	recordingConn
+	numInput int
+}
+
+func (c *numInputConn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.recordingConn.Prepare(query)
+	return numInputStmt{s.(recordingStmt), c.numInput}, err
+}
+
+type numInputStmt struct {
+	recordingStmt
+	numInput int
+}
+
+func (s numInputStmt) NumInput() int { return s.numInput }
+
+func TestNumInput(t *testing.T) {
+	// The driver knows better than the Placeholders count, 1 for the
+	// cast of the query, or doesn't know
+	query := "UPDATE t SET f1 = $1::text WHERE id = $2 AND f2 = $3"
+	for _, numInput := range []int{2, -1} {
+		var seen []int
+		record := func(ctx *Context) error {
+			seen = append(seen, ctx.NumInput)
+			return ctx.Error
+		}
+		hooks := &FuncHooks{Prepare: Funcs{After: record}, StmtExec: Funcs{Before: func(ctx *Context) error {
+			seen = append(seen, ctx.NumInput)
+			return nil
+		}, After: record}}
+		dc := &numInputConn{numInput: numInput}
+		c, err := NewDriver("", hooks, WithArgCountCheck(true)).wrap(context.Background(), "", dc)
+		require.NoError(t, err)
+
+		s, err := c.Prepare(query)
+		require.NoError(t, err)
+		assert.Equal(t, numInput, s.NumInput())
+		_, err = s.Exec([]driver.Value{"foo", 1})
+		if numInput < 0 {
+			assert.Equal(t, ErrArgCountMismatch{Expected: 3, Got: 2, Query: query}, err)
+		} else {
+			require.NoError(t, err)
+		}
+		_, err = s.Exec([]driver.Value{"foo", 1, 2})
+		if numInput < 0 {
+			require.NoError(t, err)
+		} else {
+			assert.Equal(t, ErrArgCountMismatch{Expected: 2, Got: 3, Query: query}, err)
+		}
+		assert.Equal(t, []int{numInput, numInput, numInput, numInput, numInput}, seen, "%d", numInput)
+
+		r, err := MarshalEvent(s.(*stmt).ctx)
+		require.NoError(t, err)
+		require.NotNil(t, r.NumInput)
+		assert.Equal(t, numInput, *r.NumInput)
+	}
+
+	r, err := MarshalEvent(NewContext())
+	require.NoError(t, err)
+	assert.Nil(t, r.NumInput, "not a prepared statement")
+}
@@ -0,0 +1,31 @@
+package sqlhooks
+
+import "database/sql"
+
+// ArgsSize returns the approximate encoded size of args in bytes: the length
+// of strings and byte slices, the width of scalars, and 8 for any other
+// type, such as int64, float64 or time.Time. Named arguments count for their value.
+func ArgsSize(args []interface{}) int {
+	size := 0
+	for _, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok {
+			arg = named.Value
+		}
+		switch v := arg.(type) {
+		case nil:
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		case bool, int8, uint8:
+			size++
+		case int16, uint16:
+			size += 2
+		case int32, uint32, float32:
+			size += 4
+		default:
+			size += 8
+		}
+	}
+	return size
+}
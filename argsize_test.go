@@ -0,0 +1,45 @@
+package sqlhooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArgsSize(t *testing.T) {
+	assert.Equal(t, 0, ArgsSize(nil))
+	assert.Equal(t, 0, ArgsSize([]interface{}{nil}))
+	assert.Equal(t, 3+4+1+8+8+8+2+4, ArgsSize([]interface{}{
+		"foo", []byte("barz"), true, int64(1), 1.5, time.Now(), int16(1), float32(1),
+	}))
+}
+
+func TestWithArgsSizeSetsContext(t *testing.T) {
+	var sizes []int
+	record := func(ctx *Context) error {
+		sizes = append(sizes, ctx.ArgsSize)
+		return nil
+	}
+	hooks := &HooksMock{beforeExec: record, beforeStmtExec: record}
+
+	openDBWithHooks(t, nil).Close()
+	db, err := Open(*driverFlag, *dsnFlag, hooks, WithArgsSize())
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(queries[*driverFlag].insert, "foo", []byte("bar"))
+	require.NoError(t, err)
+	require.NotEmpty(t, sizes)
+	for _, size := range sizes {
+		assert.Equal(t, 6, size)
+	}
+
+	sizes = nil
+	db = openDBWithHooks(t, &HooksMock{beforeExec: record, beforeStmtExec: record})
+	defer db.Close()
+	_, err = db.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, 0, sizes[0], "ArgsSize is only computed with WithArgsSize")
+}
@@ -0,0 +1,41 @@
+package sqlhooks
+
+import (
+	"database/sql/driver"
+	"reflect"
+)
+
+// WithArgTypes sets Context.ArgTypes for the Query, Exec and Stmt hooks,
+// the Go types of the arguments as given to database/sql, before it
+// converts them to driver values: a time.Time, a driver.Valuer or a nil
+// interface, where Context.Args only hold the converted values.
+// database/sql only shows them to the drivers from Go 1.9.
+func WithArgTypes() Option {
+	return func(d *Driver) {
+		d.argTypes = true
+	}
+}
+
+// recordArgType keeps the type of the argument nv, as database/sql checks
+// it before the statement it belongs to, with WithArgTypes
+func (c *conn) recordArgType(nv *driver.NamedValue) {
+	if !c.argTypes {
+		return
+	}
+	// The first argument of a statement drops the ones of a statement
+	// database/sql failed to convert
+	if nv.Ordinal <= 1 {
+		c.pendingArgTypes = c.pendingArgTypes[:0]
+	}
+	c.pendingArgTypes = append(c.pendingArgTypes, reflect.TypeOf(nv.Value))
+}
+
+// takeArgTypes returns the types recorded for the statement about to run
+func (c *conn) takeArgTypes() []reflect.Type {
+	if len(c.pendingArgTypes) == 0 {
+		return nil
+	}
+	types := append([]reflect.Type(nil), c.pendingArgTypes...)
+	c.pendingArgTypes = c.pendingArgTypes[:0]
+	return types
+}
@@ -0,0 +1,50 @@
+package sqlhooks
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArgTypes(t *testing.T) {
+	var types [][]reflect.Type
+	hooks := &FuncHooks{Exec: Funcs{Before: func(ctx *Context) error {
+		types = append(types, ctx.ArgTypes)
+		return nil
+	}}}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks, WithArgTypes())
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec("INSERT INTO t VALUES (?, ?)", time.Time{}, nil)
+	require.NoError(t, err)
+	// database/sql fails to convert the second argument, the first one's
+	// type isn't left for the next statement
+	_, err = db.Exec("INSERT INTO t VALUES (?, ?)", 1, struct{}{})
+	require.Error(t, err)
+	_, err = db.Exec("INSERT INTO t VALUES (?)", "a")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO t VALUES (1)")
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]reflect.Type{
+		{reflect.TypeOf(time.Time{}), nil},
+		{reflect.TypeOf("")},
+		nil,
+	}, types)
+
+	// Disabled by default
+	types = nil
+	plain := &FuncHooks{Exec: hooks.Exec}
+	db, err = Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), plain)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO t VALUES (?)", "a")
+	require.NoError(t, err)
+	assert.Equal(t, [][]reflect.Type{nil}, types)
+}
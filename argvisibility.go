@@ -0,0 +1,101 @@
+package sqlhooks
+
+import "reflect"
+
+// ArgVisibility is what the hooks see of the arguments of the statements,
+// see WithArgVisibility
+type ArgVisibility uint8
+
+const (
+	// ArgsVisible shows the hooks the arguments as given to the driver, the default
+	ArgsVisible ArgVisibility = iota
+
+	// ArgsRedacted shows the hooks the arguments returned by the Redactor
+	// set with WithRedactor, RedactArgs without any
+	ArgsRedacted
+
+	// ArgsHidden shows the hooks a HiddenArg per argument, telling only
+	// their number
+	ArgsHidden
+)
+
+// HiddenArg is the argument seen by the hooks in place of every argument
+// with ArgsHidden
+type HiddenArg struct{}
+
+func (HiddenArg) String() string { return "<hidden>" }
+
+// RedactedArg is the argument seen by the hooks in place of every non-nil
+// argument with ArgsRedacted and the default Redactor, it keeps its Go type
+type RedactedArg struct {
+	Type string
+}
+
+func (a RedactedArg) String() string { return "<redacted " + a.Type + ">" }
+
+// Redactor returns the arguments the hooks see in place of the arguments
+// args of query, with ArgsRedacted. It runs before any hook, and must not
+// change args, it may return a slice of a different length.
+type Redactor func(query string, args []interface{}) []interface{}
+
+// RedactArgs is the default Redactor, it replaces every non-nil argument
+// by a RedactedArg
+func RedactArgs(query string, args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg != nil {
+			redacted[i] = RedactedArg{Type: reflect.TypeOf(arg).String()}
+		}
+	}
+	return redacted
+}
+
+// WithArgVisibility sets what every hook of the driver sees of the
+// arguments of the Query, Exec, Stmt and Explainer hooks, composed ones and
+// the ones added with WithExtraHooks included: the values are replaced in
+// Context.Args before the first hook runs, the hooks never get the original
+// ones. ArgsHidden also leaves Context.ArgTypes and Context.ArgsSize unset.
+//
+// With ArgsRedacted or ArgsHidden, the arguments set in Context.Args by the
+// Before hooks are ignored, the driver always gets the original ones, and
+// the statements with arguments aren't explained, since an Explainer could
+// select them. The statements rewritten by Before hooks still run.
+func WithArgVisibility(v ArgVisibility) Option {
+	return func(d *Driver) {
+		d.argVisibility = v
+	}
+}
+
+// WithRedactor sets the Redactor of ArgsRedacted, RedactArgs by default
+func WithRedactor(r Redactor) Option {
+	return func(d *Driver) {
+		d.redactor = r
+	}
+}
+
+// hideArgs replaces the arguments of ctx, a statement of query, by the ones
+// the hooks may see
+func (c *conn) hideArgs(ctx *Context, query string) {
+	switch c.argVisibility {
+	case ArgsRedacted:
+		redactor := c.redactor
+		if redactor == nil {
+			redactor = RedactArgs
+		}
+		ctx.Args = redactor(query, ctx.Args)
+	case ArgsHidden:
+		hidden := make([]interface{}, len(ctx.Args))
+		for i := range hidden {
+			hidden[i] = HiddenArg{}
+		}
+		ctx.Args = hidden
+		ctx.ArgTypes = nil
+		ctx.ArgsSize = 0
+	}
+}
+
+// argsVisible tells whether the hooks see the original arguments, and may
+// change them
+func (c *conn) argsVisible() bool {
+	return c.argVisibility == ArgsVisible
+}
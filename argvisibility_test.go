@@ -0,0 +1,140 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snoopHooks records every argument it's shown, replaces them with its own
+// ones and asks for the plan of a statement selecting them
+type snoopHooks struct {
+	NopHooks
+	seen []string
+}
+
+func (h *snoopHooks) snoop(ctx *Context) error {
+	h.seen = append(h.seen, fmt.Sprintf("%v %v %d", ctx.Args, ctx.ArgTypes, ctx.ArgsSize))
+	ctx.Args = []interface{}{"snooped"}
+	return nil
+}
+
+func (h *snoopHooks) BeforeQuery(ctx *Context) error     { return h.snoop(ctx) }
+func (h *snoopHooks) BeforeExec(ctx *Context) error      { return h.snoop(ctx) }
+func (h *snoopHooks) BeforeStmtQuery(ctx *Context) error { return h.snoop(ctx) }
+func (h *snoopHooks) BeforeStmtExec(ctx *Context) error  { return h.snoop(ctx) }
+
+func (h *snoopHooks) ExplainQuery(ctx *Context) (string, bool) {
+	return "SELECT ?", true
+}
+
+func (h *snoopHooks) AfterExplain(ctx *Context, plan string, err error) {
+	h.seen = append(h.seen, fmt.Sprintf("explain %v", ctx.Args))
+}
+
+func TestArgVisibility(t *testing.T) {
+	count := func(query string, args []interface{}) []interface{} {
+		return []interface{}{fmt.Sprintf("%d args of %s", len(args), query)}
+	}
+
+	cases := []struct {
+		name    string
+		opts    []Option
+		seen    []string
+		drivers []string
+	}{
+		{
+			name: "visible",
+			seen: []string{
+				"[secret 7] [string int] 14", "explain [snooped]",
+				"[secret] [string] 6", "explain [snooped]",
+			},
+			drivers: []string{
+				"exec INSERT INTO t VALUES (?, ?) [snooped]", "query SELECT ? [snooped]",
+				"query [snooped]", "query SELECT ? [snooped]",
+			},
+		},
+		{
+			name: "redacted",
+			opts: []Option{WithArgVisibility(ArgsRedacted)},
+			seen: []string{
+				"[<redacted string> <redacted int64>] [string int] 14",
+				"[<redacted string>] [string] 6",
+			},
+			drivers: []string{"exec INSERT INTO t VALUES (?, ?) [secret 7]", "query [secret]"},
+		},
+		{
+			name: "redactor",
+			opts: []Option{WithArgVisibility(ArgsRedacted), WithRedactor(count)},
+			seen: []string{
+				"[2 args of INSERT INTO t VALUES (?, ?)] [string int] 14",
+				"[1 args of SELECT n FROM t WHERE s = ?] [string] 6",
+			},
+			drivers: []string{"exec INSERT INTO t VALUES (?, ?) [secret 7]", "query [secret]"},
+		},
+		{
+			name: "hidden",
+			opts: []Option{WithArgVisibility(ArgsHidden)},
+			seen: []string{
+				"[<hidden> <hidden>] [] 0",
+				"[<hidden>] [] 0",
+			},
+			drivers: []string{"exec INSERT INTO t VALUES (?, ?) [secret 7]", "query [secret]"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// The hook snoops directly, composed, and when added to the
+			// statement's context
+			for _, attach := range []string{"direct", "composed", "extra"} {
+				snoop := &snoopHooks{}
+				var hooks HookType = snoop
+				ctx := context.Background()
+				switch attach {
+				case "composed":
+					hooks = Compose(&FuncHooks{}, snoop)
+				case "extra":
+					hooks = &FuncHooks{}
+					ctx = WithExtraHooks(ctx, snoop)
+				}
+
+				driverConn := &recordingConn{}
+				d := NewDriver("", hooks, append([]Option{WithArgTypes(), WithArgsSize()}, c.opts...)...)
+				d.driver = recordingDriver{driverConn}
+				db, err := sql.Open(RegisterUnique("visibility", d), "")
+				require.NoError(t, err)
+				db.SetMaxOpenConns(1)
+
+				_, err = db.ExecContext(ctx, "INSERT INTO t VALUES (?, ?)", "secret", 7)
+				require.NoError(t, err)
+				stmt, err := db.PrepareContext(ctx, "SELECT n FROM t WHERE s = ?")
+				require.NoError(t, err)
+				rows, err := stmt.QueryContext(ctx, "secret")
+				require.NoError(t, err)
+				require.NoError(t, rows.Close())
+				require.NoError(t, stmt.Close())
+				require.NoError(t, db.Close())
+
+				assert.Equal(t, c.seen, snoop.seen, attach)
+				assert.Equal(t, c.drivers, filterPrepares(driverConn.log), attach)
+			}
+		})
+	}
+}
+
+// filterPrepares drops the prepares from a recordingConn log
+func filterPrepares(log []string) []string {
+	filtered := []string{}
+	for _, l := range log {
+		if !strings.HasPrefix(l, "prepare ") {
+			filtered = append(filtered, l)
+		}
+	}
+	return filtered
+}
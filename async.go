@@ -0,0 +1,198 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/internal/queue"
+)
+
+// Async returns hooks running hooks in workers background goroutines, off
+// the query path, each holding up to size pending events: an event is
+// dropped when its worker is full, or once Shutdown is called, see Dropped.
+//
+// As for TailSample, the Before hooks of an operation run right before its
+// After hooks, once it ran, on a copy of the Context taken then: they can't
+// change the query or skip the operation, and the errors the hooks return
+// are ignored. Columns are read before the copy is queued. The explain and
+// rows wrapping events run synchronously, they're part of the operation.
+//
+// The events of a transaction are all handled by the same worker, chosen by
+// the transaction id, the ones of the statements out of transactions by the
+// id of their connection: each of the hooks gets them in the order they
+// happened, Begin before the statements within the transaction, which come
+// before its Commit or Rollback and its TxSummaryHook event. The events of
+// different transactions, or connections, are handled concurrently, in no
+// particular order. The BatchSummaryHook events are handled by the worker
+// chosen by the batch id, the ConnSummaryHook ones by the connection's. The
+// CopyHook events are handled as the statements of the COPY are, the
+// ResultErrorHook ones, which don't tell their connection, by the first worker.
+//
+// A hook panicking is recovered, the panic is reported as an EventHookPanic
+// and the dropped events as EventDropped ones. The hooks returned implement
+// Shutdowner, their Shutdown waits for the pending events before shutting
+// hooks down.
+func Async(hooks HookType, workers, size int) HookType {
+	if hooks == nil {
+		return nil
+	}
+	return &async{hooks: hooks, queue: queue.New("sqlhooks: async", workers, size)}
+}
+
+type async struct {
+	hooks HookType
+	queue *queue.Queue
+}
+
+func (a *async) implements(is func(HookType) bool) bool {
+	return implementsHook(a.hooks, is)
+}
+
+// push queues event to the worker of key, it never blocks
+func (a *async) push(key uint64, event func()) {
+	a.queue.Push(key, event)
+}
+
+func (a *async) SetInternalReporter(report func(event string, err error)) {
+	a.queue.SetReporter(report)
+	setInternalReporterAll([]HookType{a.hooks}, report)
+}
+
+// Dropped returns the number of events dropped because their worker was
+// full, or the hooks shut down, it's meant for tests and metrics
+func (a *async) Dropped() uint64 {
+	return a.queue.Dropped()
+}
+
+// key is the key of the worker of the events of ctx
+func (ctx *Context) key() uint64 {
+	if ctx.TxID != 0 {
+		return ctx.TxID
+	}
+	return ctx.ConnID
+}
+
+// snapshot returns a copy of ctx the hooks can use once the operation
+// returned, as the Context of a prepared statement is reused by its
+// executions
+func (ctx *Context) snapshot() *Context {
+	c := *ctx
+	if ctx.rows != nil {
+		c.columns = ctx.Columns()
+		c.rows = nil
+	}
+	if ctx.values != nil {
+		c.values = make(map[string]interface{}, len(ctx.values))
+		for k, v := range ctx.values {
+			c.values[k] = v
+		}
+	}
+	return &c
+}
+
+// after queues running the Before and After functions of the hooks on a snapshot of ctx
+func (a *async) after(ctx *Context, funcs hookFuncs) error {
+	c := ctx.snapshot()
+	a.push(c.key(), func() { replay(a.hooks, c, funcs) })
+	return ctx.Error
+}
+
+func (a *async) BeforeBegin(ctx *Context) error { return nil }
+func (a *async) AfterBegin(ctx *Context) error  { return a.after(ctx, beginFuncs) }
+
+func (a *async) BeforeCommit(ctx *Context) error { return nil }
+func (a *async) AfterCommit(ctx *Context) error  { return a.after(ctx, commitFuncs) }
+
+func (a *async) BeforeRollback(ctx *Context) error { return nil }
+func (a *async) AfterRollback(ctx *Context) error  { return a.after(ctx, rollbackFuncs) }
+
+func (a *async) BeforePrepare(ctx *Context) error { return nil }
+func (a *async) AfterPrepare(ctx *Context) error  { return a.after(ctx, prepareFuncs) }
+
+func (a *async) BeforeStmtQuery(ctx *Context) error { return nil }
+func (a *async) AfterStmtQuery(ctx *Context) error  { return a.after(ctx, stmtQueryFuncs) }
+
+func (a *async) BeforeStmtExec(ctx *Context) error { return nil }
+func (a *async) AfterStmtExec(ctx *Context) error  { return a.after(ctx, stmtExecFuncs) }
+
+func (a *async) BeforeQuery(ctx *Context) error { return nil }
+func (a *async) AfterQuery(ctx *Context) error  { return a.after(ctx, queryFuncs) }
+
+func (a *async) BeforeExec(ctx *Context) error { return nil }
+func (a *async) AfterExec(ctx *Context) error  { return a.after(ctx, execFuncs) }
+
+func (a *async) AfterRowsClose(ctx *Context) error { return a.after(ctx, rowsCloseFuncs) }
+
+func (a *async) ExplainQuery(ctx *Context) (string, bool) {
+	if t, ok := a.hooks.(Explainer); ok {
+		return t.ExplainQuery(ctx)
+	}
+	return "", false
+}
+
+func (a *async) AfterExplain(ctx *Context, plan string, err error) {
+	if t, ok := a.hooks.(Explainer); ok {
+		t.AfterExplain(ctx, plan, err)
+	}
+}
+
+func (a *async) WrapRows(ctx *Context, rows driver.Rows) driver.Rows {
+	if t, ok := a.hooks.(RowsWrapper); ok {
+		return t.WrapRows(ctx, rows)
+	}
+	return rows
+}
+
+func (a *async) Savepoint(txID uint64, name string) {
+	if t, ok := a.hooks.(Savepointer); ok {
+		a.push(txID, func() { t.Savepoint(txID, name) })
+	}
+}
+
+func (a *async) ReleaseSavepoint(txID uint64, name string) {
+	if t, ok := a.hooks.(Savepointer); ok {
+		a.push(txID, func() { t.ReleaseSavepoint(txID, name) })
+	}
+}
+
+func (a *async) RollbackToSavepoint(txID uint64, name string) {
+	if t, ok := a.hooks.(Savepointer); ok {
+		a.push(txID, func() { t.RollbackToSavepoint(txID, name) })
+	}
+}
+
+func (a *async) AfterTx(summary TxSummary) {
+	if t, ok := a.hooks.(TxSummaryHook); ok {
+		a.push(summary.TxID, func() { t.AfterTx(summary) })
+	}
+}
+
+func (a *async) AfterBatch(summary BatchSummary) {
+	if t, ok := a.hooks.(BatchSummaryHook); ok {
+		a.push(summary.BatchID, func() { t.AfterBatch(summary) })
+	}
+}
+
+func (a *async) StillRunning(ctx *Context, elapsed time.Duration) {
+	if t, ok := a.hooks.(StillRunner); ok {
+		c := ctx.snapshot()
+		a.push(c.key(), func() { t.StillRunning(c, elapsed) })
+	}
+}
+
+// Shutdown waits for the pending events, then shuts the hooks down. Once ctx
+// is done, it gives up: the workers stop as soon as the hooks in progress
+// return, dropping the events left, and ctx's error is returned.
+func (a *async) Shutdown(ctx context.Context) error {
+	if err := a.queue.Shutdown(ctx); err != nil {
+		return err
+	}
+	return shutdownAll(ctx, []HookType{a.hooks})
+}
+
+// conditions are the conditions of a, for Describe
+func (a *async) conditions() []string {
+	return []string{fmt.Sprintf("workers %d", a.queue.Workers())}
+}
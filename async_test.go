@@ -0,0 +1,185 @@
+package sqlhooks
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// asyncRecorder records the events of every transaction, and of the statements
+// of every connection out of transactions, sleeping a bit in each so the
+// workers interleave
+type asyncRecorder struct {
+	NopHooks
+	mu    sync.Mutex
+	txs   map[uint64][]string
+	conns map[uint64][]uint64
+}
+
+func (r *asyncRecorder) record(ctx *Context, event string) error {
+	time.Sleep(time.Duration(rand.Int63n(int64(50 * time.Microsecond))))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ctx.TxID == 0 {
+		r.conns[ctx.ConnID] = append(r.conns[ctx.ConnID], ctx.Seq)
+	} else {
+		r.txs[ctx.TxID] = append(r.txs[ctx.TxID], event)
+	}
+	return nil
+}
+
+func (r *asyncRecorder) AfterBegin(ctx *Context) error    { return r.record(ctx, "begin") }
+func (r *asyncRecorder) AfterCommit(ctx *Context) error   { return r.record(ctx, "commit") }
+func (r *asyncRecorder) AfterRollback(ctx *Context) error { return r.record(ctx, "rollback") }
+func (r *asyncRecorder) AfterExec(ctx *Context) error     { return r.record(ctx, ctx.Query) }
+
+func (r *asyncRecorder) AfterTx(summary TxSummary) {
+	r.record(&Context{TxID: summary.TxID}, fmt.Sprintf("summary %d", summary.Statements))
+}
+
+func TestAsyncTxOrder(t *testing.T) {
+	r := &asyncRecorder{txs: make(map[uint64][]string), conns: make(map[uint64][]uint64)}
+	hooks := Async(r, 4, 10000)
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(8)
+
+	const txs, statements = 100, 5
+	var wg sync.WaitGroup
+	for i := 0; i < txs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tx, err := db.Begin()
+			require.NoError(t, err)
+			for j := 0; j < statements; j++ {
+				_, err := tx.Exec(fmt.Sprintf("INSERT INTO t VALUES (%d)", j))
+				require.NoError(t, err)
+			}
+			if i%2 == 0 {
+				require.NoError(t, tx.Commit())
+			} else {
+				require.NoError(t, tx.Rollback())
+			}
+			_, err = db.Exec("DELETE FROM t")
+			require.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	require.NoError(t, hooks.(Shutdowner).Shutdown(context.Background()))
+	require.Zero(t, hooks.(*async).Dropped())
+
+	require.Len(t, r.txs, txs)
+	outcomes := make(map[string]int)
+	for id, events := range r.txs {
+		require.Len(t, events, statements+3, "tx %d: %v", id, events)
+		assert.Equal(t, "begin", events[0], "tx %d", id)
+		for j := 0; j < statements; j++ {
+			assert.Equal(t, fmt.Sprintf("INSERT INTO t VALUES (%d)", j), events[j+1], "tx %d", id)
+		}
+		outcomes[events[statements+1]]++
+		assert.Equal(t, fmt.Sprintf("summary %d", statements), events[statements+2], "tx %d", id)
+	}
+	assert.Equal(t, map[string]int{"commit": txs / 2, "rollback": txs / 2}, outcomes)
+
+	var outOfTx int
+	for id, seqs := range r.conns {
+		outOfTx += len(seqs)
+		for i := 1; i < len(seqs); i++ {
+			assert.True(t, seqs[i-1] < seqs[i], "conn %d: %v", id, seqs)
+		}
+	}
+	assert.Equal(t, txs, outOfTx)
+}
+
+func TestAsyncDropsOnceShutDown(t *testing.T) {
+	r := &asyncRecorder{txs: make(map[uint64][]string), conns: make(map[uint64][]uint64)}
+	hooks := Async(r, 2, 10)
+	ctx := NewContext()
+	ctx.TxID = 1
+	require.NoError(t, hooks.(Beginner).AfterBegin(ctx))
+	require.NoError(t, hooks.(Shutdowner).Shutdown(context.Background()))
+	require.NoError(t, hooks.(Shutdowner).Shutdown(context.Background()))
+
+	require.NoError(t, hooks.(Commiter).AfterCommit(ctx))
+	assert.Equal(t, map[uint64][]string{1: {"begin"}}, r.txs)
+	assert.Equal(t, uint64(1), hooks.(*async).Dropped())
+}
+
+// blockingHooks signals started when an exec event is handled, then waits
+// for release and panics
+type blockingHooks struct {
+	NopHooks
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHooks) AfterExec(ctx *Context) error {
+	h.started <- struct{}{}
+	<-h.release
+	panic("boom " + ctx.Query)
+}
+
+func newBlockingHooks() *blockingHooks {
+	return &blockingHooks{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func TestAsyncReportsDropsAndPanics(t *testing.T) {
+	h := newBlockingHooks()
+	d := NewDriver("", Async(h, 1, 1))
+	exec := func(query string) {
+		ctx := NewContext()
+		ctx.Query = query
+		require.NoError(t, d.Hooks().(Execer).AfterExec(ctx))
+	}
+
+	exec("1")
+	<-h.started
+	exec("2")
+	exec("3")
+	close(h.release)
+	require.NoError(t, d.Hooks().(Shutdowner).Shutdown(context.Background()))
+
+	assert.Equal(t, uint64(1), d.Stats().EventsDropped, "the worker was full")
+	assert.Equal(t, uint64(2), d.Stats().HookPanics, "the panics are recovered")
+	assert.Equal(t, uint64(1), d.Hooks().(*async).Dropped())
+}
+
+func TestAsyncShutdownGivesUp(t *testing.T) {
+	h := newBlockingHooks()
+	d := NewDriver("", Async(h, 1, 10))
+	a := d.Hooks().(*async)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, a.AfterExec(NewContext()))
+	}
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, a.Shutdown(ctx))
+
+	close(h.release)
+	a.queue.Close()
+	assert.Len(t, h.started, 0, "the hooks didn't get the events left")
+	assert.Equal(t, uint64(2), a.Dropped())
+	assert.Equal(t, uint64(2), d.Stats().EventsDropped)
+	assert.Equal(t, uint64(1), d.Stats().HookPanics)
+}
+
+func TestDescribeAsync(t *testing.T) {
+	d := NewDriver("", Async(namedHooks{"async"}, 4, 100))
+	assert.Equal(t, []HookDescription{
+		{Name: "Async", Source: "driver", Conditions: []string{"workers 4"}, Hooks: []HookDescription{
+			{Name: "async", Interfaces: []string{"Queryer"}},
+		}},
+	}, d.Describe())
+	assert.Equal(t, []string{"async"}, d.DryDispatch(context.Background(), "SELECT 1")[OpQuery])
+}
@@ -0,0 +1,182 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNestedBatch is returned by BeginBatch for a context.Context already in a batch
+var ErrNestedBatch = errors.New("sqlhooks: nested batch")
+
+// ErrNoBatch is returned by EndBatch for a context.Context not in a batch,
+// or whose batch already ended
+var ErrNoBatch = errors.New("sqlhooks: no batch in progress")
+
+// BatchSummaryHook is the interface implemented by objects that wants a
+// single event per batch, see BeginBatch. AfterBatch is called by EndBatch,
+// for the hooks of the first statement of the batch, with the summary of its
+// statements run with hooks.
+type BatchSummaryHook interface {
+	AfterBatch(summary BatchSummary)
+}
+
+func isBatchSummaryHook(h HookType) bool {
+	_, ok := h.(BatchSummaryHook)
+	return ok
+}
+
+// BatchSummary sums up a batch, it only keeps aggregates, however many
+// statements the batch runs
+type BatchSummary struct {
+	// Ctx is the context.Context given to BeginBatch
+	Ctx     context.Context
+	BatchID uint64
+
+	// Duration is the time from BeginBatch to EndBatch
+	Duration time.Duration
+
+	// Statements counts the statements run within the batch, Failed the
+	// ones which failed, StatementsDuration is the time they spent in the
+	// driver
+	Statements         int
+	Failed             int
+	StatementsDuration time.Duration
+}
+
+type batchKey struct{}
+
+// batchIDs numbers the batches of the process
+var batchIDs uint64
+
+// batch is a batch in progress, its statements may run concurrently
+type batch struct {
+	start instant
+
+	mu      sync.Mutex
+	hook    BatchSummaryHook
+	ended   bool
+	summary BatchSummary
+}
+
+// BeginBatch returns a copy of ctx grouping the statements run with it, or
+// within a transaction begun with it, in a batch until EndBatch is called:
+// their Context.BatchID is the batch's, and EndBatch calls the
+// BatchSummaryHook hooks with its summary. That's the grouping of the
+// statements a driver sends in a single round trip, or of the statements
+// of a bulk insert loop, whose latency is only meaningful as a whole.
+// Batches don't nest, it fails with ErrNestedBatch for a ctx in a batch
+// which didn't end.
+func BeginBatch(ctx context.Context) (context.Context, error) {
+	if b := batchFrom(ctx); b != nil && !b.done() {
+		return ctx, ErrNestedBatch
+	}
+	b := &batch{start: systemClock(), summary: BatchSummary{Ctx: ctx, BatchID: atomic.AddUint64(&batchIDs, 1)}}
+	return context.WithValue(ctx, batchKey{}, b), nil
+}
+
+// EndBatch ends the batch of ctx, calling the BatchSummaryHook hooks with
+// its summary, which it returns. The statements run with ctx afterwards
+// aren't part of any batch. It fails with ErrNoBatch for a ctx not in a
+// batch, or whose batch already ended.
+func EndBatch(ctx context.Context) (BatchSummary, error) {
+	b := batchFrom(ctx)
+	if b == nil {
+		return BatchSummary{}, ErrNoBatch
+	}
+	b.mu.Lock()
+	if b.ended {
+		b.mu.Unlock()
+		return BatchSummary{}, ErrNoBatch
+	}
+	b.ended = true
+	b.summary.Duration = clock(systemClock).since(b.start)
+	summary, hook := b.summary, b.hook
+	b.mu.Unlock()
+
+	if hook != nil {
+		hook.AfterBatch(summary)
+	}
+	return summary, nil
+}
+
+func batchFrom(ctx context.Context) *batch {
+	if ctx == nil {
+		return nil
+	}
+	b, _ := ctx.Value(batchKey{}).(*batch)
+	return b
+}
+
+func (b *batch) done() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ended
+}
+
+// batch returns the batch in progress of a statement issued with stdCtx, the
+// one of stdCtx or of the transaction in progress, nil if there's none
+func (c *conn) batch(stdCtx context.Context) *batch {
+	b := batchFrom(stdCtx)
+	if b == nil {
+		b = c.txBatch
+	}
+	if b == nil || b.done() {
+		return nil
+	}
+	return b
+}
+
+// id returns the id of b, 0 for a nil one
+func (b *batch) id() uint64 {
+	if b == nil {
+		return 0
+	}
+	return b.summary.BatchID
+}
+
+// statement adds a statement run with hooks within b, b can be nil. The
+// hooks of the first statement get the summary.
+func (b *batch) statement(hooks HookType, took time.Duration, err error) {
+	if b == nil || err == driver.ErrSkip {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ended {
+		return
+	}
+	if b.summary.Statements == 0 {
+		if t, ok := hooks.(BatchSummaryHook); ok && implementsHook(hooks, isBatchSummaryHook) {
+			b.hook = t
+		}
+	}
+	b.summary.Statements++
+	if err != nil {
+		b.summary.Failed++
+	}
+	b.summary.StatementsDuration += took
+}
+
+func (hs composed) AfterBatch(summary BatchSummary) {
+	for _, h := range hs {
+		if t, ok := h.(BatchSummaryHook); ok {
+			t.AfterBatch(summary)
+		}
+	}
+}
+
+func (r *router) AfterBatch(summary BatchSummary) {
+	if t, ok := r.hooks(KindTx).(BatchSummaryHook); ok {
+		t.AfterBatch(summary)
+	}
+}
+
+func (s *sampler) AfterBatch(summary BatchSummary) {
+	if t, ok := s.hooks.(BatchSummaryHook); ok {
+		t.AfterBatch(summary)
+	}
+}
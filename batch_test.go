@@ -0,0 +1,144 @@
+package sqlhooks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchHooks records the BatchID of every statement and the batch summaries
+type batchHooks struct {
+	NopHooks
+	ids       map[string]uint64
+	summaries []BatchSummary
+}
+
+func (h *batchHooks) after(ctx *Context) error {
+	h.ids[ctx.Query] = ctx.BatchID
+	return ctx.Error
+}
+
+func (h *batchHooks) AfterQuery(ctx *Context) error     { return h.after(ctx) }
+func (h *batchHooks) AfterExec(ctx *Context) error      { return h.after(ctx) }
+func (h *batchHooks) AfterStmtQuery(ctx *Context) error { return h.after(ctx) }
+func (h *batchHooks) AfterStmtExec(ctx *Context) error  { return h.after(ctx) }
+func (h *batchHooks) AfterBatch(summary BatchSummary)   { h.summaries = append(h.summaries, summary) }
+
+func TestBatchSummary(t *testing.T) {
+	hooks := &batchHooks{ids: make(map[string]uint64)}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), Compose(&FuncHooks{}, hooks))
+	require.NoError(t, err)
+	defer db.Close()
+
+	parent := context.Background()
+	ctx, err := BeginBatch(parent)
+	require.NoError(t, err)
+	for _, query := range []string{"INSERT INTO t VALUES (1)", "SLEEP 20ms|INSERT INTO t VALUES (2)", "FAIL deadlock|INSERT INTO t VALUES (3)"} {
+		db.ExecContext(ctx, query)
+	}
+	rows, err := db.QueryContext(ctx, "ROWS 2|SELECT n FROM t")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	stmt, err := db.PrepareContext(ctx, "INSERT INTO t VALUES (?)")
+	require.NoError(t, err)
+	_, err = stmt.ExecContext(ctx, 4)
+	require.NoError(t, err)
+	require.NoError(t, stmt.Close())
+	_, err = db.Exec("DELETE FROM t")
+	require.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+	summary, err := EndBatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []BatchSummary{summary}, hooks.summaries)
+	assert.True(t, summary.Ctx == parent)
+	assert.NotZero(t, summary.BatchID)
+	assert.Equal(t, 5, summary.Statements, "prepares and statements outside the batch aside")
+	assert.Equal(t, 1, summary.Failed)
+	assert.True(t, summary.StatementsDuration >= 20*time.Millisecond, "%s", summary.StatementsDuration)
+	assert.True(t, summary.Duration >= summary.StatementsDuration+10*time.Millisecond, "%s", summary.Duration)
+
+	for query, id := range hooks.ids {
+		if query == "DELETE FROM t" {
+			assert.Zero(t, id, query)
+		} else {
+			assert.Equal(t, summary.BatchID, id, query)
+		}
+	}
+
+	// The batch is over for ctx
+	_, err = db.ExecContext(ctx, "UPDATE t SET n = 1")
+	require.NoError(t, err)
+	assert.Zero(t, hooks.ids["UPDATE t SET n = 1"])
+	_, err = EndBatch(ctx)
+	assert.Equal(t, ErrNoBatch, err)
+	_, err = EndBatch(parent)
+	assert.Equal(t, ErrNoBatch, err)
+	assert.Len(t, hooks.summaries, 1)
+}
+
+func TestNestedBatch(t *testing.T) {
+	ctx, err := BeginBatch(context.Background())
+	require.NoError(t, err)
+	nested, err := BeginBatch(WithExtraHooks(ctx, &FuncHooks{}))
+	assert.Equal(t, ErrNestedBatch, err)
+	assert.Equal(t, ErrNoBatch, func() error { _, err := EndBatch(context.Background()); return err }())
+
+	first, err := EndBatch(nested)
+	require.NoError(t, err, "the batch of ctx")
+	assert.Zero(t, first.Statements)
+
+	// Once it ended, ctx can begin the next one
+	next, err := BeginBatch(ctx)
+	require.NoError(t, err)
+	second, err := EndBatch(next)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.BatchID, second.BatchID)
+}
+
+func TestBatchSpanningTx(t *testing.T) {
+	for _, mode := range []string{drivertest.ModeContext, drivertest.ModeLegacy} {
+		t.Run(mode, func(t *testing.T) {
+			hooks := &batchHooks{ids: make(map[string]uint64)}
+			db, err := Open(drivertest.Name, mode+";"+t.Name(), hooks)
+			require.NoError(t, err)
+			defer db.Close()
+
+			ctx, err := BeginBatch(context.Background())
+			require.NoError(t, err)
+
+			// Begun with the batch, the statements run without it belong to it
+			tx, err := db.BeginTx(ctx, nil)
+			require.NoError(t, err)
+			_, err = tx.Exec("INSERT INTO t VALUES (1)")
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+
+			// Begun without, the ones run with it do
+			tx, err = db.Begin()
+			require.NoError(t, err)
+			_, err = tx.ExecContext(ctx, "INSERT INTO t VALUES (2)")
+			require.NoError(t, err)
+			_, err = tx.Exec("INSERT INTO t VALUES (3)")
+			require.NoError(t, err)
+			require.NoError(t, tx.Rollback())
+
+			_, err = db.Exec("INSERT INTO t VALUES (4)")
+			require.NoError(t, err)
+
+			summary, err := EndBatch(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 2, summary.Statements)
+			assert.Equal(t, map[string]uint64{
+				"INSERT INTO t VALUES (1)": summary.BatchID,
+				"INSERT INTO t VALUES (2)": summary.BatchID,
+				"INSERT INTO t VALUES (3)": 0,
+				"INSERT INTO t VALUES (4)": 0,
+			}, hooks.ids)
+		})
+	}
+}
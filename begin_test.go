@@ -0,0 +1,197 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBeginRefused = errors.New("begin refused")
+
+// flakyBeginConn fails every other Begin, logging the transactions calls
+type flakyBeginConn struct {
+	recordingConn
+	begins int
+}
+
+func (c *flakyBeginConn) Begin() (driver.Tx, error) {
+	c.begins++
+	if c.begins%2 == 0 {
+		c.log = append(c.log, "begin failed")
+		return nil, errBeginRefused
+	}
+	c.log = append(c.log, "begin")
+	return recordingTx{&c.recordingConn}, nil
+}
+
+type flakyBeginDriver struct {
+	conn *flakyBeginConn
+}
+
+func (d flakyBeginDriver) Open(string) (driver.Conn, error) { return d.conn, nil }
+
+type recordingTx struct {
+	conn *recordingConn
+}
+
+func (tx recordingTx) Commit() error {
+	tx.conn.log = append(tx.conn.log, "commit")
+	return nil
+}
+
+func (tx recordingTx) Rollback() error {
+	tx.conn.log = append(tx.conn.log, "rollback")
+	return nil
+}
+
+// txRecorder logs the transactions hooks and summaries
+type txRecorder struct {
+	*FuncHooks
+	log []string
+}
+
+func newTxRecorder() *txRecorder {
+	r := &txRecorder{}
+	record := func(op string) Funcs {
+		return Funcs{After: func(ctx *Context) error {
+			r.log = append(r.log, fmt.Sprintf("%s %d %v", op, ctx.TxID, ctx.Error))
+			return ctx.Error
+		}}
+	}
+	r.FuncHooks = &FuncHooks{Begin: record("begin"), Commit: record("commit"), Rollback: record("rollback")}
+	return r
+}
+
+func (r *txRecorder) AfterTx(s TxSummary) {
+	r.log = append(r.log, fmt.Sprintf("tx %d %s %v", s.TxID, s.Outcome, s.Err))
+}
+
+func openFlakyBeginDB(t *testing.T, hooks HookType) (*sql.DB, *Driver, *flakyBeginConn) {
+	c := &flakyBeginConn{}
+	d := NewDriver("", hooks)
+	d.driver = flakyBeginDriver{c}
+	db, err := sql.Open(RegisterUnique("flakybegin", d), "")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	return db, d, c
+}
+
+func TestFailedBegin(t *testing.T) {
+	hooks := newTxRecorder()
+	db, d, c := openFlakyBeginDB(t, hooks)
+	defer db.Close()
+
+	var ids []uint64
+	for i := 0; i < 4; i++ {
+		tx, err := db.Begin()
+		if i%2 == 1 {
+			assert.Equal(t, errBeginRefused, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+	}
+	for _, l := range hooks.log {
+		var op string
+		var id uint64
+		fmt.Sscanf(l, "%s %d", &op, &id)
+		if op == "begin" {
+			ids = append(ids, id)
+		}
+	}
+
+	require.Len(t, ids, 4)
+	assert.Equal(t, []string{
+		fmt.Sprintf("begin %d <nil>", ids[0]),
+		fmt.Sprintf("commit %d <nil>", ids[0]),
+		fmt.Sprintf("tx %d committed <nil>", ids[0]),
+		fmt.Sprintf("begin %d begin refused", ids[1]),
+		fmt.Sprintf("begin %d <nil>", ids[2]),
+		fmt.Sprintf("commit %d <nil>", ids[2]),
+		fmt.Sprintf("tx %d committed <nil>", ids[2]),
+		fmt.Sprintf("begin %d begin refused", ids[3]),
+	}, hooks.log)
+	assert.True(t, ids[0] < ids[1] && ids[1] < ids[2] && ids[2] < ids[3], "failed Begins get ids of their own")
+	assert.Equal(t, []string{"begin", "commit", "begin failed", "begin", "commit", "begin failed"}, c.log)
+	assert.Equal(t, int64(0), d.Stats().OpenTxs)
+}
+
+func TestTxUsedAfterCommit(t *testing.T) {
+	hooks := newTxRecorder()
+	db, d, _ := openFlakyBeginDB(t, hooks)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), d.Stats().OpenTxs)
+	require.NoError(t, tx.Commit())
+
+	_, err = tx.Exec("UPDATE t SET v = 1")
+	assert.Equal(t, sql.ErrTxDone, err)
+	assert.Equal(t, sql.ErrTxDone, tx.Commit())
+	assert.Equal(t, sql.ErrTxDone, tx.Rollback())
+
+	assert.Len(t, hooks.log, 3, "begin, commit and tx")
+	assert.Equal(t, int64(0), d.Stats().OpenTxs)
+}
+
+func TestFailingTxHooksEndTheTx(t *testing.T) {
+	errHook := errors.New("hook failed")
+	for _, c := range []struct {
+		name  string
+		hooks *FuncHooks
+		log   []string
+	}{
+		{"AfterBegin", &FuncHooks{Begin: Funcs{After: func(ctx *Context) error { return errHook }}},
+			[]string{"begin", "rollback"}},
+		{"BeforeCommit", &FuncHooks{Commit: Funcs{Before: func(ctx *Context) error { return errHook }}},
+			[]string{"begin", "rollback"}},
+		{"BeforeRollback", &FuncHooks{Rollback: Funcs{Before: func(ctx *Context) error { return errHook }}},
+			[]string{"begin", "rollback"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var outcomes []TxOutcome
+			hooks := summarizedFuncHooks{c.hooks, func(s TxSummary) {
+				outcomes = append(outcomes, s.Outcome)
+				assert.Equal(t, errHook, s.Err)
+			}}
+			db, d, conn := openFlakyBeginDB(t, hooks)
+			defer db.Close()
+
+			tx, err := db.Begin()
+			if err == nil {
+				if c.name == "BeforeCommit" {
+					err = tx.Commit()
+				} else {
+					err = tx.Rollback()
+				}
+			}
+			assert.Equal(t, errHook, err)
+
+			assert.Equal(t, c.log, conn.log)
+			assert.Equal(t, []TxOutcome{TxRolledBack}, outcomes)
+			assert.Equal(t, int64(0), d.Stats().OpenTxs)
+
+			// The connection is usable again
+			_, err = db.ExecContext(context.Background(), "UPDATE t SET v = 1")
+			require.NoError(t, err)
+		})
+	}
+}
+
+type txSummaryFunc func(TxSummary)
+
+func (fn txSummaryFunc) AfterTx(s TxSummary) { fn(s) }
+
+// summarizedFuncHooks get the summary of their transactions without being
+// composed, so that their After errors fail the operation
+type summarizedFuncHooks struct {
+	*FuncHooks
+	txSummaryFunc
+}
@@ -2,21 +2,57 @@ package sqlhooks
 
 import (
 	"database/sql"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 func init() {
-	sql.Register("sqlhooks", NewDriver("test", NewHooksMock(
+	hooks := NewHooksMock(
 		func(ctx *Context) error {
 			return nil
 		},
 		func(ctx *Context) error {
 			return ctx.Error
 		},
-	)))
+	)
+
+	sql.Register("sqlhooks", NewDriver("test", hooks))
+	// The test driver has no Queryer, its queries are prepared
+	sql.Register("sqlhooks-noquery", NewDriver("test", hooks, WithOperations(OpAll&^(OpQuery|OpPrepare))))
+	sql.Register("sqlhooks-nil", NewDriver("test", nil))
+	sql.Register("sqlhooks-timing", NewDriver("test", hooks, WithSelfTiming()))
+	sql.Register("sqlhooks-ids-metrics", NewDriver("test", NewHooksMock(metricsIDs, metricsIDs)))
+	sql.Register("sqlhooks-ids-logging", NewDriver("test", NewHooksMock(loggingIDs, loggingIDs)))
+}
+
+// idSink keeps the IDs metricsIDs and loggingIDs read from being optimized out
+var idSink struct {
+	sum  uint64
+	text string
+}
+
+// metricsIDs uses the IDs as a metrics hook does, as integers
+func metricsIDs(ctx *Context) error {
+	idSink.sum += ctx.ConnID + ctx.TxID + ctx.StatementID
+	return ctx.Error
+}
+
+// loggingIDs formats the IDs, as a logging hook does
+func loggingIDs(ctx *Context) error {
+	idSink.text = "conn=" + strconv.FormatUint(ctx.ConnID, 10) +
+		" tx=" + strconv.FormatUint(ctx.TxID, 10) +
+		" stmt=" + strconv.FormatUint(ctx.StatementID, 10)
+	return ctx.Error
 }
 
-func newDB(b *testing.B, driver string) *sql.DB {
+func newDB(b testing.TB, driver string) *sql.DB {
 	db, err := sql.Open(driver, "db")
 	if err != nil {
 		b.Fatalf("Open: %v", err)
@@ -79,3 +115,310 @@ func BenchmarkPreparedExecWithSQLHooks(b *testing.B) {
 		}
 	}
 }
+
+func benchmarkQuery(b *testing.B, driver string) {
+	db := newDB(b, driver)
+	if _, err := db.Exec("INSERT|t|f1=?", "xxx"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rows, err := db.Query("SELECT|t|f1|")
+		if err != nil {
+			b.Fatal(err)
+		}
+		rows.Close()
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	benchmarkQuery(b, "test")
+}
+
+func BenchmarkQueryWithSQLHooks(b *testing.B) {
+	benchmarkQuery(b, "sqlhooks")
+}
+
+func BenchmarkQueryWithSQLHooksDisabled(b *testing.B) {
+	benchmarkQuery(b, "sqlhooks-noquery")
+}
+
+// overheadDrivers are the configurations BenchmarkOverhead compares: the
+// fakedb driver itself, and wrapped with nil hooks, no-op hooks, and no-op
+// hooks with WithSelfTiming
+var overheadDrivers = []struct{ name, driver string }{
+	{"raw", "test"},
+	{"nil", "sqlhooks-nil"},
+	{"noop", "sqlhooks"},
+	{"timing", "sqlhooks-timing"},
+}
+
+var overheadOps = []struct {
+	name  string
+	setup func(b testing.TB, db *sql.DB) func(b testing.TB)
+}{
+	{"Exec", func(b testing.TB, db *sql.DB) func(b testing.TB) {
+		return func(b testing.TB) {
+			if _, err := db.Exec("INSERT|t|f1=?", "xxx"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}},
+	{"Query100", func(b testing.TB, db *sql.DB) func(b testing.TB) {
+		for i := 0; i < 100; i++ {
+			if _, err := db.Exec("INSERT|t|f1=?", "xxx"); err != nil {
+				b.Fatal(err)
+			}
+		}
+		return func(b testing.TB) {
+			rows, err := db.Query("SELECT|t|f1|")
+			if err != nil {
+				b.Fatal(err)
+			}
+			var f1 string
+			for rows.Next() {
+				if err := rows.Scan(&f1); err != nil {
+					b.Fatal(err)
+				}
+			}
+			rows.Close()
+		}
+	}},
+	{"PreparedExec", func(b testing.TB, db *sql.DB) func(b testing.TB) {
+		stmt, err := db.Prepare("INSERT|t|f1=?")
+		if err != nil {
+			b.Fatal(err)
+		}
+		return func(b testing.TB) {
+			if _, err := stmt.Exec("xxx"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}},
+	{"Tx", func(b testing.TB, db *sql.DB) func(b testing.TB) {
+		return func(b testing.TB) {
+			tx, err := db.Begin()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := tx.Exec("INSERT|t|f1=?", "xxx"); err != nil {
+				b.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}},
+}
+
+// BenchmarkOverhead measures the wrapper overhead for every operation and
+// driver configuration, serially and in parallel, results are in BENCHMARKS.md
+func BenchmarkOverhead(b *testing.B) {
+	for _, op := range overheadOps {
+		for _, d := range overheadDrivers {
+			b.Run(op.name+"/"+d.name, func(b *testing.B) {
+				run := op.setup(b, newDB(b, d.driver))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					run(b)
+				}
+			})
+
+			b.Run(op.name+"/"+d.name+"/parallel", func(b *testing.B) {
+				run := op.setup(b, newDB(b, d.driver))
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						run(b)
+					}
+				})
+			})
+		}
+	}
+}
+
+// overheadFactors are the slowdowns of the noop configuration over raw
+// TestOverheadRegression accepts by default, with room over the measured
+// ones for slower machines
+var overheadFactors = map[string]float64{
+	"Exec":         3,
+	"Query100":     1.5,
+	"PreparedExec": 2.5,
+	"Tx":           3,
+}
+
+// overheadConfig is the configuration of TestOverheadRegression, from the
+// environment
+type overheadConfig struct {
+	runs, iterations int
+	tolerance        float64
+	factors          map[string]float64
+}
+
+func overheadConfigFromEnv(t *testing.T) overheadConfig {
+	c := overheadConfig{runs: 9, iterations: 20000, tolerance: 0.1, factors: make(map[string]float64)}
+	for op, factor := range overheadFactors {
+		c.factors[op] = factor
+	}
+
+	atoi := func(name string, v *int) {
+		if s := os.Getenv(name); s != "" {
+			n, err := strconv.Atoi(s)
+			require.NoError(t, err, name)
+			require.True(t, n > 0, "%s must be positive", name)
+			*v = n
+		}
+	}
+	atoi("SQLHOOKS_OVERHEAD_RUNS", &c.runs)
+	atoi("SQLHOOKS_OVERHEAD_ITERATIONS", &c.iterations)
+	if s := os.Getenv("SQLHOOKS_OVERHEAD_TOLERANCE"); s != "" {
+		var err error
+		c.tolerance, err = strconv.ParseFloat(s, 64)
+		require.NoError(t, err, "SQLHOOKS_OVERHEAD_TOLERANCE")
+	}
+	if s := os.Getenv("SQLHOOKS_OVERHEAD_FACTORS"); s != "" {
+		for _, pair := range strings.Split(s, ",") {
+			i := strings.IndexByte(pair, '=')
+			require.True(t, i > 0, "SQLHOOKS_OVERHEAD_FACTORS: %q isn't op=factor", pair)
+			op := strings.TrimSpace(pair[:i])
+			_, ok := c.factors[op]
+			require.True(t, ok, "SQLHOOKS_OVERHEAD_FACTORS: unknown operation %q", op)
+			factor, err := strconv.ParseFloat(strings.TrimSpace(pair[i+1:]), 64)
+			require.NoError(t, err, "SQLHOOKS_OVERHEAD_FACTORS")
+			c.factors[op] = factor
+		}
+	}
+	return c
+}
+
+// timeRuns runs run iterations times, once to warm up and once timed,
+// returning the time per iteration
+func timeRuns(t *testing.T, run func(testing.TB), iterations int) time.Duration {
+	for i := 0; i < iterations/10; i++ {
+		run(t)
+	}
+	runtime.GC()
+	start := time.Now()
+	for i := 0; i < iterations; i++ {
+		run(t)
+	}
+	return time.Since(start) / time.Duration(iterations)
+}
+
+func median(ds []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// TestOverheadRegression fails when an operation on the noop configuration
+// of BenchmarkOverhead is more than its factor slower than on the raw one.
+// It's opt-in, as timings aren't reliable on shared CI machines:
+//
+//	SQLHOOKS_OVERHEAD=1 go test -run OverheadRegression -v
+//
+// Each operation runs SQLHOOKS_OVERHEAD_RUNS times on both configurations,
+// interleaved so a change of the machine load affects both, each run being
+// SQLHOOKS_OVERHEAD_ITERATIONS iterations after a tenth of them to warm up.
+// The ratio of the medians is compared to the factor of the operation, set
+// with SQLHOOKS_OVERHEAD_FACTORS as in Exec=3,Tx=2.5: up to
+// SQLHOOKS_OVERHEAD_TOLERANCE above it, 0.1 for 10%, it's only logged.
+func TestOverheadRegression(t *testing.T) {
+	if os.Getenv("SQLHOOKS_OVERHEAD") == "" {
+		t.Skip("set SQLHOOKS_OVERHEAD to check the overhead")
+	}
+	c := overheadConfigFromEnv(t)
+
+	for _, op := range overheadOps {
+		raw := op.setup(t, newDB(t, "test"))
+		noop := op.setup(t, newDB(t, "sqlhooks"))
+
+		var raws, noops []time.Duration
+		for i := 0; i < c.runs; i++ {
+			raws = append(raws, timeRuns(t, raw, c.iterations))
+			noops = append(noops, timeRuns(t, noop, c.iterations))
+		}
+
+		factor := c.factors[op.name]
+		ratio := float64(median(noops)) / float64(median(raws))
+		t.Logf("%s: raw %s, noop %s, ratio %.2f, factor %.2f", op.name, median(raws), median(noops), ratio, factor)
+		switch {
+		case ratio > factor*(1+c.tolerance):
+			t.Errorf("%s: the wrapper makes it %.2f times slower, over %.2f", op.name, ratio, factor)
+		case ratio > factor:
+			t.Logf("%s: %.2f is over %.2f, within the tolerance", op.name, ratio, factor)
+		}
+	}
+}
+
+// analyzedQueries is a query mix as an ORM issues it, the same few query
+// strings over and over
+var analyzedQueries = []string{
+	"SELECT `users`.`id`, `users`.`email`, `users`.`name`, `users`.`created_at` FROM `users` WHERE `users`.`id` = ? LIMIT 1",
+	"SELECT `orders`.* FROM `orders` WHERE `orders`.`user_id` = ? AND `orders`.`deleted_at` IS NULL ORDER BY `orders`.`created_at` DESC LIMIT 20",
+	"INSERT INTO `orders` (`user_id`, `total`, `status`, `created_at`, `updated_at`) VALUES (?, ?, ?, ?, ?)",
+	"UPDATE `users` SET `last_seen_at` = ?, `updated_at` = ? WHERE `users`.`id` = ?",
+	`SELECT "products"."id", "products"."sku", "products"."price" FROM "products" WHERE "products"."id" IN ($1, $2, $3, $4)`,
+	`DELETE FROM "sessions" WHERE "sessions"."expires_at" < $1`,
+	"SELECT COUNT(*) FROM `orders` WHERE `orders`.`status` = 'pending' /* dashboard */",
+	"BEGIN",
+	"COMMIT",
+}
+
+// BenchmarkIDs compares hooks reading the connection, transaction and
+// statement IDs as the integers they are with hooks formatting them: the
+// IDs cost no allocation until a hook formats them
+func BenchmarkIDs(b *testing.B) {
+	for _, d := range []struct{ name, driver string }{
+		{"noop", "sqlhooks"},
+		{"metrics", "sqlhooks-ids-metrics"},
+		{"logging", "sqlhooks-ids-logging"},
+	} {
+		b.Run(d.name, func(b *testing.B) {
+			db := newDB(b, d.driver)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tx, err := db.Begin()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := tx.Exec("INSERT|t|f1=?", "xxx"); err != nil {
+					b.Fatal(err)
+				}
+				if err := tx.Commit(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkQueryAnalysis measures what Classify, Fingerprint and
+// Placeholders cost per statement, with and without the query cache
+func BenchmarkQueryAnalysis(b *testing.B) {
+	defer SetQueryCacheSize(DefaultQueryCacheSize)
+
+	for _, bench := range []struct {
+		name string
+		size int
+	}{
+		{"cached", DefaultQueryCacheSize},
+		{"uncached", 0},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			SetQueryCacheSize(bench.size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				query := analyzedQueries[i%len(analyzedQueries)]
+				Classify(query)
+				Fingerprint(query)
+				Placeholders(query)
+			}
+		})
+	}
+}
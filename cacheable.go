@@ -0,0 +1,24 @@
+package sqlhooks
+
+import (
+	"context"
+	"time"
+)
+
+type cacheableKey struct{}
+
+// Cacheable returns a copy of ctx marking the queries run with it as safe to
+// serve from a cache for up to ttl, for hooks caching results such as
+// hooks/resultcache. Queries aren't cached unless asked for.
+func Cacheable(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, cacheableKey{}, ttl)
+}
+
+// CacheableTTL returns the ttl ctx has been marked Cacheable with
+func CacheableTTL(ctx context.Context) (time.Duration, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	ttl, ok := ctx.Value(cacheableKey{}).(time.Duration)
+	return ttl, ok
+}
@@ -0,0 +1,23 @@
+package sqlhooks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheable(t *testing.T) {
+	_, ok := CacheableTTL(context.Background())
+	assert.False(t, ok)
+
+	ttl, ok := CacheableTTL(Cacheable(context.Background(), 5*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, ttl)
+}
+
+func TestSkipTenantCheck(t *testing.T) {
+	assert.False(t, TenantCheckSkipped(context.Background()))
+	assert.True(t, TenantCheckSkipped(SkipTenantCheck(context.Background())))
+}
@@ -0,0 +1,216 @@
+package sqlhooks
+
+import (
+	"fmt"
+	"strings"
+)
+
+// classify is Classify, tests replace it to count the classifications
+var classify = Classify
+
+// Kind is the kind of a statement, as told by its operation
+type Kind int
+
+const (
+	KindOther Kind = iota
+	KindSelect
+	KindInsert
+	KindUpdate
+	KindDelete
+
+	// KindDDL are the statements changing the schema, as CREATE or DROP
+	KindDDL
+
+	// KindTx are the transaction statements, as SAVEPOINT, Route sends the
+	// Begin, Commit and Rollback hooks to the KindTx hooks as well
+	KindTx
+)
+
+var kinds = map[string]Kind{
+	"select":    KindSelect,
+	"insert":    KindInsert,
+	"replace":   KindInsert,
+	"update":    KindUpdate,
+	"delete":    KindDelete,
+	"create":    KindDDL,
+	"alter":     KindDDL,
+	"drop":      KindDDL,
+	"truncate":  KindDDL,
+	"rename":    KindDDL,
+	"begin":     KindTx,
+	"start":     KindTx,
+	"commit":    KindTx,
+	"rollback":  KindTx,
+	"savepoint": KindTx,
+	"release":   KindTx,
+}
+
+var kindNames = [...]string{"other", "select", "insert", "update", "delete", "ddl", "tx"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// classification caches the classification of a Context's query
+type classification struct {
+	done      bool
+	query     string
+	operation string
+	table     string
+	kind      Kind
+}
+
+// Classify returns Classify(ctx.Query). The query is classified once, the
+// first time it's asked, and shared by every hook of the operation and, for
+// prepared statements, of its executions, unless a hook changes the query.
+func (ctx *Context) Classify() (operation, table string) {
+	c := &ctx.classification
+	if !c.done || c.query != ctx.Query {
+		c.operation, c.table = classify(ctx.Query)
+		c.kind = kinds[c.operation]
+		c.query, c.done = ctx.Query, true
+	}
+	return c.operation, c.table
+}
+
+// Kind returns the kind of ctx.Query, classified as Context.Classify does
+func (ctx *Context) Kind() Kind {
+	ctx.Classify()
+	return ctx.classification.kind
+}
+
+// Classify returns the operation of query in lower case, such as select or
+// insert, and the first table it refers to, "" when it can't tell. For
+// joins, that's the first table after FROM. Quotes are removed from the
+// table name, so "public"."users" is public.users, and comments and strings
+// are skipped. Classifications are cached, see SetQueryCacheSize.
+func Classify(query string) (operation, table string) {
+	info := analyses.get(query)
+	if info == nil {
+		return classifyQuery(query)
+	}
+	info.classifyOnce.Do(func() { info.operation, info.table = classifyQuery(query) })
+	return info.operation, info.table
+}
+
+func classifyQuery(query string) (operation, table string) {
+	tokens := classifyTokens(query)
+	if len(tokens) == 0 {
+		return "", ""
+	}
+
+	operation = strings.ToLower(tokens[0].text)
+	var after string
+	switch operation {
+	case "select", "delete":
+		after = "from"
+	case "insert", "replace":
+		after = "into"
+	case "update":
+		if len(tokens) > 1 && tokens[1].ident {
+			table = tokens[1].text
+		}
+		return operation, table
+	case "copy":
+		if len(tokens) > 1 && tokens[1].ident {
+			// COPY (SELECT ...) TO copies a subquery
+			next := tokens[1]
+			if next.quoted || !strings.EqualFold(next.text, "select") && !strings.EqualFold(next.text, "with") {
+				table = next.text
+			}
+		}
+		return operation, table
+	default:
+		return operation, ""
+	}
+
+	for i := 1; i < len(tokens)-1; i++ {
+		if !tokens[i].quoted && strings.EqualFold(tokens[i].text, after) {
+			next := tokens[i+1]
+			if !next.ident || !next.quoted && strings.EqualFold(next.text, "select") {
+				// A subquery
+				return operation, ""
+			}
+			return operation, next.text
+		}
+	}
+	return operation, ""
+}
+
+// classifyToken is a word of a query, ident being set for identifiers,
+// possibly qualified, whose text has its quotes removed, quoted being set
+// if a part of it was quoted
+type classifyToken struct {
+	text   string
+	ident  bool
+	quoted bool
+}
+
+// classifyTokens splits query into words, skipping comments, the strings
+// are kept as unquoted tokens that aren't identifiers
+func classifyTokens(query string) []classifyToken {
+	var tokens []classifyToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(' || c == ')' || c == ',' || c == ';':
+			i++
+		case c == '-' && strings.HasPrefix(query[i:], "--"), c == '/' && strings.HasPrefix(query[i:], "/*"):
+			i = skipLiteral(query, i)
+		case c == '\'':
+			end := skipQuoted(query, i, c)
+			tokens = append(tokens, classifyToken{text: query[i:end]})
+			i = end
+		default:
+			var token classifyToken
+			token, i = classifyIdent(query, i)
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// classifyIdent reads the possibly qualified and quoted identifier at i
+func classifyIdent(query string, i int) (classifyToken, int) {
+	token := classifyToken{ident: true}
+	var parts []string
+	for i < len(query) {
+		var part string
+		switch c := query[i]; c {
+		case '"', '`', '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := skipQuoted(query, i, closing)
+			part = query[i+1 : end]
+			if strings.HasSuffix(part, string(closing)) {
+				part = part[:len(part)-1]
+			}
+			part = strings.Replace(part, string([]byte{closing, closing}), string(closing), -1)
+			token.quoted = true
+			i = end
+		default:
+			start := i
+			for i < len(query) && !strings.ContainsRune(" \t\n\r(),;.\"`[", rune(query[i])) {
+				i++
+			}
+			part = query[start:i]
+		}
+		parts = append(parts, part)
+
+		if i >= len(query) || query[i] != '.' {
+			break
+		}
+		i++
+	}
+	if len(parts) == 1 && parts[0] == "" {
+		// a lone character, as a stray dot
+		i++
+	}
+	token.text = strings.Join(parts, ".")
+	return token, i
+}
@@ -0,0 +1,96 @@
+package sqlhooks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	for query, expected := range map[string][2]string{
+		"SELECT f1, f2 FROM t WHERE f1 = ?":        {"select", "t"},
+		"  select count(*)\nfrom \"public\".\"t\"": {"select", "public.t"},
+		"INSERT INTO `t`(f1, f2) VALUES(?, ?)":     {"insert", "t"},
+		"UPDATE t SET f1 = ?":                      {"update", "t"},
+		"DELETE FROM t":                            {"delete", "t"},
+		"SELECT 1":                                 {"select", ""},
+		"SELECT * FROM (SELECT * FROM t) AS sub":   {"select", ""},
+		"CREATE TABLE t(f1, f2)":                   {"create", ""},
+		"":                                         {"", ""},
+
+		// qualified and quoted names
+		`SELECT * FROM "public"."users" WHERE id = ?`:   {"select", "public.users"},
+		"SELECT * FROM db.users":                        {"select", "db.users"},
+		"SELECT * FROM `db`.`users`":                    {"select", "db.users"},
+		"SELECT * FROM [dbo].[users]":                   {"select", "dbo.users"},
+		`SELECT * FROM public."Order Items"`:            {"select", "public.Order Items"},
+		`SELECT * FROM "a""b"`:                          {"select", `a"b`},
+		`SELECT * FROM"users"`:                          {"select", "users"},
+		`UPDATE "public"."users" SET name = ?`:          {"update", "public.users"},
+		"INSERT INTO `db`.`users`(id) VALUES(?)":        {"insert", "db.users"},
+		`DELETE FROM "users" WHERE id = ?`:              {"delete", "users"},
+		`COPY "public"."users" ("id") FROM STDIN`:       {"copy", "public.users"},
+		"COPY (SELECT 1) TO STDOUT":                     {"copy", ""},
+		`SELECT "from" FROM users`:                      {"select", "users"},
+		`SELECT * FROM "select"`:                        {"select", "select"},
+		"SELECT * FROM users u JOIN orders o ON 1 = 1":  {"select", "users"},
+		"SELECT * FROM users, orders":                   {"select", "users"},
+		"SELECT * FROM orders o JOIN users u USING(id)": {"select", "orders"},
+
+		// strings and comments
+		"SELECT 'a from b' FROM t":               {"select", "t"},
+		"SELECT 'it''s from' FROM t":             {"select", "t"},
+		"/* from x */ SELECT f1 FROM t":          {"select", "t"},
+		"-- DELETE\nSELECT f1 -- from x\nFROM t": {"select", "t"},
+		"SELECT * FROM 'unterminated":            {"select", ""},
+	} {
+		op, table := Classify(query)
+		assert.Equal(t, expected, [2]string{op, table}, query)
+	}
+}
+
+func TestContextKind(t *testing.T) {
+	for query, expected := range map[string]Kind{
+		"SELECT 1":                 KindSelect,
+		"insert INTO t VALUES(?)":  KindInsert,
+		"REPLACE INTO t VALUES(?)": KindInsert,
+		"UPDATE t SET f1 = ?":      KindUpdate,
+		"DELETE FROM t":            KindDelete,
+		"CREATE TABLE t(f1)":       KindDDL,
+		"DROP TABLE t":             KindDDL,
+		"SAVEPOINT sp1":            KindTx,
+		"RELEASE SAVEPOINT sp1":    KindTx,
+		"SHOW TABLES":              KindOther,
+		"":                         KindOther,
+	} {
+		ctx := NewContext()
+		ctx.Query = query
+		assert.Equal(t, expected, ctx.Kind(), query)
+	}
+
+	assert.Equal(t, "ddl", KindDDL.String())
+	assert.Equal(t, "Kind(42)", Kind(42).String())
+}
+
+func TestContextClassifiesOnce(t *testing.T) {
+	calls := 0
+	classify = func(query string) (string, string) {
+		calls++
+		return Classify(query)
+	}
+	defer func() { classify = Classify }()
+
+	ctx := NewContext()
+	ctx.Query = "SELECT f1 FROM t"
+	ctx.Classify()
+	ctx.Kind()
+	op, table := ctx.Classify()
+	assert.Equal(t, "select", op)
+	assert.Equal(t, "t", table)
+	assert.Equal(t, 1, calls)
+
+	// A hook changing the query gets it classified again
+	ctx.Query = "DELETE FROM t"
+	assert.Equal(t, KindDelete, ctx.Kind())
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,29 @@
+package sqlhooks
+
+import "time"
+
+// clock is the time source of a Driver's connections, systemClock unless a
+// test sets another one. Every Context duration is the difference of two
+// monotonic readings of it, the wall clock one is only kept for
+// Context.Start, so a step of the system clock, as NTP makes, doesn't change them.
+type clock func() instant
+
+// instant is a reading of a clock: mono is the time elapsed since an
+// arbitrary origin, it never goes back
+type instant struct {
+	wall time.Time
+	mono time.Duration
+}
+
+// processStart is the origin of systemClock's monotonic readings
+var processStart = time.Now()
+
+func systemClock() instant {
+	now := time.Now()
+	return instant{wall: now, mono: now.Sub(processStart)}
+}
+
+// since returns the time elapsed since start
+func (c clock) since(start instant) time.Duration {
+	return c().mono - start.mono
+}
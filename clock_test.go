@@ -0,0 +1,88 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// steppingClock advances its monotonic readings by tick, and steps its wall
+// clock back by an hour on every reading, as a clock corrected by NTP could
+type steppingClock struct {
+	tick time.Duration
+	now  instant
+}
+
+func (c *steppingClock) read() instant {
+	c.now.wall = c.now.wall.Add(-time.Hour)
+	c.now.mono += c.tick
+	return c.now
+}
+
+// clockEvent is the Start and Duration an After hook got
+type clockEvent struct {
+	op       string
+	start    time.Time
+	duration time.Duration
+}
+
+type clockHooks struct {
+	*FuncHooks
+	events    []clockEvent
+	summaries []TxSummary
+}
+
+func (h *clockHooks) record(op string) func(*Context) error {
+	return func(ctx *Context) error {
+		h.events = append(h.events, clockEvent{op, ctx.Start, ctx.Duration})
+		return ctx.Error
+	}
+}
+
+func (h *clockHooks) AfterRowsClose(ctx *Context) error { return h.record("rowsclose")(ctx) }
+func (h *clockHooks) AfterTx(s TxSummary)               { h.summaries = append(h.summaries, s) }
+
+func TestDurationsIgnoreWallClockSteps(t *testing.T) {
+	h := &clockHooks{}
+	h.FuncHooks = &FuncHooks{
+		Begin:  Funcs{After: h.record("begin")},
+		Exec:   Funcs{After: h.record("exec")},
+		Query:  Funcs{After: h.record("query")},
+		Commit: Funcs{After: h.record("commit")},
+	}
+
+	base := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := &steppingClock{tick: 5 * time.Millisecond, now: instant{wall: base}}
+	d := NewDriver("", h)
+	d.clock = c.read
+	dc, err := d.wrap(context.Background(), "", &recordingConn{})
+	require.NoError(t, err)
+	hooked := dc.(*conn)
+
+	tx, err := hooked.BeginTx(context.Background(), driver.TxOptions{})
+	require.NoError(t, err)
+	_, err = hooked.ExecContext(context.Background(), "UPDATE t SET n = 1", nil)
+	require.NoError(t, err)
+	rows, err := hooked.QueryContext(context.Background(), "SELECT n FROM t", nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.NoError(t, tx.Commit())
+
+	// The clock is read once when the connection is opened and when the
+	// transaction is begun, then when every operation starts and ends, and
+	// when the rows are closed
+	wall := func(readings int) time.Time { return base.Add(-time.Duration(readings+1) * time.Hour) }
+	assert.Equal(t, []clockEvent{
+		{"begin", wall(2), 5 * time.Millisecond},
+		{"exec", wall(4), 5 * time.Millisecond},
+		{"query", wall(6), 5 * time.Millisecond},
+		{"rowsclose", wall(6), 10 * time.Millisecond},
+		{"commit", wall(9), 5 * time.Millisecond},
+	}, h.events)
+	require.Len(t, h.summaries, 1)
+	assert.Equal(t, 50*time.Millisecond, h.summaries[0].Duration)
+}
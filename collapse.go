@@ -0,0 +1,267 @@
+package sqlhooks
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// collapseKeep is how many arguments of a collapsed list are kept
+const collapseKeep = 3
+
+// CollapsedArgs stands, in the arguments collapsed by CollapseInLists, for
+// the number of arguments of an IN list left out
+type CollapsedArgs int
+
+func (n CollapsedArgs) String() string {
+	return fmt.Sprintf("(%d more)", int(n))
+}
+
+// CollapseInLists returns query with every IN list of two or more
+// placeholders, as in IN (?, ?, ?), written as IN (?...), and args with only
+// the first 3 arguments of each list, followed by a CollapsedArgs counting the
+// rest. Placeholders can be ?, $1 or, leaving args untouched, :name and @name.
+// Strings, quoted identifiers and comments are left as they are.
+func CollapseInLists(query string, args []interface{}) (string, []interface{}) {
+	if strings.IndexByte(query, ',') < 0 {
+		return query, args
+	}
+
+	var b bytes.Buffer
+	var lists [][]int
+	positional := 0
+	last := 0
+
+	for i := 0; i < len(query); {
+		if next := skipLiteral(query, i); next != i {
+			i = next
+			continue
+		}
+
+		c := query[i]
+		switch {
+		case c == '?':
+			positional++
+			i++
+		case (c == 'i' || c == 'I') && (i == 0 || !isIdentByte(query[i-1])) &&
+			i+1 < len(query) && (query[i+1] == 'n' || query[i+1] == 'N'):
+			open, end, indexes, n := inList(query, i+2, positional)
+			if end < 0 {
+				i += 2
+				continue
+			}
+			b.WriteString(query[last:open])
+			b.WriteString("(?...)")
+			last, i = end, end
+			positional += n
+			lists = append(lists, indexes)
+		default:
+			i++
+		}
+	}
+
+	if lists == nil {
+		return query, args
+	}
+	b.WriteString(query[last:])
+	return b.String(), collapseArgs(args, lists)
+}
+
+// skipLiteral returns the index following the string, quoted identifier,
+// dollar quoted string or comment starting at i, i if there's none
+func skipLiteral(query string, i int) int {
+	c := query[i]
+	switch {
+	case c == '\'' || c == '"' || c == '`':
+		return skipQuoted(query, i, c)
+	case c == '-' && strings.HasPrefix(query[i:], "--"):
+		if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+			return i + end + 1
+		}
+		return len(query)
+	case c == '/' && strings.HasPrefix(query[i:], "/*"):
+		if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+			return i + end + 4
+		}
+		return len(query)
+	case c == '$':
+		tag := dollarTag(query[i:])
+		if tag == "" {
+			return i
+		}
+		if end := strings.Index(query[i+len(tag):], tag); end >= 0 {
+			return i + len(tag) + end + len(tag)
+		}
+		return len(query)
+	}
+	return i
+}
+
+// skipQuoted returns the index following the quoted string or identifier
+// starting at i, a doubled quote is an escaped one
+func skipQuoted(query string, i int, quote byte) int {
+	for i++; i < len(query); i++ {
+		if query[i] == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// dollarTag returns the tag opening a dollar quoted string at the beginning
+// of s, as $$ or $body$, "" if there's none
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80:
+		case isDigit(c) && i > 1:
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// inList parses a list of placeholders, as in "(?, ?)", following IN at i.
+// It returns the index of the opening parenthesis and the one after the
+// closing one, -1 if there's no list of two or more placeholders, the
+// indexes of the arguments of the list, nil if they can't be told, and how
+// many ? placeholders it has.
+func inList(query string, i, positional int) (open, end int, indexes []int, n int) {
+	if i < len(query) && isIdentByte(query[i]) {
+		return 0, -1, nil, 0
+	}
+	i = skipSpaces(query, i)
+	if i >= len(query) || query[i] != '(' {
+		return 0, -1, nil, 0
+	}
+	open = i
+
+	known := true
+	count := 0
+	for i = open + 1; ; {
+		i = skipSpaces(query, i)
+		next, index := placeholder(query, i)
+		if next < 0 {
+			return 0, -1, nil, 0
+		}
+		if index == '?' {
+			index = positional + n
+			n++
+		}
+		if index < 0 {
+			known = false
+		}
+		indexes = append(indexes, index)
+		count++
+
+		i = skipSpaces(query, next)
+		if i >= len(query) {
+			return 0, -1, nil, 0
+		}
+		switch query[i] {
+		case ',':
+			i++
+			continue
+		case ')':
+		default:
+			return 0, -1, nil, 0
+		}
+		break
+	}
+
+	if count < 2 {
+		return 0, -1, nil, 0
+	}
+	if !known {
+		indexes = nil
+	}
+	return open, i + 1, indexes, n
+}
+
+// placeholder parses a placeholder at i, it returns the index following it,
+// -1 if there's none, and the index of its argument: '?' for ?, -1 when it
+// can't be told
+func placeholder(query string, i int) (next, index int) {
+	if i >= len(query) {
+		return -1, 0
+	}
+
+	end := i + 1
+	for end < len(query) && isIdentByte(query[end]) && query[end] != '$' && query[end] != '.' {
+		end++
+	}
+
+	switch query[i] {
+	case '?':
+		if end > i+1 {
+			return -1, 0
+		}
+		return end, '?'
+	case '$':
+		n, err := strconv.Atoi(query[i+1 : end])
+		if err != nil || n < 1 {
+			return -1, 0
+		}
+		return end, n - 1
+	case ':', '@':
+		if end == i+1 || query[i+1] == ':' {
+			return -1, 0
+		}
+		return end, -1
+	}
+	return -1, 0
+}
+
+func skipSpaces(query string, i int) int {
+	for i < len(query) {
+		switch query[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// collapseArgs keeps the first collapseKeep arguments of each list
+func collapseArgs(args []interface{}, lists [][]int) []interface{} {
+	drop := make(map[int]bool)
+	more := make(map[int]int)
+	for _, indexes := range lists {
+		if len(indexes) <= collapseKeep {
+			continue
+		}
+		for _, index := range indexes[collapseKeep:] {
+			if index >= len(args) {
+				// Not the arguments of this query
+				return args
+			}
+			drop[index] = true
+		}
+		more[indexes[collapseKeep]] = len(indexes) - collapseKeep
+	}
+	if len(drop) == 0 {
+		return args
+	}
+
+	collapsed := make([]interface{}, 0, len(args)-len(drop)+len(more))
+	for i, arg := range args {
+		if n, ok := more[i]; ok {
+			collapsed = append(collapsed, CollapsedArgs(n))
+		}
+		if !drop[i] {
+			collapsed = append(collapsed, arg)
+		}
+	}
+	return collapsed
+}
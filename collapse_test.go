@@ -0,0 +1,216 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollapseInLists(t *testing.T) {
+	for query, expected := range map[string]string{
+		"SELECT * FROM t WHERE id IN (?, ?, ?, ?, ?)":            "SELECT * FROM t WHERE id IN (?...)",
+		"SELECT * FROM t WHERE id in(?,?)":                       "SELECT * FROM t WHERE id in(?...)",
+		"SELECT * FROM t WHERE id IN ($1, $2) AND f IN ($3,$4)":  "SELECT * FROM t WHERE id IN (?...) AND f IN (?...)",
+		"SELECT * FROM t WHERE id NOT IN (:a, :b, :c)":           "SELECT * FROM t WHERE id NOT IN (?...)",
+		"SELECT * FROM t WHERE id IN (@p1, @p2)":                 "SELECT * FROM t WHERE id IN (?...)",
+		"SELECT * FROM t WHERE id IN (\n\t?,\n\t?\n)":            "SELECT * FROM t WHERE id IN (?...)",
+		"SELECT * FROM t WHERE (a, b) IN ((?, ?), (?, ?))":       "SELECT * FROM t WHERE (a, b) IN ((?, ?), (?, ?))",
+		"SELECT * FROM t WHERE id IN (?)":                        "SELECT * FROM t WHERE id IN (?)",
+		"SELECT * FROM t WHERE id IN (?, 'a')":                   "SELECT * FROM t WHERE id IN (?, 'a')",
+		"SELECT * FROM t WHERE id IN (?, ?":                      "SELECT * FROM t WHERE id IN (?, ?",
+		"SELECT * FROM t WHERE id IN (?, ?)::int[]":              "SELECT * FROM t WHERE id IN (?...)::int[]",
+		"SELECT * FROM t WHERE id IN (?::int, ?::int)":           "SELECT * FROM t WHERE id IN (?::int, ?::int)",
+		"SELECT * FROM t WHERE id IN (??, ?)":                    "SELECT * FROM t WHERE id IN (??, ?)",
+		"SELECT * FROM t WHERE id IN ($0, $1)":                   "SELECT * FROM t WHERE id IN ($0, $1)",
+		"SELECT * FROM t WHERE id IN (::a, ::b)":                 "SELECT * FROM t WHERE id IN (::a, ::b)",
+		"SELECT * FROM t JOIN u USING (a, b)":                    "SELECT * FROM t JOIN u USING (a, b)",
+		"SELECT * FROM t WHERE pin (?, ?)":                       "SELECT * FROM t WHERE pin (?, ?)",
+		"SELECT * FROM t WHERE inside(?, ?)":                     "SELECT * FROM t WHERE inside(?, ?)",
+		"SELECT * FROM t WHERE a_in (?, ?)":                      "SELECT * FROM t WHERE a_in (?, ?)",
+		"SELECT * FROM t WHERE id IN (?, ?); SELECT 'IN (?, ?)'": "SELECT * FROM t WHERE id IN (?...); SELECT 'IN (?, ?)'",
+	} {
+		collapsed, _ := CollapseInLists(query, nil)
+		assert.Equal(t, expected, collapsed, query)
+	}
+}
+
+func TestCollapseInListsSkipsQuotedAndComments(t *testing.T) {
+	for _, query := range []string{
+		"SELECT 'id IN (?, ?)'",
+		"SELECT 'it''s IN (?, ?)'",
+		`SELECT "IN (?, ?)" FROM t`,
+		`SELECT "a""IN (?, ?)" FROM t`,
+		"SELECT `IN (?, ?)` FROM t",
+		"SELECT 1 -- IN (?, ?)",
+		"SELECT 1 /* IN (?, ?) */",
+		"SELECT 1 /* IN (?, ?)",
+		"SELECT $$IN (?, ?)$$",
+		"SELECT $body$ it's IN (?, ?) $body$",
+		"SELECT 'unterminated IN (?, ?)",
+		`SELECT "unterminated IN (?, ?)`,
+	} {
+		collapsed, _ := CollapseInLists(query, nil)
+		assert.Equal(t, query, collapsed)
+	}
+
+	// lists following strings and comments are still collapsed
+	for query, expected := range map[string]string{
+		"SELECT 'a)' FROM t WHERE id IN (?, ?)":          "SELECT 'a)' FROM t WHERE id IN (?...)",
+		"SELECT 1 -- IN (?, ?)\nWHERE id IN (?, ?)":      "SELECT 1 -- IN (?, ?)\nWHERE id IN (?...)",
+		"SELECT /* ' */ 1 WHERE id IN (?, ?)":            "SELECT /* ' */ 1 WHERE id IN (?...)",
+		"SELECT $$'$$ WHERE id IN ($1, $2)":              "SELECT $$'$$ WHERE id IN (?...)",
+		"SELECT \"in\" FROM t WHERE \"in\" IN (?, ?)":    "SELECT \"in\" FROM t WHERE \"in\" IN (?...)",
+		"SELECT * FROM t WHERE id IN (?, ?) -- trailing": "SELECT * FROM t WHERE id IN (?...) -- trailing",
+	} {
+		collapsed, _ := CollapseInLists(query, nil)
+		assert.Equal(t, expected, collapsed, query)
+	}
+}
+
+func TestCollapseInListsArgs(t *testing.T) {
+	args := func(values ...interface{}) []interface{} { return values }
+
+	for _, test := range []struct {
+		query    string
+		args     []interface{}
+		expected []interface{}
+	}{
+		{
+			query:    "SELECT * FROM t WHERE a = ? AND id IN (?, ?, ?, ?, ?) AND b = ?",
+			args:     args("a", 1, 2, 3, 4, 5, "b"),
+			expected: args("a", 1, 2, 3, CollapsedArgs(2), "b"),
+		},
+		{
+			query:    "SELECT * FROM t WHERE id IN (?, ?, ?)",
+			args:     args(1, 2, 3),
+			expected: args(1, 2, 3),
+		},
+		{
+			// ? in strings aren't placeholders
+			query:    "SELECT '?' FROM t WHERE a = ? AND id IN (?, ?, ?, ?)",
+			args:     args("a", 1, 2, 3, 4),
+			expected: args("a", 1, 2, 3, CollapsedArgs(1)),
+		},
+		{
+			// ? in lists left as they are count
+			query:    "SELECT * FROM t WHERE f(?, ?) AND id IN (?, ?, ?, ?) AND b IN (?, ?, ?, ?, ?)",
+			args:     args("x", "y", 1, 2, 3, 4, 5, 6, 7, 8, 9),
+			expected: args("x", "y", 1, 2, 3, CollapsedArgs(1), 5, 6, 7, CollapsedArgs(2)),
+		},
+		{
+			query:    "SELECT * FROM t WHERE id IN ($2, $3, $4, $5) AND a = $1",
+			args:     args("a", 1, 2, 3, 4),
+			expected: args("a", 1, 2, 3, CollapsedArgs(1)),
+		},
+		{
+			// named arguments can't be told apart
+			query:    "SELECT * FROM t WHERE id IN (:a, :b, :c, :d)",
+			args:     args(1, 2, 3, 4),
+			expected: args(1, 2, 3, 4),
+		},
+		{
+			// missing arguments
+			query:    "SELECT * FROM t WHERE id IN (?, ?, ?, ?)",
+			args:     args(1, 2),
+			expected: args(1, 2),
+		},
+	} {
+		collapsed, collapsedArgs := CollapseInLists(test.query, test.args)
+		assert.Equal(t, test.expected, collapsedArgs, collapsed)
+	}
+
+	assert.Equal(t, "(2 more)", fmt.Sprint(CollapsedArgs(2)))
+}
+
+func TestWithCollapseInLists(t *testing.T) {
+	var queries []string
+	var args [][]interface{}
+	record := func(ctx *Context) error {
+		queries = append(queries, ctx.Query)
+		args = append(args, ctx.Args)
+		return nil
+	}
+	rewrite := func(ctx *Context) error {
+		record(ctx)
+		// the driver runs the original statement anyway
+		ctx.Query = "DROP TABLE t"
+		ctx.Args = nil
+		return nil
+	}
+	hooks := &FuncHooks{
+		Exec:      Funcs{Before: rewrite},
+		Prepare:   Funcs{Before: record},
+		StmtQuery: Funcs{Before: rewrite},
+	}
+
+	driverConn := &recordingConn{}
+	d := NewDriver("", hooks, WithCollapseInLists(true))
+	dc, err := d.wrap(context.Background(), "", driverConn)
+	require.NoError(t, err)
+	c := dc.(*conn)
+
+	list := "DELETE FROM t WHERE id IN (?, ?, ?, ?, ?)"
+	_, err = c.Exec(list, []driver.Value{int64(1), int64(2), int64(3), int64(4), int64(5)})
+	require.NoError(t, err)
+
+	s, err := c.Prepare(list)
+	require.NoError(t, err)
+	_, err = s.Query([]driver.Value{int64(6), int64(7), int64(8), int64(9), int64(10)})
+	require.NoError(t, err)
+
+	collapsed := "DELETE FROM t WHERE id IN (?...)"
+	assert.Equal(t, []string{collapsed, collapsed, collapsed}, queries)
+	assert.Equal(t, [][]interface{}{
+		{int64(1), int64(2), int64(3), CollapsedArgs(2)},
+		nil,
+		{int64(6), int64(7), int64(8), CollapsedArgs(2)},
+	}, args)
+
+	assert.Equal(t, []string{
+		"exec " + list + " [1 2 3 4 5]",
+		"prepare " + list,
+		"query [6 7 8 9 10]",
+	}, driverConn.log)
+}
+
+// recordingConn logs the statements reaching the driver
+type recordingConn struct {
+	anyConn
+	log []string
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	c.log = append(c.log, "prepare "+query)
+	return recordingStmt{c}, nil
+}
+
+func (c *recordingConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	c.log = append(c.log, fmt.Sprintf("exec %s %v", query, args))
+	return driver.RowsAffected(0), nil
+}
+
+func (c *recordingConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	c.log = append(c.log, fmt.Sprintf("query %s %v", query, args))
+	return anyRows{}, nil
+}
+
+type recordingStmt struct {
+	conn *recordingConn
+}
+
+func (recordingStmt) Close() error  { return nil }
+func (recordingStmt) NumInput() int { return -1 }
+
+func (s recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.log = append(s.conn.log, fmt.Sprintf("exec %v", args))
+	return driver.RowsAffected(0), nil
+}
+
+func (s recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.log = append(s.conn.log, fmt.Sprintf("query %v", args))
+	return anyRows{}, nil
+}
@@ -0,0 +1,378 @@
+package sqlhooks
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Compose returns hooks running every given hook, nil ones are ignored.
+//
+// Before hooks run in order and the first error is returned, the remaining
+// Before hooks and the operation are skipped then, while the hooks whose
+// Before already succeeded get their After, with the error in ctx.Error.
+// After hooks run in reverse order, each one gets the operation's error in
+// ctx.Error, and the operation's error is returned whatever they return,
+// unlike a single hook's After. The After hooks returning another error
+// fail, their errors are joined, as errors.Join does, and reported as
+// EventHookErrors. The errors of the After hooks run for a failed Before
+// are reported the same way.
+// Panics aren't recovered: the hooks whose Before already succeeded still
+// get their After before the panic goes on, with the error of a panicking
+// Before in ctx.Error, or with the operation's one for a panicking After.
+// Explainer hooks are asked in order and the first that wants the statement
+// explained gets its plan. Rows are wrapped in reverse order as well.
+func Compose(hooks ...HookType) HookType {
+	var hs composed
+	for _, h := range hooks {
+		switch h := h.(type) {
+		case nil:
+		case composed:
+			hs = append(hs, h...)
+		default:
+			hs = append(hs, h)
+		}
+	}
+
+	switch len(hs) {
+	case 0:
+		return nil
+	case 1:
+		return hs[0]
+	}
+	return hs
+}
+
+type composed []HookType
+
+// implements reports whether any of the hooks implements the interface
+// checked by is, composed implements every hooks interface regardless
+func (hs composed) implements(is func(HookType) bool) bool {
+	for _, h := range hs {
+		if implementsHook(h, is) {
+			return true
+		}
+	}
+	return false
+}
+
+// implementer is implemented by the hooks combining others, as composed,
+// which implement every hooks interface
+type implementer interface {
+	implements(is func(HookType) bool) bool
+}
+
+// implementsHook reports whether hooks implements the interface checked by is,
+// for combined hooks it's true only if one of them does
+func implementsHook(hooks HookType, is func(HookType) bool) bool {
+	if hs, ok := hooks.(implementer); ok {
+		return hs.implements(is)
+	}
+	return is(hooks)
+}
+
+// The operation hooks to run: combined hooks implement every interface, they
+// only get the operations one of the hooks they combine has hooks for
+
+func asBeginner(hooks HookType) (Beginner, bool) {
+	t, ok := hooks.(Beginner)
+	return t, ok && implementsHook(hooks, isBeginner)
+}
+
+func asCommiter(hooks HookType) (Commiter, bool) {
+	t, ok := hooks.(Commiter)
+	return t, ok && implementsHook(hooks, isCommiter)
+}
+
+func asRollbacker(hooks HookType) (Rollbacker, bool) {
+	t, ok := hooks.(Rollbacker)
+	return t, ok && implementsHook(hooks, isRollbacker)
+}
+
+func asStmter(hooks HookType) (Stmter, bool) {
+	t, ok := hooks.(Stmter)
+	return t, ok && implementsHook(hooks, isStmter)
+}
+
+func asQueryer(hooks HookType) (Queryer, bool) {
+	t, ok := hooks.(Queryer)
+	return t, ok && implementsHook(hooks, isQueryer)
+}
+
+func asExecer(hooks HookType) (Execer, bool) {
+	t, ok := hooks.(Execer)
+	return t, ok && implementsHook(hooks, isExecer)
+}
+
+func isBeginner(h HookType) bool   { _, ok := h.(Beginner); return ok }
+func isCommiter(h HookType) bool   { _, ok := h.(Commiter); return ok }
+func isRollbacker(h HookType) bool { _, ok := h.(Rollbacker); return ok }
+func isStmter(h HookType) bool     { _, ok := h.(Stmter); return ok }
+func isQueryer(h HookType) bool    { _, ok := h.(Queryer); return ok }
+func isExecer(h HookType) bool     { _, ok := h.(Execer); return ok }
+
+// hookFuncs returns the Before and After functions h has for an operation, nil if it has none
+type hookFuncs func(h HookType) (before, after func(*Context) error)
+
+// before runs the Before functions in order up to the first failing one,
+// the hooks whose Before succeeded then get their After with its error
+func (hs composed) before(ctx *Context, funcs hookFuncs) error {
+	for i, h := range hs {
+		before, _ := funcs(h)
+		if before == nil {
+			continue
+		}
+		if err := hs[:i].callBefore(ctx, funcs, before); err != nil {
+			hs[:i].runAfter(ctx, funcs, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// callBefore calls before, if it panics the After functions of hs run with
+// a hookPanic error before the panic goes on
+func (hs composed) callBefore(ctx *Context, funcs hookFuncs, before func(*Context) error) error {
+	defer func() {
+		if p := recover(); p != nil {
+			hs.runAfter(ctx, funcs, hookPanic{p})
+			panic(p)
+		}
+	}()
+	return before(ctx)
+}
+
+// after runs the After functions for the operation's error in ctx.Error
+func (hs composed) after(ctx *Context, funcs hookFuncs) error {
+	return hs.runAfter(ctx, funcs, ctx.Error)
+}
+
+// runAfter runs the After functions in reverse order, each getting err in
+// ctx.Error, the errors they return instead are reported as
+// EventHookErrors and err is returned
+func (hs composed) runAfter(ctx *Context, funcs hookFuncs, err error) error {
+	var failed []error
+	for i := len(hs) - 1; i >= 0; i-- {
+		_, after := funcs(hs[i])
+		if after == nil {
+			continue
+		}
+		if hookErr := hs[:i].callAfter(ctx, funcs, after, err); hookErr != nil && !sameError(hookErr, err) {
+			failed = append(failed, hookErr)
+		}
+	}
+	ctx.Error = err
+	ctx.reportHookErrors(failed)
+	return err
+}
+
+// callAfter calls after with err in ctx.Error, if it panics the After
+// functions of hs still run with err before the panic goes on
+func (hs composed) callAfter(ctx *Context, funcs hookFuncs, after func(*Context) error, err error) error {
+	defer func() {
+		if p := recover(); p != nil {
+			hs.runAfter(ctx, funcs, err)
+			panic(p)
+		}
+	}()
+	ctx.Error = err
+	return after(ctx)
+}
+
+// hookPanic is the error the After hooks get in ctx.Error when a Before
+// hook composed with theirs panics
+type hookPanic struct {
+	value interface{}
+}
+
+func (e hookPanic) Error() string {
+	return fmt.Sprintf("sqlhooks: hook panicked: %v", e.value)
+}
+
+// sameError reports whether a and b are the same error, errors that can't
+// be compared with == are compared deeply
+func sameError(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) {
+		return false
+	}
+	if !t.Comparable() {
+		return reflect.DeepEqual(a, b)
+	}
+	return a == b
+}
+
+// hookErrors joins the errors of several After hooks, as errors.Join does
+type hookErrors []error
+
+func (e hookErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e hookErrors) Unwrap() []error {
+	return e
+}
+
+// reportHookErrors reports the errors of failed After hooks, if any
+func (ctx *Context) reportHookErrors(errs []error) {
+	if len(errs) == 0 || ctx.diag == nil {
+		return
+	}
+	ctx.diag.report(EventHookErrors, hookErrors(errs))
+}
+
+func beginFuncs(h HookType) (before, after func(*Context) error) {
+	if t, ok := h.(Beginner); ok {
+		return t.BeforeBegin, t.AfterBegin
+	}
+	return nil, nil
+}
+
+func (hs composed) BeforeBegin(ctx *Context) error { return hs.before(ctx, beginFuncs) }
+func (hs composed) AfterBegin(ctx *Context) error  { return hs.after(ctx, beginFuncs) }
+
+func commitFuncs(h HookType) (before, after func(*Context) error) {
+	if t, ok := h.(Commiter); ok {
+		return t.BeforeCommit, t.AfterCommit
+	}
+	return nil, nil
+}
+
+func (hs composed) BeforeCommit(ctx *Context) error { return hs.before(ctx, commitFuncs) }
+func (hs composed) AfterCommit(ctx *Context) error  { return hs.after(ctx, commitFuncs) }
+
+func rollbackFuncs(h HookType) (before, after func(*Context) error) {
+	if t, ok := h.(Rollbacker); ok {
+		return t.BeforeRollback, t.AfterRollback
+	}
+	return nil, nil
+}
+
+func (hs composed) BeforeRollback(ctx *Context) error { return hs.before(ctx, rollbackFuncs) }
+func (hs composed) AfterRollback(ctx *Context) error  { return hs.after(ctx, rollbackFuncs) }
+
+func prepareFuncs(h HookType) (before, after func(*Context) error) {
+	if t, ok := h.(Stmter); ok {
+		return t.BeforePrepare, t.AfterPrepare
+	}
+	return nil, nil
+}
+
+func (hs composed) BeforePrepare(ctx *Context) error { return hs.before(ctx, prepareFuncs) }
+func (hs composed) AfterPrepare(ctx *Context) error  { return hs.after(ctx, prepareFuncs) }
+
+func stmtQueryFuncs(h HookType) (before, after func(*Context) error) {
+	if t, ok := h.(Stmter); ok {
+		return t.BeforeStmtQuery, t.AfterStmtQuery
+	}
+	return nil, nil
+}
+
+func (hs composed) BeforeStmtQuery(ctx *Context) error { return hs.before(ctx, stmtQueryFuncs) }
+func (hs composed) AfterStmtQuery(ctx *Context) error  { return hs.after(ctx, stmtQueryFuncs) }
+
+func stmtExecFuncs(h HookType) (before, after func(*Context) error) {
+	if t, ok := h.(Stmter); ok {
+		return t.BeforeStmtExec, t.AfterStmtExec
+	}
+	return nil, nil
+}
+
+func (hs composed) BeforeStmtExec(ctx *Context) error { return hs.before(ctx, stmtExecFuncs) }
+func (hs composed) AfterStmtExec(ctx *Context) error  { return hs.after(ctx, stmtExecFuncs) }
+
+func queryFuncs(h HookType) (before, after func(*Context) error) {
+	if t, ok := h.(Queryer); ok {
+		return t.BeforeQuery, t.AfterQuery
+	}
+	return nil, nil
+}
+
+func (hs composed) BeforeQuery(ctx *Context) error { return hs.before(ctx, queryFuncs) }
+func (hs composed) AfterQuery(ctx *Context) error  { return hs.after(ctx, queryFuncs) }
+
+func execFuncs(h HookType) (before, after func(*Context) error) {
+	if t, ok := h.(Execer); ok {
+		return t.BeforeExec, t.AfterExec
+	}
+	return nil, nil
+}
+
+func (hs composed) BeforeExec(ctx *Context) error { return hs.before(ctx, execFuncs) }
+func (hs composed) AfterExec(ctx *Context) error  { return hs.after(ctx, execFuncs) }
+
+func (hs composed) ExplainQuery(ctx *Context) (string, bool) {
+	for _, h := range hs {
+		if t, ok := h.(Explainer); ok {
+			if query, ok := t.ExplainQuery(ctx); ok {
+				ctx.explainer = t
+				return query, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (hs composed) AfterExplain(ctx *Context, plan string, err error) {
+	if ctx.explainer != nil {
+		ctx.explainer.AfterExplain(ctx, plan, err)
+	}
+}
+
+func (hs composed) Savepoint(txID uint64, name string) {
+	for _, h := range hs {
+		if t, ok := h.(Savepointer); ok {
+			t.Savepoint(txID, name)
+		}
+	}
+}
+
+func (hs composed) ReleaseSavepoint(txID uint64, name string) {
+	for _, h := range hs {
+		if t, ok := h.(Savepointer); ok {
+			t.ReleaseSavepoint(txID, name)
+		}
+	}
+}
+
+func (hs composed) RollbackToSavepoint(txID uint64, name string) {
+	for _, h := range hs {
+		if t, ok := h.(Savepointer); ok {
+			t.RollbackToSavepoint(txID, name)
+		}
+	}
+}
+
+func (hs composed) AfterTx(summary TxSummary) {
+	for _, h := range hs {
+		if t, ok := h.(TxSummaryHook); ok {
+			t.AfterTx(summary)
+		}
+	}
+}
+
+func rowsCloseFuncs(h HookType) (before, after func(*Context) error) {
+	if t, ok := h.(RowsCloser); ok {
+		return nil, t.AfterRowsClose
+	}
+	return nil, nil
+}
+
+func (hs composed) AfterRowsClose(ctx *Context) error { return hs.after(ctx, rowsCloseFuncs) }
+
+func (hs composed) WrapRows(ctx *Context, rows driver.Rows) driver.Rows {
+	for i := len(hs) - 1; i >= 0; i-- {
+		if t, ok := hs[i].(RowsWrapper); ok {
+			rows = t.WrapRows(ctx, rows)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,335 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHooks logs the Exec hooks it runs as name.Before and name.After
+func recordingHooks(name string, log *[]string) *HooksMock {
+	return &HooksMock{
+		beforeExec: func(ctx *Context) error {
+			*log = append(*log, name+".Before")
+			return nil
+		},
+		afterExec: func(ctx *Context) error {
+			*log = append(*log, name+".After")
+			return ctx.Error
+		},
+		afterPrepare:  func(ctx *Context) error { return ctx.Error },
+		afterStmtExec: func(ctx *Context) error { return ctx.Error },
+	}
+}
+
+func TestDefaultHooksWrapDriverHooks(t *testing.T) {
+	var log []string
+	SetDefaultHooks(recordingHooks("default", &log))
+	defer SetDefaultHooks(nil)
+
+	db := openDBWithHooks(t, recordingHooks("driver", &log))
+	defer db.Close()
+	_, err := db.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"default.Before", "driver.Before", "driver.After", "default.After"}, log)
+}
+
+func TestDefaultHooksAreSnapshotAtCreation(t *testing.T) {
+	var log []string
+	SetDefaultHooks(recordingHooks("default", &log))
+	d := NewDriver(*driverFlag, recordingHooks("driver", &log))
+	SetDefaultHooks(nil)
+
+	all := func(d *Driver) HookType { return d.hooks.Load().(hooksValue).all }
+	assert.IsType(t, composed{}, all(d))
+	assert.IsType(t, &HooksMock{}, d.Hooks())
+	assert.IsType(t, &HooksMock{}, all(NewDriver(*driverFlag, &HooksMock{})))
+	assert.Nil(t, all(NewDriver(*driverFlag, nil)))
+}
+
+// hookMode is how a matrixHooks Before or After behaves
+type hookMode int
+
+const (
+	succeeds hookMode = iota
+	fails
+	panics
+)
+
+var (
+	errAbort = errors.New("abort")
+	errOp    = errors.New("op failed")
+)
+
+// matrixHooks logs the Exec hooks it runs, along with the error the After
+// gets, they fail with name's error or panic as told by before and after
+func matrixHooks(name string, log *[]string, before, after hookMode) *HooksMock {
+	run := func(mode hookMode, err error) error {
+		switch mode {
+		case fails:
+			return err
+		case panics:
+			panic(name)
+		}
+		return nil
+	}
+	return &HooksMock{
+		beforeExec: func(ctx *Context) error {
+			*log = append(*log, name+".Before")
+			return run(before, errAbort)
+		},
+		afterExec: func(ctx *Context) error {
+			*log = append(*log, fmt.Sprintf("%s.After(%v)", name, ctx.Error))
+			if err := run(after, errors.New(name)); err != nil {
+				return err
+			}
+			return ctx.Error
+		},
+	}
+}
+
+func TestComposeErrorMatrix(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		modes  [3][2]hookMode
+		opErr  error
+		log    []string
+		err    error
+		panics string
+		events []string
+	}{
+		{
+			name: "all succeed",
+			log:  []string{"0.Before", "1.Before", "2.Before", "2.After(<nil>)", "1.After(<nil>)", "0.After(<nil>)"},
+		},
+		{
+			name:  "operation fails",
+			opErr: errOp,
+			log:   []string{"0.Before", "1.Before", "2.Before", "2.After(op failed)", "1.After(op failed)", "0.After(op failed)"},
+			err:   errOp,
+		},
+		{
+			name:  "first Before fails",
+			modes: [3][2]hookMode{{fails, succeeds}},
+			log:   []string{"0.Before"},
+			err:   errAbort,
+		},
+		{
+			name:  "middle Before fails",
+			modes: [3][2]hookMode{{}, {fails, succeeds}},
+			log:   []string{"0.Before", "1.Before", "0.After(abort)"},
+			err:   errAbort,
+		},
+		{
+			name:  "last Before fails",
+			modes: [3][2]hookMode{{}, {}, {fails, succeeds}},
+			log:   []string{"0.Before", "1.Before", "2.Before", "1.After(abort)", "0.After(abort)"},
+			err:   errAbort,
+		},
+		{
+			name:   "After of an aborted operation fails",
+			modes:  [3][2]hookMode{{succeeds, fails}, {}, {fails, succeeds}},
+			log:    []string{"0.Before", "1.Before", "2.Before", "1.After(abort)", "0.After(abort)"},
+			err:    errAbort,
+			events: []string{EventHookErrors + ": 0"},
+		},
+		{
+			name:   "first Before panics",
+			modes:  [3][2]hookMode{{panics, succeeds}},
+			log:    []string{"0.Before"},
+			panics: "0",
+		},
+		{
+			name:   "middle Before panics",
+			modes:  [3][2]hookMode{{}, {panics, succeeds}},
+			log:    []string{"0.Before", "1.Before", "0.After(sqlhooks: hook panicked: 1)"},
+			panics: "1",
+		},
+		{
+			name:   "last Before panics",
+			modes:  [3][2]hookMode{{}, {}, {panics, succeeds}},
+			log:    []string{"0.Before", "1.Before", "2.Before", "1.After(sqlhooks: hook panicked: 2)", "0.After(sqlhooks: hook panicked: 2)"},
+			panics: "2",
+		},
+		{
+			name:   "first After fails",
+			modes:  [3][2]hookMode{{succeeds, fails}},
+			log:    []string{"0.Before", "1.Before", "2.Before", "2.After(<nil>)", "1.After(<nil>)", "0.After(<nil>)"},
+			events: []string{EventHookErrors + ": 0"},
+		},
+		{
+			name:   "middle After fails",
+			modes:  [3][2]hookMode{{}, {succeeds, fails}},
+			opErr:  errOp,
+			log:    []string{"0.Before", "1.Before", "2.Before", "2.After(op failed)", "1.After(op failed)", "0.After(op failed)"},
+			err:    errOp,
+			events: []string{EventHookErrors + ": 1"},
+		},
+		{
+			name:   "last After fails",
+			modes:  [3][2]hookMode{{}, {}, {succeeds, fails}},
+			opErr:  errOp,
+			log:    []string{"0.Before", "1.Before", "2.Before", "2.After(op failed)", "1.After(op failed)", "0.After(op failed)"},
+			err:    errOp,
+			events: []string{EventHookErrors + ": 2"},
+		},
+		{
+			name:   "several After fail",
+			modes:  [3][2]hookMode{{succeeds, fails}, {}, {succeeds, fails}},
+			opErr:  errOp,
+			log:    []string{"0.Before", "1.Before", "2.Before", "2.After(op failed)", "1.After(op failed)", "0.After(op failed)"},
+			err:    errOp,
+			events: []string{EventHookErrors + ": 2\n0"},
+		},
+		{
+			name:   "several After fail for a successful operation",
+			modes:  [3][2]hookMode{{succeeds, fails}, {succeeds, fails}, {succeeds, fails}},
+			log:    []string{"0.Before", "1.Before", "2.Before", "2.After(<nil>)", "1.After(<nil>)", "0.After(<nil>)"},
+			events: []string{EventHookErrors + ": 2\n1\n0"},
+		},
+		{
+			name:   "first After panics",
+			modes:  [3][2]hookMode{{succeeds, panics}},
+			log:    []string{"0.Before", "1.Before", "2.Before", "2.After(<nil>)", "1.After(<nil>)", "0.After(<nil>)"},
+			panics: "0",
+		},
+		{
+			name:   "middle After panics",
+			modes:  [3][2]hookMode{{}, {succeeds, panics}},
+			opErr:  errOp,
+			log:    []string{"0.Before", "1.Before", "2.Before", "2.After(op failed)", "1.After(op failed)", "0.After(op failed)"},
+			panics: "1",
+		},
+		{
+			name:   "last After panics while another fails",
+			modes:  [3][2]hookMode{{succeeds, fails}, {}, {succeeds, panics}},
+			log:    []string{"0.Before", "1.Before", "2.Before", "2.After(<nil>)", "1.After(<nil>)", "0.After(<nil>)"},
+			panics: "2",
+			events: []string{EventHookErrors + ": 0"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var log, events []string
+			var hooks []HookType
+			for i, modes := range c.modes {
+				hooks = append(hooks, matrixHooks(fmt.Sprint(i), &log, modes[0], modes[1]))
+			}
+
+			d := NewDriver("", Compose(hooks...), WithInternalLogger(func(event string, err error) {
+				events = append(events, fmt.Sprintf("%s: %v", event, err))
+			}))
+			dc, err := d.wrap(context.Background(), "", cancelConn{err: c.opErr})
+			require.NoError(t, err)
+
+			exec := func() error {
+				_, err := dc.(*conn).Exec("UPDATE t SET f1 = 1", nil)
+				return err
+			}
+			if c.panics != "" {
+				assert.PanicsWithValue(t, c.panics, func() { exec() })
+			} else {
+				assert.Equal(t, c.err, exec())
+			}
+			assert.Equal(t, c.log, log)
+			assert.Equal(t, c.events, events)
+			assert.EqualValues(t, len(c.events), d.Stats().HookErrors)
+		})
+	}
+}
+
+func TestComposeJoinsHookErrors(t *testing.T) {
+	var joined error
+	diag := &diagnostics{logger: func(event string, err error) { joined = err }}
+
+	first, second := errors.New("first"), errors.New("second")
+	hooks := Compose(
+		NewHooksMock(nil, func(ctx *Context) error { return first }),
+		NewHooksMock(nil, func(ctx *Context) error { return second }),
+	).(Execer)
+
+	ctx := NewContext()
+	ctx.diag = diag
+	assert.NoError(t, hooks.AfterExec(ctx))
+
+	require.IsType(t, hookErrors{}, joined)
+	assert.Equal(t, []error{second, first}, joined.(interface{ Unwrap() []error }).Unwrap())
+	assert.Equal(t, "second\nfirst", joined.Error())
+}
+
+func TestComposeComparesUncomparableErrors(t *testing.T) {
+	opErr := uncomparableError{[]string{"op"}}
+	hooks := Compose(
+		NewHooksMock(nil, func(ctx *Context) error { return ctx.Error }),
+		NewHooksMock(nil, func(ctx *Context) error { return uncomparableError{[]string{"hook"}} }),
+	).(Execer)
+
+	var reported error
+	ctx := NewContext()
+	ctx.diag = &diagnostics{logger: func(event string, err error) { reported = err }}
+	ctx.Error = opErr
+	assert.Equal(t, opErr, hooks.AfterExec(ctx))
+	assert.Equal(t, hookErrors{uncomparableError{[]string{"hook"}}}, reported)
+}
+
+type uncomparableError struct {
+	msgs []string
+}
+
+func (e uncomparableError) Error() string { return strings.Join(e.msgs, ", ") }
+
+func TestComposeFlattens(t *testing.T) {
+	a, b := &HooksMock{}, &HooksMock{}
+
+	assert.Nil(t, Compose())
+	assert.Nil(t, Compose(nil, nil))
+	assert.True(t, Compose(nil, a) == HookType(a))
+	assert.Equal(t, composed{a, b, a}, Compose(Compose(a, b), a))
+
+	assert.False(t, implementsHook(Compose(a, b), isExplainer))
+	assert.True(t, implementsHook(Compose(a, &explainMock{}), isExplainer))
+}
+
+func TestCombinedHooksOnlyGetTheirOperations(t *testing.T) {
+	ctx := context.Background()
+	run := func(hooks HookType, opts ...Option) func() {
+		raw, err := drivertest.Driver{}.Open("context;TestCombinedHooksOnlyGetTheirOperations")
+		require.NoError(t, err)
+		dc, err := NewDriver("", hooks, opts...).wrap(ctx, "", raw)
+		require.NoError(t, err)
+		return func() {
+			if _, err := dc.(driver.ExecerContext).ExecContext(ctx, "INSERT INTO t VALUES (1)", nil); err != nil {
+				t.Fatal(err)
+			}
+			tx, err := dc.(driver.ConnBeginTx).BeginTx(ctx, driver.TxOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	queryOnly := namedHooks{"query"}
+	async := Async(queryOnly, 1, 10)
+	defer async.(Shutdowner).Shutdown(ctx)
+	want := testing.AllocsPerRun(100, run(queryOnly))
+	for name, hooks := range map[string]HookType{
+		"Compose":    Compose(queryOnly, namedHooks{"other"}),
+		"Async":      async,
+		"TailSample": TailSample(queryOnly, 1, 0),
+		"Route":      Route(map[Kind]HookType{KindSelect: queryOnly}, nil),
+	} {
+		assert.Equal(t, want, testing.AllocsPerRun(100, run(hooks)), name)
+	}
+	assert.Equal(t, want, testing.AllocsPerRun(100, run(queryOnly, WithHookTimeout(time.Second))), "WithHookTimeout")
+}
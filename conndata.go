@@ -0,0 +1,55 @@
+package sqlhooks
+
+import "database/sql/driver"
+
+// ConnData holds the values hooks store for a connection, see Context.Conn.
+// It lives as long as the connection and is cleared when it's closed.
+// As database/sql doesn't use a connection concurrently, neither are the
+// hooks running on it, so ConnData isn't synchronized.
+type ConnData struct {
+	values map[string]interface{}
+	schema string
+
+	// raw and dsn are the underlying connection and its sanitized data
+	// source name, for SelfTest
+	raw driver.Conn
+	dsn string
+}
+
+func (d *ConnData) Get(key string) interface{} {
+	return d.values[key]
+}
+
+func (d *ConnData) Set(key string, value interface{}) {
+	if d.values == nil {
+		d.values = make(map[string]interface{})
+	}
+	d.values[key] = value
+}
+
+// copy returns a copy of d, whose values can be changed on their own
+func (d *ConnData) copy() *ConnData {
+	c := *d
+	if d.values != nil {
+		c.values = make(map[string]interface{}, len(d.values))
+		for k, v := range d.values {
+			c.values[k] = v
+		}
+	}
+	return &c
+}
+
+func (d *ConnData) clear() {
+	d.values = nil
+	d.schema = ""
+	d.raw = nil
+}
+
+// Conn returns the data stored for the connection the operation runs on,
+// for a Context not created by the driver it's only shared with itself
+func (ctx *Context) Conn() *ConnData {
+	if ctx.conn == nil {
+		ctx.conn = &ConnData{}
+	}
+	return ctx.conn
+}
@@ -0,0 +1,50 @@
+package sqlhooks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnDataIsPerConnection(t *testing.T) {
+	openDBWithHooks(t, nil).Close()
+	d := NewDriver(*driverFlag, nil)
+
+	c1, err := d.Open(*dsnFlag)
+	require.NoError(t, err)
+	c2, err := d.Open(*dsnFlag)
+	require.NoError(t, err)
+	defer c2.Close()
+
+	c1.(*conn).newContext().Conn().Set("version", 1)
+	assert.Equal(t, 1, c1.(*conn).newContext().Conn().Get("version"))
+	assert.Nil(t, c2.(*conn).newContext().Conn().Get("version"))
+
+	data := c1.(*conn).newContext().Conn()
+	require.NoError(t, c1.Close())
+	assert.Nil(t, data.Get("version"), "connection data is cleared on close")
+}
+
+func TestConnDataIsSharedByConnectionEvents(t *testing.T) {
+	var seen []interface{}
+	db := openDBWithHooks(t, &HooksMock{
+		beforeExec: func(ctx *Context) error {
+			seen = append(seen, ctx.Conn().Get("execs"))
+			n, _ := ctx.Conn().Get("execs").(int)
+			ctx.Conn().Set("execs", n+1)
+			return nil
+		},
+		afterExec: func(ctx *Context) error { return ctx.Error },
+	})
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for i := 0; i < 3; i++ {
+		_, err := db.Exec(queries[*driverFlag].insert, "foo", "bar")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []interface{}{nil, 1, 2}, seen)
+
+	assert.NotNil(t, NewContext().Conn())
+}
@@ -0,0 +1,72 @@
+// +build go1.10
+
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+)
+
+// OpenConnector implements driver.DriverContext, so the connector of an
+// underlying driver implementing it is created once, parsing dsn once,
+// instead of calling its Open for every new connection
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	_driver, err := d.underlying(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, ok := _driver.(driver.DriverContext)
+	if !ok {
+		return dsnConnector{d, dsn}, nil
+	}
+
+	_connector, err := dc.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector{d, dsn, _connector}, nil
+}
+
+type connector struct {
+	d   *Driver
+	dsn string
+	driver.Connector
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	_conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.d.wrap(ctx, c.dsn, _conn)
+}
+
+func (c connector) Driver() driver.Driver {
+	return c.d
+}
+
+// Close closes the underlying connector if it's an io.Closer, which
+// database/sql does on DB.Close as of Go 1.17
+func (c connector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// dsnConnector opens connections with Driver.Open, as database/sql does
+// for drivers not implementing driver.DriverContext
+type dsnConnector struct {
+	d   *Driver
+	dsn string
+}
+
+func (c dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.d.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.d
+}
@@ -0,0 +1,122 @@
+// +build go1.10
+
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectorDriver implements driver.DriverContext, counting dsn parses
+type connectorDriver struct {
+	parses   int32
+	connects int32
+	closes   int32
+}
+
+func (d *connectorDriver) Open(dsn string) (driver.Conn, error) {
+	atomic.AddInt32(&d.parses, 1)
+	atomic.AddInt32(&d.connects, 1)
+	return connectorConn{}, nil
+}
+
+func (d *connectorDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	atomic.AddInt32(&d.parses, 1)
+	return fakeConnector{d}, nil
+}
+
+type fakeConnector struct {
+	d *connectorDriver
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	atomic.AddInt32(&c.d.connects, 1)
+	return connectorConn{}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return c.d
+}
+
+func (c fakeConnector) Close() error {
+	atomic.AddInt32(&c.d.closes, 1)
+	return nil
+}
+
+type connectorConn struct {
+	driver.Conn
+}
+
+func (connectorConn) Close() error { return nil }
+
+func (connectorConn) Begin() (driver.Tx, error) {
+	return nil, errBeginFailed
+}
+
+var cdriver = &connectorDriver{}
+
+func init() {
+	sql.Register("sqlhooks-connector", cdriver)
+
+	interfaceTypes["DriverContext"] = reflect.TypeOf((*driver.DriverContext)(nil)).Elem()
+	interfaceTypes["Connector"] = reflect.TypeOf((*driver.Connector)(nil)).Elem()
+	wrapperTypes["connector"] = []reflect.Type{reflect.TypeOf(connector{}), reflect.TypeOf(dsnConnector{})}
+}
+
+func TestOpenConnectorParsesDSNOnce(t *testing.T) {
+	var begins int
+	hooks := &HooksMock{afterBegin: func(ctx *Context) error {
+		begins++
+		return ctx.Error
+	}}
+
+	db, err := Open("sqlhooks-connector", "dsn", hooks)
+	require.NoError(t, err)
+	defer db.Close()
+	parses := atomic.LoadInt32(&cdriver.parses)
+	connects := atomic.LoadInt32(&cdriver.connects)
+
+	ctx := context.Background()
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		c, err := db.Conn(ctx)
+		require.NoError(t, err)
+		conns = append(conns, c)
+	}
+
+	_, err = conns[0].BeginTx(ctx, nil)
+	assert.Equal(t, errBeginFailed, err)
+	assert.Equal(t, 1, begins)
+
+	for _, c := range conns {
+		require.NoError(t, c.Close())
+	}
+
+	assert.Equal(t, parses, atomic.LoadInt32(&cdriver.parses))
+	assert.Equal(t, connects+3, atomic.LoadInt32(&cdriver.connects))
+}
+
+func TestOpenConnectorFallsBackToOpen(t *testing.T) {
+	db, err := Open(*driverFlag, *dsnFlag, &HooksMock{})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Ping())
+	assert.IsType(t, &Driver{}, db.Driver())
+}
+
+func TestConnectorClose(t *testing.T) {
+	c, err := NewDriver("sqlhooks-connector", &HooksMock{}).OpenConnector("dsn")
+	require.NoError(t, err)
+	closes := atomic.LoadInt32(&cdriver.closes)
+	require.NoError(t, c.(io.Closer).Close())
+	assert.Equal(t, closes+1, atomic.LoadInt32(&cdriver.closes))
+}
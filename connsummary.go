@@ -0,0 +1,126 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+)
+
+// ConnSummaryHook is the interface implemented by objects that wants a
+// single event per connection, when database/sql closes it, as to tune
+// SetConnMaxLifetime and SetMaxIdleConns. AfterConnClose is called once the
+// underlying connection is closed. The connections a process exits with
+// without closing them get none.
+type ConnSummaryHook interface {
+	AfterConnClose(summary ConnSummary)
+}
+
+func isConnSummaryHook(h HookType) bool {
+	_, ok := h.(ConnSummaryHook)
+	return ok
+}
+
+// ConnSummary sums up the life of a connection
+type ConnSummary struct {
+	ConnID uint64
+	Peer   string
+
+	// Age is the time from the opening of the connection to its close
+	Age time.Duration
+
+	// Statements counts the statements run on the connection, with hooks or
+	// not, Transactions the transactions begun on it, with the Begin method
+	// or, with WithTxStatements, with a statement
+	Statements   int
+	Transactions int
+
+	// Broken is set when the connection is closed after the driver reported
+	// it broken, returning driver.ErrBadConn from an operation or from
+	// ResetSession, or false from IsValid, which makes database/sql discard
+	// the connection. Err is the error reported, driver.ErrBadConn for IsValid.
+	Broken bool
+	Err    error
+}
+
+// ConnAgeBuckets are the upper bounds of the Stats.ConnAge buckets, which
+// count closed connections by age. The last bucket counts the connections
+// closed after more than an hour.
+var ConnAgeBuckets = [...]time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, time.Hour}
+
+// ConnStatementsBuckets are the upper bounds of the Stats.ConnStatements
+// buckets, which count closed connections by number of statements run. The
+// last bucket counts the connections which ran more than 10000.
+var ConnStatementsBuckets = [...]int{0, 10, 100, 1000, 10000}
+
+// broke records err if it tells c is broken, the first one is kept
+func (c *conn) broke(err error) {
+	if err == driver.ErrBadConn && c.brokenErr == nil {
+		c.brokenErr = err
+	}
+}
+
+// closed reports the summary of c, closed, to the diagnostics and the
+// ConnSummaryHook hooks
+func (c *conn) closed() {
+	summary := ConnSummary{
+		ConnID:       c.id,
+		Peer:         c.peer,
+		Age:          c.clock.since(c.opened),
+		Statements:   int(c.statements),
+		Transactions: c.transactions,
+		Broken:       c.brokenErr != nil,
+		Err:          c.brokenErr,
+	}
+	c.diag.connClosed(summary)
+
+	hooks := c.callHooks(context.Background())
+	if t, ok := hooks.(ConnSummaryHook); ok && implementsHook(hooks, isConnSummaryHook) {
+		t.AfterConnClose(summary)
+	}
+}
+
+func (d *diagnostics) connClosed(summary ConnSummary) {
+	atomic.AddUint64(&d.connsClosed, 1)
+	if summary.Broken {
+		atomic.AddUint64(&d.connsBroken, 1)
+	}
+
+	i := 0
+	for i < len(ConnAgeBuckets) && summary.Age > ConnAgeBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&d.connAge[i], 1)
+
+	i = 0
+	for i < len(ConnStatementsBuckets) && summary.Statements > ConnStatementsBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&d.connStatements[i], 1)
+}
+
+func (hs composed) AfterConnClose(summary ConnSummary) {
+	for _, h := range hs {
+		if t, ok := h.(ConnSummaryHook); ok {
+			t.AfterConnClose(summary)
+		}
+	}
+}
+
+func (r *router) AfterConnClose(summary ConnSummary) {
+	if t, ok := r.fallback.(ConnSummaryHook); ok {
+		t.AfterConnClose(summary)
+	}
+}
+
+func (s *sampler) AfterConnClose(summary ConnSummary) {
+	if t, ok := s.hooks.(ConnSummaryHook); ok {
+		t.AfterConnClose(summary)
+	}
+}
+
+func (a *async) AfterConnClose(summary ConnSummary) {
+	if t, ok := a.hooks.(ConnSummaryHook); ok {
+		a.push(summary.ConnID, func() { t.AfterConnClose(summary) })
+	}
+}
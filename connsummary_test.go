@@ -0,0 +1,98 @@
+package sqlhooks
+
+import (
+	"database/sql/driver"
+	"sync"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type connSummaryHooks struct {
+	NopHooks
+
+	mu        sync.Mutex
+	summaries []ConnSummary
+}
+
+func (h *connSummaryHooks) AfterConnClose(summary ConnSummary) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.summaries = append(h.summaries, summary)
+}
+
+func TestConnSummary(t *testing.T) {
+	hooks := &connSummaryHooks{}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks)
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	d := db.Driver().(*Driver)
+
+	for i := 0; i < 3; i++ {
+		_, err := db.Exec("INSERT INTO t VALUES (1)")
+		require.NoError(t, err)
+	}
+	rows, err := db.Query("ROWS 2|SELECT n FROM t")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := tx.Exec("UPDATE t SET n = 2")
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	stmt, err := db.Prepare("DELETE FROM t")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := stmt.Exec()
+		require.NoError(t, err)
+	}
+	require.NoError(t, stmt.Close())
+	assert.Empty(t, hooks.summaries)
+
+	require.NoError(t, db.Close())
+	require.Len(t, hooks.summaries, 1)
+	summary := hooks.summaries[0]
+	assert.NotZero(t, summary.ConnID)
+	assert.True(t, summary.Age > 0)
+	summary.ConnID, summary.Age = 0, 0
+	assert.Equal(t, ConnSummary{Statements: 8, Transactions: 1}, summary)
+
+	stats := d.Stats()
+	assert.Equal(t, uint64(1), stats.ConnsClosed)
+	assert.Zero(t, stats.ConnsBroken)
+	assert.Equal(t, [len(ConnAgeBuckets) + 1]uint64{1}, stats.ConnAge)
+	assert.Equal(t, [len(ConnStatementsBuckets) + 1]uint64{0, 1}, stats.ConnStatements)
+}
+
+func TestConnSummaryBroken(t *testing.T) {
+	hooks := &connSummaryHooks{}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks)
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	d := db.Driver().(*Driver)
+
+	// The server kills the connection, database/sql discards it and runs
+	// the next statement on a new one
+	_, err = db.Exec("KILL|SELECT 1")
+	require.NoError(t, err)
+	_, err = db.Exec("SELECT 2")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	require.Len(t, hooks.summaries, 2)
+	assert.True(t, hooks.summaries[0].Broken)
+	assert.Equal(t, driver.ErrBadConn, hooks.summaries[0].Err)
+	assert.Equal(t, 1, hooks.summaries[0].Statements)
+	assert.False(t, hooks.summaries[1].Broken)
+	assert.Equal(t, 1, hooks.summaries[1].Statements)
+
+	stats := d.Stats()
+	assert.Equal(t, uint64(2), stats.ConnsClosed)
+	assert.Equal(t, uint64(1), stats.ConnsBroken)
+}
@@ -1,15 +1,204 @@
 package sqlhooks
 
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"time"
+)
+
 type Context struct {
+	// Ctx is the context.Context the operation was issued with,
+	// context.Background() for operations that don't take one
+	Ctx context.Context
+
 	Error error
+
+	// CtxErr is the error of Ctx, context.Canceled or context.DeadlineExceeded,
+	// when the operation failed once Ctx was done, or failed with a
+	// cancellation or a timeout within a few milliseconds of its deadline,
+	// nil otherwise. It's set along with Error, see ErrorClass.
+	CtxErr error
+
 	Query string
-	Args  []interface{}
 
-	values map[string]interface{}
+	// Args are the arguments of the statement, redacted or hidden as set
+	// with WithArgVisibility
+	Args []interface{}
+
+	// ArgsSize is the approximate encoded size of Args, as computed by
+	// ArgsSize, it's only set for Query, Exec and Stmt hooks when WithArgsSize is enabled
+	ArgsSize int
+
+	// ArgTypes are the Go types of Args before database/sql converted them
+	// to driver values, nil for a nil argument, they're only set for Query,
+	// Exec and Stmt hooks when WithArgTypes is enabled
+	ArgTypes []reflect.Type
+
+	// StatementID uniquely identifies, within the process, the operation, and
+	// Seq orders the operations of a connection. Both are set for every
+	// operation, each execution of a prepared statement gets its own.
+	StatementID uint64
+	Seq         uint64
+
+	// ConnID uniquely identifies, within the process, the connection the operation runs on
+	ConnID uint64
+
+	// Schema is the schema, or search_path, of the connection, see ConnData.Schema
+	Schema string
+
+	// ServerInfo describes the server of the connection, it's only set with WithServerInfo
+	ServerInfo ServerInfo
+
+	// Peer is the server the connection is on, it's only set with WithPeerResolver
+	Peer string
+
+	// DriverName is the name of the underlying driver, as given to NewDriver
+	// or Open, and System the database system it connects to, see System
+	DriverName string
+	System     SystemInfo
+
+	// ConnStatement is the ordinal of the statement among the ones run on its
+	// connection, 1 for the first one once the connection is opened, see
+	// FirstOnConn. It's set for the Query, Exec and Stmt hooks.
+	ConnStatement uint64
+
+	// Attempts is the number of the attempt database/sql makes at the
+	// statement, counting its retries on driver.ErrBadConn, and Retry is set
+	// for the attempts after the first. They're only set for the Query,
+	// Exec and Stmt hooks with WithRetries.
+	Attempts int
+	Retry    bool
+
+	// TxID identifies the transaction the operation belongs to, 0 if it's not within one
+	TxID uint64
+
+	// BatchID identifies the batch the statement belongs to, see
+	// BeginBatch, 0 if it's not within one. It's set for the Query, Exec and
+	// Stmt hooks.
+	BatchID uint64
+
+	// TxOrigin is how the transaction was begun, it's only set for the
+	// Begin, Commit and Rollback hooks
+	TxOrigin TxOrigin
+
+	// TxOptions holds the options a transaction is started with, it's only set for Begin
+	TxOptions driver.TxOptions
+
+	// CausedByContext is set for the Rollback hooks when the context the
+	// transaction began with is done, as for the rollback database/sql
+	// issues by itself once it's canceled
+	CausedByContext bool
+
+	// PoolWait approximates how long database/sql waited for a free
+	// connection before calling Begin, which Duration doesn't include, so
+	// slow transaction starts can be told from slow BEGINs. It's only set
+	// for the Begin hooks with WithPoolStats, see it for the approximation.
+	PoolWait time.Duration
+
+	// Migration is set for the operations of schema migrations, see MarkMigration
+	Migration bool
+
+	// Internal is set for the operations hooks run themselves, see MarkInternal
+	Internal bool
+
+	// Duration is how long the operation took, it's set before calling the
+	// After hooks. Like every duration of the package, it's measured on the
+	// monotonic clock, so a step of the system clock doesn't change it.
+	Duration time.Duration
+
+	// Path is the driver interface the operation was dispatched to, it's
+	// set before calling the After hooks of Begin, Prepare and the statements
+	Path Path
+
+	// Start is the wall clock time the operation started, once the Before
+	// hooks ran, for correlating it with logs. It's set before calling the
+	// After hooks. Durations must not be computed from it once serialized,
+	// as it loses its monotonic reading then, use Duration.
+	Start time.Time
+
+	// Result is the result of the statement, it's set for the After Exec and StmtExec hooks
+	Result driver.Result
+
+	// RowsReturned is how many rows the application read from the rows of a
+	// query, and Abandoned is set when it closed them while rows were left.
+	// NoRows is set when the rows ended without any row: that's a query
+	// which succeeded, Error is nil, even for a QueryRow database/sql fails
+	// with sql.ErrNoRows once it's scanned, see IsNotFound.
+	// They are only set for the RowsCloser hooks. The rows closed before Next
+	// reported their end are abandoned, even the ones closed right after
+	// their last row, as by QueryRow, unless WithAbandonedRowsCheck is set:
+	// it reads one more row from the driver to tell them apart, except once
+	// Ctx is done.
+	RowsReturned int64
+	Abandoned    bool
+	NoRows       bool
+
+	// TimeToFirstByte is how long the driver call of a query took, until it
+	// returned the rows, TimeToFirstRow the time from its start to the first
+	// row read, 0 when none was, and TotalTime the time to the end of the
+	// rows, when Next reported it or failed. Duration also counts the time
+	// from there to the closing of the rows, TotalTime is Duration for the
+	// rows closed before their end. They are only set for the RowsCloser
+	// hooks.
+	TimeToFirstByte time.Duration
+	TimeToFirstRow  time.Duration
+	TotalTime       time.Duration
+
+	// Synthetic is set when a Before hook substituted the result of the
+	// statement, see SubstituteResult, the driver wasn't called then. It's
+	// also set for the transaction events of statements, see WithTxStatements.
+	Synthetic bool
+
+	// HookDuration is how long the Before hooks took, it's set before calling
+	// the After hooks when WithSelfTiming is enabled
+	HookDuration time.Duration
+
+	// StmtExecutions is how many times the prepared statement has been executed,
+	// including this one, and StmtAge how long ago it was prepared.
+	// They are only set for the StmtQuery and StmtExec hooks.
+	StmtExecutions uint64
+	StmtAge        time.Duration
+
+	// NumInput is the number of arguments the driver reports the prepared
+	// statement expects, as driver.Stmt.NumInput, -1 when it doesn't know.
+	// It's set for the After Prepare hooks and the StmtQuery and StmtExec hooks.
+	NumInput int
+
+	// StmtCache holds the connection's statement cache counters, it's only set when WithStmtCache is enabled
+	StmtCache StmtCacheStats
+
+	// hasNumInput is set along with NumInput
+	hasNumInput bool
+
+	// substitute is what SubstituteResult or SubstituteRows set
+	substitute *substitute
+
+	values         map[string]interface{}
+	conn           *ConnData
+	traceExtractor TraceExtractor
+
+	// diag gets the errors of composed hooks, see Compose
+	diag *diagnostics
+
+	// rows are the rows returned by the query, columns are read from them on demand
+	rows    driver.Rows
+	columns []string
+
+	// explainer is the composed hook that asked to explain the statement
+	explainer Explainer
+
+	classification classification
 }
 
 func NewContext() *Context {
-	return &Context{}
+	return &Context{Ctx: context.Background()}
+}
+
+// FirstOnConn reports whether the statement is the first one run on its
+// connection, which is usually slower as the connection is still cold
+func (ctx *Context) FirstOnConn() bool {
+	return ctx.ConnStatement == 1
 }
 
 func (ctx *Context) Get(key string) interface{} {
@@ -31,3 +220,19 @@ func (ctx *Context) Set(key string, value interface{}) {
 
 	ctx.values[key] = value
 }
+
+// Columns returns the names of the columns returned by a query, it's
+// available to the After Query and StmtQuery hooks and to RowsCloser hooks.
+// They are only read from the driver the first time they're requested.
+func (ctx *Context) Columns() []string {
+	if ctx.columns == nil && ctx.rows != nil {
+		ctx.columns = ctx.rows.Columns()
+	}
+	return ctx.columns
+}
+
+// setRows sets the rows Columns reads from
+func (ctx *Context) setRows(rows driver.Rows) {
+	ctx.rows = rows
+	ctx.columns = nil
+}
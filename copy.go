@@ -0,0 +1,225 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"time"
+)
+
+// CopyHook is the interface implemented by objects that wants to hook to the
+// COPY ... FROM STDIN statements, as lib/pq's pq.CopyIn prepares: every
+// row is sent with an Exec of the statement, as many as there are rows,
+// then an Exec without arguments flushes them. CopyBegin is called once
+// the statement is prepared, with the Context of the prepare, and CopyDone
+// once the rows are flushed, or the statement closed without it.
+//
+// The Exec of the rows run no hook, they're only counted in CopySummary.Rows,
+// unless WithCopyRowHooks is set. The flushing Exec runs the Stmter hooks as
+// any statement does.
+type CopyHook interface {
+	CopyBegin(ctx *Context)
+	CopyDone(summary CopySummary)
+}
+
+func isCopyHook(h HookType) bool {
+	_, ok := h.(CopyHook)
+	return ok
+}
+
+// CopySummary sums up a COPY FROM STDIN statement
+type CopySummary struct {
+	// Ctx is the context.Context the statement was prepared with
+	Ctx    context.Context
+	ConnID uint64
+	Peer   string
+	TxID   uint64
+	Query  string
+	Table  string
+
+	// Rows counts the rows the driver accepted, Duration is the time from
+	// the prepare to the end of the flush, or of the close
+	Rows     int64
+	Duration time.Duration
+
+	// Err is the first error of the rows, or the one of the flush or close
+	Err error
+}
+
+// WithCopyRowHooks sets whether the Exec of every row of a COPY FROM STDIN
+// statement runs the Stmter hooks, see CopyHook. Disabled by default, the
+// rows are only counted.
+func WithCopyRowHooks(enabled bool) Option {
+	return func(d *Driver) {
+		d.copyRowHooks = enabled
+	}
+}
+
+// isCopyFromStdin reports whether query is a COPY ... FROM STDIN statement
+func isCopyFromStdin(query string) bool {
+	// Most queries are ruled out without tokenizing them
+	if !containsFold(query, "stdin") {
+		return false
+	}
+	tokens := classifyTokens(query)
+	if len(tokens) == 0 || !strings.EqualFold(tokens[0].text, "copy") {
+		return false
+	}
+	for i := 1; i < len(tokens)-1; i++ {
+		if !tokens[i].quoted && strings.EqualFold(tokens[i].text, "from") &&
+			!tokens[i+1].quoted && strings.EqualFold(tokens[i+1].text, "stdin") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether s contains the lower case substr, case insensitively
+func containsFold(s, substr string) bool {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		j := 0
+		for j < len(substr) && (s[i+j] == substr[j] || s[i+j]+'a'-'A' == substr[j]) {
+			j++
+		}
+		if j == len(substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyIn is the COPY FROM STDIN of a prepared statement
+type copyIn struct {
+	hook    CopyHook
+	summary CopySummary
+	clock   clock
+	start   instant
+	done    bool
+}
+
+// startCopy returns the copyIn of a statement prepared with stdCtx at start,
+// nil if query isn't a COPY FROM STDIN, and calls the CopyBegin hooks
+func (c *conn) startCopy(hooks HookType, stdCtx context.Context, query string, start instant) *copyIn {
+	if !isCopyFromStdin(query) {
+		return nil
+	}
+	_, table := Classify(query)
+	ci := &copyIn{
+		summary: CopySummary{Ctx: stdCtx, ConnID: c.id, Peer: c.peer, TxID: c.txID, Query: query, Table: table},
+		clock:   c.clock,
+		start:   start,
+	}
+	if t, ok := hooks.(CopyHook); ok && implementsHook(hooks, isCopyHook) {
+		ci.hook = t
+		ctx := c.newContext()
+		ctx.Ctx = stdCtx
+		ctx.Query = query
+		ctx.TxID = c.txID
+		ctx.Migration = c.migration(ctx)
+		ctx.Internal = IsInternal(ctx.Ctx)
+		ctx.Start = start.wall
+		t.CopyBegin(ctx)
+	}
+	return ci
+}
+
+// copyRow sends a row of the COPY FROM STDIN of s to the driver, without hooks
+func (s *stmt) copyRow(stdCtx context.Context, args []driver.Value) (driver.Result, error) {
+	s.usage.executed(s.conn.diag, nil)
+	res, path, err := s.driverExec(stdCtx, args)
+	s.conn.diag.dispatched(path)
+	s.conn.broke(err)
+	s.copy.exec(len(args), err)
+	return res, err
+}
+
+// exec accounts for an Exec of the statement with n arguments: a row, or
+// the flush of the rows without any, ci can be nil
+func (ci *copyIn) exec(n int, err error) {
+	if ci == nil {
+		return
+	}
+	if n == 0 {
+		ci.end(err)
+		return
+	}
+	if err == nil {
+		ci.summary.Rows++
+	} else if ci.summary.Err == nil {
+		ci.summary.Err = err
+	}
+}
+
+// end calls the CopyDone hook once, err is the one of the flush or the
+// close, ci can be nil
+func (ci *copyIn) end(err error) {
+	if ci == nil || ci.done {
+		return
+	}
+	ci.done = true
+	ci.summary.Duration = ci.clock.since(ci.start)
+	if ci.summary.Err == nil {
+		ci.summary.Err = err
+	}
+	if ci.hook != nil {
+		ci.hook.CopyDone(ci.summary)
+	}
+}
+
+func (hs composed) CopyBegin(ctx *Context) {
+	for _, h := range hs {
+		if t, ok := h.(CopyHook); ok {
+			t.CopyBegin(ctx)
+		}
+	}
+}
+
+func (hs composed) CopyDone(summary CopySummary) {
+	for _, h := range hs {
+		if t, ok := h.(CopyHook); ok {
+			t.CopyDone(summary)
+		}
+	}
+}
+
+func (r *router) CopyBegin(ctx *Context) {
+	if t, ok := r.hooks(ctx.Kind()).(CopyHook); ok {
+		t.CopyBegin(ctx)
+	}
+}
+
+func (r *router) CopyDone(summary CopySummary) {
+	operation, _ := Classify(summary.Query)
+	if t, ok := r.hooks(kinds[operation]).(CopyHook); ok {
+		t.CopyDone(summary)
+	}
+}
+
+func (s *sampler) CopyBegin(ctx *Context) {
+	if t, ok := s.hooks.(CopyHook); ok {
+		t.CopyBegin(ctx)
+	}
+}
+
+func (s *sampler) CopyDone(summary CopySummary) {
+	if t, ok := s.hooks.(CopyHook); ok {
+		t.CopyDone(summary)
+	}
+}
+
+func (a *async) CopyBegin(ctx *Context) {
+	if t, ok := a.hooks.(CopyHook); ok {
+		c := ctx.snapshot()
+		a.push(c.key(), func() { t.CopyBegin(c) })
+	}
+}
+
+func (a *async) CopyDone(summary CopySummary) {
+	if t, ok := a.hooks.(CopyHook); ok {
+		key := summary.TxID
+		if key == 0 {
+			key = summary.ConnID
+		}
+		a.push(key, func() { t.CopyDone(summary) })
+	}
+}
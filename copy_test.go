@@ -0,0 +1,122 @@
+package sqlhooks
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copyQuery is the statement pq.CopyIn("t", "a", "b") prepares
+const copyQuery = `COPY "t" ("a", "b") FROM STDIN`
+
+type copyHooks struct {
+	NopHooks
+
+	begins []*Context
+	done   []CopySummary
+	execs  [][]interface{}
+}
+
+func (h *copyHooks) CopyBegin(ctx *Context) { h.begins = append(h.begins, ctx) }
+
+func (h *copyHooks) CopyDone(summary CopySummary) { h.done = append(h.done, summary) }
+
+func (h *copyHooks) AfterStmtExec(ctx *Context) error {
+	h.execs = append(h.execs, ctx.Args)
+	return ctx.Error
+}
+
+// runCopyIn copies rows with db as pq.CopyIn does, within a transaction, and
+// flushes them unless flush is false
+func runCopyIn(t *testing.T, db *sql.DB, rows int, flush bool) {
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	stmt, err := tx.Prepare(copyQuery)
+	require.NoError(t, err)
+	for i := 0; i < rows; i++ {
+		_, err := stmt.Exec(i, "b")
+		require.NoError(t, err)
+	}
+	if flush {
+		_, err = stmt.Exec()
+		require.NoError(t, err)
+	}
+	require.NoError(t, stmt.Close())
+	require.NoError(t, tx.Commit())
+}
+
+func TestCopyIn(t *testing.T) {
+	hooks := &copyHooks{}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks)
+	require.NoError(t, err)
+	defer db.Close()
+
+	runCopyIn(t, db, 3, true)
+	require.Len(t, hooks.begins, 1)
+	assert.Equal(t, copyQuery, hooks.begins[0].Query)
+	assert.NotZero(t, hooks.begins[0].TxID)
+
+	// Only the flush runs the hooks
+	assert.Equal(t, [][]interface{}{{}}, hooks.execs)
+
+	require.Len(t, hooks.done, 1)
+	done := hooks.done[0]
+	assert.Equal(t, hooks.begins[0].TxID, done.TxID)
+	assert.Equal(t, hooks.begins[0].ConnID, done.ConnID)
+	assert.Equal(t, "t", done.Table)
+	assert.Equal(t, int64(3), done.Rows)
+	assert.True(t, done.Duration > 0)
+	assert.NoError(t, done.Err)
+}
+
+func TestCopyInRowHooks(t *testing.T) {
+	hooks := &copyHooks{}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks, WithCopyRowHooks(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	runCopyIn(t, db, 2, true)
+	assert.Equal(t, [][]interface{}{{int64(0), "b"}, {int64(1), "b"}, {}}, hooks.execs)
+	require.Len(t, hooks.done, 1)
+	assert.Equal(t, int64(2), hooks.done[0].Rows)
+}
+
+func TestCopyInClose(t *testing.T) {
+	hooks := &copyHooks{}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks)
+	require.NoError(t, err)
+	defer db.Close()
+
+	runCopyIn(t, db, 2, false)
+	assert.Empty(t, hooks.execs)
+	require.Len(t, hooks.done, 1)
+	assert.Equal(t, int64(2), hooks.done[0].Rows)
+
+	// Statements other than COPY FROM STDIN aren't collapsed
+	stmt, err := db.Prepare("COPY t TO STDOUT")
+	require.NoError(t, err)
+	_, err = stmt.Exec(1)
+	require.NoError(t, err)
+	require.NoError(t, stmt.Close())
+	assert.Len(t, hooks.begins, 1)
+	assert.Len(t, hooks.done, 1)
+	assert.Len(t, hooks.execs, 1)
+}
+
+func TestIsCopyFromStdin(t *testing.T) {
+	for query, want := range map[string]bool{
+		copyQuery:                               true,
+		"copy public.t from stdin":              true,
+		`COPY "t" FROM STDIN WITH (FORMAT csv)`: true,
+		"COPY t TO STDOUT":                      false,
+		`COPY t FROM '/tmp/t.csv'`:              false,
+		`COPY t FROM "stdin"`:                   false,
+		"SELECT 'COPY t FROM STDIN'":            false,
+		"":                                      false,
+	} {
+		assert.Equal(t, want, isCopyFromStdin(query), query)
+	}
+}
@@ -0,0 +1,300 @@
+package sqlhooks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Namer is the interface implemented by hooks giving their name to Describe
+// and DryDispatch, hooks are named after their type otherwise
+type Namer interface {
+	Name() string
+}
+
+// HookDescription describes a hook of a Driver, as returned by Describe
+type HookDescription struct {
+	// Name is the Name of a Namer, Compose, Route, TailSample and Async for
+	// the hooks they return and the type of the hook otherwise, as
+	// *sqlhooks.FuncHooks
+	Name string `json:"name"`
+
+	// Source is where the hook comes from, set on the top level
+	// descriptions only: "default" for the SetDefaultHooks hooks, "driver"
+	// for the ones given to NewDriver or SetHooks and "context" for the ones
+	// given to WithExtraHooks
+	Source string `json:"source,omitempty"`
+
+	// Route is the kind of statement a hook is routed to by Route,
+	// "fallback" for the fallback hooks
+	Route string `json:"route,omitempty"`
+
+	// Conditions restrict when the hook runs, as "min duration 5ms" for
+	// MinDuration, "rate 0.1" for TailSample or "operations Query|Exec" for
+	// WithOperations
+	Conditions []string `json:"conditions,omitempty"`
+
+	// Interfaces are the hook interfaces it implements, as Queryer, only
+	// counting the operations whose functions are set for FuncHooks
+	Interfaces []string `json:"interfaces,omitempty"`
+
+	// Hooks are the hooks composed by Compose, routed by Route, sampled by
+	// TailSample or run by Async
+	Hooks []HookDescription `json:"hooks,omitempty"`
+}
+
+func (h HookDescription) String() string {
+	if len(h.Conditions) == 0 {
+		return h.Name
+	}
+	return fmt.Sprintf("%s (%s)", h.Name, strings.Join(h.Conditions, ", "))
+}
+
+// hookInterfaces are the hook interfaces, as listed by HookType, with the
+// check of each
+var hookInterfaces = []struct {
+	name string
+	is   func(HookType) bool
+}{
+	{"Beginner", isBeginner},
+	{"Commiter", isCommiter},
+	{"Rollbacker", isRollbacker},
+	{"Stmter", isStmter},
+	{"Queryer", isQueryer},
+	{"Execer", isExecer},
+	{"Explainer", func(h HookType) bool { _, ok := h.(Explainer); return ok }},
+	{"Savepointer", isSavepointer},
+	{"RowsCloser", func(h HookType) bool { _, ok := h.(RowsCloser); return ok }},
+	{"RowsWrapper", func(h HookType) bool { _, ok := h.(RowsWrapper); return ok }},
+	{"TxSummaryHook", isTxSummaryHook},
+	{"BatchSummaryHook", isBatchSummaryHook},
+	{"ConnSummaryHook", isConnSummaryHook},
+	{"CopyHook", isCopyHook},
+	{"ResultErrorHook", isResultErrorHook},
+	{"Shutdowner", func(h HookType) bool { _, ok := h.(Shutdowner); return ok }},
+	{"StillRunner", isStillRunner},
+}
+
+// implemented returns the names of the hook interfaces h implements
+func implemented(h HookType) []string {
+	var names []string
+	for _, i := range hookInterfaces {
+		if i.is(h) && funcsSet(h, i.name) {
+			names = append(names, i.name)
+		}
+	}
+	return names
+}
+
+// funcsSet reports whether FuncHooks have functions for the operations of
+// the hook interface named name, true for any other hook
+func funcsSet(h HookType, name string) bool {
+	f, ok := h.(*FuncHooks)
+	if !ok {
+		return true
+	}
+
+	set := func(funcs ...Funcs) bool {
+		for _, fn := range funcs {
+			if fn.Before != nil || fn.After != nil {
+				return true
+			}
+		}
+		return false
+	}
+	switch name {
+	case "Beginner":
+		return set(f.Begin)
+	case "Commiter":
+		return set(f.Commit)
+	case "Rollbacker":
+		return set(f.Rollback)
+	case "Stmter":
+		return set(f.Prepare, f.StmtQuery, f.StmtExec)
+	case "Queryer":
+		return set(f.Query)
+	case "Execer":
+		return set(f.Exec)
+	}
+	return true
+}
+
+func hookName(h HookType) string {
+	switch t := h.(type) {
+	case Namer:
+		return t.Name()
+	case composed:
+		return "Compose"
+	case *router:
+		return "Route"
+	case *sampler:
+		return "TailSample"
+	case *async:
+		return "Async"
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+// describe returns the description of h, nil ones included
+func describe(h HookType) HookDescription {
+	desc := HookDescription{Name: hookName(h)}
+	switch t := h.(type) {
+	case composed:
+		for _, h := range t {
+			desc.Hooks = append(desc.Hooks, describe(h))
+		}
+		return desc
+	case *router:
+		kinds := make([]int, 0, len(t.routes))
+		for kind := range t.routes {
+			kinds = append(kinds, int(kind))
+		}
+		sort.Ints(kinds)
+		for _, kind := range kinds {
+			routed := describe(t.routes[Kind(kind)])
+			routed.Route = Kind(kind).String()
+			desc.Hooks = append(desc.Hooks, routed)
+		}
+		fallback := describe(t.fallback)
+		fallback.Route = "fallback"
+		desc.Hooks = append(desc.Hooks, fallback)
+		return desc
+	case *sampler:
+		desc.Conditions = t.conditions()
+		desc.Hooks = []HookDescription{describe(t.hooks)}
+		return desc
+	case *async:
+		desc.Conditions = t.conditions()
+		desc.Hooks = []HookDescription{describe(t.hooks)}
+		return desc
+	case *FuncHooks:
+		if t != nil && t.minDuration > 0 {
+			desc.Conditions = []string{fmt.Sprintf("min duration %s", t.minDuration)}
+		}
+	}
+	desc.Interfaces = implemented(h)
+	return desc
+}
+
+// Describe returns the description of the hooks of d, the default ones
+// followed by its own, none once it's shut down
+func (d *Driver) Describe() []HookDescription {
+	return d.DescribeContext(context.Background())
+}
+
+// DescribeContext is Describe, followed by the hooks ctx carries
+func (d *Driver) DescribeContext(ctx context.Context) []HookDescription {
+	v := d.hooks.Load().(hooksValue)
+	if v.shutdown {
+		return nil
+	}
+
+	var descs []HookDescription
+	add := func(source string, h HookType) {
+		if h == nil {
+			return
+		}
+		desc := describe(h)
+		desc.Source = source
+		if d.ops != OpAll {
+			desc.Conditions = append(desc.Conditions, "operations "+d.ops.String())
+		}
+		descs = append(descs, desc)
+	}
+	add("default", d.defaults)
+	add("driver", v.hooks)
+	if extra := extraHooksFrom(ctx); extra != nil {
+		add("context", extra.hooks)
+	}
+	return descs
+}
+
+// dispatchInterfaces are the hook interfaces run by the statement
+// operations, and the FuncHooks functions they run
+var dispatchInterfaces = []struct {
+	op         Op
+	interfaces []string
+	funcs      func(*FuncHooks) []Funcs
+}{
+	{OpPrepare, []string{"Stmter"}, func(f *FuncHooks) []Funcs { return []Funcs{f.Prepare} }},
+	{OpQuery, []string{"Queryer", "Stmter", "RowsCloser", "RowsWrapper"}, func(f *FuncHooks) []Funcs { return []Funcs{f.Query, f.StmtQuery} }},
+	{OpExec, []string{"Execer", "Stmter"}, func(f *FuncHooks) []Funcs { return []Funcs{f.Exec, f.StmtExec} }},
+}
+
+// DryDispatch reports the hooks that would run for query, executed with
+// ctx, without running anything. For each of OpPrepare, OpQuery and OpExec
+// not disabled by WithOperations, it returns the hooks implementing the
+// interfaces of the operation, as HookDescription.String does, in the order
+// they run. Routed hooks are resolved by the kind of query.
+// It's meant for tests asserting how instrumentation is wired.
+func (d *Driver) DryDispatch(ctx context.Context, query string) map[Op][]string {
+	dispatched := make(map[Op][]string)
+	v := d.hooks.Load().(hooksValue)
+	if v.shutdown {
+		return dispatched
+	}
+
+	hooks := v.all
+	if extra := extraHooksFrom(ctx); extra != nil {
+		hooks = Compose(hooks, extra.hooks)
+	}
+	kind := (&Context{Query: query}).Kind()
+	savepoint, _ := parseSavepoint(query)
+
+	for _, op := range dispatchInterfaces {
+		if d.ops&op.op == 0 || d.skipSavepointExec && savepoint != notSavepoint && op.op == OpExec {
+			continue
+		}
+		var names []string
+		walkHooks(hooks, kind, func(h HookType) {
+			if dispatches(h, op.interfaces, op.funcs) {
+				names = append(names, describe(h).String())
+			}
+		})
+		dispatched[op.op] = names
+	}
+	return dispatched
+}
+
+// walkHooks calls fn with the hooks composed by h, and the ones it routes for kind
+func walkHooks(h HookType, kind Kind, fn func(HookType)) {
+	switch t := h.(type) {
+	case nil:
+	case composed:
+		for _, h := range t {
+			walkHooks(h, kind, fn)
+		}
+	case *router:
+		walkHooks(t.hooks(kind), kind, fn)
+	case *sampler:
+		walkHooks(t.hooks, kind, fn)
+	case *async:
+		walkHooks(t.hooks, kind, fn)
+	default:
+		fn(h)
+	}
+}
+
+// dispatches reports whether h implements one of interfaces, for FuncHooks
+// whether any of funcs is set
+func dispatches(h HookType, interfaces []string, funcs func(*FuncHooks) []Funcs) bool {
+	if f, ok := h.(*FuncHooks); ok {
+		for _, fn := range funcs(f) {
+			if fn.Before != nil || fn.After != nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	implements := implemented(h)
+	for _, name := range interfaces {
+		for _, i := range implements {
+			if i == name {
+				return true
+			}
+		}
+	}
+	return false
+}
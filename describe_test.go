@@ -0,0 +1,83 @@
+package sqlhooks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedHooks are query hooks with a name
+type namedHooks struct {
+	name string
+}
+
+func (h namedHooks) Name() string                   { return h.name }
+func (h namedHooks) BeforeQuery(ctx *Context) error { return nil }
+func (h namedHooks) AfterQuery(ctx *Context) error  { return ctx.Error }
+
+func TestDescribe(t *testing.T) {
+	slow, err := MinDuration(5*time.Millisecond, &FuncHooks{Exec: Funcs{After: func(ctx *Context) error { return ctx.Error }}})
+	require.NoError(t, err)
+
+	SetDefaultHooks(namedHooks{"default"})
+	defer SetDefaultHooks(nil)
+
+	d := NewDriver("", Compose(
+		slow,
+		Route(map[Kind]HookType{KindSelect: namedHooks{"selects"}, KindDDL: nil}, namedHooks{"others"}),
+	), WithOperations(OpQuery|OpExec))
+	ctx := WithExtraHooks(context.Background(), namedHooks{"request"})
+
+	operations := []string{"operations Query|Exec"}
+	assert.Equal(t, []HookDescription{
+		{Name: "default", Source: "default", Conditions: operations, Interfaces: []string{"Queryer"}},
+		{Name: "Compose", Source: "driver", Conditions: operations, Hooks: []HookDescription{
+			{Name: "*sqlhooks.FuncHooks", Conditions: []string{"min duration 5ms"}, Interfaces: []string{"Execer"}},
+			{Name: "Route", Hooks: []HookDescription{
+				{Name: "selects", Route: "select", Interfaces: []string{"Queryer"}},
+				{Name: "<nil>", Route: "ddl"},
+				{Name: "others", Route: "fallback", Interfaces: []string{"Queryer"}},
+			}},
+		}},
+		{Name: "request", Source: "context", Conditions: operations, Interfaces: []string{"Queryer"}},
+	}, d.DescribeContext(ctx))
+	assert.Len(t, d.Describe(), 2)
+
+	require.NoError(t, d.Shutdown(context.Background()))
+	assert.Empty(t, d.Describe())
+}
+
+func TestDryDispatch(t *testing.T) {
+	slow, err := MinDuration(5*time.Millisecond, &FuncHooks{Exec: Funcs{After: func(ctx *Context) error { return ctx.Error }}})
+	require.NoError(t, err)
+	d := NewDriver("", Compose(
+		slow,
+		Route(map[Kind]HookType{KindSelect: namedHooks{"selects"}, KindDDL: nil}, namedHooks{"others"}),
+		&FuncHooks{StmtQuery: Funcs{Before: func(ctx *Context) error { return nil }}},
+	), WithSavepointExecHooks(false))
+	ctx := WithExtraHooks(context.Background(), namedHooks{"request"})
+
+	assert.Equal(t, map[Op][]string{
+		OpPrepare: nil,
+		OpQuery:   {"selects", "*sqlhooks.FuncHooks", "request"},
+		OpExec:    {"*sqlhooks.FuncHooks (min duration 5ms)"},
+	}, d.DryDispatch(ctx, "SELECT * FROM t"))
+
+	dispatched := d.DryDispatch(context.Background(), "CREATE TABLE t (id INT)")
+	assert.Equal(t, []string{"*sqlhooks.FuncHooks"}, dispatched[OpQuery])
+
+	dispatched = d.DryDispatch(context.Background(), "SAVEPOINT a")
+	assert.NotContains(t, dispatched, OpExec)
+
+	d = NewDriver("", namedHooks{"queries"}, WithOperations(OpExec))
+	assert.Equal(t, map[Op][]string{OpExec: nil}, d.DryDispatch(context.Background(), "SELECT 1"))
+}
+
+func TestOpString(t *testing.T) {
+	assert.Equal(t, "Begin|Commit|Rollback|Prepare|Query|Exec", OpAll.String())
+	assert.Equal(t, "Exec", OpExec.String())
+	assert.Equal(t, "none", Op(0).String())
+}
@@ -0,0 +1,257 @@
+package sqlhooks
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/internal/queue"
+)
+
+// Internal events reported to the WithInternalLogger function
+const (
+	// EventSwallowedError is reported when an After hook returns nil for an
+	// operation that failed without a result, such as a Prepare returning no statement
+	EventSwallowedError = "swallowed_error"
+
+	// EventHookErrors is reported when composed After hooks fail, the error
+	// joins theirs, see Compose
+	EventHookErrors = "hook_errors"
+
+	// EventArgCountMismatch is reported, when WithArgCountWarning is set, for
+	// statements whose arguments don't match their placeholders
+	EventArgCountMismatch = "arg_count_mismatch"
+
+	// EventOpenAtShutdown is reported by Driver.Shutdown when transactions or
+	// statements are still open
+	EventOpenAtShutdown = "open_at_shutdown"
+
+	// EventStmtCachePrepare is reported when the statement cache fails to prepare a query
+	EventStmtCachePrepare = "stmt_cache_prepare"
+
+	// EventStmtCacheClose is reported when closing an evicted cached statement fails
+	EventStmtCacheClose = "stmt_cache_close"
+
+	// EventHookTimeout is reported, when WithHookTimeout is set, for the hooks
+	// abandoned for not returning in time
+	EventHookTimeout = "hook_timeout"
+
+	// EventDropped is reported by the hooks handling their events in the
+	// background, as Async, for the events they drop
+	EventDropped = queue.EventDropped
+
+	// EventHookPanic is reported by the hooks handling their events in the
+	// background, as Async, when a hook panics: the panic is recovered
+	EventHookPanic = queue.EventHookPanic
+)
+
+// InternalReporter is the interface implemented by hooks reporting internal
+// events of their own, as EventDropped. SetHooks gives them the function
+// reporting to the Driver: the events are counted in its Stats and passed
+// to the WithInternalLogger function. Hooks set on several Drivers report to
+// the last one.
+type InternalReporter interface {
+	SetInternalReporter(report func(event string, err error))
+}
+
+// Stats holds the counters of a Driver
+type Stats struct {
+	// Internal events
+	SwallowedErrors    uint64
+	HookErrors         uint64
+	ArgCountMismatches uint64
+	StmtCachePrepares  uint64
+	StmtCacheCloses    uint64
+	HookTimeouts       uint64
+	EventsDropped      uint64
+	HookPanics         uint64
+
+	// Prepared statements usage, StmtReuse is indexed as StmtReuseBuckets
+	StmtsPrepared  uint64
+	StmtExecutions uint64
+	StmtReuse      [len(StmtReuseBuckets) + 1]uint64
+
+	// Paths counts the operations dispatched to each driver interface,
+	// indexed by Path
+	Paths [pathCount]uint64
+
+	// Transactions and prepared statements not ended or closed yet
+	OpenTxs   int64
+	OpenStmts int64
+
+	// Closed connections, the ones closed broken, see ConnSummary, ConnAge
+	// indexed as ConnAgeBuckets and ConnStatements as ConnStatementsBuckets
+	ConnsClosed    uint64
+	ConnsBroken    uint64
+	ConnAge        [len(ConnAgeBuckets) + 1]uint64
+	ConnStatements [len(ConnStatementsBuckets) + 1]uint64
+
+	// Time spent by the operations running hooks, inside the underlying
+	// driver and running the hooks, only measured when WithSelfTiming is set
+	SelfTimedOps uint64
+	DriverTime   time.Duration
+	HookTime     time.Duration
+}
+
+// diagnostics is shared by every connection of a Driver. Its counters are
+// updated atomically, they come first for their 64-bit alignment on 32-bit
+// platforms, as the diagnostics come first in Driver.
+type diagnostics struct {
+	swallowedErrors    uint64
+	hookErrors         uint64
+	argCountMismatches uint64
+	stmtCachePrepares  uint64
+	stmtCacheCloses    uint64
+	hookTimeouts       uint64
+	eventsDropped      uint64
+	hookPanics         uint64
+
+	stmtsPrepared  uint64
+	stmtExecutions uint64
+	stmtReuse      [len(StmtReuseBuckets) + 1]uint64
+	paths          [pathCount]uint64
+
+	openTxs   int64
+	openStmts int64
+
+	connsClosed    uint64
+	connsBroken    uint64
+	connAge        [len(ConnAgeBuckets) + 1]uint64
+	connStatements [len(ConnStatementsBuckets) + 1]uint64
+
+	selfTimedOps uint64
+	driverTime   int64
+	hookTime     int64
+
+	selfTiming bool
+	logger     func(event string, err error)
+}
+
+func (d *diagnostics) report(event string, err error) {
+	switch event {
+	case EventSwallowedError:
+		atomic.AddUint64(&d.swallowedErrors, 1)
+	case EventHookErrors:
+		atomic.AddUint64(&d.hookErrors, 1)
+	case EventArgCountMismatch:
+		atomic.AddUint64(&d.argCountMismatches, 1)
+	case EventStmtCachePrepare:
+		atomic.AddUint64(&d.stmtCachePrepares, 1)
+	case EventStmtCacheClose:
+		atomic.AddUint64(&d.stmtCacheCloses, 1)
+	case EventHookTimeout:
+		atomic.AddUint64(&d.hookTimeouts, 1)
+	case EventDropped:
+		atomic.AddUint64(&d.eventsDropped, 1)
+	case EventHookPanic:
+		atomic.AddUint64(&d.hookPanics, 1)
+	}
+
+	if d.logger != nil {
+		d.logger(event, err)
+	}
+}
+
+// setInternalReporterAll calls SetInternalReporter once on each of hooks
+// implementing InternalReporter
+func setInternalReporterAll(hooks []HookType, report func(event string, err error)) {
+	for i, h := range hooks {
+		if t, ok := h.(InternalReporter); ok && !seenBefore(hooks[:i], h) {
+			t.SetInternalReporter(report)
+		}
+	}
+}
+
+func (hs composed) SetInternalReporter(report func(event string, err error)) {
+	setInternalReporterAll(hs, report)
+}
+
+func (r *router) SetInternalReporter(report func(event string, err error)) {
+	hooks := []HookType{r.fallback}
+	for _, h := range r.routes {
+		hooks = append(hooks, h)
+	}
+	setInternalReporterAll(hooks, report)
+}
+
+func (s *sampler) SetInternalReporter(report func(event string, err error)) {
+	setInternalReporterAll([]HookType{s.hooks}, report)
+}
+
+// checkAfter returns the error of an After hook, reporting it when it hides
+// the error of an operation that has no result
+func (d *diagnostics) checkAfter(op string, noResult bool, opErr, hookErr error) error {
+	if hookErr == nil && opErr != nil && noResult {
+		d.report(EventSwallowedError, fmt.Errorf("sqlhooks: After%s hook returned nil for: %v", op, opErr))
+	}
+	return hookErr
+}
+
+// now returns the time hooks start running, only read when WithSelfTiming is set
+func (d *diagnostics) now() time.Time {
+	if !d.selfTiming {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// beforeDone sets the time spent by the Before hooks started at start
+func (d *diagnostics) beforeDone(ctx *Context, start time.Time) {
+	if d.selfTiming {
+		ctx.HookDuration = time.Since(start)
+	}
+}
+
+// afterDone accounts the driver and hooks time of an operation whose After
+// hooks started at start
+func (d *diagnostics) afterDone(ctx *Context, start time.Time) {
+	if !d.selfTiming {
+		return
+	}
+
+	atomic.AddUint64(&d.selfTimedOps, 1)
+	atomic.AddInt64(&d.driverTime, int64(ctx.Duration))
+	atomic.AddInt64(&d.hookTime, int64(ctx.HookDuration+time.Since(start)))
+}
+
+func (d *diagnostics) stats() Stats {
+	s := Stats{
+		SwallowedErrors:    atomic.LoadUint64(&d.swallowedErrors),
+		HookErrors:         atomic.LoadUint64(&d.hookErrors),
+		ArgCountMismatches: atomic.LoadUint64(&d.argCountMismatches),
+		StmtCachePrepares:  atomic.LoadUint64(&d.stmtCachePrepares),
+		StmtCacheCloses:    atomic.LoadUint64(&d.stmtCacheCloses),
+		HookTimeouts:       atomic.LoadUint64(&d.hookTimeouts),
+		EventsDropped:      atomic.LoadUint64(&d.eventsDropped),
+		HookPanics:         atomic.LoadUint64(&d.hookPanics),
+		StmtsPrepared:      atomic.LoadUint64(&d.stmtsPrepared),
+		StmtExecutions:     atomic.LoadUint64(&d.stmtExecutions),
+		OpenTxs:            atomic.LoadInt64(&d.openTxs),
+		OpenStmts:          atomic.LoadInt64(&d.openStmts),
+		ConnsClosed:        atomic.LoadUint64(&d.connsClosed),
+		ConnsBroken:        atomic.LoadUint64(&d.connsBroken),
+		SelfTimedOps:       atomic.LoadUint64(&d.selfTimedOps),
+		DriverTime:         time.Duration(atomic.LoadInt64(&d.driverTime)),
+		HookTime:           time.Duration(atomic.LoadInt64(&d.hookTime)),
+	}
+	for i := range d.stmtReuse {
+		s.StmtReuse[i] = atomic.LoadUint64(&d.stmtReuse[i])
+	}
+	for i := range d.paths {
+		s.Paths[i] = atomic.LoadUint64(&d.paths[i])
+	}
+	for i := range d.connAge {
+		s.ConnAge[i] = atomic.LoadUint64(&d.connAge[i])
+	}
+	for i := range d.connStatements {
+		s.ConnStatements[i] = atomic.LoadUint64(&d.connStatements[i])
+	}
+	return s
+}
+
+// dispatched counts an operation dispatched to p
+func (d *diagnostics) dispatched(p Path) {
+	if p != PathNone {
+		atomic.AddUint64(&d.paths[p], 1)
+	}
+}
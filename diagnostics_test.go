@@ -0,0 +1,159 @@
+package sqlhooks
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type internalEvents struct {
+	events []string
+	errs   []error
+}
+
+func (e *internalEvents) log(event string, err error) {
+	e.events = append(e.events, event)
+	e.errs = append(e.errs, err)
+}
+
+func driverStats(t *testing.T, db *sql.DB) Stats {
+	d, ok := db.Driver().(*Driver)
+	require.True(t, ok)
+	return d.Stats()
+}
+
+func TestInternalLoggerReportsSwallowedErrors(t *testing.T) {
+	hide := func(ctx *Context) error { return nil }
+	events := &internalEvents{}
+
+	openDBWithHooks(t, nil).Close()
+	db, err := Open(*driverFlag, *dsnFlag, &HooksMock{afterQuery: hide, afterPrepare: hide}, WithInternalLogger(events.log))
+	require.NoError(t, err)
+	defer db.Close()
+
+	db.Query("invalid query")
+
+	require.NotEmpty(t, events.events)
+	assert.Equal(t, EventSwallowedError, events.events[0])
+	assert.Error(t, events.errs[0])
+	assert.Equal(t, uint64(len(events.events)), driverStats(t, db).SwallowedErrors)
+}
+
+func TestInternalLoggerReportsStmtCachePrepareFailures(t *testing.T) {
+	events := &internalEvents{}
+	after := func(ctx *Context) error { return ctx.Error }
+
+	openDBWithHooks(t, nil).Close()
+	db, err := Open(*driverFlag, *dsnFlag, &execHooks{after}, WithStmtCache(10, 0), WithInternalLogger(events.log))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("invalid query")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{EventStmtCachePrepare}, events.events)
+	assert.Equal(t, uint64(1), driverStats(t, db).StmtCachePrepares)
+}
+
+var errStmtClose = errors.New("close failed")
+
+// closeErrConn prepares statements whose Close fails
+type closeErrConn struct {
+	driver.Conn
+}
+
+func (c closeErrConn) Prepare(query string) (driver.Stmt, error) {
+	return closeErrStmt{}, nil
+}
+
+type closeErrStmt struct {
+	driver.Stmt
+}
+
+func (s closeErrStmt) NumInput() int { return -1 }
+func (s closeErrStmt) Close() error  { return errStmtClose }
+
+func TestInternalLoggerReportsStmtCacheCloseFailures(t *testing.T) {
+	events := &internalEvents{}
+	diag := &diagnostics{logger: events.log}
+	cache := newStmtCache(closeErrConn{}, diag, 1, 0)
+
+	require.NotNil(t, cache.get("q1", 0))
+	require.NotNil(t, cache.get("q2", 0))
+
+	assert.Equal(t, []string{EventStmtCacheClose}, events.events)
+	assert.Equal(t, []error{errStmtClose}, events.errs)
+	assert.Equal(t, Stats{StmtCacheCloses: 1}, diag.stats())
+}
+
+func TestWithLoggerWritesEvents(t *testing.T) {
+	var buf bytes.Buffer
+	d := NewDriver("", nil, WithLogger(log.New(&buf, "", 0)))
+	cache := newStmtCache(closeErrConn{}, &d.diag, 1, 0)
+	cache.get("q1", 0)
+	cache.close()
+
+	assert.Equal(t, "[stmt_cache_close] close failed\n", buf.String())
+}
+
+func TestStatsCountWithoutInternalLogger(t *testing.T) {
+	diag := &diagnostics{}
+	cache := newStmtCache(closeErrConn{}, diag, 1, 0)
+	cache.get("q1", 0)
+	cache.close()
+
+	assert.Equal(t, Stats{StmtCacheCloses: 1}, diag.stats())
+}
+
+func TestSelfTimingSplitsHookAndDriverTime(t *testing.T) {
+	var hookDurations []time.Duration
+	hooks := &HooksMock{
+		beforeStmtExec: func(ctx *Context) error {
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		},
+		afterStmtExec: func(ctx *Context) error {
+			hookDurations = append(hookDurations, ctx.HookDuration)
+			time.Sleep(5 * time.Millisecond)
+			return ctx.Error
+		},
+	}
+
+	openDBWithHooks(t, nil).Close()
+	db, err := Open(*driverFlag, *dsnFlag, hooks, WithSelfTiming())
+	require.NoError(t, err)
+	defer db.Close()
+
+	stmt, err := db.Prepare(queries[*driverFlag].insert)
+	require.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Exec("foo", "bar")
+	require.NoError(t, err)
+
+	require.Len(t, hookDurations, 1)
+	assert.True(t, hookDurations[0] >= 5*time.Millisecond)
+
+	stats := driverStats(t, db)
+	assert.NotZero(t, stats.SelfTimedOps)
+	assert.True(t, stats.HookTime >= 10*time.Millisecond)
+	assert.True(t, stats.DriverTime < stats.HookTime)
+
+	hookDurations = nil
+	untimed := *hooks
+	db = openDBWithHooks(t, &untimed)
+	defer db.Close()
+	_, err = db.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+
+	assert.Zero(t, driverStats(t, db).SelfTimedOps)
+	for _, d := range hookDurations {
+		assert.Zero(t, d)
+	}
+}
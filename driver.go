@@ -1,8 +1,24 @@
 package sqlhooks
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// txIDs generates the transaction ids, statementIDs the Context.StatementID
+// ones, and connIDs the Context.ConnID ones
+var (
+	txIDs        uint64
+	statementIDs uint64
+	connIDs      uint64
 )
 
 func driverToInterface(args []driver.Value) []interface{} {
@@ -21,226 +37,1422 @@ func interfaceToDriver(args []interface{}) []driver.Value {
 	return r
 }
 
+// namedToValues converts the arguments of the context aware driver methods,
+// named arguments are kept as sql.NamedArg, so they reach the hooks and the
+// driver with their name. Values are never copied: the pointers of output
+// parameters are the ones the driver writes to.
+func namedToValues(nargs []driver.NamedValue) ([]driver.Value, error) {
+	args := make([]driver.Value, len(nargs))
+	for i, arg := range nargs {
+		if arg.Name != "" {
+			args[i] = sql.NamedArg{Name: arg.Name, Value: arg.Value}
+			continue
+		}
+		args[i] = arg.Value
+	}
+	return args, nil
+}
+
+func valuesToNamed(args []driver.Value) []driver.NamedValue {
+	nargs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok {
+			nargs[i] = driver.NamedValue{Name: named.Name, Ordinal: i + 1, Value: named.Value}
+			continue
+		}
+		nargs[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return nargs
+}
+
+// checkLegacyArgs fails for named arguments, as database/sql does for the
+// driver methods without context
+func checkLegacyArgs(args []driver.Value) error {
+	for _, arg := range args {
+		if _, ok := arg.(sql.NamedArg); ok {
+			return errors.New("sqlhooks: driver does not support the use of Named Parameters")
+		}
+	}
+	return nil
+}
+
 type tx struct {
 	driver.Tx
-	hooks HookType
-	ctx   *Context
+	ctx     *Context
+	stdCtx  context.Context
+	conn    *conn
+	id      uint64
+	summary *txSummary
 }
 
 func (t tx) Commit() error {
 	var ctx *Context
+	hooks := t.conn.hooksFor(t.stdCtx, OpCommit)
 
-	if v, ok := t.hooks.(Commiter); ok {
-		ctx = NewContext()
+	if v, ok := asCommiter(hooks); ok {
+		ctx = t.conn.newContext()
+		ctx.Ctx = t.stdCtx
+		ctx.TxID = t.id
+		ctx.TxOrigin = t.conn.txOrigin
+		ctx.Migration = t.conn.migration(ctx)
+		ctx.Internal = IsInternal(ctx.Ctx)
+		hooksStart := t.conn.diag.now()
 		if err := v.BeforeCommit(ctx); err != nil {
+			t.abort(err)
 			return err
 		}
+		t.conn.diag.beforeDone(ctx, hooksStart)
 	}
 
+	start := t.conn.clock()
 	err := t.Tx.Commit()
+	took := t.conn.clock.since(start)
+	t.conn.broke(err)
+	doneErr := ctxErr(t.stdCtx, err)
+	t.conn.endTx()
+	outcome, driverErr := TxCommitted, err
+	if err != nil {
+		outcome = TxCommitFailed
+	}
 
-	if v, ok := t.hooks.(Commiter); ok {
+	if v, ok := asCommiter(hooks); ok {
 		ctx.Error = err
+		ctx.CtxErr = doneErr
+		ctx.Start = start.wall
+		ctx.Duration = took
+		hooksStart := t.conn.diag.now()
 		err = v.AfterCommit(ctx)
+		t.conn.diag.afterDone(ctx, hooksStart)
 	}
 
+	t.summary.end(outcome, driverErr)
+
 	return err
 }
 
 func (t tx) Rollback() error {
 	var ctx *Context
+	hooks := t.conn.hooksFor(t.stdCtx, OpRollback)
 
-	if v, ok := t.hooks.(Rollbacker); ok {
-		ctx = NewContext()
+	if v, ok := asRollbacker(hooks); ok {
+		ctx = t.conn.newContext()
+		ctx.Ctx = t.stdCtx
+		ctx.TxID = t.id
+		ctx.TxOrigin = t.conn.txOrigin
+		ctx.CausedByContext = t.stdCtx.Err() != nil
+		ctx.Migration = t.conn.migration(ctx)
+		ctx.Internal = IsInternal(ctx.Ctx)
+		hooksStart := t.conn.diag.now()
 		if err := v.BeforeRollback(ctx); err != nil {
+			t.abort(err)
 			return err
 		}
+		t.conn.diag.beforeDone(ctx, hooksStart)
 	}
 
+	start := t.conn.clock()
 	err := t.Tx.Rollback()
+	took := t.conn.clock.since(start)
+	t.conn.broke(err)
+	doneErr := ctxErr(t.stdCtx, err)
+	t.conn.endTx()
+	driverErr := err
 
-	if v, ok := t.hooks.(Rollbacker); ok {
+	if v, ok := asRollbacker(hooks); ok {
 		ctx.Error = err
+		ctx.CtxErr = doneErr
+		ctx.Start = start.wall
+		ctx.Duration = took
+		hooksStart := t.conn.diag.now()
 		err = v.AfterRollback(ctx)
+		t.conn.diag.afterDone(ctx, hooksStart)
 	}
 
+	t.summary.end(TxRolledBack, driverErr)
+
 	return err
 }
 
+// abort ends a transaction whose Before Commit or Rollback hook failed with
+// err: database/sql considers it done anyway and puts the connection back in
+// the pool, so it's rolled back, without any hook, not to be left open there
+func (t tx) abort(err error) {
+	// A rollback failing here would fail the next statements of the
+	// connection, there's nothing else to do about it
+	t.Tx.Rollback()
+	t.conn.endTx()
+	t.summary.end(TxRolledBack, err)
+}
+
 type stmt struct {
+	// usage comes first for the alignment of its 64-bit counter
+	usage stmtUsage
+
 	driver.Stmt
-	hooks HookType
 	ctx   *Context
+	conn  *conn
+	query string
+
+	// numInput is the number of arguments the driver reported at prepare
+	// time, -1 if it doesn't know, and placeholders the ones query expects,
+	// with WithArgCountCheck: numInput, or the Placeholders count when it's -1
+	numInput     int
+	placeholders int
+
+	savepoint     savepointKind
+	savepointName string
+
+	// copy is set for COPY FROM STDIN statements, see CopyHook
+	copy *copyIn
+}
+
+func (s *stmt) Close() error {
+	s.usage.closed(s.conn.diag)
+	err := s.Stmt.Close()
+	s.copy.end(err)
+	return err
+}
+
+// context returns the Context for an execution of s, the statement's
+// Context is shared by its executions, nil if hooks don't need one
+func (s *stmt) context(hooks HookType, stdCtx context.Context) *Context {
+	if _, ok := asStmter(hooks); !ok {
+		return s.ctx
+	}
+
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = NewContext()
+		ctx.traceExtractor = s.conn.traceExtractor
+		ctx.diag = s.conn.diag
+	}
+	// The query is set again for every execution, a hook changing it must
+	// not change the one of the next executions
+	ctx.Query = s.query
+	if s.conn.collapseInLists {
+		ctx.Query, _ = CollapseInLists(s.query, nil)
+	}
+	ctx.Ctx = stdCtx
+	ctx.TxID = s.conn.txID
+	ctx.NumInput, ctx.hasNumInput = s.numInput, true
+	ctx.Synthetic = false
+	ctx.substitute = nil
+	ctx.StatementID = atomic.AddUint64(&statementIDs, 1)
+	ctx.ConnID = s.conn.id
+	ctx.conn = &s.conn.data
+	ctx.ServerInfo = s.conn.serverInfo
+	ctx.Peer = s.conn.peer
+	ctx.Schema = s.conn.data.schema
+	ctx.Seq = atomic.AddUint64(&s.conn.seq, 1)
+	ctx.Migration = s.conn.migration(ctx)
+	ctx.Internal = IsInternal(ctx.Ctx)
+	return ctx
 }
 
-func (s stmt) Close() error {
-	return s.Stmt.Close()
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.hookedExec(context.Background(), args)
 }
 
-func (s stmt) Exec(args []driver.Value) (res driver.Result, err error) {
-	if t, ok := s.hooks.(Stmter); ok {
-		s.ctx.Args = driverToInterface(args)
-		if err := t.BeforeStmtExec(s.ctx); err != nil {
+func (s *stmt) ExecContext(stdCtx context.Context, nargs []driver.NamedValue) (driver.Result, error) {
+	if s.conn.ops&OpExec == 0 || IsUnhooked(stdCtx) {
+		// Straight to the driver, without converting the arguments back and forth
+		if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+			s.usage.executed(s.conn.diag, s.ctx)
+			res, err := execer.ExecContext(stdCtx, nargs)
+			s.conn.diag.dispatched(PathStmtExecContext)
+			s.conn.ranStatement(err)
+			s.conn.updateSchema(s.query, err)
+			s.copy.exec(len(nargs), err)
+			return res, err
+		}
+	}
+
+	args, err := namedToValues(nargs)
+	if err != nil {
+		return nil, err
+	}
+	return s.hookedExec(stdCtx, args)
+}
+
+func (s *stmt) hookedExec(stdCtx context.Context, args []driver.Value) (res driver.Result, err error) {
+	if s.conn.ops&OpExec == 0 || IsUnhooked(stdCtx) {
+		s.usage.executed(s.conn.diag, s.ctx)
+		var path Path
+		res, path, err = s.driverExec(stdCtx, args)
+		s.conn.diag.dispatched(path)
+		s.conn.ranStatement(err)
+		s.conn.updateSchema(s.query, err)
+		s.copy.exec(len(args), err)
+		return res, err
+	}
+	if s.copy != nil && !s.conn.copyRowHooks && len(args) > 0 {
+		return s.copyRow(stdCtx, args)
+	}
+
+	all := s.conn.callHooks(stdCtx)
+	hooks := s.conn.execHooks(all, s.savepoint)
+	attempts, held := s.conn.startAttempt(stdCtx, s.query, args)
+	retryArgs := args
+	ctx := s.context(hooks, stdCtx)
+	retry := ctx != nil && held != nil
+	if retry {
+		ctx = held
+		s.conn.retried(ctx, attempts)
+	} else {
+		ctx.attempted(attempts)
+	}
+	s.usage.executed(s.conn.diag, ctx)
+	inBatch := s.conn.batch(stdCtx)
+	if ctx != nil {
+		ctx.ConnStatement = s.conn.nextStatement()
+		ctx.BatchID = inBatch.id()
+	}
+
+	synthetic := false
+	if retry {
+		// The Before hooks ran for the first attempt
+		args = s.conn.heldArgs(ctx, args)
+	} else if t, ok := asStmter(hooks); ok {
+		ctx.Args = driverToInterface(args)
+		ctx.ArgTypes = s.conn.takeArgTypes()
+		if s.conn.argsSize {
+			ctx.ArgsSize = ArgsSize(ctx.Args)
+		}
+		if s.conn.collapseInLists {
+			_, ctx.Args = CollapseInLists(s.query, ctx.Args)
+		}
+		s.conn.hideArgs(ctx, ctx.Query)
+		hooksStart := s.conn.diag.now()
+		if err := t.BeforeStmtExec(ctx); err != nil {
 			return nil, err
 		}
-		args = interfaceToDriver(s.ctx.Args)
+		s.conn.diag.beforeDone(ctx, hooksStart)
+		if !s.conn.collapseInLists && s.conn.argsVisible() {
+			args = interfaceToDriver(ctx.Args)
+		}
+		res, synthetic = ctx.substitutedResult()
+	}
+
+	start := s.conn.clock()
+	var took time.Duration
+	var doneErr, txErr error
+	path := PathNone
+	txKind := notTxStatement
+	if !synthetic {
+		if err = s.checkArgs(len(args)); err == nil {
+			b := s.conn.heartbeats.start(hooks, ctx, s.conn.clock, start)
+			res, path, err = s.driverExec(stdCtx, args)
+			s.conn.heartbeats.stop(b)
+			s.conn.diag.dispatched(path)
+		}
+		took = s.conn.clock.since(start)
+		doneErr = ctxErr(stdCtx, err)
+		s.conn.ranStatement(err)
+		s.conn.txSummary.statement(s.query, took, err)
+		inBatch.statement(all, took, err)
+		s.conn.updateSchema(s.query, err)
+
+		if fn := prepareExplain(stdCtx, s.conn, hooks, s.query, args, took, err); fn != nil {
+			fn()
+		}
+
+		s.conn.afterSavepoint(all, s.savepoint, s.savepointName, err)
+		txKind, txErr = s.conn.txStatement(s.query), err
+		res = s.conn.wrapResult(hooks, s.query, res)
 	}
 
-	return s.Stmt.Exec(args)
+	if t, ok := asStmter(hooks); ok {
+		ctx.Error = err
+		ctx.CtxErr = doneErr
+		ctx.Start = start.wall
+		ctx.Duration = took
+		ctx.Path = path
+		ctx.Result = res
+		if !s.conn.failedAttempt(stdCtx, s.query, retryArgs, attempts, err, ctx, func() { t.AfterStmtExec(ctx) }) {
+			hooksStart := s.conn.diag.now()
+			err = s.conn.diag.checkAfter("StmtExec", res == nil, ctx.Error, t.AfterStmtExec(ctx))
+			s.conn.diag.afterDone(ctx, hooksStart)
+		}
+	}
+	s.conn.afterTxStatement(stdCtx, txKind, start, took, txErr)
+	if !synthetic {
+		s.copy.exec(len(args), txErr)
+	}
+
+	return res, err
 }
 
-func (s stmt) NumInput() int {
+// driverExec executes the underlying statement, as database/sql would
+func (s *stmt) driverExec(stdCtx context.Context, args []driver.Value) (driver.Result, Path, error) {
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err := e.ExecContext(stdCtx, valuesToNamed(args))
+		return res, PathStmtExecContext, err
+	}
+
+	if err := stdCtx.Err(); err != nil {
+		return nil, PathStmtExec, err
+	}
+	if err := checkLegacyArgs(args); err != nil {
+		return nil, PathStmtExec, err
+	}
+	res, err := s.Stmt.Exec(args)
+	return res, PathStmtExec, err
+}
+
+func (s *stmt) NumInput() int {
 	return s.Stmt.NumInput()
 }
 
-func (s stmt) Query(args []driver.Value) (driver.Rows, error) {
-	if t, ok := s.hooks.(Stmter); ok {
-		s.ctx.Args = driverToInterface(args)
-		if err := t.BeforeStmtQuery(s.ctx); err != nil {
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.hookedQuery(context.Background(), args)
+}
+
+func (s *stmt) QueryContext(stdCtx context.Context, nargs []driver.NamedValue) (driver.Rows, error) {
+	if s.conn.ops&OpQuery == 0 || IsUnhooked(stdCtx) {
+		// Straight to the driver, without converting the arguments back and forth
+		if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			s.usage.executed(s.conn.diag, s.ctx)
+			rows, err := queryer.QueryContext(stdCtx, nargs)
+			s.conn.diag.dispatched(PathStmtQueryContext)
+			s.conn.ranStatement(err)
+			return rows, err
+		}
+	}
+
+	args, err := namedToValues(nargs)
+	if err != nil {
+		return nil, err
+	}
+	return s.hookedQuery(stdCtx, args)
+}
+
+func (s *stmt) hookedQuery(stdCtx context.Context, args []driver.Value) (driver.Rows, error) {
+	if s.conn.ops&OpQuery == 0 || IsUnhooked(stdCtx) {
+		s.usage.executed(s.conn.diag, s.ctx)
+		rows, path, err := s.driverQuery(stdCtx, args)
+		s.conn.diag.dispatched(path)
+		s.conn.ranStatement(err)
+		return rows, err
+	}
+
+	hooks := s.conn.execHooks(s.conn.callHooks(stdCtx), s.savepoint)
+	attempts, held := s.conn.startAttempt(stdCtx, s.query, args)
+	retryArgs := args
+	ctx := s.context(hooks, stdCtx)
+	retry := ctx != nil && held != nil
+	if retry {
+		ctx = held
+		s.conn.retried(ctx, attempts)
+	} else {
+		ctx.attempted(attempts)
+	}
+	s.usage.executed(s.conn.diag, ctx)
+	inBatch := s.conn.batch(stdCtx)
+	if ctx != nil {
+		ctx.ConnStatement = s.conn.nextStatement()
+		ctx.BatchID = inBatch.id()
+	}
+
+	var rows driver.Rows
+	synthetic := false
+	if retry {
+		// The Before hooks ran for the first attempt
+		args = s.conn.heldArgs(ctx, args)
+	} else if t, ok := asStmter(hooks); ok {
+		ctx.Args = driverToInterface(args)
+		ctx.ArgTypes = s.conn.takeArgTypes()
+		if s.conn.argsSize {
+			ctx.ArgsSize = ArgsSize(ctx.Args)
+		}
+		if s.conn.collapseInLists {
+			_, ctx.Args = CollapseInLists(s.query, ctx.Args)
+		}
+		s.conn.hideArgs(ctx, ctx.Query)
+		hooksStart := s.conn.diag.now()
+		if err := t.BeforeStmtQuery(ctx); err != nil {
 			return nil, err
 		}
-		args = interfaceToDriver(s.ctx.Args)
+		s.conn.diag.beforeDone(ctx, hooksStart)
+		if !s.conn.collapseInLists && s.conn.argsVisible() {
+			args = interfaceToDriver(ctx.Args)
+		}
+		rows, synthetic = ctx.substitutedRows()
 	}
 
-	rows, err := s.Stmt.Query(args)
+	raw := s.conn.rawRows(s.query)
+	start := s.conn.clock()
+	var took time.Duration
+	var err, doneErr error
+	path := PathNone
+	if !synthetic {
+		if err = s.checkArgs(len(args)); err == nil {
+			b := s.conn.heartbeats.start(hooks, ctx, s.conn.clock, start)
+			rows, path, err = s.driverQuery(stdCtx, args)
+			s.conn.heartbeats.stop(b)
+			s.conn.diag.dispatched(path)
+		}
+		took = s.conn.clock.since(start)
+		doneErr = ctxErr(stdCtx, err)
+		s.conn.ranStatement(err)
+		s.conn.txSummary.statement(s.query, took, err)
+		inBatch.statement(hooks, took, err)
 
-	if t, ok := s.hooks.(Stmter); ok {
-		s.ctx.Error = err
-		err = t.AfterStmtQuery(s.ctx)
+		if !raw {
+			if fn := prepareExplain(stdCtx, s.conn, hooks, s.query, args, took, err); fn != nil {
+				rows = explainRows(rows, fn)
+			}
+		}
+	}
+	if !raw {
+		rows = closeRows(wrapRows(rows, hooks, ctx), rows, hooks, ctx, s.conn.clock, start, took, s.conn.abandonedRows)
+	}
+
+	if t, ok := asStmter(hooks); ok {
+		ctx.Error = err
+		ctx.CtxErr = doneErr
+		ctx.Start = start.wall
+		ctx.Duration = took
+		ctx.Path = path
+		ctx.setRows(rows)
+		if !s.conn.failedAttempt(stdCtx, s.query, retryArgs, attempts, err, ctx, func() { t.AfterStmtQuery(ctx) }) {
+			hooksStart := s.conn.diag.now()
+			err = s.conn.diag.checkAfter("StmtQuery", rows == nil, ctx.Error, t.AfterStmtQuery(ctx))
+			s.conn.diag.afterDone(ctx, hooksStart)
+		}
 	}
 
 	return rows, err
 }
 
+// driverQuery queries the underlying statement, as database/sql would
+func (s *stmt) driverQuery(stdCtx context.Context, args []driver.Value) (driver.Rows, Path, error) {
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err := q.QueryContext(stdCtx, valuesToNamed(args))
+		return rows, PathStmtQueryContext, err
+	}
+
+	if err := stdCtx.Err(); err != nil {
+		return nil, PathStmtQuery, err
+	}
+	if err := checkLegacyArgs(args); err != nil {
+		return nil, PathStmtQuery, err
+	}
+	rows, err := s.Stmt.Query(args)
+	return rows, PathStmtQuery, err
+}
+
 type conn struct {
+	// seq generates the Context.Seq of the connection's operations,
+	// it's first so it's 64-bit aligned for atomic operations
+	seq uint64
+
 	driver.Conn
-	hooks HookType
+	id   uint64
+	data ConnData
+
+	serverInfo ServerInfo
+	peer       string
+
+	hooks *atomic.Value
+	cache *stmtCache
+	diag  *diagnostics
+
+	// selected are the hooks bound to the connection by WithHooksSelector,
+	// run instead of the driver ones, nil without it, boundSelected are
+	// them bounded by WithHookTimeout
+	selected      HookType
+	boundSelected HookType
+
+	traceExtractor TraceExtractor
+
+	// txID is the id of the transaction in progress, 0 if there's none,
+	// txOrigin how it was begun, txExtra the extra hooks of the
+	// context.Context it was begun with, txBatch its batch and txSummary its
+	// summary, nil without TxSummaryHook
+	txID      uint64
+	txOrigin  TxOrigin
+	txExtra   *extraHooks
+	txBatch   *batch
+	txSummary *txSummary
+
+	// statements counts the statements run on the connection, transactions
+	// the transactions begun on it, opened is when it was opened and
+	// brokenErr the error the driver reported it broken with, for ConnSummary
+	statements   uint64
+	transactions int
+	opened       instant
+	brokenErr    error
+
+	ops               Op
+	skipSavepointExec bool
+	txStatements      bool
+	copyRowHooks      bool
+	resultWrapping    bool
+	hookTimeout       time.Duration
+	argsSize          bool
+	argTypes          bool
+	collapseInLists   bool
+	abandonedRows     bool
+	rawRowsPrefixes   []string
+	argVisibility     ArgVisibility
+	redactor          Redactor
+	argCountCheck     bool
+	argCountWarn      bool
+
+	// pendingArgTypes are the types of the arguments of the statement
+	// database/sql is about to run, with WithArgTypes
+	pendingArgTypes []reflect.Type
+
+	// migrations is set for the connections of a migration driver, see MigrationDriverSuffixes
+	migrations bool
+
+	pool *poolWaits
+
+	// driverName and system are the ones of the Driver, for Context
+	driverName string
+	system     SystemInfo
+
+	clock      clock
+	heartbeats *heartbeats
+	retries    *retries
+}
+
+func (c *conn) newContext() *Context {
+	ctx := NewContext()
+	ctx.traceExtractor = c.traceExtractor
+	ctx.diag = c.diag
+	ctx.StatementID = atomic.AddUint64(&statementIDs, 1)
+	ctx.ConnID = c.id
+	ctx.conn = &c.data
+	ctx.ServerInfo = c.serverInfo
+	ctx.Peer = c.peer
+	ctx.DriverName = c.driverName
+	ctx.System = c.system
+	ctx.Schema = c.data.schema
+	ctx.Seq = atomic.AddUint64(&c.seq, 1)
+	return ctx
 }
 
-func (c conn) Prepare(query string) (driver.Stmt, error) {
+// nextStatement returns the ordinal of the next statement run on c
+func (c *conn) nextStatement() uint64 {
+	return c.statements + 1
+}
+
+// ranStatement counts a statement run on c, unless the driver skipped it,
+// as database/sql prepares it then
+func (c *conn) ranStatement(err error) {
+	if err != driver.ErrSkip {
+		c.statements++
+	}
+	c.broke(err)
+}
+
+// callHooks returns the hooks to run for a call issued with stdCtx: the
+// driver hooks, or the WithHooksSelector ones, followed by the extra hooks of
+// the transaction in progress and the ones of stdCtx, bounded by WithHookTimeout
+func (c *conn) callHooks(stdCtx context.Context) HookType {
+	v := c.hooks.Load().(hooksValue)
+	if v.shutdown {
+		return nil
+	}
+	driverHooks := v.all
+	if c.selected != nil {
+		driverHooks = c.selected
+	}
+	extra := extraHooksFrom(stdCtx)
+	if extra == nil && c.txExtra == nil {
+		// Bounded once for all, not to allocate on every operation
+		if c.selected != nil {
+			return c.boundSelected
+		}
+		return v.bounded
+	}
+
+	var hooks HookType
+	switch {
+	case extra == nil:
+		hooks = c.txExtra.hooks
+	case c.txExtra == nil || extra.inherits(c.txExtra):
+		hooks = extra.hooks
+	default:
+		hooks = Compose(c.txExtra.hooks, extra.hooks)
+	}
+	return c.boundHooks(Compose(driverHooks, hooks))
+}
+
+// hooksFor returns the hooks to run for op, nil if it's disabled by WithOperations
+func (c *conn) hooksFor(stdCtx context.Context, op Op) HookType {
+	if c.ops&op == 0 {
+		return nil
+	}
+	return c.callHooks(stdCtx)
+}
+
+func (c *conn) endTx() {
+	atomic.AddInt64(&c.diag.openTxs, -1)
+	c.txID = 0
+	c.txOrigin = TxOriginNone
+	c.txExtra = nil
+	c.txBatch = nil
+	c.txSummary = nil
+}
+
+// savepoint parses query if it needs to be treated as a savepoint statement
+func (c *conn) savepoint(hooks HookType, query string) (savepointKind, string) {
+	if !c.skipSavepointExec && !implementsHook(hooks, isSavepointer) {
+		return notSavepoint, ""
+	}
+	return parseSavepoint(query)
+}
+
+// execHooks returns the hooks to run for a statement, which are none for
+// savepoint statements when WithSavepointExecHooks(false) is set
+func (c *conn) execHooks(hooks HookType, kind savepointKind) HookType {
+	if kind != notSavepoint && c.skipSavepointExec {
+		return nil
+	}
+	return hooks
+}
+
+func (c *conn) afterSavepoint(hooks HookType, kind savepointKind, name string, err error) {
+	if kind == notSavepoint || err != nil {
+		return
+	}
+
+	if t, ok := hooks.(Savepointer); ok {
+		kind.dispatch(t, c.txID, name)
+	}
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.hookedPrepare(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(stdCtx context.Context, query string) (driver.Stmt, error) {
+	return c.hookedPrepare(stdCtx, query)
+}
+
+func (c *conn) hookedPrepare(stdCtx context.Context, query string) (driver.Stmt, error) {
+	if c.ops&(OpPrepare|OpQuery|OpExec) == 0 || IsUnhooked(stdCtx) {
+		_stmt, path, err := c.driverPrepare(stdCtx, query)
+		c.diag.dispatched(path)
+		c.broke(err)
+		return _stmt, err
+	}
+
 	var ctx *Context
 
-	if t, ok := c.hooks.(Stmter); ok {
-		ctx = NewContext()
+	all := c.callHooks(stdCtx)
+	sp, spName := c.savepoint(all, query)
+	hooks := c.execHooks(all, sp)
+	prepareHooks := hooks
+	if c.ops&OpPrepare == 0 {
+		prepareHooks = nil
+	}
+
+	// The executions get a Context of their own when the Prepare hooks don't run
+	if _, ok := asStmter(prepareHooks); ok {
+		ctx = c.newContext()
+		ctx.Ctx = stdCtx
 		ctx.Query = query
+		if c.collapseInLists {
+			ctx.Query, _ = CollapseInLists(query, nil)
+		}
+		ctx.TxID = c.txID
+		ctx.Migration = c.migration(ctx)
+		ctx.Internal = IsInternal(ctx.Ctx)
+	}
 
+	if t, ok := asStmter(prepareHooks); ok {
+		hooksStart := c.diag.now()
 		if err := t.BeforePrepare(ctx); err != nil {
 			return nil, err
 		}
+		c.diag.beforeDone(ctx, hooksStart)
+
+		if !c.collapseInLists {
+			query = ctx.Query
+		}
+	}
 
-		query = ctx.Query
+	start := c.clock()
+	_stmt, path, err := c.driverPrepare(stdCtx, query)
+	took := c.clock.since(start)
+	c.diag.dispatched(path)
+	c.broke(err)
+	doneErr := ctxErr(stdCtx, err)
+	numInput := -1
+	if err == nil {
+		numInput = _stmt.NumInput()
+	}
+	if ctx != nil {
+		ctx.NumInput, ctx.hasNumInput = numInput, err == nil
+	}
+
+	if t, ok := asStmter(prepareHooks); ok {
+		ctx.Error = err
+		ctx.CtxErr = doneErr
+		ctx.Start = start.wall
+		ctx.Duration = took
+		ctx.Path = path
+		hooksStart := c.diag.now()
+		err = c.diag.checkAfter("Prepare", _stmt == nil, ctx.Error, t.AfterPrepare(ctx))
+		c.diag.afterDone(ctx, hooksStart)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stmt{Stmt: _stmt, ctx: ctx, conn: c, query: query, numInput: numInput, savepoint: sp, savepointName: spName}
+	s.copy = c.startCopy(all, stdCtx, query, start)
+	if c.argCountCheck {
+		s.placeholders = numInput
+		if numInput < 0 {
+			s.placeholders = Placeholders(query)
+		}
+	}
+	s.usage.prepared(c.diag)
+	return s, nil
+}
+
+// driverPrepare prepares query on the underlying connection, as database/sql would
+func (c *conn) driverPrepare(stdCtx context.Context, query string) (driver.Stmt, Path, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		_stmt, err := p.PrepareContext(stdCtx, query)
+		return _stmt, PathPrepareContext, err
 	}
 
 	_stmt, err := c.Conn.Prepare(query)
+	if err == nil {
+		if err := stdCtx.Err(); err != nil {
+			_stmt.Close()
+			return nil, PathPrepare, err
+		}
+	}
+	return _stmt, PathPrepare, err
+}
 
-	if t, ok := c.hooks.(Stmter); ok {
-		err = t.AfterPrepare(ctx)
+func (c *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.hookedQuery(context.Background(), query, args)
+}
+
+func (c *conn) QueryContext(stdCtx context.Context, query string, nargs []driver.NamedValue) (driver.Rows, error) {
+	if c.ops&OpQuery == 0 || IsUnhooked(stdCtx) {
+		// Straight to the driver, without converting the arguments back and forth
+		if queryer, ok := c.Conn.(driver.QueryerContext); ok && c.cache == nil {
+			rows, err := queryer.QueryContext(stdCtx, query, nargs)
+			c.diag.dispatched(PathQueryerContext)
+			c.ranStatement(err)
+			return rows, err
+		}
 	}
 
-	return stmt{_stmt, c.hooks, ctx}, err
+	args, err := namedToValues(nargs)
+	if err != nil {
+		return nil, err
+	}
+	return c.hookedQuery(stdCtx, query, args)
 }
 
-func (c conn) Query(query string, args []driver.Value) (driver.Rows, error) {
-	if queryer, ok := c.Conn.(driver.Queryer); ok {
-		var ctx *Context
-		if t, ok := c.hooks.(Queryer); ok {
-			ctx = NewContext()
-			ctx.Query = query
-			ctx.Args = driverToInterface(args)
+func (c *conn) hookedQuery(stdCtx context.Context, query string, args []driver.Value) (driver.Rows, error) {
+	switch c.Conn.(type) {
+	case driver.QueryerContext, driver.Queryer:
+	default:
+		if c.cache == nil {
+			// Not implemented by underlying driver
+			return nil, driver.ErrSkip
+		}
+	}
+
+	if c.ops&OpQuery == 0 || IsUnhooked(stdCtx) {
+		rows, path, err := c.driverQuery(stdCtx, query, args)
+		c.diag.dispatched(path)
+		c.ranStatement(err)
+		return rows, err
+	}
 
-			if err := t.BeforeQuery(ctx); err != nil {
-				return nil, err
-			}
+	hooks := c.callHooks(stdCtx)
+	inBatch := c.batch(stdCtx)
+	attempts, held := c.startAttempt(stdCtx, query, args)
+	retryQuery, retryArgs := query, args
 
+	var ctx *Context
+	var rows driver.Rows
+	synthetic := false
+	if _, ok := asQueryer(hooks); ok && held != nil {
+		// The Before hooks ran for the first attempt
+		ctx = held
+		c.retried(ctx, attempts)
+		ctx.BatchID = inBatch.id()
+		if !c.collapseInLists {
 			query = ctx.Query
-			args = interfaceToDriver(ctx.Args)
 		}
+		args = c.heldArgs(ctx, args)
+	} else if t, ok := asQueryer(hooks); ok {
+		ctx = c.newContext()
+		ctx.Ctx = stdCtx
+		ctx.Query = query
+		ctx.Args = driverToInterface(args)
+		ctx.ArgTypes = c.takeArgTypes()
+		if c.argsSize {
+			ctx.ArgsSize = ArgsSize(ctx.Args)
+		}
+		if c.collapseInLists {
+			ctx.Query, ctx.Args = CollapseInLists(query, ctx.Args)
+		}
+		c.hideArgs(ctx, ctx.Query)
+		ctx.Migration = c.migration(ctx)
+		ctx.Internal = IsInternal(ctx.Ctx)
+		ctx.TxID = c.txID
+		ctx.BatchID = inBatch.id()
+		ctx.ConnStatement = c.nextStatement()
+		ctx.attempted(attempts)
 
-		rows, err := queryer.Query(query, args)
+		hooksStart := c.diag.now()
+		if err := t.BeforeQuery(ctx); err != nil {
+			return nil, err
+		}
+		c.diag.beforeDone(ctx, hooksStart)
 
-		if t, ok := c.hooks.(Queryer); ok {
-			ctx.Error = err
-			err = t.AfterQuery(ctx)
+		if !c.collapseInLists {
+			query = ctx.Query
+			if c.argsVisible() {
+				args = interfaceToDriver(ctx.Args)
+			}
 		}
+		rows, synthetic = ctx.substitutedRows()
+	}
 
-		return rows, err
+	raw := c.rawRows(query)
+	start := c.clock()
+	var took time.Duration
+	var err, doneErr error
+	path := PathNone
+	if !synthetic {
+		if err = c.checkArgs(query, len(args)); err == nil {
+			b := c.heartbeats.start(hooks, ctx, c.clock, start)
+			rows, path, err = c.driverQuery(stdCtx, query, args)
+			if err == driver.ErrSkip && ctx != nil {
+				rows, path, err = c.preparedQuery(stdCtx, query, args)
+			}
+			c.heartbeats.stop(b)
+			c.diag.dispatched(path)
+		}
+		took = c.clock.since(start)
+		doneErr = ctxErr(stdCtx, err)
+		c.ranStatement(err)
+		c.txSummary.statement(query, took, err)
+		inBatch.statement(hooks, took, err)
+
+		if !raw {
+			if fn := prepareExplain(stdCtx, c, hooks, query, args, took, err); fn != nil {
+				rows = explainRows(rows, fn)
+			}
+		}
+	}
+	if !raw {
+		rows = closeRows(wrapRows(rows, hooks, ctx), rows, hooks, ctx, c.clock, start, took, c.abandonedRows)
 	}
 
-	// Not implemented by underlying driver
-	return nil, driver.ErrSkip
+	if t, ok := asQueryer(hooks); ok {
+		ctx.Error = err
+		ctx.CtxErr = doneErr
+		ctx.Start = start.wall
+		ctx.Duration = took
+		ctx.Path = path
+		ctx.setRows(rows)
+		if c.cache != nil {
+			ctx.StmtCache = c.cache.stats
+		}
+		if !c.failedAttempt(stdCtx, retryQuery, retryArgs, attempts, err, ctx, func() { t.AfterQuery(ctx) }) {
+			hooksStart := c.diag.now()
+			err = c.diag.checkAfter("Query", rows == nil, ctx.Error, t.AfterQuery(ctx))
+			c.diag.afterDone(ctx, hooksStart)
+		}
+	}
+
+	return rows, err
 }
 
-func (c conn) Exec(query string, args []driver.Value) (driver.Result, error) {
-	if execer, ok := c.Conn.(driver.Execer); ok {
-		var ctx *Context
-		if t, ok := c.hooks.(Execer); ok {
-			ctx = NewContext()
-			ctx.Query = query
-			ctx.Args = driverToInterface(args)
+// driverQuery runs query using a cached statement if available,
+// or the underlying driver.QueryerContext or driver.Queryer otherwise
+func (c *conn) driverQuery(stdCtx context.Context, query string, args []driver.Value) (driver.Rows, Path, error) {
+	// Cached statements may only have the legacy Stmt methods, taking no named arguments
+	if c.cache != nil && checkLegacyArgs(args) == nil {
+		if s := c.cache.get(query, len(args)); s != nil {
+			rows, err := c.cache.query(stdCtx, s, args)
+			return rows, PathStmtCache, err
+		}
+	}
 
-			if err := t.BeforeExec(ctx); err != nil {
-				return nil, err
-			}
+	switch queryer := c.Conn.(type) {
+	case driver.QueryerContext:
+		rows, err := queryer.QueryContext(stdCtx, query, valuesToNamed(args))
+		return rows, PathQueryerContext, err
+	case driver.Queryer:
+		if err := stdCtx.Err(); err != nil {
+			return nil, PathQueryer, err
+		}
+		if err := checkLegacyArgs(args); err != nil {
+			return nil, PathQueryer, err
+		}
+		rows, err := queryer.Query(query, args)
+		return rows, PathQueryer, err
+	}
 
-			query = ctx.Query
-			args = interfaceToDriver(ctx.Args)
+	return nil, PathNone, driver.ErrSkip
+}
+
+// preparedQuery runs query as a statement prepared on the underlying
+// connection, closed along with its rows. That's what database/sql does when
+// the driver skips a query, doing it here once the Query hooks ran keeps them
+// from running a second time, as Prepare and StmtQuery hooks, for the same
+// statement.
+func (c *conn) preparedQuery(stdCtx context.Context, query string, args []driver.Value) (driver.Rows, Path, error) {
+	s, err := c.preparedStmt(stdCtx, query, args)
+	if err != nil {
+		return nil, PathPreparedFallback, err
+	}
+	rows, _, err := s.driverQuery(stdCtx, args)
+	if err != nil {
+		s.Stmt.Close()
+		return nil, PathPreparedFallback, err
+	}
+	return forwardRows(stmtRows{rows, s.Stmt}, rows), PathPreparedFallback, nil
+}
+
+// preparedStmt prepares query on the underlying connection for preparedQuery
+// and preparedExec, checking the number of args as database/sql would
+func (c *conn) preparedStmt(stdCtx context.Context, query string, args []driver.Value) (*stmt, error) {
+	ds, _, err := c.driverPrepare(stdCtx, query)
+	if err != nil {
+		return nil, err
+	}
+	if n := ds.NumInput(); n >= 0 && n != len(args) {
+		ds.Close()
+		return nil, fmt.Errorf("sql: expected %d arguments, got %d", n, len(args))
+	}
+	return &stmt{Stmt: ds, conn: c, query: query}, nil
+}
+
+func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return c.hookedExec(context.Background(), query, args)
+}
 
+func (c *conn) ExecContext(stdCtx context.Context, query string, nargs []driver.NamedValue) (driver.Result, error) {
+	if c.ops&OpExec == 0 || IsUnhooked(stdCtx) {
+		// Straight to the driver, without converting the arguments back and forth
+		if execer, ok := c.Conn.(driver.ExecerContext); ok && c.cache == nil {
+			res, err := execer.ExecContext(stdCtx, query, nargs)
+			c.diag.dispatched(PathExecerContext)
+			c.ranStatement(err)
+			c.updateSchema(query, err)
+			return res, err
 		}
+	}
 
-		res, err := execer.Exec(query, args)
+	args, err := namedToValues(nargs)
+	if err != nil {
+		return nil, err
+	}
+	return c.hookedExec(stdCtx, query, args)
+}
 
-		if t, ok := c.hooks.(Execer); ok {
-			ctx.Error = err
-			err = t.AfterExec(ctx)
+func (c *conn) hookedExec(stdCtx context.Context, query string, args []driver.Value) (driver.Result, error) {
+	switch c.Conn.(type) {
+	case driver.ExecerContext, driver.Execer:
+	default:
+		if c.cache == nil {
+			// Not implemented by underlying driver
+			return nil, driver.ErrSkip
 		}
+	}
 
+	if c.ops&OpExec == 0 || IsUnhooked(stdCtx) {
+		res, path, err := c.driverExec(stdCtx, query, args)
+		c.diag.dispatched(path)
+		c.ranStatement(err)
+		c.updateSchema(query, err)
 		return res, err
 	}
 
-	// Not implemented by underlying driver
-	return nil, driver.ErrSkip
+	all := c.callHooks(stdCtx)
+	sp, spName := c.savepoint(all, query)
+	hooks := c.execHooks(all, sp)
+	inBatch := c.batch(stdCtx)
+	attempts, held := c.startAttempt(stdCtx, query, args)
+	retryQuery, retryArgs := query, args
+
+	var ctx *Context
+	var res driver.Result
+	synthetic := false
+	if _, ok := asExecer(hooks); ok && held != nil {
+		// The Before hooks ran for the first attempt
+		ctx = held
+		c.retried(ctx, attempts)
+		ctx.BatchID = inBatch.id()
+		if !c.collapseInLists {
+			query = ctx.Query
+		}
+		args = c.heldArgs(ctx, args)
+	} else if t, ok := asExecer(hooks); ok {
+		ctx = c.newContext()
+		ctx.Ctx = stdCtx
+		ctx.Query = query
+		ctx.Args = driverToInterface(args)
+		ctx.ArgTypes = c.takeArgTypes()
+		if c.argsSize {
+			ctx.ArgsSize = ArgsSize(ctx.Args)
+		}
+		if c.collapseInLists {
+			ctx.Query, ctx.Args = CollapseInLists(query, ctx.Args)
+		}
+		c.hideArgs(ctx, ctx.Query)
+		ctx.Migration = c.migration(ctx)
+		ctx.Internal = IsInternal(ctx.Ctx)
+		ctx.TxID = c.txID
+		ctx.BatchID = inBatch.id()
+		ctx.ConnStatement = c.nextStatement()
+		ctx.attempted(attempts)
+
+		hooksStart := c.diag.now()
+		if err := t.BeforeExec(ctx); err != nil {
+			return nil, err
+		}
+		c.diag.beforeDone(ctx, hooksStart)
+
+		if !c.collapseInLists {
+			query = ctx.Query
+			if c.argsVisible() {
+				args = interfaceToDriver(ctx.Args)
+			}
+		}
+		res, synthetic = ctx.substitutedResult()
+	}
+
+	start := c.clock()
+	var took time.Duration
+	var err, doneErr, txErr error
+	path := PathNone
+	txKind := notTxStatement
+	if !synthetic {
+		if err = c.checkArgs(query, len(args)); err == nil {
+			b := c.heartbeats.start(hooks, ctx, c.clock, start)
+			res, path, err = c.driverExec(stdCtx, query, args)
+			if err == driver.ErrSkip && ctx != nil {
+				res, path, err = c.preparedExec(stdCtx, query, args)
+			}
+			c.heartbeats.stop(b)
+			c.diag.dispatched(path)
+		}
+		took = c.clock.since(start)
+		doneErr = ctxErr(stdCtx, err)
+		c.ranStatement(err)
+		c.txSummary.statement(query, took, err)
+		inBatch.statement(all, took, err)
+		c.updateSchema(query, err)
+
+		if fn := prepareExplain(stdCtx, c, hooks, query, args, took, err); fn != nil {
+			fn()
+		}
+
+		if err != driver.ErrSkip {
+			c.afterSavepoint(all, sp, spName, err)
+		}
+		txKind, txErr = c.txStatement(query), err
+		res = c.wrapResult(hooks, query, res)
+	}
+
+	if t, ok := asExecer(hooks); ok {
+		ctx.Error = err
+		ctx.CtxErr = doneErr
+		ctx.Start = start.wall
+		ctx.Duration = took
+		ctx.Path = path
+		ctx.Result = res
+		if c.cache != nil {
+			ctx.StmtCache = c.cache.stats
+		}
+		if !c.failedAttempt(stdCtx, retryQuery, retryArgs, attempts, err, ctx, func() { t.AfterExec(ctx) }) {
+			hooksStart := c.diag.now()
+			err = c.diag.checkAfter("Exec", res == nil, ctx.Error, t.AfterExec(ctx))
+			c.diag.afterDone(ctx, hooksStart)
+		}
+	}
+	c.afterTxStatement(stdCtx, txKind, start, took, txErr)
+
+	return res, err
+}
+
+// driverExec runs query using a cached statement if available,
+// or the underlying driver.ExecerContext or driver.Execer otherwise
+func (c *conn) driverExec(stdCtx context.Context, query string, args []driver.Value) (driver.Result, Path, error) {
+	// Cached statements may only have the legacy Stmt methods, taking no named arguments
+	if c.cache != nil && checkLegacyArgs(args) == nil {
+		if s := c.cache.get(query, len(args)); s != nil {
+			res, err := c.cache.exec(stdCtx, s, args)
+			return res, PathStmtCache, err
+		}
+	}
+
+	switch execer := c.Conn.(type) {
+	case driver.ExecerContext:
+		res, err := execer.ExecContext(stdCtx, query, valuesToNamed(args))
+		return res, PathExecerContext, err
+	case driver.Execer:
+		if err := stdCtx.Err(); err != nil {
+			return nil, PathExecer, err
+		}
+		if err := checkLegacyArgs(args); err != nil {
+			return nil, PathExecer, err
+		}
+		res, err := execer.Exec(query, args)
+		return res, PathExecer, err
+	}
+
+	return nil, PathNone, driver.ErrSkip
 }
 
-func (c conn) Close() error {
-	return c.Conn.Close()
+// preparedExec is preparedQuery for the statements skipped by driverExec
+func (c *conn) preparedExec(stdCtx context.Context, query string, args []driver.Value) (driver.Result, Path, error) {
+	s, err := c.preparedStmt(stdCtx, query, args)
+	if err != nil {
+		return nil, PathPreparedFallback, err
+	}
+	defer s.Stmt.Close()
+	res, _, err := s.driverExec(stdCtx, args)
+	return res, PathPreparedFallback, err
+}
+
+func (c *conn) Close() error {
+	if c.cache != nil {
+		c.cache.close()
+	}
+	c.data.clear()
+	if c.txOrigin == TxOriginStatement {
+		// The database rolls the transaction back, without any statement
+		c.endTx()
+	}
+	err := c.Conn.Close()
+	c.closed()
+	return err
 }
 
-func (c conn) Begin() (driver.Tx, error) {
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.begin(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(stdCtx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.begin(stdCtx, opts)
+}
+
+func (c *conn) driverBegin(stdCtx context.Context, opts driver.TxOptions) (driver.Tx, Path, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		_tx, err := b.BeginTx(stdCtx, opts)
+		return _tx, PathBeginTx, err
+	}
+
+	// Same checks database/sql does for drivers not implementing ConnBeginTx
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, PathBegin, errors.New("sqlhooks: driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, PathBegin, errors.New("sqlhooks: driver does not support read-only transactions")
+	}
+
+	if err := stdCtx.Err(); err != nil {
+		return nil, PathBegin, err
+	}
+	_tx, err := c.Conn.Begin()
+	return _tx, PathBegin, err
+}
+
+// begin runs the Begin hooks around driverBegin, the transaction id is assigned
+// before, so a failed Begin still gets its own, never reused, id. No state is
+// kept for a failed Begin, as no Commit or Rollback follows it.
+func (c *conn) begin(stdCtx context.Context, opts driver.TxOptions) (driver.Tx, error) {
 	var ctx *Context
+	begun := c.clock()
+	id := atomic.AddUint64(&txIDs, 1)
+	hooks := c.hooksFor(stdCtx, OpBegin)
 
-	if t, ok := c.hooks.(Beginner); ok {
-		ctx = NewContext()
+	if t, ok := asBeginner(hooks); ok {
+		ctx = c.newContext()
+		ctx.Ctx = stdCtx
+		ctx.TxID = id
+		ctx.TxOrigin = beginOrigin(opts)
+		ctx.TxOptions = opts
+		ctx.Migration = c.migration(ctx)
+		ctx.Internal = IsInternal(ctx.Ctx)
+		if c.pool != nil {
+			ctx.PoolWait = c.pool.wait()
+		}
 
+		hooksStart := c.diag.now()
 		if err := t.BeforeBegin(ctx); err != nil {
 			return nil, err
 		}
+		c.diag.beforeDone(ctx, hooksStart)
 	}
 
-	_tx, err := c.Conn.Begin()
+	start := c.clock()
+	_tx, path, err := c.driverBegin(stdCtx, opts)
+	took := c.clock.since(start)
+	c.diag.dispatched(path)
+	c.broke(err)
+	doneErr := ctxErr(stdCtx, err)
+	var summary *txSummary
+	if err == nil {
+		summary = c.startTx(stdCtx, id, beginOrigin(opts), begun)
+	}
 
-	if t, ok := c.hooks.(Beginner); ok {
+	if t, ok := asBeginner(hooks); ok {
 		ctx.Error = err
-		err = t.AfterBegin(ctx)
+		ctx.CtxErr = doneErr
+		ctx.Start = start.wall
+		ctx.Duration = took
+		ctx.Path = path
+		hooksStart := c.diag.now()
+		err = c.diag.checkAfter("Begin", _tx == nil, ctx.Error, t.AfterBegin(ctx))
+		c.diag.afterDone(ctx, hooksStart)
 	}
 
-	return tx{_tx, c.hooks, ctx}, err
+	t := tx{_tx, ctx, stdCtx, c, id, summary}
+	if err != nil && summary != nil {
+		// An After hook failed the Begin, database/sql drops the transaction
+		t.abort(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
 // Driver it's a proxy for a specific sql driver
 type Driver struct {
-	driver driver.Driver
-	name   string
-	hooks  HookType
+	// diag comes first for the alignment of its 64-bit counters
+	diag diagnostics
+
+	// driverMu guards driver, looked up by the first Open or OpenConnector
+	driverMu sync.Mutex
+	driver   driver.Driver
+	name     string
+
+	// hooks holds the hooksValue in use, defaults are the SetDefaultHooks
+	// hooks at the time the Driver was created
+	hooks    atomic.Value
+	defaults HookType
+
+	lazy               bool
+	ops                Op
+	stmtCacheSize      int
+	stmtCacheThreshold int
+	skipSavepointExec  bool
+	txStatements       bool
+	copyRowHooks       bool
+	resultWrapping     bool
+	hookTimeout        time.Duration
+	argsSize           bool
+	argTypes           bool
+	collapseInLists    bool
+	abandonedRowsCheck bool
+	rawRowsPrefixes    []string
+	argVisibility      ArgVisibility
+	redactor           Redactor
+	argCountCheck      bool
+	argCountWarn       bool
+	traceExtractor     TraceExtractor
+	onConnect          []func(context.Context, *conn) error
+	selectHooks        func(dsn string) HookType
+	pool               *poolWaits
+	system             SystemInfo
+	clock              clock
+	heartbeats         *heartbeats
+	retries            *retries
+	selfTestQuery      string
+
+	// shutdown is set by Shutdown
+	shutdown uint32
 }
 
 // NewDriver will create a Proxy Driver with defined Hooks
-// name is the underlying driver name
-func NewDriver(name string, hooks HookType) *Driver {
-	return &Driver{name: name, hooks: hooks}
+// name is the underlying driver name, looked up on the first connection,
+// NewDriverE reports a missing one straight away
+// hooks run after the ones set with SetDefaultHooks, if any
+func NewDriver(name string, hooks HookType, opts ...Option) *Driver {
+	d := &Driver{name: name, defaults: getDefaultHooks(), ops: OpAll, rawRowsPrefixes: DefaultRawRowsPrefixes, system: system(name), clock: systemClock}
+	for _, opt := range opts {
+		opt(d)
+	}
+	// After the options, the hooks are bounded by WithHookTimeout
+	d.SetHooks(hooks)
+	return d
 }
 
-// Open returns a new connection to the database, using the underlying specified driver
-func (d *Driver) Open(dsn string) (driver.Conn, error) {
+// NewDriverE is NewDriver, failing if no driver is registered as name,
+// unless WithLazyDriver is given
+func NewDriverE(name string, hooks HookType, opts ...Option) (*Driver, error) {
+	d := NewDriver(name, hooks, opts...)
+	if !d.lazy {
+		if err := checkRegistered(name); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// checkRegistered returns an error listing the registered drivers if name isn't one of them
+func checkRegistered(name string) error {
+	names := sql.Drivers()
+	for _, registered := range names {
+		if registered == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("sqlhooks: unknown driver %q (forgotten import?), registered drivers: %s", name, strings.Join(names, ", "))
+}
+
+// hooksValue holds the hooks given to SetHooks, and all the ones to run,
+// bounded by WithHookTimeout as well, none once the Driver is shut down
+type hooksValue struct {
+	hooks    HookType
+	all      HookType
+	bounded  HookType
+	shutdown bool
+}
+
+// SetHooks replaces the hooks of d, which still run after the default ones.
+// It's safe to call while d is in use: each operation uses the hooks set
+// when it starts, for both its Before and After hooks and for its rows,
+// and every operation starting after SetHooks returns uses the new ones.
+// Commit and Rollback use the hooks set when they're called. The hooks
+// implementing InternalReporter report their events to d.
+// It has no effect once d is shut down.
+func (d *Driver) SetHooks(hooks HookType) {
+	if atomic.LoadUint32(&d.shutdown) != 0 {
+		return
+	}
+	if t, ok := hooks.(InternalReporter); ok {
+		t.SetInternalReporter(d.diag.report)
+	}
+	all := hooks
+	if d.defaults != nil {
+		all = Compose(d.defaults, hooks)
+	}
+	d.hooks.Store(hooksValue{hooks: hooks, all: all, bounded: bound(all, d.hookTimeout, &d.diag)})
+}
+
+// Hooks returns the hooks of d, as given to NewDriver or SetHooks
+func (d *Driver) Hooks() HookType {
+	return d.hooks.Load().(hooksValue).hooks
+}
+
+// underlying returns the underlying driver, using dsn to look it up the first time,
+// it's safe to call concurrently and looks it up again after a failed lookup
+func (d *Driver) underlying(dsn string) (driver.Driver, error) {
+	d.driverMu.Lock()
+	defer d.driverMu.Unlock()
 	if d.driver == nil {
 		// Get Driver by Opening a new connection
 		db, err := sql.Open(d.name, dsn)
@@ -252,7 +1464,85 @@ func (d *Driver) Open(dsn string) (driver.Conn, error) {
 		}
 		d.driver = db.Driver()
 	}
+	return d.driver, nil
+}
+
+// Open returns a new connection to the database, using the underlying specified driver
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	_driver, err := d.underlying(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	_conn, err := _driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return d.wrap(context.Background(), dsn, _conn)
+}
+
+// wrap returns _conn, opened with dsn, with the hooks attached, once the
+// WithOnConnect functions succeeded on it
+func (d *Driver) wrap(stdCtx context.Context, dsn string, _conn driver.Conn) (driver.Conn, error) {
+	var selected HookType
+	if d.selectHooks != nil {
+		if selected = d.selectHooks(dsn); selected == nil {
+			return _conn, nil
+		}
+		if d.defaults != nil {
+			selected = Compose(d.defaults, selected)
+		}
+	}
+
+	c := &conn{
+		Conn:              _conn,
+		id:                atomic.AddUint64(&connIDs, 1),
+		hooks:             &d.hooks,
+		selected:          selected,
+		boundSelected:     bound(selected, d.hookTimeout, &d.diag),
+		diag:              &d.diag,
+		traceExtractor:    d.traceExtractor,
+		ops:               d.ops,
+		skipSavepointExec: d.skipSavepointExec,
+		txStatements:      d.txStatements,
+		copyRowHooks:      d.copyRowHooks,
+		resultWrapping:    d.resultWrapping,
+		hookTimeout:       d.hookTimeout,
+		argsSize:          d.argsSize,
+		argTypes:          d.argTypes,
+		collapseInLists:   d.collapseInLists,
+		abandonedRows:     d.abandonedRowsCheck,
+		rawRowsPrefixes:   d.rawRowsPrefixes,
+		argVisibility:     d.argVisibility,
+		redactor:          d.redactor,
+		argCountCheck:     d.argCountCheck,
+		argCountWarn:      d.argCountWarn,
+		migrations:        isMigrationDriver(d.name),
+		pool:              d.pool,
+		driverName:        d.name,
+		system:            d.system,
+		clock:             d.clock,
+		heartbeats:        d.heartbeats,
+		retries:           d.retries,
+		opened:            d.clock(),
+	}
+	c.data.schema = schemaFromDSN(dsn)
+	c.data.raw, c.data.dsn = _conn, SanitizeDSN(dsn)
+	if d.stmtCacheSize > 0 {
+		c.cache = newStmtCache(_conn, c.diag, d.stmtCacheSize, d.stmtCacheThreshold)
+	}
+
+	for _, fn := range d.onConnect {
+		if err := fn(stdCtx, c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
 
-	_conn, err := d.driver.Open(dsn)
-	return conn{_conn, d.hooks}, err
+// Stats returns a snapshot of the internal events counters,
+// the Driver of a *sql.DB returned by Open can be found with db.Driver().(*sqlhooks.Driver)
+func (d *Driver) Stats() Stats {
+	return d.diag.stats()
 }
@@ -0,0 +1,419 @@
+// Package drivertest provides an in-memory database/sql driver whose
+// statements fail, take time or return rows as they say, to test hooks
+// against the failures of a real driver without one.
+//
+// The driver is registered as "drivertest". Statements start with
+// directives, separated from the rest of the statement by |:
+//
+//	SLEEP 100ms|SELECT ...     takes 100ms, or until the context is done
+//	FAIL badconn|INSERT ...    fails with driver.ErrBadConn
+//	FAIL deadlock|UPDATE ...   fails with the error "drivertest: deadlock"
+//	ROWS 3|SELECT ...          returns 3 rows of a single n column, 1 to 3
+//	AFFECTED 2|DELETE ...      affects 2 rows
+//	KILL|SELECT ...            succeeds, then the server kills the connection
+//
+// Directives combine, as in SLEEP 10ms|ROWS 2|SELECT .... FAIL badconn,
+// canceled and deadline fail with driver.ErrBadConn, context.Canceled and
+// context.DeadlineExceeded, any other reason with an error of its own.
+// Directives run when the statement is executed, the ones prefixed with
+// PREPARE when it's prepared instead, as in PREPARE FAIL badconn|....
+// Statements without directives succeed right away, returning no rows.
+//
+// Statements starting with EXPLAIN return the plan SetPlan set for the
+// statement they explain, the text following EXPLAIN and its options in
+// parentheses, as a single row of a single QUERY PLAN column, or no rows
+// without one.
+//
+// Every operation on a killed connection fails with driver.ErrBadConn, as
+// on a connection the server closed, and in the context mode the
+// connection reports it from ResetSession and IsValid, for database/sql to
+// discard it before it's reused.
+//
+// The DSN chooses the driver interfaces the connections implement, so both
+// code paths of a wrapper are exercised:
+//
+//	context  the context ones: ExecerContext, QueryerContext, ConnBeginTx,
+//	         StmtExecContext... the default
+//	legacy   the ones without context: Execer, Queryer and Stmt
+//	prepare  none of Execer and Queryer, so database/sql prepares every statement
+//
+// database/sql pools connections by DSN, use a DSN of the form mode;name to
+// open databases of their own with the same mode, as "legacy;test1".
+package drivertest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name is the name the driver is registered as
+const Name = "drivertest"
+
+func init() {
+	sql.Register(Name, Driver{})
+}
+
+// Modes are the DSN modes, see the package documentation
+const (
+	ModeContext = "context"
+	ModeLegacy  = "legacy"
+	ModePrepare = "prepare"
+)
+
+// Driver is the drivertest driver
+type Driver struct{}
+
+// Open opens a connection in the mode of dsn
+func (Driver) Open(dsn string) (driver.Conn, error) {
+	mode := dsn
+	if i := strings.IndexByte(dsn, ';'); i >= 0 {
+		mode = dsn[:i]
+	}
+
+	c := &conn{}
+	switch mode {
+	case "", ModeContext:
+		return &contextConn{legacyConn{c}}, nil
+	case ModeLegacy:
+		return &legacyConn{c}, nil
+	case ModePrepare:
+		return c, nil
+	}
+	return nil, fmt.Errorf("drivertest: unknown mode %q", mode)
+}
+
+var plans struct {
+	sync.Mutex
+	byStatement map[string]string
+}
+
+// SetPlan sets the plan EXPLAIN returns for statement, directives included,
+// an empty plan removes it
+func SetPlan(statement, plan string) {
+	plans.Lock()
+	defer plans.Unlock()
+	if plans.byStatement == nil {
+		plans.byStatement = make(map[string]string)
+	}
+	if plan == "" {
+		delete(plans.byStatement, statement)
+	} else {
+		plans.byStatement[statement] = plan
+	}
+}
+
+// explained returns the statement query explains, false if it's not an EXPLAIN
+func explained(query string) (string, bool) {
+	if !strings.HasPrefix(query, "EXPLAIN ") {
+		return "", false
+	}
+	statement := strings.TrimLeft(query[len("EXPLAIN "):], " ")
+	if strings.HasPrefix(statement, "(") {
+		if i := strings.IndexByte(statement, ')'); i >= 0 {
+			statement = strings.TrimLeft(statement[i+1:], " ")
+		}
+	}
+	return statement, true
+}
+
+// explainStmt returns the statement returning the plan of query, nil if it's not an EXPLAIN
+func (c *conn) explainStmt(query string) *stmt {
+	statement, ok := explained(query)
+	if !ok {
+		return nil
+	}
+	plans.Lock()
+	plan, ok := plans.byStatement[statement]
+	plans.Unlock()
+	s := &stmt{conn: c}
+	if ok {
+		s.plan = &plan
+	}
+	return s
+}
+
+// script is what the directives of a statement say
+type script struct {
+	sleep    time.Duration
+	fail     error
+	rows     int
+	affected int64
+	kill     bool
+}
+
+// parse returns the scripts of the preparation and the executions of query
+func parse(query string) (prepare, exec script, err error) {
+	for {
+		i := strings.IndexByte(query, '|')
+		if i < 0 {
+			return prepare, exec, nil
+		}
+		directive := strings.Fields(query[:i])
+		s := &exec
+		if len(directive) > 0 && directive[0] == "PREPARE" {
+			s, directive = &prepare, directive[1:]
+		}
+		if len(directive) == 1 && directive[0] == "KILL" {
+			s.kill = true
+			query = query[i+1:]
+			continue
+		}
+		if len(directive) != 2 {
+			return prepare, exec, nil
+		}
+
+		switch directive[0] {
+		case "SLEEP":
+			s.sleep, err = time.ParseDuration(directive[1])
+		case "FAIL":
+			s.fail = failure(directive[1])
+		case "ROWS":
+			s.rows, err = strconv.Atoi(directive[1])
+		case "AFFECTED":
+			s.affected, err = strconv.ParseInt(directive[1], 10, 64)
+		default:
+			return prepare, exec, nil
+		}
+		if err != nil {
+			return prepare, exec, fmt.Errorf("drivertest: invalid directive %q: %v", query[:i], err)
+		}
+		query = query[i+1:]
+	}
+}
+
+func failure(reason string) error {
+	switch reason {
+	case "badconn":
+		return driver.ErrBadConn
+	case "canceled":
+		return context.Canceled
+	case "deadline":
+		return context.DeadlineExceeded
+	}
+	return errors.New("drivertest: " + reason)
+}
+
+// run sleeps and fails as s says on c, the sleep ends early once ctx is done
+func (s script) run(ctx context.Context, c *conn) error {
+	if c.killed {
+		return driver.ErrBadConn
+	}
+	c.killed = s.kill
+	if s.sleep > 0 {
+		t := time.NewTimer(s.sleep)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.fail
+}
+
+// conn implements the prepare mode, the other ones embed it, killed is
+// set by KILL
+type conn struct {
+	killed bool
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.prepare(context.Background(), query)
+}
+
+func (c *conn) prepare(ctx context.Context, query string) (*stmt, error) {
+	if s := c.explainStmt(query); s != nil {
+		return s, nil
+	}
+	prepare, exec, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepare.run(ctx, c); err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, script: exec}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) {
+	if c.killed {
+		return nil, driver.ErrBadConn
+	}
+	return tx{}, nil
+}
+
+// statement returns the statement of query run without being prepared
+func (c *conn) statement(query string) (*stmt, error) {
+	if s := c.explainStmt(query); s != nil {
+		return s, nil
+	}
+	_, exec, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, script: exec}, nil
+}
+
+func (c *conn) exec(ctx context.Context, query string) (driver.Result, error) {
+	s, err := c.statement(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.exec(ctx)
+}
+
+func (c *conn) query(ctx context.Context, query string) (driver.Rows, error) {
+	s, err := c.statement(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.query(ctx)
+}
+
+// legacyConn implements the legacy mode
+type legacyConn struct {
+	*conn
+}
+
+func (c *legacyConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return c.exec(context.Background(), query)
+}
+
+func (c *legacyConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.query(context.Background(), query)
+}
+
+// contextConn implements the context mode
+type contextConn struct {
+	legacyConn
+}
+
+func (c *contextConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return contextStmt{s}, nil
+}
+
+func (c *contextConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Begin()
+}
+
+func (c *contextConn) ResetSession(ctx context.Context) error {
+	if c.killed {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *contextConn) IsValid() bool { return !c.killed }
+
+func (c *contextConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.exec(ctx, query)
+}
+
+func (c *contextConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.query(ctx, query)
+}
+
+// stmt is a statement, plan is set for the EXPLAIN ones with a plan
+type stmt struct {
+	conn   *conn
+	script script
+	plan   *string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.exec(context.Background())
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.query(context.Background())
+}
+
+func (s *stmt) exec(ctx context.Context) (driver.Result, error) {
+	if err := s.script.run(ctx, s.conn); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(s.script.affected), nil
+}
+
+func (s *stmt) query(ctx context.Context) (driver.Rows, error) {
+	if err := s.script.run(ctx, s.conn); err != nil {
+		return nil, err
+	}
+	if s.plan != nil {
+		return &planRows{plan: *s.plan}, nil
+	}
+	return &rows{n: s.script.rows}, nil
+}
+
+// contextStmt is the statement of the context mode
+type contextStmt struct {
+	*stmt
+}
+
+func (s contextStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.exec(ctx)
+}
+
+func (s contextStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.query(ctx)
+}
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+// rows returns the rows 1 to n
+type rows struct {
+	n, next int
+}
+
+func (r *rows) Columns() []string { return []string{"n"} }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next == r.n {
+		return io.EOF
+	}
+	r.next++
+	dest[0] = int64(r.next)
+	return nil
+}
+
+// planRows returns plan as a single row
+type planRows struct {
+	plan string
+	done bool
+}
+
+func (r *planRows) Columns() []string { return []string{"QUERY PLAN"} }
+func (r *planRows) Close() error      { return nil }
+
+func (r *planRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.plan
+	return nil
+}
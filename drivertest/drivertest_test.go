@@ -0,0 +1,157 @@
+package drivertest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var modes = []string{ModeContext, ModeLegacy, ModePrepare}
+
+func open(t *testing.T, mode string) *sql.DB {
+	db, err := sql.Open(Name, mode+";"+t.Name())
+	require.NoError(t, err)
+	return db
+}
+
+func TestModes(t *testing.T) {
+	for mode, expected := range map[string]driver.Conn{
+		"":          &contextConn{},
+		ModeContext: &contextConn{},
+		ModeLegacy:  &legacyConn{},
+		ModePrepare: &conn{},
+	} {
+		c, err := Driver{}.Open(mode + ";db")
+		require.NoError(t, err)
+		assert.IsType(t, expected, c, mode)
+	}
+
+	_, err := Driver{}.Open("other")
+	assert.EqualError(t, err, `drivertest: unknown mode "other"`)
+}
+
+func TestDirectives(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(mode, func(t *testing.T) {
+			db := open(t, mode)
+			defer db.Close()
+
+			res, err := db.Exec("AFFECTED 2|DELETE FROM t")
+			require.NoError(t, err)
+			n, err := res.RowsAffected()
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), n)
+
+			rows, err := db.Query("ROWS 3|SELECT n FROM t")
+			require.NoError(t, err)
+			var ns []int
+			for rows.Next() {
+				var n int
+				require.NoError(t, rows.Scan(&n))
+				ns = append(ns, n)
+			}
+			require.NoError(t, rows.Err())
+			assert.Equal(t, []int{1, 2, 3}, ns)
+
+			_, err = db.Exec("FAIL badconn|INSERT INTO t VALUES (1)")
+			assert.Equal(t, driver.ErrBadConn, err)
+			_, err = db.Exec("FAIL deadlock|UPDATE t SET n = 1")
+			assert.EqualError(t, err, "drivertest: deadlock")
+
+			start := time.Now()
+			_, err = db.Exec("SLEEP 20ms|AFFECTED 1|UPDATE t SET n = 1")
+			require.NoError(t, err)
+			assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+			_, err = db.Exec("SELECT 1")
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestPrepareDirectives(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(mode, func(t *testing.T) {
+			db := open(t, mode)
+			defer db.Close()
+
+			_, err := db.Prepare("PREPARE FAIL syntax|SELECT")
+			assert.EqualError(t, err, "drivertest: syntax")
+
+			stmt, err := db.Prepare("PREPARE SLEEP 1ms|FAIL locked|SELECT 1")
+			require.NoError(t, err)
+			defer stmt.Close()
+			_, err = stmt.Exec()
+			assert.EqualError(t, err, "drivertest: locked")
+		})
+	}
+}
+
+func TestSleepEndsWithContext(t *testing.T) {
+	db := open(t, ModeContext)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := db.ExecContext(ctx, "SLEEP 1s|UPDATE t SET n = 1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestInvalidDirective(t *testing.T) {
+	db := open(t, ModeContext)
+	defer db.Close()
+
+	_, err := db.Exec("SLEEP soon|SELECT 1")
+	assert.EqualError(t, err, `drivertest: invalid directive "SLEEP soon": time: invalid duration "soon"`)
+}
+
+func TestKill(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(mode, func(t *testing.T) {
+			c, err := Driver{}.Open(mode + ";" + t.Name())
+			require.NoError(t, err)
+			s, err := c.Prepare("KILL|UPDATE t SET n = 1")
+			require.NoError(t, err)
+			_, err = s.Exec(nil)
+			require.NoError(t, err, "the killing statement succeeds")
+
+			_, err = s.Exec(nil)
+			assert.Equal(t, driver.ErrBadConn, err)
+			_, err = c.Prepare("SELECT 1")
+			assert.Equal(t, driver.ErrBadConn, err)
+			_, err = c.Begin()
+			assert.Equal(t, driver.ErrBadConn, err)
+			if v, ok := c.(interface{ IsValid() bool }); ok {
+				assert.Equal(t, mode == ModeContext, !v.IsValid())
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	const statement = "SLEEP 1s|SELECT * FROM t WHERE id = ?"
+	SetPlan(statement, `[{"Plan": {"Node Type": "Seq Scan"}}]`)
+	defer SetPlan(statement, "")
+
+	for _, mode := range modes {
+		t.Run(mode, func(t *testing.T) {
+			db := open(t, mode)
+			defer db.Close()
+
+			var plan string
+			require.NoError(t, db.QueryRow("EXPLAIN (FORMAT JSON) "+statement, 1).Scan(&plan))
+			assert.Equal(t, `[{"Plan": {"Node Type": "Seq Scan"}}]`, plan, "the statement doesn't run")
+			require.NoError(t, db.QueryRow("EXPLAIN "+statement, 1).Scan(&plan))
+
+			err := db.QueryRow("EXPLAIN SELECT 2").Scan(&plan)
+			assert.Equal(t, sql.ErrNoRows, err, "no plan set")
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failureHooks keeps the Context of every statement the driver ran, and of
+// every rows closed
+type failureHooks struct {
+	mu     sync.Mutex
+	ran    []Context
+	closed []Context
+}
+
+func (h *failureHooks) after(ctx *Context) error {
+	h.mu.Lock()
+	h.ran = append(h.ran, *ctx)
+	h.mu.Unlock()
+	return ctx.Error
+}
+
+// last returns the Context of the last statement the driver ran
+func (h *failureHooks) last() Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ran[len(h.ran)-1]
+}
+
+func (h *failureHooks) BeforeQuery(ctx *Context) error     { return nil }
+func (h *failureHooks) AfterQuery(ctx *Context) error      { return h.after(ctx) }
+func (h *failureHooks) BeforeExec(ctx *Context) error      { return nil }
+func (h *failureHooks) AfterExec(ctx *Context) error       { return h.after(ctx) }
+func (h *failureHooks) BeforePrepare(ctx *Context) error   { return nil }
+func (h *failureHooks) AfterPrepare(ctx *Context) error    { return h.after(ctx) }
+func (h *failureHooks) BeforeStmtQuery(ctx *Context) error { return nil }
+func (h *failureHooks) AfterStmtQuery(ctx *Context) error  { return h.after(ctx) }
+func (h *failureHooks) BeforeStmtExec(ctx *Context) error  { return nil }
+func (h *failureHooks) AfterStmtExec(ctx *Context) error   { return h.after(ctx) }
+
+func (h *failureHooks) AfterRowsClose(ctx *Context) error {
+	h.mu.Lock()
+	h.closed = append(h.closed, *ctx)
+	h.mu.Unlock()
+	return ctx.Error
+}
+
+// openDriverTest opens a database of its own on the drivertest driver in mode
+func openDriverTest(t *testing.T, mode string) (*sql.DB, *failureHooks) {
+	hooks := &failureHooks{}
+	db, err := Open(drivertest.Name, mode+";"+t.Name(), hooks)
+	require.NoError(t, err)
+	return db, hooks
+}
+
+func TestDriverFailures(t *testing.T) {
+	for _, mode := range []string{drivertest.ModeContext, drivertest.ModeLegacy, drivertest.ModePrepare} {
+		t.Run(mode, func(t *testing.T) {
+			db, hooks := openDriverTest(t, mode)
+			defer db.Close()
+
+			_, err := db.Exec("FAIL badconn|INSERT INTO t VALUES (1)")
+			assert.Equal(t, driver.ErrBadConn, err)
+			ctx := hooks.last()
+			assert.Equal(t, "FAIL badconn|INSERT INTO t VALUES (1)", ctx.Query)
+			assert.Equal(t, driver.ErrBadConn, ctx.Error)
+			assert.Equal(t, ErrorClassBadConn, ctx.ErrorClass())
+
+			_, err = db.Exec("FAIL deadline|UPDATE t SET n = 1")
+			assert.Equal(t, context.DeadlineExceeded, err)
+			ctx = hooks.last()
+			assert.Equal(t, ErrorClassTimeout, ctx.ErrorClass())
+			assert.Nil(t, ctx.CtxErr, "the driver failed on its own")
+
+			_, err = db.Exec("SLEEP 20ms|UPDATE t SET n = 1")
+			require.NoError(t, err)
+			assert.True(t, hooks.last().Duration >= 20*time.Millisecond)
+
+			_, err = db.Prepare("PREPARE FAIL syntax|SELECT")
+			assert.EqualError(t, err, "drivertest: syntax")
+			assert.EqualError(t, hooks.last().Error, "drivertest: syntax")
+
+			rows, err := db.Query("ROWS 3|SELECT n FROM t")
+			require.NoError(t, err)
+			require.True(t, rows.Next())
+			require.NoError(t, rows.Close())
+			require.Len(t, hooks.closed, 1)
+			assert.Equal(t, int64(1), hooks.closed[0].RowsReturned)
+			assert.True(t, hooks.closed[0].Abandoned)
+		})
+	}
+}
+
+func TestDriverCanceledStatement(t *testing.T) {
+	db, hooks := openDriverTest(t, drivertest.ModeContext)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := db.ExecContext(ctx, "SLEEP 1s|UPDATE t SET n = 1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	last := hooks.last()
+	assert.Equal(t, context.DeadlineExceeded, last.Error)
+	assert.Equal(t, context.DeadlineExceeded, last.CtxErr)
+	assert.True(t, last.Duration < time.Second)
+}
+
+// txEvents records the transaction and Exec hooks, signaling every rollback
+type txEvents struct {
+	mu         sync.Mutex
+	events     []string
+	lastTxID   uint64
+	rolledBack chan Context
+}
+
+func (e *txEvents) add(event string, ctx *Context) {
+	e.mu.Lock()
+	e.events = append(e.events, event)
+	e.lastTxID = ctx.TxID
+	e.mu.Unlock()
+}
+
+func (e *txEvents) hooks() *FuncHooks {
+	record := func(op string) Funcs {
+		return Funcs{After: func(ctx *Context) error {
+			e.add(op, ctx)
+			return ctx.Error
+		}}
+	}
+	return &FuncHooks{
+		Begin:  record("begin"),
+		Commit: record("commit"),
+		Exec:   record("exec"),
+		Rollback: Funcs{After: func(ctx *Context) error {
+			e.add("rollback", ctx)
+			e.rolledBack <- *ctx
+			return ctx.Error
+		}},
+	}
+}
+
+func TestDriverCanceledTx(t *testing.T) {
+	events := &txEvents{}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), events.hooks())
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// released checks no transaction is left open, on the connection either
+	released := func() {
+		assert.Equal(t, int64(0), db.Driver().(*Driver).Stats().OpenTxs)
+		_, err := db.Exec("INSERT INTO t VALUES (0)")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), events.lastTxID)
+	}
+	begin := func() (*sql.Tx, context.Context, context.CancelFunc) {
+		events.mu.Lock()
+		events.events = nil
+		events.rolledBack = make(chan Context, 1)
+		events.mu.Unlock()
+		ctx, cancel := context.WithCancel(context.Background())
+		tx, err := db.BeginTx(ctx, nil)
+		require.NoError(t, err)
+		return tx, ctx, cancel
+	}
+	rolledBack := func() Context {
+		select {
+		case ctx := <-events.rolledBack:
+			return ctx
+		case <-time.After(time.Second):
+			t.Fatal("database/sql didn't roll back")
+		}
+		return Context{}
+	}
+
+	t.Run("before Exec", func(t *testing.T) {
+		tx, _, cancel := begin()
+		cancel()
+		ctx := rolledBack()
+		assert.True(t, ctx.CausedByContext)
+		assert.NoError(t, ctx.Error)
+
+		_, err := tx.Exec("INSERT INTO t VALUES (1)")
+		assert.Equal(t, sql.ErrTxDone, err)
+		assert.Equal(t, []string{"begin", "rollback"}, events.events, "the Exec doesn't reach the driver")
+		released()
+	})
+
+	t.Run("during Exec", func(t *testing.T) {
+		tx, ctx, cancel := begin()
+		time.AfterFunc(10*time.Millisecond, cancel)
+		_, err := tx.ExecContext(ctx, "SLEEP 1s|INSERT INTO t VALUES (2)")
+		assert.Equal(t, context.Canceled, err)
+		assert.True(t, rolledBack().CausedByContext)
+		assert.Equal(t, []string{"begin", "exec", "rollback"}, events.events)
+		released()
+	})
+
+	t.Run("before Commit", func(t *testing.T) {
+		tx, _, cancel := begin()
+		_, err := tx.Exec("INSERT INTO t VALUES (3)")
+		require.NoError(t, err)
+		cancel()
+		assert.True(t, rolledBack().CausedByContext)
+		assert.Equal(t, sql.ErrTxDone, tx.Commit())
+		assert.Equal(t, []string{"begin", "exec", "rollback"}, events.events, "no Commit hook")
+		released()
+	})
+
+	t.Run("explicit Rollback", func(t *testing.T) {
+		tx, _, cancel := begin()
+		defer cancel()
+		require.NoError(t, tx.Rollback())
+		assert.False(t, rolledBack().CausedByContext)
+		released()
+	})
+}
@@ -0,0 +1,216 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"time"
+)
+
+// ErrorClass is a coarse classification of operation errors, suitable as a metric label
+type ErrorClass string
+
+const (
+	ErrorClassNone          ErrorClass = ""
+	ErrorClassConstraint    ErrorClass = "constraint"
+	ErrorClassDeadlock      ErrorClass = "deadlock"
+	ErrorClassSerialization ErrorClass = "serialization"
+	ErrorClassTimeout       ErrorClass = "timeout"
+	ErrorClassCanceled      ErrorClass = "canceled"
+	// ErrorClassServerTimeout is a statement the server killed for running
+	// too long, as with the postgres statement_timeout or the MySQL max_execution_time
+	ErrorClassServerTimeout ErrorClass = "server_timeout"
+	ErrorClassBadConn       ErrorClass = "bad_conn"
+	// ErrorClassAuth is a connection the server refused the credentials of,
+	// as when an authentication token expired
+	ErrorClassAuth  ErrorClass = "auth"
+	ErrorClassOther ErrorClass = "other"
+)
+
+// sqlStater is implemented by the postgres drivers errors (lib/pq, pgx)
+type sqlStater interface {
+	SQLState() string
+}
+
+// timeouter is implemented by the network errors, as net.Error: the core
+// doesn't import net, see portable_test.go
+type timeouter interface {
+	Timeout() bool
+}
+
+// IsNotFound reports whether err is sql.ErrNoRows, or an error wrapping it.
+// database/sql returns it from the Scan of a QueryRow without rows, above
+// the driver: the query itself succeeded, its hooks got no error, as told by
+// Context.NoRows. Hooks given errors of database/sql results, as when an
+// application runs them itself, shouldn't count it as a failure.
+func IsNotFound(err error) bool {
+	for err != nil {
+		if err == sql.ErrNoRows {
+			return true
+		}
+		err = unwrap(err)
+	}
+	return false
+}
+
+// unwrap returns the error err wraps, nil if it doesn't wrap any: the core
+// builds without errors.Unwrap
+func unwrap(err error) error {
+	u, ok := err.(interface{ Unwrap() error })
+	if !ok {
+		return nil
+	}
+	return u.Unwrap()
+}
+
+// ClassifyError returns the class of err, ErrorClassNone if it's nil or
+// IsNotFound.
+// Postgres errors are classified by their SQLSTATE, MySQL ones by the error
+// number in their message, and SQLite ones by their message. An error
+// wrapping another, as with fmt.Errorf's %w, has the class of the first one
+// of its chain that is known.
+func ClassifyError(err error) ErrorClass {
+	if err == nil || IsNotFound(err) {
+		return ErrorClassNone
+	}
+	for e := err; e != nil; e = unwrap(e) {
+		if class := classifyOne(e); class != ErrorClassOther {
+			return class
+		}
+	}
+	return ErrorClassOther
+}
+
+// classifyOne returns the class of err alone, without its chain
+func classifyOne(err error) ErrorClass {
+	switch err {
+	case context.Canceled:
+		return ErrorClassCanceled
+	case context.DeadlineExceeded:
+		return ErrorClassTimeout
+	case driver.ErrBadConn:
+		return ErrorClassBadConn
+	}
+
+	if e, ok := err.(sqlStater); ok {
+		if class := classifySQLState(e.SQLState(), err.Error()); class != ErrorClassOther {
+			return class
+		}
+	}
+	if e, ok := err.(timeouter); ok && e.Timeout() {
+		return ErrorClassTimeout
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "Error 1062"), strings.HasPrefix(msg, "Error 1451"),
+		strings.HasPrefix(msg, "Error 1452"), strings.HasPrefix(msg, "Error 1048"),
+		strings.Contains(msg, "constraint failed"):
+		return ErrorClassConstraint
+	case strings.HasPrefix(msg, "Error 1213"):
+		return ErrorClassDeadlock
+	case strings.HasPrefix(msg, "Error 1205"):
+		return ErrorClassTimeout
+	case strings.HasPrefix(msg, "Error 3024"):
+		return ErrorClassServerTimeout
+	case strings.HasPrefix(msg, "Error 1045"):
+		return ErrorClassAuth
+	}
+	return ErrorClassOther
+}
+
+// ErrorClass returns the class of ctx.Error. An operation failing once its
+// Ctx is done, as told by ctx.CtxErr, is classified as canceled or timed out
+// by the client, whatever error the driver returned for it.
+func (ctx *Context) ErrorClass() ErrorClass {
+	switch {
+	case ctx.Error == nil, IsNotFound(ctx.Error):
+		return ErrorClassNone
+	case ctx.CtxErr == context.Canceled:
+		return ErrorClassCanceled
+	case ctx.CtxErr == context.DeadlineExceeded:
+		return ErrorClassTimeout
+	}
+	return ClassifyError(ctx.Error)
+}
+
+// deadlineSlack is how close to its deadline an operation can fail with a
+// cancellation or a timeout to be considered timed out by the client, as the
+// driver may give up just before the context expires
+const deadlineSlack = 5 * time.Millisecond
+
+// ctxErr returns, for an operation that failed with err, the error of
+// stdCtx once it completed, nil if it succeeded or stdCtx wasn't done.
+// An err that is itself a cancellation or a timeout, within deadlineSlack
+// of the deadline of stdCtx, is attributed to the deadline as well: any
+// other error, as a constraint violation, is a failure of its own.
+func ctxErr(stdCtx context.Context, err error) error {
+	if err == nil || stdCtx == nil {
+		return nil
+	}
+	if err := stdCtx.Err(); err != nil {
+		return err
+	}
+	deadline, ok := stdCtx.Deadline()
+	if !ok || time.Until(deadline) >= deadlineSlack {
+		return nil
+	}
+	switch ClassifyError(err) {
+	case ErrorClassCanceled, ErrorClassTimeout:
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// classifySQLState classifies a postgres error by its state, msg tells the
+// canceled queries from the timed out ones, both having the same state
+func classifySQLState(state, msg string) ErrorClass {
+	switch {
+	case strings.HasPrefix(state, "23"):
+		return ErrorClassConstraint
+	case state == "40P01":
+		return ErrorClassDeadlock
+	case state == "40001":
+		return ErrorClassSerialization
+	case state == "57014" && strings.Contains(msg, "user request"):
+		return ErrorClassCanceled
+	case state == "57014":
+		return ErrorClassServerTimeout
+	case strings.HasPrefix(state, "08"):
+		return ErrorClassBadConn
+	case strings.HasPrefix(state, "28"):
+		return ErrorClassAuth
+	}
+	return ErrorClassOther
+}
+
+// ConstraintName returns the name of the constraint err violates, as found
+// in the postgres, MySQL and SQLite messages, or "" if it's unknown
+func ConstraintName(err error) string {
+	if ClassifyError(err) != ErrorClassConstraint {
+		return ""
+	}
+
+	msg := err.Error()
+	for _, delims := range [][2]string{
+		{`constraint "`, `"`}, // postgres
+		{`for key '`, `'`},    // mysql
+		{`CONSTRAINT ` + "`", "`"},
+		{`constraint failed: `, ``}, // sqlite
+	} {
+		i := strings.Index(msg, delims[0])
+		if i < 0 {
+			continue
+		}
+
+		name := msg[i+len(delims[0]):]
+		if delims[1] != "" {
+			if j := strings.Index(name, delims[1]); j >= 0 {
+				name = name[:j]
+			}
+		}
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,217 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pqError mimics the lib/pq and pgx errors
+type pqError struct {
+	code, msg string
+}
+
+func (e pqError) Error() string    { return "pq: " + e.msg }
+func (e pqError) SQLState() string { return e.code }
+
+// mysqlError mimics mysql.MySQLError formatting
+type mysqlError struct {
+	number  int
+	message string
+}
+
+func (e mysqlError) Error() string { return fmt.Sprintf("Error %d: %s", e.number, e.message) }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	for _, c := range []struct {
+		err   error
+		class ErrorClass
+	}{
+		{nil, ErrorClassNone},
+		{sql.ErrNoRows, ErrorClassNone},
+		{context.Canceled, ErrorClassCanceled},
+		{context.DeadlineExceeded, ErrorClassTimeout},
+		{driver.ErrBadConn, ErrorClassBadConn},
+		{timeoutError{}, ErrorClassTimeout},
+		{errors.New("boom"), ErrorClassOther},
+
+		{pqError{"23505", `duplicate key value violates unique constraint "t_f1_key"`}, ErrorClassConstraint},
+		{pqError{"23503", `insert or update on table "t" violates foreign key constraint "t_fk"`}, ErrorClassConstraint},
+		{pqError{"40P01", "deadlock detected"}, ErrorClassDeadlock},
+		{pqError{"40001", "could not serialize access due to concurrent update"}, ErrorClassSerialization},
+		{pqError{"57014", "canceling statement due to statement timeout"}, ErrorClassServerTimeout},
+		{pqError{"57014", "canceling statement due to user request"}, ErrorClassCanceled},
+		{pqError{"28P01", `password authentication failed for user "app"`}, ErrorClassAuth},
+		{pqError{"28000", "PAM authentication failed for user \"app\""}, ErrorClassAuth},
+		{pqError{"42601", "syntax error"}, ErrorClassOther},
+
+		{mysqlError{1062, "Duplicate entry 'foo' for key 't_f1'"}, ErrorClassConstraint},
+		{mysqlError{1213, "Deadlock found when trying to get lock; try restarting transaction"}, ErrorClassDeadlock},
+		{mysqlError{1205, "Lock wait timeout exceeded; try restarting transaction"}, ErrorClassTimeout},
+		{mysqlError{3024, "Query execution was interrupted, maximum statement execution time exceeded"}, ErrorClassServerTimeout},
+		{mysqlError{1045, "Access denied for user 'app'@'10.0.0.1' (using password: YES)"}, ErrorClassAuth},
+		{mysqlError{1064, "You have an error in your SQL syntax"}, ErrorClassOther},
+
+		{errors.New("UNIQUE constraint failed: t.f1"), ErrorClassConstraint},
+	} {
+		assert.Equal(t, c.class, ClassifyError(c.err), "%v", c.err)
+	}
+}
+
+// wrappedError wraps err, as fmt.Errorf's %w does
+type wrappedError struct {
+	err error
+}
+
+func (e wrappedError) Error() string { return "wrapped: " + e.err.Error() }
+func (e wrappedError) Unwrap() error { return e.err }
+
+func TestIsNotFound(t *testing.T) {
+	assert.True(t, IsNotFound(sql.ErrNoRows))
+	assert.True(t, IsNotFound(wrappedError{wrappedError{sql.ErrNoRows}}))
+	assert.False(t, IsNotFound(nil))
+	assert.False(t, IsNotFound(errors.New("sql: no rows in result set")))
+	assert.False(t, IsNotFound(wrappedError{driver.ErrBadConn}))
+
+	assert.Equal(t, ErrorClassNone, ClassifyError(wrappedError{sql.ErrNoRows}))
+	ctx := NewContext()
+	ctx.Error = wrappedError{sql.ErrNoRows}
+	assert.Equal(t, ErrorClassNone, ctx.ErrorClass())
+}
+
+func TestClassifyWrappedError(t *testing.T) {
+	for _, c := range []struct {
+		err   error
+		class ErrorClass
+	}{
+		{wrappedError{pqError{"40001", "could not serialize access due to concurrent update"}}, ErrorClassSerialization},
+		{wrappedError{wrappedError{pqError{"23505", `duplicate key value violates unique constraint "t_f1_key"`}}}, ErrorClassConstraint},
+		{wrappedError{mysqlError{1213, "Deadlock found when trying to get lock; try restarting transaction"}}, ErrorClassDeadlock},
+		{wrappedError{context.DeadlineExceeded}, ErrorClassTimeout},
+		{wrappedError{timeoutError{}}, ErrorClassTimeout},
+		{wrappedError{errors.New("boom")}, ErrorClassOther},
+	} {
+		assert.Equal(t, c.class, ClassifyError(c.err), "%v", c.err)
+	}
+}
+
+func TestConstraintName(t *testing.T) {
+	assert.Equal(t, "t_f1_key", ConstraintName(pqError{"23505", `duplicate key value violates unique constraint "t_f1_key"`}))
+	assert.Equal(t, "t_f1", ConstraintName(mysqlError{1062, "Duplicate entry 'foo' for key 't_f1'"}))
+	assert.Equal(t, "t.f1", ConstraintName(errors.New("UNIQUE constraint failed: t.f1")))
+	assert.Equal(t, "", ConstraintName(pqError{"40P01", "deadlock detected"}))
+	assert.Equal(t, "", ConstraintName(nil))
+}
+
+// cancelConn fails every Exec with err, once the context is done if wait is set
+type cancelConn struct {
+	anyConn
+	err  error
+	wait bool
+}
+
+func (c cancelConn) ExecContext(stdCtx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.wait {
+		<-stdCtx.Done()
+	}
+	return nil, c.err
+}
+
+// execErrorClass returns the ErrorClass and CtxErr the After hook gets for an Exec on dc run with stdCtx
+func execErrorClass(t *testing.T, dc driver.Conn, stdCtx context.Context) (ErrorClass, error) {
+	var class ErrorClass
+	var ctxErr error
+	hooks := &FuncHooks{Exec: Funcs{After: func(ctx *Context) error {
+		class, ctxErr = ctx.ErrorClass(), ctx.CtxErr
+		return ctx.Error
+	}}}
+
+	c, err := NewDriver("", hooks).wrap(context.Background(), "", dc)
+	require.NoError(t, err)
+	_, err = c.(*conn).ExecContext(stdCtx, "SELECT pg_sleep(10)", nil)
+	require.Error(t, err)
+	return class, ctxErr
+}
+
+func TestErrorClassClientDeadline(t *testing.T) {
+	stdCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// lib/pq cancels the query on the server, which answers as it does for any cancel
+	canceled := pqError{"57014", "canceling statement due to user request"}
+	class, ctxErr := execErrorClass(t, cancelConn{err: canceled, wait: true}, stdCtx)
+	assert.Equal(t, ErrorClassTimeout, class)
+	assert.Equal(t, context.DeadlineExceeded, ctxErr)
+}
+
+func TestErrorClassClientCanceled(t *testing.T) {
+	stdCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	class, ctxErr := execErrorClass(t, cancelConn{err: driver.ErrBadConn, wait: true}, stdCtx)
+	assert.Equal(t, ErrorClassCanceled, class)
+	assert.Equal(t, context.Canceled, ctxErr)
+}
+
+// nearDeadline is a context whose deadline is always about to expire, as
+// it is when the driver gives up right before it does
+type nearDeadline struct {
+	context.Context
+}
+
+func (nearDeadline) Deadline() (time.Time, bool) {
+	return time.Now().Add(deadlineSlack / 5), true
+}
+
+func TestErrorClassNearDeadline(t *testing.T) {
+	for _, err := range []error{
+		timeoutError{},
+		pqError{"57014", "canceling statement due to user request"},
+	} {
+		class, ctxErr := execErrorClass(t, cancelConn{err: err}, nearDeadline{context.Background()})
+		assert.Equal(t, ErrorClassTimeout, class, "%v", err)
+		assert.Equal(t, context.DeadlineExceeded, ctxErr, "%v", err)
+	}
+}
+
+func TestErrorClassNearDeadlineKeepsOtherErrors(t *testing.T) {
+	for _, c := range []struct {
+		err   error
+		class ErrorClass
+	}{
+		{pqError{"23505", "duplicate key value violates unique constraint"}, ErrorClassConstraint},
+		{pqError{"42601", "syntax error at or near \"SELEC\""}, ErrorClassOther},
+		{errors.New("boom"), ErrorClassOther},
+	} {
+		class, ctxErr := execErrorClass(t, cancelConn{err: c.err}, nearDeadline{context.Background()})
+		assert.Equal(t, c.class, class, "%v", c.err)
+		assert.NoError(t, ctxErr, "%v", c.err)
+	}
+}
+
+func TestErrorClassServerTimeout(t *testing.T) {
+	stdCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, err := range []error{
+		pqError{"57014", "canceling statement due to statement timeout"},
+		mysqlError{3024, "Query execution was interrupted, maximum statement execution time exceeded"},
+	} {
+		class, ctxErr := execErrorClass(t, cancelConn{err: err}, stdCtx)
+		assert.Equal(t, ErrorClassServerTimeout, class, "%v", err)
+		assert.NoError(t, ctxErr)
+	}
+}
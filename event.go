@@ -0,0 +1,265 @@
+package sqlhooks
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// EventSchemaVersion is the version of EventRecordV1, the V field of its records
+const EventSchemaVersion = 1
+
+// EventRecordV1 is the serializable record of a hook event, as returned by
+// MarshalEvent. Its JSON field names are a contract: fields can be added to
+// version 1, but not renamed or removed.
+type EventRecordV1 struct {
+	V int `json:"v"`
+
+	// Op is the operation of the statement in lower case, as Classify
+	// returns it, "copy" for the CopySummary, or "tx", "batch" and "conn"
+	// for the TxSummary, BatchSummary and ConnSummary
+	Op    string `json:"op"`
+	Kind  string `json:"kind,omitempty"`
+	Table string `json:"table,omitempty"`
+
+	Query       string `json:"query,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Args are the arguments formatted as fmt's %v does, except for []byte,
+	// shown as strings, time.Time, in RFC 3339 format, and the output
+	// parameters, shown as <out>. Named ones are prefixed by their name and =.
+	Args []string `json:"args,omitempty"`
+
+	StatementID uint64 `json:"statement_id,omitempty"`
+	Seq         uint64 `json:"seq,omitempty"`
+	ConnID      uint64 `json:"conn_id,omitempty"`
+	Peer        string `json:"peer,omitempty"`
+	TxID        uint64 `json:"tx_id,omitempty"`
+	TxOrigin    string `json:"tx_origin,omitempty"`
+	BatchID     uint64 `json:"batch_id,omitempty"`
+	Schema      string `json:"schema,omitempty"`
+	Driver      string `json:"driver,omitempty"`
+	System      string `json:"db_system,omitempty"`
+
+	// Path is the driver interface the statement was dispatched to, as
+	// Path.String returns it
+	Path string `json:"path,omitempty"`
+
+	// Start is the wall clock time the statement started, in RFC 3339
+	// format with nanoseconds
+	Start string `json:"start,omitempty"`
+
+	// Durations are in nanoseconds
+	DurationNS     int64 `json:"duration_ns"`
+	HookDurationNS int64 `json:"hook_duration_ns,omitempty"`
+
+	// The phases of a query, set once its rows are closed, see
+	// Context.TimeToFirstByte
+	TimeToFirstByteNS int64 `json:"time_to_first_byte_ns,omitempty"`
+	TimeToFirstRowNS  int64 `json:"time_to_first_row_ns,omitempty"`
+	TotalTimeNS       int64 `json:"total_time_ns,omitempty"`
+
+	// RowsAffected is the number of rows copied for the CopySummary
+	RowsAffected *int64 `json:"rows_affected,omitempty"`
+
+	// NumInput is set for the prepared statements, see Context.NumInput
+	NumInput *int `json:"num_input,omitempty"`
+
+	// Synthetic is set for the statements whose result a hook substituted
+	Synthetic bool `json:"synthetic,omitempty"`
+	Migration bool `json:"migration,omitempty"`
+	Internal  bool `json:"internal,omitempty"`
+
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+
+	// The TxSummary, BatchSummary and ConnSummary fields, Slowest is a fingerprint
+	Outcome              string `json:"outcome,omitempty"`
+	Statements           int    `json:"statements,omitempty"`
+	StatementsDurationNS int64  `json:"statements_duration_ns,omitempty"`
+	Slowest              string `json:"slowest,omitempty"`
+	SlowestDurationNS    int64  `json:"slowest_duration_ns,omitempty"`
+	Failed               int    `json:"failed,omitempty"`
+	Transactions         int    `json:"transactions,omitempty"`
+	Broken               bool   `json:"broken,omitempty"`
+}
+
+// MarshalEvent returns the record of ev, a *Context as given to the hooks,
+// a TxSummary, a BatchSummary, a ConnSummary or a CopySummary, it fails for
+// any other type
+func MarshalEvent(ev interface{}) (EventRecordV1, error) {
+	switch ev := ev.(type) {
+	case *Context:
+		return contextRecord(ev), nil
+	case TxSummary:
+		return txSummaryRecord(ev), nil
+	case *TxSummary:
+		return txSummaryRecord(*ev), nil
+	case BatchSummary:
+		return batchSummaryRecord(ev), nil
+	case *BatchSummary:
+		return batchSummaryRecord(*ev), nil
+	case ConnSummary:
+		return connSummaryRecord(ev), nil
+	case *ConnSummary:
+		return connSummaryRecord(*ev), nil
+	case CopySummary:
+		return copySummaryRecord(ev), nil
+	case *CopySummary:
+		return copySummaryRecord(*ev), nil
+	}
+	return EventRecordV1{}, fmt.Errorf("sqlhooks: can't marshal events of type %T", ev)
+}
+
+func contextRecord(ctx *Context) EventRecordV1 {
+	operation, table := ctx.Classify()
+	r := EventRecordV1{
+		V:              EventSchemaVersion,
+		Op:             operation,
+		Kind:           ctx.Kind().String(),
+		Table:          table,
+		Query:          ctx.Query,
+		Fingerprint:    Fingerprint(ctx.Query),
+		Args:           formatArgs(ctx.Args),
+		StatementID:    ctx.StatementID,
+		Seq:            ctx.Seq,
+		ConnID:         ctx.ConnID,
+		Peer:           ctx.Peer,
+		TxID:           ctx.TxID,
+		TxOrigin:       ctx.TxOrigin.String(),
+		BatchID:        ctx.BatchID,
+		Schema:         ctx.Schema,
+		Driver:         ctx.DriverName,
+		System:         ctx.System.Name,
+		DurationNS:     int64(ctx.Duration),
+		HookDurationNS: int64(ctx.HookDuration),
+		Synthetic:      ctx.Synthetic,
+		Migration:      ctx.Migration,
+		Internal:       ctx.Internal,
+		ErrorClass:     string(ctx.ErrorClass()),
+	}
+	r.TimeToFirstByteNS = int64(ctx.TimeToFirstByte)
+	r.TimeToFirstRowNS = int64(ctx.TimeToFirstRow)
+	r.TotalTimeNS = int64(ctx.TotalTime)
+	if ctx.Error != nil {
+		r.Error = ctx.Error.Error()
+	}
+	if ctx.Path != PathNone {
+		r.Path = ctx.Path.String()
+	}
+	if !ctx.Start.IsZero() {
+		r.Start = ctx.Start.Format(time.RFC3339Nano)
+	}
+	if ctx.hasNumInput {
+		n := ctx.NumInput
+		r.NumInput = &n
+	}
+	if ctx.Result != nil {
+		if n, err := ctx.Result.RowsAffected(); err == nil {
+			r.RowsAffected = &n
+		}
+	}
+	return r
+}
+
+func txSummaryRecord(s TxSummary) EventRecordV1 {
+	r := EventRecordV1{
+		V:                    EventSchemaVersion,
+		Op:                   "tx",
+		Kind:                 KindTx.String(),
+		ConnID:               s.ConnID,
+		Peer:                 s.Peer,
+		TxID:                 s.TxID,
+		TxOrigin:             s.Origin.String(),
+		DurationNS:           int64(s.Duration),
+		Outcome:              s.Outcome.String(),
+		Statements:           s.Statements,
+		StatementsDurationNS: int64(s.StatementsDuration),
+		Slowest:              s.Slowest,
+		SlowestDurationNS:    int64(s.SlowestDuration),
+	}
+	if s.Err != nil {
+		r.Error = s.Err.Error()
+		r.ErrorClass = string(ClassifyError(s.Err))
+	}
+	return r
+}
+
+func batchSummaryRecord(s BatchSummary) EventRecordV1 {
+	return EventRecordV1{
+		V:                    EventSchemaVersion,
+		Op:                   "batch",
+		BatchID:              s.BatchID,
+		DurationNS:           int64(s.Duration),
+		Statements:           s.Statements,
+		StatementsDurationNS: int64(s.StatementsDuration),
+		Failed:               s.Failed,
+	}
+}
+
+func connSummaryRecord(s ConnSummary) EventRecordV1 {
+	r := EventRecordV1{
+		V:            EventSchemaVersion,
+		Op:           "conn",
+		ConnID:       s.ConnID,
+		Peer:         s.Peer,
+		DurationNS:   int64(s.Age),
+		Statements:   s.Statements,
+		Transactions: s.Transactions,
+		Broken:       s.Broken,
+	}
+	if s.Err != nil {
+		r.Error = s.Err.Error()
+		r.ErrorClass = string(ClassifyError(s.Err))
+	}
+	return r
+}
+
+func copySummaryRecord(s CopySummary) EventRecordV1 {
+	operation, _ := Classify(s.Query)
+	rows := s.Rows
+	r := EventRecordV1{
+		V:            EventSchemaVersion,
+		Op:           operation,
+		Kind:         kinds[operation].String(),
+		Table:        s.Table,
+		Query:        s.Query,
+		ConnID:       s.ConnID,
+		Peer:         s.Peer,
+		TxID:         s.TxID,
+		DurationNS:   int64(s.Duration),
+		RowsAffected: &rows,
+	}
+	if s.Err != nil {
+		r.Error = s.Err.Error()
+		r.ErrorClass = string(ClassifyError(s.Err))
+	}
+	return r
+}
+
+// formatArgs formats args as EventRecordV1.Args are
+func formatArgs(args []interface{}) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	formatted := make([]string, len(args))
+	for i, arg := range args {
+		prefix := ""
+		if named, ok := arg.(sql.NamedArg); ok {
+			prefix, arg = named.Name+"=", named.Value
+		}
+		switch v := arg.(type) {
+		case []byte:
+			formatted[i] = prefix + string(v)
+		case time.Time:
+			formatted[i] = prefix + v.Format(time.RFC3339Nano)
+		default:
+			if isOut(v) {
+				formatted[i] = prefix + "<out>"
+			} else {
+				formatted[i] = prefix + fmt.Sprint(v)
+			}
+		}
+	}
+	return formatted
+}
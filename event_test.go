@@ -0,0 +1,117 @@
+package sqlhooks
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+// goldenEvents are events setting every EventRecordV1 field
+func goldenEvents() []interface{} {
+	ctx := NewContext()
+	ctx.Query = "UPDATE users SET name = ?, avatar = ? WHERE id = 42 AND updated_at < ?"
+	ctx.Args = []interface{}{"bob", []byte("png"), time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)}
+	ctx.StatementID = 7
+	ctx.Seq = 3
+	ctx.ConnID = 2
+	ctx.Peer = "db-2:5432"
+	ctx.TxID = 5
+	ctx.TxOrigin = TxOriginStatement
+	ctx.BatchID = 4
+	ctx.Schema = "public"
+	ctx.Start = time.Date(2017, 3, 1, 12, 0, 1, 500, time.UTC)
+	ctx.Path = PathExecerContext
+	ctx.Duration = 1500 * time.Microsecond
+	ctx.HookDuration = 20 * time.Microsecond
+	ctx.TimeToFirstByte = 500 * time.Microsecond
+	ctx.TimeToFirstRow = 800 * time.Microsecond
+	ctx.TotalTime = 1400 * time.Microsecond
+	ctx.NumInput, ctx.hasNumInput = 3, true
+	ctx.Result = driver.RowsAffected(1)
+	ctx.Synthetic = true
+	ctx.Error = errors.New("boom")
+
+	return []interface{}{ctx, TxSummary{
+		TxID:               5,
+		ConnID:             2,
+		Origin:             TxOriginBeginOptions,
+		Duration:           3 * time.Millisecond,
+		Outcome:            TxCommitFailed,
+		Err:                errors.New("commit failed"),
+		Statements:         2,
+		StatementsDuration: 2 * time.Millisecond,
+		Slowest:            "UPDATE users SET name = ? WHERE id = ?",
+		SlowestDuration:    1500 * time.Microsecond,
+	}, BatchSummary{
+		BatchID:            4,
+		Duration:           4 * time.Millisecond,
+		Statements:         3,
+		Failed:             1,
+		StatementsDuration: 2500 * time.Microsecond,
+	}, ConnSummary{
+		ConnID:       2,
+		Peer:         "db-2:5432",
+		Age:          5 * time.Minute,
+		Statements:   12,
+		Transactions: 3,
+		Broken:       true,
+		Err:          driver.ErrBadConn,
+	}, CopySummary{
+		ConnID:   2,
+		TxID:     7,
+		Query:    `COPY "users" ("id", "name") FROM STDIN`,
+		Table:    "users",
+		Rows:     1000,
+		Duration: 30 * time.Millisecond,
+	}}
+}
+
+// TestEventRecordV1Golden locks the JSON field names of EventRecordV1,
+// run it with -update to accept a new field
+func TestEventRecordV1Golden(t *testing.T) {
+	var records []EventRecordV1
+	for _, ev := range goldenEvents() {
+		r, err := MarshalEvent(ev)
+		require.NoError(t, err)
+		records = append(records, r)
+	}
+	got, err := json.MarshalIndent(records, "", "\t")
+	require.NoError(t, err)
+	got = append(got, '\n')
+
+	const golden = "testdata/event_v1.golden"
+	if *updateGolden {
+		require.NoError(t, ioutil.WriteFile(golden, got, 0644))
+	}
+	expected, err := ioutil.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(got))
+}
+
+func TestMarshalEvent(t *testing.T) {
+	ctx := NewContext()
+	ctx.Query = "SELECT 1"
+	r, err := MarshalEvent(ctx)
+	require.NoError(t, err)
+	b, err := json.Marshal(r)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"v":1,"op":"select","kind":"select","query":"SELECT 1","fingerprint":"SELECT ?","duration_ns":0}`, string(b))
+
+	summary := &TxSummary{Outcome: TxCommitted}
+	r, err = MarshalEvent(summary)
+	require.NoError(t, err)
+	assert.Equal(t, "committed", r.Outcome)
+	assert.Empty(t, r.Error)
+
+	_, err = MarshalEvent("SELECT 1")
+	assert.EqualError(t, err, "sqlhooks: can't marshal events of type string")
+}
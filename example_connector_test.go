@@ -0,0 +1,57 @@
+//go:build go1.10
+// +build go1.10
+
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+)
+
+// tokenDSN is a connector of drivertest for the DSN of a token, as a driver
+// connecting with a short-lived token would be
+type tokenDSN struct {
+	dsn string
+}
+
+func (c tokenDSN) Connect(context.Context) (driver.Conn, error) { return c.Driver().Open(c.dsn) }
+func (c tokenDSN) Driver() driver.Driver                        { return drivertest.Driver{} }
+
+func ExampleDriver_OpenConnector() {
+	d := NewDriver(drivertest.Name, logHooks("connector"))
+	connector, err := d.OpenConnector("context;ExampleDriver_OpenConnector")
+	if err != nil {
+		panic(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	db.Exec("INSERT INTO t VALUES (1)")
+	// Output:
+	// connector before
+	// connector after
+}
+
+func ExampleWrapConnectorFunc() {
+	// The connector is refreshed once its token expires
+	tokens := 0
+	refresh := func(ctx context.Context) (driver.Connector, error) {
+		tokens++
+		fmt.Println("token", tokens)
+		c := tokenDSN{fmt.Sprintf("context;ExampleWrapConnectorFunc-%d", tokens)}
+		return ConnectorWithExpiry(c, time.Now().Add(time.Hour)), nil
+	}
+	db := sql.OpenDB(WrapConnectorFunc(refresh, logHooks("refreshed")))
+	defer db.Close()
+
+	db.Exec("INSERT INTO t VALUES (1)")
+	// Output:
+	// token 1
+	// refreshed before
+	// refreshed after
+}
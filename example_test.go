@@ -1,39 +1,468 @@
 package sqlhooks
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/require"
+)
+
+// The examples run against drivertest, whose statements fail, take time or
+// return rows as their directives say, see its documentation.
 
 type MyQueryer struct{}
 
 func (q MyQueryer) BeforeQuery(ctx *Context) error {
+	fmt.Println("before", ctx.Query)
 	return nil
 }
 
 func (q MyQueryer) AfterQuery(ctx *Context) error {
-	return nil
+	fmt.Println("after", ctx.Query, ctx.Error)
+	return ctx.Error
 }
 
 func ExampleNewDriver() {
 	// MyQueryer satisfies Queryer interface
 	hooks := MyQueryer{}
 
-	// mysql is the driver we're going to attach to
-	driver := NewDriver("mysql", &hooks)
-	sql.Register("sqlhooks-mysql", driver)
+	// drivertest is the driver we're going to attach to, an application
+	// would give "mysql" or "postgres" with sql.Register
+	driver := NewDriver(drivertest.Name, &hooks)
+	name := RegisterUnique("sqlhooks-example", driver)
+
+	db, err := sql.Open(name, "context;ExampleNewDriver")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT 1+1")
+	if err != nil {
+		panic(err)
+	}
+	rows.Close()
+	// Output:
+	// before SELECT 1+1
+	// after SELECT 1+1 <nil>
 }
 
 func ExampleOpen() {
-	// Where using nil as HookType, so no hooks will be attached.
-	// In order attach hooks, the HookType should implement one of the following interfaces:
-	// - Beginner
-	// - Commiter
-	// - Rollbacker
-	// - Stmter
-	// - Queryer
-	// - Execer
-	db, err := Open("mysql", "user:pass@/db", nil)
+	// With nil as HookType, no hooks are attached. In order attach hooks,
+	// the HookType should implement one of the interfaces listed by
+	// HookType, as MyQueryer implements Queryer
+	db, err := Open(drivertest.Name, "context;ExampleOpen", MyQueryer{})
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, err = db.Query("FAIL deadlock|SELECT 1+1")
+	fmt.Println(err)
+	// Output:
+	// before FAIL deadlock|SELECT 1+1
+	// after FAIL deadlock|SELECT 1+1 drivertest: deadlock
+	// drivertest: deadlock
+}
+
+func ExampleFuncHooks() {
+	// FuncHooks sets the hooks of the operations with functions, those left
+	// unset run nothing
+	hooks := &FuncHooks{
+		Exec: Funcs{After: func(ctx *Context) error {
+			affected, _ := ctx.Result.RowsAffected()
+			fmt.Printf("%s: %d rows\n", ctx.Query, affected)
+			return ctx.Error
+		}},
+	}
+	db, err := Open(drivertest.Name, "context;ExampleFuncHooks", hooks)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Exec("AFFECTED 3|DELETE FROM sessions")
+	// Output:
+	// AFFECTED 3|DELETE FROM sessions: 3 rows
+}
+
+// logHooks prints the Before and After Exec hooks, prefixed with name
+func logHooks(name string) *FuncHooks {
+	return &FuncHooks{Exec: Funcs{
+		Before: func(ctx *Context) error {
+			fmt.Println(name, "before")
+			return nil
+		},
+		After: func(ctx *Context) error {
+			fmt.Println(name, "after")
+			return ctx.Error
+		},
+	}}
+}
+
+func ExampleCompose() {
+	// The Before hooks run in order, the After ones in reverse order, so
+	// tracing wraps the metrics of the statement
+	hooks := Compose(logHooks("tracing"), logHooks("metrics"))
+	db, err := Open(drivertest.Name, "context;ExampleCompose", hooks)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Exec("INSERT INTO t VALUES (1)")
+	// Output:
+	// tracing before
+	// metrics before
+	// metrics after
+	// tracing after
+}
+
+func ExampleMinDuration() {
+	// The slow query log only gets the statements taking 50ms or more
+	slowLog, err := MinDuration(50*time.Millisecond, &FuncHooks{
+		Exec: Funcs{After: func(ctx *Context) error {
+			fmt.Println("slow:", ctx.Query)
+			return ctx.Error
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	db, err := Open(drivertest.Name, "context;ExampleMinDuration", slowLog)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Exec("UPDATE t SET n = 1")
+	db.Exec("SLEEP 60ms|UPDATE t SET n = 2")
+	// Output:
+	// slow: SLEEP 60ms|UPDATE t SET n = 2
+}
+
+func ExampleRoute() {
+	// The writes are audited, the reads aren't
+	hooks := Route(map[Kind]HookType{
+		KindInsert: logHooks("audit"),
+		KindUpdate: logHooks("audit"),
+		KindDelete: logHooks("audit"),
+	}, nil)
+	db, err := Open(drivertest.Name, "context;ExampleRoute", hooks)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Exec("SELECT 1")
+	db.Exec("DELETE FROM t")
+	// Output:
+	// audit before
+	// audit after
+}
+
+func ExampleWithExtraHooks() {
+	db, err := Open(drivertest.Name, "context;ExampleWithExtraHooks", &FuncHooks{})
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	// The hooks of a context count the statements of a request only
+	var statements int
+	count := &FuncHooks{Exec: Funcs{After: func(ctx *Context) error {
+		statements++
+		return ctx.Error
+	}}}
+	ctx := WithExtraHooks(context.Background(), count)
+	db.ExecContext(ctx, "INSERT INTO orders VALUES (1)")
+	db.ExecContext(ctx, "UPDATE stock SET n = n - 1")
+	db.Exec("INSERT INTO logs VALUES ('x')")
+	fmt.Println(statements, "statements")
+	// Output:
+	// 2 statements
+}
+
+// registry stands for a metrics registry, as the one of Prometheus, with
+// counters by name and labels
+type registry struct {
+	mu       sync.Mutex
+	counters map[string]int
+}
+
+func (r *registry) inc(name string, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counters == nil {
+		r.counters = make(map[string]int)
+	}
+	r.counters[name+"{"+strings.Join(labels, ",")+"}"]++
+}
+
+func (r *registry) print() {
+	var names []string
+	for name := range r.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name, r.counters[name])
+	}
+}
+
+// Metrics integrations count the statements by kind and outcome
+func Example_metrics() {
+	reg := &registry{}
+	count := func(ctx *Context) error {
+		outcome := "ok"
+		if ctx.Error != nil {
+			outcome = "error"
+		}
+		reg.inc("sql_statements_total", "kind="+ctx.Kind().String(), "outcome="+outcome)
+		return ctx.Error
+	}
+	hooks := &FuncHooks{Exec: Funcs{After: count}, Query: Funcs{After: count}}
+	db, err := Open(drivertest.Name, "context;Example_metrics", hooks)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Exec("INSERT INTO t VALUES (1)")
+	db.Exec("INSERT INTO t VALUES (2)")
+	rows, err := db.Query("SELECT n FROM t")
+	if err == nil {
+		rows.Close()
+	}
+	// The directive makes the kind of the statement other
+	db.Exec("FAIL deadlock|UPDATE t SET n = 1")
+	reg.print()
+	// Output:
+	// sql_statements_total{kind=insert,outcome=ok} 2
+	// sql_statements_total{kind=other,outcome=error} 1
+	// sql_statements_total{kind=select,outcome=ok} 1
+}
+
+func ExampleSelfTest() {
+	db, err := Open(drivertest.Name, "context;app:secret@tcp(db)/shop", &FuncHooks{})
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	report, err := SelfTest(context.Background(), db)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(report.OK(), report.DSN, report.Path)
+	// Output:
+	// true context;app@tcp(db)/shop queryer_context
+}
+
+func ExampleDriver_Describe() {
+	slowLog, _ := MinDuration(time.Second, &FuncHooks{Exec: Funcs{After: func(ctx *Context) error { return ctx.Error }}})
+	d := NewDriver(drivertest.Name, Compose(MyQueryer{}, slowLog))
+	for _, desc := range d.Describe() {
+		fmt.Println(desc.Source, desc)
+		for _, h := range desc.Hooks {
+			fmt.Println("  ", h, h.Interfaces)
+		}
+	}
+	// Output:
+	// driver Compose
+	//    sqlhooks.MyQueryer [Queryer]
+	//    *sqlhooks.FuncHooks (min duration 1s) [Execer]
+}
+
+func ExampleMarshalEvent() {
+	hooks := &FuncHooks{Exec: Funcs{After: func(ctx *Context) error {
+		record, err := MarshalEvent(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(record.Op, record.Table, record.Fingerprint, record.Error)
+		return ctx.Error
+	}}}
+	db, err := Open(drivertest.Name, "context;ExampleMarshalEvent", hooks)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Exec("UPDATE accounts SET balance = 10 WHERE id = 3")
+	// Output:
+	// update accounts UPDATE accounts SET balance = ? WHERE id = ?
+}
+
+func ExampleFingerprint() {
+	fmt.Println(Fingerprint("SELECT * FROM users WHERE id = 42 AND name = 'bob'"))
+	// Output:
+	// SELECT * FROM users WHERE id = ? AND name = ?
+}
+
+func ExampleClassify() {
+	operation, table := Classify("INSERT INTO orders (id) VALUES (1)")
+	fmt.Println(operation, table)
+	// Output:
+	// insert orders
+}
+
+func ExampleQueryPlaceholderStyle() {
+	fmt.Println(QueryPlaceholderStyle("SELECT * FROM docs WHERE id = ?", "postgresql"))
+	fmt.Println(QueryPlaceholderStyle("SELECT * FROM docs WHERE data ? 'key' AND id = $1", "postgresql"))
+	// Output:
+	// ?
+	// $1
+}
+
+func ExampleSkipPlaceholderCheck() {
+	check := func(ctx *Context) error {
+		if PlaceholderCheckSkipped(ctx.Ctx) {
+			return nil
+		}
+		return fmt.Errorf("%s placeholders", QueryPlaceholderStyle(ctx.Query, "postgresql"))
+	}
+	db, err := Open(drivertest.Name, "context;ExampleSkipPlaceholderCheck", &FuncHooks{Exec: Funcs{Before: check}})
 	if err != nil {
 		panic(err)
 	}
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM docs WHERE id = ?", 1)
+	fmt.Println(err)
+	_, err = db.ExecContext(SkipPlaceholderCheck(context.Background()), "DELETE FROM docs WHERE id = ?", 1)
+	fmt.Println(err)
+	// Output:
+	// ? placeholders
+	// <nil>
+}
+
+func ExamplePlaceholderCheckSkipped() {
+	ctx := SkipPlaceholderCheck(context.Background())
+	fmt.Println(PlaceholderCheckSkipped(ctx), PlaceholderCheckSkipped(context.Background()))
+	// Output:
+	// true false
+}
+
+func ExampleWithHookTimeout() {
+	// A hook sending to a channel nobody reads would stall every statement
+	events := make(chan string)
+	send := func(ctx *Context) error {
+		events <- ctx.Query
+		return nil
+	}
+	logger := func(event string, err error) { fmt.Println(event, err) }
+	db, err := Open(drivertest.Name, "context;ExampleWithHookTimeout", &FuncHooks{Exec: Funcs{After: send}},
+		WithHookTimeout(10*time.Millisecond), WithInternalLogger(logger))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO t VALUES (1)")
+	fmt.Println(err)
+	// Output:
+	// hook_timeout sqlhooks: AfterExec hook took more than 10ms: INSERT INTO t VALUES (1)
+	// <nil>
+}
+
+// abandonedPrinter prints whether the rows of a query were abandoned
+type abandonedPrinter struct {
+	NopHooks
+	check bool
+}
+
+func (p abandonedPrinter) AfterRowsClose(ctx *Context) error {
+	fmt.Printf("check %t, %s abandoned: %t\n", p.check, ctx.Query, ctx.Abandoned)
+	return ctx.Error
+}
+
+func ExampleWithAbandonedRowsCheck() {
+	for _, check := range []bool{false, true} {
+		db, err := Open(drivertest.Name, "context;ExampleWithAbandonedRowsCheck", abandonedPrinter{check: check}, WithAbandonedRowsCheck(check))
+		if err != nil {
+			panic(err)
+		}
+
+		// QueryRow closes the rows right after their first row
+		var n int
+		db.QueryRow("ROWS 1|SELECT n FROM t").Scan(&n)
+		db.QueryRow("ROWS 2|SELECT n FROM t").Scan(&n)
+		db.Close()
+	}
+	// Output:
+	// check false, ROWS 1|SELECT n FROM t abandoned: true
+	// check false, ROWS 2|SELECT n FROM t abandoned: true
+	// check true, ROWS 1|SELECT n FROM t abandoned: false
+	// check true, ROWS 2|SELECT n FROM t abandoned: true
+}
+
+// withoutExample are the exported functions which had no example when
+// TestExportedFuncsHaveExamples was added, the new ones need one
+var withoutExample = map[string]bool{
+	"ArgsSize": true, "Async": true, "BaseDriverName": true, "BeginBatch": true,
+	"Cacheable": true, "CacheableTTL": true, "ClassifyError": true, "CollapseInLists": true,
+	"ConnectorWithExpiry": true, "ConstraintName": true, "EndBatch": true, "IsInternal": true,
+	"IsMigration": true, "IsNotFound": true, "IsOutArg": true, "IsUnhooked": true,
+	"MarkInternal": true, "MarkMigration": true, "NewContext": true, "NewDriverE": true,
+	"ParseTraceparent": true, "Placeholders": true, "RedactArgs": true, "Registered": true,
+	"RegisterUnique": true, "SanitizeDSN": true, "SetDefaultHooks": true, "SetQueryCacheSize": true,
+	"SkipTenantCheck": true, "System": true, "TailSample": true, "TenantCheckSkipped": true,
+	"Unhooked": true, "WithArgCountCheck": true, "WithArgCountWarning": true, "WithArgTypes": true,
+	"WithArgVisibility": true, "WithArgsSize": true, "WithCollapseInLists": true, "WithCopyRowHooks": true,
+	"WithHeartbeat": true, "WithHooksSelector": true, "WithInternalLogger": true, "WithLazyDriver": true,
+	"WithLogger": true, "WithOnConnect": true, "WithOperations": true, "WithPeerResolver": true,
+	"WithPoolStats": true, "WithRawRowsPrefixes": true, "WithRedactor": true, "WithResultWrapping": true,
+	"WithRetries": true, "WithSavepointExecHooks": true, "WithSchemaProbe": true, "WithSelfTestQuery": true,
+	"WithSelfTiming": true, "WithServerInfo": true, "WithStmtCache": true, "WithSystem": true,
+	"WithTraceExtractor": true, "WithTxStatements": true,
+}
+
+// TestExportedFuncsHaveExamples fails for the exported functions without
+// an Example function, go vet checks the examples refer to existing ones
+func TestExportedFuncsHaveExamples(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool { return strings.HasSuffix(fi.Name(), ".go") }, 0)
+	require.NoError(t, err)
 
-	db.Query("SELECT 1+1")
+	var exported []string
+	examples := make(map[string]bool)
+	for _, pkg := range pkgs {
+		for name, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv != nil {
+					continue
+				}
+				switch {
+				case strings.HasSuffix(name, "_test.go") && strings.HasPrefix(fn.Name.Name, "Example"):
+					// ExampleOpen_second is an example of Open too
+					example := strings.TrimPrefix(fn.Name.Name, "Example")
+					if i := strings.LastIndex(example, "_"); i >= 0 && strings.ToLower(example[i+1:]) == example[i+1:] {
+						example = example[:i]
+					}
+					examples[example] = true
+				case !strings.HasSuffix(name, "_test.go") && fn.Name.IsExported():
+					exported = append(exported, fn.Name.Name)
+				}
+			}
+		}
+	}
+
+	for _, name := range exported {
+		if !examples[name] && !withoutExample[name] {
+			t.Errorf("%s has no example, add an Example%s function to example_test.go", name, name)
+		}
+	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/hooks/capture"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// golden writes every captured result set as a JSON file named after its query
+func golden(dir string) capture.Func {
+	return func(r capture.Result) {
+		// []byte would be encoded as base64
+		for _, row := range r.Rows {
+			for i, v := range row {
+				if b, ok := v.([]byte); ok {
+					row[i] = string(b)
+				}
+			}
+		}
+
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Printf("capture: %v", err)
+			return
+		}
+
+		name := fmt.Sprintf("%x.json", sha1.Sum([]byte(fmt.Sprint(r.Query, r.Args))))
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			log.Printf("capture: %v", err)
+		}
+	}
+}
+
+func main() {
+	dir := "testdata"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := sqlhooks.Open("sqlite3", ":memory:", capture.New(golden(dir)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Exec("CREATE TABLE t (id INTEGER, text VARCHAR(16), created DATETIME)")
+	db.Exec("INSERT INTO t (id, text, created) VALUES (1, 'foo', CURRENT_TIMESTAMP), (2, 'bar', NULL)")
+
+	rows, err := db.Query("SELECT id, text, created FROM t WHERE id > ?", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+}
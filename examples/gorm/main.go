@@ -0,0 +1,56 @@
+// +build gorm
+
+package main
+
+import (
+	"log"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/gormdialect"
+	"gorm.io/gorm"
+)
+
+type Product struct {
+	ID    uint
+	Code  string
+	Price uint
+}
+
+type Logger struct{}
+
+func (Logger) BeforeExec(ctx *sqlhooks.Context) error {
+	log.Printf("exec %s %v", ctx.Query, ctx.Args)
+	return nil
+}
+
+func (Logger) AfterExec(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
+
+func (Logger) BeforeQuery(ctx *sqlhooks.Context) error {
+	log.Printf("query %s %v", ctx.Query, ctx.Args)
+	return nil
+}
+
+func (Logger) AfterQuery(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
+
+func main() {
+	name := sqlhooks.RegisterUnique("sqlite3", sqlhooks.NewDriver("sqlite3", Logger{}))
+	db, err := gorm.Open(gormdialect.SQLite(name, ":memory:"), &gorm.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&Product{}); err != nil {
+		log.Fatal(err)
+	}
+
+	db.Create(&Product{Code: "D42", Price: 100})
+
+	var product Product
+	db.First(&product, "code = ?", "D42")
+	db.Model(&product).Update("Price", 200)
+	db.Delete(&product)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type Person struct {
+	ID   int    `db:"id"`
+	Name string `db:"name"`
+}
+
+type Logger struct{}
+
+func (Logger) BeforeExec(ctx *sqlhooks.Context) error {
+	log.Printf("exec %s %v", ctx.Query, ctx.Args)
+	return nil
+}
+
+func (Logger) AfterExec(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
+
+func (Logger) BeforeQuery(ctx *sqlhooks.Context) error {
+	log.Printf("query %s %v", ctx.Query, ctx.Args)
+	return nil
+}
+
+func (Logger) AfterQuery(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
+
+func main() {
+	name := sqlhooks.RegisterUnique("postgres", sqlhooks.NewDriver("postgres", Logger{}))
+	std, err := sql.Open(name, "postgres://postgres@localhost/sqlhooks?sslmode=disable")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// sqlx picks the bindvars by driver name, hooks get the queries rebound
+	// with $1, $2... instead of the named ones
+	db := sqlx.NewDb(std, "postgres")
+	defer db.Close()
+
+	db.MustExec("DROP TABLE IF EXISTS person")
+	db.MustExec("CREATE TABLE person (id INTEGER, name TEXT)")
+	db.MustExec("INSERT INTO person VALUES ($1, $2)", 1, "Alice")
+
+	if _, err := db.NamedExec("INSERT INTO person VALUES (:id, :name)", Person{2, "Bob"}); err != nil {
+		log.Fatal(err)
+	}
+
+	var people []Person
+	if err := db.Select(&people, "SELECT id, name FROM person WHERE name = ANY($1)", pq.Array([]string{"Alice", "Bob"})); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%+v", people)
+
+	// sqlx.Open gets only the registered name, BaseDriverName gives sqlx the postgres one
+	other, err := sqlx.Open(name, "postgres://postgres@localhost/sqlhooks?sslmode=disable")
+	if err != nil {
+		log.Fatal(err)
+	}
+	other = sqlx.NewDb(other.DB, sqlhooks.BaseDriverName(name))
+	defer other.Close()
+
+	var person Person
+	if err := other.Get(&person, other.Rebind("SELECT id, name FROM person WHERE id = ?"), 1); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%+v", person)
+}
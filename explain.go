@@ -0,0 +1,113 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Explainer is the interface implemented by objects that wants to receive the
+// execution plan of Query and Exec statements.
+//
+// ExplainQuery is called once the statement has been executed, ctx carries its
+// Query, Args, TxID, Error and Duration. If ok is true, query is executed with the
+// same Args on the connection the statement ran on, without triggering any
+// hook, and its output is passed to AfterExplain. The statements with
+// arguments aren't explained when WithArgVisibility doesn't show them.
+//
+// For Query statements the plan is retrieved when the rows are closed, since
+// most drivers can't run a new statement while a result set is being read.
+type Explainer interface {
+	ExplainQuery(ctx *Context) (query string, ok bool)
+	AfterExplain(ctx *Context, plan string, err error)
+}
+
+func isExplainer(h HookType) bool {
+	_, ok := h.(Explainer)
+	return ok
+}
+
+// prepareExplain returns the function that retrieves the plan of the given
+// statement, or nil if hooks doesn't want it explained or it's an internal one
+func prepareExplain(stdCtx context.Context, c *conn, hooks HookType, query string, args []driver.Value, took time.Duration, err error) func() {
+	e, ok := hooks.(Explainer)
+	if !ok || err == driver.ErrSkip || IsInternal(stdCtx) || !implementsHook(hooks, isExplainer) {
+		return nil
+	}
+	if len(args) > 0 && !c.argsVisible() {
+		return nil
+	}
+
+	ctx := NewContext()
+	ctx.Query = query
+	ctx.Args = driverToInterface(args)
+	ctx.TxID = c.txID
+	ctx.Error = err
+	ctx.Duration = took
+
+	explainQuery, ok := e.ExplainQuery(ctx)
+	if !ok {
+		return nil
+	}
+
+	return func() {
+		plan, err := explain(c.Conn, explainQuery, args)
+		e.AfterExplain(ctx, plan, err)
+	}
+}
+
+// explain runs query directly against the underlying connection and returns
+// its rows as text, one line per row
+func explain(c driver.Conn, query string, args []driver.Value) (string, error) {
+	rows, err := internalQuery(context.Background(), c, query, args)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	dest := make([]driver.Value, len(rows.Columns()))
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		fields := make([]string, len(dest))
+		for i, v := range dest {
+			if b, ok := v.([]byte); ok {
+				fields[i] = string(b)
+			} else {
+				fields[i] = fmt.Sprint(v)
+			}
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+type explainedRows struct {
+	driver.Rows
+	explain func()
+}
+
+func (r explainedRows) Close() error {
+	err := r.Rows.Close()
+	r.explain()
+	return err
+}
+
+// explainRows defers fn until rows are closed, or runs it right away if
+// there are no rows
+func explainRows(rows driver.Rows, fn func()) driver.Rows {
+	if rows == nil {
+		fn()
+		return nil
+	}
+	return forwardRows(explainedRows{rows, fn}, rows)
+}
@@ -0,0 +1,39 @@
+package sqlhooks
+
+import "context"
+
+type extraHooksKey struct{}
+
+// extraHooks are the hooks added to a context.Context by WithExtraHooks,
+// composed with the ones added to the context.Context it derives from, parent
+type extraHooks struct {
+	hooks  HookType
+	parent *extraHooks
+}
+
+// WithExtraHooks returns a copy of ctx carrying hooks, which run after the
+// driver hooks, and after the extra hooks ctx already carries, for the
+// statements executed with the returned context or within a transaction
+// begun with it
+func WithExtraHooks(ctx context.Context, hooks HookType) context.Context {
+	e := &extraHooks{hooks: hooks, parent: extraHooksFrom(ctx)}
+	if e.parent != nil {
+		e.hooks = Compose(e.parent.hooks, hooks)
+	}
+	return context.WithValue(ctx, extraHooksKey{}, e)
+}
+
+func extraHooksFrom(ctx context.Context) *extraHooks {
+	e, _ := ctx.Value(extraHooksKey{}).(*extraHooks)
+	return e
+}
+
+// inherits reports whether e is, or derives from, parent
+func (e *extraHooks) inherits(parent *extraHooks) bool {
+	for ; e != nil; e = e.parent {
+		if e == parent {
+			return true
+		}
+	}
+	return false
+}
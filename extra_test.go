@@ -0,0 +1,98 @@
+package sqlhooks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtraHooksRunAfterDriverHooks(t *testing.T) {
+	var log []string
+	db := openDBWithHooks(t, recordingHooks("driver", &log))
+	defer db.Close()
+
+	ctx := WithExtraHooks(context.Background(), recordingHooks("extra", &log))
+	_, err := db.ExecContext(ctx, queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"driver.Before", "extra.Before", "extra.After", "driver.After"}, log)
+
+	log = nil
+	_, err = db.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"driver.Before", "driver.After"}, log, "extra hooks are scoped to their context")
+}
+
+func TestNestedExtraHooks(t *testing.T) {
+	var log []string
+	db := openDBWithHooks(t, recordingHooks("driver", &log))
+	defer db.Close()
+
+	outer := WithExtraHooks(context.Background(), recordingHooks("outer", &log))
+	inner := WithExtraHooks(outer, recordingHooks("inner", &log))
+	_, err := db.ExecContext(inner, queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"driver.Before", "outer.Before", "inner.Before",
+		"inner.After", "outer.After", "driver.After",
+	}, log)
+}
+
+func TestTxInheritsExtraHooks(t *testing.T) {
+	var log []string
+	db := openDBWithHooks(t, recordingHooks("driver", &log))
+	defer db.Close()
+
+	commits := 0
+	extra := recordingHooks("tx", &log)
+	extra.afterCommit = func(ctx *Context) error {
+		commits++
+		return ctx.Error
+	}
+
+	ctx := WithExtraHooks(context.Background(), extra)
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+
+	_, err = tx.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"driver.Before", "tx.Before", "tx.After", "driver.After"}, log)
+
+	log = nil
+	_, err = tx.ExecContext(WithExtraHooks(ctx, recordingHooks("stmt", &log)), queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"driver.Before", "tx.Before", "stmt.Before",
+		"stmt.After", "tx.After", "driver.After",
+	}, log, "tx extra hooks run once for contexts derived from the tx one")
+
+	log = nil
+	_, err = tx.ExecContext(WithExtraHooks(context.Background(), recordingHooks("stmt", &log)), queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"driver.Before", "tx.Before", "stmt.Before",
+		"stmt.After", "tx.After", "driver.After",
+	}, log)
+
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, 1, commits)
+
+	log = nil
+	_, err = db.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"driver.Before", "driver.After"}, log, "tx extra hooks end with it")
+}
+
+func TestCallHooksWithoutExtraHooksDontAllocate(t *testing.T) {
+	d := NewDriver(*driverFlag, &HooksMock{})
+	c := &conn{hooks: &d.hooks}
+	ctx := context.WithValue(context.Background(), extraHooksKey{}, nil)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if c.callHooks(ctx) != d.Hooks() {
+			t.Fatal("unexpected hooks")
+		}
+	})
+	assert.Zero(t, allocs)
+}
@@ -36,6 +36,8 @@ var _ = log.Printf
 // Any of these can be preceded by PANIC|<method>|, to cause the
 // named method on fakeStmt to panic.
 //
+// SAVEPOINT, RELEASE and ROLLBACK TO statements are accepted and ignored.
+//
 // When opening a fakeDriver's database, it starts empty with no
 // tables.  All tables and data are stored in memory only.
 type fakeDriver struct {
@@ -479,6 +481,16 @@ func (c *fakeConn) prepareInsert(stmt *fakeStmt, parts []string) (driver.Stmt, e
 // hook to simulate broken connections
 var hookPrepareBadConn func() bool
 
+func isFakeSavepoint(cmd string) bool {
+	cmd = strings.ToUpper(strings.TrimSpace(cmd))
+	for _, prefix := range []string{"SAVEPOINT ", "RELEASE ", "ROLLBACK "} {
+		if strings.HasPrefix(cmd, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
 	c.numPrepare++
 	if c.db == nil {
@@ -499,12 +511,15 @@ func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
 		parts = parts[2:]
 	}
 	cmd := parts[0]
+	if isFakeSavepoint(cmd) {
+		cmd = "SAVEPOINT"
+	}
 	stmt.cmd = cmd
 	parts = parts[1:]
 
 	c.incrStat(&c.stmtsMade)
 	switch cmd {
-	case "WIPE":
+	case "WIPE", "SAVEPOINT":
 		// Nothing
 	case "SELECT":
 		return c.prepareSelect(stmt, parts)
@@ -577,6 +592,8 @@ func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
 	case "WIPE":
 		db.wipe()
 		return driver.ResultNoRows, nil
+	case "SAVEPOINT":
+		return driver.ResultNoRows, nil
 	case "CREATE":
 		if err := db.createTable(s.table, s.colName, s.colType); err != nil {
 			return nil, err
@@ -0,0 +1,66 @@
+package sqlhooks
+
+import "bytes"
+
+// Fingerprint returns query with its string and numeric literals replaced by
+// ?, and runs of whitespace collapsed to a single space, so queries that
+// differ only in their literal values share the same fingerprint.
+// Fingerprints are cached, see SetQueryCacheSize.
+func Fingerprint(query string) string {
+	info := analyses.get(query)
+	if info == nil {
+		return fingerprint(query)
+	}
+	info.fingerprintOnce.Do(func() { info.fingerprint = fingerprint(query) })
+	return info.fingerprint
+}
+
+func fingerprint(query string) string {
+	var b bytes.Buffer
+	b.Grow(len(query))
+
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = b.Len() > 0
+			continue
+		case space:
+			b.WriteByte(' ')
+			space = false
+		}
+
+		switch {
+		case c == '\'':
+			// Skip to the closing quote, '' is an escaped quote
+			for i++; i < len(query); i++ {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case isDigit(c) && (i == 0 || !isIdentByte(query[i-1])):
+			for i+1 < len(query) && (isDigit(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// isIdentByte reports whether c can be part of an identifier or placeholder, as in t1 or $1
+func isIdentByte(c byte) bool {
+	return isDigit(c) || c == '_' || c == '$' || c == '.' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
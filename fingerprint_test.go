@@ -0,0 +1,19 @@
+package sqlhooks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	for query, expected := range map[string]string{
+		"SELECT * FROM t WHERE id = 1":                      "SELECT * FROM t WHERE id = ?",
+		"SELECT * FROM t WHERE name = 'o''brien' AND x=2.5": "SELECT * FROM t WHERE name = ? AND x=?",
+		"  SELECT f1\n\tFROM t1  WHERE f2 = $1 ":            "SELECT f1 FROM t1 WHERE f2 = $1",
+		"INSERT INTO t VALUES(?, 'foo', -3)":                "INSERT INTO t VALUES(?, ?, -?)",
+		"SELECT 'unterminated":                              "SELECT ?",
+	} {
+		assert.Equal(t, expected, Fingerprint(query), query)
+	}
+}
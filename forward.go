@@ -0,0 +1,120 @@
+package sqlhooks
+
+import (
+	"database/sql/driver"
+	"io"
+	"reflect"
+)
+
+// nextRower is the NextRow method of driver.RowsColumnScanner, declared for
+// the Go versions before it
+type nextRower interface {
+	NextRow() error
+}
+
+// nextRow reads the next row of rows, of n columns, with NextRow if they
+// have it, as database/sql would
+func nextRow(rows driver.Rows, n int) error {
+	if r, ok := rows.(nextRower); ok {
+		return r.NextRow()
+	}
+	return rows.Next(make([]driver.Value, n))
+}
+
+// forwardedRows are rows wrapping inner, the rows they were given, which
+// forward the optional interfaces of driver.Rows to inner. The ones inner
+// doesn't implement return what database/sql assumes when they're missing.
+type forwardedRows struct {
+	driver.Rows
+	inner driver.Rows
+}
+
+// forwardRows returns rows, wrapping inner, implementing the optional
+// interfaces of driver.Rows when inner implements any, so wrapping rows
+// doesn't hide the column types or the result sets of the driver from
+// database/sql
+func forwardRows(rows, inner driver.Rows) driver.Rows {
+	f := forwardedRows{rows, inner}
+	if scanner, ok := forwardColumnScanner(f); ok {
+		return scanner
+	}
+	if hasOptional(inner) {
+		return f
+	}
+	return rows
+}
+
+// forwardWrappedRows is forwardRows for the rows a RowsWrapper returned,
+// wrapping inner, unless they implement optional interfaces of their own.
+// driver.RowsColumnScanner isn't forwarded: the rows are read through the
+// Next of the wrapper, which the hooks rely on.
+func forwardWrappedRows(rows, inner driver.Rows) driver.Rows {
+	if hasOptional(rows) || !hasOptional(inner) {
+		return rows
+	}
+	return forwardedRows{rows, inner}
+}
+
+// hasOptional reports whether rows implement any of the optional
+// interfaces of driver.Rows forwardedRows forward
+func hasOptional(rows driver.Rows) bool {
+	switch rows.(type) {
+	case driver.RowsNextResultSet,
+		driver.RowsColumnTypeScanType,
+		driver.RowsColumnTypeDatabaseTypeName,
+		driver.RowsColumnTypeLength,
+		driver.RowsColumnTypeNullable,
+		driver.RowsColumnTypePrecisionScale:
+		return true
+	}
+	return false
+}
+
+func (r forwardedRows) HasNextResultSet() bool {
+	if n, ok := r.inner.(driver.RowsNextResultSet); ok {
+		return n.HasNextResultSet()
+	}
+	return false
+}
+
+func (r forwardedRows) NextResultSet() error {
+	if n, ok := r.inner.(driver.RowsNextResultSet); ok {
+		return n.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r forwardedRows) ColumnTypeScanType(index int) reflect.Type {
+	if t, ok := r.inner.(driver.RowsColumnTypeScanType); ok {
+		return t.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r forwardedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if t, ok := r.inner.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return t.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r forwardedRows) ColumnTypeLength(index int) (int64, bool) {
+	if t, ok := r.inner.(driver.RowsColumnTypeLength); ok {
+		return t.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r forwardedRows) ColumnTypeNullable(index int) (bool, bool) {
+	if t, ok := r.inner.(driver.RowsColumnTypeNullable); ok {
+		return t.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r forwardedRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if t, ok := r.inner.(driver.RowsColumnTypePrecisionScale); ok {
+		return t.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,31 @@
+//go:build go1.27
+// +build go1.27
+
+package sqlhooks
+
+import "database/sql/driver"
+
+// scannerRows are forwardedRows whose inner rows implement
+// driver.RowsColumnScanner. NextRow goes through the wrapping rows when they
+// have it, as closingRows do to count the rows, ScanColumn straight to inner.
+type scannerRows struct {
+	forwardedRows
+}
+
+func forwardColumnScanner(r forwardedRows) (driver.Rows, bool) {
+	if _, ok := r.inner.(driver.RowsColumnScanner); !ok {
+		return nil, false
+	}
+	return scannerRows{r}, true
+}
+
+func (r scannerRows) NextRow() error {
+	if n, ok := r.Rows.(nextRower); ok {
+		return n.NextRow()
+	}
+	return r.inner.(driver.RowsColumnScanner).NextRow()
+}
+
+func (r scannerRows) ScanColumn(scanCtx driver.ScanContext, index int, dest interface{}) error {
+	return r.inner.(driver.RowsColumnScanner).ScanColumn(scanCtx, index, dest)
+}
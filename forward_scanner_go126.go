@@ -0,0 +1,12 @@
+//go:build !go1.27
+// +build !go1.27
+
+package sqlhooks
+
+import "database/sql/driver"
+
+// forwardColumnScanner has nothing to forward before Go 1.27, which added
+// driver.RowsColumnScanner
+func forwardColumnScanner(r forwardedRows) (driver.Rows, bool) {
+	return nil, false
+}
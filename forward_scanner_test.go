@@ -0,0 +1,100 @@
+//go:build go1.27
+// +build go1.27
+
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	sql.Register("sqlhooks-scanner", scannerDriver{})
+
+	interfaceTypes["RowsColumnScanner"] = reflect.TypeOf((*driver.RowsColumnScanner)(nil)).Elem()
+	wrapperTypes["rows"] = rowsWrapperTypes(&scanningRows{})
+}
+
+// scanningRows are typedRows implementing driver.RowsColumnScanner, with
+// rows of their number times ten
+type scanningRows struct {
+	typedRows
+	rows, row int
+}
+
+func (*scanningRows) Columns() []string { return []string{"n"} }
+
+func (*scanningRows) Next(dest []driver.Value) error {
+	return errors.New("Next called on a RowsColumnScanner")
+}
+
+func (r *scanningRows) NextRow() error {
+	if r.row == r.rows {
+		return io.EOF
+	}
+	r.row++
+	return nil
+}
+
+func (r *scanningRows) ScanColumn(scanCtx driver.ScanContext, index int, dest interface{}) error {
+	return sql.ConvertAssign(scanCtx, dest, int64(r.row*10))
+}
+
+type scannerDriver struct{}
+
+func (scannerDriver) Open(dsn string) (driver.Conn, error) {
+	return rowsQueryConn{}, nil
+}
+
+// rowsQueryConn returns new scanningRows to every query
+type rowsQueryConn struct {
+	anyConn
+}
+
+func (rowsQueryConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return &scanningRows{rows: 3}, nil
+}
+
+// returnedHooks records the rows returned by the queries
+type returnedHooks struct {
+	NopHooks
+	returned []int64
+}
+
+func (h *returnedHooks) AfterRowsClose(ctx *Context) error {
+	h.returned = append(h.returned, ctx.RowsReturned)
+	return ctx.Error
+}
+
+func TestForwardColumnScanner(t *testing.T) {
+	hooks := &returnedHooks{}
+	db, err := Open("sqlhooks-scanner", "", hooks)
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.Background(), "SELECT n")
+	require.NoError(t, err)
+	var ns []int
+	for rows.Next() {
+		var n int
+		require.NoError(t, rows.Scan(&n))
+		ns = append(ns, n)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	assert.Equal(t, []int{10, 20, 30}, ns)
+
+	// Rows left are found reading them with NextRow as well
+	var n int
+	require.NoError(t, db.QueryRow("SELECT n").Scan(&n))
+	assert.Equal(t, 10, n)
+	assert.Equal(t, []int64{3, 1}, hooks.returned)
+}
@@ -0,0 +1,333 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// driverInterfaces are all the interfaces of database/sql/driver, with the
+// wrapper implementing them for the underlying driver, or the reason none
+// does. TestDriverInterfacesListed fails once a Go version adds one, it
+// needs to be listed here, and forwarded, or excluded.
+var driverInterfaces = []struct {
+	name     string
+	wrapper  string
+	excluded string
+}{
+	{name: "Driver", wrapper: "Driver"},
+	{name: "DriverContext", wrapper: "Driver"},
+	{name: "Connector", wrapper: "connector"},
+	{name: "Conn", wrapper: "conn"},
+	{name: "Pinger", wrapper: "conn"},
+	{name: "Execer", wrapper: "conn"},
+	{name: "ExecerContext", wrapper: "conn"},
+	{name: "Queryer", wrapper: "conn"},
+	{name: "QueryerContext", wrapper: "conn"},
+	{name: "ConnPrepareContext", wrapper: "conn"},
+	{name: "ConnBeginTx", wrapper: "conn"},
+	{name: "SessionResetter", wrapper: "conn"},
+	{name: "Validator", wrapper: "conn"},
+	{name: "NamedValueChecker", wrapper: "conn"},
+	{name: "Stmt", wrapper: "stmt"},
+	{name: "StmtExecContext", wrapper: "stmt"},
+	{name: "StmtQueryContext", wrapper: "stmt"},
+	{name: "ColumnConverter", excluded: "applied by the CheckNamedValue of stmt, which database/sql calls first"},
+	{name: "Tx", wrapper: "tx"},
+	{name: "Result", excluded: "results are the underlying driver's"},
+	{name: "Rows", wrapper: "rows"},
+	{name: "RowsNextResultSet", wrapper: "rows"},
+	{name: "RowsColumnTypeScanType", wrapper: "rows"},
+	{name: "RowsColumnTypeDatabaseTypeName", wrapper: "rows"},
+	{name: "RowsColumnTypeLength", wrapper: "rows"},
+	{name: "RowsColumnTypeNullable", wrapper: "rows"},
+	{name: "RowsColumnTypePrecisionScale", wrapper: "rows"},
+	{name: "RowsColumnScanner", wrapper: "rows"},
+	{name: "Valuer", excluded: "implemented by the arguments, not by drivers"},
+	{name: "ValueConverter", excluded: "implemented by the converters drivers return"},
+}
+
+// interfaceTypes are the driverInterfaces of the Go version running the
+// tests, the ones added after Go 1.8 are registered by the tests built for
+// them. SessionResetter and Validator are the package's declarations.
+var interfaceTypes = map[string]reflect.Type{
+	"Driver":                         reflect.TypeOf((*driver.Driver)(nil)).Elem(),
+	"Conn":                           reflect.TypeOf((*driver.Conn)(nil)).Elem(),
+	"Pinger":                         reflect.TypeOf((*driver.Pinger)(nil)).Elem(),
+	"Execer":                         reflect.TypeOf((*driver.Execer)(nil)).Elem(),
+	"ExecerContext":                  reflect.TypeOf((*driver.ExecerContext)(nil)).Elem(),
+	"Queryer":                        reflect.TypeOf((*driver.Queryer)(nil)).Elem(),
+	"QueryerContext":                 reflect.TypeOf((*driver.QueryerContext)(nil)).Elem(),
+	"ConnPrepareContext":             reflect.TypeOf((*driver.ConnPrepareContext)(nil)).Elem(),
+	"ConnBeginTx":                    reflect.TypeOf((*driver.ConnBeginTx)(nil)).Elem(),
+	"SessionResetter":                reflect.TypeOf((*sessionResetter)(nil)).Elem(),
+	"Validator":                      reflect.TypeOf((*validator)(nil)).Elem(),
+	"NamedValueChecker":              reflect.TypeOf((*namedValueChecker)(nil)).Elem(),
+	"Stmt":                           reflect.TypeOf((*driver.Stmt)(nil)).Elem(),
+	"StmtExecContext":                reflect.TypeOf((*driver.StmtExecContext)(nil)).Elem(),
+	"StmtQueryContext":               reflect.TypeOf((*driver.StmtQueryContext)(nil)).Elem(),
+	"Tx":                             reflect.TypeOf((*driver.Tx)(nil)).Elem(),
+	"Rows":                           reflect.TypeOf((*driver.Rows)(nil)).Elem(),
+	"RowsNextResultSet":              reflect.TypeOf((*driver.RowsNextResultSet)(nil)).Elem(),
+	"RowsColumnTypeScanType":         reflect.TypeOf((*driver.RowsColumnTypeScanType)(nil)).Elem(),
+	"RowsColumnTypeDatabaseTypeName": reflect.TypeOf((*driver.RowsColumnTypeDatabaseTypeName)(nil)).Elem(),
+	"RowsColumnTypeLength":           reflect.TypeOf((*driver.RowsColumnTypeLength)(nil)).Elem(),
+	"RowsColumnTypeNullable":         reflect.TypeOf((*driver.RowsColumnTypeNullable)(nil)).Elem(),
+	"RowsColumnTypePrecisionScale":   reflect.TypeOf((*driver.RowsColumnTypePrecisionScale)(nil)).Elem(),
+}
+
+// wrapperTypes are the types of the wrappers of driverInterfaces, the rows
+// wrappers as forwardRows returns them for rows implementing every
+// optional interface
+var wrapperTypes = map[string][]reflect.Type{
+	"Driver": {reflect.TypeOf(&Driver{})},
+	"conn":   {reflect.TypeOf(&conn{})},
+	"stmt":   {reflect.TypeOf(&stmt{})},
+	"tx":     {reflect.TypeOf(tx{})},
+	"rows":   rowsWrapperTypes(&typedRows{}),
+}
+
+// rowsWrapperTypes returns the types of every rows wrapper wrapping inner
+func rowsWrapperTypes(inner driver.Rows) []reflect.Type {
+	var types []reflect.Type
+	for _, rows := range []driver.Rows{&closingRows{Rows: inner}, explainedRows{Rows: inner}, stmtRows{Rows: inner}, cachedRows{Rows: inner}} {
+		types = append(types, reflect.TypeOf(forwardRows(rows, inner)))
+	}
+	return types
+}
+
+func TestDriverInterfacesListed(t *testing.T) {
+	dir := filepath.Join(build.Default.GOROOT, "src", "database", "sql", "driver")
+	pkgs, err := parser.ParseDir(token.NewFileSet(), dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Skipf("no sources of database/sql/driver: %v", err)
+	}
+
+	// listed are the names of driverInterfaces, true for the ones forwarded
+	listed := make(map[string]bool)
+	for _, i := range driverInterfaces {
+		listed[i.name] = i.excluded == ""
+	}
+	for _, file := range pkgs["driver"].Files {
+		for name, object := range file.Scope.Objects {
+			spec, ok := object.Decl.(*ast.TypeSpec)
+			if !ok || !ast.IsExported(name) {
+				continue
+			}
+			if _, ok := spec.Type.(*ast.InterfaceType); !ok {
+				continue
+			}
+			forwarded, ok := listed[name]
+			assert.True(t, ok, "driver.%s isn't in driverInterfaces", name)
+			if forwarded {
+				assert.NotNil(t, interfaceTypes[name], "driver.%s has no type in interfaceTypes", name)
+			}
+		}
+	}
+}
+
+func TestDriverInterfacesForwarded(t *testing.T) {
+	for _, i := range driverInterfaces {
+		if i.excluded != "" {
+			assert.Empty(t, i.wrapper, "driver.%s is both forwarded and excluded", i.name)
+			continue
+		}
+		typ := interfaceTypes[i.name]
+		if typ == nil {
+			// Not in the Go version running the tests
+			continue
+		}
+		require.NotEmpty(t, wrapperTypes[i.wrapper], "no wrapper %s", i.wrapper)
+		for _, wrapper := range wrapperTypes[i.wrapper] {
+			assert.True(t, wrapper.Implements(typ), "%s doesn't implement driver.%s", wrapper, i.name)
+		}
+	}
+}
+
+// typedRows are rows implementing the optional interfaces of driver.Rows of Go 1.8
+type typedRows struct {
+	anyRows
+	sets int
+}
+
+func (r *typedRows) HasNextResultSet() bool { return r.sets > 1 }
+
+func (r *typedRows) NextResultSet() error {
+	if r.sets <= 1 {
+		return io.EOF
+	}
+	r.sets--
+	return nil
+}
+
+func (typedRows) ColumnTypeScanType(index int) reflect.Type         { return reflect.TypeOf(int64(0)) }
+func (typedRows) ColumnTypeDatabaseTypeName(index int) string       { return "BIGINT" }
+func (typedRows) ColumnTypeLength(index int) (int64, bool)          { return 8, true }
+func (typedRows) ColumnTypeNullable(index int) (bool, bool)         { return true, true }
+func (typedRows) ColumnTypePrecisionScale(int) (int64, int64, bool) { return 19, 0, true }
+
+// namedRows are rows only telling their database type names
+type namedRows struct {
+	anyRows
+}
+
+func (namedRows) ColumnTypeDatabaseTypeName(index int) string { return "TEXT" }
+
+// rowsConn is a connection whose queries return rows
+type rowsConn struct {
+	anyConn
+	rows driver.Rows
+}
+
+func (c rowsConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.rows, nil
+}
+
+type rowsCloseHooks struct {
+	NopHooks
+}
+
+func (rowsCloseHooks) AfterRowsClose(ctx *Context) error { return ctx.Error }
+
+func queryRows(t *testing.T, rows driver.Rows) driver.Rows {
+	c, err := NewDriver("", rowsCloseHooks{}).wrap(context.Background(), "", rowsConn{rows: rows})
+	require.NoError(t, err)
+	rows, err = c.(driver.QueryerContext).QueryContext(context.Background(), "SELECT 1", nil)
+	require.NoError(t, err)
+	return rows
+}
+
+func TestForwardRows(t *testing.T) {
+	rows := queryRows(t, &typedRows{sets: 2})
+	require.Implements(t, (*driver.RowsNextResultSet)(nil), rows)
+	require.Implements(t, (*driver.RowsColumnTypePrecisionScale)(nil), rows)
+	assert.Equal(t, "BIGINT", rows.(driver.RowsColumnTypeDatabaseTypeName).ColumnTypeDatabaseTypeName(0))
+	assert.Equal(t, reflect.TypeOf(int64(0)), rows.(driver.RowsColumnTypeScanType).ColumnTypeScanType(0))
+	precision, scale, ok := rows.(driver.RowsColumnTypePrecisionScale).ColumnTypePrecisionScale(0)
+	assert.Equal(t, []interface{}{int64(19), int64(0), true}, []interface{}{precision, scale, ok})
+
+	sets := rows.(driver.RowsNextResultSet)
+	assert.True(t, sets.HasNextResultSet())
+	assert.NoError(t, sets.NextResultSet())
+	assert.False(t, sets.HasNextResultSet())
+	assert.Equal(t, io.EOF, sets.NextResultSet())
+	assert.NoError(t, rows.Close())
+
+	// The ones the driver's rows don't implement return database/sql's defaults
+	rows = queryRows(t, namedRows{})
+	assert.Equal(t, "TEXT", rows.(driver.RowsColumnTypeDatabaseTypeName).ColumnTypeDatabaseTypeName(0))
+	assert.Equal(t, reflect.TypeOf(new(interface{})).Elem(), rows.(driver.RowsColumnTypeScanType).ColumnTypeScanType(0))
+	nullable, ok := rows.(driver.RowsColumnTypeNullable).ColumnTypeNullable(0)
+	assert.False(t, nullable || ok)
+	assert.False(t, rows.(driver.RowsNextResultSet).HasNextResultSet())
+
+	// Rows without any aren't wrapped
+	assert.IsType(t, &closingRows{}, queryRows(t, anyRows{}))
+}
+
+// wrappingHooks wrap the rows of the queries in rows implementing none of
+// the optional interfaces of driver.Rows
+type wrappingHooks struct {
+	rowsCloseHooks
+}
+
+func (wrappingHooks) WrapRows(ctx *Context, rows driver.Rows) driver.Rows {
+	return struct{ driver.Rows }{rows}
+}
+
+func TestForwardWrappedRows(t *testing.T) {
+	c, err := NewDriver("", wrappingHooks{}).wrap(context.Background(), "", rowsConn{rows: &typedRows{sets: 2}})
+	require.NoError(t, err)
+	rows, err := c.(driver.QueryerContext).QueryContext(context.Background(), "SELECT 1", nil)
+	require.NoError(t, err)
+
+	require.Implements(t, (*driver.RowsNextResultSet)(nil), rows)
+	assert.Equal(t, "BIGINT", rows.(driver.RowsColumnTypeDatabaseTypeName).ColumnTypeDatabaseTypeName(0))
+	assert.NoError(t, rows.(driver.RowsNextResultSet).NextResultSet())
+	assert.False(t, rows.(driver.RowsNextResultSet).HasNextResultSet())
+	assert.NoError(t, rows.Close())
+
+	// The wrapping rows implementing optional interfaces forward them on their own
+	wrapped := namedRows{}
+	assert.Equal(t, wrapped, forwardWrappedRows(wrapped, &typedRows{}))
+}
+
+// pingConn is a connection implementing driver.Pinger
+type pingConn struct {
+	anyConn
+	err error
+}
+
+func (c pingConn) Ping(ctx context.Context) error { return c.err }
+
+func TestPing(t *testing.T) {
+	errPing := errors.New("server gone")
+	c, err := NewDriver("", NopHooks{}).wrap(context.Background(), "", pingConn{err: errPing})
+	require.NoError(t, err)
+	assert.Equal(t, errPing, c.(driver.Pinger).Ping(context.Background()))
+
+	c, err = NewDriver("", NopHooks{}).wrap(context.Background(), "", anyConn{})
+	require.NoError(t, err)
+	assert.NoError(t, c.(driver.Pinger).Ping(context.Background()))
+}
+
+// convertingConn prepares statements implementing driver.ColumnConverter
+type convertingConn struct {
+	anyConn
+}
+
+func (convertingConn) Prepare(query string) (driver.Stmt, error) { return convertingStmt{}, nil }
+
+type convertingStmt struct {
+	anyStmt
+}
+
+func (convertingStmt) ColumnConverter(idx int) driver.ValueConverter { return upperConverter{} }
+
+type upperConverter struct{}
+
+func (upperConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	if s, ok := v.(string); ok {
+		return strings.ToUpper(s), nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+type nullValuer struct{}
+
+func (nullValuer) Value() (driver.Value, error) { return "set", nil }
+
+func TestColumnConverter(t *testing.T) {
+	c, err := NewDriver("", NopHooks{}).wrap(context.Background(), "", convertingConn{})
+	require.NoError(t, err)
+	s, err := c.Prepare("INSERT INTO t VALUES (?, ?)")
+	require.NoError(t, err)
+
+	checker := s.(driver.NamedValueChecker)
+	nv := driver.NamedValue{Ordinal: 1, Value: "bob"}
+	require.NoError(t, checker.CheckNamedValue(&nv))
+	assert.Equal(t, "BOB", nv.Value)
+
+	// Valuers are called first, nil pointers to value receivers are NULL
+	nv = driver.NamedValue{Ordinal: 2, Value: nullValuer{}}
+	require.NoError(t, checker.CheckNamedValue(&nv))
+	assert.Equal(t, "SET", nv.Value)
+	nv = driver.NamedValue{Ordinal: 2, Value: (*nullValuer)(nil)}
+	require.NoError(t, checker.CheckNamedValue(&nv))
+	assert.Nil(t, nv.Value)
+}
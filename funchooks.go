@@ -0,0 +1,121 @@
+package sqlhooks
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Funcs holds the functions attached Before and After an operation.
+// A nil Before does nothing and a nil After returns ctx.Error.
+type Funcs struct {
+	Before func(*Context) error
+	After  func(*Context) error
+}
+
+func (f Funcs) before(ctx *Context) error {
+	if f.Before == nil {
+		return nil
+	}
+	return f.Before(ctx)
+}
+
+func (f Funcs) after(ctx *Context) error {
+	if f.After == nil {
+		return ctx.Error
+	}
+	return f.After(ctx)
+}
+
+// FuncHooks implements every hook interface with optional functions,
+// so hooks can be built without declaring a type
+type FuncHooks struct {
+	Query     Funcs
+	Exec      Funcs
+	Begin     Funcs
+	Commit    Funcs
+	Rollback  Funcs
+	Prepare   Funcs
+	StmtQuery Funcs
+	StmtExec  Funcs
+
+	// minDuration is the MinDuration threshold, for Describe
+	minDuration time.Duration
+}
+
+func (h *FuncHooks) BeforeQuery(ctx *Context) error { return h.Query.before(ctx) }
+func (h *FuncHooks) AfterQuery(ctx *Context) error  { return h.Query.after(ctx) }
+
+func (h *FuncHooks) BeforeExec(ctx *Context) error { return h.Exec.before(ctx) }
+func (h *FuncHooks) AfterExec(ctx *Context) error  { return h.Exec.after(ctx) }
+
+func (h *FuncHooks) BeforeBegin(ctx *Context) error { return h.Begin.before(ctx) }
+func (h *FuncHooks) AfterBegin(ctx *Context) error  { return h.Begin.after(ctx) }
+
+func (h *FuncHooks) BeforeCommit(ctx *Context) error { return h.Commit.before(ctx) }
+func (h *FuncHooks) AfterCommit(ctx *Context) error  { return h.Commit.after(ctx) }
+
+func (h *FuncHooks) BeforeRollback(ctx *Context) error { return h.Rollback.before(ctx) }
+func (h *FuncHooks) AfterRollback(ctx *Context) error  { return h.Rollback.after(ctx) }
+
+func (h *FuncHooks) BeforePrepare(ctx *Context) error { return h.Prepare.before(ctx) }
+func (h *FuncHooks) AfterPrepare(ctx *Context) error  { return h.Prepare.after(ctx) }
+
+func (h *FuncHooks) BeforeStmtQuery(ctx *Context) error { return h.StmtQuery.before(ctx) }
+func (h *FuncHooks) AfterStmtQuery(ctx *Context) error  { return h.StmtQuery.after(ctx) }
+
+func (h *FuncHooks) BeforeStmtExec(ctx *Context) error { return h.StmtExec.before(ctx) }
+func (h *FuncHooks) AfterStmtExec(ctx *Context) error  { return h.StmtExec.after(ctx) }
+
+// MinDuration returns a copy of hooks whose After functions only run for
+// operations that took at least d, or that failed.
+// Since the duration isn't known before the operation runs, hooks can't have
+// any Before function, an error is returned otherwise.
+func MinDuration(d time.Duration, hooks *FuncHooks) (*FuncHooks, error) {
+	var before []string
+	for _, op := range []struct {
+		name  string
+		funcs Funcs
+	}{
+		{"Query", hooks.Query},
+		{"Exec", hooks.Exec},
+		{"Begin", hooks.Begin},
+		{"Commit", hooks.Commit},
+		{"Rollback", hooks.Rollback},
+		{"Prepare", hooks.Prepare},
+		{"StmtQuery", hooks.StmtQuery},
+		{"StmtExec", hooks.StmtExec},
+	} {
+		if op.funcs.Before != nil {
+			before = append(before, op.name)
+		}
+	}
+	if len(before) > 0 {
+		return nil, fmt.Errorf("sqlhooks: MinDuration only supports After functions, got Before for %s", strings.Join(before, ", "))
+	}
+
+	gate := func(f Funcs) Funcs {
+		if f.After == nil {
+			return f
+		}
+		return Funcs{After: func(ctx *Context) error {
+			if ctx.Error == nil && ctx.Duration < d {
+				return ctx.Error
+			}
+			return f.After(ctx)
+		}}
+	}
+
+	return &FuncHooks{
+		Query:     gate(hooks.Query),
+		Exec:      gate(hooks.Exec),
+		Begin:     gate(hooks.Begin),
+		Commit:    gate(hooks.Commit),
+		Rollback:  gate(hooks.Rollback),
+		Prepare:   gate(hooks.Prepare),
+		StmtQuery: gate(hooks.StmtQuery),
+		StmtExec:  gate(hooks.StmtExec),
+
+		minDuration: d,
+	}, nil
+}
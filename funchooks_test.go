@@ -0,0 +1,81 @@
+package sqlhooks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuncHooksNilAfterReturnsError(t *testing.T) {
+	hooks := &FuncHooks{}
+	ctx := NewContext()
+	ctx.Error = errors.New("boom")
+
+	assert.NoError(t, hooks.BeforeQuery(ctx))
+	assert.Equal(t, ctx.Error, hooks.AfterQuery(ctx))
+}
+
+func TestMinDurationRejectsBeforeFuncs(t *testing.T) {
+	fn := func(ctx *Context) error { return nil }
+	_, err := MinDuration(time.Millisecond, &FuncHooks{
+		Query: Funcs{Before: fn, After: fn},
+		Exec:  Funcs{Before: fn},
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Query, Exec")
+}
+
+func TestMinDurationGatesAfterFuncs(t *testing.T) {
+	var calls int
+	hooks, err := MinDuration(10*time.Millisecond, &FuncHooks{
+		Exec: Funcs{After: func(ctx *Context) error {
+			calls++
+			return ctx.Error
+		}},
+	})
+	require.NoError(t, err)
+
+	ctx := NewContext()
+	ctx.Duration = time.Millisecond
+	assert.NoError(t, hooks.AfterExec(ctx))
+	assert.Equal(t, 0, calls)
+
+	ctx.Duration = 10 * time.Millisecond
+	assert.NoError(t, hooks.AfterExec(ctx))
+	assert.Equal(t, 1, calls)
+
+	ctx.Duration = time.Millisecond
+	ctx.Error = errors.New("boom")
+	assert.Equal(t, ctx.Error, hooks.AfterExec(ctx))
+	assert.Equal(t, 2, calls)
+}
+
+func TestMinDurationThroughDriver(t *testing.T) {
+	var reported []string
+	record := func(ctx *Context) error {
+		reported = append(reported, ctx.Query)
+		return ctx.Error
+	}
+	hooks, err := MinDuration(time.Hour, &FuncHooks{
+		Exec:     Funcs{After: record},
+		Prepare:  Funcs{After: record},
+		StmtExec: Funcs{After: record},
+	})
+	require.NoError(t, err)
+
+	db := openDBWithHooks(t, hooks)
+	defer db.Close()
+
+	q := queries[*driverFlag]
+	_, err = db.Exec(q.insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Empty(t, reported)
+
+	_, err = db.Exec("invalid query")
+	assert.Error(t, err)
+	assert.NotEmpty(t, reported)
+}
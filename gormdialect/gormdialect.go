@@ -0,0 +1,30 @@
+// +build gorm
+
+/*
+Package gormdialect builds GORM dialectors on top of drivers wrapped by sqlhooks.
+
+GORM opens the connections itself, by driver name, so the hooked driver has to
+be registered first and its name given to the dialector:
+
+	name := sqlhooks.RegisterUnique("sqlite3", sqlhooks.NewDriver("sqlite3", hooks))
+	db, err := gorm.Open(gormdialect.SQLite(name, "test.db"), &gorm.Config{})
+
+The other GORM dialectors take the driver name in their config as well, as in
+postgres.New(postgres.Config{DriverName: name, DSN: dsn}). Every statement GORM
+runs goes through the hooks, with PrepareStmt as prepared statements.
+
+GORM needs Go 1.18, so the package is built only with the gorm tag:
+	go test -tags gorm ./gormdialect
+*/
+package gormdialect
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// SQLite returns a GORM SQLite dialector opening dsn with the driver
+// registered as name, which must wrap sqlite3
+func SQLite(name, dsn string) gorm.Dialector {
+	return sqlite.New(sqlite.Config{DriverName: name, DSN: dsn})
+}
@@ -0,0 +1,274 @@
+// +build gorm
+
+package gormdialect
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type User struct {
+	ID   uint
+	Name string
+	Age  int
+	Pets []Pet
+}
+
+type Pet struct {
+	ID     uint
+	UserID uint
+	Name   string
+}
+
+type ctxKey struct{}
+
+// recorder records the statements reaching the hooks, the Before hooks
+// missing their After and the other way around, and the std contexts seen
+type recorder struct {
+	mu        sync.Mutex
+	stmts     []string
+	prepares  int
+	begins    []driver.TxOptions
+	commits   int
+	rollbacks int
+	unpaired  int
+	ctxValues []interface{}
+}
+
+func (r *recorder) before(ctx *sqlhooks.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stmts = append(r.stmts, ctx.Query)
+	if ctx.Ctx != nil {
+		r.ctxValues = append(r.ctxValues, ctx.Ctx.Value(ctxKey{}))
+	}
+	ctx.Set("recorded", true)
+	return nil
+}
+
+func (r *recorder) after(ctx *sqlhooks.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ctx.Get("recorded") != true {
+		r.unpaired++
+	}
+	ctx.Set("recorded", false)
+	return ctx.Error
+}
+
+func (r *recorder) count(n *int) sqlhooks.Funcs {
+	return sqlhooks.Funcs{After: func(ctx *sqlhooks.Context) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		*n++
+		return ctx.Error
+	}}
+}
+
+func (r *recorder) hooks() *sqlhooks.FuncHooks {
+	stmt := sqlhooks.Funcs{Before: r.before, After: r.after}
+	return &sqlhooks.FuncHooks{
+		Query:     stmt,
+		Exec:      stmt,
+		StmtQuery: stmt,
+		StmtExec:  stmt,
+		Prepare:   r.count(&r.prepares),
+		Begin: sqlhooks.Funcs{After: func(ctx *sqlhooks.Context) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.begins = append(r.begins, ctx.TxOptions)
+			return ctx.Error
+		}},
+		Commit:   r.count(&r.commits),
+		Rollback: r.count(&r.rollbacks),
+	}
+}
+
+func (r *recorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stmts, r.prepares, r.begins, r.commits, r.rollbacks = nil, 0, nil, 0, 0
+	r.unpaired, r.ctxValues = 0, nil
+}
+
+// issued records the SQL of every statement GORM runs
+type issued struct {
+	mu    sync.Mutex
+	stmts []string
+}
+
+func (i *issued) record(db *gorm.DB) {
+	if db.Statement.SQL.Len() == 0 {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.stmts = append(i.stmts, db.Statement.SQL.String())
+}
+
+func (i *issued) register(t *testing.T, db *gorm.DB) {
+	cb := db.Callback()
+	require.NoError(t, cb.Create().After("gorm:create").Register("sqlhooks:issued", i.record))
+	require.NoError(t, cb.Query().After("gorm:query").Register("sqlhooks:issued", i.record))
+	require.NoError(t, cb.Update().After("gorm:update").Register("sqlhooks:issued", i.record))
+	require.NoError(t, cb.Delete().After("gorm:delete").Register("sqlhooks:issued", i.record))
+	require.NoError(t, cb.Row().After("gorm:row").Register("sqlhooks:issued", i.record))
+	require.NoError(t, cb.Raw().After("gorm:raw").Register("sqlhooks:issued", i.record))
+}
+
+func openGorm(t *testing.T, config *gorm.Config) (*gorm.DB, *recorder, *issued, func()) {
+	dir, err := ioutil.TempDir("", "gormdialect")
+	require.NoError(t, err)
+
+	rec := &recorder{}
+	name := sqlhooks.RegisterUnique("sqlite3", sqlhooks.NewDriver("sqlite3", rec.hooks()))
+
+	config.Logger = logger.Default.LogMode(logger.Silent)
+	db, err := gorm.Open(SQLite(name, filepath.Join(dir, "gorm.db")), config)
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}, &Pet{}))
+
+	is := &issued{}
+	is.register(t, db)
+	rec.reset()
+
+	return db, rec, is, func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// flows runs the usual GORM operations
+func flows(t *testing.T, db *gorm.DB) {
+	alice := User{Name: "alice", Age: 30, Pets: []Pet{{Name: "rex"}, {Name: "tom"}}}
+	require.NoError(t, db.Create(&alice).Error)
+	require.NoError(t, db.Create(&[]User{{Name: "bob", Age: 20}, {Name: "carol", Age: 40}}).Error)
+
+	var user User
+	require.NoError(t, db.First(&user, alice.ID).Error)
+	assert.Equal(t, "alice", user.Name)
+
+	var users []User
+	require.NoError(t, db.Where("age > ?", 25).Order("age").Find(&users).Error)
+	assert.Len(t, users, 2)
+
+	require.NoError(t, db.Preload("Pets").First(&user, alice.ID).Error)
+	assert.Len(t, user.Pets, 2)
+
+	var count int64
+	require.NoError(t, db.Model(&User{}).Count(&count).Error)
+	assert.EqualValues(t, 3, count)
+
+	require.NoError(t, db.Model(&user).Update("age", 31).Error)
+	require.NoError(t, db.Model(&user).Updates(map[string]interface{}{"name": "alice2", "age": 32}).Error)
+
+	var ages []int
+	require.NoError(t, db.Raw("SELECT age FROM users WHERE age > ?", 0).Scan(&ages).Error)
+	assert.Len(t, ages, 3)
+
+	var name string
+	require.NoError(t, db.Table("users").Select("name").Where("id = ?", alice.ID).Row().Scan(&name))
+	assert.Equal(t, "alice2", name)
+
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&User{Name: "dave"}).Error; err != nil {
+			return err
+		}
+
+		// nested transactions run savepoints
+		tx.Transaction(func(tx *gorm.DB) error {
+			tx.Create(&User{Name: "eve"})
+			return errors.New("rollback eve")
+		})
+		return nil
+	}))
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		tx.Create(&User{Name: "frank"})
+		return errors.New("rollback frank")
+	})
+	assert.Error(t, err)
+
+	require.NoError(t, db.Where("name = ?", "bob").Delete(&User{}).Error)
+	require.NoError(t, db.Model(&User{}).Count(&count).Error)
+	assert.EqualValues(t, 3, count)
+}
+
+func testStatementsPaired(t *testing.T, config *gorm.Config) {
+	db, rec, is, done := openGorm(t, config)
+	defer done()
+
+	flows(t, db)
+
+	// associations are saved within the callback running their parent, so
+	// both may be recorded in a different order
+	assert.ElementsMatch(t, is.stmts, rec.stmts, "every statement GORM issues must go through the hooks once")
+	assert.Zero(t, rec.unpaired)
+	assert.Equal(t, len(rec.begins), rec.commits+rec.rollbacks)
+	assert.NotZero(t, rec.rollbacks)
+}
+
+func TestStatementsPaired(t *testing.T) {
+	testStatementsPaired(t, &gorm.Config{})
+}
+
+func TestStatementsPairedWithPrepareStmt(t *testing.T) {
+	testStatementsPaired(t, &gorm.Config{PrepareStmt: true})
+}
+
+func TestPrepareStmtPreparesOnce(t *testing.T) {
+	db, rec, _, done := openGorm(t, &gorm.Config{PrepareStmt: true})
+	defer done()
+
+	for i := 0; i < 3; i++ {
+		var user User
+		db.Where("name = ?", "nobody").First(&user)
+	}
+	assert.Equal(t, 1, rec.prepares)
+	assert.Len(t, rec.stmts, 3)
+}
+
+func TestSessionContextReachesHooks(t *testing.T) {
+	db, rec, _, done := openGorm(t, &gorm.Config{})
+	defer done()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "session")
+	session := db.WithContext(ctx)
+	require.NoError(t, session.Create(&User{Name: "alice"}).Error)
+	var users []User
+	require.NoError(t, session.Find(&users).Error)
+
+	require.NotEmpty(t, rec.ctxValues)
+	for _, v := range rec.ctxValues {
+		assert.Equal(t, "session", v)
+	}
+}
+
+func TestTransactionOptionsReachHooks(t *testing.T) {
+	db, rec, _, done := openGorm(t, &gorm.Config{})
+	defer done()
+
+	opts := &sql.TxOptions{Isolation: sql.LevelSerializable}
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&User{Name: "alice"}).Error
+	}, opts))
+
+	require.Len(t, rec.begins, 1)
+	assert.EqualValues(t, sql.LevelSerializable, rec.begins[0].Isolation)
+	assert.Equal(t, 1, rec.commits)
+}
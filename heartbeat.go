@@ -0,0 +1,199 @@
+package sqlhooks
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StillRunner is the interface implemented by hooks following the statements
+// while they run, as the watchdogs and tracers of long analytical queries.
+// With WithHeartbeat, StillRunning is called once the driver call of a Query,
+// Exec, StmtQuery or StmtExec ran for the heartbeat delay, then every
+// interval until it returns, with the time elapsed since it started.
+//
+// StillRunning is called from the goroutine of the heartbeat timer, while the
+// driver call runs: it must not change ctx, and must return quickly as it
+// delays the heartbeats of the other statements. It's never called once the
+// driver call returned, so neither concurrently with nor after the After hook.
+type StillRunner interface {
+	StillRunning(ctx *Context, elapsed time.Duration)
+}
+
+func isStillRunner(h HookType) bool {
+	_, ok := h.(StillRunner)
+	return ok
+}
+
+// WithHeartbeat calls the StillRunning hooks of the statements running for
+// longer than delay, then every interval, see StillRunner. The heartbeats
+// of every connection of the driver are driven by a single timer, not by a
+// goroutine per statement.
+func WithHeartbeat(delay, interval time.Duration) Option {
+	return func(d *Driver) {
+		d.heartbeats = newHeartbeats(delay, interval)
+	}
+}
+
+// heartbeats schedules the beats of the running statements on a single
+// timer, firing the ones due and rescheduling them an interval later
+type heartbeats struct {
+	delay, interval time.Duration
+	now             func() time.Time
+
+	mu     sync.Mutex
+	beats  beatHeap
+	timer  *time.Timer
+	firing bool
+}
+
+// beat is a statement running with heartbeats
+type beat struct {
+	hook  StillRunner
+	ctx   *Context
+	clock clock
+	start instant
+	next  time.Time
+	index int
+
+	// mu is held while StillRunning runs, stopped is set by stop
+	mu      sync.Mutex
+	stopped uint32
+}
+
+func newHeartbeats(delay, interval time.Duration) *heartbeats {
+	if interval <= 0 {
+		interval = delay
+	}
+	return &heartbeats{delay: delay, interval: interval, now: time.Now}
+}
+
+// start starts the heartbeats of ctx's statement, started at start, nil if
+// hooks implement no StillRunner
+func (h *heartbeats) start(hooks HookType, ctx *Context, clock clock, start instant) *beat {
+	if h == nil || ctx == nil || !implementsHook(hooks, isStillRunner) {
+		return nil
+	}
+	b := &beat{hook: hooks.(StillRunner), ctx: ctx, clock: clock, start: start, next: h.now().Add(h.delay)}
+
+	h.mu.Lock()
+	heap.Push(&h.beats, b)
+	if !h.firing && b.index == 0 {
+		h.schedule()
+	}
+	h.mu.Unlock()
+	return b
+}
+
+// stop stops the heartbeats of b, waiting for a StillRunning in progress
+func (h *heartbeats) stop(b *beat) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	atomic.StoreUint32(&b.stopped, 1)
+	b.mu.Unlock()
+
+	h.mu.Lock()
+	if b.index >= 0 {
+		heap.Remove(&h.beats, b.index)
+	}
+	h.mu.Unlock()
+}
+
+// schedule sets the timer to the first beat due, h.mu must be held
+func (h *heartbeats) schedule() {
+	if len(h.beats) == 0 {
+		return
+	}
+	wait := h.beats[0].next.Sub(h.now())
+	if h.timer == nil {
+		h.timer = time.AfterFunc(wait, h.fire)
+	} else {
+		h.timer.Reset(wait)
+	}
+}
+
+// fire calls StillRunning for the beats due and reschedules them
+func (h *heartbeats) fire() {
+	h.mu.Lock()
+	h.firing = true
+	now := h.now()
+	var due []*beat
+	for len(h.beats) > 0 && !h.beats[0].next.After(now) {
+		due = append(due, heap.Pop(&h.beats).(*beat))
+	}
+	h.mu.Unlock()
+
+	for _, b := range due {
+		b.mu.Lock()
+		if atomic.LoadUint32(&b.stopped) == 0 {
+			b.hook.StillRunning(b.ctx, b.clock.since(b.start))
+		}
+		b.mu.Unlock()
+	}
+
+	h.mu.Lock()
+	now = h.now()
+	for _, b := range due {
+		// stop sets stopped before looking b up, under h.mu
+		if atomic.LoadUint32(&b.stopped) != 0 {
+			continue
+		}
+		for !b.next.After(now) {
+			b.next = b.next.Add(h.interval)
+		}
+		heap.Push(&h.beats, b)
+	}
+	h.firing = false
+	h.schedule()
+	h.mu.Unlock()
+}
+
+// beatHeap orders the beats by their next one
+type beatHeap []*beat
+
+func (bh beatHeap) Len() int           { return len(bh) }
+func (bh beatHeap) Less(i, j int) bool { return bh[i].next.Before(bh[j].next) }
+
+func (bh beatHeap) Swap(i, j int) {
+	bh[i], bh[j] = bh[j], bh[i]
+	bh[i].index = i
+	bh[j].index = j
+}
+
+func (bh *beatHeap) Push(x interface{}) {
+	b := x.(*beat)
+	b.index = len(*bh)
+	*bh = append(*bh, b)
+}
+
+func (bh *beatHeap) Pop() interface{} {
+	old := *bh
+	b := old[len(old)-1]
+	old[len(old)-1] = nil
+	b.index = -1
+	*bh = old[:len(old)-1]
+	return b
+}
+
+func (hs composed) StillRunning(ctx *Context, elapsed time.Duration) {
+	for _, h := range hs {
+		if t, ok := h.(StillRunner); ok {
+			t.StillRunning(ctx, elapsed)
+		}
+	}
+}
+
+func (r *router) StillRunning(ctx *Context, elapsed time.Duration) {
+	if t, ok := r.hooks(ctx.Kind()).(StillRunner); ok {
+		t.StillRunning(ctx, elapsed)
+	}
+}
+
+func (s *sampler) StillRunning(ctx *Context, elapsed time.Duration) {
+	if t, ok := s.hooks.(StillRunner); ok {
+		t.StillRunning(ctx, elapsed)
+	}
+}
@@ -0,0 +1,119 @@
+package sqlhooks
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// beatHooks records the heartbeats of every statement, and the ones coming
+// once its After hook ran
+type beatHooks struct {
+	NopHooks
+
+	mu    sync.Mutex
+	beats map[string][]time.Duration
+	done  map[string]bool
+	late  []string
+}
+
+func (h *beatHooks) StillRunning(ctx *Context, elapsed time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done[ctx.Query] {
+		h.late = append(h.late, ctx.Query)
+	}
+	h.beats[ctx.Query] = append(h.beats[ctx.Query], elapsed)
+}
+
+func (h *beatHooks) after(ctx *Context) error {
+	h.mu.Lock()
+	h.done[ctx.Query] = true
+	h.mu.Unlock()
+	return ctx.Error
+}
+
+func (h *beatHooks) AfterQuery(ctx *Context) error     { return h.after(ctx) }
+func (h *beatHooks) AfterExec(ctx *Context) error      { return h.after(ctx) }
+func (h *beatHooks) AfterStmtQuery(ctx *Context) error { return h.after(ctx) }
+func (h *beatHooks) AfterStmtExec(ctx *Context) error  { return h.after(ctx) }
+
+func TestHeartbeat(t *testing.T) {
+	const beat = 50 * time.Millisecond
+	statements := []struct {
+		query string
+		beats int
+	}{
+		{"INSERT INTO t VALUES (1)", 0},
+		{"SLEEP 25ms|INSERT INTO t VALUES (2)", 0},
+		{"SLEEP 125ms|INSERT INTO t VALUES (3)", 2},
+		{"SLEEP 225ms|INSERT INTO t VALUES (4)", 4},
+		{"SLEEP 125ms|ROWS 1|SELECT n FROM t", 2},
+	}
+
+	for _, mode := range []string{drivertest.ModeContext, drivertest.ModeLegacy, drivertest.ModePrepare} {
+		t.Run(mode, func(t *testing.T) {
+			hooks := &beatHooks{beats: make(map[string][]time.Duration), done: make(map[string]bool)}
+			db, err := Open(drivertest.Name, mode+";"+t.Name(), hooks, WithHeartbeat(beat, beat))
+			require.NoError(t, err)
+			defer db.Close()
+
+			// Run concurrently, on a timer shared by every connection
+			var wg sync.WaitGroup
+			for _, s := range statements {
+				wg.Add(1)
+				go func(query string) {
+					defer wg.Done()
+					rows, err := db.Query(query)
+					if assert.NoError(t, err) {
+						assert.NoError(t, rows.Close())
+					}
+				}(s.query)
+			}
+			wg.Wait()
+			time.Sleep(2 * beat)
+
+			hooks.mu.Lock()
+			defer hooks.mu.Unlock()
+			for _, s := range statements {
+				beats := hooks.beats[s.query]
+				assert.Len(t, beats, s.beats, s.query)
+				for i, elapsed := range beats {
+					assert.True(t, elapsed >= beat+time.Duration(i)*beat, "%s: beat %d after %s", s.query, i, elapsed)
+				}
+			}
+			assert.Empty(t, hooks.late, "no heartbeat after the After hook")
+		})
+	}
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+	// Without WithHeartbeat, or without any StillRunner, nothing is scheduled
+	hooks := &beatHooks{beats: make(map[string][]time.Duration), done: make(map[string]bool)}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("SLEEP 30ms|INSERT INTO t VALUES (1)")
+	require.NoError(t, err)
+	assert.Empty(t, hooks.beats)
+
+	h := newHeartbeats(time.Millisecond, time.Millisecond)
+	assert.Nil(t, h.start(&FuncHooks{}, NewContext(), systemClock, systemClock()))
+	var none *heartbeats
+	assert.Nil(t, none.start(hooks, NewContext(), systemClock, systemClock()))
+	none.stop(nil)
+
+	db, err = sql.Open(RegisterUnique("heartbeat", NewDriver(drivertest.Name, Compose(&FuncHooks{}, hooks), WithHeartbeat(30*time.Millisecond, 0))), drivertest.ModeContext+";"+t.Name())
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("SLEEP 75ms|INSERT INTO t VALUES (2)")
+	require.NoError(t, err)
+	hooks.mu.Lock()
+	assert.Len(t, hooks.beats["SLEEP 75ms|INSERT INTO t VALUES (2)"], 2, "composed, with the interval defaulting to the delay")
+	hooks.mu.Unlock()
+}
@@ -0,0 +1,116 @@
+// Package abandoned provides a hook flagging the queries whose rows are
+// usually closed right after the first one while more were left, a strong
+// sign they're missing a LIMIT 1:
+//
+//	h := abandoned.New(func(fingerprint string, counts abandoned.Counts) {
+//		log.Printf("%d of %d queries read a single row: %s", counts.Abandoned, counts.Queries, fingerprint)
+//	})
+//	db, err := sqlhooks.Open("postgres", dsn, h, sqlhooks.WithAbandonedRowsCheck(true))
+//
+// Without sqlhooks.WithAbandonedRowsCheck every query closed after its first
+// row counts as abandoned, whether more rows were left or not.
+package abandoned
+
+import (
+	"sync"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// Counts counts the queries of a fingerprint, and how many of them were
+// abandoned after the first row
+type Counts struct {
+	Queries   uint64
+	Abandoned uint64
+}
+
+// Ratio returns the ratio of abandoned queries, 0 without any query
+func (c Counts) Ratio() float64 {
+	if c.Queries == 0 {
+		return 0
+	}
+	return float64(c.Abandoned) / float64(c.Queries)
+}
+
+type hook struct {
+	// Ratio is the ratio of the queries of a fingerprint abandoned after the
+	// first row from which it's flagged
+	Ratio float64
+
+	// MinQueries is the number of queries a fingerprint must have run to
+	// be flagged
+	MinQueries uint64
+
+	// Flag is called the first time the ratio of a fingerprint reaches Ratio
+	Flag func(fingerprint string, counts Counts)
+
+	mu      sync.Mutex
+	counts  map[string]*Counts
+	flagged map[string]bool
+}
+
+// New returns a hook calling flag for the fingerprints of which half the
+// queries, out of at least 10, were abandoned after the first row. Failed
+// queries aren't counted.
+func New(flag func(fingerprint string, counts Counts)) *hook {
+	return &hook{
+		Ratio:      0.5,
+		MinQueries: 10,
+		Flag:       flag,
+		counts:     make(map[string]*Counts),
+		flagged:    make(map[string]bool),
+	}
+}
+
+// Stats returns the counts of every fingerprint
+func (h *hook) Stats() map[string]Counts {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := make(map[string]Counts, len(h.counts))
+	for fingerprint, counts := range h.counts {
+		stats[fingerprint] = *counts
+	}
+	return stats
+}
+
+func (h *hook) AfterRowsClose(ctx *sqlhooks.Context) error {
+	if ctx.Error != nil {
+		return ctx.Error
+	}
+	fingerprint := sqlhooks.Fingerprint(ctx.Query)
+
+	h.mu.Lock()
+	counts, ok := h.counts[fingerprint]
+	if !ok {
+		counts = &Counts{}
+		h.counts[fingerprint] = counts
+	}
+	counts.Queries++
+	if ctx.Abandoned && ctx.RowsReturned == 1 {
+		counts.Abandoned++
+	}
+	flag := !h.flagged[fingerprint] && counts.Queries >= h.MinQueries && counts.Ratio() >= h.Ratio
+	if flag {
+		h.flagged[fingerprint] = true
+	}
+	snapshot := *counts
+	h.mu.Unlock()
+
+	if flag && h.Flag != nil {
+		h.Flag(fingerprint, snapshot)
+	}
+	return nil
+}
+
+// The query hooks are needed for AfterRowsClose to be called
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error   { return nil }
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error    { return ctx.Error }
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error  { return nil }
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error   { return ctx.Error }
@@ -0,0 +1,64 @@
+package abandoned
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// closeRows runs the rows close hook of h for a query closed after returned
+// rows
+func closeRows(h *hook, query string, returned int64, abandoned bool) error {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = query
+	ctx.RowsReturned = returned
+	ctx.Abandoned = abandoned
+	return h.AfterRowsClose(ctx)
+}
+
+func TestFlagsQueriesAbandonedAfterTheFirstRow(t *testing.T) {
+	var flagged []string
+	var counts []Counts
+	h := New(func(fingerprint string, c Counts) {
+		flagged = append(flagged, fingerprint)
+		counts = append(counts, c)
+	})
+	h.MinQueries = 4
+
+	first := "SELECT * FROM users WHERE name = ?"
+	for i := 0; i < 3; i++ {
+		require.NoError(t, closeRows(h, first, 1, true))
+	}
+	assert.Empty(t, flagged, "under MinQueries")
+	require.NoError(t, closeRows(h, first, 3, false))
+	assert.Equal(t, []string{first}, flagged)
+	assert.Equal(t, []Counts{{Queries: 4, Abandoned: 3}}, counts)
+
+	require.NoError(t, closeRows(h, first, 1, true))
+	assert.Len(t, flagged, 1, "flagged once")
+
+	// Read whole, closed after several rows or empty
+	for i := int64(0); i < 4; i++ {
+		require.NoError(t, closeRows(h, "SELECT * FROM t", 1, false))
+		require.NoError(t, closeRows(h, "SELECT * FROM t", 2, true))
+		require.NoError(t, closeRows(h, "SELECT * FROM t", 0, false))
+	}
+	assert.Len(t, flagged, 1)
+
+	assert.Equal(t, map[string]Counts{
+		first:             {Queries: 5, Abandoned: 4},
+		"SELECT * FROM t": {Queries: 12},
+	}, h.Stats())
+}
+
+func TestSkipsFailedQueries(t *testing.T) {
+	h := New(nil)
+	ctx := sqlhooks.NewContext()
+	ctx.Query = "SELECT 1"
+	ctx.Error = errors.New("boom")
+	assert.Equal(t, ctx.Error, h.AfterRowsClose(ctx))
+	assert.Empty(t, h.Stats())
+}
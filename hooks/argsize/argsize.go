@@ -0,0 +1,78 @@
+// Package argsize provides a hook that reports statements with large arguments
+package argsize
+
+import "github.com/gchaincl/sqlhooks"
+
+// Func receives a statement whose arguments exceed the threshold, with their size in bytes
+type Func func(query string, size int)
+
+type hook struct {
+	// Threshold is the arguments size, in bytes, a statement must exceed to be reported
+	Threshold int
+
+	fn Func
+}
+
+// New returns a hook that calls fn for every statement whose arguments,
+// as measured by sqlhooks.ArgsSize, are larger than threshold bytes.
+// The size is taken from the Context when the driver is opened with
+// sqlhooks.WithArgsSize, and computed otherwise.
+func New(threshold int, fn Func) *hook {
+	return &hook{Threshold: threshold, fn: fn}
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	size := ctx.ArgsSize
+	if size == 0 {
+		size = sqlhooks.ArgsSize(ctx.Args)
+	}
+
+	if size > h.Threshold {
+		h.fn(ctx.Query, size)
+	}
+	return nil
+}
+
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error {
+	return nil
+}
+
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
@@ -0,0 +1,41 @@
+package argsize
+
+import (
+	"testing"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportsArgsOverThreshold(t *testing.T) {
+	var reported []int
+	hook := New(6, func(query string, size int) {
+		assert.Equal(t, "INSERT", query)
+		reported = append(reported, size)
+	})
+
+	exec := func(args ...interface{}) {
+		ctx := sqlhooks.NewContext()
+		ctx.Query = "INSERT"
+		ctx.Args = args
+		assert.NoError(t, hook.BeforeExec(ctx))
+	}
+
+	exec("foo", []byte("bar"))
+	assert.Empty(t, reported, "args exactly at the threshold aren't reported")
+
+	exec("foo", []byte("barz"))
+	exec(int64(1), nil, true)
+	assert.Equal(t, []int{7, 9}, reported)
+}
+
+func TestUsesContextArgsSize(t *testing.T) {
+	var reported []int
+	hook := New(10, func(query string, size int) { reported = append(reported, size) })
+
+	ctx := sqlhooks.NewContext()
+	ctx.Args = []interface{}{"foo"}
+	ctx.ArgsSize = 11
+	assert.NoError(t, hook.BeforeStmtExec(ctx))
+	assert.Equal(t, []int{11}, reported)
+}
@@ -0,0 +1,172 @@
+// Package argtypes provides a hook taking a census of the Go types passed as
+// query arguments, by query fingerprint, as needed before moving to a driver
+// converting them differently:
+//
+//	h := argtypes.New(1000, 20)
+//	db, err := sqlhooks.Open("postgres", dsn, h, sqlhooks.WithArgTypes())
+//	...
+//	fmt.Print(h.Report())
+package argtypes
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// TypeCount is a Go type and the number of arguments of that type
+type TypeCount struct {
+	Type  string
+	Count uint64
+}
+
+// Entry are the argument types of the statements of a fingerprint
+type Entry struct {
+	Fingerprint string
+	Statements  uint64
+
+	// Types are sorted by decreasing count, then by name
+	Types []TypeCount
+
+	// DroppedTypes counts the arguments whose type was a new one once the
+	// entry had maxTypes of them
+	DroppedTypes uint64
+}
+
+// Report is the census of a hook
+type Report struct {
+	// Entries are sorted by fingerprint
+	Entries []Entry
+
+	// DroppedStatements counts the statements of a new fingerprint once
+	// the hook had maxFingerprints of them
+	DroppedStatements uint64
+}
+
+// String formats r as a table, a fingerprint per line followed by its types
+func (r Report) String() string {
+	var buf bytes.Buffer
+	for _, e := range r.Entries {
+		fmt.Fprintf(&buf, "%s (%d statements)\n", e.Fingerprint, e.Statements)
+		for _, t := range e.Types {
+			fmt.Fprintf(&buf, "\t%-24s %d\n", t.Type, t.Count)
+		}
+		if e.DroppedTypes > 0 {
+			fmt.Fprintf(&buf, "\t%-24s %d\n", "(other)", e.DroppedTypes)
+		}
+	}
+	if r.DroppedStatements > 0 {
+		fmt.Fprintf(&buf, "(other fingerprints) (%d statements)\n", r.DroppedStatements)
+	}
+	return buf.String()
+}
+
+type entry struct {
+	statements   uint64
+	types        map[string]uint64
+	droppedTypes uint64
+}
+
+type hook struct {
+	maxFingerprints, maxTypes int
+
+	mu                sync.Mutex
+	entries           map[string]*entry
+	droppedStatements uint64
+}
+
+// New returns a hook counting the argument types of the Query, Exec and Stmt
+// statements, for up to maxFingerprints fingerprints, as returned by
+// sqlhooks.Fingerprint, and maxTypes types for each of them, the others are
+// only counted.
+//
+// The types are the ones given to database/sql when the driver is opened
+// with sqlhooks.WithArgTypes, they're the types of the values it converted
+// them to otherwise, as time.Time for every driver.Valuer returning one.
+func New(maxFingerprints, maxTypes int) *hook {
+	return &hook{maxFingerprints: maxFingerprints, maxTypes: maxTypes, entries: make(map[string]*entry)}
+}
+
+// typeName names t as reflect does, nil for the nil arguments
+func typeName(t reflect.Type) string {
+	if t == nil {
+		return "nil"
+	}
+	return t.String()
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	types := make([]string, 0, len(ctx.Args))
+	if ctx.ArgTypes != nil {
+		for _, t := range ctx.ArgTypes {
+			types = append(types, typeName(t))
+		}
+	} else {
+		for _, arg := range ctx.Args {
+			types = append(types, typeName(reflect.TypeOf(arg)))
+		}
+	}
+	fingerprint := sqlhooks.Fingerprint(ctx.Query)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.entries[fingerprint]
+	if !ok {
+		if len(h.entries) >= h.maxFingerprints {
+			h.droppedStatements++
+			return nil
+		}
+		e = &entry{types: make(map[string]uint64)}
+		h.entries[fingerprint] = e
+	}
+	e.statements++
+	for _, t := range types {
+		if _, ok := e.types[t]; !ok && len(e.types) >= h.maxTypes {
+			e.droppedTypes++
+			continue
+		}
+		e.types[t]++
+	}
+	return nil
+}
+
+// Report returns the census so far
+func (h *hook) Report() Report {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r := Report{DroppedStatements: h.droppedStatements}
+	for fingerprint, e := range h.entries {
+		entry := Entry{Fingerprint: fingerprint, Statements: e.statements, DroppedTypes: e.droppedTypes}
+		for t, count := range e.types {
+			entry.Types = append(entry.Types, TypeCount{Type: t, Count: count})
+		}
+		sort.Slice(entry.Types, func(i, j int) bool {
+			a, b := entry.Types[i], entry.Types[j]
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return a.Type < b.Type
+		})
+		r.Entries = append(r.Entries, entry)
+	}
+	sort.Slice(r.Entries, func(i, j int) bool { return r.Entries[i].Fingerprint < r.Entries[j].Fingerprint })
+	return r
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error  { return ctx.Error }
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error   { return nil }
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error    { return ctx.Error }
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error  { return h.before(ctx) }
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error   { return ctx.Error }
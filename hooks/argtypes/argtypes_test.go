@@ -0,0 +1,69 @@
+package argtypes
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// money is a driver.Valuer, converted to an int64 by database/sql
+type money int
+
+func (m money) Value() (driver.Value, error) { return int64(m), nil }
+
+func TestReport(t *testing.T) {
+	for _, mode := range []string{drivertest.ModeContext, drivertest.ModePrepare} {
+		t.Run(mode, func(t *testing.T) {
+			h := New(10, 10)
+			db, err := sqlhooks.Open(drivertest.Name, mode+";"+t.Name(), h, sqlhooks.WithArgTypes())
+			require.NoError(t, err)
+			defer db.Close()
+
+			_, err = db.Exec("INSERT INTO t VALUES (?, ?, ?)", time.Now(), []byte("x"), money(1))
+			require.NoError(t, err)
+			_, err = db.Exec("INSERT INTO t VALUES (?, ?, ?)", nil, []byte("y"), money(2))
+			require.NoError(t, err)
+			rows, err := db.Query("SELECT * FROM t WHERE id = ?", 42)
+			require.NoError(t, err)
+			require.NoError(t, rows.Close())
+
+			assert.Equal(t, Report{Entries: []Entry{
+				{Fingerprint: "INSERT INTO t VALUES (?, ?, ?)", Statements: 2, Types: []TypeCount{
+					{"[]uint8", 2}, {"argtypes.money", 2}, {"nil", 1}, {"time.Time", 1},
+				}},
+				{Fingerprint: "SELECT * FROM t WHERE id = ?", Statements: 1, Types: []TypeCount{{"int", 1}}},
+			}}, h.Report())
+		})
+	}
+}
+
+func TestBounds(t *testing.T) {
+	h := New(1, 2)
+	run := func(query string, args ...interface{}) {
+		ctx := sqlhooks.NewContext()
+		ctx.Query = query
+		ctx.Args = args
+		require.NoError(t, h.BeforeExec(ctx))
+	}
+	run("UPDATE t SET a = ?, b = ?, c = ?", "a", int64(1), 1.5)
+	run("UPDATE t SET a = ?, b = ?, c = ?", "a", true, int64(2))
+	run("DELETE FROM t")
+
+	r := h.Report()
+	assert.Equal(t, Report{Entries: []Entry{{
+		Fingerprint:  "UPDATE t SET a = ?, b = ?, c = ?",
+		Statements:   2,
+		Types:        []TypeCount{{"int64", 2}, {"string", 2}},
+		DroppedTypes: 2,
+	}}, DroppedStatements: 1}, r)
+	assert.Equal(t, "UPDATE t SET a = ?, b = ?, c = ? (2 statements)\n"+
+		"\tint64                    2\n"+
+		"\tstring                   2\n"+
+		"\t(other)                  2\n"+
+		"(other fingerprints) (1 statements)\n", r.String())
+}
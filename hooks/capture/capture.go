@@ -0,0 +1,133 @@
+// Package capture provides a hook that records the result sets returned by
+// queries, for example to compare them against golden files
+package capture
+
+import (
+	"database/sql/driver"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// Result is a result set as read by the caller
+type Result struct {
+	Query   string
+	Args    []interface{}
+	Columns []string
+	Rows    [][]interface{}
+
+	// Truncated is set when rows were left out because of MaxRows or MaxBytes
+	Truncated bool
+}
+
+// Func receives the result set of a query once its rows are closed
+type Func func(Result)
+
+type hook struct {
+	// MaxRows is the maximum number of rows recorded by result set
+	MaxRows int
+
+	// MaxBytes is the approximate maximum size of the values recorded by result set
+	MaxBytes int
+
+	fn Func
+}
+
+// New returns a hook that calls fn with every result set read through the
+// driver, at most 1000 rows and 1MB by result set are recorded by default
+func New(fn Func) *hook {
+	return &hook{
+		MaxRows:  1000,
+		MaxBytes: 1 << 20,
+		fn:       fn,
+	}
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error   { return nil }
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error    { return ctx.Error }
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error  { return nil }
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error   { return ctx.Error }
+
+func (h *hook) WrapRows(ctx *sqlhooks.Context, rows driver.Rows) driver.Rows {
+	args := make([]interface{}, len(ctx.Args))
+	for i, arg := range ctx.Args {
+		args[i] = copyValue(arg)
+	}
+
+	return &capturingRows{
+		Rows: rows,
+		hook: h,
+		result: Result{
+			Query:   ctx.Query,
+			Args:    args,
+			Columns: rows.Columns(),
+			Rows:    [][]interface{}{},
+		},
+	}
+}
+
+// copyValue copies the values drivers may reuse between rows
+func copyValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return append([]byte(nil), b...)
+	}
+	return v
+}
+
+// size approximates the memory used by v
+func size(v interface{}) int {
+	switch v := v.(type) {
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	case time.Time:
+		return 24
+	case nil:
+		return 0
+	}
+	return 8
+}
+
+type capturingRows struct {
+	driver.Rows
+	hook   *hook
+	result Result
+	bytes  int
+}
+
+func (r *capturingRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err != nil || r.result.Truncated {
+		return err
+	}
+
+	row := make([]interface{}, len(dest))
+	n := 0
+	for i, v := range dest {
+		row[i] = copyValue(v)
+		n += size(v)
+	}
+
+	if len(r.result.Rows) >= r.hook.MaxRows || r.bytes+n > r.hook.MaxBytes {
+		r.result.Truncated = true
+		return nil
+	}
+
+	r.result.Rows = append(r.result.Rows, row)
+	r.bytes += n
+	return nil
+}
+
+func (r *capturingRows) Close() error {
+	err := r.Rows.Close()
+	if r.hook.fn != nil {
+		r.hook.fn(r.result)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package capture
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRows returns its rows reusing the same []byte buffer, as drivers may do
+type fakeRows struct {
+	rows [][]driver.Value
+	buf  []byte
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id", "name", "created", "note"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+
+	for i, v := range row {
+		if b, ok := v.([]byte); ok {
+			r.buf = append(r.buf[:0], b...)
+			v = r.buf
+		}
+		dest[i] = v
+	}
+	return nil
+}
+
+func readAll(t *testing.T, rows driver.Rows) {
+	dest := make([]driver.Value, len(rows.Columns()))
+	for rows.Next(dest) == nil {
+	}
+	require.NoError(t, rows.Close())
+}
+
+func newContext() *sqlhooks.Context {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = "SELECT id, name, created, note FROM t WHERE id > ?"
+	ctx.Args = []interface{}{int64(0)}
+	return ctx
+}
+
+func TestCaptureRecordsResultSet(t *testing.T) {
+	created := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	var results []Result
+	hook := New(func(r Result) { results = append(results, r) })
+
+	rows := hook.WrapRows(newContext(), &fakeRows{rows: [][]driver.Value{
+		{int64(1), []byte("foo"), created, nil},
+		{int64(2), []byte("bar"), created, 1.5},
+	}})
+	readAll(t, rows)
+
+	require.Len(t, results, 1)
+	assert.Equal(t, Result{
+		Query:   "SELECT id, name, created, note FROM t WHERE id > ?",
+		Args:    []interface{}{int64(0)},
+		Columns: []string{"id", "name", "created", "note"},
+		Rows: [][]interface{}{
+			{int64(1), []byte("foo"), created, nil},
+			{int64(2), []byte("bar"), created, 1.5},
+		},
+	}, results[0])
+}
+
+func TestCaptureTruncates(t *testing.T) {
+	values := [][]driver.Value{
+		{int64(1), []byte("foo"), nil, nil},
+		{int64(2), []byte("bar"), nil, nil},
+		{int64(3), []byte("baz"), nil, nil},
+	}
+
+	var result Result
+	hook := New(func(r Result) { result = r })
+	hook.MaxRows = 2
+	readAll(t, hook.WrapRows(newContext(), &fakeRows{rows: values}))
+	assert.True(t, result.Truncated)
+	assert.Len(t, result.Rows, 2)
+
+	hook = New(func(r Result) { result = r })
+	hook.MaxBytes = 15
+	readAll(t, hook.WrapRows(newContext(), &fakeRows{rows: values}))
+	assert.True(t, result.Truncated)
+	assert.Len(t, result.Rows, 1)
+
+	hook = New(func(r Result) { result = r })
+	readAll(t, hook.WrapRows(newContext(), &fakeRows{rows: values}))
+	assert.False(t, result.Truncated)
+	assert.Len(t, result.Rows, 3)
+}
+
+// setsRows are fakeRows with a result set per element of sets
+type setsRows struct {
+	fakeRows
+	sets [][][]driver.Value
+}
+
+func (r *setsRows) HasNextResultSet() bool { return len(r.sets) > 0 }
+
+func (r *setsRows) NextResultSet() error {
+	if len(r.sets) == 0 {
+		return io.EOF
+	}
+	r.rows, r.sets = r.sets[0], r.sets[1:]
+	return nil
+}
+
+type setsDriver struct{}
+
+func (setsDriver) Open(name string) (driver.Conn, error) { return setsConn{}, nil }
+
+// setsConn queries return two result sets of a row each
+type setsConn struct{}
+
+func (setsConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (setsConn) Close() error                              { return nil }
+func (setsConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+func (setsConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return &setsRows{
+		fakeRows: fakeRows{rows: [][]driver.Value{{int64(1), []byte("foo"), nil, nil}}},
+		sets:     [][][]driver.Value{{{int64(2), []byte("bar"), nil, nil}}},
+	}, nil
+}
+
+func init() {
+	sql.Register("capture-sets", setsDriver{})
+}
+
+func TestCaptureMultipleResultSets(t *testing.T) {
+	var results []Result
+	db, err := sqlhooks.Open("capture-sets", "", New(func(r Result) { results = append(results, r) }))
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query("CALL two_sets()")
+	require.NoError(t, err)
+	var ids []int64
+	for {
+		for rows.Next() {
+			var id int64
+			var name, created, note interface{}
+			require.NoError(t, rows.Scan(&id, &name, &created, &note))
+			ids = append(ids, id)
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	require.NoError(t, rows.Close())
+
+	assert.Equal(t, []int64{1, 2}, ids, "the second result set isn't hidden")
+	require.Len(t, results, 1)
+	assert.Len(t, results[0].Rows, 2)
+}
@@ -0,0 +1,233 @@
+// Package chanhooks provides hooks sending an event per completed statement
+// and transaction to a channel, for consumers doing their own fan-out:
+//
+//	hooks, events := chanhooks.New(100, chanhooks.DropOldest)
+//	db, err := sqlhooks.Open("postgres", dsn, hooks)
+//	...
+//	go func() {
+//		for ev := range events {
+//			log.Printf("%s %s took %dns", ev.Op, ev.Table, ev.DurationNS)
+//		}
+//	}()
+//	...
+//	db.Close()
+//	hooks.Close()
+package chanhooks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/internal/queue"
+)
+
+// queueSize is the number of events waiting to be sent to the channel
+// before new ones are dropped, whatever the OverflowPolicy
+const queueSize = 1024
+
+// Event is the record of a statement or a transaction, as
+// sqlhooks.MarshalEvent returns it
+type Event = sqlhooks.EventRecordV1
+
+// OverflowPolicy tells what's done with an event when the channel is full
+type OverflowPolicy struct {
+	oldest  bool
+	timeout time.Duration
+}
+
+var (
+	// DropNewest drops the event
+	DropNewest = OverflowPolicy{}
+
+	// DropOldest drops the oldest event of the channel to make room for it
+	DropOldest = OverflowPolicy{oldest: true}
+)
+
+// BlockFor waits up to timeout for room in the channel, the event is
+// dropped afterwards
+func BlockFor(timeout time.Duration) OverflowPolicy {
+	return OverflowPolicy{timeout: timeout}
+}
+
+type hook struct {
+	// dropped comes first, it's 64-bit aligned for atomic operations then
+	dropped uint64
+	events  chan Event
+	policy  OverflowPolicy
+	queue   *queue.Queue
+
+	// mu guards closing events against the sends in progress
+	mu     sync.Mutex
+	closed bool
+}
+
+// New returns hooks sending the events of the statements and transactions
+// to the returned channel, of buffer events. The events are handed to the
+// channel from a background goroutine so statements never wait for the
+// consumer, policy tells what's done when it's full, see Dropped.
+//
+// Statements are sent once done: queries once their rows are closed, or
+// when they fail, transactions once committed or rolled back, as
+// sqlhooks.TxSummary records.
+func New(buffer int, policy OverflowPolicy) (*hook, <-chan Event) {
+	h := &hook{events: make(chan Event, buffer), policy: policy}
+	h.queue = queue.New("chanhooks", 1, queueSize)
+	return h, h.events
+}
+
+// Close waits for the queued events to be sent, then closes the channel:
+// the consumer gets the events left in it before it's seen closed. No
+// events are sent afterwards.
+func (h *hook) Close() {
+	h.Shutdown(context.Background())
+}
+
+// Shutdown is Close giving up on the events still queued once ctx is done,
+// Driver.Shutdown calls it
+func (h *hook) Shutdown(ctx context.Context) error {
+	err := h.queue.Shutdown(ctx)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		h.closed = true
+		close(h.events)
+	}
+	return err
+}
+
+// Dropped returns the number of events dropped because the channel was
+// full, as told by the OverflowPolicy, or the consumer couldn't keep up
+// with the queue, or sent after Close
+func (h *hook) Dropped() uint64 {
+	return h.queue.Dropped() + atomic.LoadUint64(&h.dropped)
+}
+
+// send sends ev to the channel as told by the policy
+func (h *hook) send(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		h.drop("the channel is closed")
+		return
+	}
+
+	select {
+	case h.events <- ev:
+		return
+	default:
+	}
+
+	switch {
+	case h.policy.oldest:
+		// The consumer may empty the channel in the meantime
+		select {
+		case <-h.events:
+			h.drop("the oldest one, the channel is full")
+		default:
+		}
+		select {
+		case h.events <- ev:
+			return
+		default:
+		}
+	case h.policy.timeout > 0:
+		timer := time.NewTimer(h.policy.timeout)
+		defer timer.Stop()
+		select {
+		case h.events <- ev:
+			return
+		case <-timer.C:
+		}
+	}
+	h.drop("the channel is full")
+}
+
+// drop counts an event dropped for reason
+func (h *hook) drop(reason string) {
+	atomic.AddUint64(&h.dropped, 1)
+	h.queue.Report(sqlhooks.EventDropped, errors.New("chanhooks: event dropped, "+reason))
+}
+
+// SetInternalReporter reports the events dropped and the panics of the
+// consumer-facing goroutine to the Driver
+func (h *hook) SetInternalReporter(report func(event string, err error)) {
+	h.queue.SetReporter(report)
+}
+
+func (h *hook) push(ev interface{}) {
+	record, err := sqlhooks.MarshalEvent(ev)
+	if err == nil {
+		h.queue.Push(0, func() { h.send(record) })
+	}
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	return nil
+}
+
+// afterQuery sends failed queries, the others are sent once their rows are closed
+func (h *hook) afterQuery(ctx *sqlhooks.Context) error {
+	if ctx.Error != nil {
+		h.push(ctx)
+	}
+	return ctx.Error
+}
+
+func (h *hook) afterExec(ctx *sqlhooks.Context) error {
+	h.push(ctx)
+	return ctx.Error
+}
+
+func (h *hook) AfterRowsClose(ctx *sqlhooks.Context) error {
+	h.push(ctx)
+	return ctx.Error
+}
+
+func (h *hook) AfterTx(summary sqlhooks.TxSummary) {
+	h.push(summary)
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error {
+	return h.afterQuery(ctx)
+}
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error {
+	return h.afterExec(ctx)
+}
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
+
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error {
+	return h.afterQuery(ctx)
+}
+
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error {
+	return h.afterExec(ctx)
+}
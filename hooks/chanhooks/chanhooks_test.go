@@ -0,0 +1,118 @@
+package chanhooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendsEvents(t *testing.T) {
+	hooks, events := New(10, DropNewest)
+	db, err := sqlhooks.Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks)
+	require.NoError(t, err)
+
+	_, err = db.Exec("INSERT INTO t VALUES (1)")
+	require.NoError(t, err)
+	rows, err := db.Query("SELECT n FROM t")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	_, err = db.Exec("FAIL deadlock|DELETE FROM t")
+	require.Error(t, err)
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("UPDATE t SET n = 2")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.Close())
+	hooks.Close()
+
+	var received []Event
+	for ev := range events {
+		received = append(received, ev)
+	}
+	require.Len(t, received, 5)
+	assert.Equal(t, "insert", received[0].Op)
+	assert.Equal(t, "select", received[1].Op)
+	assert.Equal(t, "drivertest: deadlock", received[2].Error)
+	assert.Equal(t, "update", received[3].Op)
+	assert.NotZero(t, received[3].TxID)
+	assert.Equal(t, "tx", received[4].Op)
+	assert.Equal(t, received[3].TxID, received[4].TxID)
+	assert.Equal(t, "committed", received[4].Outcome)
+	assert.Zero(t, hooks.Dropped())
+}
+
+// fill sends events numbered 1 to n to h, as the queue would
+func fill(h *hook, n int) {
+	for i := 1; i <= n; i++ {
+		h.send(Event{Seq: uint64(i)})
+	}
+}
+
+func received(events <-chan Event) []uint64 {
+	var seqs []uint64
+	for ev := range events {
+		seqs = append(seqs, ev.Seq)
+	}
+	return seqs
+}
+
+func TestDropNewest(t *testing.T) {
+	h, events := New(2, DropNewest)
+	d := sqlhooks.NewDriver("", h)
+	fill(h, 4)
+	h.Close()
+
+	assert.Equal(t, []uint64{1, 2}, received(events))
+	assert.Equal(t, uint64(2), h.Dropped())
+	assert.Equal(t, uint64(2), d.Stats().EventsDropped, "the drops are reported to the driver")
+}
+
+func TestDropOldest(t *testing.T) {
+	h, events := New(2, DropOldest)
+	fill(h, 4)
+	h.Close()
+
+	assert.Equal(t, []uint64{3, 4}, received(events))
+	assert.Equal(t, uint64(2), h.Dropped())
+}
+
+func TestBlockFor(t *testing.T) {
+	h, events := New(1, BlockFor(10*time.Millisecond))
+	start := time.Now()
+	fill(h, 2)
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+	assert.Equal(t, uint64(1), h.Dropped())
+
+	// The consumer makes room before the timeout
+	h, events = New(1, BlockFor(time.Minute))
+	done := make(chan []uint64)
+	go func() { done <- received(events) }()
+	fill(h, 3)
+	h.Close()
+	assert.Equal(t, []uint64{1, 2, 3}, <-done)
+	assert.Zero(t, h.Dropped())
+}
+
+func TestClose(t *testing.T) {
+	h, events := New(2, DropNewest)
+	fill(h, 1)
+	h.Close()
+	h.Close()
+
+	// Events left in the channel are received before it's seen closed
+	ev, ok := <-events
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), ev.Seq)
+	_, ok = <-events
+	assert.False(t, ok)
+
+	fill(h, 1)
+	assert.NoError(t, h.AfterExec(sqlhooks.NewContext()))
+	assert.Equal(t, uint64(2), h.Dropped())
+}
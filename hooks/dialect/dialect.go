@@ -0,0 +1,112 @@
+// Package dialect provides a hook guarding against statements whose
+// placeholders don't match the style of the database they're run against, as
+// ? sent to postgres or $1 sent to MySQL, which fail with syntax errors or,
+// worse, run with their arguments bound to the wrong placeholders.
+//
+// The style is found by sqlhooks.QueryPlaceholderStyle, scanning the query
+// rather than parsing it: literals, quoted identifiers and comments are
+// skipped, and against postgres a ?, ?| or ?& following an operand is taken
+// for the jsonb operator it is. Statements mixing styles are flagged whatever
+// the database. Statements the check gets wrong can be exempted with
+// sqlhooks.SkipPlaceholderCheck:
+//
+//	ctx := sqlhooks.SkipPlaceholderCheck(ctx)
+//	rows, err := db.QueryContext(ctx, query, args...)
+package dialect
+
+import (
+	"fmt"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// Error is the error of a statement failing the check
+type Error struct {
+	Query  string
+	System string
+	Style  sqlhooks.PlaceholderStyle
+
+	// Expected is the style of System, PlaceholderNone when Style is
+	// PlaceholderMixed and System has no expected style
+	Expected sqlhooks.PlaceholderStyle
+}
+
+func (e *Error) Error() string {
+	if e.Style == sqlhooks.PlaceholderMixed {
+		return fmt.Sprintf("dialect: mixed placeholder styles: %s", e.Query)
+	}
+	return fmt.Sprintf("dialect: %s placeholders sent to %s, expected %s: %s", e.Style, e.System, e.Expected, e.Query)
+}
+
+// DefaultExpected is the placeholder style of the systems checked by default,
+// by their SystemInfo name. SQLite is left out, taking both ? and $1.
+var DefaultExpected = map[string]sqlhooks.PlaceholderStyle{
+	"postgresql": sqlhooks.PlaceholderDollar,
+	"mysql":      sqlhooks.PlaceholderQuestion,
+	"mssql":      sqlhooks.PlaceholderAtP,
+}
+
+type hook struct {
+	// Expected is the placeholder style of the systems checked, by their
+	// SystemInfo name, DefaultExpected unless set. The statements run against
+	// other systems are only checked for mixed styles.
+	Expected map[string]sqlhooks.PlaceholderStyle
+
+	// Warn, when set, is given the errors of the statements failing the
+	// check, which run anyway, rather than rejecting them
+	Warn func(ctx *sqlhooks.Context, err *Error)
+}
+
+// New returns a hook checking the placeholder style of the statements against
+// their Context.System, as set by sqlhooks.WithSystem when the driver name
+// doesn't tell it
+func New() *hook {
+	return &hook{Expected: DefaultExpected}
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	if sqlhooks.PlaceholderCheckSkipped(ctx.Ctx) {
+		return nil
+	}
+	err := h.check(ctx)
+	if err == nil {
+		return nil
+	}
+	if h.Warn != nil {
+		h.Warn(ctx, err)
+		return nil
+	}
+	return err
+}
+
+// check returns the Error of ctx's statement, nil if it passes
+func (h *hook) check(ctx *sqlhooks.Context) *Error {
+	system := ctx.System.Name
+	style := sqlhooks.QueryPlaceholderStyle(ctx.Query, system)
+	if style == sqlhooks.PlaceholderNone {
+		return nil
+	}
+
+	expected, checked := h.Expected[system]
+	if h.Expected == nil {
+		expected, checked = DefaultExpected[system]
+	}
+	if style != sqlhooks.PlaceholderMixed && (!checked || style == expected) {
+		return nil
+	}
+	return &Error{Query: ctx.Query, System: system, Style: style, Expected: expected}
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error  { return ctx.Error }
+
+// Prepared statements are checked when prepared, with the context given to Prepare
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error   { return h.before(ctx) }
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error    { return ctx.Error }
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error  { return nil }
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error   { return ctx.Error }
@@ -0,0 +1,117 @@
+package dialect
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newContext(ctx context.Context, system, query string) *sqlhooks.Context {
+	c := sqlhooks.NewContext()
+	c.Ctx = ctx
+	c.System = sqlhooks.SystemInfo{Name: system}
+	c.Query = query
+	return c
+}
+
+func TestCheck(t *testing.T) {
+	hook := New()
+
+	for _, c := range []struct {
+		system string
+		query  string
+		style  sqlhooks.PlaceholderStyle // PlaceholderNone when the query passes
+	}{
+		{"postgresql", "SELECT * FROM users WHERE id = $1", 0},
+		{"postgresql", "SELECT * FROM users WHERE id = ?", sqlhooks.PlaceholderQuestion},
+		{"postgresql", "SELECT * FROM users WHERE id = $1 AND name = ?", sqlhooks.PlaceholderMixed},
+		{"postgresql", "SELECT * FROM users WHERE id = @p1", sqlhooks.PlaceholderAtP},
+		{"postgresql", "SELECT * FROM docs WHERE data ? 'key'", 0},
+		{"postgresql", "SELECT * FROM docs WHERE data ? $1", 0},
+		{"postgresql", "SELECT * FROM docs WHERE data->'tags' ?| array['a', 'b'] AND id = $1", 0},
+		{"postgresql", "SELECT * FROM docs WHERE (data->'tags') ?& $1", 0},
+		{"postgresql", `SELECT * FROM docs WHERE "Data" ? 'key' AND id = $1`, 0},
+		{"postgresql", "SELECT * FROM docs WHERE data::jsonb ? 'key' LIMIT ?", sqlhooks.PlaceholderQuestion},
+		{"postgresql", "SELECT * FROM docs WHERE id IN (?, ?)", sqlhooks.PlaceholderQuestion},
+		{"postgresql", "SELECT * FROM users WHERE name = 'who?' -- or id = ?", 0},
+		{"postgresql", "SELECT 1", 0},
+		{"mysql", "SELECT * FROM users WHERE id = ?", 0},
+		{"mysql", "SELECT * FROM users WHERE id = $1", sqlhooks.PlaceholderDollar},
+		{"mysql", "SELECT * FROM docs WHERE data ? 'key'", 0},
+		{"mysql", "SELECT * FROM users WHERE name = '$1' AND `?` = ?", 0},
+		{"mysql", "SELECT * FROM users WHERE id = ? AND name = $2", sqlhooks.PlaceholderMixed},
+		{"mssql", "SELECT * FROM users WHERE id = @p1", 0},
+		{"mssql", "SELECT * FROM users WHERE id = ?", sqlhooks.PlaceholderQuestion},
+		{"sqlite", "SELECT * FROM users WHERE id = $1", 0},
+		{"sqlite", "SELECT * FROM users WHERE id = ?", 0},
+		{"sqlite", "SELECT * FROM users WHERE id = ? AND n = $2", sqlhooks.PlaceholderMixed},
+		{sqlhooks.SystemOther, "SELECT * FROM users WHERE id = @p1", 0},
+	} {
+		err := hook.BeforeQuery(newContext(context.Background(), c.system, c.query))
+		if c.style == sqlhooks.PlaceholderNone {
+			assert.NoError(t, err, "%s: %s", c.system, c.query)
+			continue
+		}
+		require.Error(t, err, "%s: %s", c.system, c.query)
+		dialectErr := err.(*Error)
+		assert.Equal(t, c.style, dialectErr.Style, "%s: %s", c.system, c.query)
+		assert.Equal(t, c.system, dialectErr.System, "%s: %s", c.system, c.query)
+	}
+
+	err := hook.BeforeExec(newContext(context.Background(), "postgresql", "DELETE FROM users WHERE id = ?"))
+	assert.EqualError(t, err, "dialect: ? placeholders sent to postgresql, expected $1: DELETE FROM users WHERE id = ?")
+	err = hook.BeforePrepare(newContext(context.Background(), "mysql", "DELETE FROM users WHERE id = ? OR id = $1"))
+	assert.EqualError(t, err, "dialect: mixed placeholder styles: DELETE FROM users WHERE id = ? OR id = $1")
+}
+
+func TestExpected(t *testing.T) {
+	hook := New()
+	hook.Expected = map[string]sqlhooks.PlaceholderStyle{"sqlite": sqlhooks.PlaceholderQuestion}
+
+	assert.Error(t, hook.BeforeQuery(newContext(context.Background(), "sqlite", "SELECT * FROM users WHERE id = $1")))
+	assert.NoError(t, hook.BeforeQuery(newContext(context.Background(), "postgresql", "SELECT * FROM users WHERE id = ?")))
+}
+
+func TestWarn(t *testing.T) {
+	var warned []string
+	hook := New()
+	hook.Warn = func(ctx *sqlhooks.Context, err *Error) {
+		warned = append(warned, err.Style.String())
+	}
+
+	assert.NoError(t, hook.BeforeQuery(newContext(context.Background(), "postgresql", "SELECT * FROM users WHERE id = ?")))
+	assert.NoError(t, hook.BeforeQuery(newContext(context.Background(), "postgresql", "SELECT * FROM users WHERE id = $1")))
+	assert.Equal(t, []string{"?"}, warned)
+}
+
+func TestSkipPlaceholderCheck(t *testing.T) {
+	hook := New()
+	ctx := sqlhooks.SkipPlaceholderCheck(context.Background())
+	assert.NoError(t, hook.BeforeQuery(newContext(ctx, "postgresql", "SELECT * FROM users WHERE id = ?")))
+	assert.NoError(t, hook.BeforePrepare(newContext(ctx, "mysql", "SELECT * FROM users WHERE id = $1")))
+}
+
+func TestChecksThroughDriver(t *testing.T) {
+	driver := sqlhooks.NewDriver(drivertest.Name, New(), sqlhooks.WithSystem(sqlhooks.SystemInfo{Name: "postgresql"}))
+	name := sqlhooks.RegisterUnique("dialect", driver)
+	db, err := sql.Open(name, "context;TestChecksThroughDriver")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM users WHERE id = ?", 1)
+	assert.IsType(t, &Error{}, err)
+
+	_, err = db.Exec("DELETE FROM users WHERE id = $1", 1)
+	assert.NoError(t, err)
+
+	_, err = db.Prepare("SELECT * FROM users WHERE id = ?")
+	assert.IsType(t, &Error{}, err)
+
+	_, err = db.ExecContext(sqlhooks.SkipPlaceholderCheck(context.Background()), "DELETE FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,118 @@
+// Package explain provides a hook that retrieves the execution plan of slow queries
+package explain
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// maxTracked bounds the number of queries remembered by the rate limiter
+const maxTracked = 1024
+
+// Func receives the plan of a slow statement
+type Func func(query string, args []interface{}, plan string, err error)
+
+type hook struct {
+	// Threshold is the minimum duration for a statement to be explained
+	Threshold time.Duration
+
+	// Interval is the minimum time between two explains of the same query
+	Interval time.Duration
+
+	// Analyze uses EXPLAIN ANALYZE, which executes the statement once more
+	Analyze bool
+
+	// AllowNonSelect explains statements other than SELECT,
+	// combined with Analyze this will execute writes twice
+	AllowNonSelect bool
+
+	// dialect is the sqlhooks.System name of the dialect
+	dialect string
+	fn      Func
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// New returns a hook that calls fn with the plan of statements taking longer
+// than threshold. dialect is the name of a MySQL, Postgres or SQLite driver,
+// as mapped by sqlhooks.System
+func New(dialect string, threshold time.Duration, fn Func) *hook {
+	return &hook{
+		Threshold: threshold,
+		Interval:  time.Minute,
+		dialect:   sqlhooks.System(dialect).Name,
+		fn:        fn,
+		last:      make(map[string]time.Time),
+	}
+}
+
+func (h *hook) prefix() string {
+	switch h.dialect {
+	case "mysql", "postgresql":
+		if h.Analyze {
+			return "EXPLAIN ANALYZE "
+		}
+		return "EXPLAIN "
+	case "sqlite":
+		return "EXPLAIN QUERY PLAN "
+	}
+	return ""
+}
+
+func isSelect(query string) bool {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	return len(query) >= 6 && strings.EqualFold(query[:6], "SELECT")
+}
+
+// allow reports whether query wasn't explained during the last Interval
+func (h *hook) allow(query string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if t, ok := h.last[query]; ok && now.Sub(t) < h.Interval {
+		return false
+	}
+
+	if len(h.last) >= maxTracked {
+		for q, t := range h.last {
+			if now.Sub(t) >= h.Interval {
+				delete(h.last, q)
+			}
+		}
+		if len(h.last) >= maxTracked {
+			return false
+		}
+	}
+
+	h.last[query] = now
+	return true
+}
+
+func (h *hook) ExplainQuery(ctx *sqlhooks.Context) (string, bool) {
+	if ctx.Error != nil || ctx.Duration < h.Threshold {
+		return "", false
+	}
+
+	prefix := h.prefix()
+	if prefix == "" {
+		return "", false
+	}
+
+	if !h.AllowNonSelect && !isSelect(ctx.Query) {
+		return "", false
+	}
+
+	if !h.allow(ctx.Query, time.Now()) {
+		return "", false
+	}
+
+	return prefix + ctx.Query, true
+}
+
+func (h *hook) AfterExplain(ctx *sqlhooks.Context, plan string, err error) {
+	h.fn(ctx.Query, ctx.Args, plan, err)
+}
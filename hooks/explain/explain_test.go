@@ -0,0 +1,99 @@
+package explain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSlowContext(query string) *sqlhooks.Context {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = query
+	ctx.Duration = time.Second
+	return ctx
+}
+
+func TestExplainDialects(t *testing.T) {
+	for dialect, expected := range map[string]string{
+		"mysql":    "EXPLAIN SELECT 1",
+		"postgres": "EXPLAIN SELECT 1",
+		"pgx":      "EXPLAIN SELECT 1",
+		"sqlite3":  "EXPLAIN QUERY PLAN SELECT 1",
+	} {
+		hook := New(dialect, time.Millisecond, nil)
+		query, ok := hook.ExplainQuery(newSlowContext("SELECT 1"))
+		assert.True(t, ok, dialect)
+		assert.Equal(t, expected, query, dialect)
+	}
+
+	_, ok := New("unknown", time.Millisecond, nil).ExplainQuery(newSlowContext("SELECT 1"))
+	assert.False(t, ok)
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	hook := New("postgres", time.Millisecond, nil)
+	hook.Analyze = true
+
+	query, ok := hook.ExplainQuery(newSlowContext("SELECT 1"))
+	assert.True(t, ok)
+	assert.Equal(t, "EXPLAIN ANALYZE SELECT 1", query)
+}
+
+func TestExplainSkipsFastAndFailedStatements(t *testing.T) {
+	hook := New("mysql", time.Minute, nil)
+	_, ok := hook.ExplainQuery(newSlowContext("SELECT 1"))
+	assert.False(t, ok)
+
+	hook = New("mysql", time.Millisecond, nil)
+	ctx := newSlowContext("SELECT 1")
+	ctx.Error = errors.New("boom")
+	_, ok = hook.ExplainQuery(ctx)
+	assert.False(t, ok)
+}
+
+func TestExplainOnlySelectsUnlessAllowed(t *testing.T) {
+	hook := New("mysql", time.Millisecond, nil)
+	for _, query := range []string{"select 1", " (SELECT 1) UNION (SELECT 2)", "\n\tSeLeCt 1"} {
+		_, ok := hook.ExplainQuery(newSlowContext(query))
+		assert.True(t, ok, query)
+	}
+
+	for _, query := range []string{"UPDATE t SET a = 1", "DELETE FROM t", "sel"} {
+		_, ok := hook.ExplainQuery(newSlowContext(query))
+		assert.False(t, ok, query)
+	}
+
+	hook.AllowNonSelect = true
+	_, ok := hook.ExplainQuery(newSlowContext("DELETE FROM t"))
+	assert.True(t, ok)
+}
+
+func TestExplainRateLimit(t *testing.T) {
+	hook := New("mysql", time.Millisecond, nil)
+
+	_, ok := hook.ExplainQuery(newSlowContext("SELECT 1"))
+	assert.True(t, ok)
+
+	_, ok = hook.ExplainQuery(newSlowContext("SELECT 1"))
+	assert.False(t, ok, "same query should not be explained twice within Interval")
+
+	_, ok = hook.ExplainQuery(newSlowContext("SELECT 2"))
+	assert.True(t, ok)
+
+	hook.Interval = 0
+	_, ok = hook.ExplainQuery(newSlowContext("SELECT 1"))
+	assert.True(t, ok)
+}
+
+func TestAfterExplainCallsFunc(t *testing.T) {
+	var got string
+	hook := New("mysql", time.Millisecond, func(query string, args []interface{}, plan string, err error) {
+		got = plan
+	})
+
+	hook.AfterExplain(newSlowContext("SELECT 1"), "the plan", nil)
+	assert.Equal(t, "the plan", got)
+}
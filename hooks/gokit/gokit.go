@@ -0,0 +1,119 @@
+// Package gokit provides a hook logging statements to a go-kit logger
+package gokit
+
+import (
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/hooks/internal/format"
+)
+
+// Logger is the go-kit log.Logger interface
+type Logger interface {
+	Log(keyvals ...interface{}) error
+}
+
+type hook struct {
+	Logger Logger
+
+	// Slow is the duration from which statements are logged at warn level
+	// instead of debug, 0 disables it
+	Slow time.Duration
+
+	// RedactArgs logs ? in place of every argument
+	RedactArgs bool
+
+	// MaxQueryLen is the length, in bytes, queries are truncated to, 0 logs
+	// them whole
+	MaxQueryLen int
+
+	// Migrations sets whether migration statements are logged, and
+	// whether they're logged with migration=true
+	Migrations sqlhooks.Migrations
+}
+
+// New returns a hook logging completed statements to logger as
+// msg=query sql=... args=... took=... err=..., failed statements are logged
+// at error level, slow ones at warn level and the rest at debug level
+func New(logger Logger) *hook {
+	return &hook{Logger: logger}
+}
+
+func (h *hook) log(ctx *sqlhooks.Context) {
+	if h.Migrations.Excludes(ctx) {
+		return
+	}
+
+	keyvals := []interface{}{
+		"msg", "query",
+		"sql", format.Query(ctx.Query, h.MaxQueryLen),
+		"args", format.Args(ctx.Args, h.RedactArgs),
+		"took", ctx.Duration,
+	}
+	if h.Migrations.Labels(ctx) {
+		keyvals = append(keyvals, "migration", true)
+	}
+
+	logger := debug(h.Logger)
+	switch {
+	case ctx.Error != nil:
+		logger = errorLevel(h.Logger)
+		keyvals = append(keyvals, "err", ctx.Error)
+	case h.Slow > 0 && ctx.Duration >= h.Slow:
+		logger = warn(h.Logger)
+	}
+	logger.Log(keyvals...)
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	return nil
+}
+
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	h.log(ctx)
+	return ctx.Error
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+// AfterPrepare only logs failures, successful statements are logged once executed
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error {
+	if ctx.Error != nil {
+		h.log(ctx)
+	}
+	return ctx.Error
+}
+
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
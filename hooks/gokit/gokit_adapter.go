@@ -0,0 +1,17 @@
+package gokit
+
+import "github.com/go-kit/log/level"
+
+// The level routing is the only use of go-kit in the package
+
+func debug(logger Logger) Logger {
+	return level.Debug(logger)
+}
+
+func warn(logger Logger) Logger {
+	return level.Warn(logger)
+}
+
+func errorLevel(logger Logger) Logger {
+	return level.Error(logger)
+}
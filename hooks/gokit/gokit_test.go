@@ -0,0 +1,108 @@
+package gokit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/go-kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLogger struct {
+	keyvals [][]interface{}
+}
+
+func (l *recordingLogger) Log(keyvals ...interface{}) error {
+	l.keyvals = append(l.keyvals, keyvals)
+	return nil
+}
+
+func newContext(took time.Duration, err error) *sqlhooks.Context {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = "SELECT * FROM t WHERE id = ?"
+	ctx.Args = []interface{}{1}
+	ctx.Duration = took
+	ctx.Error = err
+	return ctx
+}
+
+func TestLogsCompletedStatements(t *testing.T) {
+	logger := &recordingLogger{}
+	hook := New(logger)
+	hook.Slow = time.Second
+
+	require.NoError(t, hook.AfterQuery(newContext(time.Millisecond, nil)))
+	require.NoError(t, hook.AfterStmtExec(newContext(2*time.Second, nil)))
+
+	boom := errors.New("boom")
+	assert.Equal(t, boom, hook.AfterExec(newContext(time.Millisecond, boom)))
+
+	require.Len(t, logger.keyvals, 3)
+	assert.Equal(t, []interface{}{
+		level.Key(), level.DebugValue(),
+		"msg", "query", "sql", "SELECT * FROM t WHERE id = ?", "args", "[1]", "took", time.Millisecond,
+	}, logger.keyvals[0])
+	assert.Equal(t, []interface{}{
+		level.Key(), level.WarnValue(),
+		"msg", "query", "sql", "SELECT * FROM t WHERE id = ?", "args", "[1]", "took", 2 * time.Second,
+	}, logger.keyvals[1])
+	assert.Equal(t, []interface{}{
+		level.Key(), level.ErrorValue(),
+		"msg", "query", "sql", "SELECT * FROM t WHERE id = ?", "args", "[1]", "took", time.Millisecond, "err", boom,
+	}, logger.keyvals[2])
+}
+
+func TestRedactsArgs(t *testing.T) {
+	logger := &recordingLogger{}
+	hook := New(logger)
+	hook.RedactArgs = true
+
+	require.NoError(t, hook.AfterQuery(newContext(time.Millisecond, nil)))
+	require.Len(t, logger.keyvals, 1)
+	assert.Contains(t, logger.keyvals[0], "[?]")
+}
+
+func TestTruncatesQueries(t *testing.T) {
+	logger := &recordingLogger{}
+	hook := New(logger)
+	hook.MaxQueryLen = 8
+
+	require.NoError(t, hook.AfterQuery(newContext(time.Millisecond, nil)))
+	require.Len(t, logger.keyvals, 1)
+	assert.Contains(t, logger.keyvals[0], "SELECT *...")
+}
+
+func TestSkipsSuccessfulPrepares(t *testing.T) {
+	logger := &recordingLogger{}
+	hook := New(logger)
+
+	require.NoError(t, hook.AfterPrepare(newContext(0, nil)))
+	assert.Empty(t, logger.keyvals)
+}
+
+func TestMigrations(t *testing.T) {
+	logger := &recordingLogger{}
+	hook := New(logger)
+
+	ctx := newContext(time.Millisecond, nil)
+	ctx.Query = "DROP TABLE t"
+	ctx.Args = nil
+	ctx.Migration = true
+
+	hook.Migrations = sqlhooks.ExcludeMigrations
+	require.NoError(t, hook.AfterExec(ctx))
+	assert.Empty(t, logger.keyvals)
+
+	hook.Migrations = sqlhooks.LabelMigrations
+	require.NoError(t, hook.AfterExec(ctx))
+	require.NoError(t, hook.AfterExec(newContext(time.Millisecond, nil)))
+	require.Len(t, logger.keyvals, 2)
+	assert.Equal(t, []interface{}{
+		level.Key(), level.DebugValue(),
+		"msg", "query", "sql", "DROP TABLE t", "args", "[]", "took", time.Millisecond, "migration", true,
+	}, logger.keyvals[0])
+	assert.NotContains(t, logger.keyvals[1], "migration")
+}
@@ -0,0 +1,281 @@
+// Package honeycomb provides hooks sending an event per completed statement
+// to Honeycomb, through a libhoney shaped Sender
+package honeycomb
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/internal/queue"
+)
+
+// queueSize is the number of events waiting to be sent before new ones are dropped
+const queueSize = 1024
+
+// Event is a statement event, a sampled one stands for SampleRate statements
+type Event struct {
+	Fields     map[string]interface{}
+	SampleRate uint
+}
+
+// Sender sends events, for libhoney it can be written as:
+//
+//	func(e honeycomb.Event) error {
+//		ev := builder.NewEvent()
+//		ev.Add(e.Fields)
+//		ev.SampleRate = e.SampleRate
+//		return ev.SendPresampled()
+//	}
+type Sender interface {
+	SendPresampled(Event) error
+}
+
+// SenderFunc is a function implementing Sender
+type SenderFunc func(Event) error
+
+func (fn SenderFunc) SendPresampled(e Event) error {
+	return fn(e)
+}
+
+// Sampler decides whether the event of a statement is sent, and its sample rate
+type Sampler interface {
+	Sample(fingerprint string, took time.Duration, err error) (rate uint, keep bool)
+}
+
+type hook struct {
+	// Sampler samples the events, every one is sent when nil
+	Sampler Sampler
+
+	// Migrations sets whether the events of migration statements are sent,
+	// and whether they have a migration field
+	Migrations sqlhooks.Migrations
+
+	// Phases adds the time_to_first_byte_ms, time_to_first_row_ms and
+	// total_time_ms fields to the events of queries, see
+	// sqlhooks.Context.TimeToFirstByte
+	Phases bool
+
+	sender Sender
+	queue  *queue.Queue
+}
+
+// New returns hooks sending statement events to sender, from a background
+// goroutine so statements never wait for it. Events are dropped when sender
+// can't keep up.
+func New(sender Sender) *hook {
+	return &hook{sender: sender, queue: queue.New("honeycomb", 1, queueSize)}
+}
+
+// Close waits for the queued events to be sent, no events are sent afterwards
+func (h *hook) Close() {
+	h.queue.Close()
+}
+
+// Shutdown is Close giving up on the events still queued once ctx is done,
+// Driver.Shutdown calls it
+func (h *hook) Shutdown(ctx context.Context) error {
+	return h.queue.Shutdown(ctx)
+}
+
+// Dropped returns the number of events dropped because sender couldn't keep
+// up, or sent after Close
+func (h *hook) Dropped() uint64 {
+	return h.queue.Dropped()
+}
+
+// SetInternalReporter reports the events dropped and the panics of sender
+// to the Driver
+func (h *hook) SetInternalReporter(report func(event string, err error)) {
+	h.queue.SetReporter(report)
+}
+
+func (h *hook) send(ctx *sqlhooks.Context, rows int64) {
+	if h.Migrations.Excludes(ctx) {
+		return
+	}
+
+	fingerprint := sqlhooks.Fingerprint(ctx.Query)
+	rate := uint(1)
+	if h.Sampler != nil {
+		var keep bool
+		if rate, keep = h.Sampler.Sample(fingerprint, ctx.Duration, ctx.Error); !keep {
+			return
+		}
+	}
+
+	fields := map[string]interface{}{
+		"fingerprint": fingerprint,
+		"duration_ms": milliseconds(ctx.Duration),
+		"rows":        rows,
+		"tx_id":       ctx.TxID,
+		"conn_id":     ctx.ConnID,
+	}
+	if ctx.System.Name != "" {
+		fields["db.system"] = ctx.System.Name
+	}
+	if ctx.Peer != "" {
+		fields["peer"] = ctx.Peer
+	}
+	if h.Phases && ctx.TimeToFirstByte > 0 {
+		fields["time_to_first_byte_ms"] = milliseconds(ctx.TimeToFirstByte)
+		fields["time_to_first_row_ms"] = milliseconds(ctx.TimeToFirstRow)
+		fields["total_time_ms"] = milliseconds(ctx.TotalTime)
+	}
+	if h.Migrations.Labels(ctx) {
+		fields["migration"] = true
+	}
+	if ctx.Error != nil && !sqlhooks.IsNotFound(ctx.Error) {
+		fields["error"] = ctx.Error.Error()
+		fields["error_class"] = string(ctx.ErrorClass())
+	}
+	e := Event{Fields: fields, SampleRate: rate}
+	h.queue.Push(0, func() { h.sender.SendPresampled(e) })
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// countingRows counts the rows read
+type countingRows struct {
+	driver.Rows
+	n int64
+}
+
+func (r *countingRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == nil {
+		r.n++
+	}
+	return err
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	return nil
+}
+
+// afterQuery sends failed queries, the others are sent once their rows are closed
+func (h *hook) afterQuery(ctx *sqlhooks.Context) error {
+	if ctx.Error != nil {
+		h.send(ctx, 0)
+	}
+	return ctx.Error
+}
+
+func (h *hook) afterExec(ctx *sqlhooks.Context) error {
+	var rows int64
+	if ctx.Result != nil {
+		rows, _ = ctx.Result.RowsAffected()
+	}
+	h.send(ctx, rows)
+	return ctx.Error
+}
+
+func (h *hook) WrapRows(ctx *sqlhooks.Context, rows driver.Rows) driver.Rows {
+	r := &countingRows{Rows: rows}
+	ctx.Set("honeycomb", r)
+	return r
+}
+
+func (h *hook) AfterRowsClose(ctx *sqlhooks.Context) error {
+	if r, ok := ctx.Get("honeycomb").(*countingRows); ok {
+		h.send(ctx, r.n)
+	}
+	return ctx.Error
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error {
+	return h.afterQuery(ctx)
+}
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error {
+	return h.afterExec(ctx)
+}
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
+
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error {
+	return h.afterQuery(ctx)
+}
+
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error {
+	return h.afterExec(ctx)
+}
+
+type dynamicSampler struct {
+	// Target is the number of events per fingerprint and Interval sent
+	// without sampling, hotter fingerprints are sampled down to about it
+	Target uint
+
+	// Interval is the window fingerprints rates are computed on
+	Interval time.Duration
+
+	// Slow is the duration from which statements are always sent, 0 disables it
+	Slow time.Duration
+
+	mu     sync.Mutex
+	now    func() time.Time
+	start  time.Time
+	counts map[string]uint
+	last   map[string]uint
+}
+
+// NewDynamicSampler returns a Sampler sending every failed or slow statement,
+// and about target events per fingerprint and minute for the rest. Rates
+// are based on the number of statements of the previous minute.
+func NewDynamicSampler(target uint, slow time.Duration) *dynamicSampler {
+	return &dynamicSampler{
+		Target:   target,
+		Interval: time.Minute,
+		Slow:     slow,
+		now:      time.Now,
+		counts:   make(map[string]uint),
+		last:     make(map[string]uint),
+	}
+}
+
+func (s *dynamicSampler) Sample(fingerprint string, took time.Duration, err error) (uint, bool) {
+	if err != nil || s.Slow > 0 && took >= s.Slow {
+		return 1, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := s.now(); now.Sub(s.start) >= s.Interval {
+		s.start = now
+		s.last, s.counts = s.counts, make(map[string]uint)
+	}
+
+	s.counts[fingerprint]++
+	rate := uint(1)
+	if s.Target > 0 && s.last[fingerprint] > s.Target {
+		rate = s.last[fingerprint] / s.Target
+	}
+	return rate, s.counts[fingerprint]%rate == 0
+}
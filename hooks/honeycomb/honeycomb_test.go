@@ -0,0 +1,257 @@
+package honeycomb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSender struct {
+	events []Event
+}
+
+func (s *recordingSender) SendPresampled(e Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+type result int64
+
+func (r result) LastInsertId() (int64, error) { return 0, nil }
+func (r result) RowsAffected() (int64, error) { return int64(r), nil }
+
+type fakeRows struct {
+	n int
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.n == 0 {
+		return io.EOF
+	}
+	r.n--
+	dest[0] = int64(r.n)
+	return nil
+}
+
+func newContext(query string) *sqlhooks.Context {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = query
+	ctx.Duration = 1500 * time.Microsecond
+	ctx.TxID = 3
+	return ctx
+}
+
+func TestSendsStatementEvents(t *testing.T) {
+	sender := &recordingSender{}
+	hook := New(sender)
+
+	ctx := newContext("UPDATE t SET f1 = 'x'")
+	ctx.Result = result(2)
+	require.NoError(t, hook.AfterExec(ctx))
+
+	ctx = newContext("SELECT * FROM t WHERE id = 1")
+	require.NoError(t, hook.AfterStmtQuery(ctx))
+	rows := hook.WrapRows(ctx, &fakeRows{n: 3})
+	dest := make([]driver.Value, 1)
+	for rows.Next(dest) == nil {
+	}
+	require.NoError(t, hook.AfterRowsClose(ctx))
+
+	boom := errors.New("boom")
+	ctx = newContext("DELETE FROM t")
+	ctx.Error = boom
+	assert.Equal(t, boom, hook.AfterExec(ctx))
+
+	hook.Close()
+	require.Len(t, sender.events, 3)
+	assert.Equal(t, Event{SampleRate: 1, Fields: map[string]interface{}{
+		"fingerprint": "UPDATE t SET f1 = ?",
+		"duration_ms": 1.5,
+		"rows":        int64(2),
+		"tx_id":       uint64(3),
+		"conn_id":     uint64(0),
+	}}, sender.events[0])
+	assert.Equal(t, int64(3), sender.events[1].Fields["rows"])
+	assert.Equal(t, "boom", sender.events[2].Fields["error"])
+	assert.Equal(t, "other", sender.events[2].Fields["error_class"])
+}
+
+func TestSendsDBSystemAndPeer(t *testing.T) {
+	sender := &recordingSender{}
+	hook := New(sender)
+
+	ctx := newContext("DELETE FROM t")
+	ctx.System = sqlhooks.System("pgx")
+	require.NoError(t, hook.AfterExec(ctx))
+	ctx.Peer = "db-2:5432"
+	require.NoError(t, hook.AfterExec(ctx))
+	hook.Close()
+
+	require.Len(t, sender.events, 2)
+	assert.Equal(t, "postgresql", sender.events[0].Fields["db.system"])
+	assert.NotContains(t, sender.events[0].Fields, "peer")
+	assert.Equal(t, "db-2:5432", sender.events[1].Fields["peer"])
+}
+
+func TestSendsPhases(t *testing.T) {
+	for _, phases := range []bool{false, true} {
+		sender := &recordingSender{}
+		hook := New(sender)
+		hook.Phases = phases
+
+		ctx := newContext("SELECT * FROM t")
+		require.NoError(t, hook.AfterQuery(ctx))
+		rows := hook.WrapRows(ctx, &fakeRows{})
+		require.Equal(t, io.EOF, rows.Next(make([]driver.Value, 1)))
+		ctx.TimeToFirstByte = 500 * time.Microsecond
+		ctx.TotalTime = 700 * time.Microsecond
+		require.NoError(t, hook.AfterRowsClose(ctx))
+		hook.Close()
+
+		require.Len(t, sender.events, 1)
+		fields := sender.events[0].Fields
+		if !phases {
+			assert.NotContains(t, fields, "time_to_first_byte_ms")
+			continue
+		}
+		assert.Equal(t, 0.5, fields["time_to_first_byte_ms"])
+		assert.Equal(t, 0.0, fields["time_to_first_row_ms"], "no row")
+		assert.Equal(t, 0.7, fields["total_time_ms"])
+	}
+}
+
+func TestDynamicSampler(t *testing.T) {
+	now := time.Now()
+	s := NewDynamicSampler(10, time.Second)
+	s.now = func() time.Time { return now }
+
+	sample := func(fingerprint string, n int) (sent int, rate uint) {
+		for i := 0; i < n; i++ {
+			r, keep := s.Sample(fingerprint, time.Millisecond, nil)
+			if keep {
+				sent++
+				rate = r
+			}
+		}
+		return sent, rate
+	}
+
+	sent, _ := sample("hot", 100)
+	assert.Equal(t, 100, sent, "the first window has no rates yet")
+	sample("rare", 5)
+
+	now = now.Add(time.Minute)
+	sent, rate := sample("hot", 100)
+	assert.Equal(t, 10, sent)
+	assert.Equal(t, uint(10), rate)
+	sent, rate = sample("rare", 5)
+	assert.Equal(t, 5, sent)
+	assert.Equal(t, uint(1), rate)
+
+	rate, keep := s.Sample("hot", 2*time.Second, nil)
+	assert.True(t, keep, "slow statements are always sent")
+	assert.Equal(t, uint(1), rate)
+	_, keep = s.Sample("hot", time.Millisecond, errors.New("boom"))
+	assert.True(t, keep, "failed statements are always sent")
+}
+
+func TestSamplerDropsEvents(t *testing.T) {
+	sender := &recordingSender{}
+	hook := New(sender)
+	s := NewDynamicSampler(1, 0)
+	s.last["SELECT ?"] = 1000
+	s.start = time.Now()
+	hook.Sampler = s
+
+	for i := 0; i < 10; i++ {
+		hook.AfterExec(newContext("SELECT 1"))
+	}
+	hook.Close()
+	assert.Empty(t, sender.events)
+}
+
+// queueGoroutines counts the goroutines running an async queue, as goleak would find them
+func queueGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return strings.Count(string(buf), "queue.(*Queue).run(")
+}
+
+// requireNoQueueGoroutines waits for the queues goroutines to exit
+func requireNoQueueGoroutines(t *testing.T) {
+	for i := 0; queueGoroutines() > 0; i++ {
+		require.True(t, i < 100, "leaked goroutines")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDriverShutdown(t *testing.T) {
+	sender := &recordingSender{}
+	hook := New(sender)
+	d := sqlhooks.NewDriver("", sqlhooks.Compose(hook))
+	hook.AfterExec(newContext("DELETE FROM t"))
+
+	require.NoError(t, d.Shutdown(context.Background()))
+	requireNoQueueGoroutines(t)
+	assert.Len(t, sender.events, 1)
+
+	// events sent afterwards are dropped
+	hook.AfterExec(newContext("DELETE FROM t"))
+	assert.Len(t, sender.events, 1)
+	assert.Equal(t, uint64(1), hook.Dropped())
+	assert.Equal(t, uint64(1), d.Stats().EventsDropped, "the drops are reported to the driver")
+}
+
+func TestDriverShutdownDeadline(t *testing.T) {
+	block := make(chan struct{})
+	hook := New(SenderFunc(func(Event) error {
+		<-block
+		return nil
+	}))
+	for i := 0; i < 3; i++ {
+		hook.AfterExec(newContext("DELETE FROM t"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, sqlhooks.NewDriver("", hook).Shutdown(ctx))
+
+	close(block)
+	requireNoQueueGoroutines(t)
+	assert.Equal(t, uint64(2), hook.Dropped())
+}
+
+func TestMigrations(t *testing.T) {
+	sender := &recordingSender{}
+	hook := New(sender)
+	hook.Migrations = sqlhooks.LabelMigrations
+
+	ctx := newContext("ALTER TABLE t ADD COLUMN c int")
+	ctx.Result = driver.ResultNoRows
+	ctx.Migration = true
+	require.NoError(t, hook.AfterExec(ctx))
+	require.NoError(t, hook.AfterExec(newContext("UPDATE t SET c = 1")))
+
+	excluding := New(sender)
+	excluding.Migrations = sqlhooks.ExcludeMigrations
+	require.NoError(t, excluding.AfterExec(ctx))
+	excluding.Close()
+
+	hook.Close()
+	require.Len(t, sender.events, 2)
+	assert.Equal(t, true, sender.events[0].Fields["migration"])
+	assert.Equal(t, int64(0), sender.events[0].Fields["rows"])
+	assert.NotContains(t, sender.events[1].Fields, "migration")
+}
@@ -1,2 +1,9 @@
-// Package hooks provides ready-to-use hook implementations
+// Package hooks provides ready-to-use hook implementations.
+//
+// Each integration lives in its own package, depending on the small
+// interface it defines, such as a Logger or a Tracer, rather than on a
+// vendor SDK, so it can be tested with fakes and only the integrations in
+// use are built. When an SDK is needed, its use is kept in a thin
+// _adapter.go file of the package, otherwise the package documentation
+// shows the adapter to write. The sqlhooks package itself has no dependency.
 package hooks
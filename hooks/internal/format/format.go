@@ -0,0 +1,55 @@
+// Package format holds the helpers shared by the logging hooks
+package format
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// Args formats args as fmt's %v does, except for []byte which are shown as
+// strings and output parameters, shown as <out>. Named ones are prefixed by
+// their name and =. When redact is set the values are replaced by ?, so only
+// their number is shown.
+func Args(args []interface{}, redact bool) string {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, arg := range args {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+
+		if named, ok := arg.(sql.NamedArg); ok {
+			buf.WriteString(named.Name)
+			buf.WriteByte('=')
+			arg = named.Value
+		}
+
+		if redact {
+			buf.WriteByte('?')
+		} else if sqlhooks.IsOutArg(arg) {
+			buf.WriteString("<out>")
+		} else if b, ok := arg.([]byte); ok {
+			buf.Write(b)
+		} else {
+			fmt.Fprint(&buf, arg)
+		}
+	}
+	buf.WriteByte(']')
+	return buf.String()
+}
+
+// Query returns query cut to its first max bytes followed by ..., without
+// splitting a character, when it's longer. max <= 0 leaves it whole.
+func Query(query string, max int) string {
+	if max <= 0 || len(query) <= max {
+		return query
+	}
+	for max > 0 && !utf8.RuneStart(query[max]) {
+		max--
+	}
+	return query[:max] + "..."
+}
@@ -0,0 +1,26 @@
+package format
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArgs(t *testing.T) {
+	args := []interface{}{"1", 2, []byte("foo"), nil}
+	assert.Equal(t, "[1 2 foo <nil>]", Args(args, false))
+	assert.Equal(t, "[? ? ? ?]", Args(args, true))
+	assert.Equal(t, "[]", Args(nil, false))
+
+	named := []interface{}{sql.Named("n", 1), sql.Named("name", []byte("foo"))}
+	assert.Equal(t, "[n=1 name=foo]", Args(named, false))
+	assert.Equal(t, "[n=? name=?]", Args(named, true))
+}
+
+func TestQuery(t *testing.T) {
+	assert.Equal(t, "SELECT 1", Query("SELECT 1", 0))
+	assert.Equal(t, "SELECT 1", Query("SELECT 1", 8))
+	assert.Equal(t, "SELECT...", Query("SELECT 1", 6))
+	assert.Equal(t, "SELECT 'h...", Query("SELECT 'héhé'", 10), "é isn't split")
+}
@@ -8,8 +8,10 @@ import (
 	"time"
 
 	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/hooks/internal/format"
 )
 
+// Logger is the same interface as sqlhooks.Logger, satisfied by *log.Logger
 type Logger interface {
 	Printf(format string, v ...interface{})
 }
@@ -17,6 +19,10 @@ type Logger interface {
 type hook struct {
 	id  uint64
 	Log Logger
+
+	// Migrations sets whether migration statements are logged, and
+	// whether they're tagged as such
+	Migrations sqlhooks.Migrations
 }
 
 func (h *hook) next() uint64 {
@@ -30,18 +36,30 @@ func New() *hook {
 }
 
 func (h *hook) before(ctx *sqlhooks.Context) error {
+	if h.Migrations.Excludes(ctx) {
+		return nil
+	}
+
 	id := h.next()
 	ctx.Set("start", time.Now())
 	ctx.Set("id", id)
 
-	h.Log.Printf("[query#%09d] %s %v", id, ctx.Query, ctx.Args)
+	if h.Migrations.Labels(ctx) {
+		h.Log.Printf("[query#%09d] migration: %s %s", id, ctx.Query, format.Args(ctx.Args, false))
+		return nil
+	}
+	h.Log.Printf("[query#%09d] %s %s", id, ctx.Query, format.Args(ctx.Args, false))
 	return nil
 
 }
 
 func (h *hook) after(ctx *sqlhooks.Context) error {
+	start, ok := ctx.Get("start").(time.Time)
+	if !ok {
+		return ctx.Error
+	}
 	id := ctx.Get("id")
-	took := time.Since(ctx.Get("start").(time.Time))
+	took := time.Since(start)
 
 	if err := ctx.Error; err != nil {
 		h.Log.Printf("[query#%09d] Finished with error: %v", id, err)
@@ -86,3 +86,20 @@ func TestLoggerIncrementsQueryCounter(t *testing.T) {
 	hook.AfterQuery(ctx)
 	assert.Contains(t, buf.String(), "[query#000000010] ")
 }
+
+func TestLoggerMigrations(t *testing.T) {
+	hook, buf := newTestHook()
+
+	ctx := sqlhooks.NewContext()
+	ctx.Query = "CREATE TABLE t (id int)"
+	ctx.Migration = true
+
+	hook.Migrations = sqlhooks.ExcludeMigrations
+	require.NoError(t, hook.BeforeExec(ctx))
+	require.NoError(t, hook.AfterExec(ctx))
+	assert.Empty(t, buf.String())
+
+	hook.Migrations = sqlhooks.LabelMigrations
+	require.NoError(t, hook.BeforeExec(ctx))
+	assert.Contains(t, buf.String(), "[query#000000001] migration: CREATE TABLE t (id int)")
+}
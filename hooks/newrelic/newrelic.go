@@ -0,0 +1,132 @@
+// Package newrelic provides hooks recording statements as New Relic datastore segments.
+//
+// The package doesn't depend on the New Relic agent, segments are started by
+// a Tracer, which with the agent can be written as:
+//
+//	newrelic.TracerFunc(func(ctx context.Context, s newrelic.Segment) newrelic.Ender {
+//		txn := nr.FromContext(ctx)
+//		if txn == nil {
+//			return nil
+//		}
+//		return &nr.DatastoreSegment{
+//			StartTime:          txn.StartSegmentNow(),
+//			Product:            nr.DatastoreProduct(s.Product),
+//			Collection:         s.Collection,
+//			Operation:          s.Operation,
+//			ParameterizedQuery: s.ParameterizedQuery,
+//		}
+//	})
+package newrelic
+
+import (
+	"context"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// Segment holds the datastore segment attributes
+type Segment struct {
+	Product            string
+	Collection         string
+	Operation          string
+	ParameterizedQuery string
+}
+
+// Ender is a started segment, *newrelic.DatastoreSegment implements it
+type Ender interface {
+	End()
+}
+
+// Tracer starts a segment of the transaction carried by ctx, it returns nil
+// when ctx has no transaction
+type Tracer interface {
+	StartSegment(ctx context.Context, s Segment) Ender
+}
+
+// TracerFunc is a function implementing Tracer
+type TracerFunc func(ctx context.Context, s Segment) Ender
+
+func (fn TracerFunc) StartSegment(ctx context.Context, s Segment) Ender {
+	return fn(ctx, s)
+}
+
+type hook struct {
+	// Product is the datastore product segments are reported for, when
+	// empty it's the one of the Context.System of the statement
+	Product string
+
+	tracer Tracer
+}
+
+// New returns hooks starting a datastore segment of tracer for every
+// statement, Product is inferred from driverName as sqlhooks.System does, or
+// from the Context.System of every statement when driverName is empty
+func New(tracer Tracer, driverName string) *hook {
+	return &hook{Product: sqlhooks.System(driverName).Product, tracer: tracer}
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	op, table := ctx.Classify()
+	product := h.Product
+	if product == "" {
+		product = ctx.System.Product
+	}
+	seg := h.tracer.StartSegment(ctx.Ctx, Segment{
+		Product:            product,
+		Collection:         table,
+		Operation:          op,
+		ParameterizedQuery: sqlhooks.Fingerprint(ctx.Query),
+	})
+	if seg != nil {
+		ctx.Set("newrelic", seg)
+	}
+	return nil
+}
+
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	if seg, ok := ctx.Get("newrelic").(Ender); ok {
+		seg.End()
+		ctx.Set("newrelic", nil)
+	}
+	return ctx.Error
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error {
+	return nil
+}
+
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
@@ -0,0 +1,88 @@
+package newrelic
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type txnKey struct{}
+
+type segment struct {
+	Segment
+	ended bool
+}
+
+func (s *segment) End() {
+	s.ended = true
+}
+
+// application starts segments for contexts carrying a txnKey
+type application struct {
+	segments []*segment
+}
+
+func (a *application) StartSegment(ctx context.Context, s Segment) Ender {
+	if ctx.Value(txnKey{}) == nil {
+		return nil
+	}
+	seg := &segment{Segment: s}
+	a.segments = append(a.segments, seg)
+	return seg
+}
+
+func newContext(stdCtx context.Context, query string) *sqlhooks.Context {
+	ctx := sqlhooks.NewContext()
+	ctx.Ctx = stdCtx
+	ctx.Query = query
+	return ctx
+}
+
+func TestRecordsDatastoreSegments(t *testing.T) {
+	app := &application{}
+	hook := New(app, "postgres")
+	txn := context.WithValue(context.Background(), txnKey{}, true)
+
+	ctx := newContext(txn, "SELECT f1 FROM t WHERE f2 = 'foo'")
+	require.NoError(t, hook.BeforeQuery(ctx))
+	require.Len(t, app.segments, 1)
+	assert.False(t, app.segments[0].ended)
+	require.NoError(t, hook.AfterQuery(ctx))
+
+	assert.Equal(t, &segment{
+		Segment: Segment{
+			Product:            "Postgres",
+			Collection:         "t",
+			Operation:          "select",
+			ParameterizedQuery: "SELECT f1 FROM t WHERE f2 = ?",
+		},
+		ended: true,
+	}, app.segments[0])
+}
+
+func TestProduct(t *testing.T) {
+	assert.Equal(t, "MySQL", New(&application{}, "mysql").Product)
+	assert.Equal(t, "cockroach", New(&application{}, "cockroach").Product)
+
+	// Taken from the statements without a driver name
+	app := &application{}
+	hook := New(app, "")
+	ctx := newContext(context.WithValue(context.Background(), txnKey{}, true), "SELECT 1")
+	ctx.System = sqlhooks.System("sqlite3")
+	require.NoError(t, hook.BeforeQuery(ctx))
+	require.Len(t, app.segments, 1)
+	assert.Equal(t, "SQLite", app.segments[0].Product)
+}
+
+func TestNoopWithoutTransaction(t *testing.T) {
+	app := &application{}
+	hook := New(app, "mysql")
+
+	ctx := newContext(context.Background(), "DELETE FROM t")
+	require.NoError(t, hook.BeforeStmtExec(ctx))
+	require.NoError(t, hook.AfterStmtExec(ctx))
+	assert.Empty(t, app.segments)
+}
@@ -0,0 +1,186 @@
+// Package planhash provides a hook detecting the plan flips of Postgres
+// statements, the usual cause of sudden latency regressions: it explains a
+// sample of the statements with EXPLAIN (FORMAT JSON), reduces their plan to
+// a hash of its structure and calls a function when the hash of a
+// fingerprint changes:
+//
+//	h := planhash.New(0.01, func(c planhash.Change) {
+//		log.Printf("plan of %s changed from %s to %s:\n%s", c.Fingerprint, c.Old, c.New, c.Plan)
+//	})
+//	db, err := sqlhooks.Open("postgres", dsn, h)
+package planhash
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"regexp"
+	"sync"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// DefaultMaxFingerprints is the default MaxFingerprints
+const DefaultMaxFingerprints = 1024
+
+// Change is a change of the plan of a fingerprint
+type Change struct {
+	Fingerprint string
+	Query       string
+
+	// Old and New are the hashes of the plans, as returned by Hash
+	Old, New string
+
+	// Plan is the new plan, as EXPLAIN returned it
+	Plan string
+}
+
+// Func receives the plan changes
+type Func func(Change)
+
+type patternRate struct {
+	pattern *regexp.Regexp
+	rate    float64
+}
+
+type hook struct {
+	// MaxFingerprints bounds the number of fingerprints whose plan hash is
+	// remembered, the statements of the others aren't explained. It must be
+	// set before the hook is used.
+	MaxFingerprints int
+
+	rate     float64
+	patterns []patternRate
+	fn       Func
+	random   func() float64
+
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+// New returns a hook explaining the statements with probability rate, from 0
+// to 1, and calling fn when the plan of their fingerprint, as returned by
+// sqlhooks.Fingerprint, changed since it was last explained.
+//
+// Only the SELECT, INSERT, UPDATE and DELETE statements which succeeded are
+// explained, never inside a transaction, where a failing EXPLAIN would abort
+// it. EXPLAIN doesn't run the statement, but it does run on the connection of
+// the statement once it ran, Query statements once their rows are closed.
+func New(rate float64, fn Func) *hook {
+	return &hook{
+		MaxFingerprints: DefaultMaxFingerprints,
+		rate:            rate,
+		fn:              fn,
+		random:          rand.Float64,
+		hashes:          make(map[string]string),
+	}
+}
+
+// ForPattern sets the rate of the statements whose fingerprint matches
+// pattern, replacing the default one, 0 not to explain them. Patterns are
+// tried in the order they're given. It must be called before the hook is
+// used.
+func (h *hook) ForPattern(pattern *regexp.Regexp, rate float64) *hook {
+	h.patterns = append(h.patterns, patternRate{pattern: pattern, rate: rate})
+	return h
+}
+
+func (h *hook) rateOf(fingerprint string) float64 {
+	for _, p := range h.patterns {
+		if p.pattern.MatchString(fingerprint) {
+			return p.rate
+		}
+	}
+	return h.rate
+}
+
+func (h *hook) ExplainQuery(ctx *sqlhooks.Context) (string, bool) {
+	if ctx.Error != nil || ctx.TxID != 0 {
+		return "", false
+	}
+	switch ctx.Kind() {
+	case sqlhooks.KindSelect, sqlhooks.KindInsert, sqlhooks.KindUpdate, sqlhooks.KindDelete:
+	default:
+		return "", false
+	}
+
+	fingerprint := sqlhooks.Fingerprint(ctx.Query)
+	if rate := h.rateOf(fingerprint); rate <= 0 || h.random() >= rate {
+		return "", false
+	}
+
+	h.mu.Lock()
+	_, known := h.hashes[fingerprint]
+	full := len(h.hashes) >= h.MaxFingerprints
+	h.mu.Unlock()
+	if !known && full {
+		return "", false
+	}
+	return "EXPLAIN (FORMAT JSON) " + ctx.Query, true
+}
+
+func (h *hook) AfterExplain(ctx *sqlhooks.Context, plan string, err error) {
+	if err != nil {
+		return
+	}
+	hash, err := Hash(plan)
+	if err != nil {
+		return
+	}
+
+	fingerprint := sqlhooks.Fingerprint(ctx.Query)
+	h.mu.Lock()
+	old, known := h.hashes[fingerprint]
+	if !known && len(h.hashes) >= h.MaxFingerprints {
+		h.mu.Unlock()
+		return
+	}
+	h.hashes[fingerprint] = hash
+	h.mu.Unlock()
+
+	if known && old != hash {
+		h.fn(Change{Fingerprint: fingerprint, Query: ctx.Query, Old: old, New: hash, Plan: plan})
+	}
+}
+
+// node is a node of a Postgres JSON plan, reduced to its structure
+type node struct {
+	NodeType     string `json:"Node Type"`
+	RelationName string `json:"Relation Name"`
+	IndexName    string `json:"Index Name"`
+	Plans        []node `json:"Plans"`
+}
+
+func (n node) write(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "(%q %q %q", n.NodeType, n.RelationName, n.IndexName)
+	for _, child := range n.Plans {
+		child.write(buf)
+	}
+	buf.WriteByte(')')
+}
+
+// Hash returns the hash of the structure of plan, as returned by Postgres'
+// EXPLAIN (FORMAT JSON): the types of its nodes, the relations and indexes
+// they scan and how they nest. Costs, row estimates and the other details
+// don't change it.
+func Hash(plan string) (string, error) {
+	var explained []struct {
+		Plan node `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(plan), &explained); err != nil {
+		return "", fmt.Errorf("planhash: invalid plan: %v", err)
+	}
+	if len(explained) == 0 {
+		return "", fmt.Errorf("planhash: empty plan")
+	}
+
+	var buf bytes.Buffer
+	for _, e := range explained {
+		e.Plan.write(&buf)
+	}
+	h := fnv.New64a()
+	h.Write(buf.Bytes())
+	return fmt.Sprintf("%016x", h.Sum64()), nil
+}
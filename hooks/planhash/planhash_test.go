@@ -0,0 +1,120 @@
+package planhash
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	seqScan = `[{"Plan": {"Node Type": "Seq Scan", "Relation Name": "users", "Total Cost": 35.5}}]`
+	// seqScanCosts only differs by its costs
+	seqScanCosts = `[{"Plan": {"Node Type": "Seq Scan", "Relation Name": "users", "Total Cost": 99, "Plan Rows": 3}}]`
+	indexScan    = `[{"Plan": {"Node Type": "Index Scan", "Relation Name": "users", "Index Name": "users_pkey"}}]`
+	nested       = `[{"Plan": {"Node Type": "Nested Loop", "Plans": [
+		{"Node Type": "Seq Scan", "Relation Name": "users"},
+		{"Node Type": "Index Scan", "Relation Name": "orders", "Index Name": "orders_user_id"}
+	]}}]`
+)
+
+func TestHash(t *testing.T) {
+	hash := func(plan string) string {
+		h, err := Hash(plan)
+		require.NoError(t, err)
+		return h
+	}
+	assert.Equal(t, hash(seqScan), hash(seqScanCosts), "costs are ignored")
+	assert.NotEqual(t, hash(seqScan), hash(indexScan))
+	assert.NotEqual(t, hash(seqScan), hash(nested))
+	assert.Len(t, hash(nested), 16)
+
+	_, err := Hash("Seq Scan on users")
+	assert.Error(t, err)
+	_, err = Hash("[]")
+	assert.EqualError(t, err, "planhash: empty plan")
+}
+
+func TestPlanChanges(t *testing.T) {
+	const query = "SELECT * FROM users WHERE id = ?"
+	defer drivertest.SetPlan(query, "")
+
+	var changes []Change
+	h := New(1, func(c Change) { changes = append(changes, c) })
+	h.random = func() float64 { return 0.5 }
+	db, err := sqlhooks.Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), h)
+	require.NoError(t, err)
+	defer db.Close()
+
+	run := func(plan string) {
+		drivertest.SetPlan(query, plan)
+		rows, err := db.Query(query, 1)
+		require.NoError(t, err)
+		require.NoError(t, rows.Close())
+	}
+	run(seqScan)
+	run(seqScanCosts)
+	assert.Empty(t, changes, "the costs changed, not the plan")
+
+	run(indexScan)
+	require.Len(t, changes, 1)
+	assert.Equal(t, Change{
+		Fingerprint: "SELECT * FROM users WHERE id = ?",
+		Query:       query,
+		Old:         changes[0].Old,
+		New:         changes[0].New,
+		Plan:        indexScan,
+	}, changes[0])
+	seqHash, _ := Hash(seqScan)
+	indexHash, _ := Hash(indexScan)
+	assert.Equal(t, seqHash, changes[0].Old)
+	assert.Equal(t, indexHash, changes[0].New)
+
+	// Never within a transaction
+	drivertest.SetPlan(query, seqScan)
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	rows, err := tx.Query(query, 1)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.NoError(t, tx.Commit())
+	assert.Len(t, changes, 1)
+
+	// Not sampled
+	h.random = func() float64 { return 1 }
+	run(nested)
+	assert.Len(t, changes, 1)
+}
+
+func newContext(query string, txID uint64) *sqlhooks.Context {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = query
+	ctx.TxID = txID
+	return ctx
+}
+
+func TestSkippedStatements(t *testing.T) {
+	h := New(1, nil).ForPattern(regexp.MustCompile("^DELETE"), 0)
+	h.MaxFingerprints = 1
+	explain := func(query string, txID uint64) bool {
+		_, ok := h.ExplainQuery(newContext(query, txID))
+		return ok
+	}
+
+	assert.False(t, explain("CREATE TABLE t (id int)", 0))
+	assert.False(t, explain("DELETE FROM t", 0), "rate 0")
+	assert.False(t, explain("UPDATE t SET n = 1", 7), "in a transaction")
+
+	query, ok := h.ExplainQuery(newContext("UPDATE t SET n = 1", 0))
+	assert.True(t, ok)
+	assert.Equal(t, "EXPLAIN (FORMAT JSON) UPDATE t SET n = 1", query)
+
+	// Only MaxFingerprints are remembered
+	h.AfterExplain(newContext("UPDATE t SET n = 1", 0), seqScan, nil)
+	assert.True(t, explain("UPDATE t SET n = 2", 0), "same fingerprint")
+	assert.False(t, explain("INSERT INTO t VALUES (1)", 0))
+	assert.Len(t, h.hashes, 1)
+}
@@ -0,0 +1,326 @@
+// Package resultcache provides a hook serving the result sets of repeated
+// SELECTs from memory, without calling the driver.
+//
+// Caching is best effort and opt-in per query, with sqlhooks.Cacheable:
+//
+//	ctx := sqlhooks.Cacheable(ctx, 5*time.Second)
+//	rows, err := db.QueryContext(ctx, "SELECT name FROM users WHERE id = ?", id)
+//
+// Result sets are keyed by query and arguments, and dropped once their ttl
+// elapsed or when a statement that may write to the database runs through
+// the same hook: any statement other than a SELECT or a transaction control
+// one, INSERTs, UPDATEs and DELETEs only drop the result sets of their table
+// with PerTable. Writes made by other processes, or by connections not using
+// the hook, aren't seen.
+package resultcache
+
+import (
+	"bytes"
+	"container/list"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// pendingKey is the Context key of the result set being read for the cache
+const pendingKey = "resultcache.pending"
+
+// Stats holds the counters of the cache
+type Stats struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+}
+
+type entry struct {
+	key     string
+	table   string
+	columns []string
+	rows    [][]driver.Value
+	bytes   int
+	expires time.Time
+}
+
+type hook struct {
+	// Predicate restricts the queries cached, along with sqlhooks.Cacheable,
+	// every Cacheable SELECT is when it's nil
+	Predicate func(ctx *sqlhooks.Context) bool
+
+	// PerTable only drops, on a write, the result sets whose table is the
+	// one written to, as told by sqlhooks.Classify, rather than all of them.
+	// Classify only tells the first table of a query, so result sets joining
+	// other tables may be served stale.
+	PerTable bool
+
+	// MaxEntries and MaxBytes bound the number of result sets cached and the
+	// approximate size of their values, result sets bigger than MaxBytes
+	// aren't cached
+	MaxEntries int
+	MaxBytes   int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	bytes   int
+	stats   Stats
+
+	// generation is bumped by every invalidation, result sets read before
+	// one aren't cached
+	generation uint64
+
+	now func() time.Time
+}
+
+// New returns a hook caching up to 1000 result sets and 16MB by default
+func New() *hook {
+	return &hook{
+		MaxEntries: 1000,
+		MaxBytes:   16 << 20,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// Stats returns a snapshot of the cache counters
+func (h *hook) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+// Purge drops every cached result set
+func (h *hook) Purge() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.invalidate("")
+}
+
+// pending is a result set read from the driver, to be cached once it's read entirely
+type pending struct {
+	key        string
+	table      string
+	ttl        time.Duration
+	generation uint64
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	if ctx.Get(pendingKey) != nil {
+		// The Context of a prepared statement is reused by its executions
+		ctx.Set(pendingKey, nil)
+	}
+
+	ttl, ok := sqlhooks.CacheableTTL(ctx.Ctx)
+	if !ok || ttl <= 0 || ctx.Kind() != sqlhooks.KindSelect || h.Predicate != nil && !h.Predicate(ctx) {
+		return nil
+	}
+	key, ok := cacheKey(ctx.Query, ctx.Args)
+	if !ok {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if el, ok := h.entries[key]; ok {
+		e := el.Value.(*entry)
+		if h.now().Before(e.expires) {
+			h.stats.Hits++
+			h.lru.MoveToFront(el)
+			ctx.SubstituteRows(e.columns, copyRows(e.rows))
+			return nil
+		}
+		h.remove(el)
+	}
+
+	h.stats.Misses++
+	_, table := ctx.Classify()
+	ctx.Set(pendingKey, &pending{key: key, table: strings.ToLower(table), ttl: ttl, generation: h.generation})
+	return nil
+}
+
+// after drops the result sets ctx's statement may have changed
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	if ctx.Synthetic {
+		return ctx.Error
+	}
+
+	table := ""
+	switch ctx.Kind() {
+	case sqlhooks.KindSelect, sqlhooks.KindTx:
+		return ctx.Error
+	case sqlhooks.KindInsert, sqlhooks.KindUpdate, sqlhooks.KindDelete:
+		if h.PerTable {
+			_, table = ctx.Classify()
+		}
+	}
+
+	h.mu.Lock()
+	h.invalidate(strings.ToLower(table))
+	h.mu.Unlock()
+	return ctx.Error
+}
+
+// invalidate drops the result sets of table, all of them for ""
+func (h *hook) invalidate(table string) {
+	h.generation++
+	h.stats.Invalidations++
+	for el := h.lru.Front(); el != nil; {
+		next := el.Next()
+		if table == "" || el.Value.(*entry).table == table {
+			h.remove(el)
+		}
+		el = next
+	}
+}
+
+func (h *hook) remove(el *list.Element) {
+	e := h.lru.Remove(el).(*entry)
+	delete(h.entries, e.key)
+	h.bytes -= e.bytes
+}
+
+// add caches e unless the cache was invalidated since the result set was
+// read, at generation, evicting the least recently used result sets to make room
+func (h *hook) add(e *entry, generation uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if generation != h.generation || e.bytes > h.MaxBytes {
+		return
+	}
+	if el, ok := h.entries[e.key]; ok {
+		h.remove(el)
+	}
+	for h.lru.Len() > 0 && (h.lru.Len() >= h.MaxEntries || h.bytes+e.bytes > h.MaxBytes) {
+		h.remove(h.lru.Back())
+		h.stats.Evictions++
+	}
+	if h.MaxEntries <= 0 {
+		return
+	}
+	h.entries[e.key] = h.lru.PushFront(e)
+	h.bytes += e.bytes
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error   { return nil }
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error    { return ctx.Error }
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error  { return nil }
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error   { return h.after(ctx) }
+
+func (h *hook) WrapRows(ctx *sqlhooks.Context, rows driver.Rows) driver.Rows {
+	p, ok := ctx.Get(pendingKey).(*pending)
+	if !ok || ctx.Synthetic {
+		return rows
+	}
+	ctx.Set(pendingKey, nil)
+	columns := append([]string(nil), rows.Columns()...)
+	return &cachingRows{Rows: rows, hook: h, pending: p, entry: &entry{key: p.key, table: p.table, columns: columns}}
+}
+
+// cacheKey returns the key of the result set of query run with args, false
+// if it can't be told, as when WithCollapseInLists left arguments out
+func cacheKey(query string, args []interface{}) (string, bool) {
+	var b bytes.Buffer
+	b.WriteString(query)
+	for _, arg := range args {
+		if _, ok := arg.(sqlhooks.CollapsedArgs); ok {
+			return "", false
+		}
+		fmt.Fprintf(&b, "\x00%T:%v", arg, arg)
+	}
+	return b.String(), true
+}
+
+// cachingRows records the rows read by the caller, the result set is
+// cached once they are all read, unless it's abandoned, for being too big or
+// failing
+type cachingRows struct {
+	driver.Rows
+	hook      *hook
+	pending   *pending
+	entry     *entry
+	complete  bool
+	abandoned bool
+}
+
+func (r *cachingRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch {
+	case err == io.EOF:
+		r.complete = true
+	case err != nil:
+		r.abandoned = true
+	case !r.abandoned:
+		row := make([]driver.Value, len(dest))
+		for i, v := range dest {
+			// Drivers may reuse their buffers between rows
+			row[i] = copyValue(v)
+			r.entry.bytes += size(v)
+		}
+		r.entry.rows = append(r.entry.rows, row)
+		if r.entry.bytes > r.hook.MaxBytes {
+			r.abandoned = true
+			r.entry.rows = nil
+		}
+	}
+	return err
+}
+
+func (r *cachingRows) Close() error {
+	err := r.Rows.Close()
+	if err == nil && r.complete && !r.abandoned {
+		r.entry.bytes += len(r.entry.key)
+		r.entry.expires = r.hook.now().Add(r.pending.ttl)
+		r.hook.add(r.entry, r.pending.generation)
+	}
+	return err
+}
+
+// copyRows returns a copy of rows the caller can't change the cache through
+func copyRows(rows [][]driver.Value) [][]driver.Value {
+	copied := make([][]driver.Value, len(rows))
+	for i, row := range rows {
+		copied[i] = make([]driver.Value, len(row))
+		for j, v := range row {
+			copied[i][j] = copyValue(v)
+		}
+	}
+	return copied
+}
+
+func copyValue(v driver.Value) driver.Value {
+	if b, ok := v.([]byte); ok {
+		return append([]byte(nil), b...)
+	}
+	return v
+}
+
+// size approximates the memory used by v
+func size(v driver.Value) int {
+	switch v := v.(type) {
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	case time.Time:
+		return 24
+	case nil:
+		return 0
+	}
+	return 8
+}
@@ -0,0 +1,294 @@
+package resultcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDriver answers every query with two rows, whose []byte values share
+// a buffer overwritten by every row, as drivers do
+type fakeDriver struct {
+	mu      sync.Mutex
+	queries []string
+	name    string
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+func (d *fakeDriver) logged() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+type fakeConn struct {
+	driver *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c *fakeConn) log(query string) {
+	c.driver.mu.Lock()
+	c.driver.queries = append(c.driver.queries, query)
+	c.driver.mu.Unlock()
+}
+
+func (c *fakeConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	c.log(query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	c.log(query)
+	return &fakeRows{name: c.driver.name}, nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.Exec(s.query, args)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.Query(s.query, args)
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	name string
+	buf  []byte
+	n    int
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id", "name"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.n == 2 {
+		return io.EOF
+	}
+	r.n++
+	r.buf = append(r.buf[:0], strings.Repeat(r.name, r.n)...)
+	dest[0] = int64(r.n)
+	dest[1] = r.buf
+	return nil
+}
+
+var drivers int
+
+// open returns a database using a new fakeDriver through h
+func open(t *testing.T, h *hook) (*sql.DB, *fakeDriver) {
+	d := &fakeDriver{name: "a"}
+	drivers++
+	name := fmt.Sprintf("resultcache-fake-%d", drivers)
+	sql.Register(name, d)
+
+	db, err := sqlhooks.Open(name, "", h)
+	require.NoError(t, err)
+	return db, d
+}
+
+type user struct {
+	id   int64
+	name []byte
+}
+
+func query(t *testing.T, db *sql.DB, ctx context.Context, q string, args ...interface{}) []user {
+	rows, err := db.QueryContext(ctx, q, args...)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var users []user
+	for rows.Next() {
+		var u user
+		require.NoError(t, rows.Scan(&u.id, &u.name))
+		users = append(users, u)
+	}
+	require.NoError(t, rows.Err())
+	return users
+}
+
+var cacheable = sqlhooks.Cacheable(context.Background(), time.Minute)
+
+func TestCachesResultSets(t *testing.T) {
+	h := New()
+	db, d := open(t, h)
+	defer db.Close()
+
+	expected := []user{{1, []byte("a")}, {2, []byte("aa")}}
+	assert.Equal(t, expected, query(t, db, cacheable, "SELECT id, name FROM users WHERE id > ?", 0))
+	d.name = "b"
+	assert.Equal(t, expected, query(t, db, cacheable, "SELECT id, name FROM users WHERE id > ?", 0))
+	assert.Equal(t, []string{"SELECT id, name FROM users WHERE id > ?"}, d.logged())
+
+	// other arguments, or no Cacheable marker, are other result sets
+	query(t, db, cacheable, "SELECT id, name FROM users WHERE id > ?", "0")
+	query(t, db, context.Background(), "SELECT id, name FROM users WHERE id > ?", 0)
+	assert.Len(t, d.logged(), 3)
+
+	s, err := db.PrepareContext(cacheable, "SELECT id, name FROM users")
+	require.NoError(t, err)
+	defer s.Close()
+	for i := 0; i < 2; i++ {
+		rows, err := s.QueryContext(cacheable)
+		require.NoError(t, err)
+		for rows.Next() {
+		}
+		require.NoError(t, rows.Close())
+	}
+	assert.Len(t, d.logged(), 4)
+
+	assert.Equal(t, Stats{Hits: 2, Misses: 3}, h.Stats())
+}
+
+func TestCachedValuesAreCopied(t *testing.T) {
+	h := New()
+	db, d := open(t, h)
+	defer db.Close()
+
+	read := func() [][]byte {
+		rows, err := db.QueryContext(cacheable, "SELECT id, name FROM users")
+		require.NoError(t, err)
+		defer rows.Close()
+
+		var names [][]byte
+		for rows.Next() {
+			var id int
+			var name sql.RawBytes
+			require.NoError(t, rows.Scan(&id, &name))
+			names = append(names, append([]byte(nil), name...))
+			// RawBytes points into the driver's, or the cache's, memory
+			for i := range name {
+				name[i] = 'x'
+			}
+		}
+		require.NoError(t, rows.Err())
+		return names
+	}
+
+	expected := [][]byte{[]byte("a"), []byte("aa")}
+	assert.Equal(t, expected, read(), "from the driver, reusing its buffer")
+	assert.Equal(t, expected, read(), "from the cache")
+	assert.Equal(t, expected, read(), "from the cache, after changing the values it served")
+	assert.Len(t, d.logged(), 1)
+}
+
+func TestExpires(t *testing.T) {
+	h := New()
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	db, d := open(t, h)
+	defer db.Close()
+
+	ctx := sqlhooks.Cacheable(context.Background(), time.Second)
+	query(t, db, ctx, "SELECT id, name FROM users")
+	now = now.Add(999 * time.Millisecond)
+	query(t, db, ctx, "SELECT id, name FROM users")
+	assert.Len(t, d.logged(), 1)
+
+	now = now.Add(time.Millisecond)
+	query(t, db, ctx, "SELECT id, name FROM users")
+	assert.Len(t, d.logged(), 2)
+}
+
+func TestWritesInvalidate(t *testing.T) {
+	for _, perTable := range []bool{false, true} {
+		h := New()
+		h.PerTable = perTable
+		db, d := open(t, h)
+
+		query(t, db, cacheable, "SELECT id, name FROM users")
+		query(t, db, cacheable, "SELECT id, name FROM orders")
+
+		_, err := db.Exec("UPDATE Users SET name = 'b' WHERE id = 1")
+		require.NoError(t, err)
+		query(t, db, cacheable, "SELECT id, name FROM users")
+		query(t, db, cacheable, "SELECT id, name FROM orders")
+		if perTable {
+			assert.Len(t, d.logged(), 4, "orders are still cached")
+		} else {
+			assert.Len(t, d.logged(), 5)
+		}
+
+		// statements whose table isn't known drop everything
+		_, err = db.Exec("CREATE TABLE t (id INT)")
+		require.NoError(t, err)
+		n := len(d.logged())
+		query(t, db, cacheable, "SELECT id, name FROM users")
+		query(t, db, cacheable, "SELECT id, name FROM orders")
+		assert.Len(t, d.logged(), n+2)
+
+		// and transaction control nothing
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+		query(t, db, cacheable, "SELECT id, name FROM users")
+		assert.Len(t, d.logged(), n+2)
+
+		db.Close()
+	}
+}
+
+func TestNotCached(t *testing.T) {
+	h := New()
+	h.Predicate = func(ctx *sqlhooks.Context) bool {
+		return !strings.Contains(ctx.Query, "secrets")
+	}
+	db, d := open(t, h)
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		query(t, db, cacheable, "SELECT id, name FROM secrets")
+		query(t, db, cacheable, "INSERT INTO users VALUES (1) RETURNING id, name")
+
+		// result sets not read entirely
+		rows, err := db.QueryContext(cacheable, "SELECT id, name FROM users")
+		require.NoError(t, err)
+		rows.Next()
+		require.NoError(t, rows.Close())
+	}
+	assert.Len(t, d.logged(), 6)
+}
+
+func TestBounds(t *testing.T) {
+	h := New()
+	h.MaxEntries = 2
+	db, d := open(t, h)
+	defer db.Close()
+
+	for _, table := range []string{"a", "b", "c", "a"} {
+		query(t, db, cacheable, "SELECT id, name FROM "+table)
+	}
+	assert.Len(t, d.logged(), 4, "a was evicted")
+	assert.Equal(t, uint64(2), h.Stats().Evictions)
+
+	h.Purge()
+	h.MaxEntries = 10
+	h.MaxBytes = 10
+	query(t, db, cacheable, "SELECT id, name FROM too_big_to_be_cached")
+	query(t, db, cacheable, "SELECT id, name FROM too_big_to_be_cached")
+	assert.Len(t, d.logged(), 6)
+}
@@ -0,0 +1,195 @@
+// Package sentry provides a hook reporting failed statements to Sentry.
+//
+// The package doesn't depend on the Sentry SDK, events are handed to a
+// Capturer, which for a *sentry.Hub, with the SDK imported as sentrygo, can
+// be written as:
+//
+//	sentry.CapturerFunc(func(e sentry.Event) {
+//		hub.WithScope(func(scope *sentrygo.Scope) {
+//			scope.SetFingerprint([]string{e.Query})
+//			scope.SetTags(e.Tags)
+//			scope.SetExtra("query", e.Query)
+//			scope.SetExtra("duration", e.Duration.String())
+//			hub.CaptureException(e.Err)
+//		})
+//	})
+package sentry
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// maxTracked bounds the number of fingerprints remembered by the rate limiter
+const maxTracked = 1024
+
+// Event describes a failed statement
+type Event struct {
+	// Query is the fingerprint of the statement, see sqlhooks.Fingerprint
+	Query    string
+	Duration time.Duration
+	Class    sqlhooks.ErrorClass
+	Err      error
+
+	// Tags holds the transaction id and the trace of the statement, if any
+	Tags map[string]string
+
+	// Args are the statement arguments, only set when UnsafeArgs is enabled
+	Args []interface{}
+}
+
+// Capturer reports events to Sentry
+type Capturer interface {
+	Capture(Event)
+}
+
+// CapturerFunc is a function implementing Capturer
+type CapturerFunc func(Event)
+
+func (fn CapturerFunc) Capture(e Event) {
+	fn(e)
+}
+
+type hook struct {
+	// Skip holds the error classes that aren't reported, by default the
+	// retryable and canceled ones
+	Skip map[sqlhooks.ErrorClass]bool
+
+	// Interval is the minimum time between two reports of the same fingerprint
+	Interval time.Duration
+
+	// UnsafeArgs sends the statement arguments along, which may hold personal data
+	UnsafeArgs bool
+
+	capturer Capturer
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// New returns a hook reporting failed statements to capturer
+func New(capturer Capturer) *hook {
+	return &hook{
+		Skip: map[sqlhooks.ErrorClass]bool{
+			sqlhooks.ErrorClassCanceled:      true,
+			sqlhooks.ErrorClassDeadlock:      true,
+			sqlhooks.ErrorClassSerialization: true,
+			sqlhooks.ErrorClassBadConn:       true,
+		},
+		Interval: time.Minute,
+		capturer: capturer,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// allow reports whether query wasn't reported during the last Interval
+func (h *hook) allow(query string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if t, ok := h.last[query]; ok && now.Sub(t) < h.Interval {
+		return false
+	}
+
+	if len(h.last) >= maxTracked {
+		for q, t := range h.last {
+			if now.Sub(t) >= h.Interval {
+				delete(h.last, q)
+			}
+		}
+		if len(h.last) >= maxTracked {
+			return false
+		}
+	}
+
+	h.last[query] = now
+	return true
+}
+
+func (h *hook) report(ctx *sqlhooks.Context) {
+	if ctx.Error == nil {
+		return
+	}
+
+	class := ctx.ErrorClass()
+	if class == sqlhooks.ErrorClassNone || h.Skip[class] {
+		return
+	}
+
+	query := sqlhooks.Fingerprint(ctx.Query)
+	if !h.allow(query, time.Now()) {
+		return
+	}
+
+	e := Event{
+		Query:    query,
+		Duration: ctx.Duration,
+		Class:    class,
+		Err:      ctx.Error,
+		Tags:     map[string]string{"db.error_class": string(class)},
+	}
+	if ctx.TxID != 0 {
+		e.Tags["db.tx_id"] = strconv.FormatUint(ctx.TxID, 10)
+	}
+	if info, ok := ctx.TraceInfo(); ok {
+		e.Tags["trace_id"] = info.TraceID
+		e.Tags["span_id"] = info.SpanID
+	}
+	if h.UnsafeArgs {
+		e.Args = ctx.Args
+	}
+
+	h.capturer.Capture(e)
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	return nil
+}
+
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	h.report(ctx)
+	return ctx.Error
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
@@ -0,0 +1,86 @@
+package sentry
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type events []Event
+
+func (e *events) Capture(event Event) {
+	*e = append(*e, event)
+}
+
+func newContext(query string, err error) *sqlhooks.Context {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = query
+	ctx.Args = []interface{}{"secret"}
+	ctx.Duration = time.Millisecond
+	ctx.TxID = 7
+	ctx.Error = err
+	return ctx
+}
+
+func TestReportsFailedStatements(t *testing.T) {
+	var captured events
+	hook := New(&captured)
+
+	boom := errors.New("boom")
+	assert.Equal(t, boom, hook.AfterExec(newContext("DELETE FROM t WHERE id = 1", boom)))
+
+	require.Len(t, captured, 1)
+	assert.Equal(t, Event{
+		Query:    "DELETE FROM t WHERE id = ?",
+		Duration: time.Millisecond,
+		Class:    sqlhooks.ErrorClassOther,
+		Err:      boom,
+		Tags:     map[string]string{"db.error_class": "other", "db.tx_id": "7"},
+	}, captured[0], "args aren't sent by default")
+}
+
+func TestSkipsErrorClasses(t *testing.T) {
+	var captured events
+	hook := New(&captured)
+
+	for _, err := range []error{nil, sql.ErrNoRows, context.Canceled, driver.ErrBadConn} {
+		hook.AfterQuery(newContext("SELECT 1", err))
+	}
+	assert.Empty(t, captured)
+
+	delete(hook.Skip, sqlhooks.ErrorClassCanceled)
+	hook.AfterQuery(newContext("SELECT 1", context.Canceled))
+	assert.Len(t, captured, 1)
+}
+
+func TestRateLimitsPerFingerprint(t *testing.T) {
+	var captured events
+	hook := New(&captured)
+
+	boom := errors.New("boom")
+	hook.AfterExec(newContext("DELETE FROM t WHERE id = 1", boom))
+	hook.AfterExec(newContext("DELETE FROM t WHERE id = 2", boom))
+	hook.AfterExec(newContext("DELETE FROM u WHERE id = 2", boom))
+	assert.Len(t, captured, 2)
+
+	hook.Interval = 0
+	hook.AfterExec(newContext("DELETE FROM t WHERE id = 3", boom))
+	assert.Len(t, captured, 3)
+}
+
+func TestUnsafeArgs(t *testing.T) {
+	var captured events
+	hook := New(&captured)
+	hook.UnsafeArgs = true
+
+	hook.AfterStmtExec(newContext("SELECT 1", errors.New("boom")))
+	require.Len(t, captured, 1)
+	assert.Equal(t, []interface{}{"secret"}, captured[0].Args)
+}
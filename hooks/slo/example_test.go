@@ -0,0 +1,30 @@
+package slo_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/gchaincl/sqlhooks/hooks/slo"
+)
+
+func ExampleNew() {
+	h := slo.New(50*time.Millisecond).ForKind(sqlhooks.KindDDL, 5*time.Second)
+	db, err := sqlhooks.Open(drivertest.Name, "context;ExampleNew", h)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Exec("INSERT INTO t VALUES (1)")
+	db.Exec("SLEEP 60ms|INSERT INTO t VALUES (2)")
+	db.Exec("CREATE INDEX t_n ON t (n)")
+
+	stats := h.Stats()
+	fmt.Printf("default %+v\n", stats.Objectives["default"])
+	fmt.Printf("ddl %+v\n", stats.Objectives["ddl"])
+	// Output:
+	// default {Conforming:1 Violating:1}
+	// ddl {Conforming:1 Violating:0}
+}
@@ -0,0 +1,253 @@
+// Package slo provides a hook checking the duration of statements against
+// latency objectives, counting the ones meeting them and the ones violating
+// them, and alerting when too many violate them over a rolling window,
+// without any metrics pipeline:
+//
+//	h := slo.New(50 * time.Millisecond).ForKind(sqlhooks.KindDDL, 5*time.Second)
+//	h.Limit = 0.01
+//	h.Alert = func(ratio float64, window slo.Counts) {
+//		log.Printf("%.1f%% of the last %d statements over their objective", 100*ratio, window.Total())
+//	}
+package slo
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// Counts counts the statements meeting an objective and the ones violating it
+type Counts struct {
+	Conforming uint64
+	Violating  uint64
+}
+
+func (c Counts) Total() uint64 {
+	return c.Conforming + c.Violating
+}
+
+// Ratio returns the ratio of violating statements, 0 without any statement
+func (c Counts) Ratio() float64 {
+	if c.Total() == 0 {
+		return 0
+	}
+	return float64(c.Violating) / float64(c.Total())
+}
+
+func (c *Counts) add(violating bool) {
+	if violating {
+		c.Violating++
+	} else {
+		c.Conforming++
+	}
+}
+
+// Stats is a snapshot of the counts of the hook
+type Stats struct {
+	// Objectives are the counts of each objective since the hook was
+	// created, by name: "default", the name of a kind or a pattern
+	Objectives map[string]Counts
+
+	// Window are the counts of every objective over the rolling window
+	Window Counts
+}
+
+type objective struct {
+	name      string
+	pattern   *regexp.Regexp
+	threshold time.Duration
+}
+
+// bucket counts the statements of a slice of the window, epoch is the
+// number of the slice since the Unix epoch
+type bucket struct {
+	epoch int64
+	Counts
+}
+
+type hook struct {
+	// Window is the duration the violation ratio is computed on, split in
+	// Buckets, rolled one at a time. They must be set before the hook is
+	// used, the defaults are a minute and 12 buckets.
+	Window  time.Duration
+	Buckets int
+
+	// Limit is the violation ratio over the window above which Alert is
+	// called, as 0.01 for 99% of the statements meeting their objective
+	Limit float64
+
+	// MinStatements is the number of statements the window must have for
+	// Alert to be called, so a few slow statements don't trigger it
+	MinStatements uint64
+
+	// Alert is called when the violation ratio over the window rises above
+	// Limit, with the ratio and the counts of the window. It's called again
+	// only once the ratio went back to Limit or under it.
+	Alert func(ratio float64, window Counts)
+
+	defaultThreshold time.Duration
+	kinds            map[sqlhooks.Kind]objective
+	patterns         []objective
+
+	mu       sync.Mutex
+	counts   map[string]*Counts
+	ring     []bucket
+	alerting bool
+
+	now func() time.Time
+}
+
+// New returns a hook whose default objective is for statements to take
+// less than threshold, 0 for none: only the statements ForKind and
+// ForPattern give an objective are counted then.
+//
+// The duration of a statement is the one of its driver call, as in
+// Context.Duration, failed statements violate their objective whatever their
+// duration, the ones skipped by the driver and the synthetic ones aren't
+// counted.
+func New(threshold time.Duration) *hook {
+	return &hook{
+		Window:           time.Minute,
+		Buckets:          12,
+		defaultThreshold: threshold,
+		kinds:            make(map[sqlhooks.Kind]objective),
+		counts:           make(map[string]*Counts),
+		now:              time.Now,
+	}
+}
+
+// ForKind sets the objective of the statements of kind to take less than
+// threshold, replacing the default one. It must be called before the hook is
+// used.
+func (h *hook) ForKind(kind sqlhooks.Kind, threshold time.Duration) *hook {
+	h.kinds[kind] = objective{name: kind.String(), threshold: threshold}
+	return h
+}
+
+// ForPattern sets the objective of the statements whose fingerprint, as
+// returned by sqlhooks.Fingerprint, matches pattern to take less than
+// threshold, replacing the kind and default ones. Patterns are tried in the
+// order they're given. It must be called before the hook is used.
+func (h *hook) ForPattern(pattern *regexp.Regexp, threshold time.Duration) *hook {
+	h.patterns = append(h.patterns, objective{name: pattern.String(), pattern: pattern, threshold: threshold})
+	return h
+}
+
+// objective returns the objective of ctx's statement, false if it has none
+func (h *hook) objective(ctx *sqlhooks.Context) (objective, bool) {
+	if len(h.patterns) > 0 {
+		fingerprint := sqlhooks.Fingerprint(ctx.Query)
+		for _, o := range h.patterns {
+			if o.pattern.MatchString(fingerprint) {
+				return o, true
+			}
+		}
+	}
+	if o, ok := h.kinds[ctx.Kind()]; ok {
+		return o, true
+	}
+	return objective{name: "default", threshold: h.defaultThreshold}, h.defaultThreshold > 0
+}
+
+// Stats returns a snapshot of the counts of the hook
+func (h *hook) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := Stats{Objectives: make(map[string]Counts, len(h.counts))}
+	for name, counts := range h.counts {
+		s.Objectives[name] = *counts
+	}
+	s.Window = h.window(h.now())
+	return s
+}
+
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	if ctx.Synthetic {
+		return ctx.Error
+	}
+	o, ok := h.objective(ctx)
+	if !ok {
+		return ctx.Error
+	}
+	failed := ctx.Error != nil && !sqlhooks.IsNotFound(ctx.Error)
+	violating := failed || ctx.Duration >= o.threshold
+
+	h.mu.Lock()
+	counts, ok := h.counts[o.name]
+	if !ok {
+		counts = &Counts{}
+		h.counts[o.name] = counts
+	}
+	counts.add(violating)
+
+	now := h.now()
+	h.bucket(now).add(violating)
+	window := h.window(now)
+	ratio := window.Ratio()
+	alert := false
+	switch {
+	case ratio <= h.Limit:
+		h.alerting = false
+	case !h.alerting && window.Total() >= h.MinStatements:
+		h.alerting = true
+		alert = h.Alert != nil
+	}
+	h.mu.Unlock()
+
+	if alert {
+		h.Alert(ratio, window)
+	}
+	return ctx.Error
+}
+
+// width returns the duration of a bucket
+func (h *hook) width() int64 {
+	if h.ring == nil {
+		h.ring = make([]bucket, h.Buckets)
+	}
+	width := int64(h.Window) / int64(len(h.ring))
+	if width <= 0 {
+		width = 1
+	}
+	return width
+}
+
+// bucket returns the bucket of now, emptied if it was last used for an
+// older slice of the window
+func (h *hook) bucket(now time.Time) *bucket {
+	epoch := now.UnixNano() / h.width()
+	b := &h.ring[epoch%int64(len(h.ring))]
+	if b.epoch != epoch {
+		*b = bucket{epoch: epoch}
+	}
+	return b
+}
+
+// window returns the counts of the buckets of the window ending at now
+func (h *hook) window(now time.Time) Counts {
+	epoch := now.UnixNano() / h.width()
+	var window Counts
+	for _, b := range h.ring {
+		if b.epoch <= epoch && b.epoch > epoch-int64(len(h.ring)) {
+			window.Conforming += b.Conforming
+			window.Violating += b.Violating
+		}
+	}
+	return window
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error   { return nil }
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error    { return ctx.Error }
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error  { return nil }
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error   { return h.after(ctx) }
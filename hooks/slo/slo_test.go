@@ -0,0 +1,166 @@
+package slo
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a fake clock, moved by hand
+type clock struct {
+	now time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Unix(1000, 0)}
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func (h *hook) withClock(c *clock) *hook {
+	h.now = func() time.Time { return c.now }
+	return h
+}
+
+// exec runs the Exec After hook of h for a statement taking took and failing with err
+func exec(h *hook, query string, took time.Duration, err error) error {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = query
+	ctx.Duration = took
+	ctx.Error = err
+	return h.AfterExec(ctx)
+}
+
+func TestObjectives(t *testing.T) {
+	h := New(50*time.Millisecond).
+		ForKind(sqlhooks.KindDDL, time.Second).
+		ForPattern(regexp.MustCompile(`FROM reports`), 500*time.Millisecond).
+		withClock(newClock())
+
+	errFailed := errors.New("failed")
+	assert.NoError(t, exec(h, "SELECT * FROM users", 10*time.Millisecond, nil))
+	assert.NoError(t, exec(h, "SELECT * FROM users", 50*time.Millisecond, nil))
+	assert.Equal(t, errFailed, exec(h, "SELECT * FROM users", time.Millisecond, errFailed))
+	// No rows isn't a failure
+	assert.Equal(t, sql.ErrNoRows, exec(h, "SELECT * FROM users", time.Millisecond, sql.ErrNoRows))
+	assert.NoError(t, exec(h, "CREATE TABLE t (id int)", 900*time.Millisecond, nil))
+	assert.NoError(t, exec(h, "SELECT * FROM reports WHERE id = 1", 400*time.Millisecond, nil))
+	assert.NoError(t, exec(h, "SELECT * FROM reports WHERE id = 2", 600*time.Millisecond, nil))
+
+	// Not counted
+	ctx := sqlhooks.NewContext()
+	ctx.Query = "SELECT 1"
+	ctx.Duration = time.Second
+	ctx.Synthetic = true
+	assert.NoError(t, h.AfterStmtQuery(ctx))
+
+	assert.Equal(t, Stats{
+		Objectives: map[string]Counts{
+			"default":      {Conforming: 2, Violating: 2},
+			"ddl":          {Conforming: 1},
+			"FROM reports": {Conforming: 1, Violating: 1},
+		},
+		Window: Counts{Conforming: 4, Violating: 3},
+	}, h.Stats())
+}
+
+func TestNoDefaultObjective(t *testing.T) {
+	h := New(0).ForKind(sqlhooks.KindSelect, time.Millisecond).withClock(newClock())
+	assert.NoError(t, exec(h, "INSERT INTO t VALUES (1)", time.Hour, nil))
+	assert.NoError(t, exec(h, "SELECT 1", time.Hour, nil))
+	assert.Equal(t, map[string]Counts{"select": {Violating: 1}}, h.Stats().Objectives)
+}
+
+func TestRollingWindow(t *testing.T) {
+	c := newClock()
+	h := New(10 * time.Millisecond).withClock(c)
+	h.Window = 10 * time.Second
+	h.Buckets = 5
+
+	run := func(conforming, violating int) {
+		for i := 0; i < conforming; i++ {
+			require.NoError(t, exec(h, "SELECT 1", time.Millisecond, nil))
+		}
+		for i := 0; i < violating; i++ {
+			require.NoError(t, exec(h, "SELECT 1", time.Second, nil))
+		}
+	}
+
+	run(9, 1)
+	assert.Equal(t, Counts{Conforming: 9, Violating: 1}, h.Stats().Window)
+	assert.InDelta(t, 0.1, h.Stats().Window.Ratio(), 1e-9)
+
+	// Same bucket, then the next ones
+	c.advance(time.Second)
+	run(0, 10)
+	c.advance(2 * time.Second)
+	run(20, 0)
+	assert.Equal(t, Counts{Conforming: 29, Violating: 11}, h.Stats().Window)
+
+	// The first bucket, holding the first 20 statements, rolls out of the window
+	c.advance(7 * time.Second)
+	assert.Equal(t, Counts{Conforming: 20}, h.Stats().Window)
+	assert.Equal(t, 0.0, h.Stats().Window.Ratio())
+
+	// Its slot is reused for the new statements
+	run(1, 1)
+	assert.Equal(t, Counts{Conforming: 21, Violating: 1}, h.Stats().Window)
+
+	// Everything rolls out, while the objective counts keep everything
+	c.advance(time.Hour)
+	assert.Equal(t, Counts{}, h.Stats().Window)
+	assert.Equal(t, Counts{Conforming: 30, Violating: 12}, h.Stats().Objectives["default"])
+}
+
+func TestAlert(t *testing.T) {
+	c := newClock()
+	h := New(10 * time.Millisecond).withClock(c)
+	h.Window = 10 * time.Second
+	h.Buckets = 10
+	h.Limit = 0.2
+	h.MinStatements = 5
+
+	type alert struct {
+		ratio  float64
+		window Counts
+	}
+	var alerts []alert
+	h.Alert = func(ratio float64, window Counts) {
+		alerts = append(alerts, alert{ratio, window})
+	}
+	slow := func() { require.NoError(t, exec(h, "SELECT 1", time.Second, nil)) }
+	fast := func() { require.NoError(t, exec(h, "SELECT 1", time.Millisecond, nil)) }
+
+	slow()
+	slow()
+	assert.Empty(t, alerts, "under MinStatements")
+	fast()
+	fast()
+	fast()
+	assert.Equal(t, []alert{{0.4, Counts{Conforming: 3, Violating: 2}}}, alerts, "2 slow out of 5")
+
+	slow()
+	assert.Len(t, alerts, 1, "still alerting")
+	for i := 0; i < 10; i++ {
+		fast()
+	}
+	assert.Len(t, alerts, 1)
+
+	// The ratio went under the limit, the next rise alerts again
+	c.advance(10 * time.Second)
+	for i := 0; i < 4; i++ {
+		fast()
+	}
+	slow()
+	slow()
+	assert.Len(t, alerts, 2)
+	assert.InDelta(t, 2.0/6, alerts[1].ratio, 1e-9)
+}
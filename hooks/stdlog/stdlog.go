@@ -0,0 +1,127 @@
+// Package stdlog provides a hook logging statements to a standard library
+// *log.Logger, or any sqlhooks.Logger, one line per completed statement:
+//
+//	[debug] 1.2ms SELECT * FROM users WHERE id = ? [42]
+//	[warn] 1.5s SELECT * FROM reports [2018]
+//	[error] 800µs INSERT INTO users (name) VALUES (?) [bob]: pq: duplicate key value
+package stdlog
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/hooks/internal/format"
+)
+
+type hook struct {
+	Logger sqlhooks.Logger
+
+	// Threshold is the duration under which successful statements aren't
+	// logged, 0 logs all of them
+	Threshold time.Duration
+
+	// Slow is the duration from which statements are logged at warn level
+	// instead of debug, 0 disables it
+	Slow time.Duration
+
+	// RedactArgs logs ? in place of every argument
+	RedactArgs bool
+
+	// MaxQueryLen is the length, in bytes, queries are truncated to, 0 logs
+	// them whole
+	MaxQueryLen int
+
+	// Migrations sets whether migration statements are logged, and
+	// whether they're tagged as such
+	Migrations sqlhooks.Migrations
+}
+
+// New returns a hook logging completed statements to logger, failed
+// statements are logged at error level, slow ones at warn level and the rest
+// at debug level
+func New(logger *log.Logger) *hook {
+	return &hook{Logger: logger}
+}
+
+func (h *hook) log(ctx *sqlhooks.Context) {
+	if h.Migrations.Excludes(ctx) {
+		return
+	}
+	if ctx.Error == nil && ctx.Duration < h.Threshold {
+		return
+	}
+
+	level := "debug"
+	switch {
+	case ctx.Error != nil:
+		level = "error"
+	case h.Slow > 0 && ctx.Duration >= h.Slow:
+		level = "warn"
+	}
+
+	query := format.Query(ctx.Query, h.MaxQueryLen)
+	if h.Migrations.Labels(ctx) {
+		query = "migration: " + query
+	}
+	line := fmt.Sprintf("[%s] %s %s %s", level, ctx.Duration, query, format.Args(ctx.Args, h.RedactArgs))
+	if ctx.Error != nil {
+		h.Logger.Printf("%s: %v", line, ctx.Error)
+		return
+	}
+	h.Logger.Printf("%s", line)
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	return nil
+}
+
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	h.log(ctx)
+	return ctx.Error
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+// AfterPrepare only logs failures, successful statements are logged once executed
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error {
+	if ctx.Error != nil {
+		h.log(ctx)
+	}
+	return ctx.Error
+}
+
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
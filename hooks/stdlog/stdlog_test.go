@@ -0,0 +1,94 @@
+package stdlog
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHook() (*hook, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	return New(log.New(buf, "", 0)), buf
+}
+
+func newContext(query string, took time.Duration, err error) *sqlhooks.Context {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = query
+	ctx.Args = []interface{}{1, []byte("foo")}
+	ctx.Duration = took
+	ctx.Error = err
+	return ctx
+}
+
+func TestLogsCompletedStatements(t *testing.T) {
+	hook, buf := newTestHook()
+	hook.Slow = time.Second
+
+	require.NoError(t, hook.AfterQuery(newContext("SELECT * FROM t WHERE id = ?", time.Millisecond, nil)))
+	require.NoError(t, hook.AfterStmtExec(newContext("UPDATE t SET v = ?", 2*time.Second, nil)))
+	boom := errors.New("boom")
+	assert.Equal(t, boom, hook.AfterExec(newContext("INSERT INTO t VALUES (?, ?)", time.Millisecond, boom)))
+
+	assert.Equal(t, ""+
+		"[debug] 1ms SELECT * FROM t WHERE id = ? [1 foo]\n"+
+		"[warn] 2s UPDATE t SET v = ? [1 foo]\n"+
+		"[error] 1ms INSERT INTO t VALUES (?, ?) [1 foo]: boom\n",
+		buf.String())
+}
+
+func TestThreshold(t *testing.T) {
+	hook, buf := newTestHook()
+	hook.Threshold = 10 * time.Millisecond
+
+	boom := errors.New("boom")
+	require.NoError(t, hook.AfterQuery(newContext("SELECT 1", time.Millisecond, nil)))
+	require.NoError(t, hook.AfterQuery(newContext("SELECT 2", 10*time.Millisecond, nil)))
+	assert.Equal(t, boom, hook.AfterQuery(newContext("SELECT 3", time.Millisecond, boom)))
+
+	assert.Equal(t, ""+
+		"[debug] 10ms SELECT 2 [1 foo]\n"+
+		"[error] 1ms SELECT 3 [1 foo]: boom\n",
+		buf.String())
+}
+
+func TestRedactsAndTruncates(t *testing.T) {
+	hook, buf := newTestHook()
+	hook.RedactArgs = true
+	hook.MaxQueryLen = 8
+
+	require.NoError(t, hook.AfterQuery(newContext("SELECT * FROM t WHERE id = ?", time.Millisecond, nil)))
+	assert.Equal(t, "[debug] 1ms SELECT *... [? ?]\n", buf.String())
+}
+
+func TestSkipsSuccessfulPrepares(t *testing.T) {
+	hook, buf := newTestHook()
+
+	require.NoError(t, hook.AfterPrepare(newContext("SELECT 1", 0, nil)))
+	assert.Empty(t, buf.String())
+
+	boom := errors.New("boom")
+	assert.Equal(t, boom, hook.AfterPrepare(newContext("SELECT", 0, boom)))
+	assert.Equal(t, "[error] 0s SELECT [1 foo]: boom\n", buf.String())
+}
+
+func TestMigrations(t *testing.T) {
+	hook, buf := newTestHook()
+
+	ctx := newContext("DROP TABLE t", time.Millisecond, nil)
+	ctx.Args = nil
+	ctx.Migration = true
+
+	hook.Migrations = sqlhooks.ExcludeMigrations
+	require.NoError(t, hook.AfterExec(ctx))
+	assert.Empty(t, buf.String())
+
+	hook.Migrations = sqlhooks.LabelMigrations
+	require.NoError(t, hook.AfterExec(ctx))
+	assert.Equal(t, "[debug] 1ms migration: DROP TABLE t []\n", buf.String())
+}
@@ -0,0 +1,378 @@
+package tenant
+
+import (
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	identifier tokenKind = iota
+	literal
+	placeholder
+	punctuation
+)
+
+// token is a token of a query. The text of identifiers is lower-cased and
+// unquoted, qualified ones joined by dots, the one of literals is their
+// value.
+type token struct {
+	kind tokenKind
+	text string
+
+	// index is the index of the argument of a placeholder, -1 when it can't
+	// be told, as for :name ones
+	index int
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// tokenize splits query into tokens, its comments left out
+func tokenize(query string) []token {
+	var tokens []token
+	positional := 0
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '\'':
+			var value string
+			value, i = quoted(query, i, '\'')
+			tokens = append(tokens, token{kind: literal, text: value})
+		case c == '"' || c == '`' || c == '[':
+			quote := c
+			if c == '[' {
+				quote = ']'
+			}
+			var name string
+			name, i = quoted(query, i, quote)
+			tokens = appendIdentifier(tokens, strings.ToLower(name))
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(query) && isIdentByte(query[end]) {
+				end++
+			}
+			tokens = appendIdentifier(tokens, strings.ToLower(query[i:end]))
+			i = end
+		case isDigit(c):
+			end := i + 1
+			for end < len(query) && (isDigit(query[end]) || query[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: literal, text: query[i:end]})
+			i = end
+		case c == '?':
+			tokens = append(tokens, token{kind: placeholder, index: positional})
+			positional++
+			i++
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			end := i + 1
+			for end < len(query) && isDigit(query[end]) {
+				end++
+			}
+			n, _ := strconv.Atoi(query[i+1 : end])
+			tokens = append(tokens, token{kind: placeholder, index: n - 1})
+			i = end
+		case c == '$':
+			// A dollar quoted string, as $$text$$ or $tag$text$tag$
+			end := strings.IndexByte(query[i+1:], '$')
+			if end < 0 {
+				tokens = append(tokens, token{kind: punctuation, text: "$"})
+				i++
+				continue
+			}
+			tag := query[i : i+end+2]
+			body := strings.Index(query[i+len(tag):], tag)
+			if body < 0 {
+				return tokens
+			}
+			tokens = append(tokens, token{kind: literal, text: query[i+len(tag) : i+len(tag)+body]})
+			i += 2*len(tag) + body
+		case (c == '@' || c == ':') && i+1 < len(query) && isIdentStart(query[i+1]) && (i == 0 || query[i-1] != ':'):
+			end := i + 1
+			for end < len(query) && isIdentByte(query[end]) {
+				end++
+			}
+			index := -1
+			if name := strings.ToLower(query[i+1 : end]); c == '@' && strings.HasPrefix(name, "p") {
+				if n, err := strconv.Atoi(name[1:]); err == nil {
+					index = n - 1
+				}
+			}
+			tokens = append(tokens, token{kind: placeholder, index: index})
+			i = end
+		default:
+			tokens = append(tokens, token{kind: punctuation, text: string(c)})
+			i++
+		}
+	}
+	return tokens
+}
+
+// quoted returns the unquoted text of the string or identifier starting at
+// i, a doubled quote being an escaped one, and the index following it
+func quoted(query string, i int, quote byte) (string, int) {
+	var b []byte
+	for i++; i < len(query); i++ {
+		if query[i] == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+			} else {
+				return string(b), i + 1
+			}
+		}
+		b = append(b, query[i])
+	}
+	return string(b), len(query)
+}
+
+// appendIdentifier appends the identifier name to tokens, joined to the one
+// it qualifies, as in schema.table
+func appendIdentifier(tokens []token, name string) []token {
+	if n := len(tokens); n >= 2 && tokens[n-1].is(punctuation, ".") && tokens[n-2].kind == identifier {
+		tokens[n-2].text += "." + name
+		return tokens[:n-1]
+	}
+	return append(tokens, token{kind: identifier, text: name})
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+func isIdentByte(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// predicate is a comparison of the tenant column, values are the
+// placeholders and literals it's compared with, none for a column
+type predicate struct {
+	values []token
+}
+
+// bound returns the values p compares the column with, as bound in args,
+// leaving out the ones that can't be told
+func (p predicate) bound(args []interface{}) []interface{} {
+	var values []interface{}
+	for _, v := range p.values {
+		switch {
+		case v.kind == literal:
+			values = append(values, v.text)
+		case v.kind == placeholder && v.index >= 0 && v.index < len(args):
+			values = append(values, args[v.index])
+		}
+	}
+	return values
+}
+
+// scanned is what scan tells of a query
+type scanned struct {
+	// tables are the tables the query references, as written in it
+	tables []string
+
+	// predicates are the comparisons of the tenant column in the
+	// conditions of the query and the values inserted in it
+	predicates []predicate
+
+	// inserted is set when the tenant column is one of the columns inserted
+	inserted bool
+}
+
+// Keywords followed by a table name
+var tableKeywords = map[string]bool{"from": true, "join": true, "update": true, "into": true, "using": true}
+
+// Keywords ending a list of tables, as in FROM a, b
+var listEnds = map[string]bool{
+	"where": true, "on": true, "set": true, "group": true, "order": true, "limit": true, "having": true,
+	"union": true, "except": true, "intersect": true, "join": true, "inner": true, "left": true,
+	"right": true, "full": true, "cross": true, "natural": true, "values": true, "select": true,
+	"returning": true, "using": true, "window": true, "offset": true, "for": true,
+}
+
+// Keywords ending a condition
+var conditionEnds = map[string]bool{
+	"group": true, "order": true, "limit": true, "union": true, "except": true, "intersect": true,
+	"returning": true, "window": true, "offset": true, "fetch": true, "for": true, "set": true,
+	"do": true,
+}
+
+// scan tells the tables of query and its predicates on column
+func scan(query, column string) scanned {
+	var s scanned
+	tokens := tokenize(query)
+
+	expectTable := false
+	tableList := false
+	condition := false
+	insertColumn := -1
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.kind != identifier {
+			switch {
+			case t.is(punctuation, ","):
+				expectTable = tableList
+			case t.is(punctuation, "("):
+				// A subquery, or a function
+				expectTable = false
+			}
+			continue
+		}
+
+		if expectTable {
+			if t.text == "only" || t.text == "lateral" {
+				continue
+			}
+			expectTable = false
+			s.tables = append(s.tables, t.text)
+			if i > 0 && tokens[i-1].is(identifier, "into") && i+1 < len(tokens) && tokens[i+1].is(punctuation, "(") {
+				var columns [][]token
+				columns, i = group(tokens, i+1)
+				insertColumn = position(columns, column)
+				s.inserted = insertColumn >= 0
+			}
+			continue
+		}
+
+		if listEnds[t.text] {
+			tableList = false
+		}
+		if conditionEnds[t.text] {
+			condition = false
+		}
+
+		switch {
+		case t.text == "using" && i+1 < len(tokens) && tokens[i+1].is(punctuation, "("):
+			// JOIN ... USING (columns)
+			var columns [][]token
+			columns, i = group(tokens, i+1)
+			if position(columns, column) >= 0 {
+				s.predicates = append(s.predicates, predicate{})
+			}
+		case tableKeywords[t.text]:
+			expectTable = true
+			tableList = t.text == "from" || t.text == "update" || t.text == "using"
+		case t.text == "where" || t.text == "on" || t.text == "having":
+			condition = true
+		case t.text == "values" && insertColumn >= 0:
+			for i+1 < len(tokens) && tokens[i+1].is(punctuation, "(") {
+				var row [][]token
+				row, i = group(tokens, i+1)
+				if insertColumn < len(row) {
+					s.predicates = append(s.predicates, predicate{values: comparable(row[insertColumn])})
+				}
+				if i+1 < len(tokens) && tokens[i+1].is(punctuation, ",") {
+					i++
+				}
+			}
+		case condition && isColumn(t.text, column):
+			if p, ok := compared(tokens, i); ok {
+				s.predicates = append(s.predicates, p)
+			}
+		}
+	}
+	return s
+}
+
+// compared returns the predicate of the column at i, false if it isn't
+// compared with = or IN
+func compared(tokens []token, i int) (predicate, bool) {
+	if i+1 < len(tokens) {
+		next := tokens[i+1]
+		switch {
+		case next.is(punctuation, "="):
+			if i+2 < len(tokens) {
+				return predicate{values: comparable(tokens[i+2 : i+3])}, true
+			}
+			return predicate{}, true
+		case next.is(identifier, "in") && i+2 < len(tokens) && tokens[i+2].is(punctuation, "("):
+			items, _ := group(tokens, i+2)
+			var p predicate
+			for _, item := range items {
+				p.values = append(p.values, comparable(item)...)
+			}
+			return p, true
+		}
+	}
+
+	// value = column, but not value <= column
+	if i >= 2 && tokens[i-1].is(punctuation, "=") {
+		before := tokens[i-2]
+		if before.kind == punctuation && strings.Contains("<>!", before.text) {
+			return predicate{}, false
+		}
+		return predicate{values: comparable([]token{before})}, true
+	}
+	return predicate{}, false
+}
+
+// comparable returns the value of item, if it's a single placeholder or
+// literal
+func comparable(item []token) []token {
+	if len(item) == 1 && (item[0].kind == literal || item[0].kind == placeholder) {
+		return item
+	}
+	return nil
+}
+
+// group splits the parenthesized list starting at i into its comma
+// separated items, it returns them and the index of the closing parenthesis
+func group(tokens []token, i int) ([][]token, int) {
+	var items [][]token
+	var item []token
+	depth := 0
+	for i++; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.is(punctuation, "("):
+			depth++
+		case t.is(punctuation, ")"):
+			if depth == 0 {
+				return append(items, item), i
+			}
+			depth--
+		case t.is(punctuation, ",") && depth == 0:
+			items = append(items, item)
+			item = nil
+			continue
+		}
+		item = append(item, t)
+	}
+	return append(items, item), i
+}
+
+// position returns the index of the item of columns naming column, -1 if none does
+func position(columns [][]token, column string) int {
+	for i, item := range columns {
+		if len(item) == 1 && item[0].kind == identifier && isColumn(item[0].text, column) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isColumn reports whether name is column, qualified or not
+func isColumn(name, column string) bool {
+	return name == column || strings.HasSuffix(name, "."+column)
+}
@@ -0,0 +1,155 @@
+// Package tenant provides a hook guarding against statements missing their
+// tenant predicate, in schemas where the rows of every tenant share tables
+// told apart by a tenant column.
+//
+// The check is a heuristic, made by scanning the query rather than parsing
+// it: a statement referencing a guarded table passes if the tenant column is
+// compared, with = or IN, in one of its WHERE, ON or HAVING conditions, or for
+// an INSERT if it's one of the columns inserted. One predicate is enough for
+// the whole statement, whatever the number of tables joined. Statements the
+// check gets wrong can be exempted with sqlhooks.SkipTenantCheck:
+//
+//	ctx := sqlhooks.SkipTenantCheck(ctx)
+//	rows, err := db.QueryContext(ctx, "SELECT count(*) FROM users")
+package tenant
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// Error is the error of a statement failing the check
+type Error struct {
+	Query  string
+	Table  string
+	Column string
+
+	// Mismatch is set when the tenant predicate compares the column with
+	// Got rather than Expected, the tenant of the context
+	Mismatch bool
+	Expected interface{}
+	Got      interface{}
+}
+
+func (e *Error) Error() string {
+	if e.Mismatch {
+		return fmt.Sprintf("tenant: %s of %s is %v, expected %v: %s", e.Column, e.Table, e.Got, e.Expected, e.Query)
+	}
+	return fmt.Sprintf("tenant: no %s predicate on %s: %s", e.Column, e.Table, e.Query)
+}
+
+type hook struct {
+	// Key is the context key of the expected tenant. When it's set and the
+	// context of a statement has a tenant, the values the tenant column is
+	// compared with, as bound to ? or $1 placeholders or written as
+	// literals, must be the tenant, as formatted by fmt's %v.
+	Key interface{}
+
+	// Warn, when set, is given the errors of the statements failing the
+	// check, which run anyway, rather than rejecting them
+	Warn func(ctx *sqlhooks.Context, err *Error)
+
+	column string
+	tables map[string]bool
+}
+
+// New returns a hook checking that the statements on tables have a predicate
+// on column, on any table when none is given. Table names are matched case
+// insensitively, with or without their schema.
+func New(column string, tables ...string) *hook {
+	h := &hook{column: strings.ToLower(column), tables: make(map[string]bool)}
+	for _, table := range tables {
+		h.tables[strings.ToLower(table)] = true
+	}
+	return h
+}
+
+func (h *hook) guarded(table string) bool {
+	if len(h.tables) == 0 || h.tables[table] {
+		return true
+	}
+	if dot := strings.LastIndexByte(table, '.'); dot >= 0 {
+		return h.tables[table[dot+1:]]
+	}
+	return false
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	if sqlhooks.TenantCheckSkipped(ctx.Ctx) {
+		return nil
+	}
+	err := h.check(ctx)
+	if err == nil {
+		return nil
+	}
+	if h.Warn != nil {
+		h.Warn(ctx, err)
+		return nil
+	}
+	return err
+}
+
+// check returns the Error of ctx's statement, nil if it passes
+func (h *hook) check(ctx *sqlhooks.Context) *Error {
+	s := scan(ctx.Query, h.column)
+
+	table := ""
+	for _, t := range s.tables {
+		if h.guarded(t) {
+			table = t
+			break
+		}
+	}
+	if table == "" {
+		return nil
+	}
+	if len(s.predicates) == 0 && !s.inserted {
+		return &Error{Query: ctx.Query, Table: table, Column: h.column}
+	}
+
+	if h.Key == nil || ctx.Ctx == nil {
+		return nil
+	}
+	expected := ctx.Ctx.Value(h.Key)
+	if expected == nil || collapsed(ctx.Args) {
+		return nil
+	}
+	for _, p := range s.predicates {
+		for _, got := range p.bound(ctx.Args) {
+			if b, ok := got.([]byte); ok {
+				got = string(b)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(expected) {
+				return &Error{Query: ctx.Query, Table: table, Column: h.column, Mismatch: true, Expected: expected, Got: got}
+			}
+		}
+	}
+	return nil
+}
+
+// collapsed reports whether WithCollapseInLists left arguments out of args,
+// they can't be matched to their placeholders then
+func collapsed(args []interface{}) bool {
+	for _, arg := range args {
+		if _, ok := arg.(sqlhooks.CollapsedArgs); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error  { return ctx.Error }
+
+// Prepared statements are checked when executed, once their arguments are known
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error   { return nil }
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error    { return ctx.Error }
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error { return h.before(ctx) }
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error  { return ctx.Error }
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error  { return h.before(ctx) }
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error   { return ctx.Error }
@@ -0,0 +1,168 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tenantKey struct{}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+func (fakeConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func init() {
+	sql.Register("tenant-fakedb", fakeDriver{})
+}
+
+func newContext(ctx context.Context, query string, args ...interface{}) *sqlhooks.Context {
+	c := sqlhooks.NewContext()
+	c.Ctx = ctx
+	c.Query = query
+	c.Args = args
+	return c
+}
+
+func TestPredicates(t *testing.T) {
+	hook := New("tenant_id", "users", "orders")
+
+	for _, query := range []string{
+		"SELECT * FROM users WHERE tenant_id = ?",
+		"SELECT * FROM users u WHERE u.id = ? AND u.tenant_id = $2",
+		`SELECT * FROM "Users" WHERE "tenant_id" IN (?, ?)`,
+		"SELECT * FROM public.users WHERE ? = tenant_id",
+		"SELECT * FROM accounts a JOIN orders o ON o.tenant_id = a.tenant_id WHERE a.id = ?",
+		"SELECT * FROM accounts JOIN orders USING (tenant_id, account_id)",
+		"UPDATE users SET name = ? WHERE tenant_id = ? AND id = ?",
+		"DELETE FROM orders WHERE tenant_id = 42",
+		"INSERT INTO users (id, tenant_id) VALUES (?, ?)",
+		"INSERT INTO orders (id) SELECT id FROM carts WHERE tenant_id = ?",
+		"SELECT * FROM accounts WHERE id = ?",
+		"SELECT 1",
+		"BEGIN",
+	} {
+		assert.NoError(t, hook.BeforeQuery(newContext(context.Background(), query)), query)
+	}
+
+	for _, query := range []string{
+		"SELECT * FROM users",
+		"SELECT * FROM users WHERE id = ?",
+		"SELECT tenant_id FROM users ORDER BY tenant_id",
+		"SELECT * FROM users WHERE tenant_id <> ?",
+		"SELECT * FROM users WHERE tenant_id >= ?",
+		"SELECT * FROM users WHERE name = 'tenant_id = 1' -- tenant_id = ?",
+		"SELECT * FROM accounts, users WHERE accounts.id = users.id",
+		"SELECT * FROM accounts a LEFT JOIN orders o ON o.account_id = a.id",
+		"UPDATE users SET tenant_id = ? WHERE id = ?",
+		"DELETE FROM orders",
+		"INSERT INTO users (id, name) VALUES (?, ?)",
+		"INSERT INTO users VALUES (?, ?)",
+	} {
+		err := hook.BeforeQuery(newContext(context.Background(), query))
+		require.Error(t, err, query)
+		tenantErr, ok := err.(*Error)
+		require.True(t, ok, query)
+		assert.False(t, tenantErr.Mismatch, query)
+		assert.Equal(t, "tenant_id", tenantErr.Column)
+	}
+
+	err := hook.BeforeExec(newContext(context.Background(), "DELETE FROM orders"))
+	assert.EqualError(t, err, "tenant: no tenant_id predicate on orders: DELETE FROM orders")
+}
+
+func TestAllTables(t *testing.T) {
+	hook := New("tenant_id")
+	assert.Error(t, hook.BeforeQuery(newContext(context.Background(), "SELECT * FROM anything")))
+	assert.NoError(t, hook.BeforeQuery(newContext(context.Background(), "SELECT 1")))
+}
+
+func TestTenantArgs(t *testing.T) {
+	hook := New("tenant_id", "users")
+	hook.Key = tenantKey{}
+	ctx := context.WithValue(context.Background(), tenantKey{}, 7)
+
+	for _, c := range []struct {
+		query string
+		args  []interface{}
+		got   interface{}
+	}{
+		{"SELECT * FROM users WHERE id = ? AND tenant_id = ?", []interface{}{int64(1), int64(7)}, nil},
+		{"SELECT * FROM users WHERE id = ? AND tenant_id = ?", []interface{}{int64(7), int64(8)}, int64(8)},
+		{"SELECT * FROM users WHERE tenant_id = $2 AND id = $1", []interface{}{int64(7), int64(8)}, int64(8)},
+		{"SELECT * FROM users WHERE tenant_id = @p1", []interface{}{[]byte("7")}, nil},
+		{"SELECT * FROM users WHERE tenant_id IN (?, ?)", []interface{}{int64(7), int64(9)}, int64(9)},
+		{"SELECT * FROM users WHERE tenant_id = 8", nil, "8"},
+		{"SELECT * FROM users WHERE tenant_id = '7'", nil, nil},
+		{"INSERT INTO users (tenant_id, id) VALUES (?, ?), (?, ?)", []interface{}{int64(7), 1, int64(6), 2}, int64(6)},
+		{"SELECT * FROM users WHERE tenant_id = :tenant", []interface{}{int64(8)}, nil},
+		{"SELECT * FROM users WHERE tenant_id = ?", nil, nil},
+		{"SELECT * FROM users WHERE tenant_id IN (?...)", []interface{}{int64(8), sqlhooks.CollapsedArgs(2)}, nil},
+	} {
+		err := hook.BeforeStmtQuery(newContext(ctx, c.query, c.args...))
+		if c.got == nil {
+			assert.NoError(t, err, c.query)
+			continue
+		}
+		require.Error(t, err, c.query)
+		tenantErr := err.(*Error)
+		assert.True(t, tenantErr.Mismatch, c.query)
+		assert.Equal(t, 7, tenantErr.Expected, c.query)
+		assert.Equal(t, c.got, tenantErr.Got, c.query)
+	}
+
+	err := hook.BeforeStmtExec(newContext(ctx, "UPDATE users SET x = 1 WHERE tenant_id = ?", int64(3)))
+	assert.EqualError(t, err, "tenant: tenant_id of users is 3, expected 7: UPDATE users SET x = 1 WHERE tenant_id = ?")
+
+	// Contexts without a tenant only need the predicate
+	assert.NoError(t, hook.BeforeQuery(newContext(context.Background(), "SELECT * FROM users WHERE tenant_id = ?", int64(3))))
+}
+
+func TestWarn(t *testing.T) {
+	var warned []string
+	hook := New("tenant_id", "users")
+	hook.Warn = func(ctx *sqlhooks.Context, err *Error) {
+		warned = append(warned, err.Table)
+	}
+
+	assert.NoError(t, hook.BeforeQuery(newContext(context.Background(), "SELECT * FROM users")))
+	assert.NoError(t, hook.BeforeQuery(newContext(context.Background(), "SELECT * FROM users WHERE tenant_id = ?")))
+	assert.Equal(t, []string{"users"}, warned)
+}
+
+func TestSkipTenantCheck(t *testing.T) {
+	hook := New("tenant_id", "users")
+	ctx := sqlhooks.SkipTenantCheck(context.Background())
+	assert.NoError(t, hook.BeforeQuery(newContext(ctx, "SELECT count(*) FROM users")))
+	assert.NoError(t, hook.BeforeExec(newContext(ctx, "DELETE FROM users")))
+}
+
+func TestChecksThroughDriver(t *testing.T) {
+	db, err := sqlhooks.Open("tenant-fakedb", "", New("tenant_id", "users"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM users")
+	assert.IsType(t, &Error{}, err)
+
+	_, err = db.Exec("DELETE FROM users WHERE tenant_id = ?", 1)
+	assert.NoError(t, err)
+
+	_, err = db.ExecContext(sqlhooks.SkipTenantCheck(context.Background()), "DELETE FROM users")
+	assert.NoError(t, err)
+}
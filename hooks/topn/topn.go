@@ -0,0 +1,247 @@
+// Package topn provides a hook keeping the slowest distinct queries of the
+// last minutes in memory, for a quick health check without any external
+// tooling:
+//
+//	slowest := topn.New(100)
+//	db, err := sqlhooks.Open("postgres", dsn, slowest)
+//	...
+//	for _, stat := range slowest.TopN(10) {
+//		log.Printf("%s: max %s, mean %s over %d", stat.Fingerprint, stat.Max, stat.Mean, stat.Count)
+//	}
+//
+// The hook is an http.Handler as well, serving the same report as JSON, to
+// mount on a debug server:
+//
+//	http.Handle("/debug/sql/slowest", slowest)
+package topn
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// QueryStat is the latency of the statements of a fingerprint over the window
+type QueryStat struct {
+	// Fingerprint is the statement's, as returned by sqlhooks.Fingerprint
+	Fingerprint string `json:"fingerprint"`
+
+	Count uint64        `json:"count"`
+	Max   time.Duration `json:"max_ns"`
+	Mean  time.Duration `json:"mean_ns"`
+}
+
+// entry is the latency of a fingerprint within a bucket, index is its
+// position in the bucket's heap
+type entry struct {
+	fingerprint string
+	count       uint64
+	total       time.Duration
+	max         time.Duration
+	index       int
+}
+
+// slowest is a min-heap of entries by max duration, the fastest tracked
+// fingerprint is the first to go when a slower one comes
+type slowest []*entry
+
+func (s slowest) Len() int           { return len(s) }
+func (s slowest) Less(i, j int) bool { return s[i].max < s[j].max }
+func (s slowest) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	s[i].index, s[j].index = i, j
+}
+
+func (s *slowest) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*s)
+	*s = append(*s, e)
+}
+
+func (s *slowest) Pop() interface{} {
+	old := *s
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*s = old[:len(old)-1]
+	return e
+}
+
+// bucket holds the fingerprints of a slice of the window, epoch is the
+// number of the slice since the Unix epoch
+type bucket struct {
+	epoch   int64
+	entries map[string]*entry
+	heap    slowest
+}
+
+type hook struct {
+	// Window is the duration the report covers, split in Buckets, rolled
+	// one at a time. They must be set before the hook is used, the defaults
+	// are 5 minutes and 5 buckets.
+	Window  time.Duration
+	Buckets int
+
+	// tracked is the number of fingerprints a bucket keeps
+	tracked int
+
+	mu   sync.Mutex
+	ring []bucket
+
+	now func() time.Time
+}
+
+// New returns a hook tracking the latency of up to tracked fingerprints per
+// bucket, the slowest ones: once a bucket has as many, a new fingerprint
+// replaces the one whose slowest statement is the fastest, if it's slower,
+// so memory is bounded whatever the number of distinct statements.
+//
+// The duration of a statement is the one of its driver call, as in
+// Context.Duration, failed statements are tracked as well, the ones skipped
+// by the driver and the synthetic ones aren't.
+func New(tracked int) *hook {
+	if tracked < 1 {
+		tracked = 1
+	}
+	return &hook{
+		Window:  5 * time.Minute,
+		Buckets: 5,
+		tracked: tracked,
+		now:     time.Now,
+	}
+}
+
+// TopN returns the n slowest fingerprints over the window, by the duration
+// of their slowest statement, merging the buckets of the window
+func (h *hook) TopN(n int) []QueryStat {
+	h.mu.Lock()
+	merged := make(map[string]*entry)
+	epoch := h.epoch(h.now())
+	for i := range h.ring {
+		b := &h.ring[i]
+		if b.epoch > epoch || b.epoch <= epoch-int64(len(h.ring)) {
+			continue
+		}
+		for fingerprint, e := range b.entries {
+			m, ok := merged[fingerprint]
+			if !ok {
+				m = &entry{fingerprint: fingerprint}
+				merged[fingerprint] = m
+			}
+			m.count += e.count
+			m.total += e.total
+			if e.max > m.max {
+				m.max = e.max
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	stats := make([]QueryStat, 0, len(merged))
+	for _, e := range merged {
+		stats = append(stats, QueryStat{
+			Fingerprint: e.fingerprint,
+			Count:       e.count,
+			Max:         e.max,
+			Mean:        e.total / time.Duration(e.count),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Max != stats[j].Max {
+			return stats[i].Max > stats[j].Max
+		}
+		return stats[i].Fingerprint < stats[j].Fingerprint
+	})
+	if n >= 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// ServeHTTP writes the TopN report as JSON, of the number of fingerprints
+// given by the n parameter, 10 by default
+func (h *hook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "invalid n: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.TopN(n))
+}
+
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	if ctx.Synthetic {
+		return ctx.Error
+	}
+	fingerprint := sqlhooks.Fingerprint(ctx.Query)
+	took := ctx.Duration
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b := h.bucket(h.now())
+	if e, ok := b.entries[fingerprint]; ok {
+		e.count++
+		e.total += took
+		if took > e.max {
+			e.max = took
+			heap.Fix(&b.heap, e.index)
+		}
+		return ctx.Error
+	}
+
+	if len(b.heap) >= h.tracked {
+		if b.heap[0].max >= took {
+			return ctx.Error
+		}
+		delete(b.entries, heap.Pop(&b.heap).(*entry).fingerprint)
+	}
+	e := &entry{fingerprint: fingerprint, count: 1, total: took, max: took}
+	b.entries[fingerprint] = e
+	heap.Push(&b.heap, e)
+	return ctx.Error
+}
+
+// epoch returns the number of the slice of the window of now
+func (h *hook) epoch(now time.Time) int64 {
+	if h.ring == nil {
+		h.ring = make([]bucket, h.Buckets)
+	}
+	width := int64(h.Window) / int64(len(h.ring))
+	if width <= 0 {
+		width = 1
+	}
+	return now.UnixNano() / width
+}
+
+// bucket returns the bucket of now, emptied if it was last used for an
+// older slice of the window
+func (h *hook) bucket(now time.Time) *bucket {
+	epoch := h.epoch(now)
+	b := &h.ring[epoch%int64(len(h.ring))]
+	if b.epoch != epoch || b.entries == nil {
+		*b = bucket{epoch: epoch, entries: make(map[string]*entry)}
+	}
+	return b
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error   { return nil }
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error    { return ctx.Error }
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error { return nil }
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error  { return h.after(ctx) }
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error  { return nil }
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error   { return h.after(ctx) }
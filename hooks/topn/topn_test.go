@@ -0,0 +1,136 @@
+package topn
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a fake clock, moved by hand
+type clock struct {
+	now time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Unix(1000, 0)}
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func (h *hook) withClock(c *clock) *hook {
+	h.now = func() time.Time { return c.now }
+	return h
+}
+
+// exec runs the Exec After hook of h for a statement taking took
+func exec(h *hook, query string, took time.Duration) {
+	ctx := sqlhooks.NewContext()
+	ctx.Query = query
+	ctx.Duration = took
+	h.AfterExec(ctx)
+}
+
+func TestTopN(t *testing.T) {
+	c := newClock()
+	h := New(100).withClock(c)
+
+	exec(h, "SELECT * FROM users WHERE id = 1", 10*time.Millisecond)
+	exec(h, "SELECT * FROM users WHERE id = 2", 30*time.Millisecond)
+	exec(h, "UPDATE users SET name = 'bob'", 5*time.Millisecond)
+	c.advance(time.Minute)
+	exec(h, "SELECT * FROM users WHERE id = 3", 20*time.Millisecond)
+	exec(h, "DELETE FROM sessions", 100*time.Millisecond)
+
+	assert.Equal(t, []QueryStat{
+		{Fingerprint: "DELETE FROM sessions", Count: 1, Max: 100 * time.Millisecond, Mean: 100 * time.Millisecond},
+		{Fingerprint: "SELECT * FROM users WHERE id = ?", Count: 3, Max: 30 * time.Millisecond, Mean: 20 * time.Millisecond},
+		{Fingerprint: "UPDATE users SET name = ?", Count: 1, Max: 5 * time.Millisecond, Mean: 5 * time.Millisecond},
+	}, h.TopN(10))
+	assert.Len(t, h.TopN(2), 2)
+	assert.Empty(t, h.TopN(0))
+
+	// Synthetic statements aren't tracked
+	ctx := sqlhooks.NewContext()
+	ctx.Query = "SELECT pg_sleep(1)"
+	ctx.Duration = time.Second
+	ctx.Synthetic = true
+	assert.NoError(t, h.AfterStmtQuery(ctx))
+	assert.Len(t, h.TopN(10), 3)
+}
+
+func TestTopNWindow(t *testing.T) {
+	c := newClock()
+	h := New(100).withClock(c)
+
+	exec(h, "SELECT * FROM a", 50*time.Millisecond)
+	c.advance(2 * time.Minute)
+	exec(h, "SELECT * FROM b", 10*time.Millisecond)
+	c.advance(2 * time.Minute)
+	exec(h, "SELECT * FROM a", 20*time.Millisecond)
+	assert.Equal(t, []QueryStat{
+		{Fingerprint: "SELECT * FROM a", Count: 2, Max: 50 * time.Millisecond, Mean: 35 * time.Millisecond},
+		{Fingerprint: "SELECT * FROM b", Count: 1, Max: 10 * time.Millisecond, Mean: 10 * time.Millisecond},
+	}, h.TopN(10))
+
+	// The first bucket leaves the window, then its slot is reused
+	c.advance(time.Minute)
+	assert.Equal(t, []QueryStat{
+		{Fingerprint: "SELECT * FROM a", Count: 1, Max: 20 * time.Millisecond, Mean: 20 * time.Millisecond},
+		{Fingerprint: "SELECT * FROM b", Count: 1, Max: 10 * time.Millisecond, Mean: 10 * time.Millisecond},
+	}, h.TopN(10))
+	exec(h, "SELECT * FROM c", time.Millisecond)
+	assert.Len(t, h.TopN(10), 3)
+
+	c.advance(time.Hour)
+	assert.Empty(t, h.TopN(10))
+}
+
+func TestTopNBoundedMemory(t *testing.T) {
+	h := New(2).withClock(newClock())
+
+	exec(h, "SELECT * FROM a", 10*time.Millisecond)
+	exec(h, "SELECT * FROM b", 30*time.Millisecond)
+	// Faster than every tracked one, it's dropped
+	exec(h, "SELECT * FROM c", 5*time.Millisecond)
+	// Slower than a, which goes
+	exec(h, "SELECT * FROM d", 20*time.Millisecond)
+	// b isn't the fastest anymore once it's slower
+	exec(h, "SELECT * FROM d", 40*time.Millisecond)
+	exec(h, "SELECT * FROM e", 35*time.Millisecond)
+
+	var fingerprints []string
+	for _, stat := range h.TopN(10) {
+		fingerprints = append(fingerprints, stat.Fingerprint)
+	}
+	assert.Equal(t, []string{"SELECT * FROM d", "SELECT * FROM e"}, fingerprints)
+
+	b := h.bucket(h.now())
+	assert.Len(t, b.entries, 2)
+	assert.Len(t, b.heap, 2)
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := New(100).withClock(newClock())
+	exec(h, "SELECT * FROM a", 2*time.Millisecond)
+	exec(h, "SELECT * FROM b", time.Millisecond)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/sql/slowest?n=1", nil))
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[{"fingerprint":"SELECT * FROM a","count":1,"max_ns":2000000,"mean_ns":2000000}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/sql/slowest", nil))
+	assert.Contains(t, w.Body.String(), "SELECT * FROM b")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/sql/slowest?n=ten", nil))
+	assert.Equal(t, 400, w.Code)
+}
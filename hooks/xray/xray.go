@@ -0,0 +1,137 @@
+// Package xray provides hooks recording statements as AWS X-Ray subsegments.
+//
+// The package doesn't depend on the X-Ray SDK, subsegments are started by a
+// Tracer, which with the SDK can be written as:
+//
+//	xray.TracerFunc(func(ctx context.Context, name string, sql xray.SQL) xray.Subsegment {
+//		if awsxray.GetSegment(ctx) == nil {
+//			return nil
+//		}
+//		_, seg := awsxray.BeginSubsegment(ctx, name)
+//		seg.Namespace = "remote"
+//		seg.GetSQL().SanitizedQuery = sql.SanitizedQuery
+//		seg.GetSQL().DatabaseType = sql.DatabaseType
+//		seg.GetSQL().URL = sql.URL
+//		return seg
+//	})
+package xray
+
+import (
+	"context"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// SQL holds the subsegment SQL metadata
+type SQL struct {
+	SanitizedQuery string
+	DatabaseType   string
+	URL            string
+}
+
+// Subsegment is a started X-Ray subsegment, *xray.Segment implements it
+type Subsegment interface {
+	Close(err error)
+}
+
+// Tracer starts a subsegment of the segment carried by ctx, it returns nil
+// when ctx has no segment
+type Tracer interface {
+	BeginSubsegment(ctx context.Context, name string, sql SQL) Subsegment
+}
+
+// TracerFunc is a function implementing Tracer
+type TracerFunc func(ctx context.Context, name string, sql SQL) Subsegment
+
+func (fn TracerFunc) BeginSubsegment(ctx context.Context, name string, sql SQL) Subsegment {
+	return fn(ctx, name, sql)
+}
+
+type hook struct {
+	// Name is the subsegment name, typically database@host
+	Name string
+
+	// DatabaseType and URL are reported in the subsegments SQL metadata,
+	// URL is stored without its password
+	DatabaseType string
+	URL          string
+
+	tracer Tracer
+}
+
+// New returns hooks starting a subsegment of tracer for every statement,
+// dsn is reported without its password. databaseType can be a driver name,
+// reported as the Product sqlhooks.System maps it to.
+func New(tracer Tracer, databaseType, dsn string) *hook {
+	return &hook{
+		Name:         databaseType,
+		DatabaseType: sqlhooks.System(databaseType).Product,
+		URL:          sqlhooks.SanitizeDSN(dsn),
+		tracer:       tracer,
+	}
+}
+
+func (h *hook) before(ctx *sqlhooks.Context) error {
+	sub := h.tracer.BeginSubsegment(ctx.Ctx, h.Name, SQL{
+		SanitizedQuery: sqlhooks.Fingerprint(ctx.Query),
+		DatabaseType:   h.DatabaseType,
+		URL:            h.URL,
+	})
+	if sub != nil {
+		ctx.Set("xray", sub)
+	}
+	return nil
+}
+
+func (h *hook) after(ctx *sqlhooks.Context) error {
+	if sub, ok := ctx.Get("xray").(Subsegment); ok {
+		err := ctx.Error
+		// No rows isn't a failure
+		if sqlhooks.IsNotFound(err) {
+			err = nil
+		}
+		sub.Close(err)
+		ctx.Set("xray", nil)
+	}
+	return ctx.Error
+}
+
+func (h *hook) BeforeQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtQuery(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtQuery(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforeStmtExec(ctx *sqlhooks.Context) error {
+	return h.before(ctx)
+}
+
+func (h *hook) AfterStmtExec(ctx *sqlhooks.Context) error {
+	return h.after(ctx)
+}
+
+func (h *hook) BeforePrepare(ctx *sqlhooks.Context) error {
+	return nil
+}
+
+func (h *hook) AfterPrepare(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
@@ -0,0 +1,280 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// WithHookTimeout bounds the time the Before and After hooks of an
+// operation, and the AfterRowsClose ones, take to timeout, so that a hook
+// blocking, as a slow logger or a stuck channel send, doesn't stall the
+// operation. A hook not returning in time is abandoned: the operation goes on,
+// as if a Before hook returned nil, the operation not being aborted then, or
+// an After hook returned the error of the operation, and EventHookTimeout is
+// reported. The abandoned hook keeps running on its own goroutine, whatever
+// it returns is discarded.
+//
+// The hooks run on their own goroutine, on a copy of the Context whose Ctx
+// is done once timeout elapsed, for the hooks honoring it to return early.
+// The copy replaces the Context once they return in time, the changes the
+// hooks make to it, and to its Conn data, are kept then, but it's not the
+// Context the other hooks get: hooks mapping their Before and After by
+// Context don't work with WithHookTimeout. An abandoned hook has copies of
+// the Conn data and of the Columns of its own, the changes it makes are
+// lost. The explain, savepoint, summary and rows wrapping hooks run
+// unbounded, on the query path.
+func WithHookTimeout(timeout time.Duration) Option {
+	return func(d *Driver) {
+		d.hookTimeout = timeout
+	}
+}
+
+// bounded runs hooks with the timeout of WithHookTimeout
+type bounded struct {
+	hooks   HookType
+	timeout time.Duration
+	diag    *diagnostics
+}
+
+// boundHooks returns hooks bounded by the WithHookTimeout timeout, if any
+func (c *conn) boundHooks(hooks HookType) HookType {
+	return bound(hooks, c.hookTimeout, c.diag)
+}
+
+// bound returns hooks bounded by timeout, unless it's 0
+func bound(hooks HookType, timeout time.Duration, diag *diagnostics) HookType {
+	if timeout <= 0 || hooks == nil {
+		return hooks
+	}
+	return &bounded{hooks: hooks, timeout: timeout, diag: diag}
+}
+
+func (b *bounded) implements(is func(HookType) bool) bool {
+	return implementsHook(b.hooks, is)
+}
+
+// before runs the Before function of the hooks, nil on timeout
+func (b *bounded) before(ctx *Context, funcs hookFuncs, name string) error {
+	before, _ := funcs(b.hooks)
+	if before == nil {
+		return nil
+	}
+	returned, err := b.run(ctx, before, name)
+	if !returned {
+		return nil
+	}
+	return err
+}
+
+// after runs the After function of the hooks, ctx.Error on timeout
+func (b *bounded) after(ctx *Context, funcs hookFuncs, name string) error {
+	_, after := funcs(b.hooks)
+	if after == nil {
+		return ctx.Error
+	}
+	returned, err := b.run(ctx, after, name)
+	if !returned {
+		return ctx.Error
+	}
+	return err
+}
+
+// run runs hook on a copy of ctx, reporting whether it returned within the
+// timeout, the copy replaces ctx then
+func (b *bounded) run(ctx *Context, hook func(*Context) error, name string) (bool, error) {
+	stdCtx := ctx.Ctx
+	if stdCtx == nil {
+		stdCtx = context.Background()
+	}
+	// The hooks may derive contexts from the one they get, as adapters do,
+	// which must not be canceled once they returned: it's only canceled on
+	// timeout, or when the hooks didn't keep it
+	hookCtx, cancel := context.WithCancel(stdCtx)
+	timer := time.AfterFunc(b.timeout, cancel)
+
+	// The operation goes on with the connection and its rows while an
+	// abandoned hook runs: it gets copies of what it could share with them
+	c := ctx.snapshot()
+	c.Ctx = hookCtx
+	if ctx.conn != nil {
+		c.conn = ctx.conn.copy()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- hook(c) }()
+
+	select {
+	case err := <-done:
+		timer.Stop()
+		if c.Ctx == hookCtx {
+			cancel()
+			c.Ctx = ctx.Ctx
+		}
+		if ctx.conn != nil {
+			*ctx.conn = *c.conn
+			c.conn = ctx.conn
+		}
+		c.rows = ctx.rows
+		*ctx = *c
+		return true, err
+	case <-hookCtx.Done():
+		if stdCtx.Err() != nil {
+			// The operation's context is done, the hooks may return any time
+			// now, but the operation fails anyway
+			b.diag.report(EventHookTimeout, fmt.Errorf("sqlhooks: %s hook abandoned, %v: %s", name, stdCtx.Err(), ctx.Query))
+		} else {
+			b.diag.report(EventHookTimeout, fmt.Errorf("sqlhooks: %s hook took more than %s: %s", name, b.timeout, ctx.Query))
+		}
+		return false, nil
+	}
+}
+
+func (b *bounded) BeforeBegin(ctx *Context) error {
+	return b.before(ctx, beginFuncs, "BeforeBegin")
+}
+
+func (b *bounded) AfterBegin(ctx *Context) error {
+	return b.after(ctx, beginFuncs, "AfterBegin")
+}
+
+func (b *bounded) BeforeCommit(ctx *Context) error {
+	return b.before(ctx, commitFuncs, "BeforeCommit")
+}
+
+func (b *bounded) AfterCommit(ctx *Context) error {
+	return b.after(ctx, commitFuncs, "AfterCommit")
+}
+
+func (b *bounded) BeforeRollback(ctx *Context) error {
+	return b.before(ctx, rollbackFuncs, "BeforeRollback")
+}
+
+func (b *bounded) AfterRollback(ctx *Context) error {
+	return b.after(ctx, rollbackFuncs, "AfterRollback")
+}
+
+func (b *bounded) BeforePrepare(ctx *Context) error {
+	return b.before(ctx, prepareFuncs, "BeforePrepare")
+}
+
+func (b *bounded) AfterPrepare(ctx *Context) error {
+	return b.after(ctx, prepareFuncs, "AfterPrepare")
+}
+
+func (b *bounded) BeforeStmtQuery(ctx *Context) error {
+	return b.before(ctx, stmtQueryFuncs, "BeforeStmtQuery")
+}
+
+func (b *bounded) AfterStmtQuery(ctx *Context) error {
+	return b.after(ctx, stmtQueryFuncs, "AfterStmtQuery")
+}
+
+func (b *bounded) BeforeStmtExec(ctx *Context) error {
+	return b.before(ctx, stmtExecFuncs, "BeforeStmtExec")
+}
+
+func (b *bounded) AfterStmtExec(ctx *Context) error {
+	return b.after(ctx, stmtExecFuncs, "AfterStmtExec")
+}
+
+func (b *bounded) BeforeQuery(ctx *Context) error {
+	return b.before(ctx, queryFuncs, "BeforeQuery")
+}
+
+func (b *bounded) AfterQuery(ctx *Context) error {
+	return b.after(ctx, queryFuncs, "AfterQuery")
+}
+
+func (b *bounded) BeforeExec(ctx *Context) error {
+	return b.before(ctx, execFuncs, "BeforeExec")
+}
+
+func (b *bounded) AfterExec(ctx *Context) error {
+	return b.after(ctx, execFuncs, "AfterExec")
+}
+
+func (b *bounded) AfterRowsClose(ctx *Context) error {
+	return b.after(ctx, rowsCloseFuncs, "AfterRowsClose")
+}
+
+func (b *bounded) ExplainQuery(ctx *Context) (string, bool) {
+	if t, ok := b.hooks.(Explainer); ok {
+		return t.ExplainQuery(ctx)
+	}
+	return "", false
+}
+
+func (b *bounded) AfterExplain(ctx *Context, plan string, err error) {
+	if t, ok := b.hooks.(Explainer); ok {
+		t.AfterExplain(ctx, plan, err)
+	}
+}
+
+func (b *bounded) WrapRows(ctx *Context, rows driver.Rows) driver.Rows {
+	if t, ok := b.hooks.(RowsWrapper); ok {
+		return t.WrapRows(ctx, rows)
+	}
+	return rows
+}
+
+func (b *bounded) Savepoint(txID uint64, name string) {
+	if t, ok := b.hooks.(Savepointer); ok {
+		t.Savepoint(txID, name)
+	}
+}
+
+func (b *bounded) ReleaseSavepoint(txID uint64, name string) {
+	if t, ok := b.hooks.(Savepointer); ok {
+		t.ReleaseSavepoint(txID, name)
+	}
+}
+
+func (b *bounded) RollbackToSavepoint(txID uint64, name string) {
+	if t, ok := b.hooks.(Savepointer); ok {
+		t.RollbackToSavepoint(txID, name)
+	}
+}
+
+func (b *bounded) AfterTx(summary TxSummary) {
+	if t, ok := b.hooks.(TxSummaryHook); ok {
+		t.AfterTx(summary)
+	}
+}
+
+func (b *bounded) AfterBatch(summary BatchSummary) {
+	if t, ok := b.hooks.(BatchSummaryHook); ok {
+		t.AfterBatch(summary)
+	}
+}
+
+func (b *bounded) AfterConnClose(summary ConnSummary) {
+	if t, ok := b.hooks.(ConnSummaryHook); ok {
+		t.AfterConnClose(summary)
+	}
+}
+
+func (b *bounded) CopyBegin(ctx *Context) {
+	if t, ok := b.hooks.(CopyHook); ok {
+		t.CopyBegin(ctx)
+	}
+}
+
+func (b *bounded) CopyDone(summary CopySummary) {
+	if t, ok := b.hooks.(CopyHook); ok {
+		t.CopyDone(summary)
+	}
+}
+
+func (b *bounded) ResultError(query string, method string, err error) {
+	if t, ok := b.hooks.(ResultErrorHook); ok {
+		t.ResultError(query, method, err)
+	}
+}
+
+func (b *bounded) StillRunning(ctx *Context, elapsed time.Duration) {
+	if t, ok := b.hooks.(StillRunner); ok {
+		t.StillRunning(ctx, elapsed)
+	}
+}
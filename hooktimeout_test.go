@@ -0,0 +1,168 @@
+package sqlhooks
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hookTimeout = 20 * time.Millisecond
+
+func TestHookTimeoutBefore(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	errAbort := errors.New("abort")
+
+	var ran []string
+	hooks := &FuncHooks{Exec: Funcs{
+		Before: func(ctx *Context) error {
+			// Hangs, then aborts the operation once it's too late
+			<-release
+			return errAbort
+		},
+		After: func(ctx *Context) error {
+			ran = append(ran, ctx.Query)
+			return ctx.Error
+		},
+	}}
+	events := &internalEvents{}
+	db, err := Open(drivertest.Name, "context;TestHookTimeoutBefore", hooks, WithHookTimeout(hookTimeout), WithInternalLogger(events.log))
+	require.NoError(t, err)
+	defer db.Close()
+
+	start := time.Now()
+	_, err = db.Exec("INSERT INTO t VALUES (1)")
+	assert.NoError(t, err, "the abandoned Before hook doesn't abort")
+	assert.WithinDuration(t, start.Add(hookTimeout), time.Now(), time.Second)
+	assert.Equal(t, []string{"INSERT INTO t VALUES (1)"}, ran)
+
+	require.Equal(t, []string{EventHookTimeout}, events.events)
+	assert.EqualError(t, events.errs[0], "sqlhooks: BeforeExec hook took more than 20ms: INSERT INTO t VALUES (1)")
+	assert.Equal(t, uint64(1), driverStats(t, db).HookTimeouts)
+}
+
+func TestHookTimeoutAfter(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	hang := func(ctx *Context) error {
+		<-release
+		return nil
+	}
+	hooks := &FuncHooks{Exec: Funcs{After: hang}, Query: Funcs{After: hang}}
+	events := &internalEvents{}
+	db, err := Open(drivertest.Name, "context;TestHookTimeoutAfter", hooks, WithHookTimeout(hookTimeout), WithInternalLogger(events.log))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO t VALUES (1)")
+	assert.NoError(t, err)
+	_, err = db.Exec("FAIL deadlock|INSERT INTO t VALUES (1)")
+	assert.Error(t, err, "the error of the operation is returned")
+
+	rows, err := db.Query("ROWS 2|SELECT n FROM t")
+	require.NoError(t, err)
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	require.NoError(t, rows.Close())
+	assert.Equal(t, 2, n)
+
+	assert.Equal(t, []string{EventHookTimeout, EventHookTimeout, EventHookTimeout}, events.events)
+}
+
+func TestHookTimeoutCancelsContext(t *testing.T) {
+	done := make(chan error, 1)
+	hooks := &FuncHooks{Exec: Funcs{Before: func(ctx *Context) error {
+		<-ctx.Ctx.Done()
+		done <- ctx.Ctx.Err()
+		return nil
+	}}}
+	db, err := Open(drivertest.Name, "context;TestHookTimeoutCancelsContext", hooks, WithHookTimeout(hookTimeout))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO t VALUES (1)")
+	assert.NoError(t, err)
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("the hook's context wasn't canceled")
+	}
+}
+
+func TestHookTimeoutInTime(t *testing.T) {
+	type key struct{}
+	var got []interface{}
+	hooks := &FuncHooks{Exec: Funcs{
+		Before: func(ctx *Context) error {
+			ctx.Set("key", "value")
+			ctx.Ctx = context.WithValue(ctx.Ctx, key{}, "derived")
+			return nil
+		},
+		After: func(ctx *Context) error {
+			got = append(got, ctx.Get("key"), ctx.Ctx.Value(key{}), ctx.Ctx.Err())
+			return ctx.Error
+		},
+	}, Query: Funcs{Before: func(ctx *Context) error {
+		return errors.New("rejected")
+	}}}
+	events := &internalEvents{}
+	db, err := Open(drivertest.Name, "context;TestHookTimeoutInTime", hooks, WithHookTimeout(time.Second), WithInternalLogger(events.log))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO t VALUES (1)")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"value", "derived", nil}, got, "the changes of the hooks are kept")
+
+	_, err = db.Query("SELECT 1")
+	assert.EqualError(t, err, "rejected", "a Before hook returning in time aborts")
+	assert.Empty(t, events.events)
+}
+
+func TestHookTimeoutAbandonedHookOwnsItsCopies(t *testing.T) {
+	release := make(chan struct{})
+	abandoned := make(chan struct{})
+	var calls int32
+	touch := func(ctx *Context) {
+		ctx.Conn().Set("columns", ctx.Columns())
+		ctx.Set("seen", true)
+	}
+	hooks := &FuncHooks{Query: Funcs{After: func(ctx *Context) error {
+		touch(ctx)
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// Abandoned, it goes on while the next queries run on its connection
+			<-release
+			for i := 0; i < 100; i++ {
+				touch(ctx)
+			}
+			close(abandoned)
+		}
+		return ctx.Error
+	}}}
+	db, err := Open(drivertest.Name, "context;TestHookTimeoutAbandonedHookOwnsItsCopies", hooks, WithHookTimeout(hookTimeout))
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for i := 0; i < 20; i++ {
+		rows, err := db.Query("ROWS 1|SELECT n FROM t")
+		require.NoError(t, err)
+		if i == 0 {
+			close(release)
+		}
+		for rows.Next() {
+		}
+		require.NoError(t, rows.Close())
+	}
+	<-abandoned
+}
@@ -0,0 +1,90 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+type internalKey struct{}
+
+// MarkInternal returns a copy of ctx marking the operations run with it as
+// internal ones, setting Context.Internal: hooks running statements of their
+// own through the hooked *sql.DB, as a shadow read or an audit insert, should
+// mark them so the other hooks can tell them from the application ones.
+// Internal statements aren't explained.
+// The statements sqlhooks runs itself, as the WithServerInfo probes and the
+// Explainer plans, don't run any hook.
+func MarkInternal(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalKey{}, true)
+}
+
+// IsInternal reports whether ctx is marked by MarkInternal
+func IsInternal(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	marked, _ := ctx.Value(internalKey{}).(bool)
+	return marked
+}
+
+// internalExec runs query on the underlying connection c, bypassing the hooks
+func internalExec(stdCtx context.Context, c driver.Conn, query string, args []driver.Value) (driver.Result, error) {
+	var res driver.Result
+	var err error = driver.ErrSkip
+	switch execer := c.(type) {
+	case driver.ExecerContext:
+		res, err = execer.ExecContext(stdCtx, query, valuesToNamed(args))
+	case driver.Execer:
+		res, err = execer.Exec(query, args)
+	}
+	if err != driver.ErrSkip {
+		return res, err
+	}
+
+	stmt, err := c.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return stmt.Exec(args)
+}
+
+// internalQuery runs query on the underlying connection c, bypassing the
+// hooks. Closing the rows closes the statement they were prepared with, if any.
+func internalQuery(stdCtx context.Context, c driver.Conn, query string, args []driver.Value) (driver.Rows, error) {
+	var rows driver.Rows
+	var err error = driver.ErrSkip
+	switch queryer := c.(type) {
+	case driver.QueryerContext:
+		rows, err = queryer.QueryContext(stdCtx, query, valuesToNamed(args))
+	case driver.Queryer:
+		rows, err = queryer.Query(query, args)
+	}
+	if err != driver.ErrSkip {
+		return rows, err
+	}
+
+	stmt, err := c.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err = stmt.Query(args); err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	return forwardRows(stmtRows{rows, stmt}, rows), nil
+}
+
+// stmtRows closes the statement of the rows along with them
+type stmtRows struct {
+	driver.Rows
+	stmt driver.Stmt
+}
+
+func (r stmtRows) Close() error {
+	err := r.Rows.Close()
+	if stmtErr := r.stmt.Close(); err == nil {
+		err = stmtErr
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+// Package queue runs functions in background goroutines, off the query path,
+// for the Async hooks and the hooks handing their events to a slow consumer
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// The events a Queue reports, sqlhooks exports them as its own
+const (
+	EventDropped   = "dropped"
+	EventHookPanic = "hook_panic"
+)
+
+// Queue runs the functions pushed to it in workers background goroutines,
+// each holding up to size pending functions: a function is dropped when its
+// worker is full, or once the queue is closed. A panic of a function is
+// recovered, it's reported as an EventHookPanic and the dropped functions as
+// EventDropped ones, see SetReporter.
+type Queue struct {
+	// dropped comes first, it's 64-bit aligned for atomic operations then
+	dropped uint64
+	name    string
+	workers []chan func()
+	done    chan struct{}
+
+	// stopped is set when Shutdown gives up on the pending functions
+	stopped uint32
+
+	// mu guards closing the workers against the pushes in progress, and report
+	mu     sync.RWMutex
+	closed bool
+	report func(event string, err error)
+}
+
+// New returns a queue of workers goroutines, at least one, of size pending
+// functions each, name prefixes the errors it reports
+func New(name string, workers, size int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	q := &Queue{name: name, workers: make([]chan func(), workers), done: make(chan struct{})}
+	var running sync.WaitGroup
+	for i := range q.workers {
+		q.workers[i] = make(chan func(), size)
+		running.Add(1)
+		go func(fns chan func()) {
+			defer running.Done()
+			q.run(fns)
+		}(q.workers[i])
+	}
+	go func() {
+		running.Wait()
+		close(q.done)
+	}()
+	return q
+}
+
+func (q *Queue) run(fns chan func()) {
+	for fn := range fns {
+		if atomic.LoadUint32(&q.stopped) != 0 {
+			q.drop("shut down before it was handled")
+			continue
+		}
+		q.call(fn)
+	}
+}
+
+// call runs fn, recovering its panic
+func (q *Queue) call(fn func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			q.Report(EventHookPanic, fmt.Errorf("%s: hook panicked: %v", q.name, p))
+		}
+	}()
+	fn()
+}
+
+// drop counts a function dropped for reason
+func (q *Queue) drop(reason string) {
+	atomic.AddUint64(&q.dropped, 1)
+	q.Report(EventDropped, errors.New(q.name+": event dropped, "+reason))
+}
+
+// SetReporter sets the function Report calls, the hooks owning the queue
+// implement sqlhooks.InternalReporter with it
+func (q *Queue) SetReporter(report func(event string, err error)) {
+	q.mu.Lock()
+	q.report = report
+	q.mu.Unlock()
+}
+
+// Report reports event with the function given to SetReporter, if any
+func (q *Queue) Report(event string, err error) {
+	q.mu.RLock()
+	report := q.report
+	q.mu.RUnlock()
+	if report != nil {
+		report(event, err)
+	}
+}
+
+// Push queues fn to the worker of key, it never blocks: fn is dropped when
+// the worker is full or the queue closed
+func (q *Queue) Push(key uint64, fn func()) bool {
+	q.mu.RLock()
+	closed := q.closed
+	if !closed {
+		select {
+		case q.workers[key%uint64(len(q.workers))] <- fn:
+			q.mu.RUnlock()
+			return true
+		default:
+		}
+	}
+	q.mu.RUnlock()
+
+	if closed {
+		q.drop("the queue is closed")
+	} else {
+		q.drop("its worker is full")
+	}
+	return false
+}
+
+// Dropped returns the number of functions dropped because their worker was
+// full, or the queue was closed or shut down before running them
+func (q *Queue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// Workers returns the number of workers of q
+func (q *Queue) Workers() int {
+	return len(q.workers)
+}
+
+// Close waits for the pending functions to run, nothing can be pushed afterwards
+func (q *Queue) Close() {
+	q.Shutdown(context.Background())
+}
+
+// Shutdown is Close giving up once ctx is done: the functions still pending
+// are dropped and ctx's error is returned. The workers exit as soon as the
+// functions in progress, if any, return.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		for _, fns := range q.workers {
+			close(fns)
+		}
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		atomic.StoreUint32(&q.stopped, 1)
+		return ctx.Err()
+	}
+}
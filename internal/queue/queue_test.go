@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder records the values of the functions it returns, in the order they ran
+type recorder struct {
+	mu     sync.Mutex
+	values []int
+}
+
+func (r *recorder) fn(v int) func() {
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.values = append(r.values, v)
+	}
+}
+
+func TestQueueRunsFunctions(t *testing.T) {
+	r := &recorder{}
+	q := New("test", 1, 10)
+
+	assert.True(t, q.Push(0, r.fn(1)))
+	assert.True(t, q.Push(0, r.fn(2)))
+	q.Close()
+	q.Close()
+
+	assert.Equal(t, []int{1, 2}, r.values)
+	assert.Zero(t, q.Dropped())
+}
+
+func TestQueueKeepsTheOrderOfAKey(t *testing.T) {
+	recorders := []*recorder{{}, {}, {}}
+	q := New("test", 3, 100)
+	for i := 0; i < 30; i++ {
+		key := uint64(i % 3)
+		assert.True(t, q.Push(key, recorders[key].fn(i)))
+	}
+	q.Close()
+
+	for key, r := range recorders {
+		assert.Len(t, r.values, 10)
+		for i, v := range r.values {
+			assert.Equal(t, key+3*i, v)
+		}
+	}
+}
+
+func TestQueueDropsWhenFull(t *testing.T) {
+	block := make(chan struct{})
+	q := New("test", 1, 1)
+
+	dropped := 0
+	for i := 0; i < 3; i++ {
+		if !q.Push(0, func() { <-block }) {
+			dropped++
+		}
+	}
+	close(block)
+	q.Close()
+
+	assert.NotZero(t, dropped)
+	assert.Equal(t, uint64(dropped), q.Dropped())
+}
+
+func TestQueueDropsAfterClose(t *testing.T) {
+	q := New("test", 1, 10)
+	q.Close()
+
+	assert.False(t, q.Push(0, func() {}))
+	assert.Equal(t, uint64(1), q.Dropped())
+}
+
+func TestQueueShutdownGivesUp(t *testing.T) {
+	block := make(chan struct{})
+	r := &recorder{}
+	q := New("test", 1, 10)
+	for i := 0; i < 3; i++ {
+		fn := r.fn(i)
+		assert.True(t, q.Push(0, func() {
+			<-block
+			fn()
+		}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Shutdown(ctx))
+
+	close(block)
+	<-q.done
+	assert.Equal(t, []int{0}, r.values)
+	assert.Equal(t, uint64(2), q.Dropped())
+	assert.NoError(t, q.Shutdown(context.Background()))
+}
+
+// reported records the events reported to it
+type reported struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *reported) report(event string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event+": "+err.Error())
+}
+
+func TestQueueReports(t *testing.T) {
+	r := &reported{}
+	q := New("test", 1, 1)
+	q.SetReporter(r.report)
+	assert.True(t, q.Push(0, func() { panic("boom") }))
+	q.Close()
+	assert.False(t, q.Push(0, func() {}))
+
+	assert.Equal(t, []string{
+		EventHookPanic + ": test: hook panicked: boom",
+		EventDropped + ": test: event dropped, the queue is closed",
+	}, r.events)
+}
+
+func TestQueueShutdownReportsDropped(t *testing.T) {
+	r := &reported{}
+	block := make(chan struct{})
+	q := New("test", 1, 10)
+	q.SetReporter(r.report)
+	for i := 0; i < 3; i++ {
+		assert.True(t, q.Push(0, func() { <-block }))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Shutdown(ctx))
+	close(block)
+	<-q.done
+
+	drop := EventDropped + ": test: event dropped, shut down before it was handled"
+	assert.Equal(t, []string{drop, drop}, r.events)
+}
@@ -0,0 +1,123 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// explainAll explains every statement, logging the explained ones
+type explainAll struct {
+	explained []string
+	plans     []string
+	txIDs     []uint64
+}
+
+func (e *explainAll) ExplainQuery(ctx *Context) (string, bool) {
+	e.explained = append(e.explained, ctx.Query)
+	e.txIDs = append(e.txIDs, ctx.TxID)
+	return "EXPLAIN " + ctx.Query, true
+}
+
+func (e *explainAll) AfterExplain(ctx *Context, plan string, err error) {
+	e.plans = append(e.plans, ctx.Query)
+}
+
+// statementRecorder logs the query of every Before and After hook
+func statementRecorder(log *[]string) *FuncHooks {
+	record := func(ctx *Context) error {
+		*log = append(*log, ctx.Query)
+		return ctx.Error
+	}
+	funcs := Funcs{Before: record, After: record}
+	return &FuncHooks{Query: funcs, Exec: funcs, Prepare: funcs, StmtQuery: funcs, StmtExec: funcs}
+}
+
+func TestExplainDoesntRunHooks(t *testing.T) {
+	var recorded []string
+	explainer := &explainAll{}
+	driverConn := &recordingConn{}
+	dc, err := NewDriver("", Compose(statementRecorder(&recorded), explainer)).wrap(context.Background(), "", driverConn)
+	require.NoError(t, err)
+	c := dc.(*conn)
+
+	_, err = c.ExecContext(context.Background(), "UPDATE t SET v = 1", nil)
+	require.NoError(t, err)
+	rows, err := c.QueryContext(context.Background(), "SELECT v FROM t", nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	assert.Equal(t, []string{"UPDATE t SET v = 1", "SELECT v FROM t"}, explainer.explained, "the plans aren't explained")
+	assert.Equal(t, explainer.explained, explainer.plans)
+	assert.Equal(t, []string{
+		"UPDATE t SET v = 1", "UPDATE t SET v = 1",
+		"SELECT v FROM t", "SELECT v FROM t",
+	}, recorded, "the plans don't run hooks")
+	assert.Equal(t, []string{
+		"exec UPDATE t SET v = 1 []",
+		"query EXPLAIN UPDATE t SET v = 1 []",
+		"query SELECT v FROM t []",
+		"query EXPLAIN SELECT v FROM t []",
+	}, driverConn.log)
+
+	// Statements of a transaction are explained with its TxID
+	tx, err := c.BeginTx(context.Background(), driver.TxOptions{})
+	require.NoError(t, err)
+	_, err = c.ExecContext(context.Background(), "UPDATE t SET v = 2", nil)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	require.Len(t, explainer.txIDs, 3)
+	assert.Equal(t, []uint64{0, 0}, explainer.txIDs[:2])
+	assert.NotZero(t, explainer.txIDs[2])
+}
+
+func TestMarkInternal(t *testing.T) {
+	var internal []bool
+	explainer := &explainAll{}
+	hooks := Compose(&FuncHooks{Exec: Funcs{After: func(ctx *Context) error {
+		internal = append(internal, ctx.Internal)
+		return ctx.Error
+	}}}, explainer)
+
+	d := NewDriver("", hooks)
+	d.driver = recordingDriver{&recordingConn{}}
+	db, err := sql.Open(RegisterUnique("internal", d), "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(context.Background(), "INSERT INTO app VALUES (1)")
+	require.NoError(t, err)
+	_, err = db.ExecContext(MarkInternal(context.Background()), "INSERT INTO audit VALUES (1)")
+	require.NoError(t, err)
+
+	assert.Equal(t, []bool{false, true}, internal)
+	assert.Equal(t, []string{"INSERT INTO app VALUES (1)"}, explainer.explained, "internal statements aren't explained")
+	assert.False(t, IsInternal(context.Background()))
+	assert.False(t, IsInternal(nil))
+}
+
+func TestInternalExecAndQuery(t *testing.T) {
+	// Without Execer and Queryer, through a prepared statement
+	c := &recordingConn{}
+	prepareOnly := struct{ driver.Conn }{c}
+
+	res, err := internalExec(context.Background(), prepareOnly, "DELETE FROM t", []driver.Value{int64(1)})
+	require.NoError(t, err)
+	assert.Equal(t, driver.RowsAffected(0), res)
+	rows, err := internalQuery(context.Background(), prepareOnly, "SELECT 1", nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	_, err = internalExec(context.Background(), c, "DELETE FROM t", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"prepare DELETE FROM t", "exec [1]",
+		"prepare SELECT 1", "query []",
+		"exec DELETE FROM t []",
+	}, c.log)
+}
@@ -0,0 +1,70 @@
+package sqlhooks
+
+import (
+	"context"
+	"strings"
+)
+
+// MigrationDriverSuffixes are the suffixes of the names of the underlying
+// drivers whose statements are all migration ones, for migration tools to be
+// given a driver of their own, as sql.Register("postgres-migrate", &pq.Driver{})
+var MigrationDriverSuffixes = []string{"-migrate", "-migrations"}
+
+type migrationKey struct{}
+
+// MarkMigration returns a copy of ctx marking the operations run with it as
+// schema migrations, setting Context.Migration. It's also set for DDL
+// statements, as told by Context.Kind, and for every operation of a Driver
+// whose underlying driver name ends with one of MigrationDriverSuffixes.
+func MarkMigration(ctx context.Context) context.Context {
+	return context.WithValue(ctx, migrationKey{}, true)
+}
+
+// IsMigration reports whether ctx is marked by MarkMigration
+func IsMigration(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	marked, _ := ctx.Value(migrationKey{}).(bool)
+	return marked
+}
+
+func isMigrationDriver(name string) bool {
+	for _, suffix := range MigrationDriverSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// migration tells the Context.Migration of ctx, run on c
+func (c *conn) migration(ctx *Context) bool {
+	return c.migrations || IsMigration(ctx.Ctx) || ctx.Query != "" && ctx.Kind() == KindDDL
+}
+
+// Migrations is how the hooks of the hooks packages handle the migration
+// statements, the ones whose Context.Migration is set
+type Migrations int
+
+const (
+	// IncludeMigrations handles them as any other statement
+	IncludeMigrations Migrations = iota
+
+	// ExcludeMigrations leaves them out
+	ExcludeMigrations
+
+	// LabelMigrations handles them as any other statement, labeled as
+	// migrations, as with a migration field set to true
+	LabelMigrations
+)
+
+// Excludes reports whether the operation of ctx is left out with m
+func (m Migrations) Excludes(ctx *Context) bool {
+	return m == ExcludeMigrations && ctx.Migration
+}
+
+// Labels reports whether the operation of ctx is labeled as a migration with m
+func (m Migrations) Labels(ctx *Context) bool {
+	return m == LabelMigrations && ctx.Migration
+}
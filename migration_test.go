@@ -0,0 +1,125 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ddlConn is a recordingConn returning results without rows, as drivers do
+// for DDL statements
+type ddlConn struct {
+	recordingConn
+}
+
+func (c *ddlConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	c.recordingConn.Exec(query, args)
+	return driver.ResultNoRows, nil
+}
+
+// migrationRecorder records the queries it sees, with whether they're migration ones
+type migrationRecorder struct {
+	migrations map[string]bool
+}
+
+func (r *migrationRecorder) record(ctx *Context) error {
+	query := ctx.Query
+	if query == "" {
+		query = "tx"
+	}
+	r.migrations[query] = ctx.Migration
+	if _, err := MarshalEvent(ctx); err != nil {
+		return err
+	}
+	return ctx.Error
+}
+
+func (r *migrationRecorder) hooks() HookType {
+	return &FuncHooks{
+		Exec:   Funcs{After: r.record},
+		Query:  Funcs{After: r.record},
+		Begin:  Funcs{After: r.record},
+		Commit: Funcs{After: r.record},
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	r := &migrationRecorder{migrations: make(map[string]bool)}
+	c, err := NewDriver("", r.hooks()).wrap(context.Background(), "", &ddlConn{})
+	require.NoError(t, err)
+	hc := c.(*conn)
+	bg := context.Background()
+
+	for _, query := range []string{
+		"CREATE TABLE users (id int)",
+		"ALTER TABLE users ADD COLUMN name text",
+		"CREATE INDEX users_name ON users (name)",
+		"DROP TABLE users",
+	} {
+		res, err := hc.ExecContext(bg, query, nil)
+		require.NoError(t, err, query)
+		_, err = res.RowsAffected()
+		assert.Error(t, err, "no rows")
+	}
+	_, err = hc.ExecContext(bg, "INSERT INTO users VALUES (1)", nil)
+	require.NoError(t, err)
+	_, err = hc.ExecContext(MarkMigration(bg), "UPDATE users SET name = 'x'", nil)
+	require.NoError(t, err)
+	rows, err := hc.QueryContext(bg, "SELECT 1", nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	assert.Equal(t, map[string]bool{
+		"CREATE TABLE users (id int)":             true,
+		"ALTER TABLE users ADD COLUMN name text":  true,
+		"CREATE INDEX users_name ON users (name)": true,
+		"DROP TABLE users":                        true,
+		"INSERT INTO users VALUES (1)":            false,
+		"UPDATE users SET name = 'x'":             true,
+		"SELECT 1":                                false,
+	}, r.migrations)
+	assert.Equal(t, []string{
+		"exec CREATE TABLE users (id int) []",
+		"exec ALTER TABLE users ADD COLUMN name text []",
+		"exec CREATE INDEX users_name ON users (name) []",
+		"exec DROP TABLE users []",
+		"exec INSERT INTO users VALUES (1) []",
+		"exec UPDATE users SET name = 'x' []",
+		"query SELECT 1 []",
+	}, c.(*conn).Conn.(*ddlConn).log)
+
+	// Transactions begun with a marked context
+	r.migrations = make(map[string]bool)
+	tx, err := hc.BeginTx(MarkMigration(bg), driver.TxOptions{})
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, map[string]bool{"tx": true}, r.migrations)
+}
+
+func TestMigrationDriver(t *testing.T) {
+	r := &migrationRecorder{migrations: make(map[string]bool)}
+	c, err := NewDriver("postgres-migrate", r.hooks()).wrap(context.Background(), "", &recordingConn{})
+	require.NoError(t, err)
+
+	_, err = c.(*conn).ExecContext(context.Background(), "INSERT INTO schema_migrations VALUES (1)", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"INSERT INTO schema_migrations VALUES (1)": true}, r.migrations)
+}
+
+func TestMigrationsPolicy(t *testing.T) {
+	ctx := NewContext()
+	for _, m := range []Migrations{IncludeMigrations, ExcludeMigrations, LabelMigrations} {
+		assert.False(t, m.Excludes(ctx))
+		assert.False(t, m.Labels(ctx))
+	}
+
+	ctx.Migration = true
+	assert.False(t, IncludeMigrations.Excludes(ctx))
+	assert.False(t, IncludeMigrations.Labels(ctx))
+	assert.True(t, ExcludeMigrations.Excludes(ctx))
+	assert.True(t, LabelMigrations.Labels(ctx))
+	assert.False(t, LabelMigrations.Excludes(ctx))
+}
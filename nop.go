@@ -0,0 +1,50 @@
+package sqlhooks
+
+// NopHooks implements Beginner, Commiter, Rollbacker, Stmter, Queryer and
+// Execer doing nothing: its Before hooks return nil and its After hooks
+// ctx.Error. Hooks embedding it only need the methods they care about:
+//
+//	type slowQueries struct {
+//		sqlhooks.NopHooks
+//	}
+//
+//	func (slowQueries) AfterQuery(ctx *sqlhooks.Context) error {
+//		if ctx.Duration > time.Second {
+//			log.Printf("slow query: %s", ctx.Query)
+//		}
+//		return ctx.Error
+//	}
+//
+// Every interface made of Before and After hooks is implemented, the optional
+// ones, as RowsCloser or Explainer, aren't as they cost even when empty.
+// The Contexts of the operations embedders don't hook are still built, use
+// WithOperations to leave them out.
+type NopHooks struct{}
+
+// Nop is the hooks the combinators fall back to for the operations their
+// hooks don't implement
+var Nop = NopHooks{}
+
+func (NopHooks) BeforeBegin(*Context) error    { return nil }
+func (NopHooks) AfterBegin(ctx *Context) error { return ctx.Error }
+
+func (NopHooks) BeforeCommit(*Context) error    { return nil }
+func (NopHooks) AfterCommit(ctx *Context) error { return ctx.Error }
+
+func (NopHooks) BeforeRollback(*Context) error    { return nil }
+func (NopHooks) AfterRollback(ctx *Context) error { return ctx.Error }
+
+func (NopHooks) BeforePrepare(*Context) error    { return nil }
+func (NopHooks) AfterPrepare(ctx *Context) error { return ctx.Error }
+
+func (NopHooks) BeforeStmtQuery(*Context) error    { return nil }
+func (NopHooks) AfterStmtQuery(ctx *Context) error { return ctx.Error }
+
+func (NopHooks) BeforeStmtExec(*Context) error    { return nil }
+func (NopHooks) AfterStmtExec(ctx *Context) error { return ctx.Error }
+
+func (NopHooks) BeforeQuery(*Context) error    { return nil }
+func (NopHooks) AfterQuery(ctx *Context) error { return ctx.Error }
+
+func (NopHooks) BeforeExec(*Context) error    { return nil }
+func (NopHooks) AfterExec(ctx *Context) error { return ctx.Error }
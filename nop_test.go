@@ -0,0 +1,69 @@
+package sqlhooks
+
+import (
+	"testing"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// afterQueryOnly overrides AfterQuery only
+type afterQueryOnly struct {
+	NopHooks
+	queries []string
+}
+
+func (h *afterQueryOnly) AfterQuery(ctx *Context) error {
+	h.queries = append(h.queries, ctx.Query)
+	return ctx.Error
+}
+
+func TestNopHooksEmbedding(t *testing.T) {
+	var _ interface {
+		Beginner
+		Commiter
+		Rollbacker
+		Stmter
+		Queryer
+		Execer
+	} = Nop
+
+	hooks := &afterQueryOnly{}
+	db, err := Open(drivertest.Name, "context;"+t.Name(), hooks)
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("AFFECTED 1|UPDATE t SET n = 1")
+	require.NoError(t, err)
+	rows, err := tx.Query("ROWS 2|SELECT n FROM t")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	stmt, err := tx.Prepare("SELECT n FROM t WHERE n = ?")
+	require.NoError(t, err)
+	_, err = stmt.Exec(1)
+	require.NoError(t, err)
+	require.NoError(t, stmt.Close())
+	require.NoError(t, tx.Commit())
+
+	// The embedded After hooks keep the errors
+	_, err = db.Exec("FAIL deadlock|UPDATE t SET n = 2")
+	assert.EqualError(t, err, "drivertest: deadlock")
+	_, err = db.Query("FAIL locked|SELECT n FROM t")
+	assert.EqualError(t, err, "drivertest: locked")
+
+	assert.Equal(t, []string{"ROWS 2|SELECT n FROM t", "FAIL locked|SELECT n FROM t"}, hooks.queries)
+}
+
+func TestRouteFallsBackToNop(t *testing.T) {
+	r := Route(map[Kind]HookType{KindSelect: &afterQueryOnly{}}, nil).(*router)
+	ctx := NewContext()
+	ctx.Query = "UPDATE t SET n = 1"
+	ctx.Error = errOp
+	assert.Equal(t, Nop, r.execer(ctx))
+	assert.NoError(t, r.BeforeExec(ctx))
+	assert.Equal(t, errOp, r.AfterExec(ctx))
+	assert.Equal(t, Nop, r.beginner())
+}
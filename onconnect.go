@@ -0,0 +1,94 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+)
+
+// WithOnConnect sets fn to run on every new connection before it's used.
+// conn is the underlying connection, so the statements fn runs there don't
+// run any hook, and ctx gives access to the connection data.
+// If fn fails the connection is closed and the error returned to database/sql.
+func WithOnConnect(fn func(ctx *Context, conn driver.Conn) error) Option {
+	return func(d *Driver) {
+		d.onConnect = append(d.onConnect, func(stdCtx context.Context, c *conn) error {
+			ctx := c.newContext()
+			ctx.Ctx = stdCtx
+			return fn(ctx, c.Conn)
+		})
+	}
+}
+
+// ServerInfo describes the database server a connection is on, see WithServerInfo
+type ServerInfo struct {
+	Product string
+	Version string
+}
+
+// ServerVersionQueries are the queries returning the server version of the
+// usual drivers, for WithServerInfo
+var ServerVersionQueries = map[string]string{
+	"postgres": "SHOW server_version",
+	"mysql":    "SELECT VERSION()",
+	"sqlite3":  "SELECT sqlite_version()",
+}
+
+// WithServerInfo probes every new connection running query, which returns
+// the server version, and sets Context.ServerInfo for its operations.
+// The probe runs on the underlying connection, without hooks. When it fails,
+// the connection fails as well if required is set, otherwise its
+// ServerInfo has no Version.
+func WithServerInfo(product, query string, required bool) Option {
+	return func(d *Driver) {
+		d.onConnect = append(d.onConnect, func(stdCtx context.Context, c *conn) error {
+			version, err := queryString(stdCtx, c.Conn, query)
+			if err != nil && required {
+				return fmt.Errorf("sqlhooks: probing server version: %v", err)
+			}
+			c.serverInfo = ServerInfo{Product: product, Version: version}
+			return nil
+		})
+	}
+}
+
+// WithPeerResolver sets fn to tell the server every new connection is on,
+// as host:port, and sets Context.Peer for its operations, as well as the
+// Peer of the summaries of its transactions and of itself. It's meant for
+// the drivers connecting to one of several hosts, as pgx with a multi-host
+// DSN, whose connections can be on different servers: fn gets the
+// underlying connection, to unwrap down to the driver's own, and returns ""
+// when it can't tell.
+func WithPeerResolver(fn func(conn driver.Conn) string) Option {
+	return func(d *Driver) {
+		d.onConnect = append(d.onConnect, func(stdCtx context.Context, c *conn) error {
+			c.peer = fn(c.Conn)
+			return nil
+		})
+	}
+}
+
+// queryString returns the first column of the first row returned by query
+func queryString(stdCtx context.Context, c driver.Conn, query string) (string, error) {
+	rows, err := internalQuery(stdCtx, c, query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, len(rows.Columns()))
+	if len(dest) == 0 {
+		return "", fmt.Errorf("sqlhooks: %q returned no columns", query)
+	}
+	if err := rows.Next(dest); err != nil {
+		return "", err
+	}
+
+	switch v := dest[0].(type) {
+	case []byte:
+		return string(v), nil
+	case string:
+		return v, nil
+	}
+	return fmt.Sprint(dest[0]), nil
+}
@@ -0,0 +1,157 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnConnectRunsOncePerConnection(t *testing.T) {
+	openDBWithHooks(t, nil).Close()
+
+	var connects, execs int
+	hooks := &HooksMock{
+		beforeExec: func(ctx *Context) error {
+			execs++
+			assert.Equal(t, "probed", ctx.Conn().Get("state"))
+			return nil
+		},
+	}
+	d := NewDriver(*driverFlag, hooks, WithOnConnect(func(ctx *Context, conn driver.Conn) error {
+		connects++
+		ctx.Conn().Set("state", "probed")
+		return nil
+	}))
+	db, err := sql.Open(RegisterUnique(*driverFlag, d), *dsnFlag)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for i := 0; i < 2; i++ {
+		_, err := db.Exec(queries[*driverFlag].insert, "foo", "bar")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, connects)
+	assert.Equal(t, 2, execs)
+}
+
+func TestOnConnectFailureFailsConnection(t *testing.T) {
+	openDBWithHooks(t, nil).Close()
+
+	boom := errors.New("boom")
+	d := NewDriver(*driverFlag, nil, WithOnConnect(func(ctx *Context, conn driver.Conn) error {
+		return boom
+	}))
+	_, err := d.Open(*dsnFlag)
+	assert.Equal(t, boom, err)
+}
+
+func TestServerInfo(t *testing.T) {
+	db := openDBWithHooks(t, nil)
+	_, err := db.Exec(queries[*driverFlag].insert, "15.2", "x")
+	require.NoError(t, err)
+	db.Close()
+
+	var infos []ServerInfo
+	hooks := &HooksMock{
+		beforeExec: func(ctx *Context) error {
+			infos = append(infos, ctx.ServerInfo)
+			return nil
+		},
+		beforeStmtExec: func(ctx *Context) error {
+			infos = append(infos, ctx.ServerInfo)
+			return nil
+		},
+		afterPrepare: func(ctx *Context) error { return ctx.Error },
+	}
+	d := NewDriver(*driverFlag, hooks, WithServerInfo("test", queries[*driverFlag].selectall, true))
+	db, err = sql.Open(RegisterUnique(*driverFlag, d), *dsnFlag)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	require.NotEmpty(t, infos)
+	for _, info := range infos {
+		assert.Equal(t, ServerInfo{Product: "test", Version: "15.2"}, info)
+	}
+}
+
+func TestServerInfoProbeFailure(t *testing.T) {
+	openDBWithHooks(t, nil).Close()
+
+	_, err := NewDriver(*driverFlag, nil, WithServerInfo("test", "invalid query", true)).Open(*dsnFlag)
+	assert.Error(t, err)
+
+	c, err := NewDriver(*driverFlag, nil, WithServerInfo("test", "invalid query", false)).Open(*dsnFlag)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, ServerInfo{Product: "test"}, c.(*conn).newContext().ServerInfo)
+}
+
+// peerHooks record the Peer of the statements and of the connections closed by ConnID
+type peerHooks struct {
+	NopHooks
+	statements map[uint64][]string
+	closed     map[uint64]string
+}
+
+func (h *peerHooks) AfterExec(ctx *Context) error {
+	h.statements[ctx.ConnID] = append(h.statements[ctx.ConnID], ctx.Peer)
+	return ctx.Error
+}
+
+func (h *peerHooks) AfterConnClose(summary ConnSummary) {
+	h.closed[summary.ConnID] = summary.Peer
+}
+
+func TestPeerResolver(t *testing.T) {
+	// The connections are on the hosts in turn, as a failover DSN would
+	hosts := []string{"a:5432", "b:5432", "c:5432"}
+	var resolved []driver.Conn
+	resolver := func(conn driver.Conn) string {
+		resolved = append(resolved, conn)
+		return hosts[(len(resolved)-1)%len(hosts)]
+	}
+
+	hooks := &peerHooks{statements: make(map[uint64][]string), closed: make(map[uint64]string)}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks, WithPeerResolver(resolver))
+	require.NoError(t, err)
+
+	var conns []*sql.Conn
+	for range hosts {
+		c, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		conns = append(conns, c)
+	}
+	for i := 0; i < 2; i++ {
+		for _, c := range conns {
+			_, err := c.ExecContext(context.Background(), "INSERT INTO t VALUES (1)")
+			require.NoError(t, err)
+		}
+	}
+	for _, c := range conns {
+		require.NoError(t, c.Close())
+	}
+	require.NoError(t, db.Close())
+
+	require.Len(t, resolved, 3)
+	// The resolver gets the driver's connection, not the wrapper
+	_, wrapped := resolved[0].(*conn)
+	assert.False(t, wrapped)
+	require.Len(t, hooks.statements, 3)
+	var peers []string
+	for id, statements := range hooks.statements {
+		require.Len(t, statements, 2)
+		assert.Equal(t, statements[0], statements[1])
+		assert.Equal(t, statements[0], hooks.closed[id])
+		peers = append(peers, statements[0])
+	}
+	assert.ElementsMatch(t, hosts, peers)
+}
@@ -0,0 +1,204 @@
+package sqlhooks
+
+import "strings"
+
+// Option configures optional Driver features
+type Option func(*Driver)
+
+// Op identifies the operations hooks are attached to
+type Op uint
+
+const (
+	OpBegin Op = 1 << iota
+	OpCommit
+	OpRollback
+	OpPrepare
+	// OpQuery covers Query and prepared statements queries
+	OpQuery
+	// OpExec covers Exec and prepared statements executions
+	OpExec
+
+	OpAll = OpBegin | OpCommit | OpRollback | OpPrepare | OpQuery | OpExec
+)
+
+var opNames = [...]string{"Begin", "Commit", "Rollback", "Prepare", "Query", "Exec"}
+
+// String returns the names of the operations, as Query|Exec, none for 0
+func (o Op) String() string {
+	var names []string
+	for i, name := range opNames {
+		if o&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// WithLazyDriver defers looking the underlying driver up to the first
+// connection, for NewDriverE and Open, so it can be registered later.
+func WithLazyDriver() Option {
+	return func(d *Driver) {
+		d.lazy = true
+	}
+}
+
+// WithOperations restricts the hooks to the given operations, the rest are
+// delegated straight to the underlying driver, without any hook, timing or
+// argument conversion.
+// Since a prepared statement can be used for both queries and executions,
+// statements are still wrapped unless OpPrepare, OpQuery and OpExec are all disabled.
+func WithOperations(ops Op) Option {
+	return func(d *Driver) {
+		d.ops = ops
+	}
+}
+
+// WithStmtCache enables a per connection prepared statement cache.
+// Once a query has been executed more than threshold times on a connection
+// through Exec or Query, it's prepared and the statement is reused by later
+// executions. At most size statements are kept per connection, the least
+// recently used one is closed when the cache is full.
+func WithStmtCache(size, threshold int) Option {
+	return func(d *Driver) {
+		d.stmtCacheSize = size
+		d.stmtCacheThreshold = threshold
+	}
+}
+
+// WithSavepointExecHooks sets whether Exec and Stmt hooks run for savepoint
+// statements, which are reported to Savepointer hooks anyway. Enabled by default.
+func WithSavepointExecHooks(enabled bool) Option {
+	return func(d *Driver) {
+		d.skipSavepointExec = !enabled
+	}
+}
+
+// WithSelfTiming measures the time spent running hooks, set in
+// Context.HookDuration for the After hooks, and accumulated in Driver.Stats
+// along with the time spent inside the underlying driver.
+// It's disabled by default since it takes extra clock readings.
+func WithSelfTiming() Option {
+	return func(d *Driver) {
+		d.diag.selfTiming = true
+	}
+}
+
+// WithArgsSize sets Context.ArgsSize for the Query, Exec and Stmt hooks
+func WithArgsSize() Option {
+	return func(d *Driver) {
+		d.argsSize = true
+	}
+}
+
+// WithCollapseInLists sets whether the hooks get the queries and arguments
+// with their IN lists collapsed, as done by CollapseInLists, so that queries
+// only differing by the length of a list look the same. The driver still runs
+// the original query and arguments, changes made to them by the Before hooks
+// are ignored. Disabled by default.
+func WithCollapseInLists(enabled bool) Option {
+	return func(d *Driver) {
+		d.collapseInLists = enabled
+	}
+}
+
+// WithAbandonedRowsCheck sets whether the RowsCloser hooks can tell the rows
+// closed right after their last row, as by QueryRow, from the ones closed
+// while rows were left, see Context.Abandoned. It costs reading one more row
+// from the driver when rows are closed before Next reported their end: on a
+// large result set that's a row the application didn't ask for, and Close
+// waits for the driver to deliver it, from the server for network drivers.
+// Disabled by default, every rows closed before their end are abandoned then.
+func WithAbandonedRowsCheck(enabled bool) Option {
+	return func(d *Driver) {
+		d.abandonedRowsCheck = enabled
+	}
+}
+
+// WithArgCountCheck sets whether statements whose number of arguments
+// doesn't match their placeholders, as counted by Placeholders, fail with an
+// ErrArgCountMismatch without reaching the driver. For prepared statements,
+// the number the driver reports, see Context.NumInput, is used instead
+// unless it's -1. The After hooks get it
+// in ctx.Error. Statements mixing placeholder styles aren't checked, nor are
+// the operations disabled by WithOperations. Disabled by default.
+func WithArgCountCheck(enabled bool) Option {
+	return func(d *Driver) {
+		d.argCountCheck = enabled
+	}
+}
+
+// WithArgCountWarning makes WithArgCountCheck report the mismatches as
+// EventArgCountMismatch instead, still running the statements, for drivers
+// accepting extra arguments.
+func WithArgCountWarning() Option {
+	return func(d *Driver) {
+		d.argCountWarn = true
+	}
+}
+
+// WithInternalLogger sets the function called for the internal events
+// (see the Event constants), such as an After hook hiding an error.
+// Events are counted in Driver.Stats anyway, nothing is logged by default.
+func WithInternalLogger(fn func(event string, err error)) Option {
+	return func(d *Driver) {
+		d.diag.logger = fn
+	}
+}
+
+// Logger is the minimal logger the built-in output is written to, satisfied
+// by *log.Logger, so neither the package nor its simple hooks need a logging
+// library
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithLogger is WithInternalLogger writing the events to l, one line each, as
+// "[event] error".
+func WithLogger(l Logger) Option {
+	return WithInternalLogger(func(event string, err error) {
+		l.Printf("[%s] %v", event, err)
+	})
+}
+
+// WithTraceExtractor sets how Context.TraceInfo finds the trace an operation
+// belongs to, from the context.Context it was issued with.
+// Hooks can then share the trace identity however tracing is done.
+func WithTraceExtractor(fn TraceExtractor) Option {
+	return func(d *Driver) {
+		d.traceExtractor = fn
+	}
+}
+
+// WithHooksSelector sets fn to choose the hooks of every new connection from
+// the dsn it's opened with, so a single registered driver can hook the
+// connections to some databases only:
+//
+//	name := sqlhooks.RegisterUnique("postgres", sqlhooks.NewDriver("postgres", nil,
+//		sqlhooks.WithHooksSelector(func(dsn string) sqlhooks.HookType {
+//			if strings.Contains(dsn, "dbname=analytics") {
+//				return analyticsHooks
+//			}
+//			return nil
+//		})))
+//
+// The hooks fn returns run, after the default ones, instead of the hooks of
+// the Driver for as long as the connection lives, SetHooks doesn't change
+// them and Shutdown stops them without calling their Shutdown. When fn
+// returns nil, the connection is returned as the underlying driver opened
+// it: no hook, default ones included, nor any other option applies to it.
+func WithHooksSelector(fn func(dsn string) HookType) Option {
+	return func(d *Driver) {
+		d.selectHooks = fn
+	}
+}
+
+// WithSystem sets the database system of the Driver, Context.System, in place
+// of the one System infers from its driver name
+func WithSystem(info SystemInfo) Option {
+	return func(d *Driver) {
+		d.system = info
+	}
+}
@@ -0,0 +1,291 @@
+package sqlhooks
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orderLog is the log shared by the hooks and the driver, in the order of
+// their calls
+type orderLog struct {
+	mu      sync.Mutex
+	entries []orderEntry
+}
+
+// orderEntry is a call of a hook, on the connection ConnID, or of the
+// driver, on the fake connection conn
+type orderEntry struct {
+	hook   bool
+	connID uint64
+	conn   int
+	event  string
+	query  string
+}
+
+func (l *orderLog) add(e orderEntry) {
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+}
+
+// latencyDriver opens connections sleeping up to latency in every call,
+// failing the test if a connection is used concurrently
+type latencyDriver struct {
+	t       *testing.T
+	log     *orderLog
+	latency time.Duration
+	conns   int32
+}
+
+func (d *latencyDriver) Open(string) (driver.Conn, error) {
+	return &latencyConn{driver: d, id: int(atomic.AddInt32(&d.conns, 1))}, nil
+}
+
+type latencyConn struct {
+	driver *latencyDriver
+	id     int
+	busy   int32
+}
+
+// call logs the driver call event and sleeps
+func (c *latencyConn) call(event, query string) {
+	if !atomic.CompareAndSwapInt32(&c.busy, 0, 1) {
+		c.driver.t.Errorf("connection %d used concurrently by %s %s", c.id, event, query)
+		return
+	}
+	defer atomic.StoreInt32(&c.busy, 0)
+
+	c.driver.log.add(orderEntry{conn: c.id, event: event, query: query})
+	time.Sleep(time.Duration(rand.Int63n(int64(c.driver.latency))))
+}
+
+func (c *latencyConn) Prepare(query string) (driver.Stmt, error) {
+	c.call("prepare", query)
+	return &latencyStmt{conn: c, query: query}, nil
+}
+
+func (c *latencyConn) Close() error { return nil }
+
+func (c *latencyConn) Begin() (driver.Tx, error) {
+	c.call("begin", "")
+	return latencyTx{c}, nil
+}
+
+func (c *latencyConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	c.call("exec", query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *latencyConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	c.call("query", query)
+	return &latencyRows{conn: c, query: query, left: 2}, nil
+}
+
+type latencyStmt struct {
+	conn  *latencyConn
+	query string
+}
+
+func (s *latencyStmt) Close() error  { return nil }
+func (s *latencyStmt) NumInput() int { return -1 }
+
+func (s *latencyStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.call("stmtexec", s.query)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *latencyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.call("stmtquery", s.query)
+	return &latencyRows{conn: s.conn, query: s.query, left: 2}, nil
+}
+
+type latencyTx struct {
+	conn *latencyConn
+}
+
+func (tx latencyTx) Commit() error {
+	tx.conn.call("commit", "")
+	return nil
+}
+
+func (tx latencyTx) Rollback() error {
+	tx.conn.call("rollback", "")
+	return nil
+}
+
+type latencyRows struct {
+	conn  *latencyConn
+	query string
+	left  int
+}
+
+func (r *latencyRows) Columns() []string { return []string{"n"} }
+
+func (r *latencyRows) Close() error {
+	r.conn.call("rowsclose", r.query)
+	return nil
+}
+
+func (r *latencyRows) Next(dest []driver.Value) error {
+	if r.left == 0 {
+		return io.EOF
+	}
+	r.left--
+	dest[0] = int64(r.left)
+	return nil
+}
+
+// orderRecorder logs every hook event
+type orderRecorder struct {
+	log *orderLog
+}
+
+func (r orderRecorder) record(event string) func(*Context) error {
+	return func(ctx *Context) error {
+		r.log.add(orderEntry{hook: true, connID: ctx.ConnID, event: event, query: ctx.Query})
+		return ctx.Error
+	}
+}
+
+func (r orderRecorder) hooks() HookType {
+	funcs := func(op string) Funcs {
+		return Funcs{Before: r.record("before " + op), After: r.record("after " + op)}
+	}
+	return Compose(&FuncHooks{
+		Query:     funcs("query"),
+		Exec:      funcs("exec"),
+		Begin:     funcs("begin"),
+		Commit:    funcs("commit"),
+		Rollback:  funcs("rollback"),
+		Prepare:   funcs("prepare"),
+		StmtQuery: funcs("stmtquery"),
+		StmtExec:  funcs("stmtexec"),
+	}, r)
+}
+
+func (r orderRecorder) AfterRowsClose(ctx *Context) error { return r.record("after rowsclose")(ctx) }
+
+func TestHooksOrderPerConn(t *testing.T) {
+	log := &orderLog{}
+	d := NewDriver("", orderRecorder{log}.hooks())
+	d.driver = &latencyDriver{t: t, log: log, latency: 200 * time.Microsecond}
+	db, err := sql.Open(RegisterUnique("ordering", d), "")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(3)
+
+	stmt, err := db.Prepare("SELECT prepared")
+	require.NoError(t, err)
+	defer stmt.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				q := func(op string) string { return fmt.Sprintf("%s %d-%d", op, g, i) }
+
+				_, err := db.Exec(q("UPDATE"))
+				assert.NoError(t, err)
+
+				rows, err := db.Query(q("SELECT"))
+				if assert.NoError(t, err) {
+					for rows.Next() {
+					}
+					assert.NoError(t, rows.Close())
+				}
+
+				tx, err := db.Begin()
+				if assert.NoError(t, err) {
+					_, err = tx.Exec(q("INSERT"))
+					assert.NoError(t, err)
+					_, err = tx.Stmt(stmt).Exec()
+					assert.NoError(t, err)
+					if i%2 == 0 {
+						assert.NoError(t, tx.Commit())
+					} else {
+						assert.NoError(t, tx.Rollback())
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assertHooksOrder(t, log.entries)
+}
+
+// assertHooksOrder checks that, on each connection, every hooked driver call
+// is preceded by its Before hook and followed by its After hook, with no
+// other call or hook in between
+func assertHooksOrder(t *testing.T, entries []orderEntry) {
+	// The fake connection of every ConnID, told by the unique queries the
+	// driver and the hooks both see
+	conns := make(map[uint64]int)
+	for i, e := range entries {
+		if !e.hook || e.query == "" || !strings.ContainsAny(e.query, "0123456789") {
+			continue
+		}
+		for _, call := range entries[i+1:] {
+			if !call.hook && call.query == e.query {
+				if conn, ok := conns[e.connID]; ok {
+					require.Equal(t, conn, call.conn, "ConnID %d runs on several connections", e.connID)
+				}
+				conns[e.connID] = call.conn
+				break
+			}
+		}
+	}
+	require.NotEmpty(t, conns)
+
+	perConn := make(map[int][]orderEntry)
+	for _, e := range entries {
+		conn := e.conn
+		if e.hook {
+			var ok bool
+			if conn, ok = conns[e.connID]; !ok {
+				t.Fatalf("hook %s %q on unknown ConnID %d", e.event, e.query, e.connID)
+			}
+		}
+		perConn[conn] = append(perConn[conn], e)
+	}
+
+	for conn, entries := range perConn {
+		for i := 0; i < len(entries); i++ {
+			e := entries[i]
+			describe := func(e orderEntry) string {
+				return strconv.FormatBool(e.hook) + " " + e.event + " " + e.query
+			}
+
+			switch {
+			case !e.hook && e.event == "rowsclose":
+				require.True(t, i+1 < len(entries), "conn %d: no hook after %s", conn, describe(e))
+				next := entries[i+1]
+				assert.Equal(t, orderEntry{hook: true, connID: next.connID, event: "after rowsclose", query: e.query}, next, "conn %d", conn)
+				i++
+			case e.hook && strings.HasPrefix(e.event, "before "):
+				op := strings.TrimPrefix(e.event, "before ")
+				require.True(t, i+2 < len(entries), "conn %d: %s isn't completed", conn, describe(e))
+				call, after := entries[i+1], entries[i+2]
+				assert.Equal(t, orderEntry{conn: conn, event: op, query: e.query}, call, "conn %d: %s isn't followed by its call", conn, describe(e))
+				assert.Equal(t, orderEntry{hook: true, connID: e.connID, event: "after " + op, query: e.query}, after, "conn %d: %s isn't followed by its After", conn, describe(e))
+				i += 2
+			default:
+				t.Errorf("conn %d: unexpected %s at %d", conn, describe(e), i)
+			}
+		}
+	}
+}
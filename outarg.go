@@ -0,0 +1,89 @@
+package sqlhooks
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+)
+
+// IsOutArg reports whether arg, one of Context.Args, is an output parameter,
+// a sql.Out, named or not, as the RETURNING ... INTO :id binds of Oracle.
+// Its value only holds where the driver writes the output once the
+// statement ran, hooks logging arguments shouldn't render it as data.
+// It's always false before Go 1.9, which added sql.Out.
+func IsOutArg(arg interface{}) bool {
+	if named, ok := arg.(sql.NamedArg); ok {
+		arg = named.Value
+	}
+	return isOut(arg)
+}
+
+// namedValueChecker is driver.NamedValueChecker, declared for Go 1.8
+type namedValueChecker interface {
+	CheckNamedValue(*driver.NamedValue) error
+}
+
+// CheckNamedValue leaves the checks of the arguments to the underlying
+// driver, for it to accept its own types, as sql.Out for output parameters
+// or driver specific options. database/sql converts them as usual for
+// drivers without checks.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	c.recordArgType(nv)
+	if checker, ok := c.Conn.(namedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// CheckNamedValue leaves the checks to the underlying statement, or to the
+// connection, then to the driver.ColumnConverter of the statement, as
+// database/sql does. The statement doesn't implement ColumnConverter
+// itself, database/sql only uses it when CheckNamedValue skips.
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(namedValueChecker); ok {
+		s.conn.recordArgType(nv)
+		return checker.CheckNamedValue(nv)
+	}
+	err := s.conn.CheckNamedValue(nv)
+	if cc, ok := s.Stmt.(driver.ColumnConverter); ok && err == driver.ErrSkip {
+		return s.convertColumn(cc, nv)
+	}
+	return err
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// convertColumn converts nv with the converter of its column, as
+// database/sql does for the statements implementing driver.ColumnConverter
+func (s *stmt) convertColumn(cc driver.ColumnConverter, nv *driver.NamedValue) error {
+	index := nv.Ordinal - 1
+	if s.numInput >= 0 && s.numInput <= index {
+		return nil
+	}
+	if vr, ok := nv.Value.(driver.Valuer); ok {
+		var v driver.Value
+		// nil pointers to types with a value receiver Value are NULL
+		rv := reflect.ValueOf(vr)
+		if rv.Kind() != reflect.Ptr || !rv.IsNil() || !rv.Type().Elem().Implements(valuerType) {
+			var err error
+			if v, err = vr.Value(); err != nil {
+				return err
+			}
+		}
+		if !driver.IsValue(v) {
+			return fmt.Errorf("non-subset type %T returned from Value", v)
+		}
+		nv.Value = v
+	}
+	arg := nv.Value
+	v, err := cc.ColumnConverter(index).ConvertValue(arg)
+	if err != nil {
+		return err
+	}
+	if !driver.IsValue(v) {
+		return fmt.Errorf("driver ColumnConverter error converted %T to unsupported type %T", arg, v)
+	}
+	nv.Value = v
+	return nil
+}
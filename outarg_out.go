@@ -0,0 +1,11 @@
+//go:build go1.9
+// +build go1.9
+
+package sqlhooks
+
+import "database/sql"
+
+func isOut(arg interface{}) bool {
+	_, ok := arg.(sql.Out)
+	return ok
+}
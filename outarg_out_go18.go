@@ -0,0 +1,7 @@
+//go:build !go1.9
+// +build !go1.9
+
+package sqlhooks
+
+// sql.Out was added in Go 1.9
+func isOut(arg interface{}) bool { return false }
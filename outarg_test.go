@@ -0,0 +1,105 @@
+//go:build go1.9
+// +build go1.9
+
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// outConn mimics the out-binds of Oracle drivers: it accepts sql.Out
+// arguments and writes 42 to the ones named id once a statement ran
+type outConn struct {
+	anyConn
+}
+
+func (outConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if _, ok := nv.Value.(sql.Out); ok {
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+func (outConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return returnInto(args)
+}
+
+func (outConn) Prepare(query string) (driver.Stmt, error) { return outStmt{}, nil }
+
+type outStmt struct {
+	anyStmt
+}
+
+func (outStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return returnInto(args)
+}
+
+func returnInto(args []driver.NamedValue) (driver.Result, error) {
+	for _, arg := range args {
+		if out, ok := arg.Value.(sql.Out); ok && arg.Name == "id" {
+			dest, ok := out.Dest.(*int64)
+			if !ok {
+				return nil, errors.New("id isn't an *int64")
+			}
+			*dest = 42
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type outDriver struct{}
+
+func (outDriver) Open(string) (driver.Conn, error) { return outConn{}, nil }
+
+func TestOutArgs(t *testing.T) {
+	var records []EventRecordV1
+	var out []bool
+	record := Funcs{After: func(ctx *Context) error {
+		r, err := MarshalEvent(ctx)
+		require.NoError(t, err)
+		records = append(records, r)
+		for _, arg := range ctx.Args {
+			out = append(out, IsOutArg(arg))
+		}
+		return ctx.Error
+	}}
+	d := NewDriver("", &FuncHooks{Exec: record, StmtExec: record})
+	d.driver = outDriver{}
+	db, err := sql.Open(RegisterUnique("out", d), "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	const insert = "INSERT INTO t (n) VALUES (:n) RETURNING id INTO :id"
+	var id int64
+	_, err = db.Exec(insert, sql.Named("n", 1), sql.Named("id", sql.Out{Dest: &id}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), id, "the driver wrote the out-bind")
+
+	id = 0
+	stmt, err := db.Prepare(insert)
+	require.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Exec(sql.Named("n", 2), sql.Named("id", sql.Out{Dest: &id}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+
+	require.Len(t, records, 2)
+	assert.Equal(t, []string{"n=1", "id=<out>"}, records[0].Args, "out-binds aren't formatted")
+	assert.Equal(t, []string{"n=2", "id=<out>"}, records[1].Args)
+	assert.Equal(t, []bool{false, true, false, true}, out)
+	assert.False(t, IsOutArg(int64(1)))
+	assert.True(t, IsOutArg(sql.Out{Dest: &id}))
+
+	// Drivers without named arguments still refuse them
+	c, err := NewDriver("", &FuncHooks{}).wrap(context.Background(), "", &recordingConn{})
+	require.NoError(t, err)
+	_, err = c.(*conn).ExecContext(context.Background(), insert, []driver.NamedValue{{Name: "n", Ordinal: 1, Value: int64(1)}})
+	assert.EqualError(t, err, "sqlhooks: driver does not support the use of Named Parameters")
+}
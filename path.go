@@ -0,0 +1,63 @@
+package sqlhooks
+
+// Path is the driver interface the wrapper dispatched an operation to. The
+// legacy ones, without context, only check the context before the call, so
+// a deadline doesn't interrupt a statement running on them.
+type Path uint8
+
+const (
+	// PathNone is the Path of the operations the driver wasn't called for,
+	// as the synthetic ones
+	PathNone Path = iota
+
+	PathExecerContext
+	PathExecer
+	PathQueryerContext
+	PathQueryer
+
+	// PathStmt* are the paths of the executions of prepared statements
+	PathStmtExecContext
+	PathStmtExec
+	PathStmtQueryContext
+	PathStmtQuery
+
+	// PathPreparedFallback is the path of the statements the driver skipped
+	// and the wrapper prepared, see Queryer
+	PathPreparedFallback
+
+	// PathStmtCache is the path of the statements run with a cached
+	// statement, see WithStmtCache
+	PathStmtCache
+
+	PathPrepareContext
+	PathPrepare
+	PathBeginTx
+	PathBegin
+
+	pathCount
+)
+
+var pathNames = [pathCount]string{
+	PathNone:             "none",
+	PathExecerContext:    "execer_context",
+	PathExecer:           "execer",
+	PathQueryerContext:   "queryer_context",
+	PathQueryer:          "queryer",
+	PathStmtExecContext:  "stmt_exec_context",
+	PathStmtExec:         "stmt_exec",
+	PathStmtQueryContext: "stmt_query_context",
+	PathStmtQuery:        "stmt_query",
+	PathPreparedFallback: "prepared_fallback",
+	PathStmtCache:        "stmt_cache",
+	PathPrepareContext:   "prepare_context",
+	PathPrepare:          "prepare",
+	PathBeginTx:          "begin_tx",
+	PathBegin:            "begin",
+}
+
+func (p Path) String() string {
+	if p < pathCount {
+		return pathNames[p]
+	}
+	return "unknown"
+}
@@ -0,0 +1,110 @@
+package sqlhooks
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pathRecorder records the Path of every operation, by hook
+func pathRecorder(paths *[]string) *FuncHooks {
+	record := func(op string) Funcs {
+		return Funcs{After: func(ctx *Context) error {
+			*paths = append(*paths, op+" "+ctx.Path.String())
+			return ctx.Error
+		}}
+	}
+	return &FuncHooks{
+		Begin:    record("begin"),
+		Exec:     record("exec"),
+		Query:    record("query"),
+		Prepare:  record("prepare"),
+		StmtExec: record("stmtexec"),
+	}
+}
+
+// runPathOps runs an operation of each hooked kind on db
+func runPathOps(t *testing.T, db *sql.DB) {
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("UPDATE t SET n = 1")
+	require.NoError(t, err)
+	rows, err := tx.Query("SELECT n FROM t")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	stmt, err := tx.Prepare("DELETE FROM t")
+	require.NoError(t, err)
+	_, err = stmt.Exec()
+	require.NoError(t, err)
+	require.NoError(t, stmt.Close())
+	require.NoError(t, tx.Commit())
+}
+
+func TestPaths(t *testing.T) {
+	for _, test := range []struct {
+		mode  string
+		paths []string
+	}{
+		{drivertest.ModeContext, []string{
+			"begin begin_tx", "exec execer_context", "query queryer_context",
+			"prepare prepare_context", "stmtexec stmt_exec_context",
+		}},
+		{drivertest.ModeLegacy, []string{
+			"begin begin", "exec execer", "query queryer",
+			"prepare prepare", "stmtexec stmt_exec",
+		}},
+		// database/sql prepares the statements itself
+		{drivertest.ModePrepare, []string{
+			"begin begin",
+			"prepare prepare", "stmtexec stmt_exec",
+			"prepare prepare",
+			"prepare prepare", "stmtexec stmt_exec",
+		}},
+	} {
+		t.Run(test.mode, func(t *testing.T) {
+			var paths []string
+			d := NewDriver(drivertest.Name, pathRecorder(&paths))
+			db, err := sql.Open(RegisterUnique(drivertest.Name, d), test.mode+";"+t.Name())
+			require.NoError(t, err)
+			defer db.Close()
+
+			runPathOps(t, db)
+			assert.Equal(t, test.paths, paths)
+		})
+	}
+}
+
+func TestPathPreparedFallback(t *testing.T) {
+	var paths []string
+	db, dc := openSkipping(t, pathRecorder(&paths), "UPDATE t SET n = 1")
+	defer db.Close()
+
+	_, err := db.Exec("UPDATE t SET n = 1")
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE t SET n = 2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"exec prepared_fallback", "exec execer"}, paths)
+	assert.Equal(t, []string{"skip UPDATE t SET n = 1", "prepare UPDATE t SET n = 1", "exec []", "exec UPDATE t SET n = 2 []"}, dc.log)
+}
+
+func TestPathStats(t *testing.T) {
+	var paths []string
+	d := NewDriver(drivertest.Name, pathRecorder(&paths), WithOperations(OpBegin|OpPrepare))
+	db, err := sql.Open(RegisterUnique(drivertest.Name, d), "legacy;"+t.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	runPathOps(t, db)
+	var expected [pathCount]uint64
+	expected[PathBegin] = 1
+	expected[PathExecer] = 1
+	expected[PathQueryer] = 1
+	expected[PathPrepare] = 1
+	expected[PathStmtExec] = 1
+	assert.Equal(t, expected, d.Stats().Paths, "the operations without hooks are counted")
+	assert.Equal(t, []string{"begin begin", "prepare prepare"}, paths)
+	assert.Equal(t, "unknown", pathCount.String())
+}
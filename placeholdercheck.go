@@ -0,0 +1,22 @@
+package sqlhooks
+
+import "context"
+
+type skipPlaceholderCheckKey struct{}
+
+// SkipPlaceholderCheck returns a copy of ctx exempting the statements run
+// with it from placeholder style checks, as hooks/dialect does, for the
+// queries the check gets wrong
+func SkipPlaceholderCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipPlaceholderCheckKey{}, true)
+}
+
+// PlaceholderCheckSkipped reports whether ctx is exempted from placeholder
+// style checks by SkipPlaceholderCheck
+func PlaceholderCheckSkipped(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	skipped, _ := ctx.Value(skipPlaceholderCheckKey{}).(bool)
+	return skipped
+}
@@ -0,0 +1,51 @@
+package sqlhooks
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// WithPoolStats sets fn to return the stats of the *sql.DB using the Driver,
+// usually its Stats method, so the Begin hooks get Context.PoolWait:
+//
+//	var db *sql.DB
+//	d := sqlhooks.NewDriver("postgres", hooks, sqlhooks.WithPoolStats(func() sql.DBStats { return db.Stats() }))
+//	db = sql.OpenDB(...)
+//
+// PoolWait is an approximation: the average wait for a connection since the
+// previous Begin, from the WaitCount and WaitDuration deltas of the stats.
+// They count the waits of the whole pool rather than the one of the calling
+// Begin, so a Begin on a free connection can get the waits of other
+// operations, and the waits of concurrent Begins are averaged. Waits are only
+// counted by database/sql from Go 1.11 on, PoolWait is always 0 otherwise.
+// fn is called on every Begin having Begin hooks, from the goroutine calling it.
+func WithPoolStats(fn func() sql.DBStats) Option {
+	return func(d *Driver) {
+		d.pool = &poolWaits{stats: fn}
+	}
+}
+
+// poolWaits tells the pool waits from the stats of a *sql.DB
+type poolWaits struct {
+	stats func() sql.DBStats
+
+	mu       sync.Mutex
+	count    int64
+	duration time.Duration
+}
+
+// wait returns the average wait for a connection of the pool since the last
+// call, 0 if there was none
+func (p *poolWaits) wait() time.Duration {
+	count, duration := waitStats(p.stats())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	waits, waited := count-p.count, duration-p.duration
+	p.count, p.duration = count, duration
+	if waits <= 0 || waited <= 0 {
+		return 0
+	}
+	return waited / time.Duration(waits)
+}
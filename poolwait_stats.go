@@ -0,0 +1,14 @@
+//go:build go1.11
+// +build go1.11
+
+package sqlhooks
+
+import (
+	"database/sql"
+	"time"
+)
+
+// waitStats returns the number of waits for a connection counted by s, and their total duration
+func waitStats(s sql.DBStats) (int64, time.Duration) {
+	return s.WaitCount, s.WaitDuration
+}
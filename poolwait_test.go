@@ -0,0 +1,104 @@
+//go:build go1.11
+// +build go1.11
+
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolWait(t *testing.T) {
+	scripted := []sql.DBStats{
+		{},
+		{WaitCount: 2, WaitDuration: 30 * time.Millisecond},
+		{WaitCount: 2, WaitDuration: 30 * time.Millisecond},
+		{WaitCount: 5, WaitDuration: 90 * time.Millisecond},
+	}
+	stats := func() sql.DBStats {
+		s := scripted[0]
+		scripted = scripted[1:]
+		return s
+	}
+
+	var before, after []time.Duration
+	hooks := &FuncHooks{Begin: Funcs{
+		Before: func(ctx *Context) error {
+			before = append(before, ctx.PoolWait)
+			return nil
+		},
+		After: func(ctx *Context) error {
+			after = append(after, ctx.PoolWait)
+			return ctx.Error
+		},
+	}}
+	c, err := NewDriver("", hooks, WithPoolStats(stats)).wrap(context.Background(), "", &anyConn{})
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		tx, err := c.(*conn).BeginTx(context.Background(), driver.TxOptions{})
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+	}
+
+	expected := []time.Duration{0, 15 * time.Millisecond, 0, 20 * time.Millisecond}
+	assert.Equal(t, expected, before)
+	assert.Equal(t, expected, after)
+}
+
+func TestPoolWaitDisabled(t *testing.T) {
+	var waits []time.Duration
+	hooks := &FuncHooks{Begin: Funcs{After: func(ctx *Context) error {
+		waits = append(waits, ctx.PoolWait)
+		return ctx.Error
+	}}}
+	c, err := NewDriver("", hooks).wrap(context.Background(), "", &anyConn{})
+	require.NoError(t, err)
+
+	tx, err := c.(*conn).BeginTx(context.Background(), driver.TxOptions{})
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, []time.Duration{0}, waits)
+}
+
+func TestPoolWaitThroughDB(t *testing.T) {
+	var db *sql.DB
+	var waits []time.Duration
+	d := NewDriver("", &FuncHooks{Begin: Funcs{After: func(ctx *Context) error {
+		waits = append(waits, ctx.PoolWait)
+		return ctx.Error
+	}}}, WithPoolStats(func() sql.DBStats { return db.Stats() }))
+	d.driver = recordingDriver{&recordingConn{}}
+	db, err := sql.Open(RegisterUnique("poolwait", d), "")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	held, err := db.Begin()
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		// Waits for held to release the only connection
+		tx, err := db.Begin()
+		if err == nil {
+			err = tx.Commit()
+		}
+		done <- err
+	}()
+	for db.Stats().WaitCount == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, held.Commit())
+	require.NoError(t, <-done)
+
+	require.Len(t, waits, 2)
+	assert.Equal(t, time.Duration(0), waits[0])
+	assert.True(t, waits[1] >= 10*time.Millisecond, "waited %s", waits[1])
+}
@@ -0,0 +1,50 @@
+package sqlhooks
+
+import (
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nonPortable are the packages the core doesn't import, as they're missing
+// or limited on js/wasm and TinyGo: features needing them go to the hooks
+// packages, or to files with build constraints
+var nonPortable = []string{
+	"net", "net/http", "os", "os/exec", "os/signal", "plugin",
+	"runtime", "runtime/debug", "runtime/pprof", "syscall", "unsafe",
+}
+
+func TestCoreImportsArePortable(t *testing.T) {
+	for _, target := range [][2]string{{"js", "wasm"}, {"wasip1", "wasm"}, {runtime.GOOS, runtime.GOARCH}} {
+		ctx := build.Default
+		ctx.GOOS, ctx.GOARCH = target[0], target[1]
+		pkg, err := ctx.ImportDir(".", 0)
+		require.NoError(t, err)
+		for _, path := range nonPortable {
+			assert.NotContains(t, pkg.Imports, path, "%s/%s", target[0], target[1])
+		}
+	}
+}
+
+// TestCoreBuildsForWasm builds and vets the core, tests included, for
+// js/wasm, without running anything
+func TestCoreBuildsForWasm(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the package")
+	}
+	goTool := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err := os.Stat(goTool); err != nil {
+		t.Skip("no go tool: ", err)
+	}
+
+	cmd := exec.Command(goTool, "vet", ".")
+	cmd.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm")
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, "%s", out)
+}
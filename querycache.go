@@ -0,0 +1,138 @@
+package sqlhooks
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultQueryCacheSize is the number of queries whose classification,
+// fingerprint and placeholders count are cached, unless SetQueryCacheSize
+// says otherwise
+const DefaultQueryCacheSize = 4096
+
+const (
+	// queryCacheShards is the number of independently locked parts of the cache
+	queryCacheShards = 16
+
+	// maxCachedQuery is the length of the longest query cached, longer ones
+	// are unlikely to repeat, as queries with literal values inlined
+	maxCachedQuery = 4 << 10
+)
+
+// analyses is the cache shared by Classify, Fingerprint and Placeholders
+var analyses = newQueryCache(DefaultQueryCacheSize)
+
+// SetQueryCacheSize sets how many queries have their Classify, Fingerprint
+// and Placeholders results cached, an entry per distinct query string.
+// Once the cache is full, a random entry is evicted for every new query.
+// 0 disables the cache. Queries longer than 4KiB are never cached.
+func SetQueryCacheSize(size int) {
+	analyses.resize(size)
+}
+
+// queryInfo memoizes what's computed from a query, each on first use
+type queryInfo struct {
+	classifyOnce sync.Once
+	operation    string
+	table        string
+
+	fingerprintOnce sync.Once
+	fingerprint     string
+
+	placeholdersOnce sync.Once
+	placeholders     int
+}
+
+type queryCache struct {
+	// perShard is the number of entries of a shard
+	perShard int64
+	shards   [queryCacheShards]queryCacheShard
+}
+
+type queryCacheShard struct {
+	mu      sync.RWMutex
+	entries map[string]*queryInfo
+}
+
+func newQueryCache(size int) *queryCache {
+	c := &queryCache{}
+	for i := range c.shards {
+		c.shards[i].entries = make(map[string]*queryInfo)
+	}
+	c.resize(size)
+	return c
+}
+
+func (c *queryCache) resize(size int) {
+	perShard := 0
+	if size > 0 {
+		perShard = (size + queryCacheShards - 1) / queryCacheShards
+	}
+	atomic.StoreInt64(&c.perShard, int64(perShard))
+
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		for query := range s.entries {
+			if len(s.entries) <= perShard {
+				break
+			}
+			delete(s.entries, query)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// get returns the queryInfo of query, nil if it isn't cached
+func (c *queryCache) get(query string) *queryInfo {
+	perShard := int(atomic.LoadInt64(&c.perShard))
+	if perShard == 0 || len(query) > maxCachedQuery {
+		return nil
+	}
+
+	s := &c.shards[shardOf(query)]
+	s.mu.RLock()
+	info := s.entries[query]
+	s.mu.RUnlock()
+	if info != nil {
+		return info
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info = s.entries[query]; info != nil {
+		return info
+	}
+	if len(s.entries) >= perShard {
+		// map iteration starts at a random entry
+		for evicted := range s.entries {
+			delete(s.entries, evicted)
+			break
+		}
+	}
+	info = &queryInfo{}
+	s.entries[query] = info
+	return info
+}
+
+// len returns the number of cached queries
+func (c *queryCache) len() int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		n += len(s.entries)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// shardOf returns the shard of query, hashed with FNV-1a
+func shardOf(query string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(query); i++ {
+		h ^= uint32(query[i])
+		h *= 16777619
+	}
+	return int(h % queryCacheShards)
+}
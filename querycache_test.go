@@ -0,0 +1,70 @@
+package sqlhooks
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryCache(t *testing.T) {
+	c := newQueryCache(32)
+	info := c.get("SELECT 1")
+	assert.NotNil(t, info)
+	assert.True(t, info == c.get("SELECT 1"), "the entry is reused")
+	assert.Equal(t, 1, c.len())
+
+	for i := 0; i < 1000; i++ {
+		c.get(fmt.Sprintf("SELECT %d", i))
+	}
+	assert.True(t, c.len() <= 32, "%d entries", c.len())
+
+	assert.Nil(t, c.get(strings.Repeat("x", maxCachedQuery+1)))
+
+	c.resize(0)
+	assert.Zero(t, c.len())
+	assert.Nil(t, c.get("SELECT 1"))
+}
+
+func TestQueryCacheMemoizes(t *testing.T) {
+	defer SetQueryCacheSize(DefaultQueryCacheSize)
+	query := "SELECT * FROM users WHERE id = $1 AND name = 'x'"
+
+	for _, size := range []int{DefaultQueryCacheSize, 0} {
+		SetQueryCacheSize(size)
+		for i := 0; i < 2; i++ {
+			operation, table := Classify(query)
+			assert.Equal(t, "select", operation)
+			assert.Equal(t, "users", table)
+			assert.Equal(t, "SELECT * FROM users WHERE id = $1 AND name = ?", Fingerprint(query))
+			assert.Equal(t, 1, Placeholders(query))
+		}
+	}
+
+	SetQueryCacheSize(DefaultQueryCacheSize)
+	Fingerprint(query)
+	info := analyses.get(query)
+	assert.Equal(t, "SELECT * FROM users WHERE id = $1 AND name = ?", info.fingerprint)
+	assert.Empty(t, info.operation, "only the fingerprint was computed")
+}
+
+func TestQueryCacheConcurrently(t *testing.T) {
+	c := newQueryCache(16)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				query := fmt.Sprintf("SELECT %d", (g*i)%40)
+				info := c.get(query)
+				info.fingerprintOnce.Do(func() { info.fingerprint = fingerprint(query) })
+				assert.Equal(t, "SELECT ?", info.fingerprint)
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.True(t, c.len() <= 16)
+}
@@ -0,0 +1,95 @@
+//go:build go1.10
+// +build go1.10
+
+package sqlhooks
+
+import (
+	"database/sql/driver"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReconnect kills the connection of a workload over and over: every
+// replacement must be connected with the hooks, get a ConnID and ConnData of
+// its own, and the dead ones must be released
+func TestReconnect(t *testing.T) {
+	cycles := 10000
+	if testing.Short() {
+		cycles = 500
+	}
+
+	// context connections report they were killed from IsValid, the legacy
+	// ones fail the next statement with driver.ErrBadConn
+	for _, test := range []struct {
+		mode     string
+		badConns int
+	}{
+		{drivertest.ModeContext, 0},
+		{drivertest.ModeLegacy, cycles},
+	} {
+		mode := test.mode
+		t.Run(mode, func(t *testing.T) {
+			var connects, released int64
+			onConnect := WithOnConnect(func(ctx *Context, c driver.Conn) error {
+				atomic.AddInt64(&connects, 1)
+				runtime.SetFinalizer(c, func(interface{}) { atomic.AddInt64(&released, 1) })
+				return nil
+			})
+
+			// statements counts the statements of every ConnID, as kept in its ConnData
+			statements := make(map[uint64]int)
+			badConns := 0
+			count := func(ctx *Context) error {
+				if ctx.Error == driver.ErrBadConn {
+					badConns++
+				}
+				n, _ := ctx.Conn().Get("statements").(int)
+				n++
+				ctx.Conn().Set("statements", n)
+				assert.Equal(t, statements[ctx.ConnID]+1, n, "ConnData of connection %d", ctx.ConnID)
+				statements[ctx.ConnID] = n
+				return ctx.Error
+			}
+			hooks := &FuncHooks{Exec: Funcs{After: count}, StmtExec: Funcs{After: count}}
+			db, err := Open(drivertest.Name, mode+";"+t.Name(), hooks, onConnect)
+			require.NoError(t, err)
+			db.SetMaxOpenConns(1)
+			d := db.Driver().(*Driver)
+
+			stmt, err := db.Prepare("UPDATE t SET n = n + 1")
+			require.NoError(t, err)
+			for i := 0; i < cycles; i++ {
+				_, err := stmt.Exec()
+				require.NoError(t, err)
+				_, err = db.Exec("KILL|INSERT INTO t VALUES (1)")
+				require.NoError(t, err)
+			}
+			_, err = db.Exec("INSERT INTO t VALUES (2)")
+			require.NoError(t, err)
+
+			assert.Equal(t, int64(cycles+1), atomic.LoadInt64(&connects), "a connection per kill")
+			assert.Len(t, statements, cycles+1, "a ConnID per connection")
+			assert.Equal(t, test.badConns, badConns, "statements run on a killed connection")
+
+			require.NoError(t, stmt.Close())
+			require.NoError(t, db.Close())
+			assert.Equal(t, int64(0), d.Stats().OpenStmts)
+			assert.Equal(t, int64(0), d.Stats().OpenTxs)
+
+			// The finalizers of the dead connections run once the wrapper
+			// keeps none of them
+			deadline := time.Now().Add(5 * time.Second)
+			for atomic.LoadInt64(&released) < int64(cycles) && time.Now().Before(deadline) {
+				runtime.GC()
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.True(t, atomic.LoadInt64(&released) >= int64(cycles), "%d connections released out of %d", atomic.LoadInt64(&released), cycles)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+//go:build go1.10
+// +build go1.10
+
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WrapConnectorFunc returns a connector getting the connector of every new
+// connection from refresh, and attaching hooks to the connections, so
+// credentials expiring, as the RDS IAM authentication tokens do, are
+// regenerated for the connections that need them:
+//
+//	db := sql.OpenDB(sqlhooks.WrapConnectorFunc(func(ctx context.Context) (driver.Connector, error) {
+//		token, err := rdsutils.BuildAuthToken(endpoint, region, user, creds)
+//		if err != nil {
+//			return nil, err
+//		}
+//		c, err := pq.NewConnector(dsn(user, token))
+//		return sqlhooks.ConnectorWithExpiry(c, time.Now().Add(14*time.Minute)), err
+//	}, hooks))
+//
+// A connector given an expiry with ConnectorWithExpiry is reused until then,
+// refresh is called for every connection otherwise. It's also dropped as soon
+// as a connection fails with ErrorClassAuth, so the next one refreshes it
+// whatever its expiry. Concurrent connections wait for the same refresh.
+func WrapConnectorFunc(refresh func(ctx context.Context) (driver.Connector, error), hooks HookType, opts ...Option) driver.Connector {
+	return &refreshingConnector{d: NewDriver("", hooks, opts...), refresh: refresh, now: time.Now}
+}
+
+// ConnectorWithExpiry returns c, to be reused by WrapConnectorFunc until expiry
+func ConnectorWithExpiry(c driver.Connector, expiry time.Time) driver.Connector {
+	return expiringConnector{c, expiry}
+}
+
+type expiringConnector struct {
+	driver.Connector
+	expiry time.Time
+}
+
+type refreshingConnector struct {
+	d       *Driver
+	refresh func(ctx context.Context) (driver.Connector, error)
+	now     func() time.Time
+
+	// mu guards current, expiry and refreshes, and is held during refresh
+	mu      sync.Mutex
+	current driver.Connector
+	expiry  time.Time
+	// refreshes numbers the connectors refresh returned
+	refreshes uint64
+}
+
+// connector returns the current connector and its number, refreshing it once
+// it expired
+func (c *refreshingConnector) connector(ctx context.Context) (driver.Connector, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current != nil && c.now().Before(c.expiry) {
+		return c.current, c.refreshes, nil
+	}
+	c.current = nil
+
+	connector, err := c.refresh(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("sqlhooks: refreshing connector: %v", err)
+	}
+	if connector == nil {
+		return nil, 0, fmt.Errorf("sqlhooks: refreshing connector: no connector returned")
+	}
+	c.refreshes++
+	if e, ok := connector.(expiringConnector); ok {
+		c.current, c.expiry = e.Connector, e.expiry
+		return e.Connector, c.refreshes, nil
+	}
+	return connector, c.refreshes, nil
+}
+
+// drop drops the connector numbered refresh if it's still the current one
+func (c *refreshingConnector) drop(refresh uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshes == refresh {
+		c.current = nil
+	}
+}
+
+func (c *refreshingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connector, refresh, err := c.connector(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_conn, err := connector.Connect(ctx)
+	if err != nil {
+		if ClassifyError(err) == ErrorClassAuth {
+			c.drop(refresh)
+		}
+		return nil, err
+	}
+	return c.d.wrap(ctx, "", _conn)
+}
+
+func (c *refreshingConnector) Driver() driver.Driver {
+	return c.d
+}
@@ -0,0 +1,173 @@
+//go:build go1.10
+// +build go1.10
+
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenConnector connects with token, or fails with err
+type tokenConnector struct {
+	token int
+	err   error
+}
+
+func (c *tokenConnector) Connect(context.Context) (driver.Conn, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &recordingConn{}, nil
+}
+
+func (c *tokenConnector) Driver() driver.Driver { return nil }
+
+// refresher returns a new tokenConnector valid for ttl at every refresh
+type refresher struct {
+	mu      sync.Mutex
+	now     time.Time
+	ttl     time.Duration
+	err     error
+	connErr error
+	tokens  []int
+	calls   int32
+}
+
+func (r *refresher) refresh(ctx context.Context) (driver.Connector, error) {
+	atomic.AddInt32(&r.calls, 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	c := &tokenConnector{token: len(r.tokens) + 1, err: r.connErr}
+	r.tokens = append(r.tokens, c.token)
+	if r.ttl == 0 {
+		return c, nil
+	}
+	return ConnectorWithExpiry(c, r.now.Add(r.ttl)), nil
+}
+
+func (r *refresher) clock() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.now
+}
+
+func (r *refresher) advance(d time.Duration) {
+	r.mu.Lock()
+	r.now = r.now.Add(d)
+	r.mu.Unlock()
+}
+
+func newRefreshingConnector(r *refresher, hooks HookType) *refreshingConnector {
+	c := WrapConnectorFunc(r.refresh, hooks).(*refreshingConnector)
+	c.now = r.clock
+	return c
+}
+
+func TestWrapConnectorFuncRefreshesOnExpiry(t *testing.T) {
+	r := &refresher{now: time.Unix(1000, 0), ttl: 15 * time.Minute}
+	var execs []string
+	c := newRefreshingConnector(r, &FuncHooks{Exec: Funcs{After: func(ctx *Context) error {
+		execs = append(execs, ctx.Query)
+		return ctx.Error
+	}}})
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := c.Connect(ctx)
+		require.NoError(t, err)
+		r.advance(time.Minute)
+	}
+	assert.Equal(t, []int{1}, r.tokens)
+
+	r.advance(15 * time.Minute)
+	hooked, err := c.Connect(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, r.tokens)
+
+	// The connections are hooked
+	_, err = hooked.(*conn).ExecContext(ctx, "SELECT 1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SELECT 1"}, execs)
+}
+
+func TestWrapConnectorFuncWithoutExpiry(t *testing.T) {
+	r := &refresher{}
+	c := newRefreshingConnector(r, nil)
+	for i := 0; i < 3; i++ {
+		_, err := c.Connect(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []int{1, 2, 3}, r.tokens)
+}
+
+func TestWrapConnectorFuncRefreshFailure(t *testing.T) {
+	r := &refresher{now: time.Unix(1000, 0), ttl: time.Minute, err: errors.New("no credentials")}
+	c := newRefreshingConnector(r, nil)
+
+	_, err := c.Connect(context.Background())
+	assert.EqualError(t, err, "sqlhooks: refreshing connector: no credentials")
+
+	// The next connection tries again
+	r.err = nil
+	_, err = c.Connect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), r.calls)
+}
+
+func TestWrapConnectorFuncDropsRejectedCredentials(t *testing.T) {
+	expired := pqError{"28P01", "PAM authentication failed for user \"app\""}
+	r := &refresher{now: time.Unix(1000, 0), ttl: 15 * time.Minute, connErr: expired}
+	c := newRefreshingConnector(r, nil)
+
+	_, err := c.Connect(context.Background())
+	assert.Equal(t, expired, err)
+	assert.Equal(t, ErrorClassAuth, ClassifyError(err))
+
+	r.connErr = nil
+	_, err = c.Connect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, r.tokens, "refreshed before its expiry")
+
+	// Other failures keep the connector
+	c.current.(*tokenConnector).err = driver.ErrBadConn
+	_, err = c.Connect(context.Background())
+	assert.Equal(t, driver.ErrBadConn, err)
+	c.current.(*tokenConnector).err = nil
+	_, err = c.Connect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, r.tokens)
+}
+
+func TestWrapConnectorFuncConcurrentConnects(t *testing.T) {
+	r := &refresher{now: time.Unix(1000, 0), ttl: 15 * time.Minute}
+	c := newRefreshingConnector(r, &FuncHooks{})
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := db.Conn(context.Background())
+			if assert.NoError(t, err) {
+				assert.NoError(t, conn.Close())
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&r.calls))
+}
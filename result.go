@@ -0,0 +1,114 @@
+package sqlhooks
+
+import (
+	"database/sql/driver"
+	"sync"
+)
+
+// ResultErrorHook is the interface implemented by objects that wants to
+// hook to the errors of the driver.Result of the Exec and StmtExec
+// statements, with WithResultWrapping: some drivers only fail at
+// LastInsertId, as lib/pq always does, or RowsAffected, once the After
+// hooks ran. ResultError is called with the query executed, the method
+// which failed, "LastInsertId" or "RowsAffected", and its error, the first
+// time it's called.
+type ResultErrorHook interface {
+	ResultError(query string, method string, err error)
+}
+
+func isResultErrorHook(h HookType) bool {
+	_, ok := h.(ResultErrorHook)
+	return ok
+}
+
+// WithResultWrapping sets whether the driver.Result of the statements is
+// wrapped for the ResultErrorHook hooks, it's only when hooks implement it.
+// The values and errors of LastInsertId and RowsAffected are then read from
+// the driver once, and returned again to the following calls, the ones of
+// the After hooks through Context.Result included. Disabled by default.
+func WithResultWrapping(enabled bool) Option {
+	return func(d *Driver) {
+		d.resultWrapping = enabled
+	}
+}
+
+// result is a driver.Result wrapped by WithResultWrapping
+type result struct {
+	driver.Result
+	hook  ResultErrorHook
+	query string
+
+	// The After hooks run asynchronously can read the values along with the application
+	mu                         sync.Mutex
+	lastInsertID, rowsAffected resultValue
+}
+
+// resultValue is the memoized value of a driver.Result method
+type resultValue struct {
+	done bool
+	n    int64
+	err  error
+}
+
+// wrapResult returns res wrapped for the ResultErrorHook hooks, res itself
+// without WithResultWrapping or such hooks
+func (c *conn) wrapResult(hooks HookType, query string, res driver.Result) driver.Result {
+	t, ok := hooks.(ResultErrorHook)
+	if res == nil || !c.resultWrapping || !ok || !implementsHook(hooks, isResultErrorHook) {
+		return res
+	}
+	return &result{Result: res, hook: t, query: query}
+}
+
+func (r *result) LastInsertId() (int64, error) {
+	return r.value(&r.lastInsertID, "LastInsertId", r.Result.LastInsertId)
+}
+
+func (r *result) RowsAffected() (int64, error) {
+	return r.value(&r.rowsAffected, "RowsAffected", r.Result.RowsAffected)
+}
+
+// value returns v, read with fn the first time, when an error is reported
+// to the hook
+func (r *result) value(v *resultValue, method string, fn func() (int64, error)) (int64, error) {
+	r.mu.Lock()
+	if v.done {
+		r.mu.Unlock()
+		return v.n, v.err
+	}
+	v.done = true
+	v.n, v.err = fn()
+	n, err := v.n, v.err
+	r.mu.Unlock()
+
+	if err != nil {
+		r.hook.ResultError(r.query, method, err)
+	}
+	return n, err
+}
+
+func (hs composed) ResultError(query string, method string, err error) {
+	for _, h := range hs {
+		if t, ok := h.(ResultErrorHook); ok {
+			t.ResultError(query, method, err)
+		}
+	}
+}
+
+func (r *router) ResultError(query string, method string, err error) {
+	if t, ok := r.hooks((&Context{Query: query}).Kind()).(ResultErrorHook); ok {
+		t.ResultError(query, method, err)
+	}
+}
+
+func (s *sampler) ResultError(query string, method string, err error) {
+	if t, ok := s.hooks.(ResultErrorHook); ok {
+		t.ResultError(query, method, err)
+	}
+}
+
+func (a *async) ResultError(query string, method string, err error) {
+	if t, ok := a.hooks.(ResultErrorHook); ok {
+		a.push(0, func() { t.ResultError(query, method, err) })
+	}
+}
@@ -0,0 +1,141 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errNoInsertID = errors.New("LastInsertId is not supported by this driver")
+	errNoAffected = errors.New("no RowsAffected available after DDL")
+)
+
+// failingResult fails both methods, counting the calls
+type failingResult struct {
+	calls *int
+}
+
+func (r failingResult) LastInsertId() (int64, error) {
+	*r.calls++
+	return 0, errNoInsertID
+}
+
+func (r failingResult) RowsAffected() (int64, error) {
+	*r.calls++
+	return 0, errNoAffected
+}
+
+// resultConn returns failing results from its Exec and the ones of its statements
+type resultConn struct {
+	anyConn
+	calls *int
+}
+
+func (c resultConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return failingResult{c.calls}, nil
+}
+
+func (c resultConn) Prepare(query string) (driver.Stmt, error) {
+	return resultStmt{calls: c.calls}, nil
+}
+
+type resultStmt struct {
+	anyStmt
+	calls *int
+}
+
+func (s resultStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return failingResult{s.calls}, nil
+}
+
+// resultHooks records the ResultError calls
+type resultHooks struct {
+	FuncHooks
+	errors []string
+}
+
+func (h *resultHooks) ResultError(query string, method string, err error) {
+	h.errors = append(h.errors, query+" "+method+": "+err.Error())
+}
+
+func TestResultError(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		exec func(driver.Conn) (driver.Result, error)
+	}{
+		{"Exec", func(c driver.Conn) (driver.Result, error) {
+			return c.(driver.Execer).Exec("INSERT INTO t VALUES (1)", nil)
+		}},
+		{"StmtExec", func(c driver.Conn) (driver.Result, error) {
+			s, err := c.Prepare("INSERT INTO t VALUES (1)")
+			if err != nil {
+				return nil, err
+			}
+			return s.Exec(nil)
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			var afterAffected error
+			hooks := &resultHooks{}
+			after := func(ctx *Context) error {
+				_, afterAffected = ctx.Result.RowsAffected()
+				return ctx.Error
+			}
+			hooks.Exec.After, hooks.StmtExec.After = after, after
+			c, err := NewDriver("", hooks, WithResultWrapping(true)).wrap(context.Background(), "", resultConn{calls: &calls})
+			require.NoError(t, err)
+
+			res, err := test.exec(c)
+			require.NoError(t, err)
+			assert.Equal(t, errNoAffected, afterAffected)
+			for i := 0; i < 2; i++ {
+				_, err = res.LastInsertId()
+				assert.Equal(t, errNoInsertID, err)
+				_, err = res.RowsAffected()
+				assert.Equal(t, errNoAffected, err)
+			}
+
+			// Each method reached the driver once, the After hook's call included
+			assert.Equal(t, 2, calls)
+			assert.Equal(t, []string{
+				"INSERT INTO t VALUES (1) RowsAffected: " + errNoAffected.Error(),
+				"INSERT INTO t VALUES (1) LastInsertId: " + errNoInsertID.Error(),
+			}, hooks.errors)
+		})
+	}
+}
+
+func TestResultErrorDisabled(t *testing.T) {
+	var calls int
+	hooks := &resultHooks{}
+	c, err := NewDriver("", hooks).wrap(context.Background(), "", resultConn{calls: &calls})
+	require.NoError(t, err)
+
+	res, err := c.(driver.Execer).Exec("INSERT INTO t VALUES (1)", nil)
+	require.NoError(t, err)
+	res.LastInsertId()
+	res.LastInsertId()
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, hooks.errors)
+	assert.IsType(t, failingResult{}, res)
+}
+
+func TestResultErrorForwarded(t *testing.T) {
+	updates, reads := &resultHooks{}, &resultHooks{}
+	hooks := Compose(&FuncHooks{}, Route(map[Kind]HookType{KindUpdate: updates}, reads))
+	var calls int
+	c, err := NewDriver("", hooks, WithResultWrapping(true)).wrap(context.Background(), "", resultConn{calls: &calls})
+	require.NoError(t, err)
+
+	res, err := c.(driver.Execer).Exec("UPDATE t SET n = 1", nil)
+	require.NoError(t, err)
+	res.RowsAffected()
+	assert.Equal(t, []string{"UPDATE t SET n = 1 RowsAffected: " + errNoAffected.Error()}, updates.errors)
+	assert.Empty(t, reads.errors)
+}
@@ -0,0 +1,204 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RetryMode tells how the hooks get the attempts database/sql makes at a
+// statement, see WithRetries
+type RetryMode int
+
+const (
+	// RetriesSeparate runs the hooks of every attempt as the ones of any
+	// statement, the default
+	RetriesSeparate RetryMode = iota
+
+	// RetriesTagged runs the hooks of every attempt, setting
+	// Context.Attempts, and Context.Retry for the attempts after the first
+	RetriesTagged
+
+	// RetriesCollapsed runs the hooks once for all the attempts: the Before
+	// hooks for the first one, the After hooks for the last one, with the
+	// Context of the first attempt, Context.Attempts counting them
+	RetriesCollapsed
+)
+
+// maxAttempts is the number of attempts database/sql makes at a statement
+// failing with driver.ErrBadConn, its maxBadConnRetries plus one
+const maxAttempts = 3
+
+// retryWindow is how long an attempt failing with driver.ErrBadConn waits
+// for its retry, tests shorten it
+var retryWindow = time.Second
+
+// WithRetries sets how the hooks get the attempts database/sql makes at a
+// statement: when the driver fails it with driver.ErrBadConn, database/sql
+// runs it again on another connection, up to 3 times in all, and a single
+// db.Exec runs the hooks as many times.
+//
+// database/sql doesn't tell it's retrying: a statement is taken for the
+// retry of one which failed with driver.ErrBadConn less than a second
+// before when it's issued with the same context.Context, query and
+// arguments. The statements run within a transaction or on a sql.Conn,
+// which database/sql doesn't retry, and the ones database/sql gives up on
+// before they reach the driver, when it can't get another connection,
+// aren't retried: with RetriesCollapsed, the After hooks of their failed
+// attempt run a second after it, from another goroutine. Two statements
+// issued concurrently with context.Background and the same query and
+// arguments can be taken for attempts of a single one.
+//
+// Only the Query, Exec and Stmt hooks are concerned, the statements
+// database/sql prepares again for a retry run their Prepare hooks again.
+func WithRetries(mode RetryMode) Option {
+	return func(d *Driver) {
+		if mode == RetriesSeparate {
+			d.retries = nil
+			return
+		}
+		d.retries = &retries{mode: mode, pending: make(map[retryKey]*pendingRetry)}
+	}
+}
+
+// retries tracks the attempts failing with driver.ErrBadConn of the
+// statements of a Driver, for their retries to be told apart
+type retries struct {
+	mode RetryMode
+
+	mu      sync.Mutex
+	pending map[retryKey]*pendingRetry
+}
+
+type retryKey struct {
+	ctx   context.Context
+	query string
+}
+
+// pendingRetry is an attempt waiting for its retry, ctx and after are set
+// when its After hooks are held
+type pendingRetry struct {
+	args     []driver.Value
+	attempts int
+	ctx      *Context
+	after    func()
+	timer    *time.Timer
+}
+
+// key returns the key of the statement query issued with stdCtx, false for
+// the contexts which can't be compared
+func (r *retries) key(stdCtx context.Context, query string) (retryKey, bool) {
+	if stdCtx == nil || !reflect.TypeOf(stdCtx).Comparable() {
+		return retryKey{}, false
+	}
+	return retryKey{stdCtx, query}, true
+}
+
+// startAttempt returns the number of the attempt at query with args, issued with
+// stdCtx, 0 without WithRetries, and the Context of the previous attempt
+// when its After hooks are held for this one
+func (c *conn) startAttempt(stdCtx context.Context, query string, args []driver.Value) (int, *Context) {
+	r := c.retries
+	if r == nil {
+		return 0, nil
+	}
+	key, ok := r.key(stdCtx, query)
+	if !ok || c.txID != 0 {
+		return 1, nil
+	}
+
+	r.mu.Lock()
+	p := r.pending[key]
+	if p == nil || !reflect.DeepEqual(p.args, args) {
+		r.mu.Unlock()
+		return 1, nil
+	}
+	delete(r.pending, key)
+	r.mu.Unlock()
+
+	if p.timer != nil && !p.timer.Stop() {
+		// The After hooks are running, they didn't wait for this attempt
+		return p.attempts + 1, nil
+	}
+	return p.attempts + 1, p.ctx
+}
+
+// failedAttempt records the attempt number attempts at query, with args,
+// issued with stdCtx, which failed with err. It reports whether the After
+// hooks of ctx are held for its retry, after runs them if the retry doesn't
+// come.
+func (c *conn) failedAttempt(stdCtx context.Context, query string, args []driver.Value, attempts int, err error, ctx *Context, after func()) bool {
+	r := c.retries
+	if r == nil || err != driver.ErrBadConn || attempts >= maxAttempts || c.txID != 0 || stdCtx.Err() != nil {
+		return false
+	}
+	key, ok := r.key(stdCtx, query)
+	if !ok {
+		return false
+	}
+
+	p := &pendingRetry{args: args, attempts: attempts}
+	held := r.mode == RetriesCollapsed && ctx != nil
+	if held {
+		p.ctx, p.after = ctx, after
+		p.timer = time.AfterFunc(retryWindow, func() { r.expire(key, p) })
+	}
+
+	r.mu.Lock()
+	previous := r.pending[key]
+	r.pending[key] = p
+	r.mu.Unlock()
+	if !held {
+		// The entry only counts the attempts, it expires with the next one
+		// failing, or it's found by its retry
+		time.AfterFunc(retryWindow, func() { r.expire(key, p) })
+	}
+	if previous != nil && previous.timer != nil && previous.timer.Stop() {
+		previous.after()
+	}
+	return held
+}
+
+// expire forgets p, the retry of which didn't come, running its held After hooks
+func (r *retries) expire(key retryKey, p *pendingRetry) {
+	r.mu.Lock()
+	if r.pending[key] == p {
+		delete(r.pending, key)
+	}
+	r.mu.Unlock()
+	if p.after != nil {
+		p.after()
+	}
+}
+
+// retried moves ctx, the Context of a previous attempt, to c for its retry
+func (c *conn) retried(ctx *Context, attempts int) {
+	ctx.ConnID = c.id
+	ctx.conn = &c.data
+	ctx.ServerInfo = c.serverInfo
+	ctx.Peer = c.peer
+	ctx.Schema = c.data.schema
+	ctx.Seq = atomic.AddUint64(&c.seq, 1)
+	ctx.ConnStatement = c.nextStatement()
+	ctx.attempted(attempts)
+}
+
+// attempted sets the Attempts of ctx, attempts is 0 without WithRetries
+func (ctx *Context) attempted(attempts int) {
+	if ctx != nil && attempts > 0 {
+		ctx.Attempts = attempts
+		ctx.Retry = attempts > 1
+	}
+}
+
+// heldArgs returns the arguments to run the retry of ctx with, the ones its
+// Before hooks set when they're visible, see WithArgVisibility
+func (c *conn) heldArgs(ctx *Context, args []driver.Value) []driver.Value {
+	if !c.collapseInLists && c.argsVisible() {
+		return interfaceToDriver(ctx.Args)
+	}
+	return args
+}
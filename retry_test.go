@@ -0,0 +1,190 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// attempt is what retryHooks records of a Context
+type attempt struct {
+	phase       string
+	statementID uint64
+	connID      uint64
+	attempts    int
+	retry       bool
+	err         error
+}
+
+type retryHooks struct {
+	mu       sync.Mutex
+	attempts []attempt
+}
+
+func (h *retryHooks) record(phase string) func(*Context) error {
+	return func(ctx *Context) error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.attempts = append(h.attempts, attempt{phase, ctx.StatementID, ctx.ConnID, ctx.Attempts, ctx.Retry, ctx.Error})
+		return ctx.Error
+	}
+}
+
+func (h *retryHooks) funcHooks() *FuncHooks {
+	funcs := Funcs{Before: h.record("before"), After: h.record("after")}
+	return &FuncHooks{Exec: funcs, Query: funcs, StmtExec: funcs, StmtQuery: funcs}
+}
+
+func (h *retryHooks) recorded() []attempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]attempt(nil), h.attempts...)
+}
+
+// openRetries opens a legacy database, where the statement following a
+// KILL one fails with driver.ErrBadConn and database/sql retries it on
+// another connection, with the connection killed
+func openRetries(t *testing.T, mode RetryMode) (*sql.DB, *retryHooks) {
+	hooks := &retryHooks{}
+	db, err := Open(drivertest.Name, drivertest.ModeLegacy+";"+t.Name(), hooks.funcHooks(), WithRetries(mode))
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	kill(t, db, hooks)
+	return db, hooks
+}
+
+func kill(t *testing.T, db *sql.DB, hooks *retryHooks) {
+	_, err := db.Exec("KILL|SELECT 1")
+	require.NoError(t, err)
+	hooks.mu.Lock()
+	hooks.attempts = nil
+	hooks.mu.Unlock()
+}
+
+func TestRetriesSeparate(t *testing.T) {
+	db, hooks := openRetries(t, RetriesSeparate)
+	defer db.Close()
+
+	_, err := db.Exec("INSERT INTO t VALUES (?)", 1)
+	require.NoError(t, err)
+
+	attempts := hooks.recorded()
+	require.Len(t, attempts, 4)
+	assert.Equal(t, attempt{"after", attempts[0].statementID, attempts[0].connID, 0, false, driver.ErrBadConn}, attempts[1])
+	assert.Zero(t, attempts[3].attempts)
+	assert.Nil(t, attempts[3].err)
+}
+
+func TestRetriesTagged(t *testing.T) {
+	db, hooks := openRetries(t, RetriesTagged)
+	defer db.Close()
+
+	_, err := db.Exec("INSERT INTO t VALUES (?)", 1)
+	require.NoError(t, err)
+
+	attempts := hooks.recorded()
+	require.Len(t, attempts, 4)
+	first, retry := attempts[1], attempts[3]
+	assert.Equal(t, 1, first.attempts)
+	assert.False(t, first.retry)
+	assert.Equal(t, driver.ErrBadConn, first.err)
+	assert.Equal(t, 2, retry.attempts)
+	assert.True(t, retry.retry)
+	assert.Nil(t, retry.err)
+	assert.NotEqual(t, first.connID, retry.connID, "the retry runs on another connection")
+	assert.NotEqual(t, first.statementID, retry.statementID)
+
+	// A statement which isn't a retry starts over
+	_, err = db.Exec("INSERT INTO t VALUES (?)", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, hooks.recorded()[5].attempts)
+}
+
+func TestRetriesCollapsed(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		run  func(db *sql.DB, kill func()) error
+	}{
+		{"Exec", func(db *sql.DB, kill func()) error {
+			_, err := db.Exec("INSERT INTO t VALUES (?)", 1)
+			return err
+		}},
+		{"Query", func(db *sql.DB, kill func()) error {
+			rows, err := db.Query("SELECT n FROM t WHERE n = ?", 1)
+			if err == nil {
+				err = rows.Close()
+			}
+			return err
+		}},
+		{"StmtExec", func(db *sql.DB, kill func()) error {
+			// The statement is prepared on the connection killed next
+			stmt, err := db.Prepare("INSERT INTO t VALUES (?)")
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+			kill()
+			_, err = stmt.Exec(1)
+			return err
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db, hooks := openRetries(t, RetriesCollapsed)
+			defer db.Close()
+
+			require.NoError(t, test.run(db, func() { kill(t, db, hooks) }))
+			attempts := hooks.recorded()
+			require.Len(t, attempts, 2)
+			assert.Equal(t, "before", attempts[0].phase)
+			assert.Equal(t, 1, attempts[0].attempts)
+			assert.Equal(t, attempt{"after", attempts[0].statementID, attempts[1].connID, 2, true, nil}, attempts[1])
+		})
+	}
+}
+
+func TestRetriesCollapsedGivenUp(t *testing.T) {
+	hooks := &retryHooks{}
+	db, err := Open(drivertest.Name, drivertest.ModeLegacy+";"+t.Name(), hooks.funcHooks(), WithRetries(RetriesCollapsed))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// database/sql gives up after 3 attempts
+	_, err = db.Exec("FAIL badconn|UPDATE t SET n = 1")
+	assert.Equal(t, driver.ErrBadConn, err)
+	attempts := hooks.recorded()
+	require.Len(t, attempts, 2)
+	assert.Equal(t, 3, attempts[1].attempts)
+	assert.Equal(t, driver.ErrBadConn, attempts[1].err)
+}
+
+func TestRetriesCollapsedWithoutRetry(t *testing.T) {
+	defer func(window time.Duration) { retryWindow = window }(retryWindow)
+	retryWindow = 10 * time.Millisecond
+
+	db, hooks := openRetries(t, RetriesCollapsed)
+	defer db.Close()
+
+	// database/sql doesn't retry the statements of a sql.Conn, the After
+	// hooks run once the retry window is over
+	c, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	_, err = c.ExecContext(context.Background(), "INSERT INTO t VALUES (1)")
+	assert.Equal(t, driver.ErrBadConn, err)
+	require.Len(t, hooks.recorded(), 1)
+	c.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hooks.recorded()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	attempts := hooks.recorded()
+	require.Len(t, attempts, 2)
+	assert.Equal(t, attempt{"after", attempts[0].statementID, attempts[0].connID, 1, false, driver.ErrBadConn}, attempts[1])
+}
@@ -0,0 +1,163 @@
+package sqlhooks
+
+import "database/sql/driver"
+
+// Route returns hooks sending each statement event to the hooks routed for
+// the kind of its statement, as told by Context.Kind, or to fallback for the
+// kinds not in routes. A nil route runs no hooks for its kind.
+// Begin, Commit, Rollback, the Savepointer, TxSummaryHook and
+// BatchSummaryHook events go to the KindTx hooks, the statements run within
+// a transaction are routed by their own kind. The ConnSummaryHook events go
+// to fallback, the CopyHook ones to the hooks of the kind of the COPY, and
+// the ResultErrorHook ones to the hooks of the kind of their query.
+//
+// As the Kind is taken from ctx.Query, a Before hook changing the kind of
+// the query gets its After hook from the new kind's hooks.
+func Route(routes map[Kind]HookType, fallback HookType) HookType {
+	r := &router{routes: make(map[Kind]HookType, len(routes)), fallback: fallback}
+	for kind, hooks := range routes {
+		r.routes[kind] = hooks
+	}
+	return r
+}
+
+type router struct {
+	routes   map[Kind]HookType
+	fallback HookType
+}
+
+func (r *router) hooks(kind Kind) HookType {
+	if hooks, ok := r.routes[kind]; ok {
+		return hooks
+	}
+	return r.fallback
+}
+
+// implements reports whether any of the routed hooks implements the interface checked by is
+func (r *router) implements(is func(HookType) bool) bool {
+	for _, hooks := range r.routes {
+		if hooks != nil && implementsHook(hooks, is) {
+			return true
+		}
+	}
+	return r.fallback != nil && implementsHook(r.fallback, is)
+}
+
+// beginner returns the routed Beginner hooks, Nop if they don't implement it,
+// the functions below do the same for the other interfaces
+func (r *router) beginner() Beginner {
+	if t, ok := r.hooks(KindTx).(Beginner); ok {
+		return t
+	}
+	return Nop
+}
+
+func (r *router) commiter() Commiter {
+	if t, ok := r.hooks(KindTx).(Commiter); ok {
+		return t
+	}
+	return Nop
+}
+
+func (r *router) rollbacker() Rollbacker {
+	if t, ok := r.hooks(KindTx).(Rollbacker); ok {
+		return t
+	}
+	return Nop
+}
+
+func (r *router) stmter(ctx *Context) Stmter {
+	if t, ok := r.hooks(ctx.Kind()).(Stmter); ok {
+		return t
+	}
+	return Nop
+}
+
+func (r *router) queryer(ctx *Context) Queryer {
+	if t, ok := r.hooks(ctx.Kind()).(Queryer); ok {
+		return t
+	}
+	return Nop
+}
+
+func (r *router) execer(ctx *Context) Execer {
+	if t, ok := r.hooks(ctx.Kind()).(Execer); ok {
+		return t
+	}
+	return Nop
+}
+
+func (r *router) BeforeBegin(ctx *Context) error { return r.beginner().BeforeBegin(ctx) }
+func (r *router) AfterBegin(ctx *Context) error  { return r.beginner().AfterBegin(ctx) }
+
+func (r *router) BeforeCommit(ctx *Context) error { return r.commiter().BeforeCommit(ctx) }
+func (r *router) AfterCommit(ctx *Context) error  { return r.commiter().AfterCommit(ctx) }
+
+func (r *router) BeforeRollback(ctx *Context) error { return r.rollbacker().BeforeRollback(ctx) }
+func (r *router) AfterRollback(ctx *Context) error  { return r.rollbacker().AfterRollback(ctx) }
+
+func (r *router) BeforePrepare(ctx *Context) error { return r.stmter(ctx).BeforePrepare(ctx) }
+func (r *router) AfterPrepare(ctx *Context) error  { return r.stmter(ctx).AfterPrepare(ctx) }
+
+func (r *router) BeforeStmtQuery(ctx *Context) error { return r.stmter(ctx).BeforeStmtQuery(ctx) }
+func (r *router) AfterStmtQuery(ctx *Context) error  { return r.stmter(ctx).AfterStmtQuery(ctx) }
+
+func (r *router) BeforeStmtExec(ctx *Context) error { return r.stmter(ctx).BeforeStmtExec(ctx) }
+func (r *router) AfterStmtExec(ctx *Context) error  { return r.stmter(ctx).AfterStmtExec(ctx) }
+
+func (r *router) BeforeQuery(ctx *Context) error { return r.queryer(ctx).BeforeQuery(ctx) }
+func (r *router) AfterQuery(ctx *Context) error  { return r.queryer(ctx).AfterQuery(ctx) }
+
+func (r *router) BeforeExec(ctx *Context) error { return r.execer(ctx).BeforeExec(ctx) }
+func (r *router) AfterExec(ctx *Context) error  { return r.execer(ctx).AfterExec(ctx) }
+
+func (r *router) ExplainQuery(ctx *Context) (string, bool) {
+	if t, ok := r.hooks(ctx.Kind()).(Explainer); ok {
+		return t.ExplainQuery(ctx)
+	}
+	return "", false
+}
+
+func (r *router) AfterExplain(ctx *Context, plan string, err error) {
+	if t, ok := r.hooks(ctx.Kind()).(Explainer); ok {
+		t.AfterExplain(ctx, plan, err)
+	}
+}
+
+func (r *router) Savepoint(txID uint64, name string) {
+	if t, ok := r.hooks(KindTx).(Savepointer); ok {
+		t.Savepoint(txID, name)
+	}
+}
+
+func (r *router) ReleaseSavepoint(txID uint64, name string) {
+	if t, ok := r.hooks(KindTx).(Savepointer); ok {
+		t.ReleaseSavepoint(txID, name)
+	}
+}
+
+func (r *router) RollbackToSavepoint(txID uint64, name string) {
+	if t, ok := r.hooks(KindTx).(Savepointer); ok {
+		t.RollbackToSavepoint(txID, name)
+	}
+}
+
+func (r *router) AfterTx(summary TxSummary) {
+	if t, ok := r.hooks(KindTx).(TxSummaryHook); ok {
+		t.AfterTx(summary)
+	}
+}
+
+func (r *router) AfterRowsClose(ctx *Context) error {
+	if t, ok := r.hooks(ctx.Kind()).(RowsCloser); ok {
+		return t.AfterRowsClose(ctx)
+	}
+	return ctx.Error
+}
+
+func (r *router) WrapRows(ctx *Context, rows driver.Rows) driver.Rows {
+	if t, ok := r.hooks(ctx.Kind()).(RowsWrapper); ok {
+		return t.WrapRows(ctx, rows)
+	}
+	return rows
+}
@@ -0,0 +1,231 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// anyConn accepts any statement, queries return no rows
+type anyConn struct{}
+
+func (anyConn) Prepare(query string) (driver.Stmt, error) { return anyStmt{}, nil }
+func (anyConn) Close() error                              { return nil }
+func (anyConn) Begin() (driver.Tx, error)                 { return anyTx{}, nil }
+
+func (anyConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (anyConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return anyRows{}, nil
+}
+
+type anyStmt struct{}
+
+func (anyStmt) Close() error                                    { return nil }
+func (anyStmt) NumInput() int                                   { return -1 }
+func (anyStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (anyStmt) Query(args []driver.Value) (driver.Rows, error)  { return anyRows{}, nil }
+
+type anyTx struct{}
+
+func (anyTx) Commit() error   { return nil }
+func (anyTx) Rollback() error { return nil }
+
+type anyRows struct{}
+
+func (anyRows) Columns() []string              { return nil }
+func (anyRows) Close() error                   { return nil }
+func (anyRows) Next(dest []driver.Value) error { return io.EOF }
+
+// routeRecorder logs name and the operation of every Before hook
+func routeRecorder(name string, log *[]string) *FuncHooks {
+	record := func(op string) Funcs {
+		return Funcs{Before: func(ctx *Context) error {
+			*log = append(*log, name+" "+op)
+			return nil
+		}}
+	}
+	return &FuncHooks{
+		Query:     record("query"),
+		Exec:      record("exec"),
+		Begin:     record("begin"),
+		Commit:    record("commit"),
+		Rollback:  record("rollback"),
+		Prepare:   record("prepare"),
+		StmtQuery: record("stmtquery"),
+		StmtExec:  record("stmtexec"),
+	}
+}
+
+func openRouted(t *testing.T, hooks HookType) *conn {
+	c, err := NewDriver("", hooks).wrap(context.Background(), "", anyConn{})
+	require.NoError(t, err)
+	return c.(*conn)
+}
+
+func TestRouteByKind(t *testing.T) {
+	var log []string
+	writes := routeRecorder("writes", &log)
+	c := openRouted(t, Route(map[Kind]HookType{
+		KindSelect: routeRecorder("reads", &log),
+		KindInsert: writes,
+		KindUpdate: writes,
+		KindDelete: writes,
+		KindDDL:    routeRecorder("ddl", &log),
+	}, routeRecorder("fallback", &log)))
+
+	for _, query := range []string{
+		"INSERT INTO t VALUES(?)",
+		"UPDATE t SET f1 = ?",
+		"DELETE FROM t",
+		"CREATE TABLE t(f1)",
+		"SHOW TABLES",
+	} {
+		_, err := c.Exec(query, nil)
+		require.NoError(t, err)
+	}
+
+	rows, err := c.Query("SELECT f1 FROM t", nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	s, err := c.Prepare("SELECT f1 FROM t WHERE f1 = ?")
+	require.NoError(t, err)
+	rows, err = s.Query(nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.NoError(t, s.Close())
+
+	s, err = c.Prepare("INSERT INTO t VALUES(?)")
+	require.NoError(t, err)
+	_, err = s.Exec(nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	assert.Equal(t, []string{
+		"writes exec",
+		"writes exec",
+		"writes exec",
+		"ddl exec",
+		"fallback exec",
+		"reads query",
+		"reads prepare",
+		"reads stmtquery",
+		"writes prepare",
+		"writes stmtexec",
+	}, log)
+}
+
+func TestRouteTransactions(t *testing.T) {
+	var log []string
+	c := openRouted(t, Route(map[Kind]HookType{
+		KindSelect: routeRecorder("reads", &log),
+		KindInsert: routeRecorder("writes", &log),
+		KindTx:     routeRecorder("tx", &log),
+	}, nil))
+
+	tx, err := c.Begin()
+	require.NoError(t, err)
+	_, err = c.Exec("INSERT INTO t VALUES(?)", nil)
+	require.NoError(t, err)
+	_, err = c.Exec("SAVEPOINT sp1", nil)
+	require.NoError(t, err)
+	rows, err := c.Query("SELECT f1 FROM t", nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.NoError(t, tx.Commit())
+
+	tx, err = c.Begin()
+	require.NoError(t, err)
+	_, err = c.Exec("DELETE FROM t", nil)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+
+	// DELETE has no route and there's no fallback
+	assert.Equal(t, []string{
+		"tx begin",
+		"writes exec",
+		"tx exec",
+		"reads query",
+		"tx commit",
+		"tx begin",
+		"tx rollback",
+	}, log)
+}
+
+func TestRouteFallback(t *testing.T) {
+	var log []string
+	c := openRouted(t, Route(map[Kind]HookType{
+		KindSelect: nil,
+	}, routeRecorder("fallback", &log)))
+
+	tx, err := c.Begin()
+	require.NoError(t, err)
+	_, err = c.Exec("INSERT INTO t VALUES(?)", nil)
+	require.NoError(t, err)
+	rows, err := c.Query("SELECT f1 FROM t", nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.NoError(t, tx.Commit())
+
+	// a nil route disables the hooks of its kind
+	assert.Equal(t, []string{"fallback begin", "fallback exec", "fallback commit"}, log)
+}
+
+func TestRouteClassifiesOnce(t *testing.T) {
+	calls := 0
+	classify = func(query string) (string, string) {
+		calls++
+		return Classify(query)
+	}
+	defer func() { classify = Classify }()
+
+	// newrelic, among others, classifies the statement as well
+	var tables []string
+	consumer := &FuncHooks{StmtExec: Funcs{Before: func(ctx *Context) error {
+		_, table := ctx.Classify()
+		tables = append(tables, table)
+		return nil
+	}}}
+
+	var log []string
+	c := openRouted(t, Compose(Route(map[Kind]HookType{
+		KindInsert: routeRecorder("writes", &log),
+	}, nil), consumer))
+
+	s, err := c.Prepare("INSERT INTO t VALUES(?)")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = s.Exec(nil)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+
+	assert.Equal(t, []string{"t", "t", "t"}, tables)
+	assert.Len(t, log, 4)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRouteImplementsRoutedHooks(t *testing.T) {
+	r := Route(map[Kind]HookType{KindSelect: &HooksMock{}}, nil)
+	assert.False(t, implementsHook(r, isSavepointer))
+
+	r = Route(nil, Compose(&HooksMock{}, &savepointMock{HooksMock: &HooksMock{}}))
+	assert.True(t, implementsHook(r, isSavepointer))
+}
+
+func TestRouteTxSummary(t *testing.T) {
+	tx, other := &summaryRecorder{}, &summaryRecorder{}
+	r := Route(map[Kind]HookType{KindTx: tx}, other)
+	assert.True(t, implementsHook(r, isTxSummaryHook))
+
+	r.(TxSummaryHook).AfterTx(TxSummary{TxID: 1})
+	assert.Len(t, tx.summaries, 1)
+	assert.Empty(t, other.summaries)
+}
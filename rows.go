@@ -0,0 +1,142 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"time"
+)
+
+// RowsCloser is the interface implemented by objects that wants to hook to
+// the closing of the rows returned by Query and prepared statements queries,
+// which for QueryRow happens within Scan.
+// It's only called along with the Queryer or Stmter hooks.
+// AfterRowsClose gets the same *Context the query hooks got, with Error set
+// to the error closing the rows and Duration to the time since the query
+// started, so query and scan can be reported together, along with
+// RowsReturned and Abandoned.
+type RowsCloser interface {
+	AfterRowsClose(*Context) error
+}
+
+// RowsWrapper is the interface implemented by objects that wants to wrap the
+// rows returned by Query and prepared statements queries, for example to
+// record the values as they are read.
+// As RowsCloser, it's only called along with the Queryer or Stmter hooks.
+// Wrapping rows implementing none of the optional interfaces of driver.Rows,
+// as driver.RowsNextResultSet, get the ones of the rows they were given.
+type RowsWrapper interface {
+	WrapRows(ctx *Context, rows driver.Rows) driver.Rows
+}
+
+// wrapRows returns the rows wrapped by the RowsWrapper hook, if any, which
+// forward the optional interfaces of rows
+func wrapRows(rows driver.Rows, hooks HookType, ctx *Context) driver.Rows {
+	t, ok := hooks.(RowsWrapper)
+	if !ok || rows == nil || ctx == nil || !implementsHook(hooks, isRowsWrapper) {
+		return rows
+	}
+	return forwardWrappedRows(t.WrapRows(ctx, rows), rows)
+}
+
+func isRowsCloser(h HookType) bool {
+	_, ok := h.(RowsCloser)
+	return ok
+}
+
+func isRowsWrapper(h HookType) bool {
+	_, ok := h.(RowsWrapper)
+	return ok
+}
+
+type closingRows struct {
+	driver.Rows
+	hook  RowsCloser
+	ctx   *Context
+	clock clock
+	start instant
+
+	// raw are the rows before RowsWrapper hooks wrapped them, check is set
+	// by WithAbandonedRowsCheck
+	raw      driver.Rows
+	check    bool
+	returned int64
+	// done is set once Next reported the end of the rows or failed, empty
+	// when it reported the end before any row
+	done, empty bool
+
+	// firstByte is how long the driver call took, firstRow and total the
+	// time from start to the first row and the end of the rows
+	firstByte, firstRow, total time.Duration
+}
+
+func (r *closingRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	r.count(err)
+	return err
+}
+
+// NextRow is Next for the rows implementing driver.RowsColumnScanner, which
+// database/sql reads with NextRow instead as of Go 1.27
+func (r *closingRows) NextRow() error {
+	err := r.Rows.(nextRower).NextRow()
+	r.count(err)
+	return err
+}
+
+// count records the row read, or the end of the rows, err is the one of
+// Next or NextRow
+func (r *closingRows) count(err error) {
+	if err == nil {
+		if r.returned == 0 {
+			r.firstRow = r.clock.since(r.start)
+		}
+		r.returned++
+	} else if !r.done {
+		r.done = true
+		r.empty = err == io.EOF && r.returned == 0
+		r.total = r.clock.since(r.start)
+	}
+}
+
+func (r *closingRows) Close() error {
+	// columns can't be read from closed rows
+	columns := r.ctx.Columns()
+	r.ctx.RowsReturned = r.returned
+	r.ctx.NoRows = r.empty
+	// With WithAbandonedRowsCheck, reading one more row tells rows left
+	// from rows closed right after the last one, as QueryRow does. It's
+	// read from the raw rows so the RowsWrapper hooks don't see a row the
+	// application didn't read, and not once the query's context is done,
+	// as reading would wait for the driver noticing it
+	r.ctx.Abandoned = !r.done && (!r.check || ctxDone(r.ctx.Ctx) || nextRow(r.raw, len(columns)) == nil)
+	err := r.Rows.Close()
+	r.ctx.Error = err
+	r.ctx.CtxErr = ctxErr(r.ctx.Ctx, err)
+	r.ctx.Duration = r.clock.since(r.start)
+	r.ctx.TimeToFirstByte = r.firstByte
+	r.ctx.TimeToFirstRow = r.firstRow
+	r.ctx.TotalTime = r.total
+	if !r.done {
+		r.ctx.TotalTime = r.ctx.Duration
+	}
+	return r.hook.AfterRowsClose(r.ctx)
+}
+
+// ctxDone reports whether stdCtx is done, false if it's nil
+func ctxDone(stdCtx context.Context) bool {
+	return stdCtx != nil && stdCtx.Err() != nil
+}
+
+// closeRows calls the RowsCloser hook, if any, once rows are closed, raw are
+// rows before wrapRows, firstByte how long the driver call took and check
+// whether a row is read to tell whether rows were left
+func closeRows(rows, raw driver.Rows, hooks HookType, ctx *Context, clock clock, start instant, firstByte time.Duration, check bool) driver.Rows {
+	t, ok := hooks.(RowsCloser)
+	if !ok || rows == nil || ctx == nil || !implementsHook(hooks, isRowsCloser) {
+		return rows
+	}
+	// The *Context of a prepared statement is reused by its executions
+	ctx.RowsReturned, ctx.Abandoned, ctx.NoRows = 0, false, false
+	return forwardRows(&closingRows{Rows: rows, hook: t, ctx: ctx, clock: clock, start: start, raw: raw, firstByte: firstByte, check: check}, rows)
+}
@@ -0,0 +1,454 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rowsMock struct {
+	*HooksMock
+	queried []*Context
+	closed  []*Context
+}
+
+func (m *rowsMock) AfterRowsClose(ctx *Context) error {
+	m.closed = append(m.closed, ctx)
+	return ctx.Error
+}
+
+func openDBWithRowsMock(t *testing.T, opts ...Option) (*sql.DB, *rowsMock) {
+	hooks := &rowsMock{}
+	afterQuery := func(ctx *Context) error {
+		// the fake driver falls back to prepared statements
+		if ctx.Error != driver.ErrSkip {
+			hooks.queried = append(hooks.queried, ctx)
+		}
+		return ctx.Error
+	}
+	hooks.HooksMock = &HooksMock{
+		afterQuery:     afterQuery,
+		afterStmtQuery: afterQuery,
+		afterExec:      func(ctx *Context) error { return ctx.Error },
+		afterPrepare:   func(ctx *Context) error { return ctx.Error },
+	}
+
+	openDBWithHooks(t, nil).Close()
+	db, err := Open(*driverFlag, *dsnFlag, hooks, opts...)
+	require.NoError(t, err)
+	_, err = db.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	return db, hooks
+}
+
+func assertRowsCloseLinked(t *testing.T, hooks *rowsMock) {
+	require.Len(t, hooks.queried, 1)
+	require.Len(t, hooks.closed, 1)
+	assert.True(t, hooks.queried[0] == hooks.closed[0], "rows close got a different *Context")
+}
+
+func TestQueryRowFiresRowsCloseHook(t *testing.T) {
+	db, hooks := openDBWithRowsMock(t)
+	defer db.Close()
+
+	var f1, f2 string
+	err := db.QueryRow(queries[*driverFlag].selectwhere, "foo", "bar").Scan(&f1, &f2)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", f1)
+
+	assertRowsCloseLinked(t, hooks)
+	assert.NoError(t, hooks.closed[0].Error)
+	assert.NotZero(t, hooks.closed[0].Duration)
+	assert.False(t, hooks.closed[0].NoRows)
+}
+
+func TestQueryRowNoRowsFiresRowsCloseHook(t *testing.T) {
+	db, hooks := openDBWithRowsMock(t)
+	defer db.Close()
+
+	var f1, f2 string
+	err := db.QueryRow(queries[*driverFlag].selectwhere, "none", "none").Scan(&f1, &f2)
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.True(t, IsNotFound(err))
+
+	// database/sql fails the Scan, the query and its rows didn't
+	assertRowsCloseLinked(t, hooks)
+	ctx := hooks.closed[0]
+	assert.NoError(t, ctx.Error)
+	assert.Equal(t, ErrorClassNone, ctx.ErrorClass())
+	assert.True(t, ctx.NoRows)
+	assert.Zero(t, ctx.RowsReturned)
+	assert.False(t, ctx.Abandoned)
+}
+
+func TestQueryRowScanErrorFiresRowsCloseHook(t *testing.T) {
+	db, hooks := openDBWithRowsMock(t)
+	defer db.Close()
+
+	var f1 int
+	var f2 string
+	err := db.QueryRow(queries[*driverFlag].selectwhere, "foo", "bar").Scan(&f1, &f2)
+	assert.Error(t, err)
+
+	assertRowsCloseLinked(t, hooks)
+}
+
+func TestColumnsAreAvailableToHooks(t *testing.T) {
+	db, hooks := openDBWithRowsMock(t)
+	defer db.Close()
+
+	var columns []string
+	hooks.afterStmtQuery = func(ctx *Context) error {
+		columns = ctx.Columns()
+		return ctx.Error
+	}
+	hooks.afterQuery = func(ctx *Context) error {
+		if ctx.Error == nil {
+			columns = ctx.Columns()
+		}
+		return ctx.Error
+	}
+
+	rows, err := db.Query(queries[*driverFlag].selectall)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	assert.Equal(t, []string{"f1", "f2"}, columns)
+	require.Len(t, hooks.closed, 1)
+	assert.Equal(t, []string{"f1", "f2"}, hooks.closed[0].Columns())
+}
+
+type wrappingMock struct {
+	*HooksMock
+	rows int
+}
+
+type countingRows struct {
+	driver.Rows
+	m *wrappingMock
+}
+
+func (r countingRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == nil {
+		r.m.rows++
+	}
+	return err
+}
+
+func (m *wrappingMock) WrapRows(ctx *Context, rows driver.Rows) driver.Rows {
+	return countingRows{rows, m}
+}
+
+func TestRowsWrapperWrapsQueryRows(t *testing.T) {
+	hooks := &wrappingMock{HooksMock: &HooksMock{
+		afterExec:  func(ctx *Context) error { return ctx.Error },
+		afterQuery: func(ctx *Context) error { return ctx.Error },
+	}}
+	db := openDBWithHooks(t, hooks)
+	defer db.Close()
+
+	q := queries[*driverFlag]
+	for i := 0; i < 3; i++ {
+		_, err := db.Exec(q.insert, "foo", "bar")
+		require.NoError(t, err)
+	}
+
+	rows, err := db.Query(q.selectall)
+	require.NoError(t, err)
+	for rows.Next() {
+	}
+	require.NoError(t, rows.Close())
+
+	assert.Equal(t, 3, hooks.rows)
+}
+
+func TestRowsCloseReportsRowsReturned(t *testing.T) {
+	db, hooks := openDBWithRowsMock(t)
+	defer db.Close()
+	q := queries[*driverFlag]
+	for i := 0; i < 2; i++ {
+		_, err := db.Exec(q.insert, "foo", "bar")
+		require.NoError(t, err)
+	}
+
+	rows, err := db.Query(q.selectall)
+	require.NoError(t, err)
+	for rows.Next() {
+	}
+	require.NoError(t, rows.Close())
+	require.Len(t, hooks.closed, 1)
+	assert.Equal(t, int64(3), hooks.closed[0].RowsReturned)
+	assert.False(t, hooks.closed[0].Abandoned)
+
+	rows, err = db.Query(q.selectall)
+	require.NoError(t, err)
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Close())
+	require.Len(t, hooks.closed, 2)
+	assert.Equal(t, int64(1), hooks.closed[1].RowsReturned)
+	assert.True(t, hooks.closed[1].Abandoned, "closed early")
+
+	var f1, f2 string
+	require.NoError(t, db.QueryRow(q.selectall).Scan(&f1, &f2))
+	require.Len(t, hooks.closed, 3)
+	assert.Equal(t, int64(1), hooks.closed[2].RowsReturned)
+	assert.True(t, hooks.closed[2].Abandoned, "QueryRow leaves 2 rows")
+}
+
+func TestQueryRowOfOneRowIsntAbandoned(t *testing.T) {
+	db, hooks := openDBWithRowsMock(t, WithAbandonedRowsCheck(true))
+	defer db.Close()
+
+	var f1, f2 string
+	require.NoError(t, db.QueryRow(queries[*driverFlag].selectwhere, "foo", "bar").Scan(&f1, &f2))
+	require.Len(t, hooks.closed, 1)
+	assert.Equal(t, int64(1), hooks.closed[0].RowsReturned)
+	assert.False(t, hooks.closed[0].Abandoned)
+
+	err := db.QueryRow(queries[*driverFlag].selectwhere, "none", "none").Scan(&f1, &f2)
+	assert.Equal(t, sql.ErrNoRows, err)
+	require.Len(t, hooks.closed, 2)
+	assert.Equal(t, int64(0), hooks.closed[1].RowsReturned)
+	assert.False(t, hooks.closed[1].Abandoned)
+}
+
+func TestQueryRowIsAbandonedWithoutCheck(t *testing.T) {
+	db, hooks := openDBWithRowsMock(t)
+	defer db.Close()
+
+	var f1, f2 string
+	require.NoError(t, db.QueryRow(queries[*driverFlag].selectwhere, "foo", "bar").Scan(&f1, &f2))
+	require.Len(t, hooks.closed, 1)
+	assert.True(t, hooks.closed[0].Abandoned, "no row is read to tell")
+}
+
+// wrappingCloser counts the rows read through its wrapper and keeps the
+// closed rows contexts
+type wrappingCloser struct {
+	*wrappingMock
+	closed []*Context
+}
+
+func (m *wrappingCloser) AfterRowsClose(ctx *Context) error {
+	m.closed = append(m.closed, ctx)
+	return ctx.Error
+}
+
+func TestAbandonedRowsArentReadByWrappers(t *testing.T) {
+	hooks := &wrappingCloser{wrappingMock: &wrappingMock{HooksMock: &HooksMock{
+		afterExec:  func(ctx *Context) error { return ctx.Error },
+		afterQuery: func(ctx *Context) error { return ctx.Error },
+	}}}
+	openDBWithHooks(t, nil).Close()
+	db, err := Open(*driverFlag, *dsnFlag, hooks, WithAbandonedRowsCheck(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	q := queries[*driverFlag]
+	for i := 0; i < 2; i++ {
+		_, err := db.Exec(q.insert, "foo", "bar")
+		require.NoError(t, err)
+	}
+	var f1, f2 string
+	require.NoError(t, db.QueryRow(q.selectall).Scan(&f1, &f2))
+
+	assert.Equal(t, 1, hooks.rows)
+	require.Len(t, hooks.closed, 1)
+	assert.True(t, hooks.closed[0].Abandoned)
+}
+
+// endlessRows return a single column row on every Next, counting them
+type endlessRows struct {
+	anyRows
+	reads int
+}
+
+func (r *endlessRows) Columns() []string { return []string{"n"} }
+
+func (r *endlessRows) Next(dest []driver.Value) error {
+	r.reads++
+	dest[0] = int64(r.reads)
+	return nil
+}
+
+func TestAbandonedRowsArentReadOnceCanceled(t *testing.T) {
+	for _, canceled := range []bool{false, true} {
+		stdCtx, cancel := context.WithCancel(context.Background())
+		ctx := NewContext()
+		ctx.Ctx = stdCtx
+		raw := &endlessRows{}
+		ctx.setRows(raw)
+		rows := closeRows(raw, raw, &rowsMock{HooksMock: &HooksMock{}}, ctx, systemClock, systemClock(), 0, true)
+		require.NoError(t, rows.Next(make([]driver.Value, 1)))
+		if canceled {
+			cancel()
+		}
+		require.NoError(t, rows.Close())
+		cancel()
+
+		assert.True(t, ctx.Abandoned)
+		if canceled {
+			assert.Equal(t, 1, raw.reads, "no row is read once the context is done")
+		} else {
+			assert.Equal(t, 2, raw.reads, "a row is read to tell rows were left")
+		}
+	}
+}
+
+// slowRows return their first row right away, the next ones once release is closed
+type slowRows struct {
+	endlessRows
+	release chan struct{}
+}
+
+func (r *slowRows) Next(dest []driver.Value) error {
+	if r.reads > 0 {
+		<-r.release
+	}
+	return r.endlessRows.Next(dest)
+}
+
+func TestRowsClosedEarlyDontWaitForTheNextRow(t *testing.T) {
+	ctx := NewContext()
+	raw := &slowRows{release: make(chan struct{})}
+	defer close(raw.release)
+	ctx.setRows(raw)
+	rows := closeRows(raw, raw, &rowsMock{HooksMock: &HooksMock{}}, ctx, systemClock, systemClock(), 0, false)
+	require.NoError(t, rows.Next(make([]driver.Value, 1)))
+
+	closed := make(chan error, 1)
+	go func() { closed <- rows.Close() }()
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for the driver to deliver the next row")
+	}
+	assert.True(t, ctx.Abandoned)
+	assert.Equal(t, 1, raw.reads, "no row is read without WithAbandonedRowsCheck")
+}
+
+// manualClock is a clock only advanced by the phaseConn calls and the tests
+type manualClock struct {
+	now instant
+}
+
+func (c *manualClock) read() instant           { return c.now }
+func (c *manualClock) advance(d time.Duration) { c.now.mono += d }
+
+// phaseConn is a streaming driver: its queries return after 10ms, their
+// first row takes 5ms more and the next ones, as the end of the rows, 1ms
+type phaseConn struct {
+	anyConn
+	clock *manualClock
+	rows  int
+}
+
+func (c *phaseConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	c.clock.advance(10 * time.Millisecond)
+	return &phaseRows{clock: c.clock, left: c.rows}, nil
+}
+
+func (c *phaseConn) Prepare(query string) (driver.Stmt, error) { return phaseStmt{conn: c}, nil }
+
+type phaseStmt struct {
+	anyStmt
+	conn *phaseConn
+}
+
+func (s phaseStmt) Query(args []driver.Value) (driver.Rows, error) { return s.conn.Query("", args) }
+
+type phaseRows struct {
+	anyRows
+	clock *manualClock
+	left  int
+	read  bool
+}
+
+func (r *phaseRows) Columns() []string { return []string{"n"} }
+
+func (r *phaseRows) Next(dest []driver.Value) error {
+	if r.read {
+		r.clock.advance(time.Millisecond)
+	} else {
+		r.clock.advance(5 * time.Millisecond)
+		r.read = true
+	}
+	if r.left == 0 {
+		return io.EOF
+	}
+	r.left--
+	return nil
+}
+
+// phaseHooks keeps the phases of the closed rows
+type phaseHooks struct {
+	NopHooks
+	closed []Context
+}
+
+func (h *phaseHooks) AfterRowsClose(ctx *Context) error {
+	h.closed = append(h.closed, *ctx)
+	return nil
+}
+
+func TestRowsClosePhases(t *testing.T) {
+	ms := time.Millisecond
+	cases := []struct {
+		name                      string
+		rows, read                int
+		firstRow, total, duration time.Duration
+		abandoned                 bool
+	}{
+		{name: "iterated", rows: 3, read: -1, firstRow: 15 * ms, total: 18 * ms, duration: 118 * ms},
+		{name: "no rows", rows: 0, read: -1, total: 15 * ms, duration: 115 * ms},
+		{name: "abandoned", rows: 3, read: 1, firstRow: 15 * ms, total: 115 * ms, duration: 115 * ms, abandoned: true},
+		{name: "unread", rows: 3, read: 0, total: 110 * ms, duration: 110 * ms, abandoned: true},
+	}
+
+	for _, prepared := range []bool{false, true} {
+		for _, c := range cases {
+			clock := &manualClock{}
+			hooks := &phaseHooks{}
+			d := NewDriver("", hooks)
+			d.clock = clock.read
+			dc, err := d.wrap(context.Background(), "", &phaseConn{clock: clock, rows: c.rows})
+			require.NoError(t, err)
+
+			var rows driver.Rows
+			if prepared {
+				s, err := dc.Prepare("SELECT n FROM t")
+				require.NoError(t, err)
+				rows, err = s.Query(nil)
+				require.NoError(t, err)
+			} else {
+				rows, err = dc.(driver.Queryer).Query("SELECT n FROM t", nil)
+				require.NoError(t, err)
+			}
+			dest := make([]driver.Value, 1)
+			for i := 0; c.read < 0 || i < c.read; i++ {
+				if rows.Next(dest) != nil {
+					break
+				}
+			}
+			// The application takes 100ms before closing
+			clock.advance(100 * ms)
+			require.NoError(t, rows.Close())
+
+			require.Len(t, hooks.closed, 1, c.name)
+			ctx := hooks.closed[0]
+			assert.Equal(t, 10*ms, ctx.TimeToFirstByte, "%s prepared=%t", c.name, prepared)
+			assert.Equal(t, c.firstRow, ctx.TimeToFirstRow, "%s prepared=%t", c.name, prepared)
+			assert.Equal(t, c.total, ctx.TotalTime, "%s prepared=%t", c.name, prepared)
+			assert.Equal(t, c.duration, ctx.Duration, "%s prepared=%t", c.name, prepared)
+			assert.Equal(t, c.abandoned, ctx.Abandoned, "%s prepared=%t", c.name, prepared)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package sqlhooks
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RealTimeBefore is implemented by hooks whose Before must run before the
+// operation, as tracing hooks timing it. TailSample doesn't sample the hooks
+// whose RealTimeBefore returns true, they get every event as they happen.
+type RealTimeBefore interface {
+	RealTimeBefore() bool
+}
+
+// TailSample returns hooks running hooks for a sample of the operations,
+// decided once they ran: every failed operation is kept, as is every one
+// taking at least slow, unless slow is 0, and the others are kept with
+// probability rate, from 0 to 1.
+//
+// Since the outcome must be known, the Before hooks of a kept operation run
+// right before its After hooks, once it ran: they see the Context as the After
+// hooks do, with ctx.Error cleared, can't change the query or skip the
+// operation, and an error they return is returned by the operation, the After
+// hooks being skipped then. Hooks implementing RealTimeBefore, given as such
+// or composed by Compose, aren't sampled, they run as if composed after the
+// sampled ones.
+// Only the Before and After events are sampled, the explain, savepoint,
+// transaction summary and rows events are forwarded as they are.
+func TailSample(hooks HookType, rate float64, slow time.Duration) HookType {
+	var sampled, realTime []HookType
+	hs, ok := hooks.(composed)
+	if !ok {
+		hs = composed{hooks}
+	}
+	for _, h := range hs {
+		if t, ok := h.(RealTimeBefore); ok && t.RealTimeBefore() {
+			realTime = append(realTime, h)
+		} else {
+			sampled = append(sampled, h)
+		}
+	}
+
+	s := Compose(sampled...)
+	if s != nil {
+		s = &sampler{hooks: s, rate: rate, slow: slow, random: rand.Float64}
+	}
+	return Compose(append([]HookType{s}, realTime...)...)
+}
+
+type sampler struct {
+	hooks  HookType
+	rate   float64
+	slow   time.Duration
+	random func() float64
+}
+
+func (s *sampler) implements(is func(HookType) bool) bool {
+	return implementsHook(s.hooks, is)
+}
+
+// keep reports whether the operation of ctx, which ran, is sampled
+func (s *sampler) keep(ctx *Context) bool {
+	if ctx.Error != nil {
+		return true
+	}
+	if s.slow > 0 && ctx.Duration >= s.slow {
+		return true
+	}
+	return s.rate > 0 && s.random() < s.rate
+}
+
+// after runs the Before and After functions of the hooks for a kept operation
+func (s *sampler) after(ctx *Context, funcs hookFuncs) error {
+	if !s.keep(ctx) {
+		return ctx.Error
+	}
+	return replay(s.hooks, ctx, funcs)
+}
+
+// replay runs the Before and After functions of hooks for an operation which
+// already ran, the Before ones see ctx.Error cleared
+func replay(hooks HookType, ctx *Context, funcs hookFuncs) error {
+	before, after := funcs(hooks)
+	if before != nil {
+		err := ctx.Error
+		ctx.Error = nil
+		beforeErr := before(ctx)
+		ctx.Error = err
+		if beforeErr != nil {
+			return beforeErr
+		}
+	}
+	if after == nil {
+		return ctx.Error
+	}
+	return after(ctx)
+}
+
+func (s *sampler) BeforeBegin(ctx *Context) error { return nil }
+func (s *sampler) AfterBegin(ctx *Context) error  { return s.after(ctx, beginFuncs) }
+
+func (s *sampler) BeforeCommit(ctx *Context) error { return nil }
+func (s *sampler) AfterCommit(ctx *Context) error  { return s.after(ctx, commitFuncs) }
+
+func (s *sampler) BeforeRollback(ctx *Context) error { return nil }
+func (s *sampler) AfterRollback(ctx *Context) error  { return s.after(ctx, rollbackFuncs) }
+
+func (s *sampler) BeforePrepare(ctx *Context) error { return nil }
+func (s *sampler) AfterPrepare(ctx *Context) error  { return s.after(ctx, prepareFuncs) }
+
+func (s *sampler) BeforeStmtQuery(ctx *Context) error { return nil }
+func (s *sampler) AfterStmtQuery(ctx *Context) error  { return s.after(ctx, stmtQueryFuncs) }
+
+func (s *sampler) BeforeStmtExec(ctx *Context) error { return nil }
+func (s *sampler) AfterStmtExec(ctx *Context) error  { return s.after(ctx, stmtExecFuncs) }
+
+func (s *sampler) BeforeQuery(ctx *Context) error { return nil }
+func (s *sampler) AfterQuery(ctx *Context) error  { return s.after(ctx, queryFuncs) }
+
+func (s *sampler) BeforeExec(ctx *Context) error { return nil }
+func (s *sampler) AfterExec(ctx *Context) error  { return s.after(ctx, execFuncs) }
+
+func (s *sampler) ExplainQuery(ctx *Context) (string, bool) {
+	if t, ok := s.hooks.(Explainer); ok {
+		return t.ExplainQuery(ctx)
+	}
+	return "", false
+}
+
+func (s *sampler) AfterExplain(ctx *Context, plan string, err error) {
+	if t, ok := s.hooks.(Explainer); ok {
+		t.AfterExplain(ctx, plan, err)
+	}
+}
+
+func (s *sampler) Savepoint(txID uint64, name string) {
+	if t, ok := s.hooks.(Savepointer); ok {
+		t.Savepoint(txID, name)
+	}
+}
+
+func (s *sampler) ReleaseSavepoint(txID uint64, name string) {
+	if t, ok := s.hooks.(Savepointer); ok {
+		t.ReleaseSavepoint(txID, name)
+	}
+}
+
+func (s *sampler) RollbackToSavepoint(txID uint64, name string) {
+	if t, ok := s.hooks.(Savepointer); ok {
+		t.RollbackToSavepoint(txID, name)
+	}
+}
+
+func (s *sampler) AfterTx(summary TxSummary) {
+	if t, ok := s.hooks.(TxSummaryHook); ok {
+		t.AfterTx(summary)
+	}
+}
+
+func (s *sampler) AfterRowsClose(ctx *Context) error {
+	if t, ok := s.hooks.(RowsCloser); ok {
+		return t.AfterRowsClose(ctx)
+	}
+	return ctx.Error
+}
+
+func (s *sampler) WrapRows(ctx *Context, rows driver.Rows) driver.Rows {
+	if t, ok := s.hooks.(RowsWrapper); ok {
+		return t.WrapRows(ctx, rows)
+	}
+	return rows
+}
+
+// conditions are the conditions of s, for Describe
+func (s *sampler) conditions() []string {
+	conds := []string{fmt.Sprintf("rate %g", s.rate)}
+	if s.slow > 0 {
+		conds = append(conds, fmt.Sprintf("slow %s", s.slow))
+	}
+	return conds
+}
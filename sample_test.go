@@ -0,0 +1,134 @@
+package sqlhooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventRecorder records the Query events it gets, with the error Before and After see
+type eventRecorder struct {
+	events    []string
+	realTime  bool
+	beforeErr error
+}
+
+func (r *eventRecorder) RealTimeBefore() bool { return r.realTime }
+
+func (r *eventRecorder) BeforeQuery(ctx *Context) error {
+	r.events = append(r.events, "before "+ctx.Query+" "+errString(ctx.Error))
+	return r.beforeErr
+}
+
+func (r *eventRecorder) AfterQuery(ctx *Context) error {
+	r.events = append(r.events, "after "+ctx.Query+" "+errString(ctx.Error))
+	return ctx.Error
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
+
+// runQuery runs the Query hooks of h for an operation failing with err, taking took
+func runQuery(t *testing.T, h HookType, query string, took time.Duration, err error) error {
+	q := h.(Queryer)
+	ctx := NewContext()
+	ctx.Query = query
+	require.NoError(t, q.BeforeQuery(ctx))
+	ctx.Duration = took
+	ctx.Error = err
+	return q.AfterQuery(ctx)
+}
+
+func TestTailSampleKeepsFailedAndSlow(t *testing.T) {
+	r := &eventRecorder{}
+	h := TailSample(r, 0, 10*time.Millisecond)
+
+	errFailed := errors.New("failed")
+	assert.NoError(t, runQuery(t, h, "fast", time.Millisecond, nil))
+	assert.Equal(t, errFailed, runQuery(t, h, "failed", time.Millisecond, errFailed))
+	assert.NoError(t, runQuery(t, h, "slow", 10*time.Millisecond, nil))
+
+	assert.Equal(t, []string{
+		"before failed <nil>", "after failed failed",
+		"before slow <nil>", "after slow <nil>",
+	}, r.events)
+}
+
+func TestTailSampleRate(t *testing.T) {
+	r := &eventRecorder{}
+	h := TailSample(r, 0.5, 0)
+	draws := []float64{0.7, 0.2, 0.5}
+	h.(*sampler).random = func() float64 {
+		draw := draws[0]
+		draws = draws[1:]
+		return draw
+	}
+
+	for _, query := range []string{"a", "b", "c"} {
+		assert.NoError(t, runQuery(t, h, query, time.Hour, nil))
+	}
+	assert.Equal(t, []string{"before b <nil>", "after b <nil>"}, r.events)
+
+	r = &eventRecorder{}
+	h = TailSample(r, 1, 0)
+	assert.NoError(t, runQuery(t, h, "a", 0, nil))
+	assert.Len(t, r.events, 2)
+}
+
+func TestTailSampleBeforeError(t *testing.T) {
+	errBefore := errors.New("before")
+	r := &eventRecorder{beforeErr: errBefore}
+	h := TailSample(r, 1, 0)
+
+	assert.Equal(t, errBefore, runQuery(t, h, "q", 0, nil))
+	assert.Equal(t, []string{"before q <nil>"}, r.events)
+}
+
+func TestTailSampleRealTimeBefore(t *testing.T) {
+	sampled := &eventRecorder{}
+	tracer := &eventRecorder{realTime: true}
+	h := TailSample(Compose(sampled, tracer), 0, 0)
+
+	q := h.(Queryer)
+	ctx := NewContext()
+	ctx.Query = "q"
+	require.NoError(t, q.BeforeQuery(ctx))
+	assert.Equal(t, []string{"before q <nil>"}, tracer.events)
+	assert.NoError(t, q.AfterQuery(ctx))
+
+	assert.Empty(t, sampled.events)
+	assert.Equal(t, []string{"before q <nil>", "after q <nil>"}, tracer.events)
+
+	assert.Equal(t, tracer, TailSample(tracer, 0, 0), "nothing left to sample")
+}
+
+func TestTailSampleThroughDriver(t *testing.T) {
+	r := &eventRecorder{}
+	dc := &recordingConn{}
+	c, err := NewDriver("", TailSample(r, 0, time.Hour)).wrap(context.Background(), "", dc)
+	require.NoError(t, err)
+
+	rows, err := c.(*conn).QueryContext(context.Background(), "SELECT 1", nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	assert.Equal(t, []string{"query SELECT 1 []"}, dc.log)
+	assert.Empty(t, r.events)
+}
+
+func TestDescribeTailSample(t *testing.T) {
+	d := NewDriver("", TailSample(namedHooks{"sampled"}, 0.1, 5*time.Millisecond))
+	assert.Equal(t, []HookDescription{
+		{Name: "TailSample", Source: "driver", Conditions: []string{"rate 0.1", "slow 5ms"}, Hooks: []HookDescription{
+			{Name: "sampled", Interfaces: []string{"Queryer"}},
+		}},
+	}, d.Describe())
+	assert.Equal(t, []string{"sampled"}, d.DryDispatch(context.Background(), "SELECT 1")[OpQuery])
+}
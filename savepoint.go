@@ -0,0 +1,98 @@
+package sqlhooks
+
+import "strings"
+
+// Savepointer is the interface implemented by objects that wants to hook to
+// savepoint statements executed within a transaction.
+// They are called once the statement succeeds, txID is the id of the
+// enclosing transaction, as found in Context.TxID, or 0 outside transactions.
+type Savepointer interface {
+	Savepoint(txID uint64, name string)
+	ReleaseSavepoint(txID uint64, name string)
+	RollbackToSavepoint(txID uint64, name string)
+}
+
+func isSavepointer(h HookType) bool {
+	_, ok := h.(Savepointer)
+	return ok
+}
+
+type savepointKind int
+
+const (
+	notSavepoint savepointKind = iota
+	savepointStmt
+	releaseSavepointStmt
+	rollbackToSavepointStmt
+)
+
+// consumeKeyword removes keyword, case insensitive, from the beginning of s
+func consumeKeyword(s, keyword string) (string, bool) {
+	s = strings.TrimLeft(s, " \t\r\n")
+	if len(s) <= len(keyword) || !strings.EqualFold(s[:len(keyword)], keyword) {
+		return s, false
+	}
+
+	switch s[len(keyword)] {
+	case ' ', '\t', '\r', '\n':
+		return s[len(keyword):], true
+	}
+	return s, false
+}
+
+func unquoteIdentifier(name string) string {
+	name = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(name), ";"))
+	if len(name) < 2 {
+		return name
+	}
+
+	switch open, close := name[0], name[len(name)-1]; {
+	case open == '"' && close == '"', open == '`' && close == '`':
+		q := string(open)
+		return strings.Replace(name[1:len(name)-1], q+q, q, -1)
+	case open == '[' && close == ']':
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// parseSavepoint recognizes the statements:
+//	SAVEPOINT name
+//	RELEASE [SAVEPOINT] name
+//	ROLLBACK [TRANSACTION | WORK] TO [SAVEPOINT] name
+func parseSavepoint(query string) (savepointKind, string) {
+	if rest, ok := consumeKeyword(query, "SAVEPOINT"); ok {
+		return savepointStmt, unquoteIdentifier(rest)
+	}
+
+	if rest, ok := consumeKeyword(query, "RELEASE"); ok {
+		rest, _ = consumeKeyword(rest, "SAVEPOINT")
+		return releaseSavepointStmt, unquoteIdentifier(rest)
+	}
+
+	if rest, ok := consumeKeyword(query, "ROLLBACK"); ok {
+		if r, ok := consumeKeyword(rest, "TRANSACTION"); ok {
+			rest = r
+		} else if r, ok := consumeKeyword(rest, "WORK"); ok {
+			rest = r
+		}
+
+		if rest, ok = consumeKeyword(rest, "TO"); ok {
+			rest, _ = consumeKeyword(rest, "SAVEPOINT")
+			return rollbackToSavepointStmt, unquoteIdentifier(rest)
+		}
+	}
+
+	return notSavepoint, ""
+}
+
+func (kind savepointKind) dispatch(h Savepointer, txID uint64, name string) {
+	switch kind {
+	case savepointStmt:
+		h.Savepoint(txID, name)
+	case releaseSavepointStmt:
+		h.ReleaseSavepoint(txID, name)
+	case rollbackToSavepointStmt:
+		h.RollbackToSavepoint(txID, name)
+	}
+}
@@ -0,0 +1,113 @@
+package sqlhooks
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSavepoint(t *testing.T) {
+	tests := []struct {
+		query string
+		kind  savepointKind
+		name  string
+	}{
+		{"SAVEPOINT sp1", savepointStmt, "sp1"},
+		{"  savepoint sp1;", savepointStmt, "sp1"},
+		{`SavePoint "my ""sp"""`, savepointStmt, `my "sp"`},
+		{"SAVEPOINT `sp1`", savepointStmt, "sp1"},
+		{"SAVEPOINT [sp1]", savepointStmt, "sp1"},
+		{"RELEASE SAVEPOINT sp1", releaseSavepointStmt, "sp1"},
+		{"release\tsavepoint\n\"sp1\"", releaseSavepointStmt, "sp1"},
+		{"RELEASE sp1", releaseSavepointStmt, "sp1"},
+		{"ROLLBACK TO SAVEPOINT sp1", rollbackToSavepointStmt, "sp1"},
+		{"rollback to `sp1`", rollbackToSavepointStmt, "sp1"},
+		{"ROLLBACK TRANSACTION TO SAVEPOINT sp1", rollbackToSavepointStmt, "sp1"},
+		{"Rollback Work To \"sp1\"", rollbackToSavepointStmt, "sp1"},
+		{"ROLLBACK", notSavepoint, ""},
+		{"SAVEPOINTS", notSavepoint, ""},
+		{"SELECT 'SAVEPOINT sp1'", notSavepoint, ""},
+		{"INSERT INTO savepoint VALUES (1)", notSavepoint, ""},
+	}
+
+	for _, test := range tests {
+		kind, name := parseSavepoint(test.query)
+		assert.Equal(t, test.kind, kind, test.query)
+		assert.Equal(t, test.name, name, test.query)
+	}
+}
+
+type savepointMock struct {
+	*HooksMock
+	events []string
+}
+
+func (m *savepointMock) Savepoint(txID uint64, name string) {
+	m.events = append(m.events, fmt.Sprintf("savepoint %d %s", txID, name))
+}
+
+func (m *savepointMock) ReleaseSavepoint(txID uint64, name string) {
+	m.events = append(m.events, fmt.Sprintf("release %d %s", txID, name))
+}
+
+func (m *savepointMock) RollbackToSavepoint(txID uint64, name string) {
+	m.events = append(m.events, fmt.Sprintf("rollback-to %d %s", txID, name))
+}
+
+func TestSavepointHooks(t *testing.T) {
+	for _, execHooks := range []bool{true, false} {
+		q := queries[*driverFlag]
+
+		var execs []string
+		var txID uint64
+		record := func(ctx *Context) error {
+			execs = append(execs, ctx.Query)
+			return nil
+		}
+
+		hooks := &savepointMock{HooksMock: &HooksMock{
+			beforeExec:     record,
+			beforePrepare:  record,
+			beforeStmtExec: record,
+			afterExec: func(ctx *Context) error {
+				return ctx.Error
+			},
+			afterBegin: func(ctx *Context) error {
+				txID = ctx.TxID
+				return ctx.Error
+			},
+		}}
+
+		openDBWithHooks(t, nil).Close()
+		db, err := Open(*driverFlag, *dsnFlag, hooks, WithSavepointExecHooks(execHooks))
+		require.NoError(t, err)
+
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		for _, query := range []string{`savepoint "sp1"`, "Rollback To Savepoint sp1", "RELEASE sp1"} {
+			_, err := tx.Exec(query)
+			require.NoError(t, err)
+		}
+		_, err = tx.Exec(q.insert, "foo", "bar")
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+
+		assert.NotZero(t, txID)
+		assert.Equal(t, []string{
+			fmt.Sprintf("savepoint %d sp1", txID),
+			fmt.Sprintf("rollback-to %d sp1", txID),
+			fmt.Sprintf("release %d sp1", txID),
+		}, hooks.events)
+
+		if execHooks {
+			assert.Contains(t, execs, "RELEASE sp1")
+		} else {
+			assert.NotContains(t, execs, "RELEASE sp1")
+		}
+		assert.Contains(t, execs, q.insert)
+
+		require.NoError(t, db.Close())
+	}
+}
@@ -0,0 +1,85 @@
+package sqlhooks
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// Schema returns the schema, or postgres search_path, of the connection.
+// It's read from the search_path parameter of the DSN, set by WithSchemaProbe
+// or SetSchema, and updated by the SET search_path statements run through the driver.
+func (d *ConnData) Schema() string {
+	return d.schema
+}
+
+// SetSchema sets the schema of the connection, reported in Context.Schema
+func (d *ConnData) SetSchema(schema string) {
+	d.schema = schema
+}
+
+// WithSchemaProbe sets the schema of every new connection to the result of
+// query, such as SHOW search_path. Failures are ignored, leaving the schema
+// of the DSN, if any.
+func WithSchemaProbe(query string) Option {
+	return func(d *Driver) {
+		d.onConnect = append(d.onConnect, func(stdCtx context.Context, c *conn) error {
+			if schema, err := queryString(stdCtx, c.Conn, query); err == nil {
+				c.data.schema = schema
+			}
+			return nil
+		})
+	}
+}
+
+// schemaFromDSN returns the search_path parameter of a URL or key=value DSN
+func schemaFromDSN(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		if u, err := url.Parse(dsn); err == nil {
+			return u.Query().Get("search_path")
+		}
+		return ""
+	}
+
+	for _, field := range strings.Fields(dsn) {
+		if strings.HasPrefix(field, "search_path=") {
+			return strings.Trim(field[len("search_path="):], `'"`)
+		}
+	}
+	return ""
+}
+
+// parseSetSearchPath returns the search_path set by query, ok is false if
+// it's not a SET [SESSION] search_path statement. SET LOCAL statements are
+// ignored since they only last for the transaction.
+func parseSetSearchPath(query string) (schema string, ok bool) {
+	if len(query) < 3 || !strings.EqualFold(query[:3], "SET") {
+		return "", false
+	}
+
+	query = strings.Replace(strings.TrimRight(query, "; \t\r\n"), "=", " = ", 1)
+	fields := strings.Fields(query)
+	if len(fields) > 1 && strings.EqualFold(fields[1], "SESSION") {
+		fields = append(fields[:1], fields[2:]...)
+	}
+	if len(fields) < 3 || !strings.EqualFold(fields[0], "SET") || !strings.EqualFold(fields[1], "search_path") {
+		return "", false
+	}
+
+	value := fields[2:]
+	if strings.EqualFold(value[0], "TO") || value[0] == "=" {
+		value = value[1:]
+	}
+	schema = strings.Join(value, " ")
+	return strings.NewReplacer(`"`, "", "'", "").Replace(schema), true
+}
+
+// updateSchema records the search_path set by a successful query
+func (c *conn) updateSchema(query string, err error) {
+	if err != nil {
+		return
+	}
+	if schema, ok := parseSetSearchPath(query); ok {
+		c.data.schema = schema
+	}
+}
@@ -0,0 +1,74 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSetSearchPath(t *testing.T) {
+	for query, expected := range map[string]string{
+		"SET search_path TO tenant1":             "tenant1",
+		"set search_path = tenant1, public;":     "tenant1, public",
+		"SET SESSION search_path TO \"tenant2\"": "tenant2",
+		"SET search_path='tenant3'":              "tenant3",
+	} {
+		schema, ok := parseSetSearchPath(query)
+		assert.True(t, ok, query)
+		assert.Equal(t, expected, schema, query)
+	}
+
+	for _, query := range []string{"SET LOCAL search_path TO x", "SET timezone TO 'UTC'", "SELECT 1", ""} {
+		_, ok := parseSetSearchPath(query)
+		assert.False(t, ok, query)
+	}
+}
+
+func TestSchemaFromDSN(t *testing.T) {
+	assert.Equal(t, "tenant1", schemaFromDSN("postgres://localhost/db?search_path=tenant1"))
+	assert.Equal(t, "tenant1", schemaFromDSN("host=localhost search_path=tenant1 sslmode=disable"))
+	assert.Equal(t, "", schemaFromDSN("user@tcp(localhost)/db"))
+}
+
+// execConn accepts any Exec
+type execConn struct {
+	failingBeginConn
+}
+
+func (execConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func TestSetSearchPathUpdatesSchema(t *testing.T) {
+	var schemas []string
+	hooks := &HooksMock{
+		beforeExec: func(ctx *Context) error {
+			schemas = append(schemas, ctx.Schema)
+			return nil
+		},
+	}
+
+	_c, err := NewDriver("", hooks).wrap(context.Background(), "host=localhost search_path=public", execConn{})
+	require.NoError(t, err)
+	c := _c.(*conn)
+
+	for _, query := range []string{
+		"SELECT 1",
+		"SET search_path TO tenant1",
+		"SELECT 1",
+		"SET LOCAL search_path TO tenant2",
+		"SELECT 1",
+	} {
+		_, err := c.Exec(query, nil)
+		require.NoError(t, err)
+	}
+	c.newContext().Conn().SetSchema("tenant3")
+	c.Exec("SELECT 1", nil)
+
+	assert.Equal(t, []string{"public", "public", "tenant1", "tenant1", "tenant1", "tenant3"}, schemas)
+	require.NoError(t, c.Close())
+	assert.Empty(t, c.newContext().Schema)
+}
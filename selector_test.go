@@ -0,0 +1,75 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dsnDriver opens a new recordingConn for every connection, keeping them by dsn
+type dsnDriver struct {
+	conns map[string][]*recordingConn
+}
+
+func (d *dsnDriver) Open(dsn string) (driver.Conn, error) {
+	c := &recordingConn{}
+	d.conns[dsn] = append(d.conns[dsn], c)
+	return c, nil
+}
+
+func TestHooksSelector(t *testing.T) {
+	var analytics, others []string
+	record := func(log *[]string) HookType {
+		return &FuncHooks{Exec: Funcs{After: func(ctx *Context) error {
+			*log = append(*log, ctx.Query)
+			return ctx.Error
+		}}}
+	}
+	analyticsHooks, otherHooks := record(&analytics), record(&others)
+
+	var defaults []string
+	SetDefaultHooks(record(&defaults))
+	defer SetDefaultHooks(nil)
+
+	dd := &dsnDriver{conns: make(map[string][]*recordingConn)}
+	d := NewDriver("", record(&others), WithHooksSelector(func(dsn string) HookType {
+		switch dsn {
+		case "analytics":
+			return analyticsHooks
+		case "others":
+			return otherHooks
+		}
+		return nil
+	}))
+	d.driver = dd
+	name := RegisterUnique("selector", d)
+
+	for _, dsn := range []string{"analytics", "others", "plain"} {
+		db, err := sql.Open(name, dsn)
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT " + dsn)
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+	}
+
+	assert.Equal(t, []string{"INSERT analytics"}, analytics)
+	assert.Equal(t, []string{"INSERT others"}, others, "the driver hooks are replaced")
+	assert.Equal(t, []string{"INSERT analytics", "INSERT others"}, defaults)
+
+	// Connections without hooks aren't wrapped
+	c, err := d.Open("plain")
+	require.NoError(t, err)
+	assert.Equal(t, dd.conns["plain"][1], c)
+
+	// SetHooks doesn't change the hooks of the selected connections
+	c, err = d.Open("analytics")
+	require.NoError(t, err)
+	d.SetHooks(nil)
+	_, err = c.(*conn).ExecContext(context.Background(), "UPDATE analytics", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INSERT analytics", "UPDATE analytics"}, analytics)
+}
@@ -0,0 +1,259 @@
+package sqlhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// selfTestQueries are the round trips of SelfTest for the systems where
+// SELECT 1 isn't valid, by SystemInfo name
+var selfTestQueries = map[string]string{
+	"oracle": "SELECT 1 FROM DUAL",
+}
+
+// WithSelfTestQuery sets the query SelfTest runs, SELECT 1 or the
+// equivalent of the system by default. It must be harmless, and return rows.
+func WithSelfTestQuery(query string) Option {
+	return func(d *Driver) {
+		d.selfTestQuery = query
+	}
+}
+
+// Report is the result of SelfTest. It prints as a list of its findings,
+// and marshals to JSON with durations in nanoseconds.
+type Report struct {
+	// Driver and System are the name of the underlying driver and its
+	// system, DSN the data source name of the connection, without its
+	// password, see SanitizeDSN
+	Driver string `json:"driver"`
+	System string `json:"db_system,omitempty"`
+	DSN    string `json:"dsn,omitempty"`
+
+	// Hooks are the hooks of the Driver, as Driver.DescribeContext returns them
+	Hooks []HookDescription `json:"hooks"`
+
+	// Interfaces are the optional driver interfaces the underlying
+	// connection implements, as ExecerContext, and ContextAware is set when
+	// it implements the context variant of every one it implements
+	Interfaces   []string `json:"interfaces"`
+	ContextAware bool     `json:"context_aware"`
+
+	// Query is the round trip run, Path the driver interface it was
+	// dispatched to, see Path
+	Query string `json:"query"`
+	Path  string `json:"path,omitempty"`
+
+	// Before and After are the number of Before and After hooks the canary
+	// got for the query, 1 each when the hooks are wired
+	Before int `json:"before"`
+	After  int `json:"after"`
+
+	// Duration is how long the driver took to run the query, and Overhead
+	// the time the round trip took beyond it, in database/sql, the wrapper
+	// and the hooks
+	Duration time.Duration `json:"duration_ns"`
+	Overhead time.Duration `json:"overhead_ns"`
+
+	// Server and Schema are the ones of the connection, as the probes of
+	// WithServerInfo and WithSchemaProbe found them
+	Server string `json:"server,omitempty"`
+	Schema string `json:"schema,omitempty"`
+
+	// Problems are the findings which need a look before deploying
+	Problems []string `json:"problems,omitempty"`
+}
+
+// OK reports whether the self-test found no problem
+func (r Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r Report) String() string {
+	var b bytes.Buffer
+	if r.OK() {
+		b.WriteString("sqlhooks self-test: ok\n")
+	} else {
+		fmt.Fprintf(&b, "sqlhooks self-test: %d problems\n", len(r.Problems))
+	}
+	line := func(name, format string, args ...interface{}) {
+		fmt.Fprintf(&b, "  %-11s "+format+"\n", append([]interface{}{name + ":"}, args...)...)
+	}
+	line("driver", "%s (%s)", r.Driver, r.System)
+	line("dsn", "%s", r.DSN)
+	hooks := make([]string, len(r.Hooks))
+	for i, h := range r.Hooks {
+		hooks[i] = h.Source + " " + h.String()
+	}
+	line("hooks", "%s", strings.Join(hooks, ", "))
+	line("interfaces", "%s", strings.Join(r.Interfaces, ", "))
+	line("context", "%t", r.ContextAware)
+	line("query", "%s", r.Query)
+	line("path", "%s", r.Path)
+	line("hooks run", "%d before, %d after", r.Before, r.After)
+	line("duration", "%s, overhead %s", r.Duration, r.Overhead)
+	if r.Server != "" {
+		line("server", "%s", r.Server)
+	}
+	if r.Schema != "" {
+		line("schema", "%s", r.Schema)
+	}
+	for _, problem := range r.Problems {
+		line("problem", "%s", problem)
+	}
+	return b.String()
+}
+
+// SelfTest checks how db, opened with Open or with a Driver, is wired
+// before deploying: it runs a harmless query, see WithSelfTestQuery,
+// marked as Internal, with a canary hook added to the driver hooks with
+// WithExtraHooks, and reports what the canary saw. The error is set when
+// db isn't opened through sqlhooks or the query failed, the Report has the
+// problems found otherwise.
+func SelfTest(ctx context.Context, db *sql.DB) (Report, error) {
+	d, ok := db.Driver().(*Driver)
+	if !ok {
+		return Report{}, fmt.Errorf("sqlhooks: self-test of a database opened with %T, not sqlhooks", db.Driver())
+	}
+
+	report := Report{Driver: d.name, System: d.system.Name, Hooks: d.DescribeContext(ctx)}
+	report.Query = d.selfTestQuery
+	if report.Query == "" {
+		if report.Query = selfTestQueries[d.system.Name]; report.Query == "" {
+			report.Query = "SELECT 1"
+		}
+	}
+
+	// The connection is opened, and probed, before the round trip is measured
+	if err := db.PingContext(ctx); err != nil {
+		return report, fmt.Errorf("sqlhooks: self-test ping: %v", err)
+	}
+	c := &canary{}
+	start := d.clock()
+	rows, err := db.QueryContext(WithExtraHooks(MarkInternal(ctx), c), report.Query)
+	took := d.clock.since(start)
+	if err == nil {
+		err = rows.Close()
+	}
+	c.report(&report)
+	if err != nil {
+		return report, fmt.Errorf("sqlhooks: self-test query %q: %v", report.Query, err)
+	}
+	report.Overhead = took - report.Duration
+
+	if len(report.Hooks) == 0 {
+		report.Problems = append(report.Problems, "no hooks are attached, see SetHooks")
+	}
+	switch {
+	case c.before > 0:
+	case d.hooks.Load().(hooksValue).shutdown:
+		report.Problems = append(report.Problems, "the hooks didn't run, the driver is shut down")
+	case d.ops&OpQuery == 0:
+		report.Problems = append(report.Problems, "the hooks didn't run, WithOperations disables the Query ones")
+	default:
+		report.Problems = append(report.Problems, "the hooks didn't run, the connection isn't hooked, see WithHooksSelector")
+	}
+	if c.before != c.after {
+		report.Problems = append(report.Problems, fmt.Sprintf("%d Before hooks ran for %d After hooks", c.before, c.after))
+	}
+	if c.conn != nil && !report.ContextAware {
+		report.Problems = append(report.Problems, "the driver implements interfaces without context, statements dispatched to them aren't canceled with their context")
+	}
+	return report, nil
+}
+
+// connInterfaces are the optional driver interfaces of a connection, with
+// the context variant of each legacy one
+var connInterfaces = []struct {
+	name    string
+	is      func(driver.Conn) bool
+	context string
+}{
+	{"ConnBeginTx", func(c driver.Conn) bool { _, ok := c.(driver.ConnBeginTx); return ok }, ""},
+	{"ConnPrepareContext", func(c driver.Conn) bool { _, ok := c.(driver.ConnPrepareContext); return ok }, ""},
+	{"ExecerContext", func(c driver.Conn) bool { _, ok := c.(driver.ExecerContext); return ok }, ""},
+	{"Execer", func(c driver.Conn) bool { _, ok := c.(driver.Execer); return ok }, "ExecerContext"},
+	{"QueryerContext", func(c driver.Conn) bool { _, ok := c.(driver.QueryerContext); return ok }, ""},
+	{"Queryer", func(c driver.Conn) bool { _, ok := c.(driver.Queryer); return ok }, "QueryerContext"},
+	{"Pinger", func(c driver.Conn) bool { _, ok := c.(driver.Pinger); return ok }, ""},
+	{"SessionResetter", func(c driver.Conn) bool { _, ok := c.(sessionResetter); return ok }, ""},
+	{"Validator", func(c driver.Conn) bool { _, ok := c.(validator); return ok }, ""},
+	{"NamedValueChecker", func(c driver.Conn) bool { _, ok := c.(namedValueChecker); return ok }, ""},
+}
+
+// implementedByConn returns the names of the optional driver interfaces c
+// implements, and whether it implements the context variant of each of them
+func implementedByConn(c driver.Conn) ([]string, bool) {
+	var names []string
+	implements := make(map[string]bool)
+	for _, i := range connInterfaces {
+		if i.is(c) {
+			names = append(names, i.name)
+			implements[i.name] = true
+		}
+	}
+
+	// Prepare and Begin are required, without context
+	aware := implements["ConnBeginTx"] && implements["ConnPrepareContext"]
+	for _, i := range connInterfaces {
+		if i.context != "" && implements[i.name] && !implements[i.context] {
+			aware = false
+		}
+	}
+	return names, aware
+}
+
+// canary is the hook of SelfTest, it counts the Query and StmtQuery hooks
+// run and keeps the Context of the After one
+type canary struct {
+	before, after int
+	ctx           *Context
+	conn          *ConnData
+}
+
+func (c *canary) Name() string {
+	return "self-test canary"
+}
+
+func (c *canary) beforeQuery(ctx *Context) error {
+	c.before++
+	return nil
+}
+
+func (c *canary) afterQuery(ctx *Context) error {
+	c.after++
+	c.ctx = ctx
+	c.conn = ctx.conn
+	return ctx.Error
+}
+
+func (c *canary) BeforeQuery(ctx *Context) error     { return c.beforeQuery(ctx) }
+func (c *canary) AfterQuery(ctx *Context) error      { return c.afterQuery(ctx) }
+func (c *canary) BeforeStmtQuery(ctx *Context) error { return c.beforeQuery(ctx) }
+func (c *canary) AfterStmtQuery(ctx *Context) error  { return c.afterQuery(ctx) }
+func (c *canary) BeforePrepare(ctx *Context) error   { return nil }
+func (c *canary) AfterPrepare(ctx *Context) error    { return ctx.Error }
+func (c *canary) BeforeStmtExec(ctx *Context) error  { return nil }
+func (c *canary) AfterStmtExec(ctx *Context) error   { return ctx.Error }
+
+// report fills report with what c saw
+func (c *canary) report(report *Report) {
+	report.Before, report.After = c.before, c.after
+	if c.ctx == nil {
+		return
+	}
+	report.Path = c.ctx.Path.String()
+	report.Duration = c.ctx.Duration
+	report.Server = strings.TrimSpace(c.ctx.ServerInfo.Product + " " + c.ctx.ServerInfo.Version)
+	report.Schema = c.ctx.Schema
+	if c.conn != nil {
+		report.DSN = c.conn.dsn
+		if c.conn.raw != nil {
+			report.Interfaces, report.ContextAware = implementedByConn(c.conn.raw)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfTest(t *testing.T) {
+	var queries int
+	hooks := &FuncHooks{Query: Funcs{After: func(ctx *Context) error {
+		queries++
+		assert.True(t, ctx.Internal)
+		return ctx.Error
+	}}}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";app:secret@tcp(db)/shop", hooks)
+	require.NoError(t, err)
+	defer db.Close()
+
+	report, err := SelfTest(context.Background(), db)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Problems)
+	assert.Equal(t, 1, queries)
+	assert.Equal(t, drivertest.Name, report.Driver)
+	assert.Equal(t, "context;app@tcp(db)/shop", report.DSN)
+	assert.Equal(t, "SELECT 1", report.Query)
+	assert.Equal(t, "queryer_context", report.Path)
+	assert.Equal(t, 1, report.Before)
+	assert.Equal(t, 1, report.After)
+	assert.True(t, report.ContextAware)
+	assert.Contains(t, report.Interfaces, "QueryerContext")
+	assert.Contains(t, report.Interfaces, "ConnBeginTx")
+	require.Len(t, report.Hooks, 1)
+	assert.Equal(t, "*sqlhooks.FuncHooks", report.Hooks[0].Name)
+
+	assert.True(t, strings.HasPrefix(report.String(), "sqlhooks self-test: ok\n"), report.String())
+	assert.Contains(t, report.String(), "  path:       queryer_context\n")
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, true, decoded["context_aware"])
+	assert.Equal(t, "*sqlhooks.FuncHooks", decoded["hooks"].([]interface{})[0].(map[string]interface{})["name"])
+}
+
+func TestSelfTestProblems(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		mode    string
+		hooks   HookType
+		opts    []Option
+		problem string
+		path    string
+	}{
+		{"Legacy", drivertest.ModeLegacy, &FuncHooks{}, nil, "the driver implements interfaces without context", "queryer"},
+		{"Prepare", drivertest.ModePrepare, &FuncHooks{}, nil, "the driver implements interfaces without context", "stmt_query"},
+		{"NoHooks", drivertest.ModeContext, nil, nil, "no hooks are attached", "queryer_context"},
+		{"Operations", drivertest.ModeContext, &FuncHooks{}, []Option{WithOperations(OpExec)}, "WithOperations disables the Query ones", ""},
+		{"Selector", drivertest.ModeContext, &FuncHooks{}, []Option{WithHooksSelector(func(string) HookType { return nil })}, "the connection isn't hooked", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// Open would reuse the driver of the nil hooks opened by other tests
+			name := RegisterUnique(drivertest.Name, NewDriver(drivertest.Name, test.hooks, test.opts...))
+			db, err := sql.Open(name, test.mode+";"+t.Name())
+			require.NoError(t, err)
+			defer db.Close()
+
+			report, err := SelfTest(context.Background(), db)
+			require.NoError(t, err)
+			assert.False(t, report.OK())
+			require.Len(t, report.Problems, 1)
+			assert.Contains(t, report.Problems[0], test.problem)
+			assert.Equal(t, test.path, report.Path)
+			assert.Contains(t, report.String(), "  problem:    "+report.Problems[0]+"\n")
+		})
+	}
+}
+
+func TestSelfTestQuery(t *testing.T) {
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), &FuncHooks{}, WithSelfTestQuery("ROWS 2|SELECT n"))
+	require.NoError(t, err)
+	defer db.Close()
+	report, err := SelfTest(context.Background(), db)
+	require.NoError(t, err)
+	assert.Equal(t, "ROWS 2|SELECT n", report.Query)
+
+	db, err = Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), &FuncHooks{}, WithSelfTestQuery("FAIL deadlock|SELECT 1"))
+	require.NoError(t, err)
+	defer db.Close()
+	report, err = SelfTest(context.Background(), db)
+	assert.EqualError(t, err, `sqlhooks: self-test query "FAIL deadlock|SELECT 1": drivertest: deadlock`)
+	assert.Equal(t, 1, report.After)
+
+	// Oracle has no SELECT without FROM
+	assert.Equal(t, "SELECT 1 FROM DUAL", selfTestQueries[System("godror").Name])
+}
+
+func TestSelfTestNotHooked(t *testing.T) {
+	db, err := sql.Open(drivertest.Name, drivertest.ModeContext+";"+t.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = SelfTest(context.Background(), db)
+	assert.EqualError(t, err, "sqlhooks: self-test of a database opened with drivertest.Driver, not sqlhooks")
+}
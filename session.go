@@ -0,0 +1,51 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// sessionResetter and validator are driver.SessionResetter and
+// driver.Validator, declared for the Go versions before them
+type sessionResetter interface {
+	ResetSession(ctx context.Context) error
+}
+
+type validator interface {
+	IsValid() bool
+}
+
+// ResetSession lets the underlying driver tell database/sql a connection
+// returned to the pool is broken, as when the server closed it, so it's
+// discarded instead of failing the next statement
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(sessionResetter); ok {
+		err := r.ResetSession(ctx)
+		c.broke(err)
+		return err
+	}
+	return nil
+}
+
+// IsValid is ResetSession for the check database/sql makes before putting a
+// connection back in the pool
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(validator); ok {
+		valid := v.IsValid()
+		if !valid {
+			c.broke(driver.ErrBadConn)
+		}
+		return valid
+	}
+	return true
+}
+
+// Ping lets database/sql check the connection with the underlying driver's
+// driver.Pinger, for drivers without it the connection is assumed alive, as
+// database/sql does
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
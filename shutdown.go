@@ -0,0 +1,93 @@
+package sqlhooks
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+// Shutdowner is the interface implemented by hooks running background
+// goroutines, as the ones sending their events asynchronously.
+// Shutdown stops them once their pending events are handled, giving up on
+// the ones left when ctx is done. Hooks must not panic if they're called
+// afterwards.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Shutdown stops the hooks of d: it reports the transactions and
+// statements still open as an EventOpenAtShutdown, then calls the Shutdown
+// method of the hooks implementing Shutdowner, the default hooks excluded
+// as they're shared by every Driver, and waits for them until ctx is done.
+// Operations starting afterwards run no hooks at all, as if d had none, and
+// SetHooks has no effect, the ones in progress still run their After hooks.
+// Shutdown doesn't close the connections, close the *sql.DB first.
+func (d *Driver) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapUint32(&d.shutdown, 0, 1) {
+		return nil
+	}
+	v := d.hooks.Load().(hooksValue)
+	d.hooks.Store(hooksValue{hooks: v.hooks, shutdown: true})
+
+	txs, stmts := atomic.LoadInt64(&d.diag.openTxs), atomic.LoadInt64(&d.diag.openStmts)
+	if txs > 0 || stmts > 0 {
+		d.diag.report(EventOpenAtShutdown, fmt.Errorf("sqlhooks: %d transactions and %d statements still open at shutdown", txs, stmts))
+	}
+
+	if t, ok := v.hooks.(Shutdowner); ok {
+		return t.Shutdown(ctx)
+	}
+	return nil
+}
+
+// shutdownAll calls Shutdown once on each of hooks implementing Shutdowner
+func shutdownAll(ctx context.Context, hooks []HookType) error {
+	var errs hookErrors
+	for i, h := range hooks {
+		t, ok := h.(Shutdowner)
+		if !ok || seenBefore(hooks[:i], h) {
+			continue
+		}
+		if err := t.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// seenBefore reports whether h is one of hooks, only comparable hooks can be
+func seenBefore(hooks []HookType, h HookType) bool {
+	if !reflect.TypeOf(h).Comparable() {
+		return false
+	}
+	for _, seen := range hooks {
+		if seen != nil && reflect.TypeOf(seen) == reflect.TypeOf(h) && seen == h {
+			return true
+		}
+	}
+	return false
+}
+
+func (hs composed) Shutdown(ctx context.Context) error {
+	return shutdownAll(ctx, hs)
+}
+
+func (r *router) Shutdown(ctx context.Context) error {
+	hooks := []HookType{r.fallback}
+	for _, h := range r.routes {
+		hooks = append(hooks, h)
+	}
+	return shutdownAll(ctx, hooks)
+}
+
+func (s *sampler) Shutdown(ctx context.Context) error {
+	return shutdownAll(ctx, []HookType{s.hooks})
+}
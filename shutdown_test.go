@@ -0,0 +1,117 @@
+package sqlhooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shutdownHook counts its Shutdown calls, returning err
+type shutdownHook struct {
+	calls int
+	err   error
+}
+
+func (h *shutdownHook) Shutdown(ctx context.Context) error {
+	h.calls++
+	return h.err
+}
+
+func TestShutdown(t *testing.T) {
+	var events []string
+	var errs []error
+	execs := 0
+	background := &shutdownHook{}
+	hooks := Compose(&FuncHooks{Exec: Funcs{After: func(ctx *Context) error {
+		execs++
+		return ctx.Error
+	}}}, background, background)
+
+	d := NewDriver("", hooks, WithInternalLogger(func(event string, err error) {
+		events = append(events, event)
+		errs = append(errs, err)
+	}))
+	dc, err := d.wrap(context.Background(), "", &recordingConn{})
+	require.NoError(t, err)
+	c := dc.(*conn)
+
+	_, err = c.Begin()
+	require.NoError(t, err)
+	_, err = c.Prepare("SELECT 1")
+	require.NoError(t, err)
+	s, err := c.Prepare("SELECT 2")
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	_, err = c.Exec("INSERT INTO t VALUES(1)", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, execs)
+
+	stats := d.Stats()
+	assert.Equal(t, int64(1), stats.OpenTxs)
+	assert.Equal(t, int64(1), stats.OpenStmts)
+
+	require.NoError(t, d.Shutdown(context.Background()))
+	assert.Equal(t, 1, background.calls, "composed twice, shut down once")
+	assert.Equal(t, []string{EventOpenAtShutdown}, events)
+	assert.EqualError(t, errs[0], "sqlhooks: 1 transactions and 1 statements still open at shutdown")
+
+	// hooks are a no-op from now on
+	d.SetHooks(&FuncHooks{Exec: Funcs{Before: func(ctx *Context) error {
+		return errors.New("not run")
+	}}})
+	_, err = c.Exec("INSERT INTO t VALUES(2)", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, execs)
+	assert.Equal(t, hooks, d.Hooks())
+
+	require.NoError(t, d.Shutdown(context.Background()))
+	assert.Equal(t, 1, background.calls)
+	assert.Len(t, events, 1)
+}
+
+func TestShutdownWithoutLeaks(t *testing.T) {
+	var events []string
+	d := NewDriver("", &FuncHooks{}, WithInternalLogger(func(event string, err error) {
+		events = append(events, event)
+	}))
+	sc := &slowConn{}
+	dc, err := d.wrap(context.Background(), "", sc)
+	require.NoError(t, err)
+	c := dc.(*conn)
+
+	tx, err := c.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	sc.failCommit = true
+	tx, err = c.Begin()
+	require.NoError(t, err)
+	assert.Equal(t, errCommitFailed, tx.Commit())
+
+	require.NoError(t, d.Shutdown(context.Background()))
+	assert.Empty(t, events)
+	assert.Zero(t, d.Stats().OpenTxs)
+}
+
+func TestShutdownErrors(t *testing.T) {
+	first, second := errors.New("first"), errors.New("second")
+
+	err := NewDriver("", Compose(&shutdownHook{err: first}, &FuncHooks{})).Shutdown(context.Background())
+	assert.Equal(t, first, err)
+
+	err = NewDriver("", Compose(&shutdownHook{err: first}, &shutdownHook{err: second})).Shutdown(context.Background())
+	assert.EqualError(t, err, "first\nsecond")
+
+	assert.NoError(t, NewDriver("", &FuncHooks{}).Shutdown(context.Background()))
+}
+
+func TestRouteShutdown(t *testing.T) {
+	tx, fallback := &shutdownHook{}, &shutdownHook{}
+	hooks := Route(map[Kind]HookType{KindTx: tx, KindSelect: fallback, KindDDL: nil}, fallback)
+
+	require.NoError(t, hooks.(Shutdowner).Shutdown(context.Background()))
+	assert.Equal(t, 1, tx.calls)
+	assert.Equal(t, 1, fallback.calls)
+}
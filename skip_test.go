@@ -0,0 +1,96 @@
+package sqlhooks
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// skippingConn skips the statements of skip, as drivers do for the ones
+// they can only run prepared
+type skippingConn struct {
+	*recordingConn
+	skip map[string]bool
+}
+
+func (c *skippingConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	if c.skip[query] {
+		c.log = append(c.log, "skip "+query)
+		return nil, driver.ErrSkip
+	}
+	return c.recordingConn.Exec(query, args)
+}
+
+func (c *skippingConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	if c.skip[query] {
+		c.log = append(c.log, "skip "+query)
+		return nil, driver.ErrSkip
+	}
+	return c.recordingConn.Query(query, args)
+}
+
+type skippingDriver struct {
+	conn *skippingConn
+}
+
+func (d skippingDriver) Open(string) (driver.Conn, error) { return d.conn, nil }
+
+func openSkipping(t *testing.T, hooks HookType, skip ...string) (*sql.DB, *skippingConn) {
+	dc := &skippingConn{recordingConn: &recordingConn{}, skip: make(map[string]bool)}
+	for _, query := range skip {
+		dc.skip[query] = true
+	}
+	d := NewDriver("", hooks)
+	d.driver = skippingDriver{dc}
+	db, err := sql.Open(RegisterUnique("skipping", d), "")
+	require.NoError(t, err)
+	return db, dc
+}
+
+func TestSkippedStatementsRunHooksOnce(t *testing.T) {
+	var events []string
+	record := func(event string) func(*Context) error {
+		return func(ctx *Context) error {
+			if ctx.Error != nil {
+				event += " " + ctx.Error.Error()
+			}
+			events = append(events, event+" "+ctx.Query)
+			return ctx.Error
+		}
+	}
+	funcs := func(op string) Funcs {
+		return Funcs{Before: record("before " + op), After: record("after " + op)}
+	}
+	hooks := &FuncHooks{
+		Query:     funcs("query"),
+		Exec:      funcs("exec"),
+		Prepare:   funcs("prepare"),
+		StmtQuery: funcs("stmtquery"),
+		StmtExec:  funcs("stmtexec"),
+	}
+
+	db, dc := openSkipping(t, hooks, "INSERT skipped", "SELECT skipped")
+	defer db.Close()
+
+	_, err := db.Exec("INSERT skipped", 1)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT run", 2)
+	require.NoError(t, err)
+	rows, err := db.Query("SELECT skipped", 3)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	assert.Equal(t, []string{
+		"before exec INSERT skipped", "after exec INSERT skipped",
+		"before exec INSERT run", "after exec INSERT run",
+		"before query SELECT skipped", "after query SELECT skipped",
+	}, events)
+	assert.Equal(t, []string{
+		"skip INSERT skipped", "prepare INSERT skipped", "exec [1]",
+		"exec INSERT run [2]",
+		"skip SELECT skipped", "prepare SELECT skipped", "query [3]",
+	}, dc.log)
+}
@@ -52,28 +52,125 @@ package sqlhooks
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	"time"
+	"reflect"
+	"sync"
 )
 
 var (
-	drivers = make(map[interface{}]string)
+	// registryMu guards drivers, registered, bases and lastID
+	registryMu sync.Mutex
+	drivers    = make(map[interface{}]string)
+	registered []string
+	bases      = make(map[string]string)
+	lastID     = make(map[string]int)
 )
 
+// defaultHooks are composed with the hooks of every driver created
+var defaultHooks struct {
+	sync.Mutex
+	hooks HookType
+}
+
+// SetDefaultHooks sets the hooks every Driver created afterwards runs along
+// with its own hooks, as in Compose(hooks, driverHooks): the default Before
+// hooks run first and the default After hooks last. Drivers which are already
+// created keep the default hooks they were created with, nil disables them.
+func SetDefaultHooks(hooks HookType) {
+	defaultHooks.Lock()
+	defer defaultHooks.Unlock()
+	defaultHooks.hooks = hooks
+}
+
+func getDefaultHooks() HookType {
+	defaultHooks.Lock()
+	defer defaultHooks.Unlock()
+	return defaultHooks.hooks
+}
+
 // Open Register a sqlhook driver and opens a connection against it,
 // driverName is the driver where we're attaching to.
-func Open(driverName, dsn string, hooks HookType) (*sql.DB, error) {
-	if registeredName, ok := drivers[hooks]; ok {
-		return sql.Open(registeredName, dsn)
-	}
+// opts are only applied the first time a given hooks is registered.
+// Hooks that can't be compared, as the ones returned by Compose, get a new
+// driver registered every time.
+// As NewDriverE, it fails if driverName isn't registered, unless WithLazyDriver is given.
+func Open(driverName, dsn string, hooks HookType, opts ...Option) (*sql.DB, error) {
+	cached := hooks == nil || reflect.TypeOf(hooks).Comparable()
 
-	registeredName := fmt.Sprintf("sqlhooks:%d", time.Now().UnixNano())
-	sql.Register(registeredName, NewDriver(driverName, hooks))
-	drivers[hooks] = registeredName
+	registryMu.Lock()
+	registeredName, ok := "", false
+	if cached {
+		registeredName, ok = drivers[hooks]
+	}
+	if !ok {
+		d, err := NewDriverE(driverName, hooks, opts...)
+		if err != nil {
+			registryMu.Unlock()
+			return nil, err
+		}
+		registeredName = registerUnique(driverName, d)
+		if cached {
+			drivers[hooks] = registeredName
+		}
+	}
+	registryMu.Unlock()
 
 	return sql.Open(registeredName, dsn)
 }
 
+// RegisterUnique registers d with sql.Register under the first name of the
+// form base-hooks-1, base-hooks-2, ... that's not registered yet, and returns it
+func RegisterUnique(base string, d driver.Driver) string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return registerUnique(base, d)
+}
+
+func registerUnique(base string, d driver.Driver) string {
+	taken := make(map[string]bool)
+	for _, name := range sql.Drivers() {
+		taken[name] = true
+	}
+
+	for {
+		lastID[base]++
+		name := fmt.Sprintf("%s-hooks-%d", base, lastID[base])
+		if !taken[name] {
+			sql.Register(name, d)
+			registered = append(registered, name)
+			bases[name] = base
+			if hd, ok := d.(*Driver); ok && hd.name != "" {
+				bases[name] = hd.name
+			}
+			return name
+		}
+	}
+}
+
+// Registered returns the names of the drivers registered by Open and
+// RegisterUnique in registration order, each name starts with its base driver name
+func Registered() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return append([]string(nil), registered...)
+}
+
+// BaseDriverName returns the name of the driver wrapped by the driver
+// registered by Open or RegisterUnique as name, other names are returned as
+// they are. Libraries choosing the SQL dialect by driver name, as sqlx does
+// for bindvars, need the base name:
+//	db, err := sqlx.Open(name, dsn)
+//	db = sqlx.NewDb(db.DB, sqlhooks.BaseDriverName(name))
+func BaseDriverName(name string) string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if base, ok := bases[name]; ok {
+		return base
+	}
+	return name
+}
+
 /*
 HookType is the type of Hook.
 In order to reduce the amount boilerplate, it's organized by database operations,
@@ -85,32 +182,82 @@ This type is an alias for interface{}, however the hook should implement at leas
 	- Stmter
 	- Queryer
 	- Execer
+	- Explainer
+	- Savepointer
+	- RowsCloser
+	- RowsWrapper
+	- TxSummaryHook
+	- BatchSummaryHook
+	- ConnSummaryHook
+	- CopyHook
+	- ResultErrorHook
+	- Shutdowner
+	- StillRunner
+
+Hooks embedding NopHooks implement the Before and After hooks they don't declare as no-ops.
 
 Every hook can be attached Before or After the operation.
 Before hooks are triggered just before execute the operation (Begin, Commit, Rollback, Prepare, Query, Exec),
 if they returns an error, neither the operation nor the After hook will executed, and the error will be returned to the caller
+Before Query, Exec and Stmt hooks can also make the operation return a result of their own
+without calling the driver, see Context.SubstituteResult.
 
 After hooks are triggered after the operation complete, the there is an error it will be passed inside *Context.
 The error returned by an After hook will override the error returned from the operation, that's why in most cases
 an after hooks should:
 	return ctx.Error
 
+Compose documents how the errors of several hooks combine.
+
+Concurrency
+
+The hooks are shared by every connection of the driver and database/sql uses
+its connections from many goroutines, so hooks running on different connections
+run concurrently: any state a hook keeps for itself must be synchronized.
+Hooks running on the same connection are serialized, database/sql never uses a
+connection from two goroutines at once, that covers the operations of a
+transaction, the executions of a statement and the Next and Close of its
+rows, so neither the *Context nor Context.Conn need locking.
+A *sql.Stmt is prepared again on every connection it's used from, so its
+executions on different connections do run concurrently, each with its own *Context.
+
+On a connection, hooks run in the order database/sql calls the driver: the
+Before hooks of an operation, its driver call and its After hooks run in a row,
+from the goroutine calling the driver, with no hook of another operation of
+the connection in between. The same goes for the rows hooks, run within the
+Next and Close calls of the rows. No hook is run asynchronously, StillRunning
+aside, so invariants spanning the statements of a connection, as keyed by
+Context.ConnID, can be checked from the hooks without any reordering.
+
+The *Context is reused by the later executions of a prepared statement, hooks
+must not keep it once the After hook returned, copy what they need instead.
+Functions given as options, like WithInternalLogger, WithTraceExtractor or
+WithOnConnect, are called concurrently as well.
 */
 type HookType interface{}
 
-// Beginner is the interface implemented by objects that wants to hook to Begin function
+// Beginner is the interface implemented by objects that wants to hook to Begin function.
+// A Begin the driver fails still runs the After hook, with Error set and a
+// TxID of its own, no Commit or Rollback hook follows it. When AfterBegin
+// fails a Begin the driver succeeded, the transaction is rolled back, without
+// any hook.
 type Beginner interface {
 	BeforeBegin(*Context) error
 	AfterBegin(*Context) error
 }
 
-// Commiter is the interface implemented by objects that wants to hook to Commit function
+// Commiter is the interface implemented by objects that wants to hook to Commit function.
+// database/sql ends the transaction whatever Commit returns, so when
+// BeforeCommit fails the transaction is rolled back, without any hook, and
+// the error returned. The same goes for BeforeRollback.
 type Commiter interface {
 	BeforeCommit(*Context) error
 	AfterCommit(*Context) error
 }
 
-// Rollbacker is the interface implemented by objects that wants to hook to Rollback function
+// Rollbacker is the interface implemented by objects that wants to hook to Rollback function.
+// database/sql rolls back by itself the transactions whose context is done,
+// those rollbacks run the hooks as well, with Context.CausedByContext set.
 type Rollbacker interface {
 	BeforeRollback(*Context) error
 	AfterRollback(*Context) error
@@ -128,13 +275,17 @@ type Stmter interface {
 	AfterStmtExec(*Context) error
 }
 
-// Queryer is the interface implemented by objects that wants to hook to Query function
+// Queryer is the interface implemented by objects that wants to hook to Query function.
+// A query the driver skips, returning driver.ErrSkip, is prepared and run
+// within the Query hooks, as database/sql would, so they run once for it with
+// the result of the prepared one, and no Prepare or StmtQuery hook follows.
 type Queryer interface {
 	BeforeQuery(*Context) error
 	AfterQuery(*Context) error
 }
 
-// Execer is the interface implemented by objects that wants to hook to Exec function
+// Execer is the interface implemented by objects that wants to hook to Exec function.
+// Statements the driver skips run within the Exec hooks as well, see Queryer.
 type Execer interface {
 	BeforeExec(*Context) error
 	AfterExec(*Context) error
@@ -7,5 +7,7 @@ func init() {
 		insert:      "INSERT|t|f1=?,f2=?",
 		selectwhere: "SELECT|t|f1,f2|f1=?,f2=?",
 		selectall:   "SELECT|t|f1,f2|",
+		namedinsert: "INSERT|t|f1=:f1,f2=:f2",
+		namedselect: "SELECT|t|f1,f2|f1=:f1,f2=:f2",
 	}
 }
@@ -11,5 +11,7 @@ func init() {
 		insert:      "INSERT INTO t VALUES($1, $2)",
 		selectwhere: "SELECT f1, f2 FROM t WHERE f1=$1 AND f2=$2",
 		selectall:   "SELECT f1, f2 FROM t",
+		namedinsert: "INSERT INTO t VALUES(:f1, :f2)",
+		namedselect: "SELECT f1, f2 FROM t WHERE f1=:f1 AND f2=:f2",
 	}
 }
@@ -11,5 +11,7 @@ func init() {
 		insert:      "INSERT INTO t VALUES(?, ?)",
 		selectwhere: "SELECT f1, f2 FROM t WHERE f1=? AND f2=?",
 		selectall:   "SELECT f1, f2 FROM t",
+		namedinsert: "INSERT INTO t VALUES(:f1, :f2)",
+		namedselect: "SELECT f1, f2 FROM t WHERE f1=:f1 AND f2=:f2",
 	}
 }
@@ -0,0 +1,148 @@
+// +build sqlx
+
+package sqlhooks
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sqlxRow struct {
+	F1 string `db:"f1"`
+	F2 string `db:"f2"`
+}
+
+// openSqlx opens the hooked driver by its registered name, as sqlx.Open
+// users do, it returns the queries the hooks get
+func openSqlx(t *testing.T) (*sqlx.DB, *[]string) {
+	openDBWithHooks(t, nil).Close()
+
+	var seen []string
+	hooks := NewHooksMock(
+		func(ctx *Context) error {
+			seen = append(seen, ctx.Query)
+			return nil
+		},
+		func(ctx *Context) error {
+			return ctx.Error
+		},
+	)
+
+	name := RegisterUnique(*driverFlag, NewDriver(*driverFlag, hooks))
+	db, err := sqlx.Open(name, *dsnFlag)
+	require.NoError(t, err)
+
+	db = sqlx.NewDb(db.DB, BaseDriverName(name))
+	require.Equal(t, *driverFlag, db.DriverName())
+	return db, &seen
+}
+
+// assertRebound checks the hooks got named query rebound for the driver
+func assertRebound(t *testing.T, db *sqlx.DB, seen []string, named string, arg interface{}) {
+	query, _, err := sqlx.Named(named, arg)
+	require.NoError(t, err)
+	assert.Contains(t, seen, db.Rebind(query))
+	for _, q := range seen {
+		assert.False(t, strings.Contains(q, ":f1"), "hooks got the named query: %s", q)
+	}
+}
+
+func TestSqlxNamedExec(t *testing.T) {
+	q := queries[*driverFlag]
+	db, seen := openSqlx(t)
+	defer db.Close()
+
+	row := sqlxRow{"foo", "bar"}
+	_, err := db.NamedExec(q.namedinsert, row)
+	require.NoError(t, err)
+	_, err = db.NamedExec(q.namedinsert, map[string]interface{}{"f1": "baz", "f2": "qux"})
+	require.NoError(t, err)
+	assertRebound(t, db, *seen, q.namedinsert, row)
+
+	var rows []sqlxRow
+	require.NoError(t, db.Select(&rows, q.selectall))
+	assert.ElementsMatch(t, []sqlxRow{{"foo", "bar"}, {"baz", "qux"}}, rows)
+}
+
+func TestSqlxNamedQuery(t *testing.T) {
+	q := queries[*driverFlag]
+	db, seen := openSqlx(t)
+	defer db.Close()
+
+	_, err := db.Exec(q.insert, "foo", "bar")
+	require.NoError(t, err)
+
+	arg := map[string]interface{}{"f1": "foo", "f2": "bar"}
+	rows, err := db.NamedQuery(q.namedselect, arg)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var found []sqlxRow
+	for rows.Next() {
+		var row sqlxRow
+		require.NoError(t, rows.StructScan(&row))
+		found = append(found, row)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []sqlxRow{{"foo", "bar"}}, found)
+	assertRebound(t, db, *seen, q.namedselect, arg)
+}
+
+func TestSqlxGet(t *testing.T) {
+	q := queries[*driverFlag]
+	db, _ := openSqlx(t)
+	defer db.Close()
+
+	_, err := db.Exec(q.insert, "foo", "bar")
+	require.NoError(t, err)
+
+	var row sqlxRow
+	require.NoError(t, db.Get(&row, q.selectwhere, "foo", "bar"))
+	assert.Equal(t, sqlxRow{"foo", "bar"}, row)
+}
+
+func TestSqlxPrepared(t *testing.T) {
+	q := queries[*driverFlag]
+	db, seen := openSqlx(t)
+	defer db.Close()
+
+	insert, err := db.PrepareNamed(q.namedinsert)
+	require.NoError(t, err)
+	defer insert.Close()
+
+	row := sqlxRow{"foo", "bar"}
+	_, err = insert.Exec(row)
+	require.NoError(t, err)
+	assertRebound(t, db, *seen, q.namedinsert, row)
+
+	get, err := db.Preparex(q.selectwhere)
+	require.NoError(t, err)
+	defer get.Close()
+
+	var found sqlxRow
+	require.NoError(t, get.Get(&found, "foo", "bar"))
+	assert.Equal(t, row, found)
+}
+
+func TestSqlxUnsafe(t *testing.T) {
+	q := queries[*driverFlag]
+	db, _ := openSqlx(t)
+	defer db.Close()
+
+	_, err := db.Exec(q.insert, "foo", "bar")
+	require.NoError(t, err)
+
+	// f2 has no destination
+	var rows []struct {
+		F1 string `db:"f1"`
+	}
+	assert.Error(t, db.Select(&rows, q.selectall))
+
+	require.NoError(t, db.Unsafe().Select(&rows, q.selectall))
+	require.Len(t, rows, 1)
+	assert.Equal(t, "foo", rows[0].F1)
+}
@@ -1,12 +1,21 @@
 package sqlhooks
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gchaincl/sqlhooks/drivertest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -20,6 +29,10 @@ type ops struct {
 	insert      string
 	selectwhere string
 	selectall   string
+
+	// sqlx named queries, see sqlhooks_sqlx_test.go
+	namedinsert string
+	namedselect string
 }
 
 var queries = make(map[string]ops)
@@ -60,10 +73,12 @@ func openDBWithHooks(t *testing.T, hooks interface{}, dsnArgs ...string) *sql.DB
 func TestBeforeAndAfterHooks(t *testing.T) {
 	q := queries[*driverFlag]
 
-	for _, hook := range []string{"Query", "Exec", "Begin", "Commit", "Rollback"} {
+	for _, hook := range []string{"Query", "Exec", "Begin", "Commit", "Rollback", "StmtExec", "TxStmtExec"} {
 		beforeOk := false
+		var seen []string
 		before := func(ctx *Context) error {
 			beforeOk = true
+			seen = append(seen, ctx.Query)
 			return nil
 		}
 
@@ -99,10 +114,39 @@ func TestBeforeAndAfterHooks(t *testing.T) {
 
 			tx, _ := db.Begin()
 			tx.Rollback()
+		case "StmtExec":
+			stmt, err := db.Prepare(q.insert)
+			require.NoError(t, err)
+			_, err = stmt.Exec("a", "b")
+			require.NoError(t, err)
+			stmt.Close()
+		case "TxStmtExec":
+			hooks.beforeBegin, hooks.afterBegin = nil, nil
+			hooks.beforeCommit, hooks.afterCommit = nil, nil
+
+			stmt, err := db.Prepare(q.insert)
+			require.NoError(t, err)
+			// Holding the connection stmt was prepared on, database/sql
+			// prepares it again on the connection of the transaction
+			conn, err := db.Conn(context.Background())
+			require.NoError(t, err)
+			tx, err := db.Begin()
+			require.NoError(t, err)
+			_, err = tx.Stmt(stmt).Exec("a", "b")
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+			conn.Close()
+			stmt.Close()
+			assert.Len(t, seen, 3)
 		}
 
 		assert.True(t, beforeOk, "'Before%s' hook didn't run", hook)
 		assert.True(t, afterOk, "'After%s' hook didn't run", hook)
+		if hook == "StmtExec" || hook == "TxStmtExec" {
+			for _, query := range seen {
+				assert.Equal(t, q.insert, query, "'%s' hooks got another query", hook)
+			}
+		}
 	}
 }
 
@@ -190,6 +234,29 @@ func TestBeforeModifiesQueryAndArgs(t *testing.T) {
 	assert.True(t, found)
 }
 
+func TestStmtExecutionsGetThePreparedQuery(t *testing.T) {
+	q := queries[*driverFlag]
+
+	// The statement's Context is shared by its executions
+	var seen []string
+	before := func(ctx *Context) error {
+		seen = append(seen, ctx.Query)
+		ctx.Query = "/* changed */ " + ctx.Query
+		return nil
+	}
+	hooks := &HooksMock{beforeStmtExec: before}
+	db := openDBWithHooks(t, hooks)
+
+	stmt, err := db.Prepare(q.insert)
+	require.NoError(t, err)
+	defer stmt.Close()
+	for i := 0; i < 2; i++ {
+		_, err := stmt.Exec("a", "b")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{q.insert, q.insert}, seen)
+}
+
 func TestBeforePrepare(t *testing.T) {
 	q := queries[*driverFlag]
 
@@ -285,3 +352,673 @@ func TestDriverIsNotRegisteredTwice(t *testing.T) {
 		t.Errorf("Driver registered %d times more than expected", registeredAfterOpen-1)
 	}
 }
+
+type explainMock struct {
+	*HooksMock
+	query string
+	plans []string
+}
+
+func (m *explainMock) ExplainQuery(ctx *Context) (string, bool) {
+	return m.query, ctx.Query == m.query
+}
+
+func (m *explainMock) AfterExplain(ctx *Context, plan string, err error) {
+	m.plans = append(m.plans, plan)
+}
+
+func TestRegisterUniqueNames(t *testing.T) {
+	sql.Register("unique-hooks-2", NewDriver("test", nil))
+
+	first := RegisterUnique("unique", NewDriver("test", nil))
+	second := RegisterUnique("unique", NewDriver("test", nil))
+	assert.Equal(t, "unique-hooks-1", first)
+	assert.Equal(t, "unique-hooks-3", second)
+
+	names := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			names <- RegisterUnique("unique", NewDriver("test", nil))
+		}()
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		seen[<-names] = true
+	}
+	assert.Len(t, seen, 10)
+
+	registered := Registered()
+	assert.Contains(t, registered, first)
+	assert.Contains(t, registered, second)
+	assert.NotContains(t, registered, "unique-hooks-2")
+
+	db, err := Open("test", "db", &HooksMock{})
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Regexp(t, "^test-hooks-[0-9]+$", Registered()[len(Registered())-1])
+}
+
+func TestBaseDriverName(t *testing.T) {
+	name := RegisterUnique("base", NewDriver("test", nil))
+	assert.Equal(t, "test", BaseDriverName(name))
+
+	name = RegisterUnique("base", &fakeDriver{})
+	assert.Equal(t, "base", BaseDriverName(name))
+
+	assert.Equal(t, "postgres", BaseDriverName("postgres"))
+}
+
+func TestOpenComposedHooks(t *testing.T) {
+	hooks := Compose(&HooksMock{}, &HooksMock{})
+
+	first, err := Open("test", "db", hooks)
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := Open("test", "db", hooks)
+	require.NoError(t, err)
+	defer second.Close()
+
+	assert.False(t, first.Driver() == second.Driver())
+}
+
+func TestNewDriverEMissingDriver(t *testing.T) {
+	d, err := NewDriverE("tset", nil)
+	assert.Nil(t, d)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown driver "tset"`)
+	assert.Contains(t, err.Error(), "test")
+
+	db, err := Open("tset", "db", &HooksMock{})
+	assert.Nil(t, db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown driver "tset"`)
+}
+
+func TestNewDriverE(t *testing.T) {
+	d, err := NewDriverE("test", nil)
+	require.NoError(t, err)
+
+	c, err := d.Open("db")
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+}
+
+func TestNewDriverELazy(t *testing.T) {
+	d, err := NewDriverE("test-late", nil, WithLazyDriver())
+	require.NoError(t, err)
+	_, err = d.Open("db")
+	assert.Error(t, err)
+
+	sql.Register("test-late", &fakeDriver{})
+	c, err := d.Open("db")
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+}
+
+func TestExplainerRunsOnRowsCloseWithoutHooks(t *testing.T) {
+	q := queries[*driverFlag]
+
+	calls := 0
+	count := func(ctx *Context) error {
+		calls++
+		return nil
+	}
+
+	after := func(ctx *Context) error {
+		return ctx.Error
+	}
+
+	hooks := &explainMock{HooksMock: NewHooksMock(count, after), query: q.selectall}
+	db := openDBWithHooks(t, hooks)
+
+	_, err := db.Exec(q.insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Empty(t, hooks.plans)
+
+	rows, err := db.Query(q.selectall)
+	require.NoError(t, err)
+	assert.Empty(t, hooks.plans, "explain should wait for rows to be closed")
+
+	before := calls
+	for rows.Next() {
+	}
+	require.NoError(t, rows.Close())
+	assert.Equal(t, before, calls, "explain should not trigger hooks")
+	assert.Equal(t, []string{"foo bar"}, hooks.plans)
+}
+
+type ctxKey struct{}
+
+func TestTxHooksReceiveBeginTxContext(t *testing.T) {
+	var values []interface{}
+	record := func(ctx *Context) error {
+		values = append(values, ctx.Ctx.Value(ctxKey{}))
+		return ctx.Error
+	}
+
+	db := openDBWithHooks(t, &HooksMock{
+		afterBegin:  record,
+		afterCommit: record,
+	})
+
+	stdCtx := context.WithValue(context.Background(), ctxKey{}, "value")
+	tx, err := db.BeginTx(stdCtx, nil)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	assert.Equal(t, []interface{}{"value", "value"}, values)
+}
+
+var errBeginFailed = errors.New("begin failed")
+
+// failingBeginDriver opens connections whose transactions can't begin
+type failingBeginDriver struct{}
+
+func (failingBeginDriver) Open(string) (driver.Conn, error) {
+	return failingBeginConn{}, nil
+}
+
+type failingBeginConn struct {
+	driver.Conn
+}
+
+func (failingBeginConn) Close() error { return nil }
+
+func (failingBeginConn) Begin() (driver.Tx, error) {
+	return nil, errBeginFailed
+}
+
+func (failingBeginConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return nil, errBeginFailed
+}
+
+func init() {
+	sql.Register("sqlhooks-failbegin", failingBeginDriver{})
+}
+
+func TestFailedBeginIsReported(t *testing.T) {
+	var begins []*Context
+	var ends int
+	hooks := &HooksMock{
+		afterBegin: func(ctx *Context) error {
+			begins = append(begins, ctx)
+			return ctx.Error
+		},
+		afterCommit:   func(ctx *Context) error { ends++; return ctx.Error },
+		afterRollback: func(ctx *Context) error { ends++; return ctx.Error },
+	}
+
+	db, err := Open("sqlhooks-failbegin", "", hooks)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Begin()
+	assert.Equal(t, errBeginFailed, err)
+	_, err = db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	assert.Equal(t, errBeginFailed, err)
+
+	require.Len(t, begins, 2)
+	for _, ctx := range begins {
+		assert.Equal(t, errBeginFailed, ctx.Error)
+		assert.NotZero(t, ctx.TxID)
+		assert.NotZero(t, ctx.Duration)
+	}
+	assert.NotEqual(t, begins[0].TxID, begins[1].TxID)
+	assert.False(t, begins[0].TxOptions.ReadOnly)
+	assert.True(t, begins[1].TxOptions.ReadOnly)
+	assert.Equal(t, 0, ends)
+}
+
+func TestWithOperationsSkipsDisabledHooks(t *testing.T) {
+	q := queries[*driverFlag]
+
+	var ran []string
+	hook := func(name string) func(*Context) error {
+		return func(ctx *Context) error {
+			ran = append(ran, name)
+			return ctx.Error
+		}
+	}
+
+	hooks := &HooksMock{
+		afterQuery:     hook("Query"),
+		afterExec:      hook("Exec"),
+		afterStmtQuery: hook("StmtQuery"),
+		afterStmtExec:  hook("StmtExec"),
+		afterPrepare:   hook("Prepare"),
+		afterBegin:     hook("Begin"),
+		afterCommit:    hook("Commit"),
+		afterRollback:  hook("Rollback"),
+	}
+
+	openDBWithHooks(t, nil).Close()
+	db, err := Open(*driverFlag, *dsnFlag, hooks, WithOperations(OpExec|OpBegin|OpCommit))
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query(q.selectall)
+	require.NoError(t, err)
+	rows.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec(q.insert, "foo", "bar")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	tx, err = db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+
+	for _, name := range ran {
+		assert.Contains(t, []string{"Exec", "StmtExec", "Begin", "Commit"}, name)
+	}
+	assert.Contains(t, ran, "Begin")
+	assert.Contains(t, ran, "Commit")
+	assert.Contains(t, ran, "Exec")
+}
+
+func TestWithOperationsDisabledDontAllocate(t *testing.T) {
+	nargs := []driver.NamedValue{{Ordinal: 1, Value: int64(1)}}
+	raw, err := drivertest.Driver{}.Open("context;TestWithOperationsDisabledDontAllocate")
+	require.NoError(t, err)
+	disabled, err := NewDriver("", &HooksMock{}, WithOperations(OpAll&^(OpQuery|OpExec))).wrap(context.Background(), "", raw)
+	require.NoError(t, err)
+	hooked, err := NewDriver("", &HooksMock{}).wrap(context.Background(), "", raw)
+	require.NoError(t, err)
+
+	run := func(ctx context.Context, c driver.Conn) func() {
+		return func() {
+			rows, err := c.(driver.QueryerContext).QueryContext(ctx, "SELECT 1", nargs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows.Close()
+			if _, err := c.(driver.ExecerContext).ExecContext(ctx, "INSERT INTO t VALUES (?)", nargs); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	want := testing.AllocsPerRun(100, run(context.Background(), raw))
+	assert.Equal(t, want, testing.AllocsPerRun(100, run(context.Background(), disabled)), "the disabled operations cost what the driver does")
+
+	unhooked := Unhooked(context.Background())
+	assert.Equal(t, want, testing.AllocsPerRun(100, run(unhooked, hooked)), "the unhooked operations cost what the driver does")
+}
+
+func TestStatementIDsAreUnique(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		ids = make(map[uint64]bool)
+	)
+	before := func(ctx *Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.False(t, ids[ctx.StatementID], "StatementID %d reused", ctx.StatementID)
+		ids[ctx.StatementID] = true
+		ctx.Set("id", ctx.StatementID)
+		return nil
+	}
+	after := func(ctx *Context) error {
+		assert.Equal(t, ctx.Get("id"), ctx.StatementID)
+		return ctx.Error
+	}
+
+	db := openDBWithHooks(t, &HooksMock{
+		beforeExec:     before,
+		afterExec:      after,
+		afterPrepare:   func(ctx *Context) error { return ctx.Error },
+		beforeStmtExec: before,
+		afterStmtExec:  after,
+	})
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, err := db.Exec(queries[*driverFlag].insert, "foo", "bar")
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, len(ids) >= 8*20)
+}
+
+func TestSeqOrdersConnectionOperations(t *testing.T) {
+	var seqs []uint64
+	record := func(ctx *Context) error {
+		seqs = append(seqs, ctx.Seq)
+		return ctx.Error
+	}
+
+	db := openDBWithHooks(t, &HooksMock{
+		beforeExec:     record,
+		afterExec:      record,
+		afterPrepare:   func(ctx *Context) error { return ctx.Error },
+		beforeStmtExec: record,
+		afterStmtExec:  record,
+	})
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for i := 0; i < 5; i++ {
+		_, err := db.Exec(queries[*driverFlag].insert, "foo", "bar")
+		require.NoError(t, err)
+	}
+
+	require.NotEmpty(t, seqs)
+	for i := 1; i < len(seqs); i++ {
+		assert.True(t, seqs[i] >= seqs[i-1], "Seq %d after %d", seqs[i], seqs[i-1])
+	}
+	assert.True(t, seqs[len(seqs)-1] > seqs[0])
+}
+
+func TestSetHooksSwapsHooksOfOpenConnections(t *testing.T) {
+	var log []string
+	openDBWithHooks(t, nil).Close()
+
+	d := NewDriver(*driverFlag, recordingHooks("first", &log))
+	db, err := sql.Open(RegisterUnique(*driverFlag, d), *dsnFlag)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+
+	second := recordingHooks("second", &log)
+	d.SetHooks(second)
+	assert.True(t, d.Hooks() == HookType(second))
+
+	_, err = db.Exec(queries[*driverFlag].insert, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first.Before", "first.After", "second.Before", "second.After"}, log)
+}
+
+func TestConcurrentSetHooksKeepsPairs(t *testing.T) {
+	var mismatches uint64
+	newHooks := func(name string) *HooksMock {
+		before := func(ctx *Context) error {
+			ctx.Set("hooks", name)
+			return nil
+		}
+		after := func(ctx *Context) error {
+			if ctx.Get("hooks") != name {
+				atomic.AddUint64(&mismatches, 1)
+			}
+			return ctx.Error
+		}
+		return &HooksMock{
+			beforeExec: before, afterExec: after,
+			beforePrepare: before, afterPrepare: after,
+			beforeStmtExec: before, afterStmtExec: after,
+		}
+	}
+
+	openDBWithHooks(t, nil).Close()
+	d := NewDriver(*driverFlag, newHooks("initial"))
+	db, err := sql.Open(RegisterUnique(*driverFlag, d), *dsnFlag)
+	require.NoError(t, err)
+	defer db.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			d.SetHooks(newHooks(fmt.Sprint(i)))
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				_, err := db.Exec(queries[*driverFlag].insert, "foo", "bar")
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+
+	assert.Zero(t, atomic.LoadUint64(&mismatches))
+}
+
+// stressHooks counts the Before and After hooks of every operation, the
+// connection data they update is only safe if hooks running on the same
+// connection are serialized
+type stressHooks struct {
+	before, after, rowsClosed, unpaired uint64
+}
+
+func (h *stressHooks) enter(ctx *Context) error {
+	atomic.AddUint64(&h.before, 1)
+	n, _ := ctx.Conn().Get("ops").(int)
+	ctx.Conn().Set("ops", n+1)
+	ctx.Set("entered", true)
+	return nil
+}
+
+func (h *stressHooks) exit(ctx *Context) error {
+	atomic.AddUint64(&h.after, 1)
+	if ctx.Get("entered") == nil {
+		atomic.AddUint64(&h.unpaired, 1)
+	}
+	return ctx.Error
+}
+
+func (h *stressHooks) BeforeBegin(ctx *Context) error     { return h.enter(ctx) }
+func (h *stressHooks) AfterBegin(ctx *Context) error      { return h.exit(ctx) }
+func (h *stressHooks) BeforeCommit(ctx *Context) error    { return h.enter(ctx) }
+func (h *stressHooks) AfterCommit(ctx *Context) error     { return h.exit(ctx) }
+func (h *stressHooks) BeforeRollback(ctx *Context) error  { return h.enter(ctx) }
+func (h *stressHooks) AfterRollback(ctx *Context) error   { return h.exit(ctx) }
+func (h *stressHooks) BeforePrepare(ctx *Context) error   { return h.enter(ctx) }
+func (h *stressHooks) AfterPrepare(ctx *Context) error    { return h.exit(ctx) }
+func (h *stressHooks) BeforeStmtQuery(ctx *Context) error { return h.enter(ctx) }
+func (h *stressHooks) AfterStmtQuery(ctx *Context) error  { return h.exit(ctx) }
+func (h *stressHooks) BeforeStmtExec(ctx *Context) error  { return h.enter(ctx) }
+func (h *stressHooks) AfterStmtExec(ctx *Context) error   { return h.exit(ctx) }
+func (h *stressHooks) BeforeQuery(ctx *Context) error     { return h.enter(ctx) }
+func (h *stressHooks) AfterQuery(ctx *Context) error      { return h.exit(ctx) }
+func (h *stressHooks) BeforeExec(ctx *Context) error      { return h.enter(ctx) }
+func (h *stressHooks) AfterExec(ctx *Context) error       { return h.exit(ctx) }
+
+func (h *stressHooks) AfterRowsClose(ctx *Context) error {
+	atomic.AddUint64(&h.rowsClosed, 1)
+	return ctx.Error
+}
+
+// TestConcurrentStress is meant to be run with -race, it mixes every
+// operation from a few hundred goroutines sharing a DB and a prepared statement,
+// and opens connections and connectors of a fresh Driver concurrently
+func TestConcurrentStress(t *testing.T) {
+	q := queries[*driverFlag]
+	hooks := &stressHooks{}
+	openDBWithHooks(t, nil).Close()
+	db, err := Open(*driverFlag, *dsnFlag, hooks, WithStmtCache(4, 2), WithSelfTiming(), WithArgsSize())
+	require.NoError(t, err)
+	defer db.Close()
+	if *driverFlag == "sqlite3" {
+		// sqlite locks the database file on concurrent writes
+		db.SetMaxOpenConns(1)
+	}
+
+	for i := 0; i < 10; i++ {
+		_, err := db.Exec(q.insert, "seed", "bar")
+		require.NoError(t, err)
+	}
+
+	shared, err := db.Prepare(q.insert)
+	require.NoError(t, err)
+	defer shared.Close()
+
+	duration := 2 * time.Second
+	if testing.Short() {
+		duration = 200 * time.Millisecond
+	}
+	deadline := time.Now().Add(duration)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 256; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; time.Now().Before(deadline); i++ {
+				if err := stressOp(db, shared, q, i); err != nil {
+					t.Errorf("op %d: %v", i%6, err)
+					return
+				}
+			}
+		}(g)
+	}
+
+	// Registry lookups and stats are read while the operations run
+	drivers := make(chan driver.Driver, 8)
+	for i := 0; i < cap(drivers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			other, err := Open(*driverFlag, *dsnFlag, hooks)
+			if assert.NoError(t, err) {
+				driverStats(t, other)
+				drivers <- other.Driver()
+				other.Close()
+			}
+			Registered()
+		}()
+	}
+
+	// A fresh Driver looks up the underlying driver on its first Open or
+	// OpenConnector, whichever goroutine gets there first
+	fresh := NewDriver(*driverFlag, hooks)
+	start := make(chan struct{})
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if i%2 == 0 {
+				c, err := fresh.Open(*dsnFlag)
+				if assert.NoError(t, err) {
+					c.Close()
+				}
+				return
+			}
+			c, err := fresh.OpenConnector(*dsnFlag)
+			if assert.NoError(t, err) {
+				if closer, ok := c.(io.Closer); ok {
+					closer.Close()
+				}
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(drivers)
+
+	for d := range drivers {
+		assert.True(t, d == db.Driver(), "Open registered the same hooks twice")
+	}
+	assert.NotZero(t, atomic.LoadUint64(&hooks.before))
+	assert.Equal(t, atomic.LoadUint64(&hooks.before), atomic.LoadUint64(&hooks.after))
+	assert.NotZero(t, atomic.LoadUint64(&hooks.rowsClosed))
+	assert.Zero(t, atomic.LoadUint64(&hooks.unpaired))
+}
+
+func stressOp(db *sql.DB, shared *sql.Stmt, q ops, i int) error {
+	switch i % 6 {
+	case 0:
+		_, err := db.Exec(q.insert, "foo", "bar")
+		return err
+	case 1:
+		rows, err := db.Query(q.selectwhere, "seed", "bar")
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+		}
+		return rows.Close()
+	case 2:
+		_, err := shared.Exec("foo", "bar")
+		return err
+	case 3:
+		stmt, err := db.Prepare(q.selectwhere)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		var f1, f2 string
+		return stmt.QueryRow("seed", "bar").Scan(&f1, &f2)
+	default:
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(q.insert, "foo", "bar"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if i%2 == 0 {
+			return tx.Commit()
+		}
+		return tx.Rollback()
+	}
+}
+
+func TestConnStatementCountsStatementsPerConnection(t *testing.T) {
+	q := queries[*driverFlag]
+
+	var ordinals []uint64
+	var first []bool
+	record := func(ctx *Context) error {
+		if ctx.Error != driver.ErrSkip {
+			ordinals = append(ordinals, ctx.ConnStatement)
+			first = append(first, ctx.FirstOnConn())
+		}
+		return ctx.Error
+	}
+	db := openDBWithHooks(t, &HooksMock{
+		afterExec: record, afterQuery: record,
+		afterStmtExec: record, afterStmtQuery: record,
+	})
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err := db.Exec(q.insert, "foo", "bar")
+	require.NoError(t, err)
+	rows, err := db.Query(q.selectall)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	stmt, err := db.Prepare(q.selectwhere)
+	require.NoError(t, err)
+	var f1, f2 string
+	require.NoError(t, stmt.QueryRow("foo", "bar").Scan(&f1, &f2))
+	require.NoError(t, stmt.Close())
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec(q.insert, "foo", "bar")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, []uint64{1, 2, 3, 4}, ordinals)
+	assert.Equal(t, []bool{true, false, false, false}, first)
+
+	// Without idle connections every statement runs on a new one
+	ordinals = nil
+	db.SetMaxIdleConns(0)
+	for i := 0; i < 2; i++ {
+		_, err := db.Exec(q.insert, "foo", "bar")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []uint64{1, 1}, ordinals)
+}
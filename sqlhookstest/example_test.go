@@ -0,0 +1,68 @@
+package sqlhookstest_test
+
+import (
+	"fmt"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/gchaincl/sqlhooks/sqlhookstest"
+)
+
+// printT prints the failures of the assertions, a test gives its *testing.T
+type printT struct{}
+
+func (printT) Helper() {}
+
+func (printT) Errorf(format string, args ...interface{}) {
+	fmt.Printf(format+"\n", args...)
+}
+
+func ExampleRecorder() {
+	rec := sqlhookstest.NewRecorder()
+	db, err := sqlhooks.Open(drivertest.Name, "context;ExampleRecorder", rec)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Exec("INSERT INTO users VALUES (1, 'ann')")
+	for _, ev := range rec.Statements() {
+		fmt.Println(ev.Op, ev.Table, ev.Fingerprint)
+	}
+	// Output:
+	// insert users INSERT INTO users VALUES (?, ?)
+}
+
+func ExampleAssertMaxQueries() {
+	rec := sqlhookstest.NewRecorder()
+	db, err := sqlhooks.Open(drivertest.Name, "context;ExampleAssertMaxQueries", rec)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	// An N+1 pattern: a query per user
+	for id := 1; id <= 3; id++ {
+		db.Exec(fmt.Sprintf("UPDATE users SET seen = now() WHERE id = %d", id))
+	}
+	sqlhookstest.AssertMaxQueries(printT{}, rec, 1)
+	// Output:
+	// sqlhookstest: 3 statements run, want at most 1:
+	//      3  UPDATE users SET seen = now() WHERE id = ?
+}
+
+func ExampleAssertAllQueriesMatched() {
+	rec := sqlhookstest.NewRecorder()
+	db, err := sqlhooks.Open(drivertest.Name, "context;ExampleAssertAllQueriesMatched", rec)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Exec("DELETE FROM sessions WHERE id = 7")
+	db.Exec("DELETE FROM users")
+	sqlhookstest.AssertAllQueriesMatched(printT{}, rec, []string{"DELETE FROM sessions WHERE id = 1"})
+	// Output:
+	// sqlhookstest: 1 fingerprints not in the allowlist:
+	// +    1  DELETE FROM users
+}
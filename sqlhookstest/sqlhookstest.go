@@ -0,0 +1,308 @@
+// Package sqlhookstest provides a Recorder of the statements run through
+// sqlhooks, and assertions on them for end-to-end tests, failing when a
+// change runs queries nobody reviewed, out of a transaction, or too many:
+//
+//	rec := sqlhookstest.NewRecorder()
+//	db, err := sqlhooks.Open("postgres", dsn, rec)
+//	...
+//	listUsers(db)
+//	sqlhookstest.AssertMaxQueries(t, rec, 2)
+//	sqlhookstest.AssertAllQueriesMatchedFile(t, rec, "testdata/queries.allowlist")
+//
+// Statements are told apart by their fingerprint, as sqlhooks.Fingerprint
+// returns it. The statements sqlhooks runs itself, whose Context is
+// Internal, are recorded but left out of the assertions.
+package sqlhookstest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+// UpdateEnv is the environment variable which, when set, has
+// AssertAllQueriesMatchedFile write the allowlist files instead of checking them
+const UpdateEnv = "SQLHOOKSTEST_UPDATE"
+
+// T is the part of testing.TB the assertions use
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Recorder is hooks recording the statements run, once done, and the
+// transactions, as sqlhooks.MarshalEvent records them. It's safe for
+// concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	events []sqlhooks.EventRecordV1
+}
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Events returns the events recorded, in the order they were
+func (r *Recorder) Events() []sqlhooks.EventRecordV1 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]sqlhooks.EventRecordV1(nil), r.events...)
+}
+
+// Statements returns the events of the statements recorded, without the
+// transactions and the Internal statements
+func (r *Recorder) Statements() []sqlhooks.EventRecordV1 {
+	var statements []sqlhooks.EventRecordV1
+	for _, ev := range r.Events() {
+		if ev.Op != "tx" && !ev.Internal {
+			statements = append(statements, ev)
+		}
+	}
+	return statements
+}
+
+// Reset forgets the events recorded, as to assert on a part of a test only
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+}
+
+func (r *Recorder) record(ev interface{}) {
+	record, err := sqlhooks.MarshalEvent(ev)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, record)
+}
+
+func (r *Recorder) before(ctx *sqlhooks.Context) error {
+	return nil
+}
+
+func (r *Recorder) after(ctx *sqlhooks.Context) error {
+	r.record(ctx)
+	return ctx.Error
+}
+
+func (r *Recorder) AfterTx(summary sqlhooks.TxSummary) {
+	r.record(summary)
+}
+
+func (r *Recorder) BeforeQuery(ctx *sqlhooks.Context) error {
+	return r.before(ctx)
+}
+
+func (r *Recorder) AfterQuery(ctx *sqlhooks.Context) error {
+	return r.after(ctx)
+}
+
+func (r *Recorder) BeforeExec(ctx *sqlhooks.Context) error {
+	return r.before(ctx)
+}
+
+func (r *Recorder) AfterExec(ctx *sqlhooks.Context) error {
+	return r.after(ctx)
+}
+
+func (r *Recorder) BeforePrepare(ctx *sqlhooks.Context) error {
+	return r.before(ctx)
+}
+
+func (r *Recorder) AfterPrepare(ctx *sqlhooks.Context) error {
+	return ctx.Error
+}
+
+func (r *Recorder) BeforeStmtQuery(ctx *sqlhooks.Context) error {
+	return r.before(ctx)
+}
+
+func (r *Recorder) AfterStmtQuery(ctx *sqlhooks.Context) error {
+	return r.after(ctx)
+}
+
+func (r *Recorder) BeforeStmtExec(ctx *sqlhooks.Context) error {
+	return r.before(ctx)
+}
+
+func (r *Recorder) AfterStmtExec(ctx *sqlhooks.Context) error {
+	return r.after(ctx)
+}
+
+// count is the number of statements of a fingerprint
+type count struct {
+	fingerprint string
+	n           int
+}
+
+// countFingerprints returns the statements counted by fingerprint for
+// which keep returns true, the most run first
+func countFingerprints(statements []sqlhooks.EventRecordV1, keep func(sqlhooks.EventRecordV1) bool) []count {
+	index := make(map[string]int)
+	var counts []count
+	for _, ev := range statements {
+		if !keep(ev) {
+			continue
+		}
+		i, ok := index[ev.Fingerprint]
+		if !ok {
+			i = len(counts)
+			index[ev.Fingerprint] = i
+			counts = append(counts, count{fingerprint: ev.Fingerprint})
+		}
+		counts[i].n++
+	}
+	sort.SliceStable(counts, func(i, j int) bool { return counts[i].n > counts[j].n })
+	return counts
+}
+
+// format lists counts, a line per fingerprint with its count, prefixed with prefix
+func format(counts []count, prefix string) string {
+	var b bytes.Buffer
+	for _, c := range counts {
+		fmt.Fprintf(&b, "\n%s%4d  %s", prefix, c.n, c.fingerprint)
+	}
+	return b.String()
+}
+
+// AssertAllQueriesMatched fails t when statements whose fingerprint isn't
+// in allowlist were recorded, listing them. The allowlist entries can be
+// queries, they're compared by fingerprint.
+func AssertAllQueriesMatched(t T, rec *Recorder, allowlist []string) bool {
+	t.Helper()
+	allowed := make(map[string]bool, len(allowlist))
+	for _, query := range allowlist {
+		allowed[sqlhooks.Fingerprint(query)] = true
+	}
+	unexpected := countFingerprints(rec.Statements(), func(ev sqlhooks.EventRecordV1) bool {
+		return !allowed[ev.Fingerprint]
+	})
+	if len(unexpected) > 0 {
+		t.Errorf("sqlhookstest: %d fingerprints not in the allowlist:%s", len(unexpected), format(unexpected, "+ "))
+		return false
+	}
+	return true
+}
+
+// AssertAllQueriesMatchedFile is AssertAllQueriesMatched with the allowlist
+// read from the file at path, a fingerprint per line, empty lines and the
+// ones starting with # being skipped. With the UpdateEnv environment
+// variable set, the file is written with the fingerprints recorded instead,
+// sorted, and the assertion succeeds.
+func AssertAllQueriesMatchedFile(t T, rec *Recorder, path string) bool {
+	t.Helper()
+	if os.Getenv(UpdateEnv) != "" {
+		if err := WriteAllowlist(path, rec); err != nil {
+			t.Errorf("sqlhookstest: %v", err)
+			return false
+		}
+		return true
+	}
+
+	allowlist, err := ReadAllowlist(path)
+	if err != nil {
+		t.Errorf("sqlhookstest: %v, set %s=1 to create it", err, UpdateEnv)
+		return false
+	}
+	if !AssertAllQueriesMatched(t, rec, allowlist) {
+		t.Errorf("sqlhookstest: add them to %s, or set %s=1 to rewrite it", path, UpdateEnv)
+		return false
+	}
+	return true
+}
+
+// ReadAllowlist reads the allowlist file at path, see AssertAllQueriesMatchedFile
+func ReadAllowlist(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var allowlist []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			allowlist = append(allowlist, line)
+		}
+	}
+	return allowlist, scanner.Err()
+}
+
+// WriteAllowlist writes the fingerprints of the statements rec recorded to
+// the allowlist file at path, sorted
+func WriteAllowlist(path string, rec *Recorder) error {
+	seen := make(map[string]bool)
+	var fingerprints []string
+	for _, ev := range rec.Statements() {
+		if !seen[ev.Fingerprint] {
+			seen[ev.Fingerprint] = true
+			fingerprints = append(fingerprints, ev.Fingerprint)
+		}
+	}
+	sort.Strings(fingerprints)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated with %s=1, a fingerprint per line\n", UpdateEnv)
+	for _, fingerprint := range fingerprints {
+		b.WriteString(fingerprint)
+		b.WriteByte('\n')
+	}
+	return ioutil.WriteFile(path, b.Bytes(), 0644)
+}
+
+// AssertNoQueriesOutsideTx fails t when statements whose fingerprint
+// matches one of the regular expressions of patterns were recorded out of
+// a transaction, listing them
+func AssertNoQueriesOutsideTx(t T, rec *Recorder, patterns []string) bool {
+	t.Helper()
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			t.Errorf("sqlhookstest: %v", err)
+			return false
+		}
+		res[i] = re
+	}
+
+	outside := countFingerprints(rec.Statements(), func(ev sqlhooks.EventRecordV1) bool {
+		if ev.TxID != 0 {
+			return false
+		}
+		for _, re := range res {
+			if re.MatchString(ev.Fingerprint) {
+				return true
+			}
+		}
+		return false
+	})
+	if len(outside) > 0 {
+		t.Errorf("sqlhookstest: %d fingerprints run out of a transaction:%s", len(outside), format(outside, "  "))
+		return false
+	}
+	return true
+}
+
+// AssertMaxQueries fails t when more than n statements were recorded,
+// listing them by fingerprint, the most run first, as an N+1 guard
+func AssertMaxQueries(t T, rec *Recorder, n int) bool {
+	t.Helper()
+	statements := rec.Statements()
+	if len(statements) <= n {
+		return true
+	}
+	counts := countFingerprints(statements, func(sqlhooks.EventRecordV1) bool { return true })
+	t.Errorf("sqlhookstest: %d statements run, want at most %d:%s", len(statements), n, format(counts, "  "))
+	return false
+}
@@ -0,0 +1,166 @@
+package sqlhookstest
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeT records the failures of the assertions
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func open(t *testing.T, rec *Recorder) *sql.DB {
+	db, err := sqlhooks.Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), rec)
+	require.NoError(t, err)
+	return db
+}
+
+// listUsers runs an N+1 query pattern
+func listUsers(t *testing.T, db *sql.DB) {
+	rows, err := db.Query("SELECT id FROM users")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	for id := 1; id <= 3; id++ {
+		rows, err := db.Query(fmt.Sprintf("SELECT name FROM profiles WHERE user_id = %d", id))
+		require.NoError(t, err)
+		require.NoError(t, rows.Close())
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder()
+	db := open(t, rec)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("UPDATE users SET name = 'x'")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	events := rec.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "update", events[0].Op)
+	assert.Equal(t, "tx", events[1].Op)
+	assert.Len(t, rec.Statements(), 1)
+
+	rec.Reset()
+	assert.Empty(t, rec.Events())
+}
+
+func TestAssertMaxQueries(t *testing.T) {
+	rec := NewRecorder()
+	db := open(t, rec)
+	defer db.Close()
+	listUsers(t, db)
+
+	assert.True(t, AssertMaxQueries(t, rec, 4))
+
+	ft := &fakeT{}
+	assert.False(t, AssertMaxQueries(ft, rec, 2))
+	require.Len(t, ft.errors, 1)
+	assert.Equal(t, "sqlhookstest: 4 statements run, want at most 2:\n"+
+		"     3  SELECT name FROM profiles WHERE user_id = ?\n"+
+		"     1  SELECT id FROM users", ft.errors[0])
+}
+
+func TestAssertAllQueriesMatched(t *testing.T) {
+	rec := NewRecorder()
+	db := open(t, rec)
+	defer db.Close()
+	listUsers(t, db)
+
+	// Allowlist entries are compared by fingerprint
+	assert.True(t, AssertAllQueriesMatched(t, rec, []string{
+		"SELECT id FROM users",
+		"SELECT name FROM profiles WHERE user_id = 42",
+	}))
+
+	ft := &fakeT{}
+	assert.False(t, AssertAllQueriesMatched(ft, rec, []string{"SELECT id FROM users"}))
+	require.Len(t, ft.errors, 1)
+	assert.Equal(t, "sqlhookstest: 1 fingerprints not in the allowlist:\n"+
+		"+    3  SELECT name FROM profiles WHERE user_id = ?", ft.errors[0])
+}
+
+func TestAssertAllQueriesMatchedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlhookstest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queries.allowlist")
+
+	rec := NewRecorder()
+	db := open(t, rec)
+	defer db.Close()
+	listUsers(t, db)
+
+	ft := &fakeT{}
+	assert.False(t, AssertAllQueriesMatchedFile(ft, rec, path))
+	require.Len(t, ft.errors, 1)
+	assert.Contains(t, ft.errors[0], UpdateEnv+"=1 to create it")
+
+	os.Setenv(UpdateEnv, "1")
+	updated := AssertAllQueriesMatchedFile(t, rec, path)
+	os.Unsetenv(UpdateEnv)
+	assert.True(t, updated)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "# Generated with "+UpdateEnv+"=1, a fingerprint per line\n"+
+		"SELECT id FROM users\n"+
+		"SELECT name FROM profiles WHERE user_id = ?\n", string(data))
+	assert.True(t, AssertAllQueriesMatchedFile(t, rec, path))
+
+	_, err = db.Exec("DELETE FROM users")
+	require.NoError(t, err)
+	ft = &fakeT{}
+	assert.False(t, AssertAllQueriesMatchedFile(ft, rec, path))
+	require.Len(t, ft.errors, 2)
+	assert.True(t, strings.HasSuffix(ft.errors[0], "+    1  DELETE FROM users"), ft.errors[0])
+	assert.Contains(t, ft.errors[1], "add them to "+path)
+}
+
+func TestAssertNoQueriesOutsideTx(t *testing.T) {
+	rec := NewRecorder()
+	db := open(t, rec)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("UPDATE accounts SET balance = 1")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	_, err = db.Exec("INSERT INTO logs VALUES ('x')")
+	require.NoError(t, err)
+
+	patterns := []string{"^(UPDATE|DELETE) accounts"}
+	assert.True(t, AssertNoQueriesOutsideTx(t, rec, patterns))
+
+	_, err = db.Exec("UPDATE accounts SET balance = 2")
+	require.NoError(t, err)
+	ft := &fakeT{}
+	assert.False(t, AssertNoQueriesOutsideTx(ft, rec, patterns))
+	require.Len(t, ft.errors, 1)
+	assert.Equal(t, "sqlhookstest: 1 fingerprints run out of a transaction:\n"+
+		"     1  UPDATE accounts SET balance = ?", ft.errors[0])
+
+	ft = &fakeT{}
+	assert.False(t, AssertNoQueriesOutsideTx(ft, rec, []string{"("}))
+	assert.Len(t, ft.errors, 1)
+}
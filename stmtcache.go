@@ -0,0 +1,195 @@
+package sqlhooks
+
+import (
+	"container/list"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+const (
+	// stmtCacheBanTime is how long a query that failed to prepare is executed directly
+	stmtCacheBanTime = time.Minute
+
+	// stmtCacheTracked bounds the number of queries whose executions are counted
+	stmtCacheTracked = 1024
+)
+
+// StmtCacheStats holds the counters of a connection's statement cache
+type StmtCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+type cachedStmt struct {
+	driver.Stmt
+	diag    *diagnostics
+	query   string
+	open    int
+	evicted bool
+}
+
+// release closes the statement once it's been evicted and none of its rows are open
+func (s *cachedStmt) release() {
+	if s.evicted && s.open == 0 {
+		if err := s.Stmt.Close(); err != nil {
+			s.diag.report(EventStmtCacheClose, err)
+		}
+	}
+}
+
+type cachedRows struct {
+	driver.Rows
+	stmt *cachedStmt
+}
+
+func (r cachedRows) Close() error {
+	err := r.Rows.Close()
+	r.stmt.open--
+	r.stmt.release()
+	return err
+}
+
+// stmtCache is bound to a single connection, database/sql serializes the
+// access to it, so it doesn't need to be synchronized
+type stmtCache struct {
+	conn      driver.Conn
+	diag      *diagnostics
+	size      int
+	threshold int
+
+	seen   map[string]int
+	banned map[string]time.Time
+	lru    *list.List
+	stmts  map[string]*list.Element
+	stats  StmtCacheStats
+}
+
+func newStmtCache(c driver.Conn, diag *diagnostics, size, threshold int) *stmtCache {
+	return &stmtCache{
+		conn:      c,
+		diag:      diag,
+		size:      size,
+		threshold: threshold,
+		seen:      make(map[string]int),
+		banned:    make(map[string]time.Time),
+		lru:       list.New(),
+		stmts:     make(map[string]*list.Element),
+	}
+}
+
+func (c *stmtCache) ban(query string) {
+	now := time.Now()
+	if len(c.banned) >= stmtCacheTracked {
+		for q, until := range c.banned {
+			if now.After(until) {
+				delete(c.banned, q)
+			}
+		}
+	}
+	c.banned[query] = now.Add(stmtCacheBanTime)
+}
+
+func (c *stmtCache) isBanned(query string) bool {
+	until, ok := c.banned[query]
+	if ok && time.Now().After(until) {
+		delete(c.banned, query)
+		return false
+	}
+	return ok
+}
+
+// get returns the cached statement for query, preparing it if it has been
+// executed often enough. It returns nil if query should be executed directly.
+func (c *stmtCache) get(query string, nargs int) *cachedStmt {
+	if e, ok := c.stmts[query]; ok {
+		c.lru.MoveToFront(e)
+		c.stats.Hits++
+		return e.Value.(*cachedStmt)
+	}
+	c.stats.Misses++
+
+	if c.isBanned(query) {
+		return nil
+	}
+
+	if _, ok := c.seen[query]; !ok && len(c.seen) >= stmtCacheTracked {
+		c.seen = make(map[string]int)
+	}
+	c.seen[query]++
+	if c.seen[query] <= c.threshold {
+		return nil
+	}
+
+	_stmt, err := c.conn.Prepare(query)
+	if err != nil {
+		c.diag.report(EventStmtCachePrepare, err)
+		c.ban(query)
+		return nil
+	}
+	if n := _stmt.NumInput(); n >= 0 && n != nargs {
+		_stmt.Close()
+		c.diag.report(EventStmtCachePrepare, fmt.Errorf("sqlhooks: statement expects %d arguments, got %d", n, nargs))
+		c.ban(query)
+		return nil
+	}
+	delete(c.seen, query)
+
+	if c.lru.Len() >= c.size {
+		c.evict(c.lru.Back())
+	}
+
+	s := &cachedStmt{Stmt: _stmt, diag: c.diag, query: query}
+	c.stmts[query] = c.lru.PushFront(s)
+	return s
+}
+
+func (c *stmtCache) evict(e *list.Element) {
+	s := c.lru.Remove(e).(*cachedStmt)
+	delete(c.stmts, s.query)
+	c.stats.Evictions++
+
+	s.evicted = true
+	s.release()
+}
+
+// query runs s with stdCtx when it implements driver.StmtQueryContext, as
+// database/sql does, the legacy Query once checking stdCtx isn't done otherwise
+func (c *stmtCache) query(stdCtx context.Context, s *cachedStmt, args []driver.Value) (driver.Rows, error) {
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(stdCtx, valuesToNamed(args))
+	} else if err = stdCtx.Err(); err == nil {
+		rows, err = s.Query(args)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.open++
+	return forwardRows(cachedRows{rows, s}, rows), nil
+}
+
+// exec is query for Exec
+func (c *stmtCache) exec(stdCtx context.Context, s *cachedStmt, args []driver.Value) (driver.Result, error) {
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(stdCtx, valuesToNamed(args))
+	}
+	if err := stdCtx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Exec(args)
+}
+
+// close closes every cached statement
+func (c *stmtCache) close() {
+	for c.lru.Len() > 0 {
+		s := c.lru.Remove(c.lru.Front()).(*cachedStmt)
+		delete(c.stmts, s.query)
+		s.evicted = true
+		s.release()
+	}
+}
@@ -0,0 +1,155 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openDBWithStmtCache(t *testing.T, hooks interface{}, size, threshold int) *sql.DB {
+	// wipes and creates the table
+	openDBWithHooks(t, nil).Close()
+
+	db, err := Open(*driverFlag, *dsnFlag, hooks, WithStmtCache(size, threshold))
+	require.NoError(t, err)
+
+	// a single connection, so every statement hits the same cache
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestStmtCacheHitsMissesAndEvictions(t *testing.T) {
+	q := queries[*driverFlag]
+
+	var stats StmtCacheStats
+	after := func(ctx *Context) error {
+		stats = ctx.StmtCache
+		return ctx.Error
+	}
+
+	db := openDBWithStmtCache(t, &HooksMock{afterExec: after, afterQuery: after}, 1, 2)
+	if *driverFlag == "test" {
+		setStrictFakeConnClose(t)
+		defer setStrictFakeConnClose(nil)
+	}
+
+	for i := 0; i < 4; i++ {
+		_, err := db.Exec(q.insert, "foo", "bar")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, StmtCacheStats{Hits: 1, Misses: 3}, stats)
+
+	for i := 0; i < 4; i++ {
+		rows, err := db.Query(q.selectall)
+		require.NoError(t, err)
+
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		require.NoError(t, rows.Err())
+		assert.Equal(t, 4, n)
+	}
+	assert.Equal(t, StmtCacheStats{Hits: 2, Misses: 6, Evictions: 1}, stats)
+
+	require.NoError(t, db.Close())
+}
+
+// execHooks only implements Execer
+type execHooks struct {
+	after func(*Context) error
+}
+
+func (h execHooks) BeforeExec(ctx *Context) error {
+	return nil
+}
+
+func (h execHooks) AfterExec(ctx *Context) error {
+	return h.after(ctx)
+}
+
+func TestStmtCacheFallsBackWhenPrepareFails(t *testing.T) {
+	calls := 0
+	var stats StmtCacheStats
+	after := func(ctx *Context) error {
+		calls++
+		stats = ctx.StmtCache
+		return ctx.Error
+	}
+
+	db := openDBWithStmtCache(t, &execHooks{after}, 10, 1)
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := db.Exec("invalid query")
+		assert.Error(t, err)
+	}
+
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, uint64(0), stats.Hits)
+	assert.Equal(t, uint64(5), stats.Misses)
+}
+
+// ctxStmtConn prepares statements taking a context, recording theirs
+type ctxStmtConn struct {
+	anyConn
+	ctxs *[]context.Context
+}
+
+func (c ctxStmtConn) Prepare(query string) (driver.Stmt, error) { return ctxStmt{ctxs: c.ctxs}, nil }
+
+type ctxStmt struct {
+	anyStmt
+	ctxs *[]context.Context
+}
+
+func (s ctxStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	*s.ctxs = append(*s.ctxs, ctx)
+	return driver.RowsAffected(0), ctx.Err()
+}
+
+func (s ctxStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	*s.ctxs = append(*s.ctxs, ctx)
+	return anyRows{}, ctx.Err()
+}
+
+func TestStmtCacheHonorsContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("StmtContext", func(t *testing.T) {
+		var ctxs []context.Context
+		dc, err := NewDriver("", nil, WithStmtCache(10, 0)).wrap(context.Background(), "", ctxStmtConn{ctxs: &ctxs})
+		require.NoError(t, err)
+		c := dc.(*conn)
+
+		_, err = c.ExecContext(canceled, "INSERT INTO t VALUES (1)", nil)
+		assert.Equal(t, context.Canceled, err)
+		_, err = c.QueryContext(canceled, "SELECT 1", nil)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, []context.Context{canceled, canceled}, ctxs)
+		assert.Equal(t, uint64(2), c.diag.stats().Paths[PathStmtCache])
+	})
+
+	t.Run("LegacyStmt", func(t *testing.T) {
+		raw, err := drivertest.Driver{}.Open("legacy;TestStmtCacheHonorsContext")
+		require.NoError(t, err)
+		dc, err := NewDriver("", nil, WithStmtCache(10, 0)).wrap(context.Background(), "", raw)
+		require.NoError(t, err)
+		c := dc.(*conn)
+
+		// The first statements are cached, the canceled ones don't run
+		_, err = c.ExecContext(context.Background(), "INSERT INTO t VALUES (1)", nil)
+		require.NoError(t, err)
+		_, err = c.ExecContext(canceled, "INSERT INTO t VALUES (1)", nil)
+		assert.Equal(t, context.Canceled, err)
+		_, err = c.QueryContext(canceled, "SELECT 1", nil)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, uint64(3), c.diag.stats().Paths[PathStmtCache])
+	})
+}
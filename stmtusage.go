@@ -0,0 +1,49 @@
+package sqlhooks
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// StmtReuseBuckets are the upper bounds of the Stats.StmtReuse buckets, which
+// count closed prepared statements by number of executions. The last bucket
+// counts the statements executed more than 100 times.
+var StmtReuseBuckets = [...]uint64{0, 1, 10, 100}
+
+// stmtUsage tracks how a prepared statement is used.
+// A driver statement belongs to a single connection and database/sql
+// serializes its use, but a *sql.Stmt is prepared on every connection it's
+// used from, and hooks may read the counters from other goroutines,
+// so they are atomic anyway.
+type stmtUsage struct {
+	executions uint64
+	preparedAt time.Time
+}
+
+// prepared counts a new prepared statement
+func (u *stmtUsage) prepared(diag *diagnostics) {
+	atomic.AddUint64(&diag.stmtsPrepared, 1)
+	atomic.AddInt64(&diag.openStmts, 1)
+	u.preparedAt = time.Now()
+}
+
+// executed counts an execution and sets the statement stats on ctx, if any
+func (u *stmtUsage) executed(diag *diagnostics, ctx *Context) {
+	n := atomic.AddUint64(&u.executions, 1)
+	atomic.AddUint64(&diag.stmtExecutions, 1)
+
+	if ctx != nil {
+		ctx.StmtExecutions = n
+		ctx.StmtAge = time.Since(u.preparedAt)
+	}
+}
+
+func (u *stmtUsage) closed(diag *diagnostics) {
+	atomic.AddInt64(&diag.openStmts, -1)
+	n := atomic.LoadUint64(&u.executions)
+	i := 0
+	for i < len(StmtReuseBuckets) && n > StmtReuseBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&diag.stmtReuse[i], 1)
+}
@@ -0,0 +1,80 @@
+package sqlhooks
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStmtUsageIsReportedToHooks(t *testing.T) {
+	var executions []uint64
+	var ages []time.Duration
+	hooks := &HooksMock{
+		afterPrepare: func(ctx *Context) error { return ctx.Error },
+		afterStmtExec: func(ctx *Context) error {
+			executions = append(executions, ctx.StmtExecutions)
+			ages = append(ages, ctx.StmtAge)
+			return ctx.Error
+		},
+	}
+	db := openDBWithHooks(t, hooks)
+	db.SetMaxOpenConns(1)
+
+	s, err := db.Prepare(queries[*driverFlag].insert)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := s.Exec("foo", "bar")
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+
+	assert.Equal(t, []uint64{1, 2, 3}, executions)
+	assert.True(t, ages[0] <= ages[1] && ages[1] <= ages[2])
+
+	stats := driverStats(t, db)
+	require.NoError(t, db.Close())
+	assert.Equal(t, uint64(1), stats.StmtsPrepared)
+	assert.Equal(t, uint64(3), stats.StmtExecutions)
+	assert.Equal(t, [5]uint64{0, 0, 1, 0, 0}, stats.StmtReuse)
+}
+
+func TestStmtUsageWithConcurrentStmt(t *testing.T) {
+	hooks := &HooksMock{
+		afterPrepare:  func(ctx *Context) error { return ctx.Error },
+		afterStmtExec: func(ctx *Context) error { return ctx.Error },
+	}
+	db := openDBWithHooks(t, hooks)
+	db.SetMaxOpenConns(4)
+
+	// database/sql prepares s on every connection it's used from
+	s, err := db.Prepare(queries[*driverFlag].insert)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, err := s.Exec("foo", "bar")
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, s.Close())
+
+	stats := driverStats(t, db)
+	require.NoError(t, db.Close())
+	assert.Equal(t, uint64(200), stats.StmtExecutions)
+	assert.True(t, stats.StmtsPrepared >= 1 && stats.StmtsPrepared <= 4)
+
+	var closed uint64
+	for _, n := range stats.StmtReuse {
+		closed += n
+	}
+	assert.Equal(t, stats.StmtsPrepared, closed)
+}
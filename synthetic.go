@@ -0,0 +1,74 @@
+package sqlhooks
+
+import (
+	"database/sql/driver"
+	"io"
+)
+
+// substitute is what a Before hook asked a statement to return instead of running it
+type substitute struct {
+	result  driver.Result
+	rows    bool
+	columns []string
+	values  [][]driver.Value
+}
+
+// SubstituteResult, called by a Before Exec or StmtExec hook, makes the
+// statement return result without calling the driver, as dry runs, caches or
+// fault injection need. The After hooks still run, with Synthetic set and a
+// zero Duration, but the statement doesn't count for TxSummary, Explainer or
+// Savepointer hooks. A nil result stands for driver.RowsAffected(0).
+// It's ignored by the other hooks.
+func (ctx *Context) SubstituteResult(result driver.Result) {
+	if result == nil {
+		result = driver.RowsAffected(0)
+	}
+	ctx.substitute = &substitute{result: result}
+}
+
+// SubstituteRows, called by a Before Query or StmtQuery hook, is
+// SubstituteResult for queries: they return rows, read as the given columns,
+// without calling the driver. The values must be of the driver.Value types
+// and are returned as they are. It's ignored by the other hooks.
+func (ctx *Context) SubstituteRows(columns []string, rows [][]driver.Value) {
+	ctx.substitute = &substitute{rows: true, columns: columns, values: rows}
+}
+
+// substitutedResult returns the result substituted by SubstituteResult, if
+// any, and sets Synthetic
+func (ctx *Context) substitutedResult() (driver.Result, bool) {
+	if ctx.substitute == nil || ctx.substitute.rows {
+		return nil, false
+	}
+	ctx.Synthetic = true
+	return ctx.substitute.result, true
+}
+
+// substitutedRows returns the rows substituted by SubstituteRows, if any,
+// and sets Synthetic
+func (ctx *Context) substitutedRows() (driver.Rows, bool) {
+	if ctx.substitute == nil || !ctx.substitute.rows {
+		return nil, false
+	}
+	ctx.Synthetic = true
+	return &syntheticRows{columns: ctx.substitute.columns, values: ctx.substitute.values}, true
+}
+
+// syntheticRows serves the rows given to SubstituteRows
+type syntheticRows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func (r *syntheticRows) Columns() []string { return r.columns }
+func (r *syntheticRows) Close() error      { return nil }
+
+func (r *syntheticRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,183 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingDriver opens conn for every connection
+type recordingDriver struct {
+	conn *recordingConn
+}
+
+func (d recordingDriver) Open(string) (driver.Conn, error) { return d.conn, nil }
+
+// synthesizer substitutes the results of every statement, recording what
+// the After hooks see
+type synthesizer struct {
+	synthetic []bool
+	durations []time.Duration
+	txIDs     []uint64
+}
+
+func (h *synthesizer) hooks() *FuncHooks {
+	exec := Funcs{
+		Before: func(ctx *Context) error {
+			ctx.SubstituteResult(driver.RowsAffected(3))
+			return nil
+		},
+		After: h.record,
+	}
+	query := Funcs{
+		Before: func(ctx *Context) error {
+			ctx.SubstituteRows([]string{"id", "name"}, [][]driver.Value{
+				{int64(1), "alice"},
+				{int64(2), []byte("bob")},
+			})
+			return nil
+		},
+		After: h.record,
+	}
+	return &FuncHooks{Exec: exec, StmtExec: exec, Query: query, StmtQuery: query}
+}
+
+func (h *synthesizer) record(ctx *Context) error {
+	h.synthetic = append(h.synthetic, ctx.Synthetic)
+	h.durations = append(h.durations, ctx.Duration)
+	h.txIDs = append(h.txIDs, ctx.TxID)
+	return ctx.Error
+}
+
+func TestSubstituteResult(t *testing.T) {
+	h := &synthesizer{}
+	driverConn := &recordingConn{}
+	dc, err := NewDriver("", h.hooks()).wrap(context.Background(), "", driverConn)
+	require.NoError(t, err)
+	c := dc.(*conn)
+
+	res, err := c.Exec("DELETE FROM t", nil)
+	require.NoError(t, err)
+	n, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	s, err := c.Prepare("DELETE FROM t WHERE id = ?")
+	require.NoError(t, err)
+	res, err = s.Exec([]driver.Value{int64(1)})
+	require.NoError(t, err)
+	n, _ = res.RowsAffected()
+	assert.Equal(t, int64(3), n)
+
+	assert.Equal(t, []string{"prepare DELETE FROM t WHERE id = ?"}, driverConn.log)
+	assert.Equal(t, []bool{true, true}, h.synthetic)
+	assert.Equal(t, []time.Duration{0, 0}, h.durations)
+	assert.Zero(t, c.statements)
+}
+
+func TestSubstituteRowsThroughDatabaseSQL(t *testing.T) {
+	h := &synthesizer{}
+	driverConn := &recordingConn{}
+	d := NewDriver("", h.hooks())
+	d.driver = recordingDriver{driverConn}
+	db, err := sql.Open(RegisterUnique("synthetic", d), "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	type user struct {
+		id   int
+		name string
+	}
+	scan := func(rows *sql.Rows, err error) []user {
+		require.NoError(t, err)
+		defer rows.Close()
+		var users []user
+		for rows.Next() {
+			var u user
+			require.NoError(t, rows.Scan(&u.id, &u.name))
+			users = append(users, u)
+		}
+		require.NoError(t, rows.Err())
+		return users
+	}
+
+	expected := []user{{1, "alice"}, {2, "bob"}}
+	assert.Equal(t, expected, scan(db.Query("SELECT id, name FROM users")))
+
+	s, err := db.Prepare("SELECT id, name FROM users WHERE id > ?")
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, expected, scan(s.Query(0)))
+
+	assert.Equal(t, []string{"prepare SELECT id, name FROM users WHERE id > ?"}, driverConn.log)
+	assert.Equal(t, []bool{true, true}, h.synthetic)
+}
+
+func TestSubstituteWithinTx(t *testing.T) {
+	h := &synthesizer{}
+	rec := &summaryRecorder{}
+	driverConn := &recordingConn{}
+	d := NewDriver("", Compose(h.hooks(), rec))
+	d.driver = recordingDriver{driverConn}
+	db, err := sql.Open(RegisterUnique("synthetic", d), "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	res, err := tx.Exec("UPDATE t SET f1 = 1")
+	require.NoError(t, err)
+	n, _ := res.RowsAffected()
+	assert.Equal(t, int64(3), n)
+	var name string
+	require.NoError(t, tx.QueryRow("SELECT id, name FROM users").Scan(new(int), &name))
+	assert.Equal(t, "alice", name)
+	require.NoError(t, tx.Commit())
+
+	assert.Empty(t, driverConn.log)
+	require.Len(t, h.txIDs, 2)
+	assert.NotZero(t, h.txIDs[0])
+	assert.Equal(t, h.txIDs[0], h.txIDs[1])
+	require.Len(t, rec.summaries, 1)
+	assert.Equal(t, TxCommitted, rec.summaries[0].Outcome)
+	assert.Zero(t, rec.summaries[0].Statements, "synthetic statements aren't counted")
+}
+
+func TestSubstitutedStmtResetsPerExecution(t *testing.T) {
+	first := true
+	var synthetic []bool
+	hooks := &FuncHooks{StmtExec: Funcs{
+		Before: func(ctx *Context) error {
+			if first {
+				ctx.SubstituteResult(nil)
+				first = false
+			}
+			return nil
+		},
+		After: func(ctx *Context) error {
+			synthetic = append(synthetic, ctx.Synthetic)
+			return ctx.Error
+		},
+	}}
+	driverConn := &recordingConn{}
+	dc, err := NewDriver("", hooks).wrap(context.Background(), "", driverConn)
+	require.NoError(t, err)
+
+	s, err := dc.Prepare("DELETE FROM t")
+	require.NoError(t, err)
+	res, err := s.Exec(nil)
+	require.NoError(t, err)
+	n, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	_, err = s.Exec(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []bool{true, false}, synthetic)
+	assert.Equal(t, []string{"prepare DELETE FROM t", "exec []"}, driverConn.log)
+}
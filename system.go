@@ -0,0 +1,60 @@
+package sqlhooks
+
+import "strings"
+
+// SystemInfo identifies the database system a driver connects to, as the
+// integrations report it
+type SystemInfo struct {
+	// Name is the OpenTelemetry db.system value, as "postgresql"
+	Name string
+
+	// Product is the display name, as New Relic datastore products and X-Ray
+	// database types, as "Postgres"
+	Product string
+}
+
+// SystemOther is the SystemInfo name of the unknown systems
+const SystemOther = "other_sql"
+
+// systems maps the usual driver registration names to their system
+var systems = map[string]SystemInfo{
+	"postgres":         {"postgresql", "Postgres"},
+	"postgresql":       {"postgresql", "Postgres"},
+	"pgx":              {"postgresql", "Postgres"},
+	"cloudsqlpostgres": {"postgresql", "Postgres"},
+	"mysql":            {"mysql", "MySQL"},
+	"sqlite3":          {"sqlite", "SQLite"},
+	"sqlite":           {"sqlite", "SQLite"},
+	"sqlserver":        {"mssql", "MSSQL"},
+	"mssql":            {"mssql", "MSSQL"},
+	"clickhouse":       {"clickhouse", "ClickHouse"},
+	"oracle":           {"oracle", "Oracle"},
+	"godror":           {"oracle", "Oracle"},
+	"oci8":             {"oracle", "Oracle"},
+	"snowflake":        {"snowflake", "Snowflake"},
+}
+
+// System returns the database system driverName connects to. Names of
+// drivers registered by Open and RegisterUnique, and migration driver names,
+// are mapped as their base driver name. Unknown drivers are SystemOther, with
+// driverName as their Product, an empty name has no system. WithSystem sets the system of a Driver whose
+// name isn't known.
+func System(driverName string) SystemInfo {
+	return system(BaseDriverName(driverName))
+}
+
+// system is System for a base driver name, it doesn't lock the registry
+func system(name string) SystemInfo {
+	if name == "" {
+		return SystemInfo{}
+	}
+	if info, ok := systems[name]; ok {
+		return info
+	}
+	for _, suffix := range MigrationDriverSuffixes {
+		if info, ok := systems[strings.TrimSuffix(name, suffix)]; ok {
+			return info
+		}
+	}
+	return SystemInfo{Name: SystemOther, Product: name}
+}
@@ -0,0 +1,66 @@
+package sqlhooks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystem(t *testing.T) {
+	for name, expected := range map[string]SystemInfo{
+		"postgres":         {"postgresql", "Postgres"},
+		"postgresql":       {"postgresql", "Postgres"},
+		"pgx":              {"postgresql", "Postgres"},
+		"cloudsqlpostgres": {"postgresql", "Postgres"},
+		"mysql":            {"mysql", "MySQL"},
+		"sqlite3":          {"sqlite", "SQLite"},
+		"sqlite":           {"sqlite", "SQLite"},
+		"sqlserver":        {"mssql", "MSSQL"},
+		"mssql":            {"mssql", "MSSQL"},
+		"clickhouse":       {"clickhouse", "ClickHouse"},
+		"oracle":           {"oracle", "Oracle"},
+		"godror":           {"oracle", "Oracle"},
+		"oci8":             {"oracle", "Oracle"},
+		"snowflake":        {"snowflake", "Snowflake"},
+
+		"postgres-migrate": {"postgresql", "Postgres"},
+		"cockroach":        {SystemOther, "cockroach"},
+		"":                 {},
+	} {
+		assert.Equal(t, expected, System(name), name)
+	}
+
+	name := RegisterUnique("clickhouse", NewDriver("clickhouse", nil))
+	assert.Equal(t, SystemInfo{"clickhouse", "ClickHouse"}, System(name))
+}
+
+func TestContextSystem(t *testing.T) {
+	var contexts []*Context
+	hooks := &FuncHooks{Exec: Funcs{After: func(ctx *Context) error {
+		contexts = append(contexts, ctx)
+		return ctx.Error
+	}}}
+
+	for _, d := range []*Driver{
+		NewDriver("pgx", hooks),
+		NewDriver("cockroach", hooks, WithSystem(SystemInfo{"cockroachdb", "CockroachDB"})),
+	} {
+		c, err := d.wrap(context.Background(), "", &recordingConn{})
+		require.NoError(t, err)
+		_, err = c.(*conn).ExecContext(context.Background(), "DELETE FROM t", nil)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, contexts, 2)
+	assert.Equal(t, "pgx", contexts[0].DriverName)
+	assert.Equal(t, SystemInfo{"postgresql", "Postgres"}, contexts[0].System)
+	assert.Equal(t, "cockroach", contexts[1].DriverName)
+	assert.Equal(t, SystemInfo{"cockroachdb", "CockroachDB"}, contexts[1].System)
+
+	r, err := MarshalEvent(contexts[0])
+	require.NoError(t, err)
+	assert.Equal(t, "pgx", r.Driver)
+	assert.Equal(t, "postgresql", r.System)
+}
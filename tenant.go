@@ -0,0 +1,21 @@
+package sqlhooks
+
+import "context"
+
+type skipTenantCheckKey struct{}
+
+// SkipTenantCheck returns a copy of ctx exempting the statements run with it
+// from tenant checks, as hooks/tenant does, for the queries meant to span
+// tenants or the ones the check gets wrong
+func SkipTenantCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipTenantCheckKey{}, true)
+}
+
+// TenantCheckSkipped reports whether ctx is exempted from tenant checks by SkipTenantCheck
+func TenantCheckSkipped(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	skipped, _ := ctx.Value(skipTenantCheckKey{}).(bool)
+	return skipped
+}
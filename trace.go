@@ -0,0 +1,76 @@
+package sqlhooks
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TraceInfo identifies the trace an operation belongs to, the ids are
+// lowercase hex encoded as in a W3C traceparent
+type TraceInfo struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// TraceExtractor returns the trace ctx belongs to, ok is false if there's none.
+// It lets hooks agree on the trace identity without sqlhooks depending on a tracing library.
+type TraceExtractor func(ctx context.Context) (info TraceInfo, ok bool)
+
+// Traceparent formats info as a W3C traceparent header value
+func (info TraceInfo) Traceparent() string {
+	flags := 0
+	if info.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", info.TraceID, info.SpanID, flags)
+}
+
+// isHex reports whether s is size lowercase hex digits
+func isHex(s string, size int) bool {
+	if len(s) != size {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isHexID reports whether s is a valid, non zero, id of size hex digits
+func isHexID(s string, size int) bool {
+	return isHex(s, size) && strings.Trim(s, "0") != ""
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(s string) (TraceInfo, bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" {
+		return TraceInfo{}, false
+	}
+	// version 00 has exactly 4 fields, later versions can append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return TraceInfo{}, false
+	}
+
+	if !isHexID(parts[1], 32) || !isHexID(parts[2], 16) || !isHex(parts[3], 2) {
+		return TraceInfo{}, false
+	}
+	flags, _ := strconv.ParseUint(parts[3], 16, 8)
+
+	return TraceInfo{TraceID: parts[1], SpanID: parts[2], Sampled: flags&1 == 1}, true
+}
+
+// TraceInfo returns the trace the operation belongs to, using the extractor
+// set with WithTraceExtractor on ctx.Ctx. ok is false if there's no extractor
+// or no trace.
+func (ctx *Context) TraceInfo() (info TraceInfo, ok bool) {
+	if ctx.traceExtractor == nil || ctx.Ctx == nil {
+		return TraceInfo{}, false
+	}
+	return ctx.traceExtractor(ctx.Ctx)
+}
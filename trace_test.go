@@ -0,0 +1,84 @@
+package sqlhooks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value string
+		info  TraceInfo
+		ok    bool
+	}{
+		{"00-" + testTraceID + "-" + testSpanID + "-01", TraceInfo{testTraceID, testSpanID, true}, true},
+		{"00-" + testTraceID + "-" + testSpanID + "-00", TraceInfo{testTraceID, testSpanID, false}, true},
+		{"01-" + testTraceID + "-" + testSpanID + "-03-extra", TraceInfo{testTraceID, testSpanID, true}, true},
+		{"00-" + testTraceID + "-" + testSpanID + "-01-extra", TraceInfo{}, false},
+		{"ff-" + testTraceID + "-" + testSpanID + "-01", TraceInfo{}, false},
+		{"00-00000000000000000000000000000000-" + testSpanID + "-01", TraceInfo{}, false},
+		{"00-" + testTraceID + "-0000000000000000-01", TraceInfo{}, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-" + testSpanID + "-01", TraceInfo{}, false},
+		{"00-" + testTraceID + "-" + testSpanID, TraceInfo{}, false},
+		{"", TraceInfo{}, false},
+	}
+
+	for _, test := range tests {
+		info, ok := ParseTraceparent(test.value)
+		assert.Equal(t, test.ok, ok, test.value)
+		assert.Equal(t, test.info, info, test.value)
+	}
+
+	info := TraceInfo{testTraceID, testSpanID, true}
+	parsed, ok := ParseTraceparent(info.Traceparent())
+	require.True(t, ok)
+	assert.Equal(t, info, parsed)
+}
+
+type traceparentKey struct{}
+
+func TestTraceInfoUsesTraceExtractor(t *testing.T) {
+	extractor := func(ctx context.Context) (TraceInfo, bool) {
+		v, _ := ctx.Value(traceparentKey{}).(string)
+		return ParseTraceparent(v)
+	}
+
+	var infos []TraceInfo
+	record := func(ctx *Context) error {
+		if info, ok := ctx.TraceInfo(); ok {
+			infos = append(infos, info)
+		}
+		return ctx.Error
+	}
+
+	openDBWithHooks(t, nil).Close()
+	db, err := Open(*driverFlag, *dsnFlag, &HooksMock{afterBegin: record, afterCommit: record}, WithTraceExtractor(extractor))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	assert.Empty(t, infos)
+
+	stdCtx := context.WithValue(context.Background(), traceparentKey{}, "00-"+testTraceID+"-"+testSpanID+"-01")
+	tx, err = db.BeginTx(stdCtx, nil)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	want := TraceInfo{testTraceID, testSpanID, true}
+	assert.Equal(t, []TraceInfo{want, want}, infos)
+}
+
+func TestTraceInfoWithoutExtractor(t *testing.T) {
+	_, ok := NewContext().TraceInfo()
+	assert.False(t, ok)
+}
@@ -0,0 +1,197 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TxOrigin is how a transaction was begun, it's set for the Begin, Commit
+// and Rollback hooks and in TxSummary
+type TxOrigin int
+
+const (
+	// TxOriginNone is the origin of the operations that aren't transaction events
+	TxOriginNone TxOrigin = iota
+
+	// TxOriginBegin is a transaction begun by the Begin method with the
+	// default options, as db.Begin and db.BeginTx with nil options do,
+	// which database/sql doesn't tell apart
+	TxOriginBegin
+
+	// TxOriginBeginOptions is a transaction begun by db.BeginTx with an
+	// isolation level or read only
+	TxOriginBeginOptions
+
+	// TxOriginStatement is a transaction begun by a BEGIN or START
+	// TRANSACTION statement, see WithTxStatements
+	TxOriginStatement
+)
+
+var txOriginNames = [...]string{"", "begin", "begin_options", "statement"}
+
+func (o TxOrigin) String() string {
+	if o < 0 || int(o) >= len(txOriginNames) {
+		return fmt.Sprintf("TxOrigin(%d)", int(o))
+	}
+	return txOriginNames[o]
+}
+
+// beginOrigin is the origin of a transaction begun with opts
+func beginOrigin(opts driver.TxOptions) TxOrigin {
+	if opts != (driver.TxOptions{}) {
+		return TxOriginBeginOptions
+	}
+	return TxOriginBegin
+}
+
+// WithTxStatements sets whether the BEGIN, START TRANSACTION, COMMIT and
+// ROLLBACK statements run with Exec are reported as transactions, so hooks
+// see the same events however the application manages them: once a BEGIN
+// succeeds, the Begin hooks run with TxOriginStatement, the statements
+// following it get its TxID until a COMMIT or ROLLBACK, which runs the
+// Commit or Rollback hooks and the TxSummaryHook once it returned, failed
+// or not. The Exec hooks still run for these statements.
+// The hooks of such transaction events have ctx.Synthetic set and, as for
+// TailSample, their Before hooks run right before their After ones, once the
+// statement ran, the errors they return are ignored. The statements run
+// within a transaction begun by the Begin method aren't reported.
+// Disabled by default.
+func WithTxStatements(enabled bool) Option {
+	return func(d *Driver) {
+		d.txStatements = enabled
+	}
+}
+
+type txStatementKind int
+
+const (
+	notTxStatement txStatementKind = iota
+	beginTxStatement
+	commitTxStatement
+	rollbackTxStatement
+)
+
+// parseTxStatement recognizes the statements:
+//
+//	BEGIN [TRANSACTION | WORK | TRAN] ...
+//	START TRANSACTION ...
+//	COMMIT [TRANSACTION | WORK | TRAN]
+//	ROLLBACK [TRANSACTION | WORK | TRAN]
+//
+// BEGIN followed by anything else, as the BEGIN ... END blocks, isn't one,
+// nor is ROLLBACK TO SAVEPOINT
+func parseTxStatement(query string) txStatementKind {
+	words := strings.Fields(strings.TrimRight(strings.TrimSpace(query), ";"))
+	if len(words) == 0 {
+		return notTxStatement
+	}
+	second := ""
+	if len(words) > 1 {
+		second = strings.ToUpper(words[1])
+	}
+	txWord := second == "" || second == "TRANSACTION" || second == "WORK" || second == "TRAN"
+
+	switch strings.ToUpper(words[0]) {
+	case "BEGIN":
+		switch second {
+		case "ISOLATION", "READ", "DEFERRED", "IMMEDIATE", "EXCLUSIVE":
+			return beginTxStatement
+		}
+		if txWord {
+			return beginTxStatement
+		}
+	case "START":
+		if second == "TRANSACTION" {
+			return beginTxStatement
+		}
+	case "COMMIT":
+		if txWord && len(words) <= 2 {
+			return commitTxStatement
+		}
+	case "ROLLBACK":
+		if txWord && len(words) <= 2 {
+			return rollbackTxStatement
+		}
+	}
+	return notTxStatement
+}
+
+// txStatement parses query if it needs to be treated as a transaction statement
+func (c *conn) txStatement(query string) txStatementKind {
+	if !c.txStatements {
+		return notTxStatement
+	}
+	return parseTxStatement(query)
+}
+
+// startTx starts tracking the transaction id, begun with stdCtx, and
+// returns its summary
+func (c *conn) startTx(stdCtx context.Context, id uint64, origin TxOrigin, begun instant) *txSummary {
+	summary := newTxSummary(c.callHooks(stdCtx), stdCtx, id, c.id, c.peer, origin, c.clock, begun)
+	c.txID = id
+	c.txOrigin = origin
+	c.txExtra = extraHooksFrom(stdCtx)
+	c.txBatch = batchFrom(stdCtx)
+	c.txSummary = summary
+	c.transactions++
+	atomic.AddInt64(&c.diag.openTxs, 1)
+	return summary
+}
+
+// afterTxStatement reports a transaction statement of kind, which started
+// at start and took took, as a transaction event
+func (c *conn) afterTxStatement(stdCtx context.Context, kind txStatementKind, start instant, took time.Duration, err error) {
+	if kind == notTxStatement || err == driver.ErrSkip {
+		return
+	}
+
+	switch {
+	case kind == beginTxStatement && c.txID == 0 && err == nil:
+		id := atomic.AddUint64(&txIDs, 1)
+		c.startTx(stdCtx, id, TxOriginStatement, start)
+		if t, ok := asBeginner(c.hooksFor(stdCtx, OpBegin)); ok {
+			ctx := c.txStatementContext(stdCtx, start, took, err)
+			replay(t, ctx, beginFuncs)
+		}
+
+	case kind != beginTxStatement && c.txOrigin == TxOriginStatement:
+		summary := c.txSummary
+		op, funcs, outcome := OpRollback, rollbackFuncs, TxRolledBack
+		if kind == commitTxStatement {
+			op, funcs, outcome = OpCommit, commitFuncs, TxCommitted
+			if err != nil {
+				outcome = TxCommitFailed
+			}
+		}
+		hooks := c.hooksFor(stdCtx, op)
+		var ctx *Context
+		if before, after := funcs(hooks); before != nil || after != nil {
+			ctx = c.txStatementContext(stdCtx, start, took, err)
+		}
+		c.endTx()
+		if ctx != nil {
+			replay(hooks, ctx, funcs)
+		}
+		summary.end(outcome, err)
+	}
+}
+
+// txStatementContext returns the Context of the transaction event of a statement
+func (c *conn) txStatementContext(stdCtx context.Context, start instant, took time.Duration, err error) *Context {
+	ctx := c.newContext()
+	ctx.Ctx = stdCtx
+	ctx.TxID = c.txID
+	ctx.TxOrigin = TxOriginStatement
+	ctx.Synthetic = true
+	ctx.Migration = c.migration(ctx)
+	ctx.Internal = IsInternal(ctx.Ctx)
+	ctx.Error = err
+	ctx.CtxErr = ctxErr(stdCtx, err)
+	ctx.Start = start.wall
+	ctx.Duration = took
+	return ctx
+}
@@ -0,0 +1,186 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gchaincl/sqlhooks/drivertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTxStatement(t *testing.T) {
+	for query, expected := range map[string]txStatementKind{
+		"BEGIN":                              beginTxStatement,
+		" begin;":                            beginTxStatement,
+		"BEGIN TRANSACTION":                  beginTxStatement,
+		"BEGIN WORK":                         beginTxStatement,
+		"BEGIN ISOLATION LEVEL SERIALIZABLE": beginTxStatement,
+		"BEGIN IMMEDIATE":                    beginTxStatement,
+		"START TRANSACTION READ ONLY":        beginTxStatement,
+		"COMMIT":                             commitTxStatement,
+		"commit work;":                       commitTxStatement,
+		"ROLLBACK":                           rollbackTxStatement,
+		"ROLLBACK TRANSACTION":               rollbackTxStatement,
+		"ROLLBACK TO SAVEPOINT sp":           notTxStatement,
+		"ROLLBACK TO sp":                     notTxStatement,
+		"COMMIT AND CHAIN":                   notTxStatement,
+		"BEGIN UPDATE t SET n = 1; END;":     notTxStatement,
+		"START SLAVE":                        notTxStatement,
+		"SELECT 'BEGIN'":                     notTxStatement,
+		"":                                   notTxStatement,
+		"SAVEPOINT sp":                       notTxStatement,
+		"BEGINNING":                          notTxStatement,
+		"INSERT INTO t (status) VALUES ('BEGIN')": notTxStatement,
+	} {
+		assert.Equal(t, expected, parseTxStatement(query), query)
+	}
+}
+
+// txOriginHooks records the transaction events, and the statements, as
+// op:query(TxID) origin
+type txOriginHooks struct {
+	NopHooks
+	events []string
+}
+
+func (h *txOriginHooks) record(op string, ctx *Context) error {
+	event := fmt.Sprintf("%s:%s(%d)", op, ctx.Query, ctx.TxID)
+	if ctx.TxOrigin != TxOriginNone {
+		event += " " + ctx.TxOrigin.String()
+	}
+	if ctx.Synthetic {
+		event += " synthetic"
+	}
+	h.events = append(h.events, event)
+	return ctx.Error
+}
+
+func (h *txOriginHooks) AfterBegin(ctx *Context) error    { return h.record("begin", ctx) }
+func (h *txOriginHooks) AfterCommit(ctx *Context) error   { return h.record("commit", ctx) }
+func (h *txOriginHooks) AfterRollback(ctx *Context) error { return h.record("rollback", ctx) }
+func (h *txOriginHooks) AfterExec(ctx *Context) error     { return h.record("exec", ctx) }
+func (h *txOriginHooks) AfterStmtExec(ctx *Context) error { return h.record("exec", ctx) }
+
+func (h *txOriginHooks) AfterTx(summary TxSummary) {
+	h.events = append(h.events, fmt.Sprintf("tx(%d) %s %s %d", summary.TxID, summary.Origin, summary.Outcome, summary.Statements))
+}
+
+// ids replaces the transaction ids of the events by their order of appearance
+func (h *txOriginHooks) ids() []string {
+	var events []string
+	seen := map[uint64]int{}
+	for _, event := range h.events {
+		i := strings.LastIndex(event, "(")
+		j := i + strings.Index(event[i:], ")")
+		id, _ := strconv.ParseUint(event[i+1:j], 10, 64)
+		if id != 0 {
+			if seen[id] == 0 {
+				seen[id] = len(seen) + 1
+			}
+			event = fmt.Sprintf("%s(#%d%s", event[:i], seen[id], event[j:])
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestTxOriginBeginMethod(t *testing.T) {
+	hooks := &txOriginHooks{}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks)
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	tx, err = db.BeginTx(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	tx, err = db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	require.NoError(t, err)
+	_, err = tx.Exec("SELECT 1")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	assert.Equal(t, []string{
+		"begin:(#1) begin",
+		"commit:(#1) begin",
+		"tx(#1) begin committed 0",
+		"begin:(#2) begin",
+		"rollback:(#2) begin",
+		"tx(#2) begin rolled back 0",
+		"begin:(#3) begin_options",
+		"exec:SELECT 1(#3)",
+		"commit:(#3) begin_options",
+		"tx(#3) begin_options committed 1",
+	}, hooks.ids())
+}
+
+func TestTxStatements(t *testing.T) {
+	for _, mode := range []string{drivertest.ModeContext, drivertest.ModeLegacy, drivertest.ModePrepare} {
+		t.Run(mode, func(t *testing.T) {
+			hooks := &txOriginHooks{}
+			db, err := Open(drivertest.Name, mode+";"+t.Name(), hooks, WithTxStatements(true))
+			require.NoError(t, err)
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			for _, query := range []string{
+				"BEGIN", "INSERT INTO t VALUES (1)", "COMMIT",
+				"START TRANSACTION", "ROLLBACK",
+				"ROLLBACK", "DELETE FROM t",
+			} {
+				_, err := db.Exec(query)
+				require.NoError(t, err)
+			}
+
+			// Within the transactions of the Begin method, they're statements
+			tx, err := db.Begin()
+			require.NoError(t, err)
+			_, err = tx.Exec("BEGIN")
+			require.NoError(t, err)
+			_, err = tx.Exec("COMMIT")
+			require.NoError(t, err)
+			require.NoError(t, tx.Rollback())
+
+			assert.Equal(t, []string{
+				"exec:BEGIN(0)",
+				"begin:(#1) statement synthetic",
+				"exec:INSERT INTO t VALUES (1)(#1)",
+				"exec:COMMIT(#1)",
+				"commit:(#1) statement synthetic",
+				"tx(#1) statement committed 2",
+				"exec:START TRANSACTION(0)",
+				"begin:(#2) statement synthetic",
+				"exec:ROLLBACK(#2)",
+				"rollback:(#2) statement synthetic",
+				"tx(#2) statement rolled back 1",
+				"exec:ROLLBACK(0)",
+				"exec:DELETE FROM t(0)",
+				"begin:(#3) begin",
+				"exec:BEGIN(#3)",
+				"exec:COMMIT(#3)",
+				"rollback:(#3) begin",
+				"tx(#3) begin rolled back 2",
+			}, hooks.ids())
+		})
+	}
+}
+
+func TestTxStatementsDisabled(t *testing.T) {
+	hooks := &txOriginHooks{}
+	db, err := Open(drivertest.Name, drivertest.ModeContext+";"+t.Name(), hooks)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, query := range []string{"BEGIN", "COMMIT"} {
+		_, err := db.Exec(query)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"exec:BEGIN(0)", "exec:COMMIT(0)"}, hooks.ids())
+}
@@ -0,0 +1,120 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// TxSummaryHook is the interface implemented by objects that wants a single
+// event per transaction, rather than hooking to every statement it runs.
+// AfterTx is called once the transaction commits or rolls back, whichever
+// operations WithOperations enables, with the summary of the statements run
+// with hooks, which excludes the statements of the disabled operations.
+type TxSummaryHook interface {
+	AfterTx(summary TxSummary)
+}
+
+func isTxSummaryHook(h HookType) bool {
+	_, ok := h.(TxSummaryHook)
+	return ok
+}
+
+// TxOutcome is how a transaction ended
+type TxOutcome int
+
+const (
+	TxCommitted TxOutcome = iota
+	TxRolledBack
+	// TxCommitFailed is a Commit the driver failed, as when the database
+	// rolls the transaction back instead
+	TxCommitFailed
+)
+
+var txOutcomeNames = [...]string{"committed", "rolled back", "commit failed"}
+
+func (o TxOutcome) String() string {
+	if o < 0 || int(o) >= len(txOutcomeNames) {
+		return fmt.Sprintf("TxOutcome(%d)", int(o))
+	}
+	return txOutcomeNames[o]
+}
+
+// TxSummary sums up a transaction, it only keeps aggregates and the slowest
+// statement, however many statements the transaction runs
+type TxSummary struct {
+	// Ctx is the context.Context the transaction was begun with
+	Ctx    context.Context
+	TxID   uint64
+	ConnID uint64
+	Peer   string
+	Origin TxOrigin
+
+	// Duration is the time from Begin to the end of Commit or Rollback
+	Duration time.Duration
+	Outcome  TxOutcome
+
+	// Err is the error the driver returned ending the transaction
+	Err error
+
+	// Statements counts the statements run within the transaction, failed
+	// ones included, StatementsDuration is the time they spent in the driver
+	Statements         int
+	StatementsDuration time.Duration
+
+	// Slowest is the Fingerprint of the slowest statement, "" if there's none
+	Slowest         string
+	SlowestDuration time.Duration
+}
+
+// txSummary accumulates the TxSummary of the transaction in progress
+type txSummary struct {
+	hook    TxSummaryHook
+	summary TxSummary
+	clock   clock
+	begun   instant
+	slowest string
+}
+
+// newTxSummary returns the txSummary of a transaction, nil if hooks have no TxSummaryHook
+func newTxSummary(hooks HookType, stdCtx context.Context, id, connID uint64, peer string, origin TxOrigin, clock clock, begun instant) *txSummary {
+	t, ok := hooks.(TxSummaryHook)
+	if !ok || !implementsHook(hooks, isTxSummaryHook) {
+		return nil
+	}
+	return &txSummary{
+		hook:    t,
+		summary: TxSummary{Ctx: stdCtx, TxID: id, ConnID: connID, Peer: peer, Origin: origin},
+		clock:   clock,
+		begun:   begun,
+	}
+}
+
+// statement adds a statement run within the transaction, s can be nil
+func (s *txSummary) statement(query string, took time.Duration, err error) {
+	if s == nil || err == driver.ErrSkip {
+		return
+	}
+	s.summary.Statements++
+	s.summary.StatementsDuration += took
+	if s.summary.Statements == 1 || took > s.summary.SlowestDuration {
+		s.slowest = query
+		s.summary.SlowestDuration = took
+	}
+}
+
+// end calls the hook with the summary, s can be nil.
+// The slowest query is only fingerprinted here, once.
+func (s *txSummary) end(outcome TxOutcome, err error) {
+	if s == nil {
+		return
+	}
+	s.summary.Duration = s.clock.since(s.begun)
+	s.summary.Outcome = outcome
+	s.summary.Err = err
+	if s.summary.Statements > 0 {
+		s.summary.Slowest = Fingerprint(s.slowest)
+	}
+	s.hook.AfterTx(s.summary)
+}
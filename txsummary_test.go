@@ -0,0 +1,149 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errCommitFailed = errors.New("commit failed")
+
+// slowConn takes 10ms to run the statements mentioning slow, and fails
+// the Commit of the transactions begun after failCommit is set
+type slowConn struct {
+	anyConn
+	failCommit bool
+}
+
+func (c *slowConn) Begin() (driver.Tx, error) {
+	if c.failCommit {
+		return failingCommitTx{}, nil
+	}
+	return anyTx{}, nil
+}
+
+func (c *slowConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	if strings.Contains(query, "slow") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return driver.RowsAffected(0), nil
+}
+
+type failingCommitTx struct{ anyTx }
+
+func (failingCommitTx) Commit() error { return errCommitFailed }
+
+// summaryRecorder records the summaries of the transactions
+type summaryRecorder struct {
+	summaries []TxSummary
+}
+
+func (r *summaryRecorder) AfterTx(summary TxSummary) {
+	r.summaries = append(r.summaries, summary)
+}
+
+func openSummarized(t *testing.T, hooks HookType, dc driver.Conn) *conn {
+	c, err := NewDriver("", hooks).wrap(context.Background(), "", dc)
+	require.NoError(t, err)
+	return c.(*conn)
+}
+
+func TestTxSummary(t *testing.T) {
+	rec := &summaryRecorder{}
+	dc := &slowConn{}
+	c := openSummarized(t, Compose(&FuncHooks{}, rec), dc)
+
+	_, err := c.Exec("INSERT INTO outside VALUES(1)", nil)
+	require.NoError(t, err)
+
+	stdCtx := context.WithValue(context.Background(), ctxKey{}, "v")
+	dtx, err := c.BeginTx(stdCtx, driver.TxOptions{})
+	require.NoError(t, err)
+	_, err = c.Exec("INSERT INTO t VALUES(1)", nil)
+	require.NoError(t, err)
+	_, err = c.Exec("UPDATE t SET slow = 1 WHERE id = 42", nil)
+	require.NoError(t, err)
+	s, err := c.Prepare("SELECT f1 FROM t")
+	require.NoError(t, err)
+	rows, err := s.Query(nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.NoError(t, s.Close())
+	require.NoError(t, dtx.Commit())
+
+	require.Len(t, rec.summaries, 1)
+	summary := rec.summaries[0]
+	assert.Equal(t, "v", summary.Ctx.Value(ctxKey{}))
+	assert.Equal(t, dtx.(tx).id, summary.TxID)
+	assert.Equal(t, c.id, summary.ConnID)
+	assert.Equal(t, TxCommitted, summary.Outcome)
+	assert.NoError(t, summary.Err)
+	assert.Equal(t, 3, summary.Statements)
+	assert.Equal(t, "UPDATE t SET slow = ? WHERE id = ?", summary.Slowest)
+	assert.True(t, summary.SlowestDuration >= 10*time.Millisecond)
+	assert.True(t, summary.StatementsDuration >= summary.SlowestDuration)
+	assert.True(t, summary.Duration >= summary.StatementsDuration)
+
+	dtx, err = c.Begin()
+	require.NoError(t, err)
+	require.NoError(t, dtx.Rollback())
+
+	dc.failCommit = true
+	dtx, err = c.Begin()
+	require.NoError(t, err)
+	_, err = c.Exec("DELETE FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, errCommitFailed, dtx.Commit())
+
+	require.Len(t, rec.summaries, 3)
+	assert.Equal(t, TxRolledBack, rec.summaries[1].Outcome)
+	assert.Zero(t, rec.summaries[1].Statements)
+	assert.Empty(t, rec.summaries[1].Slowest)
+	assert.Equal(t, TxCommitFailed, rec.summaries[2].Outcome)
+	assert.Equal(t, errCommitFailed, rec.summaries[2].Err)
+	assert.Equal(t, 1, rec.summaries[2].Statements)
+	assert.Equal(t, "DELETE FROM t", rec.summaries[2].Slowest)
+}
+
+func TestTxSummaryOnlyWithHook(t *testing.T) {
+	c := openSummarized(t, Compose(&FuncHooks{}, &FuncHooks{}), &slowConn{})
+
+	tx, err := c.Begin()
+	require.NoError(t, err)
+	assert.Nil(t, c.txSummary)
+	require.NoError(t, tx.Commit())
+}
+
+func TestTxSummaryWithOperations(t *testing.T) {
+	rec := &summaryRecorder{}
+	d := NewDriver("", rec, WithOperations(OpQuery))
+	dc, err := d.wrap(context.Background(), "", &slowConn{})
+	require.NoError(t, err)
+	c := dc.(*conn)
+
+	tx, err := c.Begin()
+	require.NoError(t, err)
+	_, err = c.Exec("INSERT INTO t VALUES(1)", nil)
+	require.NoError(t, err)
+	rows, err := c.Query("SELECT f1 FROM t", nil)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.NoError(t, tx.Commit())
+
+	// Exec is disabled
+	require.Len(t, rec.summaries, 1)
+	assert.Equal(t, 1, rec.summaries[0].Statements)
+	assert.Equal(t, "SELECT f1 FROM t", rec.summaries[0].Slowest)
+}
+
+func TestTxOutcomeString(t *testing.T) {
+	assert.Equal(t, "committed", TxCommitted.String())
+	assert.Equal(t, "commit failed", TxCommitFailed.String())
+	assert.Equal(t, "TxOutcome(7)", TxOutcome(7).String())
+}
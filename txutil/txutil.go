@@ -0,0 +1,149 @@
+// Package txutil provides transaction helpers that cooperate with sqlhooks
+package txutil
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+)
+
+type key int
+
+const (
+	attemptKey key = iota
+	sideEffectsKey
+)
+
+// Options configures RunTx
+type Options struct {
+	// TxOptions are used to begin every attempt
+	TxOptions *sql.TxOptions
+
+	// MaxAttempts is the maximum number of times the transaction is run, 3 by default
+	MaxAttempts int
+
+	// Backoff returns how long to wait before the given attempt, starting at 2.
+	// By default it doubles from 10ms up to 1s
+	Backoff func(attempt int) time.Duration
+
+	// Retryable reports whether the transaction failed with err can be retried,
+	// IsRetryable by default
+	Retryable func(err error) bool
+}
+
+func defaultBackoff(attempt int) time.Duration {
+	d := 10 * time.Millisecond << uint(attempt-2)
+	if d > time.Second || d <= 0 {
+		return time.Second
+	}
+	return d
+}
+
+// IsRetryable reports whether err is a serialization failure or a deadlock,
+// as classified by sqlhooks.ClassifyError. A lock wait timeout, as the MySQL
+// error 1205, is a timeout: the transaction waited long enough already.
+func IsRetryable(err error) bool {
+	switch sqlhooks.ClassifyError(err) {
+	case sqlhooks.ErrorClassSerialization, sqlhooks.ErrorClassDeadlock:
+		return true
+	}
+	return false
+}
+
+// Attempt returns the attempt number of the transaction ctx belongs to,
+// starting at 1, or 0 if ctx doesn't come from RunTx.
+// Transaction hooks can read it from sqlhooks.Context.Ctx.
+func Attempt(ctx context.Context) int {
+	n, _ := ctx.Value(attemptKey).(int)
+	return n
+}
+
+// WithSideEffects returns a copy of ctx on which MarkSideEffect can be signalled
+func WithSideEffects(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sideEffectsKey, new(int32))
+}
+
+// MarkSideEffect signals that the transaction running under ctx did something
+// that can't be undone by a rollback, so RunTx must not retry it.
+// ctx must come from WithSideEffects, otherwise it's a no-op.
+func MarkSideEffect(ctx context.Context) {
+	if flag, ok := ctx.Value(sideEffectsKey).(*int32); ok {
+		atomic.StoreInt32(flag, 1)
+	}
+}
+
+func hasSideEffects(ctx context.Context) bool {
+	flag, ok := ctx.Value(sideEffectsKey).(*int32)
+	return ok && atomic.LoadInt32(flag) == 1
+}
+
+// RunTx begins a transaction, runs fn and commits it.
+// If fn or the commit fail with a retryable error, the transaction is rolled
+// back and the whole function is run again after a backoff, up to MaxAttempts.
+// It doesn't retry if a side effect was marked on ctx, nor if ctx is done
+// (or its deadline would expire) before the next attempt.
+// opts can be nil to use the defaults.
+func RunTx(ctx context.Context, db *sql.DB, opts *Options, fn func(tx *sql.Tx) error) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+
+	backoff := opts.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+
+	retryable := opts.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := run(context.WithValue(ctx, attemptKey, attempt), db, opts.TxOptions, fn)
+		if err == nil || attempt >= maxAttempts || !retryable(err) || hasSideEffects(ctx) {
+			return err
+		}
+
+		wait := backoff(attempt + 1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func run(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,238 @@
+package txutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sql state " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+var errSerialization = sqlStateError("40001")
+
+// fakeDriver only supports transactions, commits fail with the scripted errors
+type fakeDriver struct {
+	mu         sync.Mutex
+	commitErrs []error
+}
+
+func (d *fakeDriver) script(errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.commitErrs = errs
+}
+
+func (d *fakeDriver) nextCommitErr() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.commitErrs) == 0 {
+		return nil
+	}
+	err := d.commitErrs[0]
+	d.commitErrs = d.commitErrs[1:]
+	return err
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{d}, nil
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{c.d}, nil
+}
+
+type fakeTx struct {
+	d *fakeDriver
+}
+
+func (tx fakeTx) Commit() error {
+	return tx.d.nextCommitErr()
+}
+
+func (tx fakeTx) Rollback() error {
+	return nil
+}
+
+var fdriver = &fakeDriver{}
+
+func init() {
+	sql.Register("txutil-test", fdriver)
+}
+
+type txHooks struct {
+	mu       sync.Mutex
+	attempts []int
+	events   []string
+}
+
+func (h *txHooks) record(event string, ctx *sqlhooks.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if event == "begin" {
+		h.attempts = append(h.attempts, Attempt(ctx.Ctx))
+	}
+	h.events = append(h.events, event)
+}
+
+func (h *txHooks) BeforeBegin(ctx *sqlhooks.Context) error { return nil }
+func (h *txHooks) AfterBegin(ctx *sqlhooks.Context) error {
+	h.record("begin", ctx)
+	return ctx.Error
+}
+func (h *txHooks) BeforeCommit(ctx *sqlhooks.Context) error { return nil }
+func (h *txHooks) AfterCommit(ctx *sqlhooks.Context) error {
+	h.record("commit", ctx)
+	return ctx.Error
+}
+func (h *txHooks) BeforeRollback(ctx *sqlhooks.Context) error { return nil }
+func (h *txHooks) AfterRollback(ctx *sqlhooks.Context) error {
+	h.record("rollback", ctx)
+	return ctx.Error
+}
+
+func openDB(t *testing.T) (*sql.DB, *txHooks) {
+	hooks := &txHooks{}
+	db, err := sqlhooks.Open("txutil-test", "", hooks)
+	require.NoError(t, err)
+	return db, hooks
+}
+
+var noWait = &Options{
+	Backoff: func(int) time.Duration { return 0 },
+}
+
+func TestRunTxRetriesRetryableErrors(t *testing.T) {
+	db, hooks := openDB(t)
+	defer db.Close()
+
+	fdriver.script(errSerialization, errSerialization)
+
+	runs := 0
+	err := RunTx(context.Background(), db, noWait, func(tx *sql.Tx) error {
+		runs++
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, runs)
+	assert.Equal(t, []int{1, 2, 3}, hooks.attempts)
+	assert.Equal(t, []string{"begin", "commit", "begin", "commit", "begin", "commit"}, hooks.events)
+}
+
+func TestRunTxRollsBackOnClosureError(t *testing.T) {
+	db, hooks := openDB(t)
+	defer db.Close()
+
+	runs := 0
+	err := RunTx(context.Background(), db, noWait, func(tx *sql.Tx) error {
+		runs++
+		if runs == 1 {
+			return errSerialization
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, runs)
+	assert.Equal(t, []string{"begin", "rollback", "begin", "commit"}, hooks.events)
+}
+
+func TestRunTxDoesNotRetryOtherErrors(t *testing.T) {
+	db, hooks := openDB(t)
+	defer db.Close()
+
+	boom := errors.New("boom")
+	runs := 0
+	err := RunTx(context.Background(), db, noWait, func(tx *sql.Tx) error {
+		runs++
+		return boom
+	})
+
+	assert.Equal(t, boom, err)
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, []int{1}, hooks.attempts)
+}
+
+func TestRunTxStopsAtMaxAttempts(t *testing.T) {
+	db, _ := openDB(t)
+	defer db.Close()
+
+	runs := 0
+	opts := &Options{MaxAttempts: 5, Backoff: noWait.Backoff}
+	err := RunTx(context.Background(), db, opts, func(tx *sql.Tx) error {
+		runs++
+		return errSerialization
+	})
+
+	assert.Equal(t, errSerialization, err)
+	assert.Equal(t, 5, runs)
+}
+
+func TestRunTxDoesNotRetrySideEffects(t *testing.T) {
+	db, _ := openDB(t)
+	defer db.Close()
+
+	ctx := WithSideEffects(context.Background())
+	runs := 0
+	err := RunTx(ctx, db, noWait, func(tx *sql.Tx) error {
+		runs++
+		MarkSideEffect(ctx)
+		return errSerialization
+	})
+
+	assert.Equal(t, errSerialization, err)
+	assert.Equal(t, 1, runs)
+}
+
+func TestRunTxRespectsDeadlineBetweenAttempts(t *testing.T) {
+	db, _ := openDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	runs := 0
+	opts := &Options{Backoff: func(int) time.Duration { return time.Minute }}
+	start := time.Now()
+	err := RunTx(ctx, db, opts, func(tx *sql.Tx) error {
+		runs++
+		return errSerialization
+	})
+
+	assert.Equal(t, errSerialization, err)
+	assert.Equal(t, 1, runs)
+	assert.True(t, time.Since(start) < time.Minute)
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(sqlStateError("40001")))
+	assert.True(t, IsRetryable(sqlStateError("40P01")))
+	assert.True(t, IsRetryable(errors.New("Error 1213: Deadlock found when trying to get lock")))
+	assert.False(t, IsRetryable(errors.New("Error 1205: Lock wait timeout exceeded")), "a lock wait timeout is a timeout")
+	assert.False(t, IsRetryable(sqlStateError("23505")))
+	assert.False(t, IsRetryable(errors.New("boom")))
+	assert.False(t, IsRetryable(nil))
+}
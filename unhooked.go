@@ -0,0 +1,50 @@
+package sqlhooks
+
+import "context"
+
+type unhookedKey struct{}
+
+// Unhooked returns a copy of ctx marking the statements run with it as ones
+// taking over the connection, as lib/pq's LISTEN or a streaming COPY: they
+// bypass sqlhooks entirely, run no hook and return the driver's own rows and
+// statements, unwrapped. The statements prepared with it return the driver's
+// statement, whose executions aren't hooked either.
+func Unhooked(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unhookedKey{}, true)
+}
+
+// IsUnhooked reports whether ctx is marked by Unhooked
+func IsUnhooked(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	marked, _ := ctx.Value(unhookedKey{}).(bool)
+	return marked
+}
+
+// DefaultRawRowsPrefixes are the default WithRawRowsPrefixes
+var DefaultRawRowsPrefixes = []string{"LISTEN", "COPY"}
+
+// WithRawRowsPrefixes sets the keywords of the statements whose Query and
+// StmtQuery rows are returned unwrapped, DefaultRawRowsPrefixes by default,
+// none without any. A statement matches when it starts with one of prefixes
+// followed by a space, in any case, leading spaces aside. Their hooks run,
+// the After one once the driver returned the rows, but the RowsWrapper and
+// RowsCloser hooks don't, nor are the statements explained: their rows may
+// never be closed.
+func WithRawRowsPrefixes(prefixes ...string) Option {
+	return func(d *Driver) {
+		d.rawRowsPrefixes = prefixes
+	}
+}
+
+// rawRows tells whether the rows of query are returned unwrapped, see
+// WithRawRowsPrefixes
+func (c *conn) rawRows(query string) bool {
+	for _, prefix := range c.rawRowsPrefixes {
+		if _, ok := consumeKeyword(query, prefix); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// heldRows are rows wrapped by rowsHooks
+type heldRows struct {
+	driver.Rows
+}
+
+// rowsHooks counts the statements and holds the Context of every rows it
+// wraps until they're closed
+type rowsHooks struct {
+	NopHooks
+	befores, afters int
+	held            map[*Context]bool
+}
+
+func (h *rowsHooks) before(ctx *Context) error { h.befores++; return nil }
+func (h *rowsHooks) after(ctx *Context) error  { h.afters++; return ctx.Error }
+
+func (h *rowsHooks) BeforeQuery(ctx *Context) error     { return h.before(ctx) }
+func (h *rowsHooks) AfterQuery(ctx *Context) error      { return h.after(ctx) }
+func (h *rowsHooks) BeforeExec(ctx *Context) error      { return h.before(ctx) }
+func (h *rowsHooks) AfterExec(ctx *Context) error       { return h.after(ctx) }
+func (h *rowsHooks) BeforePrepare(ctx *Context) error   { return h.before(ctx) }
+func (h *rowsHooks) AfterPrepare(ctx *Context) error    { return h.after(ctx) }
+func (h *rowsHooks) BeforeStmtQuery(ctx *Context) error { return h.before(ctx) }
+func (h *rowsHooks) AfterStmtQuery(ctx *Context) error  { return h.after(ctx) }
+
+func (h *rowsHooks) WrapRows(ctx *Context, rows driver.Rows) driver.Rows {
+	h.held[ctx] = true
+	return heldRows{rows}
+}
+
+func (h *rowsHooks) AfterRowsClose(ctx *Context) error {
+	delete(h.held, ctx)
+	return nil
+}
+
+func TestUnhooked(t *testing.T) {
+	hooks := &rowsHooks{held: make(map[*Context]bool)}
+	driverConn := &recordingConn{}
+	dc, err := NewDriver("", hooks).wrap(context.Background(), "", driverConn)
+	require.NoError(t, err)
+	c := dc.(*conn)
+
+	// Statements that never end, their rows are never closed
+	ctx := Unhooked(context.Background())
+	for i := 0; i < 1000; i++ {
+		rows, err := c.QueryContext(ctx, "SELECT pg_sleep(3600)", nil)
+		require.NoError(t, err)
+		require.IsType(t, anyRows{}, rows)
+
+		_, err = c.ExecContext(ctx, "NOTIFY channel", nil)
+		require.NoError(t, err)
+
+		s, err := c.PrepareContext(ctx, "SELECT pg_sleep(3600)")
+		require.NoError(t, err)
+		require.IsType(t, recordingStmt{}, s, "the driver's statement")
+	}
+	assert.Len(t, driverConn.log, 3000)
+	assert.Equal(t, 0, hooks.befores)
+	assert.Equal(t, 0, hooks.afters)
+	assert.Empty(t, hooks.held)
+
+	// Executions of a hooked statement with an Unhooked context
+	s, err := c.PrepareContext(context.Background(), "SELECT pg_sleep(3600)")
+	require.NoError(t, err)
+	rows, err := s.(driver.StmtQueryContext).QueryContext(ctx, nil)
+	require.NoError(t, err)
+	assert.IsType(t, anyRows{}, rows)
+	assert.Equal(t, 1, hooks.befores, "the Prepare hooks only")
+	assert.Empty(t, hooks.held)
+
+	// Without it, the wrapper and the hook hold the rows until they close
+	rows, err = c.QueryContext(context.Background(), "SELECT pg_sleep(3600)", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &closingRows{}, rows)
+	assert.Len(t, hooks.held, 1)
+	require.NoError(t, rows.Close())
+	assert.Empty(t, hooks.held)
+}
+
+func TestRawRowsPrefixes(t *testing.T) {
+	for _, prefixes := range [][]string{nil, {"listen", "COPY"}, {}} {
+		hooks := &rowsHooks{held: make(map[*Context]bool)}
+		var opts []Option
+		if prefixes != nil {
+			opts = append(opts, WithRawRowsPrefixes(prefixes...))
+		}
+		dc, err := NewDriver("", hooks, opts...).wrap(context.Background(), "", &recordingConn{})
+		require.NoError(t, err)
+		c := dc.(*conn)
+
+		for _, query := range []string{"LISTEN channel", "  copy t to stdout", "SELECT 1", "LISTENING", "COPY"} {
+			raw := len(prefixes) != 0 || prefixes == nil
+			raw = raw && (query == "LISTEN channel" || query == "  copy t to stdout")
+
+			hooks.befores, hooks.afters = 0, 0
+			rows, err := c.QueryContext(context.Background(), query, nil)
+			require.NoError(t, err)
+			s, err := c.PrepareContext(context.Background(), query)
+			require.NoError(t, err)
+			stmtRows, err := s.(driver.StmtQueryContext).QueryContext(context.Background(), nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, 3, hooks.befores, "%v %s: the hooks run", prefixes, query)
+			assert.Equal(t, 3, hooks.afters, "%v %s: the hooks run", prefixes, query)
+			if raw {
+				assert.IsType(t, anyRows{}, rows, "%v %s", prefixes, query)
+				assert.IsType(t, anyRows{}, stmtRows, "%v %s", prefixes, query)
+				assert.Empty(t, hooks.held, "%v %s", prefixes, query)
+				continue
+			}
+			assert.IsType(t, &closingRows{}, rows, "%v %s", prefixes, query)
+			assert.IsType(t, &closingRows{}, stmtRows, "%v %s", prefixes, query)
+			assert.Len(t, hooks.held, 2, "%v %s", prefixes, query)
+			require.NoError(t, rows.Close())
+			require.NoError(t, stmtRows.Close())
+			assert.Empty(t, hooks.held)
+		}
+	}
+}